@@ -0,0 +1,160 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// NodeUpdaterConfigName is the name of the only NodeUpdaterConfig the controller reads
+	NodeUpdaterConfigName = "default"
+	// DefaultAccessTokenSecretKey is the key of the personal access token in its Secret when the reference has no key
+	DefaultAccessTokenSecretKey = "token"
+
+	// ConditionApplied is true when the controller runs with the spec of the NodeUpdaterConfig
+	ConditionApplied = "Applied"
+	// ReasonConfigApplied is the reason of the Applied condition once the spec is applied
+	ReasonConfigApplied = "Applied"
+	// ReasonInvalidConfig is the reason of the Applied condition while the spec cannot be applied, the controller keeps
+	// the configuration it applied before
+	ReasonInvalidConfig = "InvalidConfig"
+)
+
+// SecretKeyReference selects a key of a Secret
+type SecretKeyReference struct {
+	// namespace of the Secret
+	Namespace string `json:"namespace"`
+	// name of the Secret
+	Name string `json:"name"`
+	// +optional
+	// key of the value in the Secret, defaults to "token"
+	Key string `json:"key,omitempty"`
+}
+
+// GetKey returns the key of the value in the Secret
+func (r SecretKeyReference) GetKey() string {
+	if r.Key == "" {
+		return DefaultAccessTokenSecretKey
+	}
+	return r.Key
+}
+
+// AzureDevOpsConfig configures the Azure DevOps organization the agents are registered in
+type AzureDevOpsConfig struct {
+	// +optional
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// serverURL is the address of an Azure DevOps Server (TFS), e.g. https://tfs.contoso.com/tfs, whose collection is
+	// the organization. Azure DevOps Services (https://dev.azure.com) is used when it is empty.
+	ServerURL string `json:"serverURL,omitempty"`
+	// organization is the name of the Azure DevOps organization, or the collection of the Azure DevOps Server
+	Organization string `json:"organization"`
+	// accessTokenSecretRef selects the personal access token, it needs the Agent Pools (read & manage) scope
+	AccessTokenSecretRef SecretKeyReference `json:"accessTokenSecretRef"`
+}
+
+// NotificationsConfig configures the services the notifications about the rotations are sent to. The notifiers
+// compiled into the binary and the email of --smtp-config-dir are kept.
+type NotificationsConfig struct {
+	// +optional
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// webhookURL is the address the notifications are posted to as JSON
+	WebhookURL string `json:"webhookURL,omitempty"`
+	// +optional
+	// pagerDutyRoutingKeySecretRef selects the integration key of the PagerDuty service the incidents are opened in
+	PagerDutyRoutingKeySecretRef *SecretKeyReference `json:"pagerDutyRoutingKeySecretRef,omitempty"`
+	// +optional
+	// opsgenieAPIKeySecretRef selects the API key of the Opsgenie integration the alerts are opened with
+	OpsgenieAPIKeySecretRef *SecretKeyReference `json:"opsgenieAPIKeySecretRef,omitempty"`
+	// +optional
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// opsgenieURL is the Opsgenie API, e.g. https://api.eu.opsgenie.com for the EU instance
+	OpsgenieURL string `json:"opsgenieURL,omitempty"`
+}
+
+// NodeUpdaterConfigSpec defines the global settings of the controller. Every unset field keeps the value of the
+// command line flag or the environment variable of the controller.
+type NodeUpdaterConfigSpec struct {
+	// +optional
+	// time to wait before retrying a failed reconcile, overrides --error-reconcile-time
+	ErrorReconcileTime *metav1.Duration `json:"errorReconcileTime,omitempty"`
+	// +optional
+	// time to wait before the next reconcile of a rotation in progress, overrides --success-reconcile-time
+	SuccessReconcileTime *metav1.Duration `json:"successReconcileTime,omitempty"`
+	// +optional
+	// time to wait before an up to date cluster is checked for a new node image again, overrides --upgrade-frequency
+	UpgradeFrequency *metav1.Duration `json:"upgradeFrequency,omitempty"`
+	// +optional
+	// time to wait before the next reconcile while ARM provisions a nodepool by its provisioning state, e.g. Creating:
+	// 2m, overrides the listed states of --provisioning-requeue-times
+	ProvisioningRequeueTimes map[string]metav1.Duration `json:"provisioningRequeueTimes,omitempty"`
+	// +optional
+	// Azure DevOps organization and access token, overrides AZURE_DEVOPS_URL, AZURE_DEVOPS_ORG and AZURE_DEVOPS_PAT
+	AzureDevOps *AzureDevOpsConfig `json:"azureDevOps,omitempty"`
+	// +optional
+	// templates of the notification messages by event, e.g. RolledBack, as Go templates whose data is the
+	// notification: .Namespace, .Name, .Cluster, .Nodepools, .Versions, .Duration, .Error and .Message. Overrides
+	// notificationTemplates of --config-file.
+	NotificationTemplates map[string]string `json:"notificationTemplates,omitempty"`
+	// +optional
+	// services the notifications are sent to, overrides --notification-webhook-url, PAGERDUTY_ROUTING_KEY,
+	// OPSGENIE_API_KEY and --opsgenie-url
+	Notifications *NotificationsConfig `json:"notifications,omitempty"`
+}
+
+// NodeUpdaterConfigStatus defines the observed state of NodeUpdaterConfig.
+type NodeUpdaterConfigStatus struct {
+	// observedGeneration is the generation of the NodeUpdaterConfig which was applied last
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// conditions of the NodeUpdaterConfig
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:validation:XValidation:rule="self.metadata.name == 'default'",message="the NodeUpdaterConfig must be named 'default'"
+// +kubebuilder:printcolumn:name="Applied",type=string,JSONPath=".status.conditions[?(@.type==\"Applied\")].status"
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
+
+// NodeUpdaterConfig is the Schema for the nodeupdaterconfigs API. It holds the global settings of the controller,
+// which applies its changes without a restart.
+type NodeUpdaterConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeUpdaterConfigSpec   `json:"spec,omitempty"`
+	Status NodeUpdaterConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NodeUpdaterConfigList contains a list of NodeUpdaterConfig.
+type NodeUpdaterConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodeUpdaterConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NodeUpdaterConfig{}, &NodeUpdaterConfigList{})
+}
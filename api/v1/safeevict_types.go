@@ -17,13 +17,70 @@ limitations under the License.
 package v1
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// SubstitutionTemporaryPool is replaced with the name of the temporary nodepool in its extra node labels and taints
+	SubstitutionTemporaryPool = "$(TEMPORARY_POOL)"
+	// SubstitutionBasePool is replaced with the name of the base pool in the extra node labels and taints
+	SubstitutionBasePool = "$(BASE_POOL)"
+	// SubstitutionSafeEvict is replaced with the name of the SafeEvict in the extra node labels and taints
+	SubstitutionSafeEvict = "$(SAFE_EVICT)"
+	// MaxNodepoolNameLength is the maximum length of a Linux nodepool name in AKS
+	MaxNodepoolNameLength = 12
+	// temporaryNodepoolPrefix is prepended to the name of every temporary nodepool
+	temporaryNodepoolPrefix = "tmp"
+	// temporaryNodepoolHashLength is the number of hash characters derived from the UID of the SafeEvict
+	temporaryNodepoolHashLength = 6
+	// StateConfigMapLabel marks the ConfigMaps which hold the saved scaling of a rotation, its value is the name of the SafeEvict
+	StateConfigMapLabel = "update.norbinto/safeevict"
+	// StateNamespaceLabel is the namespace of the SafeEvict of a state ConfigMap which is kept in the state namespace of
+	// the controller, the ConfigMap cannot be owned by a SafeEvict in another namespace
+	StateNamespaceLabel = "update.norbinto/safeevict-namespace"
+	// ReleaseStateAnnotation allows the deletion of a state ConfigMap while its rotation is in progress when it is "true"
+	ReleaseStateAnnotation = "update.norbinto/release"
+	// ApprovedAnnotation approves the node image upgrade of the rotations which started at or before its value, an
+	// RFC 3339 timestamp, when the SafeEvict requires approval
+	ApprovedAnnotation = "update.norbinto/approved"
+	// CheckNowAnnotation asks for an immediate check of the nodepools instead of waiting for the upgrade frequency
+	CheckNowAnnotation = "update.norbinto/check-now"
+	// AbortAnnotation stops the running rotation and rolls it back when it is "true"
+	AbortAnnotation = "update.norbinto/abort"
+	// LastRotationAnnotation holds the time, in RFC 3339, a rotation restored the upgraded nodepool of the node
+	LastRotationAnnotation = "update.norbinto/last-rotation"
+	// RotatedByAnnotation holds the SafeEvict, as namespace/name, whose rotation upgraded the nodepool of the node
+	RotatedByAnnotation = "update.norbinto/rotated-by"
+	// RotatedByLabel and RotatedByNamespaceLabel hold the name and the namespace of the SafeEvict whose rotation
+	// upgraded the nodepool of the node, so the nodes can be selected by it. They are not set for a name which is no
+	// valid label value.
+	RotatedByLabel          = "update.norbinto/rotated-by"
+	RotatedByNamespaceLabel = "update.norbinto/rotated-by-namespace"
+	// DefaultPendingTimeout is the time an agent pod may be unschedulable before the pending pod watchdog reacts
+	DefaultPendingTimeout = 10 * time.Minute
+	// DefaultHeadroomPercent is the capacity the right-sized temporary nodepool gets on top of the requests of the pods
+	DefaultHeadroomPercent = 20
+	// DefaultMinHealthyPercent is the share of the agents on the upgraded nodepools which must be ready and online before
+	// the rotation is rolled back onto the retained temporary nodepool
+	DefaultMinHealthyPercent = 80
+	// DefaultRollbackGracePeriod is the time a new agent pod gets to become ready and register before it is counted
+	DefaultRollbackGracePeriod = 5 * time.Minute
+	// DefaultImageHistoryLimit is the number of node image versions kept in the image history of a nodepool
+	DefaultImageHistoryLimit = 10
+)
+
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 // SafeEvictSpec defines the desired state of SafeEvict.
+// +kubebuilder:validation:XValidation:rule="!has(self.drainSignal) || !has(self.drainSignal.exec) || has(self.serviceAccountRef)",message="drainSignal.exec requires serviceAccountRef, the command is executed in the name of that ServiceAccount"
 type SafeEvictSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make manifests" to regenerate code after modifying this file
@@ -31,25 +88,789 @@ type SafeEvictSpec struct {
 	// only pods will be effected with this labels
 	LabelSelector map[string]string `json:"labelSelector,omitempty"`
 	// +kubebuilder:validation:Required
-	// if this is the last line in the logs, it is safe to evict
-	LastLogLines []string `json:"lastLogLines,omitempty"`
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=32
+	// +kubebuilder:validation:items:MaxLength=1024
+	// +kubebuilder:validation:XValidation:rule="self.all(line, line.matches('\\\\S'))",message="every last log line must contain a non-whitespace character"
+	// if this is the last line in the logs, it is safe to evict. A blank line would match the logs of every pod.
+	LastLogLines []string `json:"lastLogLines"`
+	// +kubebuilder:validation:MaxItems=100
+	// +kubebuilder:validation:XValidation:rule="self.all(name, name.matches('^[a-z][a-z0-9]{0,11}$'))",message="nodepool names must be lowercase alphanumeric, start with a letter and have at most 12 characters"
 	// nodepools which will be monitored by node-updater controller
 	Nodepools []string `json:"nodepools,omitempty"`
+	// +kubebuilder:validation:MaxItems=256
+	// +kubebuilder:validation:items:MaxLength=63
+	// +kubebuilder:validation:XValidation:rule="self.all(name, name.matches('^[a-z0-9]([-a-z0-9]*[a-z0-9])?$'))",message="namespaces must be valid DNS labels"
 	// namespaces which will be monitored by node-updater controller
 	Namespaces []string `json:"namespaces,omitempty"`
 	// +kubebuilder:validation:Required
-	// pool name which will be cloned for creating backup pool
+	// +kubebuilder:validation:XValidation:rule="self.matches('^[a-z][a-z0-9]{0,11}$')",message="baseForBackupPoolName must be a nodepool name: lowercase alphanumeric, starting with a letter, at most 12 characters"
+	// pool name which will be cloned for creating backup pool, it is one of nodepools or another nodepool of the cluster
 	BaseForBackupPool string `json:"baseForBackupPoolName,omitempty"`
+	// +kubebuilder:validation:Enum=AzureDevOps;None
+	// +kubebuilder:default=AzureDevOps
+	// +optional
+	// agent provider where the evicted pods are registered as agents, None evicts idle pods without deregistering them
+	AgentProvider AgentProvider `json:"agentProvider,omitempty"`
+	// +optional
+	// service account which is impersonated to cordon nodes, delete jobs and evict pods, when it is not set the controller's own identity is used
+	ServiceAccountRef *ServiceAccountReference `json:"serviceAccountRef,omitempty"`
+	// +optional
+	// when it is set, the nodepools are not rotated while the controller runs on one of them
+	RequireControllerExcluded bool `json:"requireControllerExcluded,omitempty"`
+	// +optional
+	// selects the kubeconfig Secrets of the workload clusters in the namespace of the SafeEvict, when it is not set the cluster of the controller is updated
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+	// +optional
+	// maximum duration of a rotation, a rotation which takes longer is rolled back: the saved scaling, cordons and taints
+	// are restored and the rotation is retried after the upgrade frequency
+	UpgradeTimeout *metav1.Duration `json:"upgradeTimeout,omitempty"`
+	// +optional
+	// when it is set, the temporary nodepool is drained and removed when a rotation is rolled back, otherwise it is kept
+	// for the next attempt
+	RemoveBackupPoolOnTimeout bool `json:"removeBackupPoolOnTimeout,omitempty"`
+	// +optional
+	// when it is set, the drained nodepools are upgraded only after the rotation is approved with the
+	// update.norbinto/approved annotation
+	RequireApproval bool `json:"requireApproval,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// minimum number of ready agent pods in the monitored namespaces, across the outdated and the temporary nodepools.
+	// Idle pods are only evicted while more agents are ready, the rest is evicted once replacement agents came up.
+	MinAvailableAgents int32 `json:"minAvailableAgents,omitempty"`
+	// +optional
+	// when it is set, an agent is only removed from its Azure DevOps pool while a replacement agent of the same pool is
+	// online outside of the cordoned nodes, e.g. on the temporary nodepool
+	VerifyReplacementAgents bool `json:"verifyReplacementAgents,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// maximum number of jobs queued in an Azure DevOps pool, the agents of a pool with more queued jobs are not evicted
+	// until the backlog is cleared
+	MaxQueuedJobs *int32 `json:"maxQueuedJobs,omitempty"`
+	// +optional
+	// when it is set, the busy agents are sampled into a usage history and new rotations are started in the
+	// historically least busy hours of the day
+	AutoSchedule bool `json:"autoSchedule,omitempty"`
+	// +optional
+	// overrides of the temporary nodepool, which is otherwise a clone of the base pool
+	BackupPool *BackupPoolSpec `json:"backupPool,omitempty"`
+	// +optional
+	// notifies the agent in an idle pod before its job and pod are deleted, so it can shut down cleanly
+	DrainSignal *DrainSignal `json:"drainSignal,omitempty"`
+	// +optional
+	// how the idle pods are evicted
+	Eviction *EvictionSpec `json:"eviction,omitempty"`
+	// +kubebuilder:validation:Enum=NodePool;NodeReimage
+	// +kubebuilder:default=NodePool
+	// +optional
+	// how the outdated nodepools are upgraded, NodeReimage drains and reimages their scale set instances one node at a
+	// time instead of upgrading the whole nodepool at once
+	UpgradeStrategy UpgradeStrategy `json:"upgradeStrategy,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// maximum number of outdated nodepools which are drained and upgraded at the same time, the others are queued in
+	// name order until one of them is upgraded. By default every outdated nodepool is rotated at once.
+	MaxConcurrentPools *int32 `json:"maxConcurrentPools,omitempty"`
+	// +optional
+	// reacts to agent pods which stay Pending while a rotation drains, e.g. because the temporary nodepool is too small
+	// for the evicted agents
+	PendingPodWatchdog *PendingPodWatchdogSpec `json:"pendingPodWatchdog,omitempty"`
+	// +optional
+	// selects the running pods of the namespaces which hold back the upgrade of their nodepool, e.g. the build agents.
+	// When it is not set every running pod of the namespaces blocks the upgrade, also unrelated long-running services.
+	BlockingPodSelector *metav1.LabelSelector `json:"blockingPodSelector,omitempty"`
+	// +optional
+	// removes the nodepools which no longer exist in the cluster from status.pools. The missing nodepools are reported
+	// in the NodepoolsMissing condition and left out of the rotation either way.
+	PruneMissingNodepools bool `json:"pruneMissingNodepools,omitempty"`
+	// +kubebuilder:validation:Enum=Rotate;Skip
+	// +kubebuilder:default=Rotate
+	// +optional
+	// how the outdated system nodepools of the spec are handled. Rotate rotates them like the user nodepools as long as
+	// schedulable system nodes remain outside of the rotation, Skip leaves them alone. A skipped system nodepool is
+	// reported in the SystemNodepoolsSkipped condition.
+	SystemNodepools SystemNodepoolPolicy `json:"systemNodepools,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	// number of node image versions kept in the image history of every nodepool in status.pools, defaults to 10. Zero
+	// keeps no history.
+	ImageHistoryLimit *int32 `json:"imageHistoryLimit,omitempty"`
+}
+
+// PendingPodWatchdogSpec configures how the rotation reacts to agent pods which cannot be scheduled
+type PendingPodWatchdogSpec struct {
+	// +optional
+	// how long an agent pod of the monitored namespaces may be unschedulable before the watchdog reacts, defaults to
+	// 10 minutes
+	PendingTimeout *metav1.Duration `json:"pendingTimeout,omitempty"`
+	// +kubebuilder:validation:Enum=PauseEvictions;ScaleUpBackupPool
+	// +kubebuilder:default=PauseEvictions
+	// +optional
+	// what the rotation does while agent pods are stuck Pending
+	Action PendingPodAction `json:"action,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// node count the temporary nodepool is scaled up to at most, the maximum count of its autoscaler when it is
+	// autoscaled. It is required by ScaleUpBackupPool, the evictions are paused once it is reached.
+	MaxBackupPoolCount *int32 `json:"maxBackupPoolCount,omitempty"`
+}
+
+// PendingPodAction is how the rotation reacts to agent pods which are stuck Pending
+type PendingPodAction string
+
+const (
+	// PendingPodActionPauseEvictions stops evicting idle pods until the stuck pods are scheduled
+	PendingPodActionPauseEvictions PendingPodAction = "PauseEvictions"
+	// PendingPodActionScaleUpBackupPool adds a node to the temporary nodepool every reconcile while pods are stuck
+	PendingPodActionScaleUpBackupPool PendingPodAction = "ScaleUpBackupPool"
+)
+
+// EvictionSpec configures the eviction of the idle pods
+type EvictionSpec struct {
+	// +kubebuilder:validation:Enum=OldestFirst;NewestFirst;ByPriorityClass
+	// +optional
+	// order in which the idle pods of a nodepool are evicted, so the least valuable agents go first. By default they
+	// are evicted in the order they are listed.
+	Order EvictionOrder `json:"order,omitempty"`
+	// +optional
+	// pods which started less than minPodAge ago are neither evaluated nor evicted, a freshly started agent may be about
+	// to pick up a job while its logs are still short, e.g. when KEDA just scaled it up
+	MinPodAge *metav1.Duration `json:"minPodAge,omitempty"`
+}
+
+// EvictionOrder is the order in which the idle pods are evicted
+type EvictionOrder string
+
+const (
+	// EvictionOrderOldestFirst evicts the longest running pods first
+	EvictionOrderOldestFirst EvictionOrder = "OldestFirst"
+	// EvictionOrderNewestFirst evicts the most recently created pods first, e.g. to keep warm cache agents until last
+	EvictionOrderNewestFirst EvictionOrder = "NewestFirst"
+	// EvictionOrderByPriorityClass evicts the pods of the lowest priority first, the newest first within a priority
+	EvictionOrderByPriorityClass EvictionOrder = "ByPriorityClass"
+)
+
+// UpgradeStrategy is how the outdated nodepools are upgraded
+type UpgradeStrategy string
+
+const (
+	// UpgradeStrategyNodePool drains the whole nodepool and starts its node image upgrade in AKS
+	UpgradeStrategyNodePool UpgradeStrategy = "NodePool"
+	// UpgradeStrategyNodeReimage drains one node of the nodepool at a time and reimages its scale set instance through
+	// the compute API, the next node is drained once the reimaged one is back with the latest node image
+	UpgradeStrategyNodeReimage UpgradeStrategy = "NodeReimage"
+)
+
+// SystemNodepoolPolicy is how the outdated system nodepools are handled
+type SystemNodepoolPolicy string
+
+const (
+	// SystemNodepoolPolicyRotate rotates the system nodepools, a system nodepool is skipped while no schedulable system
+	// node would remain outside of the rotation for the pods of kube-system
+	SystemNodepoolPolicyRotate SystemNodepoolPolicy = "Rotate"
+	// SystemNodepoolPolicySkip leaves the system nodepools alone, e.g. when AKS upgrades them with the cluster
+	SystemNodepoolPolicySkip SystemNodepoolPolicy = "Skip"
+)
+
+// DrainSignal is sent to the agent of a pod before it is evicted, e.g. touching a drain file watched by the entrypoint
+// of the agent. Exactly one of Exec and HTTPGet is set.
+type DrainSignal struct {
+	// +optional
+	// command executed in the agent container
+	Exec *corev1.ExecAction `json:"exec,omitempty"`
+	// +optional
+	// HTTP request sent to the pod IP
+	HTTPGet *corev1.HTTPGetAction `json:"httpGet,omitempty"`
+	// +optional
+	// container of the command, defaults to the first container of the pod
+	Container string `json:"container,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=300
+	// +optional
+	// seconds the agent gets to shut down after the signal before its job and pod are deleted
+	GracePeriodSeconds int32 `json:"gracePeriodSeconds,omitempty"`
+}
+
+// BackupPoolSpec overrides the configuration cloned from the base pool into the temporary nodepool
+type BackupPoolSpec struct {
+	// +kubebuilder:validation:Pattern=`^Standard_[A-Za-z0-9_]+$`
+	// +optional
+	// VM size of the temporary nodepool, e.g. Standard_D4ps_v5. It must have the architecture (amd64 or arm64) of the
+	// base pool, which runs the agent images today.
+	VMSize string `json:"vmSize,omitempty"`
+	// +optional
+	// node labels added to the labels cloned from the base pool, they replace the cloned labels of the same key. Keys
+	// and values may contain $(TEMPORARY_POOL), $(BASE_POOL) and $(SAFE_EVICT), which are replaced with the name of the
+	// temporary nodepool, of the base pool and of the SafeEvict, e.g. pool: $(TEMPORARY_POOL)
+	ExtraNodeLabels map[string]string `json:"extraNodeLabels,omitempty"`
+	// +optional
+	// +kubebuilder:validation:items:Pattern=`^[^=:\s]+(=[^:\s]*)?:(NoSchedule|PreferNoSchedule|NoExecute)$`
+	// node taints in the AKS format key=value:Effect added to the taints cloned from the base pool, they replace the
+	// cloned taints of the same key and effect. They may contain the substitutions of extraNodeLabels.
+	ExtraNodeTaints []string `json:"extraNodeTaints,omitempty"`
+	// +optional
+	// sizes the temporary nodepool from the resource requests of the agent pods on the outdated nodepools instead of
+	// cloning the node count of the base pool, which may be scaled to its maximum already
+	RightSizing *RightSizingSpec `json:"rightSizing,omitempty"`
+	// +optional
+	// shares the temporary nodepool with the other shared SafeEvicts whose temporary nodepool has the same base pool,
+	// VM size, extra node labels and extra node taints. The nodepool is created by the first rotation which needs it,
+	// with the node count of that SafeEvict, and it is drained and removed once every rotation using it is cleaning up.
+	Shared bool `json:"shared,omitempty"`
+	// +optional
+	// keeps the drained temporary nodepool, scaled down, for the duration after the upgrade before it is removed, e.g.
+	// 1h, so the workload can be moved back onto it quickly when the new node image breaks the agents. The rotation
+	// stays in CleaningUp meanwhile. It is not supported for a shared temporary nodepool.
+	RetainFor *metav1.Duration `json:"retainFor,omitempty"`
+	// +optional
+	// watches the agents on the upgraded nodepools while the temporary nodepool is retained, and moves the workload back
+	// onto it when they degrade. It requires retainFor.
+	AutoRollback *AutoRollbackSpec `json:"autoRollback,omitempty"`
+}
+
+// AutoRollbackSpec configures the thresholds below which the agents on the upgraded nodepools count as degraded
+type AutoRollbackSpec struct {
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	// share of the agent pods on the upgraded nodepools which must be ready in percent, defaults to 80
+	MinReadyPercent *int32 `json:"minReadyPercent,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	// share of the agent pods on the upgraded nodepools whose agents must be registered and online in Azure DevOps in
+	// percent, defaults to 80. It is not checked when the agent provider is None.
+	MinOnlinePercent *int32 `json:"minOnlinePercent,omitempty"`
+	// +optional
+	// time a new agent pod gets to become ready and register before it is counted, defaults to 5m
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// RightSizingSpec configures how the node count of the temporary nodepool is computed from the live workload
+type RightSizingSpec struct {
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=200
+	// +optional
+	// capacity added on top of the resource requests of the agent pods in percent, defaults to 20
+	HeadroomPercent *int32 `json:"headroomPercent,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1000
+	// +optional
+	// upper limit of the computed node count
+	MaxCount *int32 `json:"maxCount,omitempty"`
+}
+
+// ServiceAccountReference points to the ServiceAccount impersonated for the mutations of a SafeEvict. The ServiceAccount
+// is always looked up in the namespace of the SafeEvict, so a SafeEvict cannot borrow the permissions of another
+// namespace.
+type ServiceAccountReference struct {
+	// +kubebuilder:validation:MinLength=1
+	// name of the ServiceAccount in the namespace of the SafeEvict
+	Name string `json:"name"`
+}
+
+// AgentProvider is the system where the evicted pods are registered as build agents
+type AgentProvider string
+
+const (
+	// AgentProviderAzureDevOps disables and removes the agent in Azure DevOps before the pod is evicted
+	AgentProviderAzureDevOps AgentProvider = "AzureDevOps"
+	// AgentProviderNone evicts idle pods without talking to any agent provider
+	AgentProviderNone AgentProvider = "None"
+)
+
+// Phase is the step of the node image rotation of a cluster
+// +kubebuilder:validation:Enum=Detecting;ProvisioningBackup;Draining;Upgrading;Restoring;CleaningUp;RollingBack;Failed
+type Phase string
+
+const (
+	// PhaseDetecting looks for outdated nodepools, the cluster stays in it while it is up to date
+	PhaseDetecting Phase = "Detecting"
+	// PhaseProvisioningBackup creates the temporary nodepool and saves the scaling of the outdated nodepools
+	PhaseProvisioningBackup Phase = "ProvisioningBackup"
+	// PhaseDraining cordons the outdated nodepools, evicts the idle pods and starts the upgrade of the drained nodepools
+	PhaseDraining Phase = "Draining"
+	// PhaseUpgrading waits until the node image upgrades are finished
+	PhaseUpgrading Phase = "Upgrading"
+	// PhaseRestoring restores the saved scaling and uncordons the upgraded nodepools
+	PhaseRestoring Phase = "Restoring"
+	// PhaseCleaningUp drains and removes the temporary nodepool and deletes the saved scaling
+	PhaseCleaningUp Phase = "CleaningUp"
+	// PhaseRollingBack restores the saved scaling, cordons and taints of a rotation which exceeded the upgrade timeout
+	PhaseRollingBack Phase = "RollingBack"
+	// PhaseFailed waits for the upgrade frequency after a rollback, then the rotation is retried from detection
+	PhaseFailed Phase = "Failed"
+)
+
+// InProgress returns true when the rotation is past detecting, i.e. the scaling of the nodepools may already be changed
+func (p Phase) InProgress() bool {
+	return p != "" && p != PhaseDetecting && p != PhaseFailed
+}
+
+const (
+	// ConditionFailed is true when the last rotation was rolled back
+	ConditionFailed = "Failed"
+
+	// ReasonUpgradeTimeout is the reason of the Failed condition of a rotation which exceeded the upgrade timeout
+	ReasonUpgradeTimeout = "UpgradeTimeout"
+	// ReasonRotationStarted is the reason of the Failed and Aborted conditions while a new rotation is running
+	ReasonRotationStarted = "RotationStarted"
+	// ReasonAborted is the reason of the Failed condition of a rotation which was stopped with the abort annotation
+	ReasonAborted = "Aborted"
+	// ReasonAgentsDegraded is the reason of the Failed condition of a rotation which was rolled back onto the retained
+	// temporary nodepool
+	ReasonAgentsDegraded = "AgentsDegraded"
+
+	// ConditionRolledBackToBackupPool is true while the workload is held on the retained temporary nodepool because the
+	// agents on the upgraded nodepools degraded
+	ConditionRolledBackToBackupPool = "RolledBackToBackupPool"
+	// ReasonHeld is the reason of the RolledBackToBackupPool condition while the workload is held
+	ReasonHeld = "Held"
+	// ReasonReleased is the reason of the RolledBackToBackupPool condition once the abort annotation released the hold
+	ReasonReleased = "Released"
+
+	// ConditionAborted is true when the last rotation was stopped with the abort annotation
+	ConditionAborted = "Aborted"
+
+	// ReasonAbortRequested is the reason of the Aborted condition of a rotation which was stopped with the abort annotation
+	ReasonAbortRequested = "AbortRequested"
+
+	// ConditionAwaitingApproval is true while drained nodepools wait for the approval of their upgrade
+	ConditionAwaitingApproval = "AwaitingApproval"
+
+	// ReasonApprovalRequired is the reason of the AwaitingApproval condition while the rotation is not approved
+	ReasonApprovalRequired = "ApprovalRequired"
+	// ReasonApproved is the reason of the AwaitingApproval condition once the rotation is approved
+	ReasonApproved = "Approved"
+
+	// ConditionUpgradeBlocked is true while the node image upgrade of a drained nodepool is not permitted, e.g. because
+	// another operation is running on the managed cluster
+	ConditionUpgradeBlocked = "UpgradeBlocked"
+
+	// ReasonUpgradeNotPermitted is the reason of the UpgradeBlocked condition while an upgrade is not permitted
+	ReasonUpgradeNotPermitted = "UpgradeNotPermitted"
+	// ReasonUpgradePermitted is the reason of the UpgradeBlocked condition once the upgrades are permitted again
+	ReasonUpgradePermitted = "UpgradePermitted"
+
+	// ConditionPlanVetoed is true while the plan of a rotation is vetoed by a plan reviewer
+	ConditionPlanVetoed = "PlanVetoed"
+
+	// ReasonVetoed is the reason of the PlanVetoed condition while the plan is vetoed
+	ReasonVetoed = "Vetoed"
+	// ReasonPlanAllowed is the reason of the PlanVetoed condition once the plan is allowed
+	ReasonPlanAllowed = "Allowed"
+
+	// ConditionPlacementImpossible is true while the agent pods of an outdated nodepool could not be scheduled on the
+	// temporary nodepool because of their node selectors, node affinities or tolerations, the nodepool is not cordoned
+	ConditionPlacementImpossible = "PlacementImpossible"
+
+	// ReasonUnschedulable is the reason of the PlacementImpossible condition while agent pods cannot be placed
+	ReasonUnschedulable = "Unschedulable"
+	// ReasonSchedulable is the reason of the PlacementImpossible condition once the agent pods can be placed
+	ReasonSchedulable = "Schedulable"
+
+	// ConditionPodsPending is true while agent pods are unschedulable for longer than the pending timeout of the
+	// pending pod watchdog
+	ConditionPodsPending = "PodsPending"
+
+	// ReasonEvictionsPaused is the reason of the PodsPending condition while the evictions are paused
+	ReasonEvictionsPaused = "EvictionsPaused"
+	// ReasonBackupPoolScaledUp is the reason of the PodsPending condition while the temporary nodepool is scaled up
+	ReasonBackupPoolScaledUp = "BackupPoolScaledUp"
+	// ReasonPodsScheduled is the reason of the PodsPending condition once no agent pod is stuck Pending
+	ReasonPodsScheduled = "PodsScheduled"
+
+	// ConditionNodepoolsMissing is true while nodepools listed in the spec do not exist in the cluster
+	ConditionNodepoolsMissing = "NodepoolsMissing"
+
+	// ReasonNodepoolsNotFound is the reason of the NodepoolsMissing condition while listed nodepools do not exist
+	ReasonNodepoolsNotFound = "NodepoolsNotFound"
+	// ReasonNodepoolsFound is the reason of the NodepoolsMissing condition once every listed nodepool exists
+	ReasonNodepoolsFound = "NodepoolsFound"
+
+	// ConditionSystemNodepoolsSkipped is true while outdated system nodepools of the spec are left out of the rotation
+	ConditionSystemNodepoolsSkipped = "SystemNodepoolsSkipped"
+
+	// ReasonSkippedBySpec is the reason of the SystemNodepoolsSkipped condition when the spec skips the system nodepools
+	ReasonSkippedBySpec = "SkippedBySpec"
+	// ReasonNoOtherSystemNode is the reason of the SystemNodepoolsSkipped condition when no schedulable system node
+	// remains outside of the rotation for the pods of kube-system
+	ReasonNoOtherSystemNode = "NoOtherSystemNode"
+	// ReasonSystemNodepoolsRotated is the reason of the SystemNodepoolsSkipped condition once no system nodepool is
+	// skipped
+	ReasonSystemNodepoolsRotated = "SystemNodepoolsRotated"
+
+	// ConditionScalingStateInvalid is true while the saved scaling of the nodepools does not match its checksum or has
+	// an invalid scaling, the nodepools are not restored from it
+	ConditionScalingStateInvalid = "ScalingStateInvalid"
+
+	// ReasonChecksumMismatch is the reason of the ScalingStateInvalid condition while the saved scaling does not match
+	// its checksum
+	ReasonChecksumMismatch = "ChecksumMismatch"
+	// ReasonInvalidScaling is the reason of the ScalingStateInvalid condition while the saved scaling of a nodepool lacks
+	// the counts of its scaling or has a newer schema version than the controller supports
+	ReasonInvalidScaling = "InvalidScaling"
+	// ReasonChecksumVerified is the reason of the ScalingStateInvalid condition once the saved scaling is accepted
+	ReasonChecksumVerified = "ChecksumVerified"
+
+	// ConditionReady is true when the last reconcile succeeded and no rotation has failed
+	ConditionReady = "Ready"
+	// ConditionProgressing is true while a rotation is running
+	ConditionProgressing = "Progressing"
+
+	// ReasonReconciled is the reason of the Ready condition after a successful reconcile
+	ReasonReconciled = "Reconciled"
+	// ReasonReconcileError is the reason of the Ready condition when the last reconcile returned an error
+	ReasonReconcileError = "ReconcileError"
+	// ReasonReconcileTimedOut is the reason of the Ready condition when the last reconcile ran out of its deadline
+	ReasonReconcileTimedOut = "ReconcileTimedOut"
+	// ReasonRotationFailed is the reason of the Ready and Progressing conditions when a rotation was rolled back
+	ReasonRotationFailed = "RotationFailed"
+	// ReasonRotationInProgress is the reason of the Progressing condition while a rotation is running
+	ReasonRotationInProgress = "RotationInProgress"
+	// ReasonUpToDate is the reason of the Progressing condition while no rotation is running
+	ReasonUpToDate = "UpToDate"
+)
+
+// NodepoolState is the outcome of the rotation of a nodepool
+// +kubebuilder:validation:Enum=Queued;InProgress;Succeeded;Failed;Skipped
+type NodepoolState string
+
+const (
+	// NodepoolStateQueued means the outdated nodepool waits until fewer than maxConcurrentPools nodepools are rotated
+	NodepoolStateQueued NodepoolState = "Queued"
+	// NodepoolStateInProgress means the nodepool is being rotated
+	NodepoolStateInProgress NodepoolState = "InProgress"
+	// NodepoolStateSucceeded means the nodepool is upgraded and its scaling is restored
+	NodepoolStateSucceeded NodepoolState = "Succeeded"
+	// NodepoolStateFailed means the last step of the rotation failed for the nodepool, it is retried with the next reconcile
+	NodepoolStateFailed NodepoolState = "Failed"
+	// NodepoolStateSkipped means a plan reviewer removed the outdated nodepool from the rotation
+	NodepoolStateSkipped NodepoolState = "Skipped"
+)
+
+// ErrorCategory is the dependency whose failure stopped a step of the rotation of a nodepool
+// +kubebuilder:validation:Enum=Azure;DevOps;Kubernetes;Unknown
+type ErrorCategory string
+
+const (
+	// ErrorCategoryAzure means the Azure Resource Manager refused or failed a request, or an operation on the agent pool failed
+	ErrorCategoryAzure ErrorCategory = "Azure"
+	// ErrorCategoryDevOps means a request to Azure DevOps failed
+	ErrorCategoryDevOps ErrorCategory = "DevOps"
+	// ErrorCategoryKubernetes means the API server of the cluster refused or failed a request
+	ErrorCategoryKubernetes ErrorCategory = "Kubernetes"
+	// ErrorCategoryUnknown means the error could not be attributed to a dependency
+	ErrorCategoryUnknown ErrorCategory = "Unknown"
+)
+
+// NodepoolError is the last error which stopped a step of the rotation of a nodepool
+type NodepoolError struct {
+	// time is the time the error occurred
+	Time metav1.Time `json:"time"`
+
+	// category is the dependency which failed
+	Category ErrorCategory `json:"category"`
+
+	// message is the error
+	Message string `json:"message"`
+
+	// retryable is true when the failed step is expected to succeed once retried, e.g. on throttling, conflicts or
+	// server errors. A step which is not retryable needs the configuration or the cluster to be fixed.
+	Retryable bool `json:"retryable"`
+}
+
+// NodepoolStatus is the observed state of a nodepool in the last rotation
+type NodepoolStatus struct {
+	// name is the name of the nodepool
+	Name string `json:"name"`
+
+	// state is the outcome of the rotation of the nodepool
+	State NodepoolState `json:"state"`
+
+	// message describes the last failure of the nodepool
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// conflicts counts the consecutive changes of the nodepool which ARM refused because another operation was
+	// running on it, the retries of the change back off with it
+	// +optional
+	Conflicts int32 `json:"conflicts,omitempty"`
+
+	// lastError is the last error of the nodepool in the rotation, it is kept after the nodepool recovered so the
+	// failing dependency can be looked up later
+	// +optional
+	LastError *NodepoolError `json:"lastError,omitempty"`
+
+	// imageHistory holds the node image versions the rotations upgraded the nodepool to, the oldest first. It is kept
+	// across the rotations up to spec.imageHistoryLimit versions.
+	// +optional
+	ImageHistory []ImageVersionRecord `json:"imageHistory,omitempty"`
+}
+
+// ImageVersionRecord is a node image version a rotation upgraded a nodepool to
+type ImageVersionRecord struct {
+	// version is the node image version
+	Version string `json:"version"`
+
+	// upgradedAt is the time the nodepool took workload again with the version
+	UpgradedAt metav1.Time `json:"upgradedAt"`
+
+	// initiatedBy is the SafeEvict whose rotation upgraded the nodepool, as namespace/name
+	InitiatedBy string `json:"initiatedBy"`
+}
+
+// RotationStatus is the observed state of the rotation of one cluster
+type RotationStatus struct {
+	// phase is the step of the rotation, it is empty until the first reconcile
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+
+	// pools holds the state of every nodepool of the last rotation, the nodepools with an image history keep it from
+	// the earlier rotations
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Pools []NodepoolStatus `json:"pools,omitempty"`
+
+	// startTime is the time the last rotation started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// nextCheckTime is the time the up to date cluster is checked for outdated nodepools again, it is empty while a
+	// rotation is in progress
+	// +optional
+	NextCheckTime *metav1.Time `json:"nextCheckTime,omitempty"`
+
+	// stragglerNodes is the number of nodes of the last rotation which missed the upgrade of their nodepool, they are
+	// drained and deleted so the scale set replaces them with nodes of the upgraded image
+	// +optional
+	StragglerNodes int32 `json:"stragglerNodes,omitempty"`
+
+	// temporaryNodepoolRetainedUntil is the time the drained temporary nodepool of the last rotation is removed, it is
+	// set while the nodepool is retained for spec.backupPool.retainFor
+	// +optional
+	TemporaryNodepoolRetainedUntil *metav1.Time `json:"temporaryNodepoolRetainedUntil,omitempty"`
+
+	// temporaryNodepool is the name of a temporary nodepool which was created under the legacy name, before the names
+	// were derived from the UID of the SafeEvict. It is kept until the nodepool is removed, empty for the derived name.
+	// +optional
+	TemporaryNodepool string `json:"temporaryNodepool,omitempty"`
+
+	// temporaryNodepoolCount is the node count of the retained temporary nodepool before it was scaled down, it is
+	// scaled back to it when the rotation is rolled back onto it
+	// +optional
+	TemporaryNodepoolCount int32 `json:"temporaryNodepoolCount,omitempty"`
+
+	// openAlerts are the keys of the alerts opened for the failed rotation or the blocked upgrades, they are resolved
+	// once the rotation recovers
+	// +optional
+	OpenAlerts []string `json:"openAlerts,omitempty"`
+
+	// conditions of the rotation
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// counters are the cumulative counts of every rotation of the cluster, they survive restarts of the controller
+	// +optional
+	Counters RotationCounters `json:"counters,omitempty"`
+
+	// agentPools maps the nodepools to the Azure DevOps pools of the agent pods running on them. It is discovered from
+	// the AZP_POOL of the pods in namespaces at every check for outdated nodepools, and kept when the discovery fails.
+	// +optional
+	// +listType=map
+	// +listMapKey=nodepool
+	AgentPools []AgentPoolMapping `json:"agentPools,omitempty"`
+}
+
+// AgentPoolMapping is a nodepool and the Azure DevOps pools of the agent pods running on it
+type AgentPoolMapping struct {
+	// nodepool is the name of the nodepool
+	Nodepool string `json:"nodepool"`
+
+	// azureDevOpsPools are the Azure DevOps pools of the agent pods on the nodepool
+	AzureDevOpsPools []string `json:"azureDevOpsPools"`
+}
+
+// RotationCounters counts the work of the rotations of a cluster since the SafeEvict was created, e.g. for SLO reporting
+type RotationCounters struct {
+	// rotations is the number of rotations which ended, completed or rolled back
+	// +optional
+	Rotations int64 `json:"rotations,omitempty"`
+
+	// succeededRotations is the number of rotations which completed
+	// +optional
+	SucceededRotations int64 `json:"succeededRotations,omitempty"`
+
+	// podsEvicted is the number of idle agent pods evicted by the rotations
+	// +optional
+	PodsEvicted int64 `json:"podsEvicted,omitempty"`
+
+	// downtimeFreeUpgrades is the number of nodepools upgraded and restored by the rotations, only idle agents are
+	// evicted so no running job was interrupted by them
+	// +optional
+	DowntimeFreeUpgrades int64 `json:"downtimeFreeUpgrades,omitempty"`
 }
 
 // SafeEvictStatus defines the observed state of SafeEvict.
 type SafeEvictStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// the rotation of the cluster of the controller, in management cluster mode only its conditions are used which
+	// summarize the workload clusters
+	RotationStatus `json:",inline"`
+
+	// observedGeneration is the generation of the SafeEvict which was reconciled last
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// clusters holds the phase of every workload cluster selected by clusterSelector
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Clusters []ClusterStatus `json:"clusters,omitempty"`
+}
+
+// ClusterStatus is the observed state of a workload cluster
+type ClusterStatus struct {
+	// name is the name of the kubeconfig Secret of the workload cluster
+	Name string `json:"name"`
+
+	// the rotation of the workload cluster
+	RotationStatus `json:",inline"`
+}
+
+// SetNodepoolState records the state of the nodepool, the message is cleared unless the nodepool failed. The
+// conflicts are cleared, the step which recorded the state got past them.
+func (s *RotationStatus) SetNodepoolState(name string, state NodepoolState, message string) {
+	for i := range s.Pools {
+		if s.Pools[i].Name == name {
+			s.Pools[i].State = state
+			s.Pools[i].Message = message
+			s.Pools[i].Conflicts = 0
+			return
+		}
+	}
+	s.Pools = append(s.Pools, NodepoolStatus{Name: name, State: state, Message: message})
+}
+
+// RecordConflict counts a change of the nodepool which ARM refused with a conflict and returns the number of
+// consecutive conflicts, the nodepool stays in progress
+func (s *RotationStatus) RecordConflict(name string, message string) int32 {
+	for i := range s.Pools {
+		if s.Pools[i].Name == name {
+			s.Pools[i].State = NodepoolStateInProgress
+			s.Pools[i].Message = message
+			s.Pools[i].Conflicts++
+			return s.Pools[i].Conflicts
+		}
+	}
+	s.Pools = append(s.Pools, NodepoolStatus{Name: name, State: NodepoolStateInProgress, Message: message, Conflicts: 1})
+	return 1
+}
+
+// SetNodepoolError records the last error of the nodepool, the error of a nodepool which is not part of the rotation is
+// dropped
+func (s *RotationStatus) SetNodepoolError(name string, nodepoolError NodepoolError) {
+	for i := range s.Pools {
+		if s.Pools[i].Name == name {
+			s.Pools[i].LastError = &nodepoolError
+			return
+		}
+	}
+}
+
+// ResetPools forgets the nodepools of the last rotation when a rotation starts. The nodepools with an image history are
+// kept with it, only the message, the conflicts and the last error of the last rotation are cleared.
+func (s *RotationStatus) ResetPools() {
+	s.Pools = slices.DeleteFunc(s.Pools, func(nodepoolStatus NodepoolStatus) bool {
+		return len(nodepoolStatus.ImageHistory) == 0
+	})
+	for i := range s.Pools {
+		s.Pools[i].Message = ""
+		s.Pools[i].Conflicts = 0
+		s.Pools[i].LastError = nil
+	}
+}
+
+// RecordImageVersion adds the version to the image history of the nodepool, the oldest versions are dropped beyond
+// limit. A version which is already the last one of the history is not added again.
+func (s *RotationStatus) RecordImageVersion(name string, record ImageVersionRecord, limit int) {
+	for i := range s.Pools {
+		if s.Pools[i].Name != name {
+			continue
+		}
+		history := s.Pools[i].ImageHistory
+		if len(history) > 0 && history[len(history)-1].Version == record.Version {
+			return
+		}
+		history = append(history, record)
+		s.Pools[i].ImageHistory = history[max(len(history)-limit, 0):]
+		if len(s.Pools[i].ImageHistory) == 0 {
+			s.Pools[i].ImageHistory = nil
+		}
+		return
+	}
+}
+
+// RemoveNodepool drops the status of the nodepool, it returns false when the nodepool had no status
+func (s *RotationStatus) RemoveNodepool(name string) bool {
+	pools := slices.DeleteFunc(s.Pools, func(nodepoolStatus NodepoolStatus) bool {
+		return nodepoolStatus.Name == name
+	})
+	removed := len(pools) != len(s.Pools)
+	s.Pools = pools
+	return removed
+}
+
+// GetNodepoolState returns the state of the nodepool, it is empty when the nodepool is not part of the rotation
+func (s *RotationStatus) GetNodepoolState(name string) NodepoolState {
+	for _, nodepoolStatus := range s.Pools {
+		if nodepoolStatus.Name == name {
+			return nodepoolStatus.State
+		}
+	}
+	return ""
+}
+
+// GetClusterPhase returns the phase of the workload cluster, it is empty when the cluster was not reconciled yet
+func (s *SafeEvictStatus) GetClusterPhase(name string) Phase {
+	for _, clusterStatus := range s.Clusters {
+		if clusterStatus.Name == name {
+			return clusterStatus.Phase
+		}
+	}
+	return ""
+}
+
+// GetClusterStatus returns a copy of the status of the workload cluster, only the name is set when the cluster was not
+// reconciled yet
+func (s *SafeEvictStatus) GetClusterStatus(name string) ClusterStatus {
+	for _, clusterStatus := range s.Clusters {
+		if clusterStatus.Name == name {
+			return *clusterStatus.DeepCopy()
+		}
+	}
+	return ClusterStatus{Name: name}
+}
+
+// SetClusterPhase records the phase of the workload cluster
+func (s *SafeEvictStatus) SetClusterPhase(name string, phase Phase) {
+	for i := range s.Clusters {
+		if s.Clusters[i].Name == name {
+			s.Clusters[i].Phase = phase
+			return
+		}
+	}
+	s.Clusters = append(s.Clusters, ClusterStatus{Name: name, RotationStatus: RotationStatus{Phase: phase}})
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
 
 // SafeEvict is the Schema for the safeevicts API.
 type SafeEvict struct {
@@ -64,12 +885,241 @@ func (s *SafeEvict) GetConfigmapName() string {
 	return "tmp" + s.Name
 }
 
-// GetTemporaryNodepoolName returns the name of the temporary nodepool. AKS allows maximum 12 chars in the nodepool name
+// GetClusterConfigmapName returns the name of the ConfigMap which holds the state of a workload cluster
+func (s *SafeEvict) GetClusterConfigmapName(clusterName string) string {
+	return s.GetConfigmapName() + "-" + clusterName
+}
+
+// GetUsageConfigmapName returns the name of the ConfigMap which holds the usage history for the auto schedule
+func (s *SafeEvict) GetUsageConfigmapName() string {
+	return "usage" + s.Name
+}
+
+// GetBackupPoolNodeLabels returns the extra node labels of the temporary nodepool with their substitutions replaced
+func (s *SafeEvict) GetBackupPoolNodeLabels() map[string]string {
+	if s.Spec.BackupPool == nil || len(s.Spec.BackupPool.ExtraNodeLabels) == 0 {
+		return nil
+	}
+	replacer := s.backupPoolSubstitutions()
+	nodeLabels := make(map[string]string, len(s.Spec.BackupPool.ExtraNodeLabels))
+	for key, value := range s.Spec.BackupPool.ExtraNodeLabels {
+		nodeLabels[replacer.Replace(key)] = replacer.Replace(value)
+	}
+	return nodeLabels
+}
+
+// GetBackupPoolNodeTaints returns the extra node taints of the temporary nodepool with their substitutions replaced
+func (s *SafeEvict) GetBackupPoolNodeTaints() []string {
+	if s.Spec.BackupPool == nil || len(s.Spec.BackupPool.ExtraNodeTaints) == 0 {
+		return nil
+	}
+	replacer := s.backupPoolSubstitutions()
+	nodeTaints := make([]string, 0, len(s.Spec.BackupPool.ExtraNodeTaints))
+	for _, nodeTaint := range s.Spec.BackupPool.ExtraNodeTaints {
+		nodeTaints = append(nodeTaints, replacer.Replace(nodeTaint))
+	}
+	return nodeTaints
+}
+
+// backupPoolSubstitutions replaces the placeholders of the extra node labels and taints of the temporary nodepool
+func (s *SafeEvict) backupPoolSubstitutions() *strings.Replacer {
+	return strings.NewReplacer(
+		SubstitutionTemporaryPool, s.GetTemporaryNodepoolName(),
+		SubstitutionBasePool, s.Spec.BaseForBackupPool,
+		SubstitutionSafeEvict, s.Name,
+	)
+}
+
+// GetRightSizing returns the right-sizing of the temporary nodepool, nil when its node count is cloned from the base pool
+func (s *SafeEvict) GetRightSizing() *RightSizingSpec {
+	if s.Spec.BackupPool == nil {
+		return nil
+	}
+	return s.Spec.BackupPool.RightSizing
+}
+
+// GetHeadroomPercent returns the capacity added on top of the resource requests of the agent pods in percent
+func (r *RightSizingSpec) GetHeadroomPercent() int32 {
+	if r.HeadroomPercent == nil {
+		return DefaultHeadroomPercent
+	}
+	return *r.HeadroomPercent
+}
+
+// GetImageHistoryLimit returns the number of node image versions kept in the image history of a nodepool
+func (s *SafeEvict) GetImageHistoryLimit() int {
+	if s.Spec.ImageHistoryLimit == nil {
+		return DefaultImageHistoryLimit
+	}
+	return int(*s.Spec.ImageHistoryLimit)
+}
+
+// GetAutoRollback returns the thresholds of the automatic rollback, nil when it is disabled
+func (s *SafeEvict) GetAutoRollback() *AutoRollbackSpec {
+	if s.Spec.BackupPool == nil {
+		return nil
+	}
+	return s.Spec.BackupPool.AutoRollback
+}
+
+// GetMinReadyPercent returns the share of the agent pods on the upgraded nodepools which must be ready
+func (a *AutoRollbackSpec) GetMinReadyPercent() int32 {
+	if a.MinReadyPercent == nil {
+		return DefaultMinHealthyPercent
+	}
+	return *a.MinReadyPercent
+}
+
+// GetMinOnlinePercent returns the share of the agents on the upgraded nodepools which must be online
+func (a *AutoRollbackSpec) GetMinOnlinePercent() int32 {
+	if a.MinOnlinePercent == nil {
+		return DefaultMinHealthyPercent
+	}
+	return *a.MinOnlinePercent
+}
+
+// GetGracePeriod returns the time a new agent pod gets before it is counted
+func (a *AutoRollbackSpec) GetGracePeriod() time.Duration {
+	if a.GracePeriod == nil {
+		return DefaultRollbackGracePeriod
+	}
+	return a.GracePeriod.Duration
+}
+
+// GetBackupPoolVMSize returns the VM size of the temporary nodepool, empty when it is cloned from the base pool
+func (s *SafeEvict) GetBackupPoolVMSize() string {
+	if s.Spec.BackupPool == nil {
+		return ""
+	}
+	return s.Spec.BackupPool.VMSize
+}
+
+// GetEvictionOrder returns the order of the evictions, empty when the pods are evicted in the order they are listed
+func (s *SafeEvict) GetEvictionOrder() EvictionOrder {
+	if s.Spec.Eviction == nil {
+		return ""
+	}
+	return s.Spec.Eviction.Order
+}
+
+// GetPendingTimeout returns how long an agent pod may be unschedulable before the pending pod watchdog reacts
+func (s *SafeEvict) GetPendingTimeout() time.Duration {
+	if s.Spec.PendingPodWatchdog == nil || s.Spec.PendingPodWatchdog.PendingTimeout == nil {
+		return DefaultPendingTimeout
+	}
+	return s.Spec.PendingPodWatchdog.PendingTimeout.Duration
+}
+
+// GetClusterUsageConfigmapName returns the name of the ConfigMap which holds the usage history of a workload cluster
+func (s *SafeEvict) GetClusterUsageConfigmapName(clusterName string) string {
+	return s.GetUsageConfigmapName() + "-" + clusterName
+}
+
+// GetConfigmapPhase returns the phase of the cluster whose state is held by the ConfigMap, false is returned when the
+// ConfigMap does not belong to any cluster of the SafeEvict
+func (s *SafeEvict) GetConfigmapPhase(configmapName string) (Phase, bool) {
+	if configmapName == s.GetConfigmapName() {
+		return s.Status.Phase, true
+	}
+	for _, clusterStatus := range s.Status.Clusters {
+		if configmapName == s.GetClusterConfigmapName(clusterStatus.Name) {
+			return clusterStatus.Phase, true
+		}
+	}
+	return "", false
+}
+
+// GetOwnerTag returns the value used to tag Azure resources created for this SafeEvict
+func (s *SafeEvict) GetOwnerTag() string {
+	return s.Namespace + "/" + s.Name
+}
+
+// ApprovedRotation returns true when the upgrade of a rotation started at startTime is approved, i.e. approval is not
+// required or the approved annotation is not before the start of the rotation, so an old approval never approves a
+// new rotation. The start time is compared with the
+// precision of the status, which is stored in seconds.
+func (s *SafeEvict) ApprovedRotation(startTime *metav1.Time) bool {
+	if !s.Spec.RequireApproval {
+		return true
+	}
+	approvedAt, err := time.Parse(time.RFC3339, s.Annotations[ApprovedAnnotation])
+	if err != nil {
+		return false
+	}
+	// the start of a rotation begun by an earlier version of the controller is unknown
+	if startTime == nil {
+		return true
+	}
+	return !approvedAt.Before(startTime.Truncate(time.Second))
+}
+
+// SharesBackupPool returns true when the temporary nodepool is shared with the compatible SafeEvicts
+func (s *SafeEvict) SharesBackupPool() bool {
+	return s.Spec.BackupPool != nil && s.Spec.BackupPool.Shared
+}
+
+// GetBackupPoolRetention returns how long the drained temporary nodepool is kept after the upgrade, zero removes it
+// right away
+func (s *SafeEvict) GetBackupPoolRetention() time.Duration {
+	if s.Spec.BackupPool == nil || s.Spec.BackupPool.RetainFor == nil {
+		return 0
+	}
+	return s.Spec.BackupPool.RetainFor.Duration
+}
+
+// GetTemporaryNodepoolName returns the name of the temporary nodepool. AKS allows maximum 12 chars in the nodepool name,
+// so the name is built from the "tmp" prefix, the beginning of the base pool name and a hash of the UID of the SafeEvict.
+// The hash keeps the name stable across reconciles while two SafeEvicts with the same base pool get different names.
+// A shared temporary nodepool is hashed from its characteristics instead, so compatible SafeEvicts get the same name.
 func (s *SafeEvict) GetTemporaryNodepoolName() string {
-	if len(s.Spec.BaseForBackupPool) > 9 {
-		return "tmp" + s.Spec.BaseForBackupPool[:9]
+	key := string(s.UID)
+	if s.SharesBackupPool() {
+		key = s.sharedBackupPoolKey()
+	}
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])[:temporaryNodepoolHashLength]
+
+	base := s.Spec.BaseForBackupPool
+	maxBaseLength := MaxNodepoolNameLength - len(temporaryNodepoolPrefix) - temporaryNodepoolHashLength
+	if len(base) > maxBaseLength {
+		base = base[:maxBaseLength]
+	}
+	return temporaryNodepoolPrefix + base + hash
+}
+
+// GetLegacyTemporaryNodepoolName returns the name the controller gave the temporary nodepool before the names were
+// derived from the UID: the "tmp" prefix and the beginning of the base pool name. A rotation which created its
+// temporary nodepool under it keeps using it until the nodepool is removed.
+func (s *SafeEvict) GetLegacyTemporaryNodepoolName() string {
+	base := s.Spec.BaseForBackupPool
+	if maxBaseLength := MaxNodepoolNameLength - len(temporaryNodepoolPrefix); len(base) > maxBaseLength {
+		base = base[:maxBaseLength]
+	}
+	return temporaryNodepoolPrefix + base
+}
+
+// sharedBackupPoolKey describes the characteristics of a shared temporary nodepool: the base pool, the VM size, the
+// extra node labels and the extra node taints. $(SAFE_EVICT) is replaced in the labels and the taints, a SafeEvict
+// whose temporary nodepool is labeled with its own name does not share it with anyone.
+func (s *SafeEvict) sharedBackupPoolKey() string {
+	replacer := strings.NewReplacer(SubstitutionSafeEvict, s.Name)
+	backupPool := s.Spec.BackupPool
+	nodeLabels := make([]string, 0, len(backupPool.ExtraNodeLabels))
+	for key, value := range backupPool.ExtraNodeLabels {
+		nodeLabels = append(nodeLabels, replacer.Replace(key)+"="+replacer.Replace(value))
+	}
+	slices.Sort(nodeLabels)
+	nodeTaints := make([]string, 0, len(backupPool.ExtraNodeTaints))
+	for _, nodeTaint := range backupPool.ExtraNodeTaints {
+		nodeTaints = append(nodeTaints, replacer.Replace(nodeTaint))
 	}
-	return "tmp" + s.Spec.BaseForBackupPool
+	slices.Sort(nodeTaints)
+	return strings.Join([]string{
+		s.Spec.BaseForBackupPool,
+		backupPool.VMSize,
+		strings.Join(nodeLabels, ","),
+		strings.Join(nodeTaints, ","),
+	}, "|")
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,148 @@
+package v1
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGetTemporaryNodepoolName(t *testing.T) {
+	first := &SafeEvict{ObjectMeta: metav1.ObjectMeta{UID: "11111111-1111-1111-1111-111111111111"}, Spec: SafeEvictSpec{BaseForBackupPool: "agentpoolwithlongname"}}
+	second := &SafeEvict{ObjectMeta: metav1.ObjectMeta{UID: "22222222-2222-2222-2222-222222222222"}, Spec: SafeEvictSpec{BaseForBackupPool: "agentpoolwithlongname"}}
+
+	if len(first.GetTemporaryNodepoolName()) > MaxNodepoolNameLength {
+		t.Fatalf("Expected name not longer than %d chars, got '%s'", MaxNodepoolNameLength, first.GetTemporaryNodepoolName())
+	}
+	if first.GetTemporaryNodepoolName() != first.GetTemporaryNodepoolName() {
+		t.Fatalf("Expected deterministic temporary nodepool name")
+	}
+	if first.GetTemporaryNodepoolName() == second.GetTemporaryNodepoolName() {
+		t.Fatalf("Expected different temporary nodepool names, got '%s' for both", first.GetTemporaryNodepoolName())
+	}
+}
+
+func TestGetLegacyTemporaryNodepoolName(t *testing.T) {
+	tests := []struct {
+		base     string
+		expected string
+	}{
+		{base: "agentpool", expected: "tmpagentpool"},
+		{base: "agentpoolwithlongname", expected: "tmpagentpool"},
+		{base: "pool", expected: "tmppool"},
+	}
+	for _, tt := range tests {
+		safeEvict := &SafeEvict{Spec: SafeEvictSpec{BaseForBackupPool: tt.base}}
+		if name := safeEvict.GetLegacyTemporaryNodepoolName(); name != tt.expected {
+			t.Errorf("Expected legacy name '%s' for base pool '%s', got '%s'", tt.expected, tt.base, name)
+		}
+	}
+}
+
+func TestGetTemporaryNodepoolName_Shared(t *testing.T) {
+	sharedSafeEvict := func(uid, name string, backupPool BackupPoolSpec) *SafeEvict {
+		backupPool.Shared = true
+		return &SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(uid)}, Spec: SafeEvictSpec{BaseForBackupPool: "agentpool", BackupPool: &backupPool}}
+	}
+	first := sharedSafeEvict("11111111-1111-1111-1111-111111111111", "first", BackupPoolSpec{ExtraNodeLabels: map[string]string{"team": "ci", "pool": "$(TEMPORARY_POOL)"}})
+	second := sharedSafeEvict("22222222-2222-2222-2222-222222222222", "second", BackupPoolSpec{ExtraNodeLabels: map[string]string{"pool": "$(TEMPORARY_POOL)", "team": "ci"}})
+	if first.GetTemporaryNodepoolName() != second.GetTemporaryNodepoolName() {
+		t.Errorf("Expected compatible SafeEvicts to share the temporary nodepool, got '%s' and '%s'", first.GetTemporaryNodepoolName(), second.GetTemporaryNodepoolName())
+	}
+
+	incompatible := []*SafeEvict{
+		sharedSafeEvict("33333333-3333-3333-3333-333333333333", "third", BackupPoolSpec{ExtraNodeLabels: map[string]string{"team": "qa", "pool": "$(TEMPORARY_POOL)"}}),
+		sharedSafeEvict("44444444-4444-4444-4444-444444444444", "fourth", BackupPoolSpec{VMSize: "Standard_D8s_v5", ExtraNodeLabels: map[string]string{"team": "ci", "pool": "$(TEMPORARY_POOL)"}}),
+		sharedSafeEvict("55555555-5555-5555-5555-555555555555", "first", BackupPoolSpec{ExtraNodeLabels: map[string]string{"team": "ci"}}),
+	}
+	for _, other := range incompatible {
+		if other.GetTemporaryNodepoolName() == first.GetTemporaryNodepoolName() {
+			t.Errorf("Expected SafeEvict '%s' to get its own temporary nodepool, got '%s'", other.UID, other.GetTemporaryNodepoolName())
+		}
+	}
+
+	ownLabel := BackupPoolSpec{ExtraNodeLabels: map[string]string{"owner": "$(SAFE_EVICT)"}}
+	if sharedSafeEvict(string(first.UID), "first", ownLabel).GetTemporaryNodepoolName() == sharedSafeEvict(string(second.UID), "second", ownLabel).GetTemporaryNodepoolName() {
+		t.Errorf("Expected the temporary nodepools labeled with the name of their SafeEvict not to be shared")
+	}
+}
+
+func TestApprovedRotation(t *testing.T) {
+	startTime := metav1.NewTime(time.Date(2025, 3, 1, 10, 0, 0, 500, time.UTC))
+	tests := []struct {
+		name            string
+		requireApproval bool
+		approved        string
+		expected        bool
+	}{
+		{name: "approval not required", expected: true},
+		{name: "not approved", requireApproval: true, expected: false},
+		{name: "invalid approval", requireApproval: true, approved: "yes", expected: false},
+		{name: "approved before the rotation", requireApproval: true, approved: "2025-03-01T09:59:59Z", expected: false},
+		{name: "approved at the start of the rotation", requireApproval: true, approved: "2025-03-01T10:00:00Z", expected: true},
+		{name: "approved after the start of the rotation", requireApproval: true, approved: "2025-03-01T11:00:00+01:00", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			safeEvict := &SafeEvict{Spec: SafeEvictSpec{RequireApproval: tt.requireApproval}}
+			if tt.approved != "" {
+				safeEvict.Annotations = map[string]string{ApprovedAnnotation: tt.approved}
+			}
+			if approved := safeEvict.ApprovedRotation(&startTime); approved != tt.expected {
+				t.Errorf("Expected approved %v, got %v", tt.expected, approved)
+			}
+		})
+	}
+}
+
+func TestGetBackupPoolNodeTaints(t *testing.T) {
+	safeEvict := &SafeEvict{
+		ObjectMeta: metav1.ObjectMeta{Name: "agents", UID: "11111111-1111-1111-1111-111111111111"},
+		Spec: SafeEvictSpec{
+			BaseForBackupPool: "agentpool",
+			BackupPool:        &BackupPoolSpec{ExtraNodeTaints: []string{"pool=$(TEMPORARY_POOL):NoSchedule", "$(SAFE_EVICT)-of-$(BASE_POOL)=true:NoExecute"}},
+		},
+	}
+
+	nodeTaints := safeEvict.GetBackupPoolNodeTaints()
+
+	expected := []string{"pool=" + safeEvict.GetTemporaryNodepoolName() + ":NoSchedule", "agents-of-agentpool=true:NoExecute"}
+	if len(nodeTaints) != len(expected) || nodeTaints[0] != expected[0] || nodeTaints[1] != expected[1] {
+		t.Errorf("Expected taints %v, got %v", expected, nodeTaints)
+	}
+}
+
+func TestRecordImageVersion(t *testing.T) {
+	status := RotationStatus{Pools: []NodepoolStatus{{Name: "userpool", State: NodepoolStateSucceeded}}}
+	for _, version := range []string{"v1", "v2", "v2", "v3"} {
+		status.RecordImageVersion("userpool", ImageVersionRecord{Version: version, InitiatedBy: "ci/agents"}, 2)
+	}
+	status.RecordImageVersion("missing", ImageVersionRecord{Version: "v1"}, 2)
+
+	history := status.Pools[0].ImageHistory
+	if len(history) != 2 || history[0].Version != "v2" || history[1].Version != "v3" {
+		t.Errorf("Expected the two newest versions, got %+v", history)
+	}
+	if len(status.Pools) != 1 {
+		t.Errorf("Expected no status for a nodepool outside of the rotation, got %+v", status.Pools)
+	}
+
+	status.RecordImageVersion("userpool", ImageVersionRecord{Version: "v4"}, 0)
+	if status.Pools[0].ImageHistory != nil {
+		t.Errorf("Expected no history with a zero limit, got %+v", status.Pools[0].ImageHistory)
+	}
+}
+
+func TestResetPools_KeepsImageHistory(t *testing.T) {
+	status := RotationStatus{Pools: []NodepoolStatus{
+		{Name: "upgraded", State: NodepoolStateSucceeded, Conflicts: 2, ImageHistory: []ImageVersionRecord{{Version: "v1"}}},
+		{Name: "skipped", State: NodepoolStateSkipped, Message: "Removed from the plan of the rotation"},
+	}}
+
+	status.ResetPools()
+
+	if len(status.Pools) != 1 || status.Pools[0].Name != "upgraded" || status.Pools[0].Conflicts != 0 || len(status.Pools[0].ImageHistory) != 1 {
+		t.Errorf("Expected only the nodepool with an image history to be kept, got %+v", status.Pools)
+	}
+}
@@ -21,16 +21,525 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentPoolMapping) DeepCopyInto(out *AgentPoolMapping) {
+	*out = *in
+	if in.AzureDevOpsPools != nil {
+		in, out := &in.AzureDevOpsPools, &out.AzureDevOpsPools
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentPoolMapping.
+func (in *AgentPoolMapping) DeepCopy() *AgentPoolMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentPoolMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AutoRollbackSpec) DeepCopyInto(out *AutoRollbackSpec) {
+	*out = *in
+	if in.MinReadyPercent != nil {
+		in, out := &in.MinReadyPercent, &out.MinReadyPercent
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MinOnlinePercent != nil {
+		in, out := &in.MinOnlinePercent, &out.MinOnlinePercent
+		*out = new(int32)
+		**out = **in
+	}
+	if in.GracePeriod != nil {
+		in, out := &in.GracePeriod, &out.GracePeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoRollbackSpec.
+func (in *AutoRollbackSpec) DeepCopy() *AutoRollbackSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AutoRollbackSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureDevOpsConfig) DeepCopyInto(out *AzureDevOpsConfig) {
+	*out = *in
+	out.AccessTokenSecretRef = in.AccessTokenSecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureDevOpsConfig.
+func (in *AzureDevOpsConfig) DeepCopy() *AzureDevOpsConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureDevOpsConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupPoolSpec) DeepCopyInto(out *BackupPoolSpec) {
+	*out = *in
+	if in.ExtraNodeLabels != nil {
+		in, out := &in.ExtraNodeLabels, &out.ExtraNodeLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ExtraNodeTaints != nil {
+		in, out := &in.ExtraNodeTaints, &out.ExtraNodeTaints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RightSizing != nil {
+		in, out := &in.RightSizing, &out.RightSizing
+		*out = new(RightSizingSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RetainFor != nil {
+		in, out := &in.RetainFor, &out.RetainFor
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.AutoRollback != nil {
+		in, out := &in.AutoRollback, &out.AutoRollback
+		*out = new(AutoRollbackSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupPoolSpec.
+func (in *BackupPoolSpec) DeepCopy() *BackupPoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupPoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterStatus) DeepCopyInto(out *ClusterStatus) {
+	*out = *in
+	in.RotationStatus.DeepCopyInto(&out.RotationStatus)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterStatus.
+func (in *ClusterStatus) DeepCopy() *ClusterStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DrainSignal) DeepCopyInto(out *DrainSignal) {
+	*out = *in
+	if in.Exec != nil {
+		in, out := &in.Exec, &out.Exec
+		*out = new(corev1.ExecAction)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTPGet != nil {
+		in, out := &in.HTTPGet, &out.HTTPGet
+		*out = new(corev1.HTTPGetAction)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrainSignal.
+func (in *DrainSignal) DeepCopy() *DrainSignal {
+	if in == nil {
+		return nil
+	}
+	out := new(DrainSignal)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EvictionSpec) DeepCopyInto(out *EvictionSpec) {
+	*out = *in
+	if in.MinPodAge != nil {
+		in, out := &in.MinPodAge, &out.MinPodAge
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EvictionSpec.
+func (in *EvictionSpec) DeepCopy() *EvictionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EvictionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageVersionRecord) DeepCopyInto(out *ImageVersionRecord) {
+	*out = *in
+	in.UpgradedAt.DeepCopyInto(&out.UpgradedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageVersionRecord.
+func (in *ImageVersionRecord) DeepCopy() *ImageVersionRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageVersionRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeUpdaterConfig) DeepCopyInto(out *NodeUpdaterConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeUpdaterConfig.
+func (in *NodeUpdaterConfig) DeepCopy() *NodeUpdaterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeUpdaterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NodeUpdaterConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeUpdaterConfigList) DeepCopyInto(out *NodeUpdaterConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NodeUpdaterConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeUpdaterConfigList.
+func (in *NodeUpdaterConfigList) DeepCopy() *NodeUpdaterConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeUpdaterConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NodeUpdaterConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeUpdaterConfigSpec) DeepCopyInto(out *NodeUpdaterConfigSpec) {
+	*out = *in
+	if in.ErrorReconcileTime != nil {
+		in, out := &in.ErrorReconcileTime, &out.ErrorReconcileTime
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.SuccessReconcileTime != nil {
+		in, out := &in.SuccessReconcileTime, &out.SuccessReconcileTime
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.UpgradeFrequency != nil {
+		in, out := &in.UpgradeFrequency, &out.UpgradeFrequency
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.ProvisioningRequeueTimes != nil {
+		in, out := &in.ProvisioningRequeueTimes, &out.ProvisioningRequeueTimes
+		*out = make(map[string]metav1.Duration, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.AzureDevOps != nil {
+		in, out := &in.AzureDevOps, &out.AzureDevOps
+		*out = new(AzureDevOpsConfig)
+		**out = **in
+	}
+	if in.NotificationTemplates != nil {
+		in, out := &in.NotificationTemplates, &out.NotificationTemplates
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Notifications != nil {
+		in, out := &in.Notifications, &out.Notifications
+		*out = new(NotificationsConfig)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeUpdaterConfigSpec.
+func (in *NodeUpdaterConfigSpec) DeepCopy() *NodeUpdaterConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeUpdaterConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeUpdaterConfigStatus) DeepCopyInto(out *NodeUpdaterConfigStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeUpdaterConfigStatus.
+func (in *NodeUpdaterConfigStatus) DeepCopy() *NodeUpdaterConfigStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeUpdaterConfigStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodepoolError) DeepCopyInto(out *NodepoolError) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodepoolError.
+func (in *NodepoolError) DeepCopy() *NodepoolError {
+	if in == nil {
+		return nil
+	}
+	out := new(NodepoolError)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodepoolStatus) DeepCopyInto(out *NodepoolStatus) {
+	*out = *in
+	if in.LastError != nil {
+		in, out := &in.LastError, &out.LastError
+		*out = new(NodepoolError)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImageHistory != nil {
+		in, out := &in.ImageHistory, &out.ImageHistory
+		*out = make([]ImageVersionRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodepoolStatus.
+func (in *NodepoolStatus) DeepCopy() *NodepoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodepoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotificationsConfig) DeepCopyInto(out *NotificationsConfig) {
+	*out = *in
+	if in.PagerDutyRoutingKeySecretRef != nil {
+		in, out := &in.PagerDutyRoutingKeySecretRef, &out.PagerDutyRoutingKeySecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+	if in.OpsgenieAPIKeySecretRef != nil {
+		in, out := &in.OpsgenieAPIKeySecretRef, &out.OpsgenieAPIKeySecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotificationsConfig.
+func (in *NotificationsConfig) DeepCopy() *NotificationsConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NotificationsConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PendingPodWatchdogSpec) DeepCopyInto(out *PendingPodWatchdogSpec) {
+	*out = *in
+	if in.PendingTimeout != nil {
+		in, out := &in.PendingTimeout, &out.PendingTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MaxBackupPoolCount != nil {
+		in, out := &in.MaxBackupPoolCount, &out.MaxBackupPoolCount
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PendingPodWatchdogSpec.
+func (in *PendingPodWatchdogSpec) DeepCopy() *PendingPodWatchdogSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PendingPodWatchdogSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RightSizingSpec) DeepCopyInto(out *RightSizingSpec) {
+	*out = *in
+	if in.HeadroomPercent != nil {
+		in, out := &in.HeadroomPercent, &out.HeadroomPercent
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxCount != nil {
+		in, out := &in.MaxCount, &out.MaxCount
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RightSizingSpec.
+func (in *RightSizingSpec) DeepCopy() *RightSizingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RightSizingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RotationCounters) DeepCopyInto(out *RotationCounters) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RotationCounters.
+func (in *RotationCounters) DeepCopy() *RotationCounters {
+	if in == nil {
+		return nil
+	}
+	out := new(RotationCounters)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RotationStatus) DeepCopyInto(out *RotationStatus) {
+	*out = *in
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]NodepoolStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextCheckTime != nil {
+		in, out := &in.NextCheckTime, &out.NextCheckTime
+		*out = (*in).DeepCopy()
+	}
+	if in.TemporaryNodepoolRetainedUntil != nil {
+		in, out := &in.TemporaryNodepoolRetainedUntil, &out.TemporaryNodepoolRetainedUntil
+		*out = (*in).DeepCopy()
+	}
+	if in.OpenAlerts != nil {
+		in, out := &in.OpenAlerts, &out.OpenAlerts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	out.Counters = in.Counters
+	if in.AgentPools != nil {
+		in, out := &in.AgentPools, &out.AgentPools
+		*out = make([]AgentPoolMapping, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RotationStatus.
+func (in *RotationStatus) DeepCopy() *RotationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RotationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SafeEvict) DeepCopyInto(out *SafeEvict) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SafeEvict.
@@ -108,6 +617,61 @@ func (in *SafeEvictSpec) DeepCopyInto(out *SafeEvictSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ServiceAccountRef != nil {
+		in, out := &in.ServiceAccountRef, &out.ServiceAccountRef
+		*out = new(ServiceAccountReference)
+		**out = **in
+	}
+	if in.ClusterSelector != nil {
+		in, out := &in.ClusterSelector, &out.ClusterSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UpgradeTimeout != nil {
+		in, out := &in.UpgradeTimeout, &out.UpgradeTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MaxQueuedJobs != nil {
+		in, out := &in.MaxQueuedJobs, &out.MaxQueuedJobs
+		*out = new(int32)
+		**out = **in
+	}
+	if in.BackupPool != nil {
+		in, out := &in.BackupPool, &out.BackupPool
+		*out = new(BackupPoolSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DrainSignal != nil {
+		in, out := &in.DrainSignal, &out.DrainSignal
+		*out = new(DrainSignal)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Eviction != nil {
+		in, out := &in.Eviction, &out.Eviction
+		*out = new(EvictionSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MaxConcurrentPools != nil {
+		in, out := &in.MaxConcurrentPools, &out.MaxConcurrentPools
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PendingPodWatchdog != nil {
+		in, out := &in.PendingPodWatchdog, &out.PendingPodWatchdog
+		*out = new(PendingPodWatchdogSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BlockingPodSelector != nil {
+		in, out := &in.BlockingPodSelector, &out.BlockingPodSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImageHistoryLimit != nil {
+		in, out := &in.ImageHistoryLimit, &out.ImageHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SafeEvictSpec.
@@ -123,6 +687,14 @@ func (in *SafeEvictSpec) DeepCopy() *SafeEvictSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SafeEvictStatus) DeepCopyInto(out *SafeEvictStatus) {
 	*out = *in
+	in.RotationStatus.DeepCopyInto(&out.RotationStatus)
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = make([]ClusterStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SafeEvictStatus.
@@ -134,3 +706,33 @@ func (in *SafeEvictStatus) DeepCopy() *SafeEvictStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyReference) DeepCopyInto(out *SecretKeyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyReference.
+func (in *SecretKeyReference) DeepCopy() *SecretKeyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceAccountReference) DeepCopyInto(out *ServiceAccountReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceAccountReference.
+func (in *ServiceAccountReference) DeepCopy() *ServiceAccountReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceAccountReference)
+	in.DeepCopyInto(out)
+	return out
+}
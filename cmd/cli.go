@@ -0,0 +1,244 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/metrics"
+)
+
+// newRootCommand returns the node-updater command. Without a subcommand it runs the controller manager with the
+// flags of runManager, the subcommands are operational actions which use the kubeconfig of the user.
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:                "node-updater",
+		Short:              "Rotates the node images of AKS nodepools without interrupting the running agents",
+		Args:               cobra.ArbitraryArgs,
+		DisableFlagParsing: true,
+		SilenceUsage:       true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runManager(args)
+		},
+	}
+
+	kubeconfig := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(kubeconfig, overrides)
+	cli := &cliOptions{clientConfig: clientConfig}
+
+	commands := []*cobra.Command{newStatusCommand(cli), newTriggerCommand(cli), newAbortCommand(cli)}
+	for _, command := range commands {
+		flags := command.Flags()
+		flags.StringVar(&kubeconfig.ExplicitPath, "kubeconfig", "", "Path to the kubeconfig file, the default loading rules are used when it is empty.")
+		flags.StringVar(&overrides.CurrentContext, "context", "", "The kubeconfig context to use.")
+		flags.StringVarP(&overrides.Context.Namespace, "namespace", "n", "", "The namespace of the SafeEvicts, defaults to the namespace of the context.")
+	}
+	root.AddCommand(append(commands, newDashboardCommand())...)
+	return root
+}
+
+// cliOptions connects the subcommands to the cluster of the kubeconfig of the user
+type cliOptions struct {
+	clientConfig clientcmd.ClientConfig
+	// newClient is replaced in tests
+	newClient func() (client.Client, error)
+}
+
+func (o *cliOptions) client() (client.Client, string, error) {
+	namespace, _, err := o.clientConfig.Namespace()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get the namespace of the kubeconfig: %w", err)
+	}
+	if o.newClient != nil {
+		kubeClient, err := o.newClient()
+		return kubeClient, namespace, err
+	}
+	restConfig, err := o.clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load the kubeconfig: %w", err)
+	}
+	kubeClient, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create the client: %w", err)
+	}
+	return kubeClient, namespace, nil
+}
+
+func newStatusCommand(cli *cliOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status [name]",
+		Short: "Print the state of the rotations of the SafeEvicts",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeClient, namespace, err := cli.client()
+			if err != nil {
+				return err
+			}
+			return printStatus(cmd.Context(), kubeClient, namespace, args, cmd.OutOrStdout())
+		},
+	}
+}
+
+func newTriggerCommand(cli *cliOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "trigger <name>",
+		Short: "Check the nodepools of the SafeEvict now instead of waiting for the upgrade frequency",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeClient, namespace, err := cli.client()
+			if err != nil {
+				return err
+			}
+			err = annotateSafeEvict(cmd.Context(), kubeClient, client.ObjectKey{Namespace: namespace, Name: args[0]}, updatev1.CheckNowAnnotation, time.Now().UTC().Format(time.RFC3339))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "safeevict/%s check requested\n", args[0])
+			return nil
+		},
+	}
+}
+
+func newAbortCommand(cli *cliOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "abort <name>",
+		Short: "Stop the running rotation of the SafeEvict and roll it back",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeClient, namespace, err := cli.client()
+			if err != nil {
+				return err
+			}
+			err = annotateSafeEvict(cmd.Context(), kubeClient, client.ObjectKey{Namespace: namespace, Name: args[0]}, updatev1.AbortAnnotation, "true")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "safeevict/%s abort requested\n", args[0])
+			return nil
+		},
+	}
+}
+
+func newDashboardCommand() *cobra.Command {
+	var title string
+	command := &cobra.Command{
+		Use:   "dashboard",
+		Short: "Print the Grafana dashboard of the controller metrics",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out, err := json.MarshalIndent(metrics.NewDashboard(title), "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to marshal the dashboard: %w", err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return nil
+		},
+	}
+	command.Flags().StringVar(&title, "title", "Node updater", "The title of the dashboard.")
+	return command
+}
+
+// printStatus prints a line per rotation, i.e. per workload cluster of the SafeEvicts in management cluster mode
+func printStatus(ctx context.Context, kubeClient client.Client, namespace string, names []string, out io.Writer) error {
+	var safeEvicts []updatev1.SafeEvict
+	if len(names) == 0 {
+		list := &updatev1.SafeEvictList{}
+		if err := kubeClient.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			return fmt.Errorf("failed to list SafeEvicts: %w", err)
+		}
+		safeEvicts = list.Items
+	} else {
+		safeEvict := &updatev1.SafeEvict{}
+		if err := kubeClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: names[0]}, safeEvict); err != nil {
+			return fmt.Errorf("failed to get SafeEvict '%s': %w", names[0], err)
+		}
+		safeEvicts = append(safeEvicts, *safeEvict)
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tCLUSTER\tPHASE\tREADY\tSTARTED\tPOOLS")
+	for _, safeEvict := range safeEvicts {
+		ready := "Unknown"
+		if condition := meta.FindStatusCondition(safeEvict.Status.Conditions, updatev1.ConditionReady); condition != nil {
+			ready = string(condition.Status)
+		}
+		if safeEvict.Spec.ClusterSelector == nil {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", safeEvict.Name, "-", phaseOrNone(safeEvict.Status.Phase), ready, startTime(safeEvict.Status.RotationStatus), pools(safeEvict.Status.RotationStatus))
+			continue
+		}
+		for _, clusterStatus := range safeEvict.Status.Clusters {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", safeEvict.Name, clusterStatus.Name, phaseOrNone(clusterStatus.Phase), ready, startTime(clusterStatus.RotationStatus), pools(clusterStatus.RotationStatus))
+		}
+	}
+	return writer.Flush()
+}
+
+// annotateSafeEvict sets the annotation on the SafeEvict, the controller acts on it in its next reconcile
+func annotateSafeEvict(ctx context.Context, kubeClient client.Client, key client.ObjectKey, annotation, value string) error {
+	safeEvict := &updatev1.SafeEvict{}
+	if err := kubeClient.Get(ctx, key, safeEvict); err != nil {
+		return fmt.Errorf("failed to get SafeEvict '%s': %w", key.Name, err)
+	}
+	original := safeEvict.DeepCopy()
+	if safeEvict.Annotations == nil {
+		safeEvict.Annotations = make(map[string]string)
+	}
+	safeEvict.Annotations[annotation] = value
+	if err := kubeClient.Patch(ctx, safeEvict, client.MergeFrom(original)); err != nil {
+		return fmt.Errorf("failed to annotate SafeEvict '%s': %w", key.Name, err)
+	}
+	return nil
+}
+
+func phaseOrNone(phase updatev1.Phase) string {
+	if phase == "" {
+		return "-"
+	}
+	return string(phase)
+}
+
+func startTime(status updatev1.RotationStatus) string {
+	if status.StartTime == nil || !status.Phase.InProgress() {
+		return "-"
+	}
+	return status.StartTime.UTC().Format(time.RFC3339)
+}
+
+// pools lists the nodepools of the rotation with their state
+func pools(status updatev1.RotationStatus) string {
+	if len(status.Pools) == 0 {
+		return "-"
+	}
+	pools := make([]string, 0, len(status.Pools))
+	for _, pool := range status.Pools {
+		pools = append(pools, pool.Name+"="+string(pool.State))
+	}
+	return strings.Join(pools, ",")
+}
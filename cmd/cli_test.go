@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+func newFakeClient(objects ...client.Object) client.Client {
+	return crfake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
+
+func TestPrintStatus(t *testing.T) {
+	local := &updatev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: "local", Namespace: "default"}}
+	local.Status.Phase = updatev1.PhaseDraining
+	local.Status.StartTime = &metav1.Time{Time: metav1.Now().Time}
+	local.Status.SetNodepoolState("agentpool", updatev1.NodepoolStateInProgress, "")
+	remote := &updatev1.SafeEvict{
+		ObjectMeta: metav1.ObjectMeta{Name: "remote", Namespace: "default"},
+		Spec:       updatev1.SafeEvictSpec{ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"fleet": "ci"}}},
+		Status: updatev1.SafeEvictStatus{Clusters: []updatev1.ClusterStatus{
+			{Name: "first", RotationStatus: updatev1.RotationStatus{Phase: updatev1.PhaseDetecting}},
+			{Name: "second", RotationStatus: updatev1.RotationStatus{Phase: updatev1.PhaseFailed}},
+		}},
+	}
+	out := &bytes.Buffer{}
+
+	if err := printStatus(context.Background(), newFakeClient(local, remote), "default", nil, out); err != nil {
+		t.Fatalf("printStatus returned error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected a header and a line per rotation, got:\n%s", out.String())
+	}
+	if !strings.Contains(lines[1], "Draining") || !strings.Contains(lines[1], "agentpool=InProgress") {
+		t.Errorf("Expected the rotation of the local cluster, got '%s'", lines[1])
+	}
+	if !strings.Contains(lines[3], "second") || !strings.Contains(lines[3], "Failed") {
+		t.Errorf("Expected the rotation of the second workload cluster, got '%s'", lines[3])
+	}
+}
+
+func TestAnnotateSafeEvict(t *testing.T) {
+	safeEvict := &updatev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: "rotation", Namespace: "default", Annotations: map[string]string{"keep": "me"}}}
+	kubeClient := newFakeClient(safeEvict)
+	key := client.ObjectKeyFromObject(safeEvict)
+
+	if err := annotateSafeEvict(context.Background(), kubeClient, key, updatev1.AbortAnnotation, "true"); err != nil {
+		t.Fatalf("annotateSafeEvict returned error: %v", err)
+	}
+
+	annotated := &updatev1.SafeEvict{}
+	if err := kubeClient.Get(context.Background(), key, annotated); err != nil {
+		t.Fatalf("failed to get SafeEvict: %v", err)
+	}
+	if annotated.Annotations[updatev1.AbortAnnotation] != "true" || annotated.Annotations["keep"] != "me" {
+		t.Errorf("Expected the abort annotation next to the existing ones, got %v", annotated.Annotations)
+	}
+	if err := annotateSafeEvict(context.Background(), kubeClient, client.ObjectKey{Namespace: "default", Name: "missing"}, updatev1.AbortAnnotation, "true"); err == nil {
+		t.Error("Expected an error for a missing SafeEvict")
+	}
+}
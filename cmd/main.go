@@ -17,19 +17,27 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	uberzap "go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -41,7 +49,6 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
-	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -49,18 +56,36 @@ import (
 
 	updatev1 "norbinto/node-updater/api/v1"
 	"norbinto/node-updater/internal/appconfig"
+	"norbinto/node-updater/internal/audit"
 	"norbinto/node-updater/internal/azure"
 	"norbinto/node-updater/internal/azuredevops"
+	"norbinto/node-updater/internal/chaos"
+	"norbinto/node-updater/internal/cluster"
 	configmap "norbinto/node-updater/internal/configmap" // Import the configmap package
 	"norbinto/node-updater/internal/controller"
+	"norbinto/node-updater/internal/egress"
+	"norbinto/node-updater/internal/health"
+	"norbinto/node-updater/internal/identity"
+	"norbinto/node-updater/internal/impersonation"
 	"norbinto/node-updater/internal/job"
+	"norbinto/node-updater/internal/logging"
+	"norbinto/node-updater/internal/metrics"
 	nodepool "norbinto/node-updater/internal/nodepool"
 	pod "norbinto/node-updater/internal/pod" // Import the pod package
+	"norbinto/node-updater/internal/releasefeed"
+	"norbinto/node-updater/internal/selfexclusion"
+	webhookupdatev1 "norbinto/node-updater/internal/webhook/v1"
+	"norbinto/node-updater/pkg/notify"
+	"norbinto/node-updater/pkg/plan"
 
 	"github.com/go-logr/zapr"
 	// +kubebuilder:scaffold:imports
 )
 
+// configFileInterval is the time between two reads of the configuration file, the kubelet updates a mounted ConfigMap
+// about once a minute
+const configFileInterval = 10 * time.Second
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -73,8 +98,15 @@ func init() {
 	// +kubebuilder:scaffold:scheme
 }
 
-// nolint:gocyclo
 func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// runManager runs the controller manager, args are its command line flags
+// nolint:gocyclo
+func runManager(args []string) error {
 	var metricsAddr string
 	var metricsCertPath, metricsCertName, metricsCertKey string
 	var webhookCertPath, webhookCertName, webhookCertKey string
@@ -85,8 +117,32 @@ func main() {
 	var tlsOpts []func(*tls.Config)
 	var errorReconcileTime int
 	var successReconcileTime int
+	var provisioningRequeueTimes string
 	var upgradeFrequency int
 	var runInVsCode bool
+	var livenessReconcileMultiplier int
+	var azureDevopsReadinessCheck bool
+	var shutdownDrainBudget int
+	var reconcileTimeout int
+	var maxConcurrentReconciles int
+	var stateNamespace string
+	var jobPropagationPolicy string
+	var provisioningPollInterval, provisioningTimeout int
+	var tagOperations bool
+	var workloadClusterControllerCredential bool
+	var caBundlePath string
+	var httpConnectTimeout, httpTLSHandshakeTimeout, httpResponseHeaderTimeout, httpRequestTimeout int
+	var subscriptionID, clusterResourceGroup, clusterName string
+	var chaosFailureRate, chaosDelayRate float64
+	var releaseFeedURL string
+	var releaseFeedInterval int
+	var planWebhookURL string
+	var notificationWebhookURL string
+	var opsgenieURL string
+	var smtpConfigDir string
+	var auditLog, auditConfigMap, auditWebhookURL string
+	var auditConfigMapSize int
+	var configFile string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -106,12 +162,96 @@ func main() {
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
 	flag.IntVar(&errorReconcileTime, "error-reconcile-time", 10, "Default value is 10 seconds. The time to wait before retrying a failed reconcile.")
 	flag.IntVar(&successReconcileTime, "success-reconcile-time", 10, "Default value is 10 seconds. The time to wait before retrying a successful reconcile.")
+	flag.StringVar(&provisioningRequeueTimes, "provisioning-requeue-times", "Creating=1m,Updating=1m,Scaling=1m,UpgradingNodeImageVersion=2m,Deleting=1m",
+		"The time to wait before the next reconcile while ARM provisions a nodepool, by its provisioning state as state=duration pairs. "+
+			"The other states wait --success-reconcile-time.")
 	flag.IntVar(&upgradeFrequency, "upgrade-frequency", 3600, "Default value is 3600 seconds(1 hour). The time to wait before checking for a new version.")
 	flag.BoolVar(&runInVsCode, "run-in-vs-code", false, "If set, the controller will run in VS Code.")
+	flag.StringVar(&subscriptionID, "subscription-id", os.Getenv("AZURE_SUBSCRIPTION_ID"),
+		"The subscription of the AKS cluster. Together with --resource-group and --cluster-name it bypasses the discovery "+
+			"from the instance metadata service and the tags of the node resource group. Defaults to AZURE_SUBSCRIPTION_ID.")
+	flag.StringVar(&clusterResourceGroup, "resource-group", os.Getenv("AZURE_CLUSTER_RESOURCE_GROUP"),
+		"The resource group of the AKS cluster. Defaults to AZURE_CLUSTER_RESOURCE_GROUP.")
+	flag.StringVar(&clusterName, "cluster-name", os.Getenv("AZURE_CLUSTER_NAME"),
+		"The name of the AKS cluster. Defaults to AZURE_CLUSTER_NAME.")
+	flag.IntVar(&shutdownDrainBudget, "shutdown-drain-budget", 30, "Default value is 30 seconds. The time an eviction which is in progress gets to finish or roll back when the manager is shutting down.")
+	flag.StringVar(&stateNamespace, "state-namespace", controllerNamespace(), "The namespace of the ConfigMaps which hold the state of the rotations. "+
+		"Defaults to the namespace of the controller, an empty value keeps the state next to its SafeEvict.")
+	flag.StringVar(&jobPropagationPolicy, "job-propagation-policy", "Background", "The propagation policy of the deletion of "+
+		"the job of an evicted agent pod. Background and Foreground delete the pod with its job, Orphan keeps the pod and deletes it on its own.")
+	flag.IntVar(&provisioningPollInterval, "provisioning-poll-interval", 10, "Default value is 10 seconds. The time between two checks of the provisioning state of a nodepool which is waited for.")
+	flag.IntVar(&provisioningTimeout, "provisioning-timeout", 0, "Default value is 0 (do not wait). The time in seconds a reconcile waits for a nodepool to finish an update of its scaling. "+
+		"Without waiting the next reconcile checks the provisioning state.")
+	flag.StringVar(&caBundlePath, "ca-bundle", "", "The path of a PEM file whose certificates are trusted by the calls to Azure, Azure DevOps and the webhooks "+
+		"on top of the system certificates, e.g. the CA of a TLS-inspecting proxy. The proxy itself is configured with HTTPS_PROXY and NO_PROXY.")
+	flag.IntVar(&httpConnectTimeout, "http-connect-timeout", int(egress.DefaultTimeouts.Connect/time.Second), "Default value is 10 seconds, 0 disables it. "+
+		"The time an outbound call to ARM, the instance metadata service, Azure DevOps or a webhook may take to connect.")
+	flag.IntVar(&httpTLSHandshakeTimeout, "http-tls-handshake-timeout", int(egress.DefaultTimeouts.TLSHandshake/time.Second), "Default value is 10 seconds, 0 disables it. "+
+		"The time the TLS handshake of an outbound call may take.")
+	flag.IntVar(&httpResponseHeaderTimeout, "http-response-header-timeout", int(egress.DefaultTimeouts.ResponseHeader/time.Second), "Default value is 30 seconds, 0 disables it. "+
+		"The time the server of an outbound call may take to answer with its headers.")
+	flag.IntVar(&httpRequestTimeout, "http-request-timeout", int(egress.DefaultTimeouts.Request/time.Second), "Default value is 30 seconds, 0 disables it. "+
+		"The time a whole outbound call may take, including the response body, so a hung endpoint cannot stall a reconcile.")
+	flag.IntVar(&reconcileTimeout, "reconcile-timeout", 120, "Default value is 120 seconds, 0 disables it. "+
+		"The time a reconcile of a SafeEvict may take, a reconcile which runs out of it is requeued.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "Default value is 1. The number of SafeEvicts reconciled at the same time. "+
+		"A SafeEvict annotated with check-now or abort is reconciled before the periodic reconciles of the others.")
+	flag.BoolVar(&tagOperations, "tag-operations", false, "If set, every nodepool the controller updates is tagged with the operation, "+
+		"its time and the correlation ID of the reconcile, so the activity log tells the changes of the controller apart from manual ones.")
+	flag.BoolVar(&workloadClusterControllerCredential, "workload-cluster-controller-credential", false, "If set, the workload clusters whose kubeconfig Secret "+
+		"has no client-id and tenant-id annotations are managed with the controller's own Azure credential. Anyone who can write a Secret in the namespace "+
+		"of a SafeEvict can then point that identity at any AKS cluster it can reach.")
+	flag.IntVar(&livenessReconcileMultiplier, "liveness-reconcile-multiplier", 3, "Default value is 3. The liveness probe fails when no reconcile finished within this many times the longest reconcile period.")
+	flag.BoolVar(&azureDevopsReadinessCheck, "azure-devops-readiness-check", true, "Default value is true. If set, the pod is not ready while the Azure DevOps API "+
+		"is not reachable. The readiness also admits the pod to the endpoints of the webhooks, disable it to keep them serving during an Azure DevOps outage.")
+	flag.Float64Var(&chaosFailureRate, "chaos-failure-rate", 0, "Default value is 0 (disabled). Only for soak tests in staging clusters. "+
+		"The probability of failing an ARM or Azure DevOps call with a 429, a 409 or a timeout.")
+	flag.Float64Var(&chaosDelayRate, "chaos-delay-rate", 0, "Default value is 0 (disabled). Only for soak tests in staging clusters. "+
+		"The probability of reporting a Succeeded provisioning state as Updating.")
+
+	flag.StringVar(&configFile, "config-file", "", "The path of a YAML file, e.g. a key of a mounted ConfigMap, whose errorReconcileTime, "+
+		"successReconcileTime, upgradeFrequency and provisioningRequeueTimes (Go durations) override the flags and whose logLevels set the log level of "+
+		"the components. The file is reloaded when it changes.")
+
+	flag.StringVar(&releaseFeedURL, "release-feed-url", releasefeed.DefaultFeedURL, "The GitHub releases API of AKS, polled for node images with CVE fixes.")
+	flag.IntVar(&releaseFeedInterval, "release-feed-interval", 0, "Default value is 0 (disabled). The time in seconds between two polls of the AKS release feed. "+
+		"A release which fixes CVEs in a node image checks the nodepools of every SafeEvict right away.")
 
 	// todo: like in keda we should use strings instead of numbers for log levels
 	var logLevel int
-	flag.IntVar(&logLevel, "log-level", 1, "The log level for the controller. 0=debug, 1=info, 2=warn, 3=error")
+	var logDedupeWindow int
+	flag.StringVar(&planWebhookURL, "plan-webhook-url", "", "The URL the plans of the rotations are posted to before they start. "+
+		"The webhook can veto a plan or remove nodepools from it.")
+	flag.StringVar(&notificationWebhookURL, "notification-webhook-url", "", "The URL the notifications about the rotations are posted to, "+
+		"e.g. when a rotation moved the workload back onto the retained temporary nodepool. The notifications of the NodeUpdaterConfig override it.")
+	flag.StringVar(&opsgenieURL, "opsgenie-url", notify.OpsgenieURL, "The Opsgenie API the alerts are opened with when OPSGENIE_API_KEY is set, "+
+		"e.g. https://api.eu.opsgenie.com for the EU instance.")
+	flag.StringVar(&smtpConfigDir, "smtp-config-dir", "", "The directory the Secret with the SMTP server, credentials and recipients "+
+		"of the email notifications is mounted at, no email is sent when it is empty or not mounted.")
+	flag.StringVar(&auditLog, "audit-log", "", "The file the audit records of the mutating actions are appended to as JSON lines, "+
+		"- writes them to the standard output. Empty disables it.")
+	flag.StringVar(&auditConfigMap, "audit-configmap", "", "The ConfigMap in the namespace of the controller which keeps the latest audit "+
+		"records of the mutating actions. Empty disables it.")
+	flag.IntVar(&auditConfigMapSize, "audit-configmap-size", 500, "Default value is 500. The number of audit records --audit-configmap keeps, "+
+		"the oldest ones are dropped.")
+	flag.StringVar(&auditWebhookURL, "audit-webhook-url", "", "The URL every audit record of the mutating actions is posted to as JSON.")
+	flag.IntVar(&logLevel, "log-level", 1, "The default log level for the controller. 0=debug, 1=info, 2=warn, 3=error. "+
+		"The level of a component (e.g. nodepool, pod, azureDevOps, safeEvict) can be changed at runtime with logLevels in --config-file "+
+		"or with PUT /debug/loglevel of the metrics server.")
+	flag.IntVar(&logDedupeWindow, "log-dedupe-window", 600, "Default value is 600 seconds, 0 disables it. An info or debug entry which repeats "+
+		"an entry of the same logger with the same message and fields within this time is dropped, the next one written counts the dropped repeats.")
+
+	opts := zap.Options{
+		Development: true,
+	}
+
+	// Create a context for the application
+	opts.BindFlags(flag.CommandLine)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+
+	// --zap-log-level takes precedence over --log-level
 	var zapLevel zapcore.Level
 	switch logLevel {
 	case 0:
@@ -125,15 +265,18 @@ func main() {
 	default:
 		zapLevel = zapcore.InfoLevel
 	}
-
-	opts := zap.Options{
-		Development: true,
-		Level:       zapLevel,
+	if opts.Level != nil {
+		zapLevel = zapcore.LevelOf(opts.Level)
 	}
-
-	// Create a context for the application
-	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
+	// every component logs at the default level until its level is changed at runtime
+	logLevels := logging.NewLevels(zapLevel)
+	opts.Level = logLevels
+	if logDedupeWindow > 0 {
+		// the entries of two reconciles only differ by their reconcile ID
+		deduper := logging.NewDeduper(time.Duration(logDedupeWindow)*time.Second, "reconcileID")
+		opts.ZapOpts = append(opts.ZapOpts, uberzap.WrapCore(deduper.Core))
+	}
+	opts.ZapOpts = append(opts.ZapOpts, uberzap.WrapCore(logLevels.Core))
 
 	config := appconfig.NewConfig(time.Duration(errorReconcileTime)*time.Second, time.Duration(successReconcileTime)*time.Second, time.Duration(upgradeFrequency)*time.Second)
 
@@ -142,6 +285,16 @@ func main() {
 	//create a logger
 	ctrl.SetLogger(zapr.NewLogger(logger))
 
+	requeueTimes, err := appconfig.ParseProvisioningRequeueTimes(provisioningRequeueTimes)
+	if err != nil {
+		setupLog.Error(err, "invalid --provisioning-requeue-times", "provisioningRequeueTimes", provisioningRequeueTimes)
+		os.Exit(1)
+	}
+	*config = config.WithProvisioningRequeueTimes(requeueTimes)
+	// the configuration file replaces the configuration of the flags at runtime, the NodeUpdaterConfig overrides both
+	defaultsStore := appconfig.NewStore(config)
+	configStore := appconfig.NewStore(config)
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -194,6 +347,7 @@ func main() {
 		BindAddress:   metricsAddr,
 		SecureServing: secureMetrics,
 		TLSOpts:       tlsOpts,
+		ExtraHandlers: map[string]http.Handler{"/debug/loglevel": logLevels},
 	}
 
 	if secureMetrics {
@@ -231,13 +385,16 @@ func main() {
 		})
 	}
 
+	// the manager waits for the running reconciles to drain their in-progress eviction before it stops
+	gracefulShutdownTimeout := time.Duration(shutdownDrainBudget)*time.Second + 10*time.Second
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		Metrics:                metricsServerOptions,
-		WebhookServer:          webhookServer,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "a3a1ffc7.norbinto",
+		Scheme:                  scheme,
+		GracefulShutdownTimeout: &gracefulShutdownTimeout,
+		Metrics:                 metricsServerOptions,
+		WebhookServer:           webhookServer,
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        "a3a1ffc7.norbinto",
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -255,9 +412,40 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the outbound calls honour HTTPS_PROXY and NO_PROXY and trust the CA of a TLS-inspecting proxy, the manifests mount
+	// it from the optional node-updater-ca Secret
+	timeouts := egress.Timeouts{
+		Connect:        time.Duration(httpConnectTimeout) * time.Second,
+		TLSHandshake:   time.Duration(httpTLSHandshakeTimeout) * time.Second,
+		ResponseHeader: time.Duration(httpResponseHeaderTimeout) * time.Second,
+		Request:        time.Duration(httpRequestTimeout) * time.Second,
+	}
+	transport, err := egress.NewTransport(caBundlePath, timeouts)
+	if errors.Is(err, fs.ErrNotExist) {
+		setupLog.Info("CA bundle is not mounted, only the system certificates are trusted", "caBundle", caBundlePath)
+		transport, err = egress.NewTransport("", timeouts)
+	}
+	if err != nil {
+		setupLog.Error(err, "unable to create the transport of the outbound calls")
+		os.Exit(1)
+	}
+	egressClient := timeouts.NewClient(transport)
+	credentialOptions := azcore.ClientOptions{Transport: egressClient}
+
+	// chaos mode injects failures into the ARM and Azure DevOps calls to soak-test the reconciler
+	var httpClient egress.Doer = egressClient
+	// the throttled ARM requests are counted for the NodeUpdaterARMThrottling alert, every ARM request for the summary
+	// of its reconcile. The requests carry the User-Agent of the controller and the correlation ID of their reconcile,
+	// the discovery of the cluster uses the same options.
+	armOptions := &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+		PerCallPolicies:  []policy.Policy{identity.CorrelationPolicy{}},
+		PerRetryPolicies: []policy.Policy{metrics.ThrottlingPolicy{}, metrics.ARMCallPolicy{}},
+		Telemetry:        policy.TelemetryOptions{ApplicationID: identity.UserAgent()},
+		Transport:        httpClient,
+	}}
+
 	var kubeConfig *rest.Config
 	var azureCred azcore.TokenCredential
-	var subscriptionID, clusterResourceGroup, clusterName string
 	if runInVsCode {
 		kubeconfigPath := os.Getenv("KUBECONFIG")
 		if kubeconfigPath == "" {
@@ -268,29 +456,21 @@ func main() {
 			setupLog.Error(err, "unable to build kubeconfig from flags")
 			os.Exit(1)
 		}
-		azureCred, err = azidentity.NewDefaultAzureCredential(nil)
+		azureCred, err = azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: credentialOptions})
 		if err != nil {
 			setupLog.Error(err, "unable to create Azure credentials")
 			os.Exit(1)
 		}
-		subscriptionID = os.Getenv("AZURE_SUBSCRIPTION_ID")
-		clusterResourceGroup = os.Getenv("AZURE_CLUSTER_RESOURCE_GROUP")
-		clusterName = os.Getenv("AZURE_CLUSTER_NAME")
 		setupLog.Info("Running in VS Code mode", "subscriptionID", subscriptionID, "clusterResourceGroup", clusterResourceGroup, "clusterName", clusterName)
 	} else {
-		//todo pass doers interface instead of https client
-		azureController := azure.NewAzureController(&http.Client{}, logger.Named("azure"))
-		subscriptionID, clusterResourceGroup, clusterName, err = azureController.GetClusterInfo()
-		if err != nil {
-			setupLog.Error(err, "unable to get subsription id")
-			os.Exit(1)
-		}
-		kubeConfig, err = rest.InClusterConfig()
+		// falls back to the in-cluster config unless --kubeconfig or KUBECONFIG points outside of the cluster
+		kubeConfig, err = ctrl.GetConfig()
 		if err != nil {
-			setupLog.Error(err, "unable to build in-cluster kubeconfig")
+			setupLog.Error(err, "unable to build kubeconfig")
 			os.Exit(1)
 		}
 		credOptions := azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: credentialOptions,
 			TokenFilePath: os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
 			ClientID:      os.Getenv("AZURE_CLIENT_ID"),
 			TenantID:      os.Getenv("AZURE_TENANT_ID"),
@@ -302,6 +482,17 @@ func main() {
 			os.Exit(1)
 		}
 		setupLog.Info("Using Managed Identity (workload identity) federated credentials for authentication")
+
+		if subscriptionID != "" && clusterResourceGroup != "" && clusterName != "" {
+			setupLog.Info("Using the configured cluster, skipping discovery", "subscriptionID", subscriptionID, "clusterResourceGroup", clusterResourceGroup, "clusterName", clusterName)
+		} else {
+			var azureController azure.AzureControllerInterface = azure.NewAzureController(egressClient, azureCred, armOptions, logger.Named("azure"))
+			subscriptionID, clusterResourceGroup, clusterName, err = azureController.GetClusterInfo(context.Background())
+			if err != nil {
+				setupLog.Error(err, "unable to discover the cluster")
+				os.Exit(1)
+			}
+		}
 	}
 
 	// Initialize KubeClient
@@ -311,38 +502,222 @@ func main() {
 		os.Exit(1)
 	}
 
-	agentPoolClient, err := armcontainerservice.NewAgentPoolsClient(subscriptionID, azureCred, nil)
+	propagationPolicy, err := job.ParsePropagationPolicy(jobPropagationPolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid job propagation policy")
+		os.Exit(1)
+	}
+	if chaosFailureRate > 0 || chaosDelayRate > 0 {
+		if chaosFailureRate > 1 || chaosDelayRate > 1 || chaosFailureRate < 0 || chaosDelayRate < 0 {
+			setupLog.Error(errors.New("chaos rates must be between 0 and 1"), "invalid chaos configuration")
+			os.Exit(1)
+		}
+		setupLog.Info("Chaos mode is enabled, ARM and Azure DevOps calls will fail on purpose", "failureRate", chaosFailureRate, "delayRate", chaosDelayRate)
+		httpClient = chaos.NewTransport(httpClient, chaosFailureRate, chaosDelayRate, logger.Named("chaos"))
+		armOptions.Transport = httpClient
+	}
+
+	agentPoolClient, err := armcontainerservice.NewAgentPoolsClient(subscriptionID, azureCred, armOptions)
 	if err != nil {
 		setupLog.Error(err, "unable to create container service client")
 		os.Exit(1)
 	}
+	managedClusterClient, err := armcontainerservice.NewManagedClustersClient(subscriptionID, azureCred, armOptions)
+	if err != nil {
+		setupLog.Error(err, "unable to create managed cluster client")
+		os.Exit(1)
+	}
+	scaleSetVMsClient, err := armcompute.NewVirtualMachineScaleSetVMsClient(subscriptionID, azureCred, armOptions)
+	if err != nil {
+		setupLog.Error(err, "unable to create scale set VMs client")
+		os.Exit(1)
+	}
+	// Azure DevOps integration is optional, without it idle pods are evicted without deregistering agents. The
+	// NodeUpdaterConfig can configure it later, so the controllers get a client whose credentials can be replaced.
+	azureDevopsCredentials := azuredevops.Credentials{
+		ServerURL:        os.Getenv("AZURE_DEVOPS_URL"),
+		OrganizationName: os.Getenv("AZURE_DEVOPS_ORG"),
+		AccessToken:      os.Getenv("AZURE_DEVOPS_PAT"),
+	}
+	if !azureDevopsCredentials.IsComplete() {
+		setupLog.Info("AZURE_DEVOPS_ORG or AZURE_DEVOPS_PAT is not set, Azure DevOps integration is disabled unless the NodeUpdaterConfig configures it")
+	}
+	azureDevopsController := azuredevops.NewReloadableController(identity.NewUserAgentTransport(httpClient), azureDevopsCredentials, logger.Named("azureDevOps"))
+
+	livenessThreshold := time.Duration(livenessReconcileMultiplier) * max(config.UpgradeFrequency, config.SuccessReconcileTime, config.ErrorReconcileTime)
+	healthChecker := health.NewHealthChecker(mgr.GetClient(), azureCred, livenessThreshold, logger.Named("health")).
+		WithElected(mgr.Elected()).
+		WithAzureDevopsController(azureDevopsController)
+
+	// a release with security fixes requests the check of every SafeEvict with the check-now annotation
+	if releaseFeedInterval > 0 {
+		poller := releasefeed.NewPoller(timeouts.NewClient(transport), releaseFeedURL,
+			time.Duration(releaseFeedInterval)*time.Second, mgr.GetClient(), logger.Named("releaseFeed"))
+		if err = mgr.Add(poller); err != nil {
+			setupLog.Error(err, "unable to add the release feed poller")
+			os.Exit(1)
+		}
+	}
+
+	// the reviewers compiled into the binary review the plans first, the webhook gets the nodepools they kept
+	var planReviewer plan.Reviewer
+	planReviewers := plan.Registered()
+	if planWebhookURL != "" {
+		planReviewers = append(planReviewers, plan.NewWebhookReviewer(timeouts.NewClient(transport), planWebhookURL))
+	}
+	if len(planReviewers) > 0 {
+		planReviewer = plan.Reviewers(planReviewers)
+	}
+	// the webhook and the alerting services are reconfigured by the NodeUpdaterConfig, the notifiers compiled into the
+	// binary and the email are not
+	notifiers := notify.Registered()
+	notificationSinks := notify.Sinks{
+		WebhookURL:          notificationWebhookURL,
+		PagerDutyRoutingKey: os.Getenv("PAGERDUTY_ROUTING_KEY"),
+		OpsgenieAPIKey:      os.Getenv("OPSGENIE_API_KEY"),
+		OpsgenieURL:         opsgenieURL,
+	}
+	if smtpConfigDir != "" {
+		smtpConfig, err := notify.LoadSMTPConfig(smtpConfigDir)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			setupLog.Info("SMTP Secret is not mounted, no email notification is sent", "smtpConfigDir", smtpConfigDir)
+		case err != nil:
+			setupLog.Error(err, "unable to load the SMTP configuration", "smtpConfigDir", smtpConfigDir)
+			os.Exit(1)
+		default:
+			notifiers = append(notifiers, notify.NewEmailNotifier(smtpConfig).WithTLSConfig(transport.TLSClientConfig).WithTimeout(timeouts.Request))
+		}
+	}
+	notifier := notify.NewReloadableNotifier(timeouts.NewClient(transport), notifiers, notificationSinks)
+
+	// every mutating action of the reconciles is recorded in the audit sinks for the compliance reviews
+	var auditor *audit.Auditor
+	var auditSinks []audit.Sink
+	switch auditLog {
+	case "":
+	case "-":
+		auditSinks = append(auditSinks, audit.NewStreamSink(os.Stdout))
+	default:
+		auditFile, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			setupLog.Error(err, "unable to open the audit log", "auditLog", auditLog)
+			os.Exit(1)
+		}
+		defer auditFile.Close()
+		auditSinks = append(auditSinks, audit.NewStreamSink(auditFile))
+	}
+	if auditConfigMap != "" {
+		if auditConfigMapSize < 1 {
+			setupLog.Error(errors.New("the audit ConfigMap must keep at least one record"), "invalid --audit-configmap-size", "auditConfigMapSize", auditConfigMapSize)
+			os.Exit(1)
+		}
+		if namespace := controllerNamespace(); namespace != "" {
+			auditSinks = append(auditSinks, audit.NewConfigMapSink(kubeClient, namespace, auditConfigMap, auditConfigMapSize))
+		} else {
+			setupLog.Info("Controller runs outside of a cluster, the audit records are not kept in a ConfigMap", "auditConfigMap", auditConfigMap)
+		}
+	}
+	if auditWebhookURL != "" {
+		auditSinks = append(auditSinks, audit.NewHTTPSink(timeouts.NewClient(transport), auditWebhookURL))
+	}
+	if len(auditSinks) > 0 {
+		auditor = audit.NewAuditor(logger.Named("audit"), auditSinks...)
+	}
+
+	// the drain signals of the local and the workload clusters are sent with the timeouts of the outbound calls
+	drainSignalClient := timeouts.NewClient(nil)
 	if err = (&controller.SafeEvictReconciler{
 		Client:     mgr.GetClient(),
 		Scheme:     mgr.GetScheme(),
 		KubeClient: kubeClient,
 		PodController: pod.NewPodController(
 			kubeClient,
-			azuredevops.NewAzureDevopsController(&http.Client{}, os.Getenv("AZURE_DEVOPS_ORG"), os.Getenv("AZURE_DEVOPS_PAT"), logger.Named("azureDevOps")),
+			azureDevopsController,
 			job.NewJobController(
 				kubeClient,
-				logger.Named("job")),
-			logger.Named("pod")),
+				logger.Named("job")).
+				WithPropagationPolicy(propagationPolicy),
+			time.Duration(shutdownDrainBudget)*time.Second,
+			logger.Named("pod")).
+			WithDrainSignaler(pod.NewDrainSignaler(kubeClient, drainSignalClient)),
 		NodepoolController: nodepool.NewNodePoolController(
 			kubeClient,
 			agentPoolClient,
 			subscriptionID,
 			clusterResourceGroup,
 			clusterName,
-			logger.Named("nodepool")),
+			logger.Named("nodepool")).
+			WithManagedClusterClient(managedClusterClient).
+			WithScaleSetVMsClient(scaleSetVMsClient).
+			WithStatePolling(time.Duration(provisioningPollInterval)*time.Second, time.Duration(provisioningTimeout)*time.Second).
+			WithOperationTags(tagOperations),
 		ConfigmapController: configmap.NewConfigMapController(
 			kubeClient,
 			logger.Named("configmap")),
-		Config: config,
-		Logger: logger.Named("safeEvict"),
+		Config:               configStore,
+		HealthChecker:        healthChecker,
+		ImpersonationFactory: impersonation.NewClientFactory(kubeConfig, logger.Named("impersonation")),
+		ClusterController: cluster.NewClusterController(
+			kubeClient,
+			azureCred,
+			os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
+			armOptions,
+			logger.Named("cluster")).
+			WithCredentialOptions(credentialOptions).
+			WithDrainSignalClient(drainSignalClient).
+			WithControllerCredential(workloadClusterControllerCredential),
+		SelfExclusionController: selfexclusion.NewSelfExclusionController(
+			kubeClient,
+			os.Getenv(selfexclusion.PodNameEnvName),
+			os.Getenv(selfexclusion.PodNamespaceEnvName),
+			os.Getenv(selfexclusion.NodeNameEnvName),
+			logger.Named("selfExclusion")),
+		PlanReviewer:            planReviewer,
+		Notifier:                notifier,
+		Auditor:                 auditor,
+		ReconcileTimeout:        time.Duration(reconcileTimeout) * time.Second,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		StateNamespace:          stateNamespace,
+		Logger:                  logger.Named("safeEvict"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "SafeEvict")
 		os.Exit(1)
 	}
+	nodeUpdaterConfigReconciler := &controller.NodeUpdaterConfigReconciler{
+		Client:                mgr.GetClient(),
+		KubeClient:            kubeClient,
+		Defaults:              defaultsStore,
+		Config:                configStore,
+		AzureDevOpsDefaults:   azureDevopsCredentials,
+		AzureDevopsController: azureDevopsController,
+		NotificationDefaults:  notificationSinks,
+		Notifier:              notifier,
+		Logger:                logger.Named("config"),
+	}
+	if err = nodeUpdaterConfigReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NodeUpdaterConfig")
+		os.Exit(1)
+	}
+	if configFile != "" {
+		fileWatcher := appconfig.NewFileWatcher(configFile, *config, defaultsStore, configFileInterval,
+			nodeUpdaterConfigReconciler.DefaultsChanged, logger.Named("config")).WithLogLevels(logLevels)
+		if err = mgr.Add(fileWatcher); err != nil {
+			setupLog.Error(err, "unable to add the configuration file watcher")
+			os.Exit(1)
+		}
+	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookupdatev1.SetupSafeEvictWebhookWithManager(mgr, logger.Named("webhook")); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "SafeEvict")
+			os.Exit(1)
+		}
+		if err = webhookupdatev1.SetupConfigMapWebhookWithManager(mgr, logger.Named("webhook")); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ConfigMap")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if metricsCertWatcher != nil {
@@ -361,18 +736,40 @@ func main() {
 		}
 	}
 
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+	if err := mgr.AddHealthzCheck("healthz", healthChecker.LivenessCheck); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	if err := mgr.AddReadyzCheck("readyz", healthChecker.ReadinessCheck); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if azureDevopsReadinessCheck {
+		if err := mgr.AddReadyzCheck("azure-devops", healthChecker.AzureDevOpsReadinessCheck); err != nil {
+			setupLog.Error(err, "unable to set up Azure DevOps ready check")
+			os.Exit(1)
+		}
+	}
 
-	setupLog.Info("starting manager")
+	setupLog.Info("starting manager", "version", identity.Version)
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+	return nil
+}
+
+// serviceAccountNamespaceFile holds the namespace of the pod, it is mounted with the token of its ServiceAccount
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// controllerNamespace returns the namespace the controller runs in, it is empty outside of a cluster
+func controllerNamespace() string {
+	if namespace := os.Getenv(selfexclusion.PodNamespaceEnvName); namespace != "" {
+		return namespace
+	}
+	namespace, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(namespace))
 }
@@ -0,0 +1,43 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// alerts prints the PrometheusRule of the metrics of the controller, run it with go run ./hack/alerts
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+
+	"norbinto/node-updater/internal/metrics"
+)
+
+func main() {
+	var namespace, name string
+	flag.StringVar(&namespace, "namespace", "system", "Namespace of the PrometheusRule.")
+	flag.StringVar(&name, "name", "controller-manager-alerts", "Name of the PrometheusRule.")
+	flag.Parse()
+
+	out, err := yaml.Marshal(metrics.NewPrometheusRule(namespace, name))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal the PrometheusRule: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print("# Code generated by go run ./hack/alerts. DO NOT EDIT.\n")
+	fmt.Print(string(out))
+}
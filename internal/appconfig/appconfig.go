@@ -1,11 +1,23 @@
 package appconfig
 
-import "time"
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
 
 type Config struct {
 	ErrorReconcileTime   time.Duration
 	SuccessReconcileTime time.Duration
 	UpgradeFrequency     time.Duration
+	// NotificationTemplates are the templates of the notification messages by event, see notify.Format
+	NotificationTemplates map[string]string
+	// ProvisioningRequeueTimes are the times to wait before the next reconcile while ARM provisions a node pool in the
+	// provisioning state of the key, e.g. Creating, the states without a time wait the SuccessReconcileTime
+	ProvisioningRequeueTimes map[string]time.Duration
 }
 
 func NewConfig(errorReconcileTime, successReconcileTime, upgradeFrequency time.Duration) *Config {
@@ -15,3 +27,86 @@ func NewConfig(errorReconcileTime, successReconcileTime, upgradeFrequency time.D
 		UpgradeFrequency:     upgradeFrequency,
 	}
 }
+
+// Equal returns true when both configurations have the same settings
+func (c Config) Equal(other Config) bool {
+	return c.ErrorReconcileTime == other.ErrorReconcileTime && c.SuccessReconcileTime == other.SuccessReconcileTime &&
+		c.UpgradeFrequency == other.UpgradeFrequency && maps.Equal(c.NotificationTemplates, other.NotificationTemplates) &&
+		maps.Equal(c.ProvisioningRequeueTimes, other.ProvisioningRequeueTimes)
+}
+
+// RequeueTimeIn returns the time to wait before the next reconcile while a node pool is in the provisioning state
+func (c Config) RequeueTimeIn(provisioningState string) time.Duration {
+	if requeueTime, ok := c.ProvisioningRequeueTimes[provisioningState]; ok {
+		return requeueTime
+	}
+	return c.SuccessReconcileTime
+}
+
+// WithProvisioningRequeueTimes returns a copy of the Config whose requeue times of the provisioning states are
+// replaced by the requeueTimes, the other states keep their requeue times
+func (c Config) WithProvisioningRequeueTimes(requeueTimes map[string]time.Duration) Config {
+	merged := maps.Clone(c.ProvisioningRequeueTimes)
+	if merged == nil {
+		merged = make(map[string]time.Duration, len(requeueTimes))
+	}
+	maps.Copy(merged, requeueTimes)
+	c.ProvisioningRequeueTimes = merged
+	return c
+}
+
+// ParseProvisioningRequeueTimes parses the requeue times of the provisioning states from a comma separated list of
+// state=duration pairs, e.g. Creating=1m,UpgradingNodeImageVersion=2m
+func ParseProvisioningRequeueTimes(value string) (map[string]time.Duration, error) {
+	requeueTimes := make(map[string]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		state, duration, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("requeue time '%s' is not a state=duration pair", pair)
+		}
+		requeueTime, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil {
+			return nil, fmt.Errorf("invalid requeue time of provisioning state '%s': %w", state, err)
+		}
+		requeueTimes[strings.TrimSpace(state)] = requeueTime
+	}
+	return requeueTimes, ValidateProvisioningRequeueTimes(requeueTimes)
+}
+
+// ValidateProvisioningRequeueTimes returns an error for a requeue time of an empty state or one which is not positive
+func ValidateProvisioningRequeueTimes(requeueTimes map[string]time.Duration) error {
+	for _, state := range slices.Sorted(maps.Keys(requeueTimes)) {
+		if state == "" {
+			return fmt.Errorf("requeue time without a provisioning state")
+		}
+		if requeueTimes[state] <= 0 {
+			return fmt.Errorf("requeue time of provisioning state '%s' is not positive", state)
+		}
+	}
+	return nil
+}
+
+// Store holds the current Config of the controller. The Config is replaced as a whole when it is reloaded, so a
+// loaded Config is never changed while it is used.
+type Store struct {
+	current atomic.Pointer[Config]
+}
+
+func NewStore(config *Config) *Store {
+	store := &Store{}
+	store.Update(config)
+	return store
+}
+
+// Load returns the current Config, it must not be modified
+func (s *Store) Load() *Config {
+	return s.current.Load()
+}
+
+// Update replaces the current Config, the reconciles which already loaded the previous one finish with it
+func (s *Store) Update(config *Config) {
+	s.current.Store(config)
+}
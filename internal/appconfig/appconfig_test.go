@@ -0,0 +1,46 @@
+package appconfig
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestParseProvisioningRequeueTimes(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  map[string]time.Duration
+		expectErr bool
+	}{
+		{name: "pairs", value: "Creating=1m, UpgradingNodeImageVersion=2m,", expected: map[string]time.Duration{"Creating": time.Minute, "UpgradingNodeImageVersion": 2 * time.Minute}},
+		{name: "empty", value: "", expected: map[string]time.Duration{}},
+		{name: "without duration", value: "Creating", expectErr: true},
+		{name: "invalid duration", value: "Creating=soon", expectErr: true},
+		{name: "not positive", value: "Creating=0s", expectErr: true},
+		{name: "without state", value: "=1m", expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requeueTimes, err := ParseProvisioningRequeueTimes(tt.value)
+
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expected error %t, got %v", tt.expectErr, err)
+			}
+			if !tt.expectErr && !maps.Equal(requeueTimes, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, requeueTimes)
+			}
+		})
+	}
+}
+
+func TestRequeueTimeIn(t *testing.T) {
+	config := NewConfig(time.Second, 10*time.Second, time.Hour).WithProvisioningRequeueTimes(map[string]time.Duration{"Creating": time.Minute})
+
+	if requeueTime := config.RequeueTimeIn("Creating"); requeueTime != time.Minute {
+		t.Errorf("expected the requeue time of the state, got %v", requeueTime)
+	}
+	if requeueTime := config.RequeueTimeIn("Scaling"); requeueTime != 10*time.Second {
+		t.Errorf("expected the success reconcile time for a state without requeue time, got %v", requeueTime)
+	}
+}
@@ -0,0 +1,179 @@
+package appconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/yaml"
+
+	"norbinto/node-updater/internal/logging"
+	"norbinto/node-updater/pkg/notify"
+)
+
+// fileConfig is the content of the configuration file, every unset field keeps the value of the command line flag
+type fileConfig struct {
+	ErrorReconcileTime   string `json:"errorReconcileTime,omitempty"`
+	SuccessReconcileTime string `json:"successReconcileTime,omitempty"`
+	UpgradeFrequency     string `json:"upgradeFrequency,omitempty"`
+	// LogLevels is the log level of the components, e.g. nodepool: debug
+	LogLevels map[string]string `json:"logLevels,omitempty"`
+	// NotificationTemplates are the templates of the notification messages by event
+	NotificationTemplates map[string]string `json:"notificationTemplates,omitempty"`
+	// ProvisioningRequeueTimes are the requeue times by provisioning state, e.g. Creating: 2m, they replace the requeue
+	// times of the flag for the listed states
+	ProvisioningRequeueTimes map[string]string `json:"provisioningRequeueTimes,omitempty"`
+}
+
+// FileWatcher reloads the Config from a file, typically a key of a ConfigMap mounted into the controller, so the
+// reconcile times can be tuned without a rollout. The kubelet updates a mounted ConfigMap in place, so the file is
+// polled instead of watched for events.
+type FileWatcher struct {
+	path     string
+	flags    Config
+	store    *Store
+	interval time.Duration
+	// onChange is called after the Config of the file is stored
+	onChange func()
+	logger   *zap.Logger
+	// content is the content of the file which was applied last
+	content []byte
+	// levels get the log levels of the file, the components missing from the file log at the default level
+	levels *logging.Levels
+}
+
+// NewFileWatcher creates a FileWatcher which stores the flags overridden by the file in store, onChange may be nil
+func NewFileWatcher(path string, flags Config, store *Store, interval time.Duration, onChange func(), logger *zap.Logger) *FileWatcher {
+	return &FileWatcher{
+		path:     path,
+		flags:    flags,
+		store:    store,
+		interval: interval,
+		onChange: onChange,
+		logger:   logger,
+	}
+}
+
+// WithLogLevels returns a copy of the FileWatcher which also applies the logLevels of the file to levels
+func (w *FileWatcher) WithLogLevels(levels *logging.Levels) *FileWatcher {
+	copied := *w
+	copied.levels = levels
+	return &copied
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, every replica runs with the configuration
+func (w *FileWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable, it reloads the file until the context is cancelled. A file which cannot be read
+// or parsed keeps the Config applied before.
+func (w *FileWatcher) Start(ctx context.Context) error {
+	w.logger.Info("Watching the configuration file", zap.String("path", w.path), zap.Duration("interval", w.interval))
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if err := w.reload(); err != nil {
+			w.logger.Error("Failed to reload the configuration file, keeping the previous configuration", zap.Error(err), zap.String("path", w.path))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// reload stores the Config of the file when the file changed since the last reload. A missing file is empty, so
+// removing the key from an optional ConfigMap falls back to the flags.
+func (w *FileWatcher) reload() error {
+	content, err := os.ReadFile(w.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		content = []byte{}
+	} else if err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	if w.content != nil && bytes.Equal(content, w.content) {
+		return nil
+	}
+	config, logLevels, err := parse(content, w.flags)
+	if err != nil {
+		return err
+	}
+	w.content = content
+	w.store.Update(config)
+	if w.levels != nil {
+		w.levels.Apply(logLevels)
+	}
+	w.logger.Info("Configuration file is reloaded", zap.Duration("errorReconcileTime", config.ErrorReconcileTime),
+		zap.Duration("successReconcileTime", config.SuccessReconcileTime), zap.Duration("upgradeFrequency", config.UpgradeFrequency))
+	if w.onChange != nil {
+		w.onChange()
+	}
+	return nil
+}
+
+// parse overrides the flags with the durations of the file and returns the log levels of the file
+func parse(content []byte, flags Config) (*Config, map[string]zapcore.Level, error) {
+	var file fileConfig
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse configuration file: %w", err)
+	}
+	config := flags
+	for _, field := range []struct {
+		name  string
+		value string
+		into  *time.Duration
+	}{
+		{"errorReconcileTime", file.ErrorReconcileTime, &config.ErrorReconcileTime},
+		{"successReconcileTime", file.SuccessReconcileTime, &config.SuccessReconcileTime},
+		{"upgradeFrequency", file.UpgradeFrequency, &config.UpgradeFrequency},
+	} {
+		if field.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(field.value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s in configuration file: %w", field.name, err)
+		}
+		if duration <= 0 {
+			return nil, nil, fmt.Errorf("invalid %s in configuration file: %s is not positive", field.name, field.value)
+		}
+		*field.into = duration
+	}
+	if file.NotificationTemplates != nil {
+		if err := notify.ValidateTemplates(file.NotificationTemplates); err != nil {
+			return nil, nil, fmt.Errorf("invalid notificationTemplates in configuration file: %w", err)
+		}
+		config.NotificationTemplates = file.NotificationTemplates
+	}
+	if file.ProvisioningRequeueTimes != nil {
+		requeueTimes := make(map[string]time.Duration, len(file.ProvisioningRequeueTimes))
+		for state, value := range file.ProvisioningRequeueTimes {
+			requeueTime, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid requeue time of provisioning state '%s' in configuration file: %w", state, err)
+			}
+			requeueTimes[state] = requeueTime
+		}
+		if err := ValidateProvisioningRequeueTimes(requeueTimes); err != nil {
+			return nil, nil, fmt.Errorf("invalid provisioningRequeueTimes in configuration file: %w", err)
+		}
+		config = config.WithProvisioningRequeueTimes(requeueTimes)
+	}
+	logLevels := make(map[string]zapcore.Level, len(file.LogLevels))
+	for component, value := range file.LogLevels {
+		level, err := zapcore.ParseLevel(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid log level of %s in configuration file: %w", component, err)
+		}
+		logLevels[component] = level
+	}
+	return &config, logLevels, nil
+}
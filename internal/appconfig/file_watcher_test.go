@@ -0,0 +1,168 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+
+	"norbinto/node-updater/internal/logging"
+)
+
+func newTestWatcher(t *testing.T) (*FileWatcher, *Store, *int) {
+	flags := NewConfig(10*time.Second, 10*time.Second, time.Hour)
+	store := NewStore(flags)
+	changes := 0
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	watcher := NewFileWatcher(path, *flags, store, time.Second, func() { changes++ }, zaptest.NewLogger(t))
+	return watcher, store, &changes
+}
+
+func writeConfig(t *testing.T, watcher *FileWatcher, content string) {
+	t.Helper()
+	if err := os.WriteFile(watcher.path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write configuration file: %v", err)
+	}
+}
+
+func TestReload_OverridesFlagsWithFile(t *testing.T) {
+	watcher, store, changes := newTestWatcher(t)
+	writeConfig(t, watcher, "errorReconcileTime: 30s\nupgradeFrequency: 2h\n")
+
+	if err := watcher.reload(); err != nil {
+		t.Fatalf("expected the file to be loaded, got %v", err)
+	}
+
+	expected := Config{ErrorReconcileTime: 30 * time.Second, SuccessReconcileTime: 10 * time.Second, UpgradeFrequency: 2 * time.Hour}
+	if config := *store.Load(); !config.Equal(expected) {
+		t.Errorf("expected %+v, got %+v", expected, config)
+	}
+	if *changes != 1 {
+		t.Errorf("expected one change, got %d", *changes)
+	}
+}
+
+func TestReload_IgnoresUnchangedFile(t *testing.T) {
+	watcher, _, changes := newTestWatcher(t)
+	writeConfig(t, watcher, "successReconcileTime: 5s\n")
+
+	for range 2 {
+		if err := watcher.reload(); err != nil {
+			t.Fatalf("expected the file to be loaded, got %v", err)
+		}
+	}
+
+	if *changes != 1 {
+		t.Errorf("expected the unchanged file to be applied once, got %d changes", *changes)
+	}
+}
+
+func TestReload_KeepsPreviousConfigOnInvalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unknown field", "reconcileTime: 30s\n"},
+		{"invalid duration", "errorReconcileTime: soon\n"},
+		{"negative duration", "upgradeFrequency: -1h\n"},
+		{"invalid log level", "logLevels:\n  nodepool: verbose\n"},
+		{"invalid notification template", "notificationTemplates:\n  RolledBack: '{{.Pools}}'\n"},
+		{"invalid requeue time", "provisioningRequeueTimes:\n  Creating: 0s\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watcher, store, changes := newTestWatcher(t)
+			writeConfig(t, watcher, "errorReconcileTime: 30s\n")
+			if err := watcher.reload(); err != nil {
+				t.Fatalf("expected the valid file to be loaded, got %v", err)
+			}
+			writeConfig(t, watcher, tt.content)
+
+			if err := watcher.reload(); err == nil {
+				t.Fatal("expected the invalid file to be rejected")
+			}
+
+			if errorReconcileTime := store.Load().ErrorReconcileTime; errorReconcileTime != 30*time.Second {
+				t.Errorf("expected the previous configuration to be kept, got %v", errorReconcileTime)
+			}
+			if *changes != 1 {
+				t.Errorf("expected no change for the invalid file, got %d changes", *changes)
+			}
+		})
+	}
+}
+
+func TestReload_AppliesNotificationTemplates(t *testing.T) {
+	watcher, store, _ := newTestWatcher(t)
+	writeConfig(t, watcher, "notificationTemplates:\n  RolledBack: '{{.Name}} rolled back'\n")
+
+	if err := watcher.reload(); err != nil {
+		t.Fatalf("expected the file to be loaded, got %v", err)
+	}
+
+	if template := store.Load().NotificationTemplates["RolledBack"]; template != "{{.Name}} rolled back" {
+		t.Errorf("expected the notification template of the file, got %q", template)
+	}
+}
+
+func TestReload_MergesProvisioningRequeueTimes(t *testing.T) {
+	watcher, store, _ := newTestWatcher(t)
+	watcher.flags = watcher.flags.WithProvisioningRequeueTimes(map[string]time.Duration{"Creating": time.Minute, "Deleting": time.Minute})
+	writeConfig(t, watcher, "provisioningRequeueTimes:\n  Creating: 2m\n")
+
+	if err := watcher.reload(); err != nil {
+		t.Fatalf("expected the file to be loaded, got %v", err)
+	}
+
+	config := store.Load()
+	if config.RequeueTimeIn("Creating") != 2*time.Minute || config.RequeueTimeIn("Deleting") != time.Minute {
+		t.Errorf("expected the file to override only the listed states, got %v", config.ProvisioningRequeueTimes)
+	}
+	if watcher.flags.RequeueTimeIn("Creating") != time.Minute {
+		t.Error("expected the flags not to be changed by the file")
+	}
+}
+
+func TestReload_AppliesLogLevels(t *testing.T) {
+	watcher, _, _ := newTestWatcher(t)
+	levels := logging.NewLevels(zapcore.InfoLevel)
+	levels.Set("pod", zapcore.DebugLevel)
+	watcher = watcher.WithLogLevels(levels)
+	writeConfig(t, watcher, "logLevels:\n  nodepool: debug\n")
+
+	if err := watcher.reload(); err != nil {
+		t.Fatalf("expected the file to be loaded, got %v", err)
+	}
+
+	if level := levels.Level("nodepool"); level != zapcore.DebugLevel {
+		t.Errorf("expected the level of the file, got %s", level)
+	}
+	if level := levels.Level("pod"); level != zapcore.InfoLevel {
+		t.Errorf("expected a component missing from the file to log at the default level, got %s", level)
+	}
+}
+
+func TestReload_RemovedFileFallsBackToFlags(t *testing.T) {
+	watcher, store, changes := newTestWatcher(t)
+	writeConfig(t, watcher, "errorReconcileTime: 30s\n")
+	if err := watcher.reload(); err != nil {
+		t.Fatalf("expected the file to be loaded, got %v", err)
+	}
+	if err := os.Remove(watcher.path); err != nil {
+		t.Fatalf("failed to remove configuration file: %v", err)
+	}
+
+	if err := watcher.reload(); err != nil {
+		t.Fatalf("expected the missing file to be treated as empty, got %v", err)
+	}
+
+	if config := *store.Load(); !config.Equal(watcher.flags) {
+		t.Errorf("expected the flags %+v, got %+v", watcher.flags, config)
+	}
+	if *changes != 2 {
+		t.Errorf("expected the removal to be a change, got %d changes", *changes)
+	}
+}
@@ -0,0 +1,166 @@
+// Package audit records every mutating action of the controller, e.g. a cordoned node, a disabled agent or an upgraded
+// node pool, to the sinks the compliance reviews of the automated infrastructure changes read: a dedicated log stream,
+// a ring buffer in a ConfigMap or an external HTTP endpoint. The auditor travels in the context of the reconcile, so
+// the controllers which call Kubernetes, ARM and Azure DevOps record their actions without being passed it.
+package audit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"norbinto/node-updater/internal/identity"
+)
+
+// Action is the kind of a mutating action
+type Action string
+
+// The actions the controller records
+const (
+	ActionCordonNode           Action = "CordonNode"
+	ActionUncordonNode         Action = "UncordonNode"
+	ActionLabelNode            Action = "LabelNode"
+	ActionRestoreNodeTaints    Action = "RestoreNodeTaints"
+	ActionSetScaleDownDisabled Action = "SetScaleDownDisabled"
+	ActionDeleteNode           Action = "DeleteNode"
+	ActionReimageNode          Action = "ReimageNode"
+	ActionUpgradePool          Action = "UpgradePool"
+	ActionCreatePool           Action = "CreatePool"
+	ActionDeletePool           Action = "DeletePool"
+	ActionScalePool            Action = "ScalePool"
+	ActionDisableAutoScaling   Action = "DisableAutoScaling"
+	ActionRestoreScaling       Action = "RestoreScaling"
+	ActionUpdatePoolReferences Action = "UpdatePoolReferences"
+	ActionDisableAgent         Action = "DisableAgent"
+	ActionEnableAgent          Action = "EnableAgent"
+	ActionRemoveAgent          Action = "RemoveAgent"
+	ActionEvictPod             Action = "EvictPod"
+	ActionDeleteJob            Action = "DeleteJob"
+	ActionSuspendCronJob       Action = "SuspendCronJob"
+	ActionResumeCronJob        Action = "ResumeCronJob"
+)
+
+// Outcome tells whether the action was carried out
+type Outcome string
+
+const (
+	OutcomeSucceeded Outcome = "Succeeded"
+	OutcomeFailed    Outcome = "Failed"
+)
+
+// Record is the audit record of an action
+type Record struct {
+	Time time.Time `json:"time"`
+	// Actor is the controller which acted, its User-Agent, e.g. node-updater/v1.2.3
+	Actor  string `json:"actor"`
+	Action Action `json:"action"`
+	// Target is the object the action changed as kind/name, e.g. node/aks-userpool-12345678-vmss000000
+	Target string `json:"target"`
+	// Namespace and Name identify the SafeEvict whose reconcile acted, they are empty outside of a reconcile
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Cluster is the workload cluster in management cluster mode, empty for the cluster of the controller
+	Cluster string `json:"cluster,omitempty"`
+	// CorrelationID is the ID of the reconcile, ARM records it in the activity log of its actions
+	CorrelationID string  `json:"correlationID,omitempty"`
+	Outcome       Outcome `json:"outcome"`
+	// Error is the error of a failed action
+	Error string `json:"error,omitempty"`
+}
+
+// Sink stores the records
+type Sink interface {
+	Write(ctx context.Context, record Record) error
+}
+
+// The kinds of the targets
+const (
+	KindNode     = "node"
+	KindNodepool = "nodepool"
+	KindAgent    = "agent"
+	KindPod      = "pod"
+	KindJob      = "job"
+	KindCronJob  = "cronjob"
+)
+
+// Target returns the target of a record, the parts of the name are joined with a slash, e.g. Target(KindPod, ns, name)
+func Target(kind string, name ...string) string {
+	target := kind
+	for _, part := range name {
+		target += "/" + part
+	}
+	return target
+}
+
+// Auditor writes the records to every sink, a sink which fails to store a record is logged and does not fail the
+// action, which already happened
+type Auditor struct {
+	sinks  []Sink
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewAuditor creates an Auditor which writes the records to the sinks
+func NewAuditor(logger *zap.Logger, sinks ...Sink) *Auditor {
+	return &Auditor{sinks: sinks, logger: logger, now: time.Now}
+}
+
+// scope is what the context knows about the reconcile of the actions
+type scope struct {
+	auditor   *Auditor
+	namespace string
+	name      string
+	cluster   string
+}
+
+type scopeKey struct{}
+
+// WithSafeEvict returns a context whose actions are written to the auditor as actions of the reconcile of the SafeEvict,
+// a nil auditor records nothing
+func WithSafeEvict(ctx context.Context, auditor *Auditor, namespace, name string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope{auditor: auditor, namespace: namespace, name: name})
+}
+
+// WithCluster returns a context whose actions are recorded on the workload cluster
+func WithCluster(ctx context.Context, cluster string) context.Context {
+	current, _ := ctx.Value(scopeKey{}).(scope)
+	current.cluster = cluster
+	return context.WithValue(ctx, scopeKey{}, current)
+}
+
+// Write records the action on the target with the outcome of its error. The record is written without the
+// cancellation of ctx, so an action which ran out of the deadline of the reconcile is still recorded.
+func Write(ctx context.Context, action Action, target string, err error) {
+	current, _ := ctx.Value(scopeKey{}).(scope)
+	if current.auditor == nil {
+		return
+	}
+	record := Record{
+		Time:          current.auditor.now().UTC(),
+		Actor:         identity.UserAgent(),
+		Action:        action,
+		Target:        target,
+		Namespace:     current.namespace,
+		Name:          current.name,
+		Cluster:       current.cluster,
+		CorrelationID: identity.CorrelationID(ctx),
+		Outcome:       OutcomeSucceeded,
+	}
+	if err != nil {
+		record.Outcome = OutcomeFailed
+		record.Error = err.Error()
+	}
+	current.auditor.write(context.WithoutCancel(ctx), record)
+}
+
+func (a *Auditor) write(ctx context.Context, record Record) {
+	var errs []error
+	for _, sink := range a.sinks {
+		errs = append(errs, sink.Write(ctx, record))
+	}
+	if err := errors.Join(errs...); err != nil {
+		a.logger.Error("Failed to store the audit record", zap.Error(err), zap.String("action", string(record.Action)), zap.String("target", record.Target))
+	}
+}
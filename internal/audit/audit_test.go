@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	"norbinto/node-updater/internal/identity"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	records []Record
+	err     error
+}
+
+func (s *recordingSink) Write(_ context.Context, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return s.err
+}
+
+func TestWrite(t *testing.T) {
+	sink := &recordingSink{}
+	failing := &recordingSink{err: errors.New("unavailable")}
+	ctx := identity.WithCorrelationID(context.Background(), "reconcile-1")
+	ctx = WithSafeEvict(ctx, NewAuditor(zaptest.NewLogger(t), failing, sink), "ci", "agents")
+	ctx = WithCluster(ctx, "workload")
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	Write(ctx, ActionCordonNode, Target(KindNode, "aks-userpool-0"), nil)
+	Write(cancelled, ActionDisableAgent, Target(KindAgent, "linux", "agent-0"), errors.New("forbidden"))
+
+	if len(sink.records) != 2 {
+		t.Fatalf("expected every action to reach every sink, got %+v", sink.records)
+	}
+	cordoned := sink.records[0]
+	if cordoned.Actor != identity.UserAgent() || cordoned.Target != "node/aks-userpool-0" || cordoned.Outcome != OutcomeSucceeded {
+		t.Errorf("unexpected record %+v", cordoned)
+	}
+	if cordoned.Namespace != "ci" || cordoned.Name != "agents" || cordoned.Cluster != "workload" || cordoned.CorrelationID != "reconcile-1" {
+		t.Errorf("expected the record to identify the reconcile, got %+v", cordoned)
+	}
+	if cordoned.Time.IsZero() {
+		t.Error("expected the record to have a time")
+	}
+	disabled := sink.records[1]
+	if disabled.Target != "agent/linux/agent-0" || disabled.Outcome != OutcomeFailed || disabled.Error != "forbidden" {
+		t.Errorf("expected the failed action to be recorded, got %+v", disabled)
+	}
+}
+
+func TestWrite_WithoutAuditor(t *testing.T) {
+	// nothing is recorded outside of an audited reconcile
+	Write(context.Background(), ActionCordonNode, Target(KindNode, "aks-userpool-0"), nil)
+	Write(WithSafeEvict(context.Background(), nil, "ci", "agents"), ActionCordonNode, Target(KindNode, "aks-userpool-0"), nil)
+}
+
+func TestStreamSink_WritesJSONLines(t *testing.T) {
+	var stream bytes.Buffer
+	sink := NewStreamSink(&stream)
+
+	for _, target := range []string{"node/a", "node/b"} {
+		if err := sink.Write(context.Background(), Record{Action: ActionCordonNode, Target: target, Outcome: OutcomeSucceeded}); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(stream.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a line per record, got %q", stream.String())
+	}
+	var record Record
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil || record.Target != "node/b" {
+		t.Errorf("expected the second record as JSON, got %s (%v)", lines[1], err)
+	}
+}
+
+func TestConfigMapSink_KeepsTheLatestRecords(t *testing.T) {
+	kubeClient := kubefake.NewSimpleClientset()
+	sink := NewConfigMapSink(kubeClient, "node-updater-system", "node-updater-audit", 2)
+
+	for _, target := range []string{"node/a", "node/b", "node/c"} {
+		if err := sink.Write(context.Background(), Record{Action: ActionCordonNode, Target: target, Outcome: OutcomeSucceeded}); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+	}
+
+	configMap, err := kubeClient.CoreV1().ConfigMaps("node-updater-system").Get(context.Background(), "node-updater-audit", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the ConfigMap to be created: %v", err)
+	}
+	var targets []string
+	for _, line := range strings.Split(strings.TrimSuffix(configMap.Data[RecordsKey], "\n"), "\n") {
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode the record %s: %v", line, err)
+		}
+		targets = append(targets, record.Target)
+	}
+	if strings.Join(targets, ",") != "node/b,node/c" {
+		t.Errorf("expected the oldest record to be dropped, got %v", targets)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var received Record
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode the record: %v", err)
+		}
+		if received.Target == "node/refused" {
+			http.Error(w, "refused", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+	sink := NewHTTPSink(server.Client(), server.URL)
+
+	if err := sink.Write(context.Background(), Record{Action: ActionUpgradePool, Target: "nodepool/userpool"}); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if received.Action != ActionUpgradePool || received.Target != "nodepool/userpool" {
+		t.Errorf("expected the record to be posted, got %+v", received)
+	}
+	if err := sink.Write(context.Background(), Record{Action: ActionCordonNode, Target: "node/refused"}); err == nil {
+		t.Error("expected an error for a refused record")
+	}
+}
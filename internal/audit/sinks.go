@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"norbinto/node-updater/internal/egress"
+)
+
+// StreamSink writes the records as JSON lines to a dedicated stream, e.g. a file or the standard output, apart from
+// the log of the controller so the log levels and the deduplication of the log do not drop records
+type StreamSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func NewStreamSink(writer io.Writer) *StreamSink {
+	return &StreamSink{writer: writer}
+}
+
+// Write implements Sink
+func (s *StreamSink) Write(_ context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode the audit record: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.writer.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write the audit record: %w", err)
+	}
+	return nil
+}
+
+const (
+	// RecordsKey is the key of the records in the ConfigMap of the ConfigMapSink, one JSON record per line
+	RecordsKey = "records"
+	// configMapTimeout bounds the update of the ConfigMap, the record is written with a context without deadline
+	configMapTimeout = 10 * time.Second
+)
+
+// ConfigMapSink keeps the latest records in a ConfigMap as a ring buffer, so they can be read with kubectl without
+// access to the log of the controller. The oldest records are dropped once the ConfigMap holds size records.
+type ConfigMapSink struct {
+	mu         sync.Mutex
+	kubeClient kubernetes.Interface
+	namespace  string
+	name       string
+	size       int
+}
+
+func NewConfigMapSink(kubeClient kubernetes.Interface, namespace, name string, size int) *ConfigMapSink {
+	return &ConfigMapSink{kubeClient: kubeClient, namespace: namespace, name: name, size: size}
+}
+
+// Write implements Sink
+func (s *ConfigMapSink) Write(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode the audit record: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, configMapTimeout)
+	defer cancel()
+	// the reconciles of the SafeEvicts record concurrently, the lock spares them most of the conflicts
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+				Data:       map[string]string{RecordsKey: string(data) + "\n"},
+			}
+			_, err = s.kubeClient.CoreV1().ConfigMaps(s.namespace).Create(ctx, configMap, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), s.name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[RecordsKey] = appendRecord(configMap.Data[RecordsKey], string(data), s.size)
+		_, err = s.kubeClient.CoreV1().ConfigMaps(s.namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store the audit record in ConfigMap '%s/%s': %w", s.namespace, s.name, err)
+	}
+	return nil
+}
+
+// appendRecord appends the record to the lines of the records and drops the oldest ones beyond size
+func appendRecord(records, record string, size int) string {
+	lines := append(strings.Split(strings.TrimSuffix(records, "\n"), "\n"), record)
+	if lines[0] == "" {
+		lines = lines[1:]
+	}
+	if len(lines) > size {
+		lines = lines[len(lines)-size:]
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// HTTPSink posts every record as JSON to an external endpoint, e.g. the collector of a SIEM. Every 2xx answer counts as
+// stored.
+type HTTPSink struct {
+	httpClient egress.Doer
+	url        string
+}
+
+func NewHTTPSink(httpClient egress.Doer, url string) *HTTPSink {
+	return &HTTPSink{httpClient: httpClient, url: url}
+}
+
+// Write implements Sink
+func (s *HTTPSink) Write(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode the audit record: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create the request of the audit endpoint: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call the audit endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("audit endpoint returned status %d: %s", resp.StatusCode, string(message))
+	}
+	return nil
+}
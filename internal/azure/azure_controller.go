@@ -1,65 +1,210 @@
 package azure
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
-	azuredevops "norbinto/node-updater/internal/azuredevops"
 	"strings"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"go.uber.org/zap"
+
+	"norbinto/node-updater/internal/egress"
+)
+
+const (
+	imdsURL = "http://169.254.169.254/metadata/instance?api-version=2021-02-01"
+	// imdsMaxAttempts is the number of times the instance metadata is requested before giving up
+	imdsMaxAttempts = 5
+	// imdsRequestTimeout bounds a single request to the instance metadata service
+	imdsRequestTimeout = 5 * time.Second
+	// managedClusterNameTag and managedClusterResourceGroupTag are set by AKS on the node resource group of a cluster
+	managedClusterNameTag          = "aks-managed-cluster-name"
+	managedClusterResourceGroupTag = "aks-managed-cluster-rg"
 )
 
 type AzureController struct {
-	httpClient azuredevops.Doer
-	logger     *zap.Logger
+	httpClient              egress.Doer
+	newManagedClusterClient func(subscriptionID string) (ManagedClusterClientInterface, error)
+	newResourceGroupClient  func(subscriptionID string) (ResourceGroupClientInterface, error)
+	retryDelay              time.Duration
+	logger                  *zap.Logger
 }
 
-func NewAzureController(client azuredevops.Doer, logger *zap.Logger) *AzureController {
-	return &AzureController{httpClient: client, logger: logger}
+var _ AzureControllerInterface = &AzureController{}
+
+// NewAzureController creates an AzureController which discovers the cluster from the instance metadata service of the node
+// and the tags of its node resource group. azureCred needs read access to the node resource group, or to the managed
+// clusters of the subscription (Microsoft.ContainerService/managedClusters/read) when the tags cannot be read. The ARM
+// clients are created with armOptions, e.g. the transport and the policies of the controller's other ARM clients.
+func NewAzureController(client egress.Doer, azureCred azcore.TokenCredential, armOptions *arm.ClientOptions, logger *zap.Logger) *AzureController {
+	return &AzureController{
+		httpClient: client,
+		newManagedClusterClient: func(subscriptionID string) (ManagedClusterClientInterface, error) {
+			return armcontainerservice.NewManagedClustersClient(subscriptionID, azureCred, armOptions)
+		},
+		newResourceGroupClient: func(subscriptionID string) (ResourceGroupClientInterface, error) {
+			return armresources.NewResourceGroupsClient(subscriptionID, azureCred, armOptions)
+		},
+		retryDelay: time.Second,
+		logger:     logger,
+	}
 }
 
-func (c *AzureController) GetClusterInfo() (string, string, string, error) {
-	const imdsURL = "http://169.254.169.254/metadata/instance?api-version=2021-02-01"
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+type instanceMetadata struct {
+	Compute struct {
+		ResourceGroupName string `json:"resourceGroupName"`
+		SubscriptionID    string `json:"subscriptionId"`
+	} `json:"compute"`
+}
+
+// GetClusterInfo returns the subscription id, the resource group and the name of the AKS cluster the controller runs in.
+// The node resource group is read from the instance metadata service, the cluster is read from its tags, or matched
+// against the node resource group of the managed clusters in the subscription, so custom node resource group names are
+// supported.
+func (c *AzureController) GetClusterInfo(ctx context.Context) (string, string, string, error) {
+	metadata, err := c.getInstanceMetadata(ctx)
+	if err != nil {
+		return "", "", "", err
+	}
+	if metadata.Compute.SubscriptionID == "" || metadata.Compute.ResourceGroupName == "" {
+		return "", "", "", fmt.Errorf("instance metadata does not contain the subscription id and the resource group")
 	}
 
-	req, err := http.NewRequest("GET", imdsURL, nil)
+	clusterResourceGroup, clusterName, err := c.findClusterByNodeResourceGroup(ctx, metadata.Compute.SubscriptionID, metadata.Compute.ResourceGroupName)
 	if err != nil {
 		return "", "", "", err
 	}
+	c.logger.Info("Discovered cluster", zap.String("subscriptionID", metadata.Compute.SubscriptionID), zap.String("clusterResourceGroup", clusterResourceGroup), zap.String("clusterName", clusterName))
+	return metadata.Compute.SubscriptionID, clusterResourceGroup, clusterName, nil
+}
+
+// getInstanceMetadata requests the instance metadata, transient failures are retried with an exponential backoff
+func (c *AzureController) getInstanceMetadata(ctx context.Context) (*instanceMetadata, error) {
+	delay := c.retryDelay
+	var lastErr error
+	for attempt := 1; attempt <= imdsMaxAttempts; attempt++ {
+		metadata, retryable, err := c.requestInstanceMetadata(ctx)
+		if err == nil {
+			return metadata, nil
+		}
+		if !retryable {
+			return nil, err
+		}
+		lastErr = err
+		c.logger.Warn("Failed to get instance metadata, retrying", zap.Error(err), zap.Int("attempt", attempt), zap.Duration("delay", delay))
 
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to get instance metadata: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return nil, fmt.Errorf("failed to get instance metadata after %d attempts: %w", imdsMaxAttempts, lastErr)
+}
+
+func (c *AzureController) requestInstanceMetadata(ctx context.Context) (*instanceMetadata, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, imdsRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", imdsURL, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create instance metadata request: %w", err)
+	}
 	req.Header.Set("Metadata", "true")
 
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", "", "", err
+		return nil, true, fmt.Errorf("failed to request instance metadata: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", "", "", err
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return nil, retryable, fmt.Errorf("unexpected status code from instance metadata service: %d", resp.StatusCode)
 	}
 
-	var metadata struct {
-		Compute struct {
-			ResourceGroupName string `json:"resourceGroupName"`
-			SubscriptionID    string `json:"subscriptionId"`
-		} `json:"compute"`
+	metadata := &instanceMetadata{}
+	if err := json.NewDecoder(resp.Body).Decode(metadata); err != nil {
+		return nil, false, fmt.Errorf("failed to decode instance metadata: %w", err)
 	}
+	return metadata, false, nil
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
-		return "", "", "", err
+// findClusterByNodeResourceGroup returns the resource group and the name of the managed cluster which owns the node
+// resource group. They are read from the tags AKS sets on the node resource group, the managed clusters of the
+// subscription are only listed when the tags cannot be read, e.g. the identity has no read access to the resource group.
+func (c *AzureController) findClusterByNodeResourceGroup(ctx context.Context, subscriptionID, nodeResourceGroup string) (string, string, error) {
+	clusterResourceGroup, clusterName, err := c.findClusterByTags(ctx, subscriptionID, nodeResourceGroup)
+	if err == nil {
+		return clusterResourceGroup, clusterName, nil
 	}
+	c.logger.Info("Cannot read the cluster from the tags of the node resource group, listing the managed clusters of the subscription",
+		zap.Error(err), zap.String("subscriptionID", subscriptionID), zap.String("nodeResourceGroup", nodeResourceGroup))
+	return c.listClusterByNodeResourceGroup(ctx, subscriptionID, nodeResourceGroup)
+}
 
-	// Extract cluster name from resourceGroupName
-	parts := strings.Split(metadata.Compute.ResourceGroupName, "_")
-	if len(parts) < 2 {
-		return "", "", "", err
+// findClusterByTags returns the resource group and the name of the managed cluster from the tags of its node resource group
+func (c *AzureController) findClusterByTags(ctx context.Context, subscriptionID, nodeResourceGroup string) (string, string, error) {
+	resourceGroupClient, err := c.newResourceGroupClient(subscriptionID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create resource group client: %w", err)
+	}
+	resourceGroup, err := resourceGroupClient.Get(ctx, nodeResourceGroup, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get node resource group '%s': %w", nodeResourceGroup, err)
 	}
+	clusterResourceGroup, clusterName := tagValue(resourceGroup.Tags, managedClusterResourceGroupTag), tagValue(resourceGroup.Tags, managedClusterNameTag)
+	if clusterResourceGroup == "" || clusterName == "" {
+		return "", "", fmt.Errorf("node resource group '%s' has no %s and %s tags", nodeResourceGroup, managedClusterResourceGroupTag, managedClusterNameTag)
+	}
+	return clusterResourceGroup, clusterName, nil
+}
+
+// tagValue returns the value of the tag, the names of Azure tags are case-insensitive
+func tagValue(tags map[string]*string, name string) string {
+	for key, value := range tags {
+		if strings.EqualFold(key, name) && value != nil {
+			return *value
+		}
+	}
+	return ""
+}
 
-	clusterName := parts[2]
-	clusterResourceGroup := parts[1] // Assuming the cluster name is the second part
-	return metadata.Compute.SubscriptionID, clusterResourceGroup, clusterName, err
+// listClusterByNodeResourceGroup lists the managed clusters of the subscription and returns the resource group and the
+// name of the one which owns the node resource group
+func (c *AzureController) listClusterByNodeResourceGroup(ctx context.Context, subscriptionID, nodeResourceGroup string) (string, string, error) {
+	managedClusterClient, err := c.newManagedClusterClient(subscriptionID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create managed cluster client: %w", err)
+	}
+
+	pager := managedClusterClient.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			c.logger.Error("Failed to list managed clusters", zap.Error(err), zap.String("subscriptionID", subscriptionID))
+			return "", "", fmt.Errorf("failed to list managed clusters: %w", err)
+		}
+		for _, cluster := range page.Value {
+			if cluster == nil || cluster.ID == nil || cluster.Name == nil || cluster.Properties == nil || cluster.Properties.NodeResourceGroup == nil {
+				continue
+			}
+			if !strings.EqualFold(*cluster.Properties.NodeResourceGroup, nodeResourceGroup) {
+				continue
+			}
+			clusterID, err := arm.ParseResourceID(*cluster.ID)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to parse managed cluster id '%s': %w", *cluster.ID, err)
+			}
+			return clusterID.ResourceGroupName, *cluster.Name, nil
+		}
+	}
+	return "", "", fmt.Errorf("no managed cluster found with node resource group '%s' in subscription '%s'", nodeResourceGroup, subscriptionID)
 }
@@ -0,0 +1,239 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"go.uber.org/zap/zaptest"
+)
+
+const metadataBody = `{"compute": {"resourceGroupName": "custom-node-rg", "subscriptionId": "sub-1"}}`
+
+type fakeDoer struct {
+	responses []*http.Response
+	errors    []error
+	calls     int
+}
+
+func (f *fakeDoer) Do(req *http.Request) (*http.Response, error) {
+	i := f.calls
+	f.calls++
+	if i < len(f.errors) && f.errors[i] != nil {
+		return nil, f.errors[i]
+	}
+	return f.responses[i], nil
+}
+
+func newResponse(statusCode int, body string) *http.Response {
+	return &http.Response{StatusCode: statusCode, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+type fakeManagedClusterClient struct {
+	clusters []*armcontainerservice.ManagedCluster
+	listed   bool
+}
+
+func (f *fakeManagedClusterClient) NewListPager(options *armcontainerservice.ManagedClustersClientListOptions) *azruntime.Pager[armcontainerservice.ManagedClustersClientListResponse] {
+	return azruntime.NewPager(azruntime.PagingHandler[armcontainerservice.ManagedClustersClientListResponse]{
+		More: func(page armcontainerservice.ManagedClustersClientListResponse) bool {
+			return false
+		},
+		Fetcher: func(ctx context.Context, page *armcontainerservice.ManagedClustersClientListResponse) (armcontainerservice.ManagedClustersClientListResponse, error) {
+			f.listed = true
+			return armcontainerservice.ManagedClustersClientListResponse{
+				ManagedClusterListResult: armcontainerservice.ManagedClusterListResult{Value: f.clusters},
+			}, nil
+		},
+	})
+}
+
+// fakeResourceGroupClient answers with the tags of the node resource group, or with 403 when it has none
+type fakeResourceGroupClient struct {
+	tags map[string]*string
+}
+
+func (f *fakeResourceGroupClient) Get(ctx context.Context, resourceGroupName string, options *armresources.ResourceGroupsClientGetOptions) (armresources.ResourceGroupsClientGetResponse, error) {
+	if f.tags == nil {
+		return armresources.ResourceGroupsClientGetResponse{}, &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "AuthorizationFailed"}
+	}
+	return armresources.ResourceGroupsClientGetResponse{ResourceGroup: armresources.ResourceGroup{Name: to.Ptr(resourceGroupName), Tags: f.tags}}, nil
+}
+
+func newTestAzureController(t *testing.T, doer *fakeDoer, clusters ...*armcontainerservice.ManagedCluster) *AzureController {
+	controller, _ := newTaggedTestAzureController(t, doer, nil, clusters...)
+	return controller
+}
+
+func newTaggedTestAzureController(t *testing.T, doer *fakeDoer, tags map[string]*string, clusters ...*armcontainerservice.ManagedCluster) (*AzureController, *fakeManagedClusterClient) {
+	controller := NewAzureController(doer, nil, nil, zaptest.NewLogger(t))
+	controller.retryDelay = 0
+	managedClusterClient := &fakeManagedClusterClient{clusters: clusters}
+	controller.newManagedClusterClient = func(subscriptionID string) (ManagedClusterClientInterface, error) {
+		return managedClusterClient, nil
+	}
+	controller.newResourceGroupClient = func(subscriptionID string) (ResourceGroupClientInterface, error) {
+		return &fakeResourceGroupClient{tags: tags}, nil
+	}
+	return controller, managedClusterClient
+}
+
+func newManagedCluster(resourceGroup, name, nodeResourceGroup string) *armcontainerservice.ManagedCluster {
+	return &armcontainerservice.ManagedCluster{
+		ID:   to.Ptr("/subscriptions/sub-1/resourceGroups/" + resourceGroup + "/providers/Microsoft.ContainerService/managedClusters/" + name),
+		Name: to.Ptr(name),
+		Properties: &armcontainerservice.ManagedClusterProperties{
+			NodeResourceGroup: to.Ptr(nodeResourceGroup),
+		},
+	}
+}
+
+func TestGetClusterInfo_CustomNodeResourceGroup(t *testing.T) {
+	doer := &fakeDoer{responses: []*http.Response{newResponse(http.StatusOK, metadataBody)}}
+	controller := newTestAzureController(t, doer,
+		newManagedCluster("other_rg", "other", "MC_other_rg_other_westeurope"),
+		newManagedCluster("cluster_rg", "my_cluster", "custom-node-rg"),
+	)
+
+	subscriptionID, resourceGroup, clusterName, err := controller.GetClusterInfo(context.TODO())
+	if err != nil {
+		t.Fatalf("GetClusterInfo failed: %v", err)
+	}
+	if subscriptionID != "sub-1" || resourceGroup != "cluster_rg" || clusterName != "my_cluster" {
+		t.Fatalf("Unexpected cluster info: %s, %s, %s", subscriptionID, resourceGroup, clusterName)
+	}
+}
+
+func TestGetClusterInfo_NodeResourceGroupTags(t *testing.T) {
+	tests := []struct {
+		name         string
+		tags         map[string]*string
+		expectListed bool
+	}{
+		{
+			name:         "tagged node resource group",
+			tags:         map[string]*string{"aks-managed-cluster-rg": to.Ptr("cluster_rg"), "aks-managed-cluster-name": to.Ptr("my_cluster")},
+			expectListed: false,
+		},
+		{
+			name:         "tag names are case-insensitive",
+			tags:         map[string]*string{"AKS-Managed-Cluster-RG": to.Ptr("cluster_rg"), "AKS-Managed-Cluster-Name": to.Ptr("my_cluster")},
+			expectListed: false,
+		},
+		{
+			name:         "untagged node resource group",
+			tags:         map[string]*string{"team": to.Ptr("ci")},
+			expectListed: true,
+		},
+		{
+			name:         "node resource group cannot be read",
+			tags:         nil,
+			expectListed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doer := &fakeDoer{responses: []*http.Response{newResponse(http.StatusOK, metadataBody)}}
+			controller, managedClusterClient := newTaggedTestAzureController(t, doer, tt.tags, newManagedCluster("cluster_rg", "my_cluster", "custom-node-rg"))
+
+			_, resourceGroup, clusterName, err := controller.GetClusterInfo(context.TODO())
+			if err != nil {
+				t.Fatalf("GetClusterInfo failed: %v", err)
+			}
+			if resourceGroup != "cluster_rg" || clusterName != "my_cluster" {
+				t.Errorf("Unexpected cluster info: %s, %s", resourceGroup, clusterName)
+			}
+			if managedClusterClient.listed != tt.expectListed {
+				t.Errorf("Expected the managed clusters to be listed: %v, got %v", tt.expectListed, managedClusterClient.listed)
+			}
+		})
+	}
+}
+
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// armDoer answers the ARM requests with the tags of the node resource group and records their User-Agent
+type armDoer struct {
+	userAgents []string
+}
+
+func (f *armDoer) Do(req *http.Request) (*http.Response, error) {
+	f.userAgents = append(f.userAgents, req.Header.Get("User-Agent"))
+	body := `{"name": "custom-node-rg", "tags": {"aks-managed-cluster-rg": "cluster_rg", "aks-managed-cluster-name": "my_cluster"}}`
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+func TestGetClusterInfo_UsesARMClientOptions(t *testing.T) {
+	doer := &fakeDoer{responses: []*http.Response{newResponse(http.StatusOK, metadataBody)}}
+	armTransport := &armDoer{}
+	armOptions := &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+		Telemetry: policy.TelemetryOptions{ApplicationID: "node-updater/test"},
+		Transport: armTransport,
+	}}
+	controller := NewAzureController(doer, fakeCredential{}, armOptions, zaptest.NewLogger(t))
+
+	_, resourceGroup, clusterName, err := controller.GetClusterInfo(context.TODO())
+	if err != nil {
+		t.Fatalf("GetClusterInfo failed: %v", err)
+	}
+	if resourceGroup != "cluster_rg" || clusterName != "my_cluster" {
+		t.Errorf("Unexpected cluster info: %s, %s", resourceGroup, clusterName)
+	}
+	if len(armTransport.userAgents) != 1 || !strings.HasPrefix(armTransport.userAgents[0], "node-updater/test") {
+		t.Errorf("Expected the node resource group to be read through the ARM transport with the User-Agent of the options, got: %v", armTransport.userAgents)
+	}
+}
+
+func TestGetClusterInfo_RetriesTransientFailures(t *testing.T) {
+	doer := &fakeDoer{
+		errors:    []error{errors.New("connection refused"), nil, nil},
+		responses: []*http.Response{nil, newResponse(http.StatusServiceUnavailable, ""), newResponse(http.StatusOK, metadataBody)},
+	}
+	controller := newTestAzureController(t, doer, newManagedCluster("cluster_rg", "my_cluster", "custom-node-rg"))
+
+	_, _, _, err := controller.GetClusterInfo(context.TODO())
+	if err != nil {
+		t.Fatalf("GetClusterInfo failed: %v", err)
+	}
+	if doer.calls != 3 {
+		t.Fatalf("Expected 3 requests, got: %d", doer.calls)
+	}
+}
+
+func TestGetClusterInfo_NonRetryableStatus(t *testing.T) {
+	doer := &fakeDoer{responses: []*http.Response{newResponse(http.StatusBadRequest, "")}}
+	controller := newTestAzureController(t, doer)
+
+	_, _, _, err := controller.GetClusterInfo(context.TODO())
+	if err == nil {
+		t.Fatalf("Expected GetClusterInfo to fail, got nil")
+	}
+	if doer.calls != 1 {
+		t.Fatalf("Expected a single request, got: %d", doer.calls)
+	}
+}
+
+func TestGetClusterInfo_ClusterNotFound(t *testing.T) {
+	doer := &fakeDoer{responses: []*http.Response{newResponse(http.StatusOK, metadataBody)}}
+	controller := newTestAzureController(t, doer, newManagedCluster("other_rg", "other", "MC_other_rg_other_westeurope"))
+
+	_, _, _, err := controller.GetClusterInfo(context.TODO())
+	if err == nil {
+		t.Fatalf("Expected GetClusterInfo to fail, got nil")
+	}
+}
@@ -2,103 +2,164 @@ package azuredevops
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"net/url"
+	"strings"
+	"unicode"
 
 	"go.uber.org/zap"
+
+	"norbinto/node-updater/internal/audit"
+	"norbinto/node-updater/internal/egress"
 )
 
 type AzureDevopsControllerInterface interface {
-	DisableAgent(poolName, agentName string) error
-	RemoveAgent(poolName, agentName string) error
+	DisableAgent(ctx context.Context, poolName string, agent Agent) error
+	EnableAgent(ctx context.Context, poolName string, agent Agent) error
+	RemoveAgent(ctx context.Context, poolName string, agent Agent) error
+	CheckConnection(ctx context.Context) error
+	GetOnlineAgents(ctx context.Context, poolName string) ([]Agent, error)
+	GetQueuedJobCount(ctx context.Context, poolName string) (int, error)
+	GetBusyAgentCount(ctx context.Context, poolName string) (int, error)
 }
 
-type AzureDevopsController struct {
-	httpClient       Doer
-	logger           *zap.Logger
-	OrganizationName string
-	AccessToken      string
+// RequestError is a failed request to Azure DevOps, StatusCode is the status of the response and zero when no response
+// was received. A lookup of an agent or a pool which does not exist fails with http.StatusNotFound.
+type RequestError struct {
+	StatusCode int
+	Err        error
 }
 
-type Doer interface {
-	Do(req *http.Request) (*http.Response, error)
-	// NewRequest(method string, url string, body io.Reader) (*http.Request, error)
+func (e *RequestError) Error() string {
+	return e.Err.Error()
 }
 
-func NewAzureDevopsController(client Doer, organizationName string, accessToken string, logger *zap.Logger) *AzureDevopsController {
-	return &AzureDevopsController{httpClient: client, OrganizationName: organizationName, AccessToken: accessToken, logger: logger}
+func (e *RequestError) Unwrap() error {
+	return e.Err
 }
 
-func (c *AzureDevopsController) DisableAgent(poolName, agentName string) error {
-	c.logger.Debug("Disabling agent", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-	// Get the pool ID from the pool name
-	poolID, err := c.getPoolIDFromName(c.OrganizationName, poolName)
-	if err != nil {
-		c.logger.Error("Error getting pool ID", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
-		return fmt.Errorf("failed to get pool ID from name: %w", err)
+// Retryable returns true when the request may succeed if it is sent again: no response was received, or Azure DevOps
+// throttled the request or failed itself
+func (e *RequestError) Retryable() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// ErrInvalidPoolName is returned for a pool name which no Azure DevOps pool can have, e.g. an AZP_POOL read from a
+// Secret with a trailing newline
+var ErrInvalidPoolName = errors.New("invalid Azure DevOps pool name")
+
+// ValidatePoolName returns an error wrapping ErrInvalidPoolName when the name is empty, starts or ends with a space or
+// contains a control character. Azure DevOps trims the names of the pools, so such a name never matches a pool. Spaces
+// inside the name and unicode letters are valid.
+func ValidatePoolName(poolName string) error {
+	switch {
+	case strings.TrimSpace(poolName) == "":
+		return fmt.Errorf("%w: the name is empty", ErrInvalidPoolName)
+	case strings.TrimSpace(poolName) != poolName:
+		return fmt.Errorf("%w: '%s' starts or ends with a space", ErrInvalidPoolName, poolName)
+	case strings.IndexFunc(poolName, unicode.IsControl) >= 0:
+		return fmt.Errorf("%w: %q contains a control character", ErrInvalidPoolName, poolName)
 	}
+	return nil
+}
 
-	// Construct the API URL to list agents
-	url := fmt.Sprintf("https://dev.azure.com/%s/_apis/distributedtask/pools/%s/agents?api-version=7.1-preview.1", c.OrganizationName, strconv.Itoa(poolID))
+// Agent identifies an agent registered in an Azure DevOps pool
+type Agent struct {
+	// Name is the name the agent was registered with (AZP_AGENT_NAME)
+	Name string
+	// HostName is matched against the host name capabilities of the agents when no agent is registered with Name
+	HostName string
+}
 
-	// Create the HTTP request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
-	}
+// hostNameCapabilities are the system capabilities reported by the agent which contain the host name, which is the pod name in Kubernetes
+var hostNameCapabilities = []string{"HOSTNAME", "Agent.ComputerName"}
+
+const (
+	// DefaultServerURL is the address of Azure DevOps Services, the organizations are the first segment of its paths
+	DefaultServerURL = "https://dev.azure.com"
+	// servicesAPIVersion is the api-version of the requests to Azure DevOps Services
+	servicesAPIVersion = "7.1"
+	// serverAPIVersion is the api-version of the requests to Azure DevOps Server, the distributed task API of Azure
+	// DevOps Server 2019 and later supports it
+	serverAPIVersion = "5.0"
+	// continuationTokenHeader is set on a page of a list which has more entries, the token is sent back in the
+	// continuationToken query parameter to get the next page
+	continuationTokenHeader = "X-MS-ContinuationToken"
+)
 
-	// Add headers
-	req.SetBasicAuth("", c.AccessToken)
+type AzureDevopsController struct {
+	httpClient egress.Doer
+	logger     *zap.Logger
+	// ServerURL is DefaultServerURL, or the address of an Azure DevOps Server (TFS) whose collection is the
+	// OrganizationName, e.g. https://tfs.contoso.com/tfs
+	ServerURL        string
+	OrganizationName string
+	AccessToken      string
+}
 
-	// Send the request
-	client := c.httpClient
-	resp, err := client.Do(req)
-	if err != nil {
-		c.logger.Error("Error sending HTTP request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to send HTTP request: %w", err)
-	}
-	defer resp.Body.Close()
+func NewAzureDevopsController(client egress.Doer, organizationName string, accessToken string, logger *zap.Logger) *AzureDevopsController {
+	return &AzureDevopsController{httpClient: client, ServerURL: DefaultServerURL, OrganizationName: organizationName, AccessToken: accessToken, logger: logger}
+}
 
-	// Check the response status
-	if resp.StatusCode != http.StatusOK {
-		c.logger.Error("Failed to list agents", zap.Error(fmt.Errorf("unexpected status code")), zap.Int("statusCode", resp.StatusCode), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to list agents: status code %d", resp.StatusCode)
+// WithServerURL returns a copy of the AzureDevopsController which calls the Azure DevOps Server at serverURL, an
+// empty serverURL calls Azure DevOps Services
+func (c *AzureDevopsController) WithServerURL(serverURL string) *AzureDevopsController {
+	controller := *c
+	controller.ServerURL = strings.TrimSuffix(serverURL, "/")
+	if controller.ServerURL == "" {
+		controller.ServerURL = DefaultServerURL
 	}
+	return &controller
+}
 
-	// Parse the response body
-	var response struct {
-		Value []struct {
-			ID   json.Number `json:"id"`
-			Name string      `json:"name"`
-		} `json:"value"`
+// apiURL returns the URL of the path of the distributed task API in the organization or collection, the api-version
+// supported by the server is added to the query. The name of the organization or collection is escaped, e.g. the
+// "Default Collection" of an Azure DevOps Server, the values of the query must be escaped by the caller.
+func (c *AzureDevopsController) apiURL(path string, query string) string {
+	apiVersion := servicesAPIVersion
+	if c.ServerURL != DefaultServerURL {
+		apiVersion = serverAPIVersion
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		c.logger.Error("Error decoding response body", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to decode response body: %w", err)
+	if query != "" {
+		query += "&"
 	}
+	return fmt.Sprintf("%s/%s/_apis/distributedtask/%s?%sapi-version=%s", c.ServerURL, url.PathEscape(c.OrganizationName), path, query, apiVersion)
+}
 
-	// Find the agent ID by name
-	var agentID int = 0
-	for _, agent := range response.Value {
-		if agent.Name == agentName {
-			id, err := agent.ID.Int64()
-			if err != nil {
-				c.logger.Error("Error converting agent ID to int", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-				return fmt.Errorf("failed to convert agent ID to int: %w", err)
-			}
-			agentID = int(id)
-			break
-		}
+func (c *AzureDevopsController) DisableAgent(ctx context.Context, poolName string, agent Agent) error {
+	c.logger.Debug("Disabling agent", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
+	err := c.setAgentEnabled(ctx, poolName, agent, false)
+	audit.Write(ctx, audit.ActionDisableAgent, audit.Target(audit.KindAgent, poolName, agent.Name), err)
+	return err
+}
+
+// EnableAgent enables a previously disabled agent, it is used to roll back an interrupted eviction
+func (c *AzureDevopsController) EnableAgent(ctx context.Context, poolName string, agent Agent) error {
+	c.logger.Debug("Enabling agent", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
+	err := c.setAgentEnabled(ctx, poolName, agent, true)
+	audit.Write(ctx, audit.ActionEnableAgent, audit.Target(audit.KindAgent, poolName, agent.Name), err)
+	return err
+}
+
+func (c *AzureDevopsController) setAgentEnabled(ctx context.Context, poolName string, agent Agent, enabled bool) error {
+	// Get the pool ID from the pool name
+	poolID, err := c.getPoolIDFromName(ctx, c.OrganizationName, poolName)
+	if err != nil {
+		c.logger.Error("Error getting pool ID", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
+		return fmt.Errorf("failed to get pool ID from name: %w", err)
 	}
-	if agentID == 0 {
-		c.logger.Error("Agent not found", zap.Error(fmt.Errorf("agent not found")), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("agent with name '%s' not found", agentName)
+
+	agentID, err := c.getAgentID(ctx, poolID, poolName, agent)
+	if err != nil {
+		return err
 	}
 
-	// Construct the API URL to disable the agent
-	url = fmt.Sprintf("https://dev.azure.com/%s/_apis/distributedtask/pools/%s/agents/%s?api-version=7.1-preview.1", c.OrganizationName, strconv.Itoa(poolID), strconv.Itoa(agentID))
+	// Construct the API URL to update the agent
+	url := c.apiURL(fmt.Sprintf("pools/%d/agents/%d", poolID, agentID), "")
 
 	// Create the request payload
 	payload := struct {
@@ -106,19 +167,19 @@ func (c *AzureDevopsController) DisableAgent(poolName, agentName string) error {
 		Enabled bool `json:"enabled"`
 	}{
 		ID:      agentID,
-		Enabled: false,
+		Enabled: enabled,
 	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
-		c.logger.Error("Error marshalling request payload", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
+		c.logger.Error("Error marshalling request payload", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
 		return fmt.Errorf("failed to marshal request payload: %w", err)
 	}
 
 	// Create the HTTP request
-	req, err = http.NewRequest("PATCH", url, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewBuffer(body))
 	if err != nil {
-		c.logger.Error("Error creating HTTP PATCH request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
+		c.logger.Error("Error creating HTTP PATCH request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
 		return fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
@@ -127,39 +188,50 @@ func (c *AzureDevopsController) DisableAgent(poolName, agentName string) error {
 	req.SetBasicAuth("", c.AccessToken)
 
 	// Send the request
-	resp, err = client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		c.logger.Error("Error sending HTTP PATCH request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to send HTTP request: %w", err)
+		c.logger.Error("Error sending HTTP PATCH request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
+		return &RequestError{Err: fmt.Errorf("failed to send HTTP request: %w", err)}
 	}
 	defer resp.Body.Close()
 
 	// Check the response status
 	if resp.StatusCode != http.StatusOK {
-		c.logger.Error("Failed to disable agent", zap.Error(fmt.Errorf("unexpected status code")), zap.Int("statusCode", resp.StatusCode), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to disable agent: status code %d", resp.StatusCode)
+		c.logger.Error("Failed to update agent", zap.Error(fmt.Errorf("unexpected status code")), zap.Int("statusCode", resp.StatusCode), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name), zap.Bool("enabled", enabled))
+		return &RequestError{StatusCode: resp.StatusCode, Err: fmt.Errorf("failed to update agent: status code %d", resp.StatusCode)}
 	}
 
-	c.logger.Debug("Agent successfully disabled", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
+	c.logger.Debug("Agent successfully updated", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name), zap.Bool("enabled", enabled))
 	return nil
 }
 
-func (c *AzureDevopsController) RemoveAgent(poolName, agentName string) error {
-	c.logger.Debug("Removing agent", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
+func (c *AzureDevopsController) RemoveAgent(ctx context.Context, poolName string, agent Agent) error {
+	c.logger.Debug("Removing agent", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
+	err := c.removeAgent(ctx, poolName, agent)
+	audit.Write(ctx, audit.ActionRemoveAgent, audit.Target(audit.KindAgent, poolName, agent.Name), err)
+	return err
+}
+
+func (c *AzureDevopsController) removeAgent(ctx context.Context, poolName string, agent Agent) error {
 	// Get the pool ID from the pool name
-	poolID, err := c.getPoolIDFromName(c.OrganizationName, poolName)
+	poolID, err := c.getPoolIDFromName(ctx, c.OrganizationName, poolName)
 	if err != nil {
 		c.logger.Error("Error getting pool ID", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
 		return fmt.Errorf("failed to get pool ID from name: %w", err)
 	}
 
-	// Construct the API URL to list agents
-	url := fmt.Sprintf("https://dev.azure.com/%s/_apis/distributedtask/pools/%s/agents?api-version=7.1-preview.1", c.OrganizationName, strconv.Itoa(poolID))
+	agentID, err := c.getAgentID(ctx, poolID, poolName, agent)
+	if err != nil {
+		return err
+	}
+
+	// Construct the API URL to remove the agent
+	url := c.apiURL(fmt.Sprintf("pools/%d/agents/%d", poolID, agentID), "")
 
 	// Create the HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
-		c.logger.Error("Error creating HTTP request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
+		c.logger.Error("Error creating HTTP DELETE request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
 		return fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
@@ -167,126 +239,206 @@ func (c *AzureDevopsController) RemoveAgent(poolName, agentName string) error {
 	req.SetBasicAuth("", c.AccessToken)
 
 	// Send the request
-	client := c.httpClient
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		c.logger.Error("Error sending HTTP request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to send HTTP request: %w", err)
+		c.logger.Error("Error sending HTTP DELETE request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
+		return &RequestError{Err: fmt.Errorf("failed to send HTTP request: %w", err)}
 	}
 	defer resp.Body.Close()
 
 	// Check the response status
-	if resp.StatusCode != http.StatusOK {
-		c.logger.Error("Failed to list agents", zap.Error(fmt.Errorf("unexpected status code")), zap.Int("statusCode", resp.StatusCode), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to list agents: status code %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		c.logger.Error("Failed to remove agent", zap.Error(fmt.Errorf("unexpected status code")), zap.Int("statusCode", resp.StatusCode), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
+		return &RequestError{StatusCode: resp.StatusCode, Err: fmt.Errorf("failed to remove agent: status code %d", resp.StatusCode)}
 	}
 
-	// Parse the response body
-	var response struct {
-		Value []struct {
-			ID   json.Number `json:"id"`
-			Name string      `json:"name"`
-		} `json:"value"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		c.logger.Error("Error decoding response body", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to decode response body: %w", err)
+	c.logger.Debug("Agent successfully removed", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
+	return nil
+}
+
+// CheckConnection verifies that the Azure DevOps API is reachable and the access token is accepted
+func (c *AzureDevopsController) CheckConnection(ctx context.Context) error {
+	url := c.apiURL("pools", "$top=1")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
 	}
+	req.SetBasicAuth("", c.AccessToken)
 
-	// Find the agent ID by name
-	var agentID int = 0
-	for _, agent := range response.Value {
-		if agent.Name == agentName {
-			id, err := agent.ID.Int64()
-			if err != nil {
-				c.logger.Error("Error converting agent ID to int", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-				return fmt.Errorf("failed to convert agent ID to int: %w", err)
-			}
-			agentID = int(id)
-			break
-		}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logger.Debug("Azure DevOps API is not reachable", zap.Error(err), zap.String("organization", c.OrganizationName))
+		return &RequestError{Err: fmt.Errorf("failed to send HTTP request: %w", err)}
 	}
-	if agentID == 0 {
-		c.logger.Error("Agent not found", zap.Error(fmt.Errorf("agent not found")), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("agent with name '%s' not found", agentName)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		c.logger.Debug("Azure DevOps API returned unexpected status code", zap.Int("statusCode", resp.StatusCode), zap.String("organization", c.OrganizationName))
+		return &RequestError{StatusCode: resp.StatusCode, Err: fmt.Errorf("failed to list pools: status code %d", resp.StatusCode)}
 	}
+	return nil
+}
 
-	// Construct the API URL to remove the agent
-	url = fmt.Sprintf("https://dev.azure.com/%s/_apis/distributedtask/pools/%s/agents/%s?api-version=7.1-preview.1", c.OrganizationName, strconv.Itoa(poolID), strconv.Itoa(agentID))
+// GetOnlineAgents returns the enabled agents of the pool which are connected to Azure DevOps, their HostName is set
+// from the host name capabilities
+func (c *AzureDevopsController) GetOnlineAgents(ctx context.Context, poolName string) ([]Agent, error) {
+	poolID, err := c.getPoolIDFromName(ctx, c.OrganizationName, poolName)
+	if err != nil {
+		c.logger.Error("Error getting pool ID", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
+		return nil, fmt.Errorf("failed to get pool ID from name: %w", err)
+	}
 
-	// Create the HTTP request
-	req, err = http.NewRequest("DELETE", url, nil)
+	registered, err := c.listAgents(ctx, poolID, poolName)
 	if err != nil {
-		c.logger.Error("Error creating HTTP DELETE request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, err
 	}
+	var agents []Agent
+	for _, candidate := range registered {
+		if !candidate.Enabled || candidate.Status != "online" {
+			continue
+		}
+		agent := Agent{Name: candidate.Name}
+		for _, capability := range hostNameCapabilities {
+			if hostName := candidate.SystemCapabilities[capability]; hostName != "" {
+				agent.HostName = hostName
+				break
+			}
+		}
+		agents = append(agents, agent)
+	}
+	c.logger.Debug("Listed online agents", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.Int("onlineAgents", len(agents)))
+	return agents, nil
+}
 
-	// Add headers
-	req.SetBasicAuth("", c.AccessToken)
+// GetBusyAgentCount returns the number of agents of the pool which run a job
+func (c *AzureDevopsController) GetBusyAgentCount(ctx context.Context, poolName string) (int, error) {
+	poolID, err := c.getPoolIDFromName(ctx, c.OrganizationName, poolName)
+	if err != nil {
+		c.logger.Error("Error getting pool ID", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
+		return 0, fmt.Errorf("failed to get pool ID from name: %w", err)
+	}
 
-	// Send the request
-	resp, err = client.Do(req)
+	registered, err := c.listAgents(ctx, poolID, poolName)
 	if err != nil {
-		c.logger.Error("Error sending HTTP DELETE request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to send HTTP request: %w", err)
+		return 0, err
 	}
-	defer resp.Body.Close()
+	busy := 0
+	for _, candidate := range registered {
+		if candidate.AssignedRequest != nil {
+			busy++
+		}
+	}
+	c.logger.Debug("Counted busy agents", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.Int("busyAgents", busy))
+	return busy, nil
+}
 
-	// Check the response status
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		c.logger.Error("Failed to remove agent", zap.Error(fmt.Errorf("unexpected status code")), zap.Int("statusCode", resp.StatusCode), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-		return fmt.Errorf("failed to remove agent: status code %d", resp.StatusCode)
+// GetQueuedJobCount returns the number of jobs which wait in the pool for an agent
+func (c *AzureDevopsController) GetQueuedJobCount(ctx context.Context, poolName string) (int, error) {
+	poolID, err := c.getPoolIDFromName(ctx, c.OrganizationName, poolName)
+	if err != nil {
+		c.logger.Error("Error getting pool ID", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
+		return 0, fmt.Errorf("failed to get pool ID from name: %w", err)
 	}
 
-	c.logger.Debug("Agent successfully removed", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agentName))
-	return nil
+	// a job request is queued until it is assigned to an agent, a cancelled request is finished without being assigned
+	type jobRequest struct {
+		AssignTime string `json:"assignTime"`
+		FinishTime string `json:"finishTime"`
+		Result     string `json:"result"`
+	}
+	requests, err := listAll[jobRequest](ctx, c, fmt.Sprintf("pools/%d/jobrequests", poolID), "", "job requests", poolName)
+	if err != nil {
+		return 0, err
+	}
+	queued := 0
+	for _, request := range requests {
+		if request.AssignTime == "" && request.FinishTime == "" && request.Result == "" {
+			queued++
+		}
+	}
+	c.logger.Debug("Counted queued jobs", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.Int("queuedJobs", queued))
+	return queued, nil
 }
 
-func (c *AzureDevopsController) getPoolIDFromName(organization, poolName string) (int, error) {
-	// Construct the API URL to list pools
-	url := fmt.Sprintf("https://dev.azure.com/%s/_apis/distributedtask/pools?api-version=7.1-preview.1", organization)
+// registeredAgent is an agent as it is listed by the Azure DevOps API
+type registeredAgent struct {
+	ID                 json.Number       `json:"id"`
+	Name               string            `json:"name"`
+	Status             string            `json:"status"`
+	Enabled            bool              `json:"enabled"`
+	SystemCapabilities map[string]string `json:"systemCapabilities"`
+	// AssignedRequest is the job the agent runs, it is nil for an idle agent
+	AssignedRequest *struct {
+		RequestID json.Number `json:"requestId"`
+	} `json:"assignedRequest"`
+}
 
-	// Send the request
-	client := c.httpClient
+// listAgents returns the agents registered in the pool with their capabilities
+func (c *AzureDevopsController) listAgents(ctx context.Context, poolID int, poolName string) ([]registeredAgent, error) {
+	return listAll[registeredAgent](ctx, c, fmt.Sprintf("pools/%d/agents", poolID), "includeCapabilities=true&includeAssignedRequest=true", "agents", poolName)
+}
 
-	// Create the HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+// getAgentID looks up the agent by its registered name first, and falls back to its host name capabilities
+func (c *AzureDevopsController) getAgentID(ctx context.Context, poolID int, poolName string, agent Agent) (int, error) {
+	registered, err := c.listAgents(ctx, poolID, poolName)
 	if err != nil {
-		c.logger.Error("Error creating HTTP request", zap.Error(err), zap.String("organization", organization), zap.String("poolName", poolName))
-		return 0, fmt.Errorf("failed to create HTTP request: %w", err)
+		return 0, err
 	}
 
-	// Add headers
-	req.SetBasicAuth("", c.AccessToken)
+	// Find the agent ID by name, then by host name
+	var agentID json.Number
+	for _, candidate := range registered {
+		if candidate.Name == agent.Name {
+			agentID = candidate.ID
+			break
+		}
+	}
+	if agentID == "" && agent.HostName != "" {
+		for _, candidate := range registered {
+			for _, capability := range hostNameCapabilities {
+				if candidate.SystemCapabilities[capability] == agent.HostName {
+					agentID = candidate.ID
+					break
+				}
+			}
+			if agentID != "" {
+				c.logger.Debug("Agent found by host name capability", zap.String("poolName", poolName), zap.String("agentName", candidate.Name), zap.String("hostName", agent.HostName))
+				break
+			}
+		}
+	}
+	if agentID == "" {
+		c.logger.Error("Agent not found", zap.Error(fmt.Errorf("agent not found")), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name), zap.String("hostName", agent.HostName))
+		return 0, &RequestError{StatusCode: http.StatusNotFound, Err: fmt.Errorf("agent with name '%s' not found", agent.Name)}
+	}
 
-	resp, err := client.Do(req)
+	id, err := agentID.Int64()
 	if err != nil {
-		c.logger.Error("Error sending HTTP request", zap.Error(err), zap.String("organization", organization), zap.String("poolName", poolName))
-		return 0, fmt.Errorf("failed to send HTTP request: %w", err)
+		c.logger.Error("Error converting agent ID to int", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
+		return 0, fmt.Errorf("failed to convert agent ID to int: %w", err)
 	}
-	defer resp.Body.Close()
+	return int(id), nil
+}
 
-	// Check the response status
-	if resp.StatusCode != http.StatusOK {
-		c.logger.Error("Failed to list pools", zap.Error(fmt.Errorf("unexpected status code")), zap.Int("statusCode", resp.StatusCode), zap.String("organization", organization), zap.String("poolName", poolName))
-		return 0, fmt.Errorf("failed to list pools: status code %d", resp.StatusCode)
+func (c *AzureDevopsController) getPoolIDFromName(ctx context.Context, organization, poolName string) (int, error) {
+	if err := ValidatePoolName(poolName); err != nil {
+		c.logger.Error("Invalid pool name", zap.Error(err), zap.String("organization", organization), zap.String("poolName", poolName))
+		return 0, err
 	}
-
-	// Parse the response body
-	var response struct {
-		Value []struct {
-			ID   json.Number `json:"id"`
-			Name string      `json:"name"`
-		} `json:"value"`
+	// List only the pool of the name, Azure DevOps matches it case-insensitively
+	type agentPool struct {
+		ID   json.Number `json:"id"`
+		Name string      `json:"name"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		c.logger.Error("Error decoding response body", zap.Error(err), zap.String("organization", organization), zap.String("poolName", poolName))
-		return 0, fmt.Errorf("failed to decode response body: %w", err)
+	pools, err := listAll[agentPool](ctx, c, "pools", "poolName="+url.QueryEscape(poolName), "pools", poolName)
+	if err != nil {
+		return 0, err
 	}
 
 	// Find the pool ID by name
-	for _, pool := range response.Value {
-		if pool.Name == poolName {
+	for _, pool := range pools {
+		if strings.EqualFold(pool.Name, poolName) {
 			id, err := pool.ID.Int64()
 			if err != nil {
 				c.logger.Error("Error converting pool ID to int", zap.Error(err), zap.String("organization", organization), zap.String("poolName", poolName))
@@ -297,5 +449,69 @@ func (c *AzureDevopsController) getPoolIDFromName(organization, poolName string)
 	}
 
 	c.logger.Error("Pool not found", zap.Error(fmt.Errorf("pool not found")), zap.String("organization", organization), zap.String("poolName", poolName))
-	return 0, fmt.Errorf("pool with name '%s' not found", poolName)
+	return 0, &RequestError{StatusCode: http.StatusNotFound, Err: fmt.Errorf("pool with name '%s' not found", poolName)}
+}
+
+// listAll returns the entries of every page of the list at path. Azure DevOps sends a list with more entries than fit
+// into a response in pages, every page but the last has a continuation token which is sent back to get the next one.
+func listAll[T any](ctx context.Context, c *AzureDevopsController, path, query, entries, poolName string) ([]T, error) {
+	var values []T
+	seen := map[string]bool{}
+	token := ""
+	for {
+		pageQuery := query
+		if token != "" {
+			if pageQuery != "" {
+				pageQuery += "&"
+			}
+			pageQuery += "continuationToken=" + url.QueryEscape(token)
+		}
+		page, next, err := listPage[T](ctx, c, c.apiURL(path, pageQuery), entries, poolName)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, page...)
+		if next == "" {
+			return values, nil
+		}
+		// a token returned twice would request the same pages forever
+		if seen[next] {
+			c.logger.Error("Continuation token repeated", zap.String("organization", c.OrganizationName), zap.String("poolName", poolName), zap.String("continuationToken", next))
+			return nil, fmt.Errorf("failed to list %s: continuation token '%s' was returned twice", entries, next)
+		}
+		seen[next] = true
+		token = next
+	}
+}
+
+// listPage returns the entries of one page of a list and the continuation token of the next page, the token is empty
+// on the last page
+func listPage[T any](ctx context.Context, c *AzureDevopsController, pageURL, entries, poolName string) ([]T, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		c.logger.Error("Error creating HTTP request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
+		return nil, "", fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.SetBasicAuth("", c.AccessToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logger.Error("Error sending HTTP request", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
+		return nil, "", &RequestError{Err: fmt.Errorf("failed to send HTTP request: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		c.logger.Error("Failed to list "+entries, zap.Error(fmt.Errorf("unexpected status code")), zap.Int("statusCode", resp.StatusCode), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
+		return nil, "", &RequestError{StatusCode: resp.StatusCode, Err: fmt.Errorf("failed to list %s: status code %d", entries, resp.StatusCode)}
+	}
+
+	var response struct {
+		Value []T `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		c.logger.Error("Error decoding response body", zap.Error(err), zap.String("organization", c.OrganizationName), zap.String("poolName", poolName))
+		return nil, "", fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return response.Value, resp.Header.Get(continuationTokenHeader), nil
 }
@@ -0,0 +1,278 @@
+package azuredevops
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+)
+
+// recordingDoer answers every request with an empty list and records the URLs
+type recordingDoer struct {
+	urls []string
+}
+
+func (d *recordingDoer) Do(req *http.Request) (*http.Response, error) {
+	d.urls = append(d.urls, req.URL.String())
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"value":[]}`))}, nil
+}
+
+func TestCheckConnection_ServerURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverURL   string
+		expectedURL string
+	}{
+		{"Azure DevOps Services", "", "https://dev.azure.com/my-org/_apis/distributedtask/pools?$top=1&api-version=7.1"},
+		{"Azure DevOps Server collection", "https://tfs.contoso.com/tfs/", "https://tfs.contoso.com/tfs/my-org/_apis/distributedtask/pools?$top=1&api-version=5.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doer := &recordingDoer{}
+			controller := NewAzureDevopsController(doer, "my-org", "pat", zaptest.NewLogger(t)).WithServerURL(tt.serverURL)
+
+			if err := controller.CheckConnection(context.Background()); err != nil {
+				t.Fatalf("CheckConnection returned error: %v", err)
+			}
+
+			if len(doer.urls) != 1 || doer.urls[0] != tt.expectedURL {
+				t.Errorf("expected a request to %s, got %v", tt.expectedURL, doer.urls)
+			}
+		})
+	}
+}
+
+func TestGetOnlineAgents_CancelledContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("expected no request with a cancelled context")
+	}))
+	defer server.Close()
+	controller := NewAzureDevopsController(server.Client(), "my-org", "pat", zaptest.NewLogger(t)).WithServerURL(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := controller.GetOnlineAgents(ctx, "pool")
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation to be returned, got %v", err)
+	}
+}
+
+func TestGetOnlineAgents_ExoticPoolNames(t *testing.T) {
+	tests := []struct {
+		name         string
+		organization string
+		poolName     string
+		serverPool   string
+		expectedPath string
+	}{
+		{"space", "my-org", "Linux Agents", "Linux Agents", "/my-org/_apis/distributedtask/pools"},
+		{"unicode", "my-org", "Ünïcödé 池", "Ünïcödé 池", "/my-org/_apis/distributedtask/pools"},
+		{"reserved characters", "my-org", "a&b=c#d?e+f/g", "a&b=c#d?e+f/g", "/my-org/_apis/distributedtask/pools"},
+		{"different case", "my-org", "linux agents", "Linux Agents", "/my-org/_apis/distributedtask/pools"},
+		{"collection with a space", "Default Collection", "Linux Agents", "Linux Agents", "/Default%20Collection/_apis/distributedtask/pools"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var poolQueries []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.EscapedPath() == tt.expectedPath:
+					poolQueries = append(poolQueries, r.URL.Query().Get("poolName"))
+					_, _ = io.WriteString(w, `{"value":[{"id":3,"name":`+strconv.Quote(tt.serverPool)+`}]}`)
+				case strings.HasSuffix(r.URL.Path, "/pools/3/agents"):
+					_, _ = io.WriteString(w, `{"value":[{"id":7,"name":"agent","status":"online","enabled":true}]}`)
+				default:
+					t.Errorf("unexpected request to %s", r.URL.EscapedPath())
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+			controller := NewAzureDevopsController(server.Client(), tt.organization, "pat", zaptest.NewLogger(t)).WithServerURL(server.URL)
+
+			agents, err := controller.GetOnlineAgents(context.Background(), tt.poolName)
+
+			if err != nil {
+				t.Fatalf("GetOnlineAgents returned error: %v", err)
+			}
+			if len(agents) != 1 || agents[0].Name != "agent" {
+				t.Errorf("expected the agent of the pool, got %v", agents)
+			}
+			if len(poolQueries) != 1 || poolQueries[0] != tt.poolName {
+				t.Errorf("expected the pool to be looked up by the name %q, got %q", tt.poolName, poolQueries)
+			}
+		})
+	}
+}
+
+func TestValidatePoolName(t *testing.T) {
+	tests := []struct {
+		name     string
+		poolName string
+		valid    bool
+	}{
+		{"plain", "Default", true},
+		{"space inside", "Linux Agents", true},
+		{"unicode", "Ünïcödé 池", true},
+		{"reserved characters", "a&b=c#d?", true},
+		{"empty", "", false},
+		{"only spaces", "   ", false},
+		{"trailing newline", "Default\n", false},
+		{"leading space", " Default", false},
+		{"control character", "De\x00fault", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePoolName(tt.poolName)
+
+			if tt.valid && err != nil {
+				t.Errorf("expected %q to be valid, got %v", tt.poolName, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidPoolName) {
+				t.Errorf("expected %q to be rejected with ErrInvalidPoolName, got %v", tt.poolName, err)
+			}
+		})
+	}
+}
+
+func TestGetOnlineAgents_InvalidPoolNameSendsNoRequest(t *testing.T) {
+	doer := &recordingDoer{}
+	controller := NewAzureDevopsController(doer, "my-org", "pat", zaptest.NewLogger(t))
+
+	_, err := controller.GetOnlineAgents(context.Background(), "Default\n")
+
+	if !errors.Is(err, ErrInvalidPoolName) {
+		t.Errorf("expected ErrInvalidPoolName, got %v", err)
+	}
+	if len(doer.urls) != 0 {
+		t.Errorf("expected no request, got %v", doer.urls)
+	}
+}
+
+// fixtureServer serves the recorded responses of testdata, a page is chosen by the path and the continuation token of
+// the request. The next token of a page is sent in the continuation token header.
+type fixtureServer struct {
+	t     *testing.T
+	pages map[string]fixturePage
+}
+
+type fixturePage struct {
+	file      string
+	nextToken string
+}
+
+func newFixtureServer(t *testing.T, pages map[string]fixturePage) *httptest.Server {
+	fixtures := &fixtureServer{t: t, pages: pages}
+	server := httptest.NewServer(fixtures)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *fixtureServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if version := r.URL.Query().Get("api-version"); version != serverAPIVersion {
+		s.t.Errorf("expected api-version %s, got %s", serverAPIVersion, version)
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/my-org/_apis/distributedtask/")
+	if token := r.URL.Query().Get("continuationToken"); token != "" {
+		key += "?" + token
+	}
+	page, found := s.pages[key]
+	if !found {
+		s.t.Errorf("unexpected request to %s", r.URL)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := os.ReadFile(filepath.Join("testdata", page.file))
+	if err != nil {
+		s.t.Errorf("failed to read fixture: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if page.nextToken != "" {
+		w.Header().Set(continuationTokenHeader, page.nextToken)
+	}
+	_, _ = w.Write(body)
+}
+
+func TestGetOnlineAgents_FollowsContinuationTokens(t *testing.T) {
+	server := newFixtureServer(t, map[string]fixturePage{
+		"pools":                                 {file: "pools.json"},
+		"pools/12/agents":                       {file: "agents_page1.json", nextToken: "102;agent-7d9f8-fghij"},
+		"pools/12/agents?102;agent-7d9f8-fghij": {file: "agents_page2.json"},
+	})
+	controller := NewAzureDevopsController(server.Client(), "my-org", "pat", zaptest.NewLogger(t)).WithServerURL(server.URL)
+
+	agents, err := controller.GetOnlineAgents(context.Background(), "Linux Agents")
+
+	if err != nil {
+		t.Fatalf("GetOnlineAgents returned error: %v", err)
+	}
+	expected := []Agent{
+		{Name: "agent-7d9f8-abcde", HostName: "agent-7d9f8-abcde"},
+		{Name: "agent-7d9f8-fghij", HostName: "agent-7d9f8-fghij"},
+		{Name: "custom-agent-name", HostName: "agent-7d9f8-pqrst"},
+	}
+	if !reflect.DeepEqual(agents, expected) {
+		t.Errorf("expected the online agents of both pages %v, got %v", expected, agents)
+	}
+}
+
+func TestGetBusyAgentCount_FollowsContinuationTokens(t *testing.T) {
+	server := newFixtureServer(t, map[string]fixturePage{
+		"pools":                                 {file: "pools.json"},
+		"pools/12/agents":                       {file: "agents_page1.json", nextToken: "102;agent-7d9f8-fghij"},
+		"pools/12/agents?102;agent-7d9f8-fghij": {file: "agents_page2.json"},
+	})
+	controller := NewAzureDevopsController(server.Client(), "my-org", "pat", zaptest.NewLogger(t)).WithServerURL(server.URL)
+
+	busy, err := controller.GetBusyAgentCount(context.Background(), "Linux Agents")
+
+	if err != nil {
+		t.Fatalf("GetBusyAgentCount returned error: %v", err)
+	}
+	if busy != 2 {
+		t.Errorf("expected the agents with an assigned request on both pages to be busy, got %d", busy)
+	}
+}
+
+func TestGetQueuedJobCount_FollowsContinuationTokens(t *testing.T) {
+	server := newFixtureServer(t, map[string]fixturePage{
+		"pools":                     {file: "pools.json"},
+		"pools/12/jobrequests":      {file: "jobrequests_page1.json", nextToken: "5503"},
+		"pools/12/jobrequests?5503": {file: "jobrequests_page2.json"},
+	})
+	controller := NewAzureDevopsController(server.Client(), "my-org", "pat", zaptest.NewLogger(t)).WithServerURL(server.URL)
+
+	queued, err := controller.GetQueuedJobCount(context.Background(), "Linux Agents")
+
+	if err != nil {
+		t.Fatalf("GetQueuedJobCount returned error: %v", err)
+	}
+	if queued != 2 {
+		t.Errorf("expected the unassigned and unfinished requests of both pages to be queued, got %d", queued)
+	}
+}
+
+func TestGetOnlineAgents_RepeatedContinuationToken(t *testing.T) {
+	server := newFixtureServer(t, map[string]fixturePage{
+		"pools":                {file: "pools.json"},
+		"pools/12/agents":      {file: "agents_page1.json", nextToken: "loop"},
+		"pools/12/agents?loop": {file: "agents_page2.json", nextToken: "loop"},
+	})
+	controller := NewAzureDevopsController(server.Client(), "my-org", "pat", zaptest.NewLogger(t)).WithServerURL(server.URL)
+
+	_, err := controller.GetOnlineAgents(context.Background(), "Linux Agents")
+
+	if err == nil || !strings.Contains(err.Error(), "continuation token 'loop' was returned twice") {
+		t.Errorf("expected the repeated continuation token to fail the listing, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package azuredevops
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"norbinto/node-updater/internal/egress"
+)
+
+var _ AzureDevopsControllerInterface = &ReloadableController{}
+
+// ErrNotConfigured is returned by a ReloadableController without an organization or an access token
+var ErrNotConfigured = errors.New("azure DevOps organization or access token is not configured")
+
+// Credentials identify the Azure DevOps organization and the personal access token used for it
+type Credentials struct {
+	// ServerURL is the address of an Azure DevOps Server whose collection is the OrganizationName, empty for Azure
+	// DevOps Services
+	ServerURL        string
+	OrganizationName string
+	AccessToken      string
+}
+
+// IsComplete returns true when both the organization and the access token are set
+func (c Credentials) IsComplete() bool {
+	return c.OrganizationName != "" && c.AccessToken != ""
+}
+
+// ReloadableController is an AzureDevopsControllerInterface whose credentials are replaced at runtime, e.g. when the
+// NodeUpdaterConfig changes. The calls which already started finish with the previous credentials.
+type ReloadableController struct {
+	httpClient egress.Doer
+	logger     *zap.Logger
+
+	mu          sync.RWMutex
+	credentials Credentials
+	controller  *AzureDevopsController
+}
+
+func NewReloadableController(client egress.Doer, credentials Credentials, logger *zap.Logger) *ReloadableController {
+	c := &ReloadableController{httpClient: client, logger: logger}
+	c.Configure(credentials)
+	return c
+}
+
+// Configure replaces the credentials, incomplete credentials disable the Azure DevOps integration
+func (c *ReloadableController) Configure(credentials Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if credentials == c.credentials {
+		return
+	}
+	c.credentials = credentials
+	if !credentials.IsComplete() {
+		c.controller = nil
+		c.logger.Info("Azure DevOps integration is disabled, the organization or the access token is not configured")
+		return
+	}
+	c.controller = NewAzureDevopsController(c.httpClient, credentials.OrganizationName, credentials.AccessToken, c.logger).
+		WithServerURL(credentials.ServerURL)
+	c.logger.Info("Azure DevOps integration is configured", zap.String("server", c.controller.ServerURL), zap.String("organization", credentials.OrganizationName))
+}
+
+// Configured returns true when the controller has complete credentials
+func (c *ReloadableController) Configured() bool {
+	return c.current() != nil
+}
+
+func (c *ReloadableController) current() *AzureDevopsController {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.controller
+}
+
+func (c *ReloadableController) DisableAgent(ctx context.Context, poolName string, agent Agent) error {
+	controller := c.current()
+	if controller == nil {
+		return ErrNotConfigured
+	}
+	return controller.DisableAgent(ctx, poolName, agent)
+}
+
+func (c *ReloadableController) EnableAgent(ctx context.Context, poolName string, agent Agent) error {
+	controller := c.current()
+	if controller == nil {
+		return ErrNotConfigured
+	}
+	return controller.EnableAgent(ctx, poolName, agent)
+}
+
+func (c *ReloadableController) RemoveAgent(ctx context.Context, poolName string, agent Agent) error {
+	controller := c.current()
+	if controller == nil {
+		return ErrNotConfigured
+	}
+	return controller.RemoveAgent(ctx, poolName, agent)
+}
+
+func (c *ReloadableController) CheckConnection(ctx context.Context) error {
+	controller := c.current()
+	if controller == nil {
+		return ErrNotConfigured
+	}
+	return controller.CheckConnection(ctx)
+}
+
+func (c *ReloadableController) GetOnlineAgents(ctx context.Context, poolName string) ([]Agent, error) {
+	controller := c.current()
+	if controller == nil {
+		return nil, ErrNotConfigured
+	}
+	return controller.GetOnlineAgents(ctx, poolName)
+}
+
+func (c *ReloadableController) GetQueuedJobCount(ctx context.Context, poolName string) (int, error) {
+	controller := c.current()
+	if controller == nil {
+		return 0, ErrNotConfigured
+	}
+	return controller.GetQueuedJobCount(ctx, poolName)
+}
+
+func (c *ReloadableController) GetBusyAgentCount(ctx context.Context, poolName string) (int, error) {
+	controller := c.current()
+	if controller == nil {
+		return 0, ErrNotConfigured
+	}
+	return controller.GetBusyAgentCount(ctx, poolName)
+}
+
+// IsEnabled returns true when the Azure DevOps integration can be used: the controller is set and, when it is
+// reloadable, it is configured
+func IsEnabled(controller AzureDevopsControllerInterface) bool {
+	if reloadable, ok := controller.(*ReloadableController); ok {
+		return reloadable != nil && reloadable.Configured()
+	}
+	return controller != nil
+}
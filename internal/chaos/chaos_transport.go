@@ -0,0 +1,118 @@
+package chaos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"norbinto/node-updater/internal/egress"
+)
+
+const (
+	// delayedProvisioningState is reported instead of Succeeded when a provisioning state is delayed
+	delayedProvisioningState = "Updating"
+	// retryAfterSeconds is sent with the injected 429 responses
+	retryAfterSeconds = "1"
+)
+
+type failure string
+
+const (
+	failureTooManyRequests failure = "TooManyRequests"
+	failureConflict        failure = "Conflict"
+	failureTimeout         failure = "Timeout"
+)
+
+var failures = []failure{failureTooManyRequests, failureConflict, failureTimeout}
+
+// Transport injects failures into the HTTP calls to ARM and Azure DevOps, it is used to soak-test the reconciler.
+// Failed requests are never sent, so the injected failures do not change anything in Azure.
+type Transport struct {
+	next egress.Doer
+	// failureRate is the probability of answering a request with a 429, a 409 or a timeout
+	failureRate float64
+	// delayRate is the probability of reporting a Succeeded provisioning state as Updating
+	delayRate float64
+	random    func() float64
+	logger    *zap.Logger
+}
+
+func NewTransport(next egress.Doer, failureRate, delayRate float64, logger *zap.Logger) *Transport {
+	return &Transport{next: next, failureRate: failureRate, delayRate: delayRate, random: rand.Float64, logger: logger}
+}
+
+// Do implements policy.Transporter and egress.Doer
+func (t *Transport) Do(req *http.Request) (*http.Response, error) {
+	if t.random() < t.failureRate {
+		injected := failures[int(t.random()*float64(len(failures)))%len(failures)]
+		t.logger.Info("Injecting failure", zap.String("failure", string(injected)), zap.String("method", req.Method), zap.String("url", req.URL.String()))
+		switch injected {
+		case failureTooManyRequests:
+			response := newResponse(req, http.StatusTooManyRequests, "TooManyRequests")
+			response.Header.Set("Retry-After", retryAfterSeconds)
+			return response, nil
+		case failureConflict:
+			return newResponse(req, http.StatusConflict, "OperationNotAllowed"), nil
+		default:
+			return nil, fmt.Errorf("chaos: %s %s: %w", req.Method, req.URL.String(), context.DeadlineExceeded)
+		}
+	}
+
+	response, err := t.next.Do(req)
+	if err != nil || req.Method != http.MethodGet || response.StatusCode != http.StatusOK || t.random() >= t.delayRate {
+		return response, err
+	}
+	return t.delayProvisioningState(req, response), nil
+}
+
+// delayProvisioningState rewrites a Succeeded provisioning state in the response body to Updating, responses
+// without a provisioning state are returned unchanged
+func (t *Transport) delayProvisioningState(req *http.Request, response *http.Response) *http.Response {
+	body, err := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return response
+	}
+
+	resource := map[string]any{}
+	if err := json.Unmarshal(body, &resource); err != nil {
+		return response
+	}
+	properties, ok := resource["properties"].(map[string]any)
+	if !ok || properties["provisioningState"] != "Succeeded" {
+		return response
+	}
+	properties["provisioningState"] = delayedProvisioningState
+	delayedBody, err := json.Marshal(resource)
+	if err != nil {
+		return response
+	}
+
+	t.logger.Info("Delaying provisioning state", zap.String("url", req.URL.String()))
+	response.Body = io.NopCloser(bytes.NewReader(delayedBody))
+	response.ContentLength = int64(len(delayedBody))
+	response.Header.Del("Content-Length")
+	return response
+}
+
+func newResponse(req *http.Request, statusCode int, code string) *http.Response {
+	body, _ := json.Marshal(map[string]any{"error": map[string]string{"code": code, "message": "injected by chaos mode"}})
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("x-ms-error-code", code)
+	return &http.Response{
+		StatusCode:    statusCode,
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
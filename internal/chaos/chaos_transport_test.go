@@ -0,0 +1,98 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+)
+
+type fakeDoer struct {
+	body  string
+	calls int
+}
+
+func (f *fakeDoer) Do(req *http.Request) (*http.Response, error) {
+	f.calls++
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(f.body)), Request: req}, nil
+}
+
+// sequence returns the values in order, it replaces the random source of the transport
+func sequence(values ...float64) func() float64 {
+	return func() float64 {
+		value := values[0]
+		values = values[1:]
+		return value
+	}
+}
+
+func TestTransport_InjectsFailuresWithoutSendingRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		pick       float64
+		statusCode int
+		timeout    bool
+	}{
+		{name: "too many requests", pick: 0.1, statusCode: http.StatusTooManyRequests},
+		{name: "conflict", pick: 0.5, statusCode: http.StatusConflict},
+		{name: "timeout", pick: 0.9, timeout: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &fakeDoer{}
+			transport := NewTransport(next, 0.5, 0, zaptest.NewLogger(t))
+			transport.random = sequence(0.1, tt.pick)
+			req, _ := http.NewRequest(http.MethodPut, "https://management.azure.com/agentPools/pool", nil)
+
+			response, err := transport.Do(req)
+			if tt.timeout {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("expected a deadline exceeded error, got %v", err)
+				}
+			} else if err != nil || response.StatusCode != tt.statusCode {
+				t.Errorf("expected status %d, got %v, %v", tt.statusCode, response, err)
+			}
+			if next.calls != 0 {
+				t.Errorf("expected the request not to be sent, got %d calls", next.calls)
+			}
+		})
+	}
+}
+
+func TestTransport_DelaysSucceededProvisioningState(t *testing.T) {
+	next := &fakeDoer{body: `{"name":"pool","properties":{"provisioningState":"Succeeded"}}`}
+	transport := NewTransport(next, 0.5, 0.5, zaptest.NewLogger(t))
+	transport.random = sequence(0.9, 0.1)
+	req, _ := http.NewRequest(http.MethodGet, "https://management.azure.com/agentPools/pool", nil)
+
+	response, err := transport.Do(req)
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	if !strings.Contains(string(body), `"provisioningState":"Updating"`) {
+		t.Errorf("expected the provisioning state to be delayed, got %s", body)
+	}
+	if next.calls != 1 {
+		t.Errorf("expected the request to be sent once, got %d calls", next.calls)
+	}
+}
+
+func TestTransport_PassesThroughWhenDisabled(t *testing.T) {
+	next := &fakeDoer{body: `{"properties":{"provisioningState":"Succeeded"}}`}
+	transport := NewTransport(next, 0, 0, zaptest.NewLogger(t))
+	req, _ := http.NewRequest(http.MethodGet, "https://dev.azure.com/organization/_apis/distributedtask/pools", nil)
+
+	response, err := transport.Do(req)
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	if string(body) != next.body {
+		t.Errorf("expected the body to be unchanged, got %s", body)
+	}
+}
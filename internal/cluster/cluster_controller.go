@@ -0,0 +1,246 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"norbinto/node-updater/internal/egress"
+	"norbinto/node-updater/internal/impersonation"
+	"norbinto/node-updater/internal/nodepool"
+	"norbinto/node-updater/internal/pod"
+)
+
+const (
+	// KubeconfigSecretKey is the key of the kubeconfig in the Secret of a workload cluster, it follows the Cluster API convention
+	KubeconfigSecretKey = "value"
+	// SubscriptionIDAnnotation holds the subscription of the AKS cluster on the kubeconfig Secret
+	SubscriptionIDAnnotation = "update.norbinto/subscription-id"
+	// ResourceGroupAnnotation holds the resource group of the AKS cluster on the kubeconfig Secret
+	ResourceGroupAnnotation = "update.norbinto/resource-group"
+	// ClusterNameAnnotation holds the name of the AKS cluster on the kubeconfig Secret
+	ClusterNameAnnotation = "update.norbinto/cluster-name"
+	// ClientIDAnnotation holds the client id of the managed identity which is federated with the controller's ServiceAccount,
+	// a Secret without it is refused unless WithControllerCredential allows the controller's own Azure credential
+	ClientIDAnnotation = "update.norbinto/client-id"
+	// TenantIDAnnotation holds the tenant of the managed identity in ClientIDAnnotation
+	TenantIDAnnotation = "update.norbinto/tenant-id"
+)
+
+// WorkloadCluster is an AKS cluster managed from the management cluster
+type WorkloadCluster struct {
+	// Name is the name of the kubeconfig Secret
+	Name                 string
+	KubeClient           kubernetes.Interface
+	ImpersonationFactory *impersonation.ClientFactory
+	DrainSignaler        pod.DrainSignaler
+	AgentPoolClient      nodepool.AgentPoolClientInterface
+	ManagedClusterClient nodepool.ManagedClusterClientInterface
+	ScaleSetVMsClient    nodepool.ScaleSetVMsClientInterface
+	SubscriptionID       string
+	ResourceGroup        string
+	ClusterName          string
+}
+
+type cachedWorkloadCluster struct {
+	resourceVersion string
+	cluster         *WorkloadCluster
+}
+
+// ClusterController builds the clients of workload clusters from kubeconfig Secrets in the management cluster
+type ClusterController struct {
+	kubeClient         kubernetes.Interface
+	newCredential      func(clientID, tenantID string) (azcore.TokenCredential, error)
+	newAgentPoolClient func(subscriptionID string, azureCred azcore.TokenCredential) (nodepool.AgentPoolClientInterface, error)
+	// newManagedClusterClient creates the client which checks the managed cluster before an upgrade
+	newManagedClusterClient func(subscriptionID string, azureCred azcore.TokenCredential) (nodepool.ManagedClusterClientInterface, error)
+	// newScaleSetVMsClient creates the client which reimages single nodes
+	newScaleSetVMsClient func(subscriptionID string, azureCred azcore.TokenCredential) (nodepool.ScaleSetVMsClientInterface, error)
+	// credentialOptions configure the workload identity credentials of the workload clusters, e.g. their transport
+	credentialOptions azcore.ClientOptions
+	// drainSignalClient sends the HTTP drain signals to the pods of the workload clusters
+	drainSignalClient *http.Client
+	// controllerCredential allows the controller's own Azure credential for Secrets without federation annotations
+	controllerCredential bool
+	logger               *zap.Logger
+
+	mu           sync.Mutex
+	clusterCache map[types.UID]cachedWorkloadCluster
+}
+
+// NewClusterController creates a ClusterController. The workload clusters get a workload identity credential which
+// exchanges the token in federatedTokenFile, azureCred is only used for those without federation annotations once
+// WithDrainSignalClient sets the client which sends the HTTP drain signals to the pods of the workload clusters, e.g.
+// with the timeouts of the outbound calls, and returns the ClusterController
+func (c *ClusterController) WithDrainSignalClient(httpClient *http.Client) *ClusterController {
+	c.drainSignalClient = httpClient
+	return c
+}
+
+// WithControllerCredential allows it. armOptions is passed to
+// the agent pool clients of the workload clusters, it can be nil.
+func NewClusterController(kubeClient kubernetes.Interface, azureCred azcore.TokenCredential, federatedTokenFile string, armOptions *arm.ClientOptions, logger *zap.Logger) *ClusterController {
+	controller := &ClusterController{
+		kubeClient: kubeClient,
+		newAgentPoolClient: func(subscriptionID string, azureCred azcore.TokenCredential) (nodepool.AgentPoolClientInterface, error) {
+			return armcontainerservice.NewAgentPoolsClient(subscriptionID, azureCred, armOptions)
+		},
+		newManagedClusterClient: func(subscriptionID string, azureCred azcore.TokenCredential) (nodepool.ManagedClusterClientInterface, error) {
+			return armcontainerservice.NewManagedClustersClient(subscriptionID, azureCred, armOptions)
+		},
+		newScaleSetVMsClient: func(subscriptionID string, azureCred azcore.TokenCredential) (nodepool.ScaleSetVMsClientInterface, error) {
+			return armcompute.NewVirtualMachineScaleSetVMsClient(subscriptionID, azureCred, armOptions)
+		},
+		drainSignalClient: egress.DefaultTimeouts.NewClient(nil),
+		logger:            logger,
+		clusterCache:      make(map[types.UID]cachedWorkloadCluster),
+	}
+	controller.newCredential = func(clientID, tenantID string) (azcore.TokenCredential, error) {
+		if clientID == "" {
+			return azureCred, nil
+		}
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: controller.credentialOptions,
+			ClientID:      clientID,
+			TenantID:      tenantID,
+			TokenFilePath: federatedTokenFile,
+		})
+	}
+	return controller
+}
+
+// WithCredentialOptions sets the client options of the workload identity credentials of the workload clusters, e.g.
+// the transport which trusts the CA of a proxy, and returns the ClusterController
+func (c *ClusterController) WithCredentialOptions(options azcore.ClientOptions) *ClusterController {
+	c.credentialOptions = options
+	return c
+}
+
+// WithControllerCredential allows the controller's own Azure credential for the workload clusters whose Secret has no
+// client id and tenant id annotations, and returns the ClusterController. Anyone who can write a Secret in the namespace
+// of a SafeEvict can then point the controller's identity at any cluster it can reach, so it is off by default.
+func (c *ClusterController) WithControllerCredential(allowed bool) *ClusterController {
+	c.controllerCredential = allowed
+	return c
+}
+
+// GetWorkloadClusters returns the workload clusters whose kubeconfig Secret in the namespace matches the selector. A
+// Secret whose clients cannot be created does not hold back the other clusters, its error is returned by the name of
+// the cluster instead.
+func (c *ClusterController) GetWorkloadClusters(ctx context.Context, namespace string, selector *metav1.LabelSelector) ([]*WorkloadCluster, map[string]error, error) {
+	if selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0) {
+		return nil, nil, fmt.Errorf("empty cluster selector would select every Secret in namespace %s", namespace)
+	}
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cluster selector: %w", err)
+	}
+
+	secretList, err := c.kubeClient.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector.String()})
+	if err != nil {
+		c.logger.Error("Failed to list kubeconfig Secrets", zap.Error(err), zap.String("namespace", namespace), zap.String("selector", labelSelector.String()))
+		return nil, nil, fmt.Errorf("failed to list kubeconfig secrets in namespace %s: %w", namespace, err)
+	}
+
+	clusters := make([]*WorkloadCluster, 0, len(secretList.Items))
+	failed := make(map[string]error)
+	for _, secret := range secretList.Items {
+		cluster, err := c.getWorkloadCluster(secret)
+		if err != nil {
+			failed[secret.Name] = err
+			continue
+		}
+		clusters = append(clusters, cluster)
+	}
+	c.logger.Debug("Found workload clusters", zap.Int("clusters", len(clusters)), zap.Int("failed", len(failed)), zap.String("namespace", namespace))
+	return clusters, failed, nil
+}
+
+// getWorkloadCluster returns the cached clients of the Secret, they are rebuilt when the Secret changes
+func (c *ClusterController) getWorkloadCluster(secret corev1.Secret) (*WorkloadCluster, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, exists := c.clusterCache[secret.UID]; exists && cached.resourceVersion == secret.ResourceVersion {
+		return cached.cluster, nil
+	}
+
+	cluster, err := c.newWorkloadCluster(secret)
+	if err != nil {
+		c.logger.Error("Failed to create clients for workload cluster", zap.Error(err), zap.String("secretName", secret.Name), zap.String("namespace", secret.Namespace))
+		return nil, err
+	}
+	c.clusterCache[secret.UID] = cachedWorkloadCluster{resourceVersion: secret.ResourceVersion, cluster: cluster}
+	return cluster, nil
+}
+
+func (c *ClusterController) newWorkloadCluster(secret corev1.Secret) (*WorkloadCluster, error) {
+	subscriptionID := secret.Annotations[SubscriptionIDAnnotation]
+	resourceGroup := secret.Annotations[ResourceGroupAnnotation]
+	clusterName := secret.Annotations[ClusterNameAnnotation]
+	if subscriptionID == "" || resourceGroup == "" || clusterName == "" {
+		return nil, fmt.Errorf("secret '%s' in namespace %s is missing the %s, %s or %s annotation", secret.Name, secret.Namespace, SubscriptionIDAnnotation, ResourceGroupAnnotation, ClusterNameAnnotation)
+	}
+
+	kubeconfig, exists := secret.Data[KubeconfigSecretKey]
+	if !exists {
+		return nil, fmt.Errorf("secret '%s' in namespace %s has no '%s' key", secret.Name, secret.Namespace, KubeconfigSecretKey)
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig of secret '%s' in namespace %s: %w", secret.Name, secret.Namespace, err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kube client for cluster '%s': %w", clusterName, err)
+	}
+
+	clientID, tenantID := secret.Annotations[ClientIDAnnotation], secret.Annotations[TenantIDAnnotation]
+	federated := clientID != "" && tenantID != ""
+	unfederated := clientID == "" && tenantID == ""
+	if !federated && !(unfederated && c.controllerCredential) {
+		return nil, fmt.Errorf("secret '%s' in namespace %s is missing the %s or %s annotation, the controller's own Azure credential is not used for workload clusters", secret.Name, secret.Namespace, ClientIDAnnotation, TenantIDAnnotation)
+	}
+	azureCred, err := c.newCredential(clientID, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure credential for cluster '%s': %w", clusterName, err)
+	}
+	agentPoolClient, err := c.newAgentPoolClient(subscriptionID, azureCred)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent pool client for cluster '%s': %w", clusterName, err)
+	}
+	managedClusterClient, err := c.newManagedClusterClient(subscriptionID, azureCred)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create managed cluster client for cluster '%s': %w", clusterName, err)
+	}
+	scaleSetVMsClient, err := c.newScaleSetVMsClient(subscriptionID, azureCred)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scale set VMs client for cluster '%s': %w", clusterName, err)
+	}
+
+	return &WorkloadCluster{
+		Name:                 secret.Name,
+		KubeClient:           kubeClient,
+		ImpersonationFactory: impersonation.NewClientFactory(rest.CopyConfig(restConfig), c.logger.Named("impersonation")),
+		DrainSignaler:        pod.NewDrainSignaler(kubeClient, c.drainSignalClient),
+		AgentPoolClient:      agentPoolClient,
+		ManagedClusterClient: managedClusterClient,
+		ScaleSetVMsClient:    scaleSetVMsClient,
+		SubscriptionID:       subscriptionID,
+		ResourceGroup:        resourceGroup,
+		ClusterName:          clusterName,
+	}, nil
+}
@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"norbinto/node-updater/internal/nodepool"
+)
+
+const kubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: workload
+  cluster:
+    server: https://workload.example:443
+contexts:
+- name: workload
+  context:
+    cluster: workload
+    user: workload
+current-context: workload
+users:
+- name: workload
+  user:
+    token: token
+`
+
+func newKubeconfigSecret(name string, labels, annotations map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "fleet",
+			UID:             types.UID("uid-" + name),
+			ResourceVersion: "1",
+			Labels:          labels,
+			Annotations:     annotations,
+		},
+		Data: map[string][]byte{KubeconfigSecretKey: []byte(kubeconfig)},
+	}
+}
+
+func newTestClusterController(t *testing.T, objects ...*corev1.Secret) (*ClusterController, *[]string) {
+	kubeClient := fake.NewSimpleClientset()
+	for _, object := range objects {
+		if _, err := kubeClient.CoreV1().Secrets(object.Namespace).Create(context.TODO(), object, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create secret: %v", err)
+		}
+	}
+	controller := NewClusterController(kubeClient, nil, "", nil, zaptest.NewLogger(t))
+	clientIDs := &[]string{}
+	controller.newCredential = func(clientID, tenantID string) (azcore.TokenCredential, error) {
+		*clientIDs = append(*clientIDs, clientID)
+		return nil, nil
+	}
+	controller.newAgentPoolClient = func(subscriptionID string, azureCred azcore.TokenCredential) (nodepool.AgentPoolClientInterface, error) {
+		return nil, nil
+	}
+	return controller, clientIDs
+}
+
+var clusterAnnotations = map[string]string{
+	SubscriptionIDAnnotation: "sub-1",
+	ResourceGroupAnnotation:  "rg-1",
+	ClusterNameAnnotation:    "aks-1",
+	ClientIDAnnotation:       "client-1",
+	TenantIDAnnotation:       "tenant-1",
+}
+
+func TestGetWorkloadClusters_Selector(t *testing.T) {
+	controller, clientIDs := newTestClusterController(t,
+		newKubeconfigSecret("aks-1-kubeconfig", map[string]string{"env": "prod"}, clusterAnnotations),
+		newKubeconfigSecret("aks-2-kubeconfig", map[string]string{"env": "dev"}, clusterAnnotations),
+	)
+
+	clusters, failed, err := controller.GetWorkloadClusters(context.TODO(), "fleet", &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}})
+	if err != nil || len(failed) > 0 {
+		t.Fatalf("GetWorkloadClusters failed: %v, %v", err, failed)
+	}
+	if len(clusters) != 1 || clusters[0].Name != "aks-1-kubeconfig" {
+		t.Fatalf("Expected only the selected cluster, got: %v", clusters)
+	}
+	if clusters[0].SubscriptionID != "sub-1" || clusters[0].ResourceGroup != "rg-1" || clusters[0].ClusterName != "aks-1" {
+		t.Fatalf("Unexpected cluster coordinates: %+v", clusters[0])
+	}
+	if len(*clientIDs) != 1 || (*clientIDs)[0] != "client-1" {
+		t.Fatalf("Expected the federated client id to be used, got: %v", *clientIDs)
+	}
+
+	// the clients are cached until the Secret changes
+	if _, _, err := controller.GetWorkloadClusters(context.TODO(), "fleet", &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}); err != nil {
+		t.Fatalf("GetWorkloadClusters failed: %v", err)
+	}
+	if len(*clientIDs) != 1 {
+		t.Fatalf("Expected cached clients to be reused, got %d credentials", len(*clientIDs))
+	}
+}
+
+func TestGetWorkloadClusters_ControllerCredential(t *testing.T) {
+	unfederated := map[string]string{
+		SubscriptionIDAnnotation: "sub-1",
+		ResourceGroupAnnotation:  "rg-1",
+		ClusterNameAnnotation:    "aks-1",
+	}
+	clientOnly := map[string]string{
+		SubscriptionIDAnnotation: "sub-1",
+		ResourceGroupAnnotation:  "rg-1",
+		ClusterNameAnnotation:    "aks-1",
+		ClientIDAnnotation:       "client-1",
+	}
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		allowed       bool
+		expectError   bool
+		expectedCalls []string
+	}{
+		{"refused without federation annotations", unfederated, false, true, nil},
+		{"refused without tenant id", clientOnly, false, true, nil},
+		{"refused without tenant id even when allowed", clientOnly, true, true, nil},
+		{"controller credential when allowed", unfederated, true, false, []string{""}},
+		{"federated credential when allowed", clusterAnnotations, true, false, []string{"client-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, clientIDs := newTestClusterController(t, newKubeconfigSecret("aks-1-kubeconfig", map[string]string{"env": "prod"}, tt.annotations))
+			controller.WithControllerCredential(tt.allowed)
+
+			_, failed, err := controller.GetWorkloadClusters(context.TODO(), "fleet", &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}})
+			if err != nil {
+				t.Fatalf("GetWorkloadClusters failed: %v", err)
+			}
+			if tt.expectError != (failed["aks-1-kubeconfig"] != nil) {
+				t.Fatalf("Expected error %v, got: %v", tt.expectError, failed)
+			}
+			if !slices.Equal(*clientIDs, tt.expectedCalls) {
+				t.Fatalf("Expected credentials for %v, got: %v", tt.expectedCalls, *clientIDs)
+			}
+		})
+	}
+}
+
+func TestGetWorkloadClusters_MissingAnnotations(t *testing.T) {
+	controller, _ := newTestClusterController(t, newKubeconfigSecret("aks-1-kubeconfig", map[string]string{"env": "prod"}, nil))
+
+	_, failed, err := controller.GetWorkloadClusters(context.TODO(), "fleet", &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatalf("GetWorkloadClusters failed: %v", err)
+	}
+	if failed["aks-1-kubeconfig"] == nil {
+		t.Fatalf("Expected missing annotation error, got nil")
+	}
+}
+
+func TestGetWorkloadClusters_SkipsInvalidSecret(t *testing.T) {
+	controller, _ := newTestClusterController(t,
+		newKubeconfigSecret("aks-1-kubeconfig", map[string]string{"env": "prod"}, clusterAnnotations),
+		newKubeconfigSecret("aks-2-kubeconfig", map[string]string{"env": "prod"}, nil),
+	)
+
+	clusters, failed, err := controller.GetWorkloadClusters(context.TODO(), "fleet", &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatalf("GetWorkloadClusters failed: %v", err)
+	}
+	if len(clusters) != 1 || clusters[0].Name != "aks-1-kubeconfig" {
+		t.Fatalf("Expected the valid cluster to be returned, got: %v", clusters)
+	}
+	if len(failed) != 1 || failed["aks-2-kubeconfig"] == nil {
+		t.Fatalf("Expected the invalid Secret to be reported by its name, got: %v", failed)
+	}
+}
+
+func TestGetWorkloadClusters_EmptySelector(t *testing.T) {
+	controller, clientIDs := newTestClusterController(t, newKubeconfigSecret("aks-1-kubeconfig", map[string]string{"env": "prod"}, clusterAnnotations))
+
+	if _, _, err := controller.GetWorkloadClusters(context.TODO(), "fleet", &metav1.LabelSelector{}); err == nil {
+		t.Fatalf("Expected an error for an empty selector, got nil")
+	}
+	if len(*clientIDs) != 0 {
+		t.Fatalf("Expected no clients to be created, got: %v", *clientIDs)
+	}
+}
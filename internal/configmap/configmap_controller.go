@@ -3,12 +3,17 @@ package configmap
 import (
 	"context"
 	"fmt"
+	"maps"
 
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+
+	safev1 "norbinto/node-updater/api/v1"
 )
 
 type ConfigMapController struct {
@@ -23,25 +28,36 @@ func NewConfigMapController(kubeClient kubernetes.Interface, logger *zap.Logger)
 	}
 }
 
-// EnsureConfigMap ensures that a ConfigMap exists in the specified namespace
-func (c *ConfigMapController) CreateConfigMap(namespace string, name string, data map[string]string) error {
-	_, err := c.getConfigMap(namespace, name)
+// WithLogFields returns a copy of the ConfigMapController which attaches the fields to every log entry
+func (c *ConfigMapController) WithLogFields(fields ...zap.Field) ConfigMapControllerInterface {
+	controller := *c
+	controller.logger = c.logger.With(fields...)
+	return &controller
+}
+
+// EnsureConfigMap ensures that a ConfigMap exists in the specified namespace. When owner is set, the ConfigMap is
+// labeled as the state of its rotation and owned by it, so it is protected by the webhook and garbage collected with it.
+// An existing ConfigMap keeps its data, but it is adopted by the owner when it was created without one.
+func (c *ConfigMapController) CreateConfigMap(ctx context.Context, namespace string, name string, data map[string]string, owner *safev1.SafeEvict) error {
+	existing, err := c.getConfigMap(ctx, namespace, name)
 	if err == nil {
 		c.logger.Debug("ConfigMap already exists, data is not changed in it", zap.String("namespace", namespace), zap.String("name", name))
-		return nil
+		return c.adoptConfigMap(ctx, existing, owner)
 	}
 
 	configMap := &corev1.ConfigMap{
 		ObjectMeta: v1.ObjectMeta{
-			Name: name,
+			Name:      name,
+			Namespace: namespace,
 		},
 		Data: data,
 	}
+	setOwner(configMap, owner)
 
 	c.logger.Debug("Creating a new ConfigMap", zap.String("namespace", namespace), zap.String("name", name), zap.Any("data", data))
-	_, err = c.kubeClient.CoreV1().ConfigMaps(namespace).Create(context.TODO(), configMap, v1.CreateOptions{})
+	_, err = c.kubeClient.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, v1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to create ConfigMap: %v", err)
+		return fmt.Errorf("failed to create ConfigMap: %w", err)
 	}
 
 	c.logger.Debug("ConfigMap created successfully", zap.String("namespace", namespace), zap.String("name", name))
@@ -49,33 +65,133 @@ func (c *ConfigMapController) CreateConfigMap(namespace string, name string, dat
 
 }
 
-// DeleteConfigMap deletes a ConfigMap by name in the specified namespace
-func (c *ConfigMapController) DeleteConfigMap(namespace string, name string) error {
+// UpdateConfigMap replaces the data of an existing ConfigMap, nothing is written when the data is unchanged
+func (c *ConfigMapController) UpdateConfigMap(ctx context.Context, namespace string, name string, data map[string]string) error {
+	configMap, err := c.getConfigMap(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	if maps.Equal(configMap.Data, data) {
+		c.logger.Debug("ConfigMap data is unchanged", zap.String("namespace", namespace), zap.String("name", name))
+		return nil
+	}
+
+	configMap.Data = data
+	c.logger.Debug("Updating ConfigMap", zap.String("namespace", namespace), zap.String("name", name), zap.Any("data", data))
+	_, err = c.kubeClient.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, v1.UpdateOptions{})
+	if err != nil {
+		c.logger.Error("Failed to update ConfigMap", zap.Error(err), zap.String("namespace", namespace), zap.String("name", name))
+		return fmt.Errorf("failed to update ConfigMap: %w", err)
+	}
+	c.logger.Debug("ConfigMap updated successfully", zap.String("namespace", namespace), zap.String("name", name))
+	return nil
+}
+
+// adoptConfigMap sets the owner of a ConfigMap which was created without one, e.g. by an earlier version of the
+// controller. A ConfigMap controlled by another object is left alone.
+func (c *ConfigMapController) adoptConfigMap(ctx context.Context, configMap *corev1.ConfigMap, owner *safev1.SafeEvict) error {
+	if owner == nil || isOwnedBy(configMap, owner) {
+		return nil
+	}
+	if controllerRef := v1.GetControllerOf(configMap); controllerRef != nil {
+		c.logger.Warn("ConfigMap is controlled by another object, not adopting it", zap.String("namespace", configMap.Namespace), zap.String("name", configMap.Name), zap.String("controller", controllerRef.Kind+"/"+controllerRef.Name))
+		return nil
+	}
+
+	setOwner(configMap, owner)
+	c.logger.Debug("Adopting ConfigMap", zap.String("namespace", configMap.Namespace), zap.String("name", configMap.Name), zap.String("owner", owner.Name))
+	_, err := c.kubeClient.CoreV1().ConfigMaps(configMap.Namespace).Update(ctx, configMap, v1.UpdateOptions{})
+	if err != nil {
+		c.logger.Error("Failed to adopt ConfigMap", zap.Error(err), zap.String("namespace", configMap.Namespace), zap.String("name", configMap.Name))
+		return fmt.Errorf("failed to adopt ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// setOwner labels the ConfigMap as the state of the rotation of owner and makes owner its controller. A ConfigMap in
+// another namespace than owner is only labeled with the namespace of owner, an owner reference cannot cross namespaces.
+func setOwner(configMap *corev1.ConfigMap, owner *safev1.SafeEvict) {
+	if owner == nil {
+		return
+	}
+	if configMap.Labels == nil {
+		configMap.Labels = make(map[string]string)
+	}
+	configMap.Labels[safev1.StateConfigMapLabel] = owner.Name
+	if configMap.Namespace != owner.Namespace {
+		configMap.Labels[safev1.StateNamespaceLabel] = owner.Namespace
+		return
+	}
+	configMap.OwnerReferences = append(configMap.OwnerReferences, *v1.NewControllerRef(owner, safev1.GroupVersion.WithKind("SafeEvict")))
+}
+
+// isOwnedBy returns true when the ConfigMap is controlled by owner, or labeled with it when it is in another namespace
+func isOwnedBy(configMap *corev1.ConfigMap, owner *safev1.SafeEvict) bool {
+	if configMap.Namespace != owner.Namespace {
+		return configMap.Labels[safev1.StateConfigMapLabel] == owner.Name && configMap.Labels[safev1.StateNamespaceLabel] == owner.Namespace
+	}
+	return v1.IsControlledBy(configMap, owner)
+}
+
+// DeleteConfigMap deletes a ConfigMap by name in the specified namespace. The state of a rotation is released first,
+// otherwise the webhook would deny the deletion while the status of the SafeEvict still shows the rotation in progress.
+func (c *ConfigMapController) DeleteConfigMap(ctx context.Context, namespace string, name string) error {
+	configMap, err := c.getConfigMap(ctx, namespace, name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if configMap != nil && configMap.Labels[safev1.StateConfigMapLabel] != "" && configMap.Annotations[safev1.ReleaseStateAnnotation] != "true" {
+		c.logger.Debug("Releasing state ConfigMap", zap.String("namespace", namespace), zap.String("name", name))
+		patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}}}`, safev1.ReleaseStateAnnotation)
+		_, err = c.kubeClient.CoreV1().ConfigMaps(namespace).Patch(ctx, name, types.MergePatchType, []byte(patch), v1.PatchOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			c.logger.Error("Failed to release state ConfigMap", zap.Error(err), zap.String("namespace", namespace), zap.String("name", name))
+			return fmt.Errorf("failed to release ConfigMap: %w", err)
+		}
+	}
+
 	c.logger.Debug("Deleting ConfigMap", zap.String("namespace", namespace), zap.String("name", name))
-	err := c.kubeClient.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), name, v1.DeleteOptions{})
+	err = c.kubeClient.CoreV1().ConfigMaps(namespace).Delete(ctx, name, v1.DeleteOptions{})
 	if apierrors.IsNotFound(err) {
 		c.logger.Debug("ConfigMap not found, nothing to delete", zap.String("namespace", namespace), zap.String("name", name))
 		return nil
 	}
 	if err != nil {
 		c.logger.Error("Failed to delete ConfigMap", zap.Error(err), zap.String("namespace", namespace), zap.String("name", name))
-		return fmt.Errorf("failed to delete ConfigMap: %v", err)
+		return fmt.Errorf("failed to delete ConfigMap: %w", err)
 	}
 	c.logger.Debug("ConfigMap deleted successfully", zap.String("namespace", namespace), zap.String("name", name))
 	return nil
 }
 
+// DeleteStateConfigMaps deletes the state ConfigMaps of the SafeEvict from another namespace, they are not deleted by
+// the garbage collector with the SafeEvict because they cannot be owned by it
+func (c *ConfigMapController) DeleteStateConfigMaps(ctx context.Context, namespace string, safeEvict types.NamespacedName) error {
+	selector := labels.Set{safev1.StateConfigMapLabel: safeEvict.Name, safev1.StateNamespaceLabel: safeEvict.Namespace}.String()
+	configMaps, err := c.kubeClient.CoreV1().ConfigMaps(namespace).List(ctx, v1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		c.logger.Error("Failed to list state ConfigMaps", zap.Error(err), zap.String("namespace", namespace), zap.String("labelSelector", selector))
+		return fmt.Errorf("failed to list state ConfigMaps: %w", err)
+	}
+	for _, configMap := range configMaps.Items {
+		if err := c.DeleteConfigMap(ctx, namespace, configMap.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetConfigMapData retrieves the data from a ConfigMap by name in the specified namespace
-func (c *ConfigMapController) GetConfigMapData(namespace string, name string) (map[string]string, error) {
+func (c *ConfigMapController) GetConfigMapData(ctx context.Context, namespace string, name string) (map[string]string, error) {
 	c.logger.Debug("Retrieving ConfigMap data", zap.String("namespace", namespace), zap.String("name", name))
-	configMap, err := c.getConfigMap(namespace, name)
+	configMap, err := c.getConfigMap(ctx, namespace, name)
 	if apierrors.IsNotFound(err) {
 		c.logger.Debug("ConfigMap not found, returning nil", zap.String("namespace", namespace), zap.String("name", name))
 		return nil, err
 	}
 	if err != nil {
 		c.logger.Error("Failed to get ConfigMap data", zap.Error(err), zap.String("namespace", namespace), zap.String("name", name))
-		return nil, fmt.Errorf("failed to get ConfigMap data: %v", err)
+		return nil, fmt.Errorf("failed to get ConfigMap data: %w", err)
 	}
 
 	c.logger.Debug("ConfigMap data retrieved successfully", zap.String("namespace", namespace), zap.String("name", name), zap.Any("data", configMap.Data))
@@ -83,15 +199,15 @@ func (c *ConfigMapController) GetConfigMapData(namespace string, name string) (m
 }
 
 // GetConfigMap retrieves a ConfigMap by name in the specified namespace
-func (c *ConfigMapController) getConfigMap(namespace string, name string) (*corev1.ConfigMap, error) {
-	configMap, err := c.kubeClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, v1.GetOptions{})
+func (c *ConfigMapController) getConfigMap(ctx context.Context, namespace string, name string) (*corev1.ConfigMap, error) {
+	configMap, err := c.kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, name, v1.GetOptions{})
 	if apierrors.IsNotFound(err) {
 		return nil, err
 	}
 
 	if err != nil {
 		c.logger.Error("Failed to get ConfigMap", zap.Error(err), zap.String("namespace", namespace), zap.String("name", name))
-		return nil, fmt.Errorf("failed to get ConfigMap: %v", err)
+		return nil, fmt.Errorf("failed to get ConfigMap: %w", err)
 	}
 
 	return configMap, nil
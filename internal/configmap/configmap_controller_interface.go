@@ -0,0 +1,22 @@
+package configmap
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+
+	safev1 "norbinto/node-updater/api/v1"
+)
+
+// ConfigMapControllerInterface keeps the state of the rotations in ConfigMaps, it is implemented by ConfigMapController
+type ConfigMapControllerInterface interface {
+	CreateConfigMap(ctx context.Context, namespace string, name string, data map[string]string, owner *safev1.SafeEvict) error
+	UpdateConfigMap(ctx context.Context, namespace string, name string, data map[string]string) error
+	DeleteConfigMap(ctx context.Context, namespace string, name string) error
+	DeleteStateConfigMaps(ctx context.Context, namespace string, safeEvict types.NamespacedName) error
+	GetConfigMapData(ctx context.Context, namespace string, name string) (map[string]string, error)
+	WithLogFields(fields ...zap.Field) ConfigMapControllerInterface
+}
+
+var _ ConfigMapControllerInterface = &ConfigMapController{}
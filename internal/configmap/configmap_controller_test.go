@@ -7,10 +7,14 @@ import (
 
 	"go.uber.org/zap/zaptest"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
+
+	safev1 "norbinto/node-updater/api/v1"
 )
 
 func TestCreateConfigMap(t *testing.T) {
@@ -18,7 +22,7 @@ func TestCreateConfigMap(t *testing.T) {
 	kubeClient := fake.NewSimpleClientset()
 	controller := NewConfigMapController(kubeClient, logger)
 
-	err := controller.CreateConfigMap("default", "test-configmap", map[string]string{"key": "value"})
+	err := controller.CreateConfigMap(context.Background(), "default", "test-configmap", map[string]string{"key": "value"}, nil)
 	if err != nil {
 		t.Fatalf("CreateConfigMap failed: %v", err)
 	}
@@ -40,7 +44,7 @@ func TestCreateConfigMap_AlreadyExists(t *testing.T) {
 	})
 	controller := NewConfigMapController(kubeClient, logger)
 
-	err := controller.CreateConfigMap("default", "test-configmap", map[string]string{"key": "value"})
+	err := controller.CreateConfigMap(context.Background(), "default", "test-configmap", map[string]string{"key": "value"}, nil)
 	if err != nil {
 		t.Fatalf("CreateConfigMap failed: %v", err)
 	}
@@ -56,7 +60,7 @@ func TestDeleteConfigMap(t *testing.T) {
 	})
 	controller := NewConfigMapController(kubeClient, logger)
 
-	err := controller.DeleteConfigMap("default", "test-configmap")
+	err := controller.DeleteConfigMap(context.Background(), "default", "test-configmap")
 	if err != nil {
 		t.Fatalf("DeleteConfigMap failed: %v", err)
 	}
@@ -73,7 +77,7 @@ func TestDeleteConfigMap_NotFound(t *testing.T) {
 	kubeClient := fake.NewSimpleClientset()
 	controller := NewConfigMapController(kubeClient, logger)
 
-	err := controller.DeleteConfigMap("default", "nonexistent-configmap")
+	err := controller.DeleteConfigMap(context.Background(), "default", "nonexistent-configmap")
 	if err != nil {
 		t.Fatalf("DeleteConfigMap failed: %v", err)
 	}
@@ -90,7 +94,7 @@ func TestGetConfigMapData(t *testing.T) {
 	})
 	controller := NewConfigMapController(kubeClient, logger)
 
-	data, err := controller.GetConfigMapData("default", "test-configmap")
+	data, err := controller.GetConfigMapData(context.Background(), "default", "test-configmap")
 	if err != nil {
 		t.Fatalf("GetConfigMapData failed: %v", err)
 	}
@@ -104,7 +108,7 @@ func TestGetConfigMapData_NotFound(t *testing.T) {
 	kubeClient := fake.NewSimpleClientset()
 	controller := NewConfigMapController(kubeClient, logger)
 
-	_, err := controller.GetConfigMapData("default", "nonexistent-configmap")
+	_, err := controller.GetConfigMapData(context.Background(), "default", "nonexistent-configmap")
 	if err == nil {
 		t.Fatalf("Expected error for nonexistent ConfigMap, got nil")
 	}
@@ -118,7 +122,7 @@ func TestCreateConfigMap_Error(t *testing.T) {
 	})
 	controller := NewConfigMapController(kubeClient, logger)
 
-	err := controller.CreateConfigMap("default", "test-configmap", map[string]string{"key": "value"})
+	err := controller.CreateConfigMap(context.Background(), "default", "test-configmap", map[string]string{"key": "value"}, nil)
 	if err == nil || err.Error() != "failed to create ConfigMap: mock create error" {
 		t.Fatalf("Expected mock create error, got: %v", err)
 	}
@@ -132,8 +136,186 @@ func TestDeleteConfigMap_Error(t *testing.T) {
 	})
 	controller := NewConfigMapController(kubeClient, logger)
 
-	err := controller.DeleteConfigMap("default", "test-configmap")
+	err := controller.DeleteConfigMap(context.Background(), "default", "test-configmap")
 	if err == nil || err.Error() != "failed to delete ConfigMap: mock delete error" {
 		t.Fatalf("Expected mock delete error, got: %v", err)
 	}
 }
+
+func TestCreateConfigMap_WithOwner(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset()
+	controller := NewConfigMapController(kubeClient, logger)
+	owner := &safev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: "rotation", Namespace: "default", UID: "uid"}}
+
+	err := controller.CreateConfigMap(context.Background(), "default", "test-configmap", map[string]string{"key": "value"}, owner)
+	if err != nil {
+		t.Fatalf("CreateConfigMap failed: %v", err)
+	}
+
+	configMap, err := kubeClient.CoreV1().ConfigMaps("default").Get(context.TODO(), "test-configmap", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected ConfigMap to be created, but it was not: %v", err)
+	}
+	if configMap.Labels[safev1.StateConfigMapLabel] != "rotation" {
+		t.Fatalf("Expected ConfigMap to be labeled as state, got: %v", configMap.Labels)
+	}
+	if len(configMap.OwnerReferences) != 1 || configMap.OwnerReferences[0].UID != "uid" || configMap.OwnerReferences[0].Kind != "SafeEvict" {
+		t.Fatalf("Expected ConfigMap to be owned by the SafeEvict, got: %v", configMap.OwnerReferences)
+	}
+}
+
+func TestCreateConfigMap_InStateNamespace(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset()
+	controller := NewConfigMapController(kubeClient, logger)
+	owner := &safev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: "rotation", Namespace: "default", UID: "uid"}}
+
+	for range 2 {
+		err := controller.CreateConfigMap(context.Background(), "node-updater-system", "default.tmprotation", map[string]string{"key": "value"}, owner)
+		if err != nil {
+			t.Fatalf("CreateConfigMap failed: %v", err)
+		}
+	}
+
+	configMap, err := kubeClient.CoreV1().ConfigMaps("node-updater-system").Get(context.TODO(), "default.tmprotation", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected ConfigMap to be created, but it was not: %v", err)
+	}
+	if configMap.Labels[safev1.StateConfigMapLabel] != "rotation" || configMap.Labels[safev1.StateNamespaceLabel] != "default" {
+		t.Fatalf("Expected ConfigMap to be labeled with the SafeEvict, got: %v", configMap.Labels)
+	}
+	if len(configMap.OwnerReferences) != 0 {
+		t.Fatalf("Expected no owner reference across namespaces, got: %v", configMap.OwnerReferences)
+	}
+}
+
+func TestDeleteStateConfigMaps(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	stateConfigMap := func(name, safeEvictNamespace string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "node-updater-system",
+			Labels:    map[string]string{safev1.StateConfigMapLabel: "rotation", safev1.StateNamespaceLabel: safeEvictNamespace},
+		}}
+	}
+	kubeClient := fake.NewSimpleClientset(
+		stateConfigMap("default.tmprotation", "default"),
+		stateConfigMap("default.usagerotation", "default"),
+		stateConfigMap("other.tmprotation", "other"))
+	controller := NewConfigMapController(kubeClient, logger)
+
+	err := controller.DeleteStateConfigMaps(context.Background(), "node-updater-system", types.NamespacedName{Namespace: "default", Name: "rotation"})
+	if err != nil {
+		t.Fatalf("DeleteStateConfigMaps failed: %v", err)
+	}
+
+	configMaps, err := kubeClient.CoreV1().ConfigMaps("node-updater-system").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list ConfigMaps: %v", err)
+	}
+	if len(configMaps.Items) != 1 || configMaps.Items[0].Name != "other.tmprotation" {
+		t.Fatalf("Expected only the state of the SafeEvict in the other namespace to be kept, got: %v", configMaps.Items)
+	}
+}
+
+func TestDeleteConfigMap_ReleasesState(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-configmap",
+			Namespace: "default",
+			Labels:    map[string]string{safev1.StateConfigMapLabel: "rotation"},
+		},
+	})
+	kubeClient.PrependReactor("delete", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		configMap, err := kubeClient.Tracker().Get(corev1.SchemeGroupVersion.WithResource("configmaps"), "default", "test-configmap")
+		if err != nil {
+			return true, nil, err
+		}
+		if configMap.(*corev1.ConfigMap).Annotations[safev1.ReleaseStateAnnotation] != "true" {
+			return true, nil, errors.New("state ConfigMap was not released")
+		}
+		return false, nil, nil
+	})
+	controller := NewConfigMapController(kubeClient, logger)
+
+	if err := controller.DeleteConfigMap(context.Background(), "default", "test-configmap"); err != nil {
+		t.Fatalf("DeleteConfigMap failed: %v", err)
+	}
+}
+
+func TestCreateConfigMap_AdoptsExisting(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-configmap",
+			Namespace: "default",
+		},
+		Data: map[string]string{"key": "original"},
+	})
+	controller := NewConfigMapController(kubeClient, logger)
+	owner := &safev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: "rotation", Namespace: "default", UID: "uid"}}
+
+	err := controller.CreateConfigMap(context.Background(), "default", "test-configmap", map[string]string{"key": "value"}, owner)
+	if err != nil {
+		t.Fatalf("CreateConfigMap failed: %v", err)
+	}
+
+	configMap, err := kubeClient.CoreV1().ConfigMaps("default").Get(context.TODO(), "test-configmap", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get ConfigMap: %v", err)
+	}
+	if !metav1.IsControlledBy(configMap, owner) {
+		t.Fatalf("Expected ConfigMap to be adopted by the SafeEvict, got: %v", configMap.OwnerReferences)
+	}
+	if configMap.Data["key"] != "original" {
+		t.Fatalf("Expected data to be kept, got: %v", configMap.Data)
+	}
+}
+
+func TestUpdateConfigMap(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-configmap",
+			Namespace: "default",
+		},
+		Data: map[string]string{"key": "value"},
+	})
+	updates := 0
+	kubeClient.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		return false, nil, nil
+	})
+	controller := NewConfigMapController(kubeClient, logger)
+
+	if err := controller.UpdateConfigMap(context.Background(), "default", "test-configmap", map[string]string{"key": "value"}); err != nil {
+		t.Fatalf("UpdateConfigMap failed: %v", err)
+	}
+	if updates != 0 {
+		t.Fatalf("Expected unchanged data not to be written, got %d updates", updates)
+	}
+
+	if err := controller.UpdateConfigMap(context.Background(), "default", "test-configmap", map[string]string{"key": "changed"}); err != nil {
+		t.Fatalf("UpdateConfigMap failed: %v", err)
+	}
+	data, err := controller.GetConfigMapData(context.Background(), "default", "test-configmap")
+	if err != nil {
+		t.Fatalf("GetConfigMapData failed: %v", err)
+	}
+	if data["key"] != "changed" {
+		t.Fatalf("Expected data to be updated, got: %v", data)
+	}
+}
+
+func TestUpdateConfigMap_NotFound(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset()
+	controller := NewConfigMapController(kubeClient, logger)
+
+	err := controller.UpdateConfigMap(context.Background(), "default", "nonexistent-configmap", map[string]string{"key": "value"})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("Expected not found error, got: %v", err)
+	}
+}
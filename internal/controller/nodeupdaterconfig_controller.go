@@ -0,0 +1,246 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/appconfig"
+	"norbinto/node-updater/internal/azuredevops"
+	"norbinto/node-updater/pkg/notify"
+)
+
+// NodeUpdaterConfigReconciler applies the NodeUpdaterConfig to the running controller, its changes take effect without
+// a restart. Without a NodeUpdaterConfig the controller runs with its command line flags and environment variables.
+type NodeUpdaterConfigReconciler struct {
+	client.Client
+	KubeClient kubernetes.Interface
+	// Defaults is the configuration of the command line flags and the configuration file, the NodeUpdaterConfig
+	// overrides its fields
+	Defaults *appconfig.Store
+	// Config is the configuration the SafeEvictReconciler reads
+	Config *appconfig.Store
+	// AzureDevOpsDefaults are the credentials of the environment variables, the NodeUpdaterConfig overrides them
+	AzureDevOpsDefaults azuredevops.Credentials
+	// AzureDevopsController is the Azure DevOps client of the controllers, it may be nil when it cannot be reconfigured
+	AzureDevopsController *azuredevops.ReloadableController
+	// NotificationDefaults are the notification sinks of the flags and environment variables, the NodeUpdaterConfig
+	// overrides them
+	NotificationDefaults notify.Sinks
+	// Notifier sends the notifications of the SafeEvictReconciler, it may be nil when it cannot be reconfigured
+	Notifier *notify.ReloadableNotifier
+	Logger   *zap.Logger
+
+	// defaultsChanged triggers a reconcile when the defaults are reloaded
+	defaultsChanged chan event.GenericEvent
+}
+
+// +kubebuilder:rbac:groups=update.norbinto,resources=nodeupdaterconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=update.norbinto,resources=nodeupdaterconfigs/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+func (c *NodeUpdaterConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	nodeUpdaterConfig := &updatev1.NodeUpdaterConfig{}
+	if err := c.Get(ctx, req.NamespacedName, nodeUpdaterConfig); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to get NodeUpdaterConfig '%s': %w", req.Name, err)
+		}
+		c.Logger.Debug("NodeUpdaterConfig does not exist, applying the command line flags and the configuration file", zap.String("name", req.Name))
+		c.apply(*c.Defaults.Load(), c.AzureDevOpsDefaults, c.NotificationDefaults)
+		return ctrl.Result{}, nil
+	}
+
+	config, credentials, err := c.resolve(ctx, nodeUpdaterConfig.Spec)
+	var sinks notify.Sinks
+	if err == nil {
+		sinks, err = c.resolveSinks(ctx, nodeUpdaterConfig.Spec.Notifications)
+	}
+	if err != nil {
+		c.Logger.Error("Failed to apply NodeUpdaterConfig, keeping the previous configuration", zap.Error(err), zap.String("name", req.Name))
+		if statusErr := c.updateStatus(ctx, nodeUpdaterConfig, metav1.ConditionFalse, updatev1.ReasonInvalidConfig, err.Error()); statusErr != nil {
+			return ctrl.Result{}, statusErr
+		}
+		return ctrl.Result{}, err
+	}
+	c.apply(config, credentials, sinks)
+	if err := c.updateStatus(ctx, nodeUpdaterConfig, metav1.ConditionTrue, updatev1.ReasonConfigApplied, "Configuration is applied"); err != nil {
+		return ctrl.Result{}, err
+	}
+	if nodeUpdaterConfig.Spec.AzureDevOps != nil || nodeUpdaterConfig.Spec.Notifications != nil {
+		// the access token and the keys of the notification sinks are read again in case they were rotated in their
+		// Secrets
+		return ctrl.Result{RequeueAfter: config.UpgradeFrequency}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// resolve overrides the defaults with the spec and reads the access token from its Secret
+func (c *NodeUpdaterConfigReconciler) resolve(ctx context.Context, spec updatev1.NodeUpdaterConfigSpec) (appconfig.Config, azuredevops.Credentials, error) {
+	config := *c.Defaults.Load()
+	if spec.ErrorReconcileTime != nil {
+		config.ErrorReconcileTime = spec.ErrorReconcileTime.Duration
+	}
+	if spec.SuccessReconcileTime != nil {
+		config.SuccessReconcileTime = spec.SuccessReconcileTime.Duration
+	}
+	if spec.UpgradeFrequency != nil {
+		config.UpgradeFrequency = spec.UpgradeFrequency.Duration
+	}
+	if config.ErrorReconcileTime <= 0 || config.SuccessReconcileTime <= 0 || config.UpgradeFrequency <= 0 {
+		return config, azuredevops.Credentials{}, fmt.Errorf("reconcile times and upgrade frequency must be positive")
+	}
+	if spec.ProvisioningRequeueTimes != nil {
+		requeueTimes := make(map[string]time.Duration, len(spec.ProvisioningRequeueTimes))
+		for state, requeueTime := range spec.ProvisioningRequeueTimes {
+			requeueTimes[state] = requeueTime.Duration
+		}
+		if err := appconfig.ValidateProvisioningRequeueTimes(requeueTimes); err != nil {
+			return config, azuredevops.Credentials{}, err
+		}
+		config = config.WithProvisioningRequeueTimes(requeueTimes)
+	}
+	if spec.NotificationTemplates != nil {
+		if err := notify.ValidateTemplates(spec.NotificationTemplates); err != nil {
+			return config, azuredevops.Credentials{}, err
+		}
+		config.NotificationTemplates = spec.NotificationTemplates
+	}
+
+	credentials := c.AzureDevOpsDefaults
+	if spec.AzureDevOps != nil {
+		accessToken, err := c.readSecret(ctx, "access token", spec.AzureDevOps.AccessTokenSecretRef)
+		if err != nil {
+			return config, credentials, err
+		}
+		credentials = azuredevops.Credentials{
+			ServerURL:        spec.AzureDevOps.ServerURL,
+			OrganizationName: spec.AzureDevOps.Organization,
+			AccessToken:      accessToken,
+		}
+	}
+	return config, credentials, nil
+}
+
+// resolveSinks overrides the default notification sinks with the spec and reads their keys from their Secrets
+func (c *NodeUpdaterConfigReconciler) resolveSinks(ctx context.Context, spec *updatev1.NotificationsConfig) (notify.Sinks, error) {
+	if spec == nil {
+		return c.NotificationDefaults, nil
+	}
+	sinks := notify.Sinks{WebhookURL: spec.WebhookURL, OpsgenieURL: spec.OpsgenieURL}
+	if spec.PagerDutyRoutingKeySecretRef != nil {
+		routingKey, err := c.readSecret(ctx, "PagerDuty routing key", *spec.PagerDutyRoutingKeySecretRef)
+		if err != nil {
+			return sinks, err
+		}
+		sinks.PagerDutyRoutingKey = routingKey
+	}
+	if spec.OpsgenieAPIKeySecretRef != nil {
+		apiKey, err := c.readSecret(ctx, "Opsgenie API key", *spec.OpsgenieAPIKeySecretRef)
+		if err != nil {
+			return sinks, err
+		}
+		sinks.OpsgenieAPIKey = apiKey
+	}
+	return sinks, nil
+}
+
+// readSecret returns the value of the key of a Secret, the description names the value in the errors
+func (c *NodeUpdaterConfigReconciler) readSecret(ctx context.Context, description string, ref updatev1.SecretKeyReference) (string, error) {
+	secret, err := c.KubeClient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s Secret '%s/%s': %w", description, ref.Namespace, ref.Name, err)
+	}
+	value, ok := secret.Data[ref.GetKey()]
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("%s Secret '%s/%s' has no key '%s'", description, ref.Namespace, ref.Name, ref.GetKey())
+	}
+	return string(value), nil
+}
+
+// apply makes the controllers use the configuration from their next reconcile
+func (c *NodeUpdaterConfigReconciler) apply(config appconfig.Config, credentials azuredevops.Credentials, sinks notify.Sinks) {
+	if current := c.Config.Load(); current == nil || !current.Equal(config) {
+		c.Config.Update(&config)
+		c.Logger.Info("Configuration is reloaded", zap.Duration("errorReconcileTime", config.ErrorReconcileTime),
+			zap.Duration("successReconcileTime", config.SuccessReconcileTime), zap.Duration("upgradeFrequency", config.UpgradeFrequency))
+	}
+	if c.AzureDevopsController != nil {
+		c.AzureDevopsController.Configure(credentials)
+	}
+	if c.Notifier != nil {
+		c.Notifier.Configure(sinks)
+	}
+}
+
+// updateStatus records whether the spec is applied, the status is only written when it changed
+func (c *NodeUpdaterConfigReconciler) updateStatus(ctx context.Context, nodeUpdaterConfig *updatev1.NodeUpdaterConfig, status metav1.ConditionStatus, reason, message string) error {
+	original := nodeUpdaterConfig.DeepCopy()
+	nodeUpdaterConfig.Status.ObservedGeneration = nodeUpdaterConfig.Generation
+	meta.SetStatusCondition(&nodeUpdaterConfig.Status.Conditions, metav1.Condition{
+		Type:               updatev1.ConditionApplied,
+		Status:             status,
+		ObservedGeneration: nodeUpdaterConfig.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	if equality.Semantic.DeepEqual(original.Status, nodeUpdaterConfig.Status) {
+		return nil
+	}
+	if err := c.Client.Status().Patch(ctx, nodeUpdaterConfig, client.MergeFrom(original)); err != nil {
+		c.Logger.Error("Failed to update NodeUpdaterConfig status", zap.Error(err), zap.String("name", nodeUpdaterConfig.Name))
+		return fmt.Errorf("failed to update status of NodeUpdaterConfig '%s': %w", nodeUpdaterConfig.Name, err)
+	}
+	return nil
+}
+
+// DefaultsChanged applies the reloaded defaults with the NodeUpdaterConfig on top, it does not block
+func (c *NodeUpdaterConfigReconciler) DefaultsChanged() {
+	select {
+	case c.defaultsChanged <- event.GenericEvent{Object: &updatev1.NodeUpdaterConfig{ObjectMeta: metav1.ObjectMeta{Name: updatev1.NodeUpdaterConfigName}}}:
+	default:
+		// a reconcile is pending already, it reads the latest defaults
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (c *NodeUpdaterConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	c.defaultsChanged = make(chan event.GenericEvent, 1)
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&updatev1.NodeUpdaterConfig{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(object client.Object) bool {
+			return object.GetName() == updatev1.NodeUpdaterConfigName
+		}))).
+		WatchesRawSource(source.Channel(c.defaultsChanged, &handler.EnqueueRequestForObject{})).
+		Named("nodeupdaterconfig").
+		Complete(c)
+}
@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/appconfig"
+	"norbinto/node-updater/internal/azuredevops"
+	"norbinto/node-updater/pkg/notify"
+)
+
+type configFixture struct {
+	reconciler            *NodeUpdaterConfigReconciler
+	kubeClient            *kubefake.Clientset
+	azureDevopsController *azuredevops.ReloadableController
+	notifier              *notify.ReloadableNotifier
+}
+
+func newConfigFixture(t *testing.T, nodeUpdaterConfig *updatev1.NodeUpdaterConfig) *configFixture {
+	scheme := runtime.NewScheme()
+	if err := updatev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to build scheme: %v", err)
+	}
+	builder := crfake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&updatev1.NodeUpdaterConfig{})
+	if nodeUpdaterConfig != nil {
+		builder = builder.WithObjects(nodeUpdaterConfig)
+	}
+	logger := zaptest.NewLogger(t)
+	defaults := appconfig.NewConfig(10*time.Second, 10*time.Second, time.Hour)
+	kubeClient := kubefake.NewClientset()
+	azureDevopsController := azuredevops.NewReloadableController(http.DefaultClient, azuredevops.Credentials{}, logger.Named("azureDevOps"))
+	notifier := notify.NewReloadableNotifier(http.DefaultClient, nil, notify.Sinks{})
+	return &configFixture{
+		reconciler: &NodeUpdaterConfigReconciler{
+			Client:                builder.Build(),
+			KubeClient:            kubeClient,
+			Defaults:              appconfig.NewStore(defaults),
+			Config:                appconfig.NewStore(defaults),
+			AzureDevopsController: azureDevopsController,
+			Notifier:              notifier,
+			Logger:                logger.Named("config"),
+		},
+		kubeClient:            kubeClient,
+		azureDevopsController: azureDevopsController,
+		notifier:              notifier,
+	}
+}
+
+func (f *configFixture) reconcile(t *testing.T) (ctrl.Result, error) {
+	t.Helper()
+	return f.reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: updatev1.NodeUpdaterConfigName}})
+}
+
+func (f *configFixture) createSecret(t *testing.T, data map[string][]byte) {
+	t.Helper()
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "azure-devops", Namespace: "node-updater"}, Data: data}
+	if _, err := f.kubeClient.CoreV1().Secrets(secret.Namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create secret: %v", err)
+	}
+}
+
+func (f *configFixture) appliedCondition(t *testing.T) *metav1.Condition {
+	t.Helper()
+	nodeUpdaterConfig := &updatev1.NodeUpdaterConfig{}
+	if err := f.reconciler.Get(context.Background(), types.NamespacedName{Name: updatev1.NodeUpdaterConfigName}, nodeUpdaterConfig); err != nil {
+		t.Fatalf("failed to get NodeUpdaterConfig: %v", err)
+	}
+	condition := meta.FindStatusCondition(nodeUpdaterConfig.Status.Conditions, updatev1.ConditionApplied)
+	if condition == nil {
+		t.Fatal("expected the Applied condition to be set")
+	}
+	return condition
+}
+
+func newNodeUpdaterConfig(spec updatev1.NodeUpdaterConfigSpec) *updatev1.NodeUpdaterConfig {
+	return &updatev1.NodeUpdaterConfig{ObjectMeta: metav1.ObjectMeta{Name: updatev1.NodeUpdaterConfigName, Generation: 1}, Spec: spec}
+}
+
+func azureDevOpsConfig() *updatev1.AzureDevOpsConfig {
+	return &updatev1.AzureDevOpsConfig{
+		Organization:         "organization",
+		AccessTokenSecretRef: updatev1.SecretKeyReference{Namespace: "node-updater", Name: "azure-devops"},
+	}
+}
+
+func TestNodeUpdaterConfig_OverridesFlags(t *testing.T) {
+	f := newConfigFixture(t, newNodeUpdaterConfig(updatev1.NodeUpdaterConfigSpec{
+		ErrorReconcileTime: &metav1.Duration{Duration: time.Minute},
+		AzureDevOps:        azureDevOpsConfig(),
+	}))
+	f.createSecret(t, map[string][]byte{updatev1.DefaultAccessTokenSecretKey: []byte("pat")})
+
+	result, err := f.reconcile(t)
+
+	if err != nil {
+		t.Fatalf("expected the configuration to be applied, got %v", err)
+	}
+	expected := appconfig.Config{ErrorReconcileTime: time.Minute, SuccessReconcileTime: 10 * time.Second, UpgradeFrequency: time.Hour}
+	if config := *f.reconciler.Config.Load(); !config.Equal(expected) {
+		t.Errorf("expected config %+v, got %+v", expected, config)
+	}
+	if !azuredevops.IsEnabled(f.azureDevopsController) {
+		t.Error("expected the Azure DevOps integration to be enabled with the access token of the Secret")
+	}
+	if result.RequeueAfter != time.Hour {
+		t.Errorf("expected the access token to be read again after the upgrade frequency, got %v", result.RequeueAfter)
+	}
+	if condition := f.appliedCondition(t); condition.Status != metav1.ConditionTrue {
+		t.Errorf("expected the configuration to be reported as applied, got %s (%s)", condition.Status, condition.Message)
+	}
+}
+
+func TestNodeUpdaterConfig_KeepsPreviousConfigWhenSecretIsMissing(t *testing.T) {
+	f := newConfigFixture(t, newNodeUpdaterConfig(updatev1.NodeUpdaterConfigSpec{
+		UpgradeFrequency: &metav1.Duration{Duration: time.Minute},
+		AzureDevOps:      azureDevOpsConfig(),
+	}))
+	f.createSecret(t, map[string][]byte{"other": []byte("pat")})
+
+	if _, err := f.reconcile(t); err == nil {
+		t.Fatal("expected the missing access token to fail the reconcile")
+	}
+
+	if upgradeFrequency := f.reconciler.Config.Load().UpgradeFrequency; upgradeFrequency != time.Hour {
+		t.Errorf("expected the previous upgrade frequency to be kept, got %v", upgradeFrequency)
+	}
+	if azuredevops.IsEnabled(f.azureDevopsController) {
+		t.Error("expected the Azure DevOps integration to stay disabled")
+	}
+	if condition := f.appliedCondition(t); condition.Status != metav1.ConditionFalse || condition.Reason != updatev1.ReasonInvalidConfig {
+		t.Errorf("expected the configuration to be reported as invalid, got %s/%s", condition.Status, condition.Reason)
+	}
+}
+
+func TestNodeUpdaterConfig_RemovedConfigFallsBackToFlags(t *testing.T) {
+	f := newConfigFixture(t, nil)
+	f.reconciler.Config.Update(appconfig.NewConfig(time.Minute, time.Minute, time.Minute))
+	f.azureDevopsController.Configure(azuredevops.Credentials{OrganizationName: "organization", AccessToken: "pat"})
+
+	if _, err := f.reconcile(t); err != nil {
+		t.Fatalf("expected the removed configuration to be handled, got %v", err)
+	}
+
+	if config, defaults := *f.reconciler.Config.Load(), *f.reconciler.Defaults.Load(); !config.Equal(defaults) {
+		t.Errorf("expected the configuration of the flags %+v, got %+v", defaults, config)
+	}
+	if azuredevops.IsEnabled(f.azureDevopsController) {
+		t.Error("expected the Azure DevOps integration to fall back to the environment, which does not configure it")
+	}
+}
+
+func TestNodeUpdaterConfig_OverridesReloadedDefaults(t *testing.T) {
+	f := newConfigFixture(t, newNodeUpdaterConfig(updatev1.NodeUpdaterConfigSpec{
+		ErrorReconcileTime: &metav1.Duration{Duration: time.Minute},
+	}))
+	f.reconciler.Defaults.Update(appconfig.NewConfig(5*time.Second, 5*time.Second, 2*time.Hour))
+
+	if _, err := f.reconcile(t); err != nil {
+		t.Fatalf("expected the configuration to be applied, got %v", err)
+	}
+
+	// the reloaded configuration file replaces the flags, the NodeUpdaterConfig stays on top of it
+	expected := appconfig.Config{ErrorReconcileTime: time.Minute, SuccessReconcileTime: 5 * time.Second, UpgradeFrequency: 2 * time.Hour}
+	if config := *f.reconciler.Config.Load(); !config.Equal(expected) {
+		t.Errorf("expected config %+v, got %+v", expected, config)
+	}
+}
+
+func TestNodeUpdaterConfig_OverridesProvisioningRequeueTimes(t *testing.T) {
+	f := newConfigFixture(t, newNodeUpdaterConfig(updatev1.NodeUpdaterConfigSpec{
+		ProvisioningRequeueTimes: map[string]metav1.Duration{"Creating": {Duration: 2 * time.Minute}},
+	}))
+	defaults := f.reconciler.Defaults.Load().WithProvisioningRequeueTimes(map[string]time.Duration{"Creating": time.Minute, "Deleting": time.Minute})
+	f.reconciler.Defaults.Update(&defaults)
+
+	if _, err := f.reconcile(t); err != nil {
+		t.Fatalf("expected the configuration to be applied, got %v", err)
+	}
+
+	config := f.reconciler.Config.Load()
+	if config.RequeueTimeIn("Creating") != 2*time.Minute || config.RequeueTimeIn("Deleting") != time.Minute {
+		t.Errorf("expected the spec to override only the listed states, got %v", config.ProvisioningRequeueTimes)
+	}
+
+	f = newConfigFixture(t, newNodeUpdaterConfig(updatev1.NodeUpdaterConfigSpec{
+		ProvisioningRequeueTimes: map[string]metav1.Duration{"Creating": {}},
+	}))
+	if _, err := f.reconcile(t); err == nil {
+		t.Error("expected a requeue time which is not positive to fail the reconcile")
+	}
+}
+
+func TestNodeUpdaterConfig_RejectsInvalidNotificationTemplate(t *testing.T) {
+	f := newConfigFixture(t, newNodeUpdaterConfig(updatev1.NodeUpdaterConfigSpec{
+		NotificationTemplates: map[string]string{"RolledBack": "{{.Name"},
+	}))
+
+	if _, err := f.reconcile(t); err == nil {
+		t.Fatal("expected the invalid notification template to fail the reconcile")
+	}
+
+	if templates := f.reconciler.Config.Load().NotificationTemplates; templates != nil {
+		t.Errorf("expected the previous configuration to be kept, got templates %v", templates)
+	}
+	if condition := f.appliedCondition(t); condition.Status != metav1.ConditionFalse || condition.Reason != updatev1.ReasonInvalidConfig {
+		t.Errorf("expected the configuration to be reported as invalid, got %s/%s", condition.Status, condition.Reason)
+	}
+}
+
+func TestNodeUpdaterConfig_OverridesNotificationSinks(t *testing.T) {
+	f := newConfigFixture(t, newNodeUpdaterConfig(updatev1.NodeUpdaterConfigSpec{
+		Notifications: &updatev1.NotificationsConfig{
+			PagerDutyRoutingKeySecretRef: &updatev1.SecretKeyReference{Namespace: "node-updater", Name: "azure-devops", Key: "routingKey"},
+		},
+	}))
+	f.createSecret(t, map[string][]byte{"routingKey": []byte("key")})
+
+	result, err := f.reconcile(t)
+
+	if err != nil {
+		t.Fatalf("expected the configuration to be applied, got %v", err)
+	}
+	if !f.notifier.Configured() {
+		t.Error("expected the PagerDuty sink of the NodeUpdaterConfig to be configured")
+	}
+	if result.RequeueAfter != time.Hour {
+		t.Errorf("expected the routing key to be read again after the upgrade frequency, got %v", result.RequeueAfter)
+	}
+
+	if err := f.reconciler.Delete(context.Background(), newNodeUpdaterConfig(updatev1.NodeUpdaterConfigSpec{})); err != nil {
+		t.Fatalf("failed to delete the NodeUpdaterConfig: %v", err)
+	}
+	if _, err := f.reconcile(t); err != nil {
+		t.Fatalf("expected the removed configuration to be handled, got %v", err)
+	}
+	if f.notifier.Configured() {
+		t.Error("expected the notification sinks to fall back to the flags, which do not configure any")
+	}
+}
+
+func TestNodeUpdaterConfig_KeepsNotificationSinksWhenSecretIsMissing(t *testing.T) {
+	f := newConfigFixture(t, newNodeUpdaterConfig(updatev1.NodeUpdaterConfigSpec{
+		Notifications: &updatev1.NotificationsConfig{
+			WebhookURL:              "https://hooks.example.com/rotations",
+			OpsgenieAPIKeySecretRef: &updatev1.SecretKeyReference{Namespace: "node-updater", Name: "opsgenie"},
+		},
+	}))
+
+	if _, err := f.reconcile(t); err == nil {
+		t.Fatal("expected the missing API key to fail the reconcile")
+	}
+
+	if f.notifier.Configured() {
+		t.Error("expected the previous notification sinks to be kept")
+	}
+	if condition := f.appliedCondition(t); condition.Status != metav1.ConditionFalse || condition.Reason != updatev1.ReasonInvalidConfig {
+		t.Errorf("expected the configuration to be reported as invalid, got %s/%s", condition.Status, condition.Reason)
+	}
+}
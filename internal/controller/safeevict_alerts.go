@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/pkg/notify"
+)
+
+const (
+	// alertKindFailed is the kind of the alerts of a rotation waiting in the Failed phase
+	alertKindFailed = "failed"
+	// alertKindBlocked is the kind of the alerts of the upgrades ARM did not permit
+	alertKindBlocked = "blocked"
+)
+
+// updateAlerts opens the alerts of a rotation which entered the Failed phase or whose upgrades were blocked, and
+// resolves them once the failure or the block is over. The keys of the open alerts are kept in the status, so every
+// alert is opened and resolved once, also across restarts of the controller.
+func (c *SafeEvictReconciler) updateAlerts(ctx context.Context, r *rotation, phase updatev1.Phase) {
+	failedKeys := r.openAlerts(alertKindFailed)
+	failed := meta.FindStatusCondition(r.status.Conditions, updatev1.ConditionFailed)
+	switch {
+	case phase == updatev1.PhaseFailed && len(failedKeys) == 0 && failed != nil:
+		c.openAlerts(ctx, r, notify.EventRotationFailed, alertKindFailed, r.failedNodepools(), failed.Message)
+	case len(failedKeys) > 0 && (failed == nil || failed.Status != metav1.ConditionTrue || r.upToDate):
+		c.resolveAlerts(ctx, r, failedKeys, "Rotation is no longer failed")
+	}
+
+	blockedKeys := r.openAlerts(alertKindBlocked)
+	blocked := meta.FindStatusCondition(r.status.Conditions, updatev1.ConditionUpgradeBlocked)
+	switch {
+	case blocked != nil && blocked.Status == metav1.ConditionTrue && len(blockedKeys) == 0:
+		c.openAlerts(ctx, r, notify.EventUpgradeBlocked, alertKindBlocked, r.blockedNodepools, blocked.Message)
+	case len(blockedKeys) > 0 && (blocked == nil || blocked.Status != metav1.ConditionTrue):
+		c.resolveAlerts(ctx, r, blockedKeys, "Node image upgrades are permitted again")
+	}
+}
+
+// openAlerts sends the event with an alert key per nodepool and records the keys as open
+func (c *SafeEvictReconciler) openAlerts(ctx context.Context, r *rotation, event notify.Event, kind string, nodepools []string, message string) {
+	keys := []string{r.alertKey(kind, "")}
+	if len(nodepools) > 0 {
+		keys = make([]string, 0, len(nodepools))
+		for _, nodepoolName := range nodepools {
+			keys = append(keys, r.alertKey(kind, nodepoolName))
+		}
+	}
+	r.status.OpenAlerts = append(r.status.OpenAlerts, keys...)
+	c.notify(ctx, r, notify.Notification{Event: event, Nodepools: nodepools, AlertKeys: keys, Message: message})
+}
+
+// resolveAlerts sends the Recovered event of the open alerts and forgets them
+func (c *SafeEvictReconciler) resolveAlerts(ctx context.Context, r *rotation, keys []string, message string) {
+	r.status.OpenAlerts = slices.DeleteFunc(r.status.OpenAlerts, func(key string) bool {
+		return slices.Contains(keys, key)
+	})
+	if len(r.status.OpenAlerts) == 0 {
+		r.status.OpenAlerts = nil
+	}
+	c.notify(ctx, r, notify.Notification{Event: notify.EventRecovered, AlertKeys: keys, Message: message})
+}
+
+// alertKey returns the key the alerting services deduplicate the alert with, one per SafeEvict, cluster and nodepool
+func (r *rotation) alertKey(kind, nodepoolName string) string {
+	parts := []string{"node-updater", kind, r.safeEvict.Namespace, r.safeEvict.Name}
+	if r.target.clusterName != "" {
+		parts = append(parts, r.target.clusterName)
+	}
+	if nodepoolName != "" {
+		parts = append(parts, nodepoolName)
+	}
+	return strings.Join(parts, "/")
+}
+
+// openAlerts returns the keys of the open alerts of the kind
+func (r *rotation) openAlerts(kind string) []string {
+	var keys []string
+	for _, key := range r.status.OpenAlerts {
+		if strings.HasPrefix(key, "node-updater/"+kind+"/") {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// failedNodepools returns the nodepools whose last step failed
+func (r *rotation) failedNodepools() []string {
+	var nodepools []string
+	for _, pool := range r.status.Pools {
+		if pool.State == updatev1.NodepoolStateFailed {
+			nodepools = append(nodepools, pool.Name)
+		}
+	}
+	return nodepools
+}
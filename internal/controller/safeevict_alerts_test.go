@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/pkg/notify"
+)
+
+// runAlerts runs updateAlerts on the rotation as if its reconcile ended in the phase
+func (f *phaseFixture) runAlerts(t *testing.T, phase updatev1.Phase, blockedNodepools ...string) {
+	f.runPhase(t, func(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+		r.blockedNodepools = blockedNodepools
+		f.reconciler.updateAlerts(ctx, r, phase)
+		return phase, nil, nil
+	})
+}
+
+func (f *phaseFixture) setCondition(conditionType string, status metav1.ConditionStatus) {
+	meta.SetStatusCondition(&f.status.Conditions, metav1.Condition{Type: conditionType, Status: status, Reason: "Test", Message: conditionType})
+}
+
+func TestUpdateAlerts_OpensAndResolvesAlertsOnce(t *testing.T) {
+	f := newPhaseFixture(t)
+	var notifications []notify.Notification
+	f.reconciler.Notifier = notifierFunc(func(_ context.Context, notification notify.Notification) error {
+		notifications = append(notifications, notification)
+		return nil
+	})
+	f.status.SetNodepoolState(testNodepoolName, updatev1.NodepoolStateFailed, "upgrade failed")
+	f.setCondition(updatev1.ConditionFailed, metav1.ConditionTrue)
+	f.setCondition(updatev1.ConditionUpgradeBlocked, metav1.ConditionTrue)
+
+	f.runAlerts(t, updatev1.PhaseFailed, testNodepoolName)
+	f.runAlerts(t, updatev1.PhaseFailed)
+
+	failedKey := "node-updater/failed/" + f.safeEvict.Namespace + "/" + f.safeEvict.Name + "/" + testNodepoolName
+	blockedKey := "node-updater/blocked/" + f.safeEvict.Namespace + "/" + f.safeEvict.Name + "/" + testNodepoolName
+	if len(notifications) != 2 || notifications[0].Event != notify.EventRotationFailed || notifications[1].Event != notify.EventUpgradeBlocked {
+		t.Fatalf("expected the failed rotation and the blocked upgrade to be alerted once, got %+v", notifications)
+	}
+	if !slices.Equal(notifications[0].AlertKeys, []string{failedKey}) || !slices.Equal(notifications[1].AlertKeys, []string{blockedKey}) {
+		t.Errorf("expected an alert key per nodepool, got %v and %v", notifications[0].AlertKeys, notifications[1].AlertKeys)
+	}
+	if !slices.Equal(f.status.OpenAlerts, []string{failedKey, blockedKey}) {
+		t.Errorf("expected the open alerts to be recorded, got %v", f.status.OpenAlerts)
+	}
+
+	f.setCondition(updatev1.ConditionUpgradeBlocked, metav1.ConditionFalse)
+	f.runAlerts(t, updatev1.PhaseFailed)
+
+	if len(notifications) != 3 || notifications[2].Event != notify.EventRecovered || !slices.Equal(notifications[2].AlertKeys, []string{blockedKey}) {
+		t.Fatalf("expected the blocked upgrade to be resolved, got %+v", notifications)
+	}
+
+	// the retry of the rotation clears the Failed condition
+	f.setCondition(updatev1.ConditionFailed, metav1.ConditionFalse)
+	f.runAlerts(t, updatev1.PhaseProvisioningBackup)
+
+	if len(notifications) != 4 || notifications[3].Event != notify.EventRecovered || !slices.Equal(notifications[3].AlertKeys, []string{failedKey}) {
+		t.Fatalf("expected the failed rotation to be resolved, got %+v", notifications)
+	}
+	if f.status.OpenAlerts != nil {
+		t.Errorf("expected no open alert, got %v", f.status.OpenAlerts)
+	}
+}
@@ -18,26 +18,43 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
+	"norbinto/node-updater/internal/cluster"
 	"norbinto/node-updater/internal/configmap"
+	"norbinto/node-updater/internal/health"
+	"norbinto/node-updater/internal/identity"
+	"norbinto/node-updater/internal/impersonation"
+	"norbinto/node-updater/internal/metrics"
 	pod "norbinto/node-updater/internal/pod"
+	"norbinto/node-updater/internal/selfexclusion"
+	"norbinto/node-updater/pkg/notify"
+	"norbinto/node-updater/pkg/plan"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 
 	"norbinto/node-updater/internal/appconfig"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/audit"
 	nodepool "norbinto/node-updater/internal/nodepool"
 )
 
@@ -46,13 +63,42 @@ type SafeEvictReconciler struct {
 	client.Client
 	Scheme              *runtime.Scheme
 	KubeClient          kubernetes.Interface
-	PodController       *pod.PodController
-	ConfigmapController *configmap.ConfigMapController
-	NodepoolController  *nodepool.NodePoolController
-	Config              *appconfig.Config
-	Logger              *zap.Logger
+	PodController       pod.PodControllerInterface
+	ConfigmapController configmap.ConfigMapControllerInterface
+	NodepoolController  nodepool.NodePoolControllerInterface
+	Config              *appconfig.Store
+	HealthChecker       *health.HealthChecker
+	// ImpersonationFactory creates the clients for SafeEvicts with a ServiceAccountRef, it may be nil when impersonation is not used
+	ImpersonationFactory *impersonation.ClientFactory
+	// SelfExclusionController keeps the controller from evicting itself or upgrading the node it runs on, it may be nil
+	SelfExclusionController *selfexclusion.SelfExclusionController
+	// ClusterController provides the workload clusters of SafeEvicts with a ClusterSelector, it may be nil when the controller
+	// only updates its own cluster
+	ClusterController *cluster.ClusterController
+	// PlanReviewer vetoes or modifies the plans of the rotations before they start, it may be nil when the plans are
+	// not reviewed
+	PlanReviewer plan.Reviewer
+	// Notifier tells the teams running the agents about the rollbacks of the rotations, it may be nil when nobody is
+	// notified
+	Notifier notify.Notifier
+	// Auditor records the mutating actions of the reconciles for the compliance reviews, it may be nil when they are not
+	// audited
+	Auditor *audit.Auditor
+	// ReconcileTimeout is the deadline of a reconcile, so a hung call cannot block the work queue of the SafeEvict. A
+	// reconcile which runs out of it is requeued, 0 disables it.
+	ReconcileTimeout time.Duration
+	// StateNamespace is the namespace of the state ConfigMaps of the rotations, the state of a SafeEvict is kept in its
+	// own namespace when it is empty
+	StateNamespace string
+	// MaxConcurrentReconciles is the number of SafeEvicts reconciled at the same time, 0 reconciles one at a time
+	MaxConcurrentReconciles int
+	Logger                  *zap.Logger
 }
 
+// statusUpdateTimeout bounds the write of the status, which is detached from the deadline of the reconcile so the
+// outcome of a reconcile which ran out of it is still recorded
+const statusUpdateTimeout = 10 * time.Second
+
 // var (
 // 	saveEvictLog = ctrl.Log.WithName("safeEvict")
 // )
@@ -60,6 +106,8 @@ type SafeEvictReconciler struct {
 // +kubebuilder:rbac:groups=update.norbinto,resources=safeevicts,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=update.norbinto,resources=safeevicts/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=update.norbinto,resources=safeevicts/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -71,277 +119,543 @@ type SafeEvictReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.20.4/pkg/reconcile
 func (c *SafeEvictReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	c.Logger.Info("Reconciling SafeEvict resource", zap.String("namespace", req.Namespace), zap.String("name", req.Name))
+	ctx, stats := metrics.WithReconcileStats(ctx)
+	// the ID controller-runtime gives the reconcile is sent to ARM, so its activity log can be matched with the summary
+	correlationID := string(controller.ReconcileIDFromContext(ctx))
+	ctx = identity.WithCorrelationID(ctx, correlationID)
+	ctx = audit.WithSafeEvict(ctx, c.Auditor, req.Namespace, req.Name)
+	reconciler := c.withLogFields(
+		zap.String("safeEvictNamespace", req.Namespace),
+		zap.String("safeEvictName", req.Name),
+		zap.String("reconcileID", correlationID))
+	if c.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.ReconcileTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	result, err := reconciler.reconcile(ctx, req)
+	duration := time.Since(start)
+	err = deadlineExceeded(ctx, err)
+	metrics.ObserveReconcile(duration, err)
+	if errors.Is(err, errReconcileTimedOut) {
+		metrics.RecordReconcileTimeout(req.Namespace, req.Name)
+		reconciler.Logger.Warn("Reconcile ran out of its deadline, it is requeued", zap.Duration("timeout", c.ReconcileTimeout))
+		result = reconcile.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}
+	}
+	reconciler.logSummary(correlationID, stats, duration, err)
+	return result, err
+}
+
+// withLogFields returns a copy of the reconciler whose logger and controllers attach the fields to every entry, so the
+// entries of one reconcile can be told apart from those of the reconciles running next to it
+func (c *SafeEvictReconciler) withLogFields(fields ...zap.Field) *SafeEvictReconciler {
+	reconciler := *c
+	reconciler.Logger = c.Logger.With(fields...)
+	if c.PodController != nil {
+		reconciler.PodController = c.PodController.WithLogFields(fields...)
+	}
+	if c.NodepoolController != nil {
+		reconciler.NodepoolController = c.NodepoolController.WithLogFields(fields...)
+	}
+	if c.ConfigmapController != nil {
+		reconciler.ConfigmapController = c.ConfigmapController.WithLogFields(fields...)
+	}
+	return &reconciler
+}
+
+// logSummary logs what the reconcile did as a single record. A reconcile which checked no nodepool, e.g. because the
+// cluster is not due for a check, is only logged at debug level.
+func (c *SafeEvictReconciler) logSummary(correlationID string, stats *metrics.ReconcileStats, duration time.Duration, err error) {
+	log := c.Logger.Info
+	if stats.PoolsChecked.Load() == 0 && err == nil {
+		log = c.Logger.Debug
+	}
+	log("Reconcile summary",
+		zap.String("correlationID", correlationID),
+		zap.Int64("poolsChecked", stats.PoolsChecked.Load()),
+		zap.Int64("outdatedPools", stats.OutdatedPools.Load()),
+		zap.Int64("podsEvicted", stats.PodsEvicted.Load()),
+		zap.Int64("armCalls", stats.ARMCalls.Load()),
+		zap.Duration("duration", duration),
+		zap.Bool("failed", err != nil))
+}
+
+func (c *SafeEvictReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	c.Logger.Debug("Reconciling SafeEvict resource")
+	if c.HealthChecker != nil {
+		c.HealthChecker.ReconcileStarted(req.NamespacedName)
+		defer c.HealthChecker.ReconcileFinished(req.NamespacedName)
+	}
 
 	// Fetch the SafeEvict instance
 	safeEvict := &updatev1.SafeEvict{}
 	err := c.Client.Get(ctx, req.NamespacedName, safeEvict)
 	if err != nil {
-		c.Logger.Error("Failed to get SafeEvict resource", zap.Error(err), zap.String("namespace", req.Namespace), zap.String("name", req.Name))
-		return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, client.IgnoreNotFound(err)
+		c.Logger.Error("Failed to get SafeEvict resource", zap.Error(err))
+		if apierrors.IsNotFound(err) {
+			metrics.Forget(req.Namespace, req.Name)
+			err = c.deleteState(ctx, req)
+		}
+		return reconcile.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, client.IgnoreNotFound(err)
 	}
 
-	var outdatedNodes = make(map[string]corev1.Node)
-	var outdatedNodePools = make(map[string]armcontainerservice.AgentPool)
-	c.Logger.Debug("Checking if updates are needed for nodes and node pools...")
-	//check if we need to update something
-	outdatedNodes, outdatedNodePools, err = c.NodepoolController.UpdateNeeded(ctx, safeEvict.Spec.Nodepools)
-	if err != nil {
-		c.Logger.Error("Error determining if updates are needed for nodes and node pools", zap.Error(err))
-		return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, nil
+	if safeEvict.Annotations[updatev1.CheckNowAnnotation] != "" {
+		c.Logger.Info("Check of the nodepools is requested with annotation", zap.String("annotation", updatev1.CheckNowAnnotation))
 	}
 
-	notReadyPools, err := c.NodepoolController.GetNotReadyNodePools(ctx, safeEvict.Spec.Nodepools)
-	if err != nil {
-		c.Logger.Error("Failed to get not ready node pools", zap.Error(err))
-		return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+	var result ctrl.Result
+	if safeEvict.Spec.ClusterSelector == nil {
+		var target *clusterTarget
+		target, err = c.localClusterTarget(safeEvict)
+		if err != nil {
+			c.Logger.Error("Failed to create controllers for the ServiceAccount of the SafeEvict", zap.Error(err))
+			return reconcile.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
+		}
+		rotationStatus := *safeEvict.Status.RotationStatus.DeepCopy()
+		result, err = c.reconcileCluster(ctx, req, safeEvict, target, &rotationStatus)
+		err = deadlineExceeded(ctx, err)
+		statusErr := c.updateStatus(ctx, safeEvict, func(status *updatev1.SafeEvictStatus) {
+			status.RotationStatus = rotationStatus
+			setReadiness(status, safeEvict.Generation, []updatev1.Phase{rotationStatus.Phase}, err)
+		})
+		err = errors.Join(err, statusErr)
+	} else {
+		result, err = c.reconcileWorkloadClusters(ctx, req, safeEvict)
+	}
+	// a failed check is retried with the annotation in place
+	if err == nil {
+		err = c.removeAnnotation(ctx, safeEvict, updatev1.CheckNowAnnotation)
+	}
+	// an abort is acted on until no rotation is running anymore, in any workload cluster
+	if err == nil && !rotationInProgress(safeEvict.Status) {
+		err = c.removeAnnotation(ctx, safeEvict, updatev1.AbortAnnotation)
+	}
+	return result, err
+}
+
+// deleteState deletes the state ConfigMaps a deleted SafeEvict left in the state namespace, the ConfigMaps in its own
+// namespace are deleted by the garbage collector
+func (c *SafeEvictReconciler) deleteState(ctx context.Context, req ctrl.Request) error {
+	if c.StateNamespace == "" || c.StateNamespace == req.Namespace {
+		return nil
 	}
+	return c.ConfigmapController.DeleteStateConfigMaps(ctx, c.StateNamespace, req.NamespacedName)
+}
 
-	for poolName, pool := range notReadyPools {
-		outdatedNodePools[poolName] = pool
+// rotationInProgress returns true while a rotation runs in the cluster of the controller or in a workload cluster
+func rotationInProgress(status updatev1.SafeEvictStatus) bool {
+	if status.Phase.InProgress() {
+		return true
 	}
+	return slices.ContainsFunc(status.Clusters, func(clusterStatus updatev1.ClusterStatus) bool {
+		return clusterStatus.Phase.InProgress()
+	})
+}
 
-	c.Logger.Debug("Outdated nodes and node pools identified", zap.Int("outdatedNodes", len(outdatedNodes)), zap.Int("outdatedNodePools", len(outdatedNodePools)))
-	c.Logger.Debug("Checking if temporary nodepool exists", zap.String("temporaryNodepoolName", safeEvict.GetTemporaryNodepoolName()))
-	temporaryNodepoolExists, err := c.NodepoolController.NodePoolExists(ctx, safeEvict.GetTemporaryNodepoolName())
+// removeAnnotation removes the annotation from the SafeEvict once it was acted on, nothing is written when it is not set
+func (c *SafeEvictReconciler) removeAnnotation(ctx context.Context, safeEvict *updatev1.SafeEvict, annotation string) error {
+	if _, found := safeEvict.Annotations[annotation]; !found {
+		return nil
+	}
+	original := safeEvict.DeepCopy()
+	delete(safeEvict.Annotations, annotation)
+	err := c.Client.Patch(ctx, safeEvict, client.MergeFrom(original))
 	if err != nil {
-		c.Logger.Error("Failed to check if temporary nodepool exists", zap.Error(err))
-		return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+		c.Logger.Error("Failed to remove annotation", zap.Error(err), zap.String("annotation", annotation), zap.String("namespace", safeEvict.Namespace), zap.String("name", safeEvict.Name))
+		return fmt.Errorf("failed to remove annotation %s of SafeEvict '%s/%s': %w", annotation, safeEvict.Namespace, safeEvict.Name, err)
 	}
+	return nil
+}
 
-	if !temporaryNodepoolExists {
-
-		if len(outdatedNodes) == 0 && len(outdatedNodePools) == 0 {
-			c.Logger.Debug("No outdated nodes or node pools found, deleting ConfigMap and requeuing...")
-			err = c.ConfigmapController.DeleteConfigMap(req.Namespace, safeEvict.GetConfigmapName())
-			if err != nil {
-				c.Logger.Error("Failed to delete ConfigMap", zap.Error(err))
-				return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
-			}
-			c.Logger.Info(fmt.Sprintf("Cluster is up to date, requeuing for next reconciliation loop %d sec later", c.Config.UpgradeFrequency/time.Second))
-			return reconcile.Result{RequeueAfter: c.Config.UpgradeFrequency}, nil
-		}
-		c.Logger.Info("Temporary nodepool does not exist and outdated nodes or node pools are found, creating temporary nodepool...")
-		err = c.NodepoolController.CreateTemporaryNodePool(ctx, safeEvict.GetTemporaryNodepoolName(), safeEvict.Spec.BaseForBackupPool)
-		if err != nil {
-			c.Logger.Error("Failed to create temporary nodepool", zap.Error(err))
-			return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, nil
-		}
+// reconcileWorkloadClusters reconciles every workload cluster selected by the SafeEvict one after the other. A failing
+// cluster does not block the others, the errors are returned together and the shortest requeue wins.
+func (c *SafeEvictReconciler) reconcileWorkloadClusters(ctx context.Context, req ctrl.Request, safeEvict *updatev1.SafeEvict) (ctrl.Result, error) {
+	if c.ClusterController == nil {
+		err := fmt.Errorf("SafeEvict '%s' has a cluster selector but the management cluster mode is not configured", req.NamespacedName)
+		c.Logger.Error("Failed to reconcile workload clusters", zap.Error(err))
+		return reconcile.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
 	}
 
-	// Check if the temporary node pool is still being created
-	status, err := c.NodepoolController.GetNodePoolProvisioningState(ctx, safeEvict.GetTemporaryNodepoolName())
+	workloadClusters, failedClusters, err := c.ClusterController.GetWorkloadClusters(ctx, safeEvict.Namespace, safeEvict.Spec.ClusterSelector)
 	if err != nil {
-		return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
-	}
-	//TODO: look for an enum
-	if status == "Creating" {
-		c.Logger.Info("Temporary node pool is being created, requeuing...")
-		return reconcile.Result{RequeueAfter: c.Config.SuccessReconcileTime}, nil
-	}
-
-	configMapData, err := c.ConfigmapController.GetConfigMapData(req.Namespace, safeEvict.GetConfigmapName())
-	if apierrors.IsNotFound(err) {
-		configData := make(map[string]string)
-		for poolName, pool := range outdatedNodePools {
-			if pool.Properties.MinCount != nil || pool.Properties.MaxCount != nil {
-				configData[poolName] = fmt.Sprintf(`{"MinCount": %d, "MaxCount": %d}`, *pool.Properties.MinCount, *pool.Properties.MaxCount)
-			} else {
-				configData[poolName] = fmt.Sprintf(`{"Count": %d}`, *pool.Properties.Count)
-			}
-		}
-		c.Logger.Info("Creating ConfigMap with outdated node pool scaling information", zap.String("configMapName", safeEvict.GetConfigmapName()), zap.Any("data", configData))
-		err = c.ConfigmapController.CreateConfigMap(req.Namespace, safeEvict.GetConfigmapName(), configData)
+		c.Logger.Error("Failed to get workload clusters", zap.Error(err))
+		return reconcile.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
+	}
+
+	result := reconcile.Result{RequeueAfter: c.Config.Load().UpgradeFrequency}
+	clusterStatuses := make([]updatev1.ClusterStatus, 0, len(workloadClusters)+len(failedClusters))
+	var errs []error
+	// the clusters whose Secret cannot be used keep their status, the other clusters are reconciled
+	for _, clusterName := range slices.Sorted(maps.Keys(failedClusters)) {
+		c.Logger.Error("Failed to create clients for the workload cluster", zap.Error(failedClusters[clusterName]), zap.String("cluster", clusterName))
+		errs = append(errs, fmt.Errorf("cluster '%s': %w", clusterName, failedClusters[clusterName]))
+		clusterStatuses = append(clusterStatuses, safeEvict.Status.GetClusterStatus(clusterName))
+		result.RequeueAfter = min(result.RequeueAfter, c.Config.Load().ErrorReconcileTime)
+	}
+	for _, workloadCluster := range workloadClusters {
+		c.Logger.Debug("Reconciling workload cluster", zap.String("cluster", workloadCluster.Name))
+		clusterStatus := safeEvict.Status.GetClusterStatus(workloadCluster.Name)
+		target, err := c.workloadClusterTarget(safeEvict, workloadCluster)
 		if err != nil {
-			c.Logger.Error("Failed to create ConfigMap with outdated node pool scaling information", zap.Error(err))
-			return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+			c.Logger.Error("Failed to create controllers for the workload cluster", zap.Error(err), zap.String("cluster", workloadCluster.Name))
+			errs = append(errs, fmt.Errorf("cluster '%s': %w", workloadCluster.Name, err))
+			clusterStatuses = append(clusterStatuses, clusterStatus)
+			continue
 		}
-	} else {
+		clusterResult, err := c.reconcileCluster(ctx, req, safeEvict, target, &clusterStatus.RotationStatus)
 		if err != nil {
-			c.Logger.Error("Failed to retrieve ConfigMap data", zap.Error(err))
-			return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+			errs = append(errs, fmt.Errorf("cluster '%s': %w", workloadCluster.Name, err))
+		}
+		if clusterResult.RequeueAfter > 0 && clusterResult.RequeueAfter < result.RequeueAfter {
+			result.RequeueAfter = clusterResult.RequeueAfter
 		}
+		clusterStatuses = append(clusterStatuses, clusterStatus)
 	}
 
-	c.Logger.Debug("Starting to create evictions for outdated nodes and node pools...")
-	err = c.performSafeEviction(ctx, outdatedNodePools, safeEvict)
-	if err != nil {
-		c.Logger.Error("Failed to perform safe eviction", zap.Error(err))
-		return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+	phases := make([]updatev1.Phase, 0, len(clusterStatuses))
+	for _, clusterStatus := range clusterStatuses {
+		phases = append(phases, clusterStatus.Phase)
 	}
-	c.Logger.Debug("Safe eviction process is ready")
+	reconcileErr := deadlineExceeded(ctx, errors.Join(errs...))
+	// clusters which are not selected anymore are dropped from the status
+	statusErr := c.updateStatus(ctx, safeEvict, func(status *updatev1.SafeEvictStatus) {
+		status.Clusters = clusterStatuses
+		setReadiness(status, safeEvict.Generation, phases, reconcileErr)
+	})
+	return result, errors.Join(reconcileErr, statusErr)
+}
 
-	for _, nodepoolName := range safeEvict.Spec.Nodepools {
-		c.Logger.Debug("Processing Nodepool", zap.String("nodepoolName", nodepoolName))
-		nodes, err := c.NodepoolController.GetNodesByNodePool(ctx, nodepoolName)
-		if err != nil {
-			c.Logger.Error("Failed to get nodes by nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
-			return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+// errReconcileTimedOut marks the error of a reconcile which ran out of its deadline
+var errReconcileTimedOut = errors.New("reconcile ran out of its deadline")
+
+// deadlineExceeded marks the error of a reconcile whose deadline passed with errReconcileTimedOut. The error of a call
+// cut by the deadline does not always wrap context.DeadlineExceeded, and a call timing out on its own does not mean the
+// reconcile ran out of its deadline.
+func deadlineExceeded(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, errReconcileTimedOut) || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errReconcileTimedOut, err)
+}
+
+// reconcileCluster runs the phases of the rotation of the target cluster, starting from the phase in the status, until
+// one of them has to wait. The phase the cluster is left in and the state of its nodepools are recorded in the status.
+func (c *SafeEvictReconciler) reconcileCluster(ctx context.Context, req ctrl.Request, safeEvict *updatev1.SafeEvict, target *clusterTarget, status *updatev1.RotationStatus) (clusterResult ctrl.Result, err error) {
+	ctx = audit.WithCluster(ctx, target.clusterName)
+	if status.Phase == "" {
+		status.Phase = updatev1.PhaseDetecting
+	}
+	if err := c.migrateState(ctx, safeEvict, target); err != nil {
+		c.Logger.Error("Failed to move the state ConfigMaps to the state namespace", zap.Error(err), zap.String("cluster", target.clusterName))
+		return reconcile.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
+	}
+	if wait := untilNextCheck(safeEvict, status); wait > 0 {
+		c.Logger.Debug("Cluster is not due for a check, requeuing until the next check time", zap.Time("nextCheckTime", status.NextCheckTime.Time), zap.String("cluster", target.clusterName))
+		return reconcile.Result{RequeueAfter: wait}, nil
+	}
+	phase := status.Phase
+	defer func() {
+		status.Phase = phase
+		status.NextCheckTime = nil
+		if phase == updatev1.PhaseDetecting && clusterResult.RequeueAfter > 0 {
+			status.NextCheckTime = &metav1.Time{Time: time.Now().Add(clusterResult.RequeueAfter).Truncate(time.Second)}
 		}
+		metrics.RecordRotation(req.Namespace, req.Name, target.clusterName, phase, status.StartTime)
+	}()
 
-		c.Logger.Debug("Checking for running stateful pods in the nodepool", zap.String("nodepoolName", nodepoolName), zap.Int("nodesCount", len(nodes)))
-		// Check if any nodes in the nodepool still have pods running in the specified namespaces
-		hasRunningPods, err := c.NodepoolController.HasRunningStatefulPods(ctx, nodes, safeEvict.Spec.Namespaces)
+	if safeEvict.Spec.RequireControllerExcluded && target.selfExclusionController != nil {
+		ownNodePool, err := target.selfExclusionController.GetOwnNodePool(ctx)
 		if err != nil {
-			c.Logger.Error("Error checking for running stateful pods in the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
-			return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+			return reconcile.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
 		}
-		if !hasRunningPods {
-			c.Logger.Debug("No nodes in the nodepool still have running pods in the specified namespaces, updating node images...")
-
-			nodepool, err := c.NodepoolController.GetNodePoolByName(ctx, nodepoolName)
-			if err != nil {
-				c.Logger.Error("Failed to get nodepool by name", zap.Error(err), zap.String("nodepoolName", nodepoolName))
-				return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
-			}
-
-			if nodepool.Properties != nil && nodepool.Properties.ProvisioningState != nil && *nodepool.Properties.ProvisioningState == "UpgradingNodeImageVersion" {
-				c.Logger.Info(fmt.Sprintf("Node pool '%s' is still running a node image upgrade", *nodepool.Name))
-				return reconcile.Result{RequeueAfter: c.Config.SuccessReconcileTime}, nil
-			}
-
-			c.Logger.Debug("Starting to upgrade node image version", zap.String("nodepoolName", nodepoolName))
-			err = c.NodepoolController.UpgradeNodeImageVersion(ctx, nodepool)
-			if err != nil {
-				c.Logger.Error("Failed to upgrade node image version", zap.Error(err), zap.String("nodepoolName", nodepoolName))
-				return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
-			}
-
-		} else {
-			if _, exists := outdatedNodePools[nodepoolName]; exists {
-				c.Logger.Info(fmt.Sprintf("Nodepool '%s' still has running stateful pods", nodepoolName))
-			}
+		if slices.Contains(safeEvict.Spec.Nodepools, ownNodePool) {
+			c.Logger.Error("Controller runs on a monitored nodepool, but it is required to be excluded", zap.String("nodepoolName", ownNodePool))
+			return reconcile.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, fmt.Errorf("controller runs on nodepool '%s' which is monitored by SafeEvict '%s'", ownNodePool, req.NamespacedName)
 		}
 	}
 
-	// if the nodepool is not outdated and cordoned, we should uncordon it
-	for nodepoolName := range configMapData {
-		if _, exists := outdatedNodePools[nodepoolName]; !exists {
-			c.Logger.Debug("Nodepool is ready to take workload again", zap.String("nodepoolName", nodepoolName))
-			nodepool, err := c.NodepoolController.GetNodePoolByName(ctx, nodepoolName)
-			if err != nil {
-				c.Logger.Error("Failed to get nodepool by name", zap.Error(err), zap.String("nodepoolName", nodepoolName))
-				return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
-			}
-			c.Logger.Debug("Restoring original scaling settings for the nodepool", zap.String("nodepoolName", nodepoolName), zap.String("scalingSettings", configMapData[nodepoolName]))
-			err = c.NodepoolController.SetDefaultScaling(ctx, nodepool, configMapData[nodepoolName])
-			if err != nil {
-				if nodepool.Properties != nil && nodepool.Properties.ProvisioningState != nil && *nodepool.Properties.ProvisioningState == "Updating" {
-					c.Logger.Debug(fmt.Sprintf("Node pool '%s' is still running a node image upgrade", *nodepool.Name))
-					return reconcile.Result{RequeueAfter: c.Config.SuccessReconcileTime}, nil
-				}
-				c.Logger.Error("Failed to restore original scaling settings for the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
-				return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
-			}
-			c.Logger.Debug("Restore of original scaling settings is completed", zap.String("nodepoolName", nodepoolName))
-			c.Logger.Debug("Uncordoning nodes in the nodepool", zap.String("nodepoolName", nodepoolName))
-			c.NodepoolController.CordonNodesByAgentPool(ctx, nodepoolName, false)
-			c.Logger.Debug("Nodes in the nodepool have been uncordoned", zap.String("nodepoolName", nodepoolName))
-		}
+	r, result, err := c.observeRotation(ctx, req, safeEvict, target, status)
+	if result != nil {
+		return *result, err
+	}
+	defer func() {
+		c.updateAlerts(ctx, r, phase)
+	}()
+
+	if upgradeTimedOut(safeEvict, status, phase) {
+		c.Logger.Error("Rotation exceeded the upgrade timeout, rolling it back", zap.String("phase", string(phase)), zap.Duration("upgradeTimeout", safeEvict.Spec.UpgradeTimeout.Duration))
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               updatev1.ConditionFailed,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: safeEvict.Generation,
+			Reason:             updatev1.ReasonUpgradeTimeout,
+			Message:            fmt.Sprintf("Rotation did not finish within %s, it was stopped in phase %s", safeEvict.Spec.UpgradeTimeout.Duration, phase),
+		})
+		phase = updatev1.PhaseRollingBack
+	}
+	if abortRequested(safeEvict, status, phase) {
+		c.Logger.Warn("Rotation is aborted with annotation, rolling it back", zap.String("phase", string(phase)), zap.String("annotation", updatev1.AbortAnnotation))
+		message := fmt.Sprintf("Rotation was aborted with annotation %s in phase %s", updatev1.AbortAnnotation, phase)
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               updatev1.ConditionFailed,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: safeEvict.Generation,
+			Reason:             updatev1.ReasonAborted,
+			Message:            message,
+		})
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               updatev1.ConditionAborted,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: safeEvict.Generation,
+			Reason:             updatev1.ReasonAbortRequested,
+			Message:            message,
+		})
+		phase = updatev1.PhaseRollingBack
 	}
 
-	if len(outdatedNodes) == 0 && len(outdatedNodePools) == 0 {
-		c.Logger.Info("All nodepools are up to date, cleaning up temporary resources")
-		temporaryNodepool, err := c.NodepoolController.GetNodePoolByName(ctx, safeEvict.GetTemporaryNodepoolName())
-		if err != nil && !apierrors.IsNotFound(err) {
-			c.Logger.Error("Failed to get temporary nodepool by name", zap.Error(err), zap.String("temporaryNodepoolName", safeEvict.GetTemporaryNodepoolName()))
-			return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+	steps := c.phaseSteps()
+	// every phase runs at most once per reconcile, so a rotation which goes back and forth cannot spin
+	for range len(steps) {
+		step, exists := steps[phase]
+		if !exists {
+			c.Logger.Error("Unknown phase, restarting the rotation from detection", zap.String("phase", string(phase)))
+			phase = updatev1.PhaseDetecting
+			continue
 		}
-
-		temporaryNodepoolMap := map[string]armcontainerservice.AgentPool{
-			*temporaryNodepool.Name: *temporaryNodepool,
+		next, result, err := step(ctx, r)
+		if next != phase {
+			c.Logger.Info("Rotation moves to the next phase", zap.String("from", string(phase)), zap.String("to", string(next)))
+			phase = next
 		}
-		c.Logger.Debug("Disabling auto-scaling for the temporary nodepool", zap.String("temporaryNodepoolName", safeEvict.GetTemporaryNodepoolName()))
-		err = c.NodepoolController.DisableAutoScaling(ctx, temporaryNodepoolMap)
-		if err != nil {
-			c.Logger.Error("Failed to disable auto-scaling for the temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", safeEvict.GetTemporaryNodepoolName()))
-			return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+		if result != nil {
+			return *result, err
 		}
+	}
 
-		temporaryNodes, err := c.NodepoolController.GetNodesByNodePool(ctx, *temporaryNodepool.Name)
-		if err != nil {
-			c.Logger.Error("Failed to get nodes by temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", *temporaryNodepool.Name))
-			return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
-		}
+	c.Logger.Info("Reconciliation loop completed", zap.String("phase", string(phase)))
+	return reconcile.Result{RequeueAfter: c.Config.Load().SuccessReconcileTime}, nil
+}
 
-		temporaryNodesMap := make(map[string]corev1.Node)
-		for _, node := range temporaryNodes {
-			temporaryNodesMap[node.Name] = node
-		}
+// untilNextCheck returns the time left until the next check of a cluster which waits in Detecting, e.g. when the
+// reconcile is triggered by the update of the status. A changed spec and the check-now annotation are acted on right
+// away.
+func untilNextCheck(safeEvict *updatev1.SafeEvict, status *updatev1.RotationStatus) time.Duration {
+	if status.Phase != updatev1.PhaseDetecting || status.NextCheckTime == nil {
+		return 0
+	}
+	if safeEvict.Generation != safeEvict.Status.ObservedGeneration || safeEvict.Annotations[updatev1.CheckNowAnnotation] != "" {
+		return 0
+	}
+	return time.Until(status.NextCheckTime.Time)
+}
 
-		c.Logger.Debug("Starting to perform pod eviction from the temporary nodepool", zap.String("temporaryNodepoolName", *temporaryNodepool.Name))
-		c.performSafeEviction(ctx, temporaryNodepoolMap, safeEvict)
-		c.Logger.Debug("Pod evictions from the temporary nodepool are completed", zap.String("temporaryNodepoolName", *temporaryNodepool.Name))
+// setReadiness summarizes the phases of the rotations in the Ready and Progressing conditions and records the
+// reconciled generation, so GitOps tools can compute the health of the SafeEvict
+func setReadiness(status *updatev1.SafeEvictStatus, generation int64, phases []updatev1.Phase, reconcileErr error) {
+	status.ObservedGeneration = generation
+	ready := metav1.Condition{
+		Type:               updatev1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             updatev1.ReasonReconciled,
+		Message:            "SafeEvict is reconciled",
+	}
+	progressing := metav1.Condition{
+		Type:               updatev1.ConditionProgressing,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             updatev1.ReasonUpToDate,
+		Message:            "No rotation is running",
+	}
 
-		c.Logger.Debug("Checking for running stateful pods in the temporary nodepool", zap.String("temporaryNodepoolName", *temporaryNodepool.Name), zap.Int("nodesCount", len(temporaryNodes)))
-		// Check if any nodes in the nodepool still have pods running in the specified namespaces
-		hasRunningPods, err := c.NodepoolController.HasRunningStatefulPods(ctx, temporaryNodes, safeEvict.Spec.Namespaces)
-		if err != nil {
-			c.Logger.Error("Error checking for running stateful pods in the temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", *temporaryNodepool.Name))
-			return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
+	if slices.ContainsFunc(phases, updatev1.Phase.InProgress) {
+		progressing.Status = metav1.ConditionTrue
+		progressing.Reason = updatev1.ReasonRotationInProgress
+		progressing.Message = "Rotation is running"
+	}
+	if slices.Contains(phases, updatev1.PhaseFailed) {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = updatev1.ReasonRotationFailed
+		ready.Message = "Rotation was rolled back, it is retried at the next upgrade check"
+		if progressing.Status == metav1.ConditionFalse {
+			progressing.Reason = updatev1.ReasonRotationFailed
+			progressing.Message = ready.Message
 		}
-		if !hasRunningPods {
-			c.Logger.Debug("All stateful pods have been evicted from the temporary nodepool,removing it...", zap.String("temporaryNodepoolName", *temporaryNodepool.Name))
-			err = c.NodepoolController.RemoveTemporaryNodePool(ctx, safeEvict.GetTemporaryNodepoolName())
-			if err != nil {
-				c.Logger.Error("Failed to remove temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", safeEvict.GetTemporaryNodepoolName()))
-				return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, nil
-			}
-			c.Logger.Info("Temporary nodepool has been removed successfully", zap.String("temporaryNodepoolName", safeEvict.GetTemporaryNodepoolName()))
-			c.Logger.Debug("Starting to delete temporary ConfigMap", zap.String("configMapName", safeEvict.GetConfigmapName()))
-			err = c.ConfigmapController.DeleteConfigMap(req.Namespace, safeEvict.GetConfigmapName())
-			if err != nil {
-				return reconcile.Result{RequeueAfter: c.Config.ErrorReconcileTime}, err
-			}
-			c.Logger.Info("ConfigMap deleted successfully", zap.String("configMapName", safeEvict.GetConfigmapName()))
-
+	}
+	if reconcileErr != nil {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = updatev1.ReasonReconcileError
+		ready.Message = reconcileErr.Error()
+		if errors.Is(reconcileErr, errReconcileTimedOut) {
+			ready.Reason = updatev1.ReasonReconcileTimedOut
 		}
 	}
 
-	c.Logger.Info("Reconciliation loop completed", zap.String("namespace", req.Namespace), zap.String("name", req.Name))
-	return reconcile.Result{RequeueAfter: c.Config.SuccessReconcileTime}, nil
+	meta.SetStatusCondition(&status.Conditions, ready)
+	meta.SetStatusCondition(&status.Conditions, progressing)
 }
 
-func (c *SafeEvictReconciler) performSafeEviction(ctx context.Context, outdatedNodePools map[string]armcontainerservice.AgentPool, safeEvict *updatev1.SafeEvict) error {
+// updateStatus applies the change to the status of the SafeEvict, it is only written when it changed
+func (c *SafeEvictReconciler) updateStatus(ctx context.Context, safeEvict *updatev1.SafeEvict, mutate func(status *updatev1.SafeEvictStatus)) error {
+	original := safeEvict.DeepCopy()
+	mutate(&safeEvict.Status)
+	if equality.Semantic.DeepEqual(original.Status, safeEvict.Status) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusUpdateTimeout)
+	defer cancel()
+	err := c.Client.Status().Patch(ctx, safeEvict, client.MergeFrom(original))
+	if err != nil {
+		c.Logger.Error("Failed to update SafeEvict status", zap.Error(err), zap.String("namespace", safeEvict.Namespace), zap.String("name", safeEvict.Name))
+		return fmt.Errorf("failed to update status of SafeEvict '%s/%s': %w", safeEvict.Namespace, safeEvict.Name, err)
+	}
+	return nil
+}
 
-	c.Logger.Debug("Disabling auto-scaling for node pools...")
-	err := c.NodepoolController.DisableAutoScaling(ctx, outdatedNodePools)
+// rescheduleControllerFrom moves the controller off the cordoned nodepool before it is upgraded or removed, so the
+// rotation is not interrupted in the middle. It returns true when the controller pod is being replaced.
+func (c *SafeEvictReconciler) rescheduleControllerFrom(ctx context.Context, target *clusterTarget, nodepoolName string) (bool, error) {
+	if target.selfExclusionController == nil {
+		return false, nil
+	}
+	rescheduled, err := target.selfExclusionController.RescheduleFromNodePool(ctx, nodepoolName)
 	if err != nil {
-		c.Logger.Error("Failed to disable auto-scaling for node pools", zap.Error(err))
-		return err
+		c.Logger.Error("Failed to reschedule the controller from the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
 	}
+	return rescheduled, nil
+}
 
-	for poolName, _ := range outdatedNodePools {
-		err = c.NodepoolController.CordonNodesByAgentPool(ctx, poolName, true) //todo delete
-		if err != nil {
-			c.Logger.Error("Failed to cordon nodes", zap.Error(err))
-			return err
-		}
+// rescheduleControllerFromNode moves the controller off the cordoned node before it is reimaged. It returns true when the
+// controller pod is being replaced.
+func (c *SafeEvictReconciler) rescheduleControllerFromNode(ctx context.Context, target *clusterTarget, nodeName string) (bool, error) {
+	if target.selfExclusionController == nil {
+		return false, nil
+	}
+	rescheduled, err := target.selfExclusionController.RescheduleFromNode(ctx, nodeName)
+	if err != nil {
+		c.Logger.Error("Failed to reschedule the controller from the node", zap.Error(err), zap.String("nodeName", nodeName))
+		return false, err
+	}
+	return rescheduled, nil
+}
+
+// clusterTarget holds the controllers and the state of one cluster reconciled for a SafeEvict
+type clusterTarget struct {
+	podController           pod.PodControllerInterface
+	nodepoolController      nodepool.NodePoolControllerInterface
+	selfExclusionController *selfexclusion.SelfExclusionController
+	configmapName           string
+	// configmapNamespace is the namespace of the state ConfigMaps of the cluster
+	configmapNamespace string
+	// usageConfigmapName holds the usage history of the cluster for the auto schedule
+	usageConfigmapName string
+	// clusterName is the name of the workload cluster, it is empty for the cluster of the controller
+	clusterName string
+}
+
+// localClusterTarget returns the target of the cluster the controller runs in
+func (c *SafeEvictReconciler) localClusterTarget(safeEvict *updatev1.SafeEvict) (*clusterTarget, error) {
+	podController, nodepoolController, err := mutatingControllers(safeEvict, c.PodController, c.NodepoolController, c.ImpersonationFactory)
+	if err != nil {
+		return nil, err
+	}
+	configmapNamespace, configmapName := c.stateConfigMap(safeEvict, safeEvict.GetConfigmapName())
+	_, usageConfigmapName := c.stateConfigMap(safeEvict, safeEvict.GetUsageConfigmapName())
+	return &clusterTarget{
+		podController:           podController,
+		nodepoolController:      nodepoolController,
+		selfExclusionController: c.SelfExclusionController,
+		configmapName:           configmapName,
+		configmapNamespace:      configmapNamespace,
+		usageConfigmapName:      usageConfigmapName,
+	}, nil
+}
+
+// workloadClusterTarget returns the target of a workload cluster managed from the management cluster. The state of every
+// workload cluster is kept in its own ConfigMap in the management cluster.
+func (c *SafeEvictReconciler) workloadClusterTarget(safeEvict *updatev1.SafeEvict, workloadCluster *cluster.WorkloadCluster) (*clusterTarget, error) {
+	podController, nodepoolController, err := mutatingControllers(
+		safeEvict,
+		c.PodController.WithKubeClient(workloadCluster.KubeClient).WithDrainSignaler(workloadCluster.DrainSignaler),
+		c.NodepoolController.WithCluster(workloadCluster.KubeClient, workloadCluster.AgentPoolClient, workloadCluster.SubscriptionID, workloadCluster.ResourceGroup, workloadCluster.ClusterName).
+			WithManagedClusterClient(workloadCluster.ManagedClusterClient).
+			WithScaleSetVMsClient(workloadCluster.ScaleSetVMsClient),
+		workloadCluster.ImpersonationFactory)
+	if err != nil {
+		return nil, err
+	}
+	configmapNamespace, configmapName := c.stateConfigMap(safeEvict, safeEvict.GetClusterConfigmapName(workloadCluster.Name))
+	_, usageConfigmapName := c.stateConfigMap(safeEvict, safeEvict.GetClusterUsageConfigmapName(workloadCluster.Name))
+	return &clusterTarget{
+		podController:      podController,
+		nodepoolController: nodepoolController,
+		configmapName:      configmapName,
+		configmapNamespace: configmapNamespace,
+		usageConfigmapName: usageConfigmapName,
+		clusterName:        workloadCluster.Name,
+	}, nil
+}
 
-		safeToEvictPods, err := c.PodController.GetSafeToEvictPods(ctx, safeEvict.Spec)
+// stateConfigMap returns the namespace and the name of a state ConfigMap of the SafeEvict. In the state namespace the
+// name is prefixed with the namespace of the SafeEvict, the SafeEvicts of two namespaces may have the same name.
+func (c *SafeEvictReconciler) stateConfigMap(safeEvict *updatev1.SafeEvict, name string) (string, string) {
+	if c.StateNamespace == "" || c.StateNamespace == safeEvict.Namespace {
+		return safeEvict.Namespace, name
+	}
+	return c.StateNamespace, safeEvict.Namespace + "." + name
+}
+
+// migrateState moves the state ConfigMaps an earlier version of the controller created in the namespace of the SafeEvict
+// into the state namespace, so a rotation which was running during the upgrade of the controller finishes with the
+// scaling it saved. A ConfigMap which cannot be read anymore is left behind.
+func (c *SafeEvictReconciler) migrateState(ctx context.Context, safeEvict *updatev1.SafeEvict, target *clusterTarget) error {
+	if target.configmapNamespace == safeEvict.Namespace {
+		return nil
+	}
+	for _, configmapName := range []string{target.configmapName, target.usageConfigmapName} {
+		legacyName := strings.TrimPrefix(configmapName, safeEvict.Namespace+".")
+		data, err := c.ConfigmapController.GetConfigMapData(ctx, safeEvict.Namespace, legacyName)
+		if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+			continue
+		}
 		if err != nil {
-			c.Logger.Error("Failed to get safe-to-evict pods", zap.Error(err))
 			return err
 		}
-		nodes, err := c.NodepoolController.GetNodesByNodePool(ctx, poolName)
-		if err != nil {
-			c.Logger.Error("Failed to get safe-to-evict pods", zap.Error(err))
+		// the data of a ConfigMap which was already copied by a reconcile which failed to delete the legacy one is kept
+		if err := c.ConfigmapController.CreateConfigMap(ctx, target.configmapNamespace, configmapName, data, safeEvict); err != nil {
 			return err
 		}
-		//only pods which runs on outdated nodes
-		safeToEvictPods = filterPodsOnNodes(safeToEvictPods, nodes)
-
-		err = c.PodController.EvictIdlePods(ctx, safeToEvictPods)
-		if err != nil {
-			c.Logger.Error("Failed to evict idle pods", zap.Error(err))
+		if err := c.ConfigmapController.DeleteConfigMap(ctx, safeEvict.Namespace, legacyName); err != nil {
 			return err
 		}
+		c.Logger.Info("State ConfigMap is moved to the state namespace", zap.String("configMapName", legacyName), zap.String("stateNamespace", target.configmapNamespace))
 	}
-
-	c.Logger.Debug("Eviction process completed for safe-to-evict pods")
 	return nil
 }
 
+// mutatingControllers returns the controllers used by the reconcile of the SafeEvict. The CronJobs of the evicted agents
+// are suspended for the SafeEvict. When the SafeEvict references a ServiceAccount, the nodes are cordoned, the jobs are
+// deleted, the pods are evicted and the drain signal commands are executed in the name of that ServiceAccount of the
+// SafeEvict's namespace. Without it no drain signal command is executed.
+func mutatingControllers(safeEvict *updatev1.SafeEvict, podController pod.PodControllerInterface, nodepoolController nodepool.NodePoolControllerInterface, impersonationFactory *impersonation.ClientFactory) (pod.PodControllerInterface, nodepool.NodePoolControllerInterface, error) {
+	podController = podController.WithOwner(safeEvict.GetOwnerTag())
+	if safeEvict.Spec.ServiceAccountRef == nil {
+		return podController, nodepoolController, nil
+	}
+	if impersonationFactory == nil {
+		return nil, nil, fmt.Errorf("SafeEvict references ServiceAccount '%s' but impersonation is not configured", safeEvict.Spec.ServiceAccountRef.Name)
+	}
+
+	mutationClient, err := impersonationFactory.ForServiceAccount(safeEvict.Namespace, safeEvict.Spec.ServiceAccountRef.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	execConfig := impersonationFactory.ConfigForServiceAccount(safeEvict.Namespace, safeEvict.Spec.ServiceAccountRef.Name)
+	return podController.WithMutationClient(mutationClient).WithExecConfig(execConfig), nodepoolController.WithMutationClient(mutationClient), nil
+}
+
 func filterPodsOnNodes(safeToEvictPods []corev1.Pod, outdatedNodes []corev1.Node) []corev1.Pod {
 	filteredPods := make([]corev1.Pod, 0)
 	for _, pod := range safeToEvictPods {
@@ -358,7 +672,11 @@ func filterPodsOnNodes(safeToEvictPods []corev1.Pod, outdatedNodes []corev1.Node
 // SetupWithManager sets up the controller with the Manager.
 func (r *SafeEvictReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&updatev1.SafeEvict{}).
 		Named("safeevict").
+		Watches(&updatev1.SafeEvict{}, requestedCheckHandler{EventHandler: &handler.EnqueueRequestForObject{}}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			NewQueue:                newRequeuePriorityQueue,
+		}).
 		Complete(r)
 }
@@ -0,0 +1,2325 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/azuredevops"
+	"norbinto/node-updater/internal/metrics"
+	"norbinto/node-updater/internal/nodepool"
+	"norbinto/node-updater/internal/pod"
+	"norbinto/node-updater/internal/utilization"
+	"norbinto/node-updater/pkg/notify"
+	"norbinto/node-updater/pkg/plan"
+)
+
+const (
+	// nodeTaintsKeySuffix is appended to the nodepool name in the ConfigMap key of its saved node taints
+	nodeTaintsKeySuffix = ".taints"
+	// scalingChecksumKey is the ConfigMap key of the checksum of the saved scaling and node taints, it contains a dot
+	// so it never collides with the saved scaling of a nodepool
+	scalingChecksumKey = "checksum.sha256"
+	// usageSampleInterval is the minimum time between two samples of the usage history
+	usageSampleInterval = 15 * time.Minute
+	// quietHourCount is the number of the least busy hours of the day in which the auto schedule starts rotations
+	quietHourCount = 4
+	// maxConflictBackoff is the longest wait before a change which ARM refused with a conflict is retried
+	maxConflictBackoff = 5 * time.Minute
+)
+
+// errPlacementImpossible is returned when an agent pod of an outdated nodepool could not be scheduled on the temporary
+// nodepool, the nodepool is not cordoned until its pods fit
+var errPlacementImpossible = errors.New("agent pods cannot be placed on the temporary nodepool")
+
+// rotation is the snapshot of a cluster which the phases of one reconcile work on
+type rotation struct {
+	req               ctrl.Request
+	safeEvict         *updatev1.SafeEvict
+	target            *clusterTarget
+	outdatedNodes     map[string]corev1.Node
+	outdatedNodePools map[string]armcontainerservice.AgentPool
+	// nodepools are the nodepools of the spec which exist in the cluster
+	nodepools []string
+	// status is the status of the cluster, the phases record the state of the nodepools in it
+	status *updatev1.RotationStatus
+	// evictionsPaused holds back the evictions of this reconcile while agent pods are stuck Pending
+	evictionsPaused bool
+	// upToDate is set when this reconcile found no outdated nodes or nodepools
+	upToDate bool
+	// blockedNodepools are the nodepools whose upgrade ARM did not permit in this reconcile
+	blockedNodepools []string
+	// skippedSystemNodepools are the outdated system nodepools left out of the rotation which starts in this reconcile
+	skippedSystemNodepools []string
+}
+
+// nodepoolFailed records the failure of a step on one nodepool and returns the error annotated with the nodepool name
+func (r *rotation) nodepoolFailed(nodepoolName string, err error) error {
+	r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateFailed, err.Error())
+	r.status.SetNodepoolError(nodepoolName, nodepoolError(err))
+	return fmt.Errorf("nodepool '%s': %w", nodepoolName, err)
+}
+
+// nodepoolError stamps the error of a nodepool with the current time and the dependency which failed
+func nodepoolError(err error) updatev1.NodepoolError {
+	category, retryable := classifyError(err)
+	return updatev1.NodepoolError{
+		Time:      metav1.Now(),
+		Category:  category,
+		Message:   err.Error(),
+		Retryable: retryable,
+	}
+}
+
+// classifyError attributes the error to the dependency which failed and tells whether retrying the step is expected to
+// help: throttling, conflicts, timeouts and server errors are retryable, refused requests and failed agent pools need
+// the configuration or the cluster to be fixed first
+func classifyError(err error) (updatev1.ErrorCategory, bool) {
+	var requestErr *azuredevops.RequestError
+	if errors.As(err, &requestErr) {
+		return updatev1.ErrorCategoryDevOps, requestErr.Retryable()
+	}
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		return updatev1.ErrorCategoryAzure, responseErr.StatusCode == http.StatusConflict ||
+			responseErr.StatusCode == http.StatusTooManyRequests ||
+			responseErr.StatusCode >= http.StatusInternalServerError
+	}
+	switch {
+	case errors.Is(err, azuredevops.ErrNotConfigured),
+		errors.Is(err, azuredevops.ErrInvalidPoolName):
+		return updatev1.ErrorCategoryDevOps, false
+	case errors.Is(err, nodepool.ErrProvisioningTimeout):
+		return updatev1.ErrorCategoryAzure, true
+	case errors.Is(err, nodepool.ErrProvisioningFailed),
+		errors.Is(err, nodepool.ErrArchitectureMismatch),
+		errors.Is(err, nodepool.ErrUpgradeNotPermitted),
+		errors.Is(err, nodepool.ErrReimageNotSupported):
+		return updatev1.ErrorCategoryAzure, false
+	}
+	var statusErr apierrors.APIStatus
+	if errors.As(err, &statusErr) {
+		return updatev1.ErrorCategoryKubernetes, apierrors.IsConflict(err) ||
+			apierrors.IsTooManyRequests(err) ||
+			apierrors.IsServerTimeout(err) ||
+			apierrors.IsTimeout(err) ||
+			apierrors.IsInternalError(err) ||
+			apierrors.IsServiceUnavailable(err)
+	}
+	return updatev1.ErrorCategoryUnknown, false
+}
+
+// phaseStep runs one phase of the rotation and returns the phase the rotation moves to. A nil result continues with
+// the returned phase in the same reconcile, otherwise the reconcile stops and is requeued after the result.
+type phaseStep func(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error)
+
+func (c *SafeEvictReconciler) phaseSteps() map[updatev1.Phase]phaseStep {
+	return map[updatev1.Phase]phaseStep{
+		updatev1.PhaseDetecting:          c.detect,
+		updatev1.PhaseProvisioningBackup: c.provisionBackup,
+		updatev1.PhaseDraining:           c.drain,
+		updatev1.PhaseUpgrading:          c.awaitUpgrade,
+		updatev1.PhaseRestoring:          c.restore,
+		updatev1.PhaseCleaningUp:         c.cleanUp,
+		updatev1.PhaseRollingBack:        c.rollBack,
+		updatev1.PhaseFailed:             c.awaitRetry,
+	}
+}
+
+// upgradeTimedOut returns true when the rotation runs for longer than the upgrade timeout of the SafeEvict. A rotation
+// which is already rolled back, or is being rolled back, never times out again.
+func upgradeTimedOut(safeEvict *updatev1.SafeEvict, status *updatev1.RotationStatus, phase updatev1.Phase) bool {
+	timeout := safeEvict.Spec.UpgradeTimeout
+	if timeout == nil || status.StartTime == nil || !phase.InProgress() || phase == updatev1.PhaseRollingBack {
+		return false
+	}
+	// the upgrade is done while the temporary nodepool is retained
+	if meta.IsStatusConditionTrue(status.Conditions, updatev1.ConditionFailed) || status.TemporaryNodepoolRetainedUntil != nil {
+		return false
+	}
+	return time.Since(status.StartTime.Time) > timeout.Duration
+}
+
+// abortRequested returns true when the abort annotation asks to stop the running rotation. A rotation which is already
+// rolled back, or is being rolled back, is not aborted again.
+func abortRequested(safeEvict *updatev1.SafeEvict, status *updatev1.RotationStatus, phase updatev1.Phase) bool {
+	if safeEvict.Annotations[updatev1.AbortAnnotation] != "true" || !phase.InProgress() || phase == updatev1.PhaseRollingBack {
+		return false
+	}
+	return !meta.IsStatusConditionTrue(status.Conditions, updatev1.ConditionFailed)
+}
+
+// startRotation records the start of a rotation, the upgrade timeout is measured from it
+func (r *rotation) startRotation() {
+	r.status.StartTime = &metav1.Time{Time: time.Now()}
+	r.status.StragglerNodes = 0
+	r.status.TemporaryNodepoolRetainedUntil = nil
+	r.status.TemporaryNodepoolCount = 0
+	meta.SetStatusCondition(&r.status.Conditions, metav1.Condition{
+		Type:               updatev1.ConditionFailed,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: r.safeEvict.Generation,
+		Reason:             updatev1.ReasonRotationStarted,
+		Message:            "Rotation is running",
+	})
+	if meta.FindStatusCondition(r.status.Conditions, updatev1.ConditionAborted) != nil {
+		meta.SetStatusCondition(&r.status.Conditions, metav1.Condition{
+			Type:               updatev1.ConditionAborted,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: r.safeEvict.Generation,
+			Reason:             updatev1.ReasonRotationStarted,
+			Message:            "Rotation is running",
+		})
+	}
+}
+
+// aborted returns true when the rotation is rolled back because of the abort annotation
+func (r *rotation) aborted() bool {
+	return meta.IsStatusConditionTrue(r.status.Conditions, updatev1.ConditionAborted)
+}
+
+// observeRotation collects the outdated nodes and nodepools of the target. Nodepools which are not ready count as
+// outdated, so a rotation does not move on while one of them is still updating.
+func (c *SafeEvictReconciler) observeRotation(ctx context.Context, req ctrl.Request, safeEvict *updatev1.SafeEvict, target *clusterTarget, status *updatev1.RotationStatus) (*rotation, *ctrl.Result, error) {
+	nodepoolController := target.nodepoolController
+
+	missingNodePools, err := nodepoolController.GetMissingNodePools(ctx, safeEvict.Spec.Nodepools)
+	if err != nil {
+		c.Logger.Error("Failed to check if the node pools exist", zap.Error(err))
+		return nil, &ctrl.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
+	}
+	if err := c.adoptLegacyTemporaryNodepool(ctx, safeEvict, target, status); err != nil {
+		c.Logger.Error("Failed to check the temporary nodepool of the legacy name", zap.Error(err))
+		return nil, &ctrl.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
+	}
+	c.setNodepoolsMissing(safeEvict, status, missingNodePools)
+	nodepools := slices.DeleteFunc(slices.Clone(safeEvict.Spec.Nodepools), func(nodepoolName string) bool {
+		return slices.Contains(missingNodePools, nodepoolName)
+	})
+
+	c.Logger.Debug("Checking if updates are needed for nodes and node pools...")
+	outdatedNodes, outdatedNodePools, err := nodepoolController.UpdateNeeded(ctx, nodepools)
+	if err != nil {
+		c.Logger.Error("Error determining if updates are needed for nodes and node pools", zap.Error(err))
+		return nil, &ctrl.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, nil
+	}
+
+	notReadyPools, err := nodepoolController.GetNotReadyNodePools(ctx, nodepools)
+	if err != nil {
+		c.Logger.Error("Failed to get not ready node pools", zap.Error(err))
+		return nil, &ctrl.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
+	}
+	if safeEvict.Spec.UpgradeStrategy == updatev1.UpgradeStrategyNodeReimage {
+		if err := c.dropReimagedNodePools(ctx, target, outdatedNodes, outdatedNodePools); err != nil {
+			c.Logger.Error("Failed to check the reimaged nodes of the node pools", zap.Error(err))
+			return nil, &ctrl.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
+		}
+	}
+	maps.Copy(outdatedNodePools, notReadyPools)
+	if status.Phase.InProgress() {
+		// the nodepools removed from the plan of the rotation are left alone until it is finished
+		maps.DeleteFunc(outdatedNodePools, func(nodepoolName string, _ armcontainerservice.AgentPool) bool {
+			return status.GetNodepoolState(nodepoolName) == updatev1.NodepoolStateSkipped
+		})
+	}
+	var skippedSystemNodepools []string
+	if !status.Phase.InProgress() {
+		skippedSystemNodepools, err = c.skipSystemNodePools(ctx, safeEvict, target, status, outdatedNodes, outdatedNodePools)
+		if err != nil {
+			c.Logger.Error("Failed to check the system nodes of the cluster", zap.Error(err))
+			return nil, &ctrl.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
+		}
+	}
+	stats := metrics.StatsFrom(ctx)
+	stats.PoolsChecked.Add(int64(len(nodepools)))
+	stats.OutdatedPools.Add(int64(len(outdatedNodePools)))
+
+	c.Logger.Debug("Outdated nodes and node pools identified", zap.Int("outdatedNodes", len(outdatedNodes)), zap.Int("outdatedNodePools", len(outdatedNodePools)))
+	return &rotation{
+		req:               req,
+		safeEvict:         safeEvict,
+		target:            target,
+		outdatedNodes:     outdatedNodes,
+		outdatedNodePools: outdatedNodePools,
+		nodepools:         nodepools,
+		status:            status,
+
+		skippedSystemNodepools: skippedSystemNodepools,
+	}, nil, nil
+}
+
+// adoptLegacyTemporaryNodepool keeps a rotation on the temporary nodepool which an earlier version of the controller
+// created under the legacy name, so upgrading the controller during a rotation neither orphans it nor creates a second
+// one. The nodepool is only adopted when it carries the owner tag of the SafeEvict.
+func (c *SafeEvictReconciler) adoptLegacyTemporaryNodepool(ctx context.Context, safeEvict *updatev1.SafeEvict, target *clusterTarget, status *updatev1.RotationStatus) error {
+	if status.TemporaryNodepool != "" || safeEvict.SharesBackupPool() || (!status.Phase.InProgress() && status.Phase != updatev1.PhaseFailed) {
+		return nil
+	}
+	legacyName := safeEvict.GetLegacyTemporaryNodepoolName()
+	exists, err := target.nodepoolController.NodePoolExists(ctx, legacyName)
+	if err != nil || !exists {
+		return err
+	}
+	err = target.nodepoolController.VerifyTemporaryNodePoolOwnership(ctx, legacyName, safeEvict.GetOwnerTag())
+	if errors.Is(err, nodepool.ErrNodePoolNotManaged) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.Logger.Info("Temporary nodepool was created under its legacy name, the rotation keeps using it", zap.String("temporaryNodepoolName", legacyName))
+	status.TemporaryNodepool = legacyName
+	return nil
+}
+
+// temporaryNodepoolName returns the name of the temporary nodepool of the rotation, the legacy name of an adopted
+// temporary nodepool is used until the nodepool is removed
+func (r *rotation) temporaryNodepoolName() string {
+	if r.status.TemporaryNodepool != "" {
+		return r.status.TemporaryNodepool
+	}
+	return r.safeEvict.GetTemporaryNodepoolName()
+}
+
+// skipSystemNodePools removes the outdated system nodepools which are not rotated from the outdated nodes and
+// nodepools and reports them in the SystemNodepoolsSkipped condition, the condition is only added to the status once a
+// system nodepool was skipped. A system nodepool is skipped by the spec, or while no schedulable system node remains
+// outside of the outdated system nodepools to run the pods of kube-system during their drain.
+func (c *SafeEvictReconciler) skipSystemNodePools(ctx context.Context, safeEvict *updatev1.SafeEvict, target *clusterTarget, status *updatev1.RotationStatus, outdatedNodes map[string]corev1.Node, outdatedNodePools map[string]armcontainerservice.AgentPool) ([]string, error) {
+	var systemNodepools []string
+	for nodepoolName, agentPool := range outdatedNodePools {
+		if agentPool.Properties != nil && agentPool.Properties.Mode != nil && *agentPool.Properties.Mode == armcontainerservice.AgentPoolModeSystem {
+			systemNodepools = append(systemNodepools, nodepoolName)
+		}
+	}
+	slices.Sort(systemNodepools)
+
+	condition := metav1.Condition{
+		Type:               updatev1.ConditionSystemNodepoolsSkipped,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: safeEvict.Generation,
+		Reason:             updatev1.ReasonSystemNodepoolsRotated,
+		Message:            "No system nodepool is left out of the rotation",
+	}
+	if len(systemNodepools) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = updatev1.ReasonSkippedBySpec
+		condition.Message = fmt.Sprintf("System nodepools %s are skipped by the spec", strings.Join(systemNodepools, ", "))
+		if safeEvict.Spec.SystemNodepools != updatev1.SystemNodepoolPolicySkip {
+			schedulable, err := target.nodepoolController.HasSchedulableSystemNodes(ctx, systemNodepools)
+			if err != nil {
+				return nil, err
+			}
+			if schedulable {
+				systemNodepools = nil
+				condition.Status = metav1.ConditionFalse
+				condition.Reason = updatev1.ReasonSystemNodepoolsRotated
+				condition.Message = "No system nodepool is left out of the rotation"
+			} else {
+				condition.Reason = updatev1.ReasonNoOtherSystemNode
+				condition.Message = fmt.Sprintf("System nodepools %s are skipped, no schedulable system node remains outside of them", strings.Join(systemNodepools, ", "))
+			}
+		}
+	}
+	if len(systemNodepools) == 0 && meta.FindStatusCondition(status.Conditions, updatev1.ConditionSystemNodepoolsSkipped) == nil {
+		return nil, nil
+	}
+	if len(systemNodepools) > 0 {
+		c.Logger.Warn("System nodepools are left out of the rotation", zap.Strings("nodepools", systemNodepools), zap.String("reason", condition.Reason))
+	}
+	for _, nodepoolName := range systemNodepools {
+		delete(outdatedNodePools, nodepoolName)
+		maps.DeleteFunc(outdatedNodes, func(_ string, node corev1.Node) bool {
+			return node.Labels[nodepool.AgentPoolLabel] == nodepoolName
+		})
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	return systemNodepools, nil
+}
+
+// setNodepoolsMissing reports the nodepools of the spec which do not exist in the cluster in the NodepoolsMissing
+// condition, the condition is only added to the status once a nodepool was missing. With pruneMissingNodepools their
+// status is dropped from the pools.
+func (c *SafeEvictReconciler) setNodepoolsMissing(safeEvict *updatev1.SafeEvict, status *updatev1.RotationStatus, missingNodePools []string) {
+	if safeEvict.Spec.PruneMissingNodepools {
+		for _, nodepoolName := range missingNodePools {
+			if status.RemoveNodepool(nodepoolName) {
+				c.Logger.Info("Pruned the status of a nodepool which no longer exists", zap.String("nodepoolName", nodepoolName))
+			}
+		}
+	}
+	if len(missingNodePools) == 0 && meta.FindStatusCondition(status.Conditions, updatev1.ConditionNodepoolsMissing) == nil {
+		return
+	}
+	condition := metav1.Condition{
+		Type:               updatev1.ConditionNodepoolsMissing,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: safeEvict.Generation,
+		Reason:             updatev1.ReasonNodepoolsFound,
+		Message:            "Every nodepool exists in the cluster",
+	}
+	if len(missingNodePools) > 0 {
+		c.Logger.Warn("Nodepools do not exist in the cluster, they are left out of the rotation", zap.Strings("nodepools", missingNodePools))
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = updatev1.ReasonNodepoolsNotFound
+		condition.Message = fmt.Sprintf("Nodepools %s do not exist in the cluster", strings.Join(missingNodePools, ", "))
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
+
+// dropReimagedNodePools removes the nodepools whose nodes all run the latest node image from the outdated ones. ARM
+// keeps reporting the node image version of the last upgrade of a nodepool whose nodes were reimaged one by one.
+func (c *SafeEvictReconciler) dropReimagedNodePools(ctx context.Context, target *clusterTarget, outdatedNodes map[string]corev1.Node, outdatedNodePools map[string]armcontainerservice.AgentPool) error {
+	for nodepoolName := range outdatedNodePools {
+		latestNodeImageVersion, err := target.nodepoolController.GetLatestNodeImageVersion(ctx, nodepoolName)
+		if err != nil {
+			return err
+		}
+		reimaged := false
+		for _, node := range outdatedNodes {
+			if node.Labels[nodepool.AgentPoolLabel] != nodepoolName {
+				continue
+			}
+			_, reimaging := node.Annotations[nodepool.ReimageStartedAnnotation]
+			reimaged = !reimaging && node.Labels[nodepool.NodeImageVersionLabel] == latestNodeImageVersion
+			if !reimaged {
+				break
+			}
+		}
+		if !reimaged {
+			continue
+		}
+		c.Logger.Debug("Every node of the nodepool is reimaged with the latest node image", zap.String("nodepoolName", nodepoolName))
+		delete(outdatedNodePools, nodepoolName)
+		maps.DeleteFunc(outdatedNodes, func(_ string, node corev1.Node) bool {
+			return node.Labels[nodepool.AgentPoolLabel] == nodepoolName
+		})
+	}
+	return nil
+}
+
+// detect starts a rotation when a nodepool is outdated, or resumes it when the temporary nodepool of an interrupted
+// rotation is left behind. An up to date cluster stays in this phase until the next upgrade check.
+func (c *SafeEvictReconciler) detect(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	temporaryNodepoolName := r.temporaryNodepoolName()
+	c.Logger.Debug("Checking if temporary nodepool exists", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+	temporaryNodepoolExists, err := r.target.nodepoolController.NodePoolExists(ctx, temporaryNodepoolName)
+	if err != nil {
+		c.Logger.Error("Failed to check if temporary nodepool exists", zap.Error(err))
+		return c.failIn(updatev1.PhaseDetecting, err)
+	}
+	if r.safeEvict.Annotations[updatev1.AbortAnnotation] == "true" {
+		c.Logger.Info("Abort is requested with annotation, no rotation is started until the next upgrade check", zap.String("annotation", updatev1.AbortAnnotation))
+		return updatev1.PhaseDetecting, &ctrl.Result{RequeueAfter: c.Config.Load().UpgradeFrequency}, nil
+	}
+	// a shared temporary nodepool is left behind by an interrupted rotation only when this SafeEvict holds a reference
+	if temporaryNodepoolExists && r.safeEvict.SharesBackupPool() {
+		err = r.target.nodepoolController.VerifyTemporaryNodePoolOwnership(ctx, temporaryNodepoolName, r.safeEvict.GetOwnerTag())
+		if err != nil && !errors.Is(err, nodepool.ErrNodePoolNotManaged) {
+			return c.failIn(updatev1.PhaseDetecting, err)
+		}
+		temporaryNodepoolExists = err == nil
+	}
+	if temporaryNodepoolExists {
+		c.Logger.Info("Temporary nodepool of an interrupted rotation found, resuming the rotation", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		r.startRotation()
+		return updatev1.PhaseProvisioningBackup, nil, nil
+	}
+
+	c.discoverAgentPools(ctx, r)
+
+	var history utilization.History
+	if r.safeEvict.Spec.AutoSchedule {
+		history = c.sampleUsage(ctx, r)
+	}
+
+	if len(r.outdatedNodes) == 0 && len(r.outdatedNodePools) == 0 {
+		c.Logger.Debug("No outdated nodes or node pools found, deleting ConfigMap and requeuing...")
+		r.upToDate = true
+		restored, err := c.restoreKeptScaling(ctx, r)
+		if err != nil {
+			c.Logger.Error("Failed to restore the saved scaling kept by the rolled back rotation", zap.Error(err))
+			return c.failIn(updatev1.PhaseDetecting, err)
+		}
+		if !restored {
+			return c.waitIn(updatev1.PhaseDetecting)
+		}
+		err = c.ConfigmapController.DeleteConfigMap(ctx, r.target.configmapNamespace, r.target.configmapName)
+		if err != nil {
+			c.Logger.Error("Failed to delete ConfigMap", zap.Error(err))
+			return c.failIn(updatev1.PhaseDetecting, err)
+		}
+		// the periodic checks of an up to date cluster are only logged at debug level
+		logUpToDate := c.Logger.Info
+		if r.status.NextCheckTime != nil {
+			logUpToDate = c.Logger.Debug
+		}
+		logUpToDate("Cluster is up to date, requeuing for the next reconciliation loop", zap.Duration("requeueAfter", c.Config.Load().UpgradeFrequency))
+		return updatev1.PhaseDetecting, &ctrl.Result{RequeueAfter: c.Config.Load().UpgradeFrequency}, nil
+	}
+
+	if r.safeEvict.Spec.AutoSchedule && r.safeEvict.Annotations[updatev1.CheckNowAnnotation] == "" && history.Complete() {
+		now := time.Now()
+		if quietTime := history.NextQuietTime(now, quietHourCount); quietTime.After(now) {
+			c.Logger.Info("Outdated nodes or node pools are found, waiting for a quiet hour to start the rotation", zap.Time("quietTime", quietTime))
+			return updatev1.PhaseDetecting, &ctrl.Result{RequeueAfter: min(quietTime.Sub(now), c.Config.Load().UpgradeFrequency)}, nil
+		}
+	}
+
+	planned, result, err := c.reviewPlan(ctx, r)
+	if result != nil {
+		return updatev1.PhaseDetecting, result, err
+	}
+
+	c.Logger.Info("Outdated nodes or node pools are found, starting the rotation")
+	r.startRotation()
+	r.status.ResetPools()
+	// the drain admits the queued nodepools as long as fewer than MaxConcurrentPools are rotated
+	initialState := updatev1.NodepoolStateInProgress
+	if r.safeEvict.Spec.MaxConcurrentPools != nil {
+		initialState = updatev1.NodepoolStateQueued
+	}
+	for _, nodepoolName := range slices.Sorted(maps.Keys(r.outdatedNodePools)) {
+		if planned != nil && !slices.Contains(planned, nodepoolName) {
+			r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateSkipped, "Removed from the plan of the rotation")
+			delete(r.outdatedNodePools, nodepoolName)
+			continue
+		}
+		r.status.SetNodepoolState(nodepoolName, initialState, "")
+	}
+	// the skipped system nodepools stay outdated, they are left alone until the rotation is finished
+	for _, nodepoolName := range r.skippedSystemNodepools {
+		r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateSkipped, "System nodepool is left out of the rotation")
+	}
+	return updatev1.PhaseProvisioningBackup, nil, nil
+}
+
+// reviewPlan asks the plan reviewer whether the rotation of the outdated nodepools can start. It returns the nodepools
+// kept in the plan, nil when every outdated nodepool is rotated. A vetoed plan keeps the cluster in detection until it
+// is reviewed again.
+func (c *SafeEvictReconciler) reviewPlan(ctx context.Context, r *rotation) ([]string, *ctrl.Result, error) {
+	if c.PlanReviewer == nil {
+		return nil, nil, nil
+	}
+	proposed := plan.Plan{
+		Namespace:         r.safeEvict.Namespace,
+		Name:              r.safeEvict.Name,
+		Cluster:           r.target.clusterName,
+		BaseNodepool:      r.safeEvict.Spec.BaseForBackupPool,
+		TemporaryNodepool: r.temporaryNodepoolName(),
+	}
+	for _, nodepoolName := range slices.Sorted(maps.Keys(r.outdatedNodePools)) {
+		nodepool := plan.Nodepool{Name: nodepoolName}
+		if properties := r.outdatedNodePools[nodepoolName].Properties; properties != nil && properties.NodeImageVersion != nil {
+			nodepool.NodeImageVersion = *properties.NodeImageVersion
+		}
+		proposed.Nodepools = append(proposed.Nodepools, nodepool)
+	}
+
+	decision, err := c.PlanReviewer.Review(ctx, proposed)
+	if err != nil {
+		c.Logger.Error("Failed to review the plan of the rotation", zap.Error(err))
+		return nil, &ctrl.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, fmt.Errorf("failed to review the plan of the rotation: %w", err)
+	}
+	if !decision.Allowed {
+		retryAfter := decision.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = c.Config.Load().UpgradeFrequency
+		}
+		c.Logger.Info("Plan of the rotation is vetoed", zap.String("reason", decision.Reason), zap.Strings("nodepools", proposed.NodepoolNames()), zap.Duration("retryAfter", retryAfter))
+		meta.SetStatusCondition(&r.status.Conditions, metav1.Condition{
+			Type:               updatev1.ConditionPlanVetoed,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: r.safeEvict.Generation,
+			Reason:             updatev1.ReasonVetoed,
+			Message:            decision.Reason,
+		})
+		return nil, &ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+	if meta.FindStatusCondition(r.status.Conditions, updatev1.ConditionPlanVetoed) != nil {
+		meta.SetStatusCondition(&r.status.Conditions, metav1.Condition{
+			Type:               updatev1.ConditionPlanVetoed,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: r.safeEvict.Generation,
+			Reason:             updatev1.ReasonPlanAllowed,
+			Message:            decision.Reason,
+		})
+	}
+	return decision.Nodepools, nil, nil
+}
+
+// discoverAgentPools records the Azure DevOps pools of the agent pods per nodepool in the status. A failing discovery
+// is only logged, the mapping of the previous check is kept.
+func (c *SafeEvictReconciler) discoverAgentPools(ctx context.Context, r *rotation) {
+	discovered, err := r.target.podController.DiscoverAgentPools(ctx, r.safeEvict.Spec.Namespaces)
+	if err != nil {
+		c.Logger.Warn("Failed to discover the Azure DevOps pools of the agent pods, keeping the previous ones", zap.Error(err))
+		return
+	}
+	agentPools := make([]updatev1.AgentPoolMapping, 0, len(discovered))
+	for _, nodepoolName := range slices.Sorted(maps.Keys(discovered)) {
+		agentPools = append(agentPools, updatev1.AgentPoolMapping{Nodepool: nodepoolName, AzureDevOpsPools: discovered[nodepoolName]})
+	}
+	unchanged := slices.EqualFunc(agentPools, r.status.AgentPools, func(a, b updatev1.AgentPoolMapping) bool {
+		return a.Nodepool == b.Nodepool && slices.Equal(a.AzureDevOpsPools, b.AzureDevOpsPools)
+	})
+	if !unchanged {
+		c.Logger.Info("Discovered the Azure DevOps pools of the agent pods", zap.Any("agentPools", discovered))
+	}
+	r.status.AgentPools = agentPools
+}
+
+// sampleUsage records the busy agents into the usage history of the cluster, at most once per usageSampleInterval, and
+// returns the history. The auto schedule starts rotations right away until every hour of the day has been sampled.
+// A failing sample is only logged, it does not hold back the rotation.
+func (c *SafeEvictReconciler) sampleUsage(ctx context.Context, r *rotation) utilization.History {
+	configmapName := r.target.usageConfigmapName
+	data, err := c.ConfigmapController.GetConfigMapData(ctx, r.target.configmapNamespace, configmapName)
+	missing := apierrors.IsNotFound(err)
+	if err != nil && !missing {
+		c.Logger.Error("Failed to get the usage history", zap.Error(err), zap.String("configMapName", configmapName))
+		return utilization.History{}
+	}
+	history := utilization.ParseHistory(data)
+	now := time.Now()
+	if now.Sub(history.LastSample) < usageSampleInterval {
+		return history
+	}
+
+	busyAgents, err := r.target.podController.CountBusyAgents(ctx, r.safeEvict.Spec)
+	if errors.Is(err, pod.ErrAgentProviderDisabled) {
+		c.Logger.Debug("Agent provider is disabled, the usage history is not sampled")
+		return history
+	}
+	if err != nil {
+		c.Logger.Error("Failed to count the busy agents for the usage history", zap.Error(err))
+		return history
+	}
+	history.Record(now, busyAgents)
+	metrics.RecordBusyAgents(r.safeEvict.Namespace, r.safeEvict.Name, r.target.clusterName, busyAgents)
+	c.Logger.Debug("Sampled the usage history", zap.String("configMapName", configmapName), zap.Int("busyAgents", busyAgents))
+
+	if missing {
+		err = c.ConfigmapController.CreateConfigMap(ctx, r.target.configmapNamespace, configmapName, history.Data(), r.safeEvict)
+	} else {
+		err = c.ConfigmapController.UpdateConfigMap(ctx, r.target.configmapNamespace, configmapName, history.Data())
+	}
+	if err != nil {
+		c.Logger.Error("Failed to save the usage history", zap.Error(err), zap.String("configMapName", configmapName))
+	}
+	return history
+}
+
+// provisionBackup creates the temporary nodepool which takes the workload of the outdated nodepools, and saves their
+// scaling before it is changed by the rotation
+func (c *SafeEvictReconciler) provisionBackup(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	nodepoolController := r.target.nodepoolController
+	temporaryNodepoolName := r.temporaryNodepoolName()
+
+	temporaryNodepoolExists, err := nodepoolController.NodePoolExists(ctx, temporaryNodepoolName)
+	if err != nil {
+		c.Logger.Error("Failed to check if temporary nodepool exists", zap.Error(err))
+		return c.failIn(updatev1.PhaseProvisioningBackup, err)
+	}
+	if temporaryNodepoolExists && r.safeEvict.SharesBackupPool() {
+		acquired, err := nodepoolController.AcquireSharedNodePool(ctx, temporaryNodepoolName, r.safeEvict.GetOwnerTag())
+		if nodepool.IsRetryable(err) {
+			return c.backOffIn(updatev1.PhaseProvisioningBackup, c.conflictBackoff(r, temporaryNodepoolName, err))
+		}
+		if err != nil {
+			c.Logger.Error("Temporary nodepool exists but it is not shared by node-updater", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+			return c.failIn(updatev1.PhaseProvisioningBackup, err)
+		}
+		if !acquired {
+			c.Logger.Info("Shared temporary nodepool is cleaned up by the other SafeEvicts, waiting for its removal", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+			return c.waitIn(updatev1.PhaseProvisioningBackup)
+		}
+	} else if temporaryNodepoolExists {
+		err = nodepoolController.VerifyTemporaryNodePoolOwnership(ctx, temporaryNodepoolName, r.safeEvict.GetOwnerTag())
+		if err != nil {
+			c.Logger.Error("Temporary nodepool exists but it is not managed by this SafeEvict", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+			return c.failIn(updatev1.PhaseProvisioningBackup, err)
+		}
+	} else {
+		c.Logger.Info("Temporary nodepool does not exist, creating temporary nodepool...")
+		count, err := c.rightSizeTemporaryNodepool(ctx, r)
+		if err != nil {
+			return c.failIn(updatev1.PhaseProvisioningBackup, err)
+		}
+		err = nodepoolController.CreateTemporaryNodePool(ctx, temporaryNodepoolName, r.safeEvict.Spec.BaseForBackupPool, nodepool.TemporaryNodePoolOverrides{
+			VMSize:     r.safeEvict.GetBackupPoolVMSize(),
+			NodeLabels: r.safeEvict.GetBackupPoolNodeLabels(),
+			NodeTaints: r.safeEvict.GetBackupPoolNodeTaints(),
+			Count:      count,
+			Shared:     r.safeEvict.SharesBackupPool(),
+		}, r.safeEvict.GetOwnerTag())
+		if errors.Is(err, nodepool.ErrArchitectureMismatch) {
+			// retrying does not help until the VM size is changed in the spec
+			return c.failIn(updatev1.PhaseProvisioningBackup, err)
+		}
+		if err != nil {
+			c.Logger.Error("Failed to create temporary nodepool", zap.Error(err))
+			return c.retryIn(updatev1.PhaseProvisioningBackup)
+		}
+	}
+
+	status, err := nodepoolController.GetNodePoolProvisioningState(ctx, temporaryNodepoolName)
+	if err != nil {
+		return c.failIn(updatev1.PhaseProvisioningBackup, err)
+	}
+	switch status {
+	case nodepool.ProvisioningStateCreating:
+		c.Logger.Info("Temporary node pool is being created, requeuing...")
+		return c.waitForStates(updatev1.PhaseProvisioningBackup, status)
+	case nodepool.ProvisioningStateDeleting:
+		c.Logger.Info("Temporary node pool is being removed, finishing the cleanup of the previous rotation")
+		return updatev1.PhaseCleaningUp, nil, nil
+	case nodepool.ProvisioningStateFailed, nodepool.ProvisioningStateCanceled:
+		// the temporary nodepool stays in the state until it is fixed in AKS, the rotation must not rely on it
+		return c.failIn(updatev1.PhaseProvisioningBackup, fmt.Errorf("%w: temporary node pool '%s' is in provisioning state '%s'", nodepool.ErrProvisioningFailed, temporaryNodepoolName, status))
+	}
+
+	c.recordTemporaryNodepool(ctx, r)
+
+	// keep the cluster-autoscaler away from the temporary capacity while the rotation is running
+	err = nodepoolController.SetScaleDownDisabledByAgentPool(ctx, temporaryNodepoolName, true)
+	if err != nil {
+		c.Logger.Error("Failed to disable autoscaler scale down for the temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return c.failIn(updatev1.PhaseProvisioningBackup, err)
+	}
+
+	if err := c.saveScaling(ctx, r); err != nil {
+		return c.failIn(updatev1.PhaseProvisioningBackup, err)
+	}
+	return updatev1.PhaseDraining, nil, nil
+}
+
+// rightSizeTemporaryNodepool returns the node count which fits the resource requests of the agent pods on the outdated
+// nodepools with the headroom of the right-sizing, nil when the count of the base pool is cloned. The allocatable
+// resources of a node are read from a node which runs the VM size of the temporary nodepool, without such a node the
+// count of the base pool is cloned as well.
+func (c *SafeEvictReconciler) rightSizeTemporaryNodepool(ctx context.Context, r *rotation) (*int32, error) {
+	rightSizing := r.safeEvict.GetRightSizing()
+	if rightSizing == nil {
+		return nil, nil
+	}
+	nodepoolController := r.target.nodepoolController
+	vmSize := r.safeEvict.GetBackupPoolVMSize()
+	if vmSize == "" {
+		basePool, err := nodepoolController.GetNodePoolByName(ctx, r.safeEvict.Spec.BaseForBackupPool)
+		if err != nil {
+			return nil, err
+		}
+		if basePool.Properties != nil && basePool.Properties.VMSize != nil {
+			vmSize = *basePool.Properties.VMSize
+		}
+	}
+	allocatable, err := nodepoolController.GetNodeAllocatable(ctx, vmSize)
+	if err != nil {
+		c.Logger.Error("Failed to get the allocatable resources of the VM size", zap.Error(err), zap.String("vmSize", vmSize))
+		return nil, err
+	}
+	if allocatable == nil {
+		c.Logger.Info("No node runs the VM size of the temporary nodepool, cloning the node count of the base pool", zap.String("vmSize", vmSize))
+		return nil, nil
+	}
+
+	var nodes []corev1.Node
+	for _, nodepoolName := range slices.Sorted(maps.Keys(r.outdatedNodePools)) {
+		nodepoolNodes, err := nodepoolController.GetNodesByNodePool(ctx, nodepoolName)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, nodepoolNodes...)
+	}
+	agentPods, err := nodepoolController.GetPodsOnNodes(ctx, nodes, r.safeEvict.Spec.Namespaces)
+	if err != nil {
+		return nil, err
+	}
+	count := nodepool.RequiredNodeCount(agentPods, allocatable, rightSizing.GetHeadroomPercent())
+	if rightSizing.MaxCount != nil {
+		count = min(count, *rightSizing.MaxCount)
+	}
+	c.Logger.Info("Right-sized the temporary nodepool", zap.Int32("count", count), zap.Int("agentPods", len(agentPods)), zap.String("vmSize", vmSize))
+	return &count, nil
+}
+
+// recordTemporaryNodepool exposes the creation time of the temporary nodepool for the NodeUpdaterTemporaryNodepoolLeftBehind
+// alert, a nodepool without a valid created-at tag is not reported
+func (c *SafeEvictReconciler) recordTemporaryNodepool(ctx context.Context, r *rotation) {
+	createdAt, err := r.target.nodepoolController.GetNodePoolCreationTime(ctx, r.temporaryNodepoolName())
+	if err != nil {
+		c.Logger.Warn("Failed to get the creation time of the temporary nodepool", zap.Error(err))
+		return
+	}
+	metrics.RecordTemporaryNodepool(r.req.Namespace, r.req.Name, r.target.clusterName, createdAt)
+}
+
+// saveScaling stores the scaling and the node taints of the outdated nodepools in the ConfigMap of the target. The saved scaling of a
+// nodepool is never overwritten, because the nodepool already runs with the scaling of the rotation by then, only the
+// nodepools which became outdated during the rotation are added.
+func (c *SafeEvictReconciler) saveScaling(ctx context.Context, r *rotation) error {
+	configData := make(map[string]string)
+	for poolName, pool := range r.outdatedNodePools {
+		configData[poolName] = nodepool.SavedScalingOf(pool).JSON()
+
+		nodeTaints, err := r.target.nodepoolController.GetNodeTaintsByAgentPool(ctx, poolName)
+		if err != nil {
+			c.Logger.Error("Failed to get node taints of the nodepool", zap.Error(err), zap.String("nodepoolName", poolName))
+			return err
+		}
+		taintsData, err := json.Marshal(nodeTaints)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node taints of nodepool '%s': %w", poolName, err)
+		}
+		configData[nodeTaintsKey(poolName)] = string(taintsData)
+	}
+	configData[scalingChecksumKey] = scalingChecksum(configData)
+	c.Logger.Debug("Saving outdated node pool scaling information", zap.String("configMapName", r.target.configmapName), zap.Any("data", configData))
+	err := c.ConfigmapController.CreateConfigMap(ctx, r.target.configmapNamespace, r.target.configmapName, configData, r.safeEvict)
+	if err != nil {
+		c.Logger.Error("Failed to create ConfigMap with outdated node pool scaling information", zap.Error(err))
+		return err
+	}
+
+	// the ConfigMap existed when the rotation saved its scaling before, the nodepools which became outdated since then
+	// are added and the checksum covers them too
+	saved, err := c.ConfigmapController.GetConfigMapData(ctx, r.target.configmapNamespace, r.target.configmapName)
+	if err != nil {
+		c.Logger.Error("Failed to get the saved node pool scaling information", zap.Error(err))
+		return err
+	}
+	if err := c.verifyScaling(r, saved); err != nil {
+		return err
+	}
+	sealed := maps.Clone(saved)
+	if sealed == nil {
+		sealed = make(map[string]string, len(configData))
+	}
+	for key, value := range configData {
+		if _, found := sealed[key]; !found {
+			sealed[key] = value
+		}
+	}
+	sealed[scalingChecksumKey] = scalingChecksum(sealed)
+	err = c.ConfigmapController.UpdateConfigMap(ctx, r.target.configmapNamespace, r.target.configmapName, sealed)
+	if err != nil {
+		c.Logger.Error("Failed to add outdated node pool scaling information to ConfigMap", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// scalingChecksum returns the SHA-256 of the saved scaling and node taints in key order, the checksum itself is left out
+func scalingChecksum(data map[string]string) string {
+	hash := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		if key != scalingChecksumKey {
+			fmt.Fprintf(hash, "%q=%q\n", key, data[key])
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// verifyScaling refuses the saved scaling when it does not match its checksum, e.g. because the ConfigMap was edited by
+// hand, or when the scaling of a nodepool is invalid, and reports it in the ScalingStateInvalid condition. The scaling
+// saved by an earlier version of the controller has no checksum, so has a scaling whose checksum was removed to accept
+// an edit; only the scaling of its nodepools is validated.
+func (c *SafeEvictReconciler) verifyScaling(r *rotation, data map[string]string) error {
+	var err error
+	if checksum, found := data[scalingChecksumKey]; found && checksum != scalingChecksum(data) {
+		err = fmt.Errorf("saved scaling in ConfigMap '%s' does not match its checksum, it was modified outside of the controller; "+
+			"fix the data or remove the %s key to use it anyway", r.target.configmapName, scalingChecksumKey)
+	}
+	for _, nodepoolName := range slices.Sorted(maps.Keys(data)) {
+		if err != nil {
+			break
+		}
+		if !isScalingKey(nodepoolName) {
+			continue
+		}
+		if _, parseErr := nodepool.ParseScaling(data[nodepoolName]); parseErr != nil {
+			err = fmt.Errorf("saved scaling of nodepool '%s' in ConfigMap '%s' cannot be restored: %w", nodepoolName, r.target.configmapName, parseErr)
+		}
+	}
+	if err != nil {
+		c.Logger.Error("Saved scaling is refused", zap.Error(err), zap.String("configMapName", r.target.configmapName))
+	}
+	c.setScalingStateInvalid(r, err)
+	return err
+}
+
+// setScalingStateInvalid reports a refused saved scaling in the ScalingStateInvalid condition, the condition is only
+// added to the status once a saved scaling was refused
+func (c *SafeEvictReconciler) setScalingStateInvalid(r *rotation, err error) {
+	if err == nil && meta.FindStatusCondition(r.status.Conditions, updatev1.ConditionScalingStateInvalid) == nil {
+		return
+	}
+	condition := metav1.Condition{
+		Type:               updatev1.ConditionScalingStateInvalid,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: r.safeEvict.Generation,
+		Reason:             updatev1.ReasonChecksumVerified,
+		Message:            "Saved scaling matches its checksum",
+	}
+	switch {
+	case nodepool.IsInvalidScaling(err):
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = updatev1.ReasonInvalidScaling
+		condition.Message = err.Error()
+	case err != nil:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = updatev1.ReasonChecksumMismatch
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(&r.status.Conditions, condition)
+}
+
+// nodeTaintsKey returns the ConfigMap key of the saved node taints of a nodepool, nodepool names cannot contain dots
+// so it never collides with the saved scaling of a nodepool
+func nodeTaintsKey(nodepoolName string) string {
+	return nodepoolName + nodeTaintsKeySuffix
+}
+
+func isNodeTaintsKey(key string) bool {
+	return strings.HasSuffix(key, nodeTaintsKeySuffix)
+}
+
+// isScalingKey returns true when the ConfigMap key holds the saved scaling of a nodepool
+func isScalingKey(key string) bool {
+	return !isNodeTaintsKey(key) && key != scalingChecksumKey
+}
+
+// restoreNodeTaints sets the taints of the nodes of the nodepool back to the ones saved before the rotation
+func (c *SafeEvictReconciler) restoreNodeTaints(ctx context.Context, r *rotation, nodepoolName string, configMapData map[string]string) error {
+	taintsData, saved := configMapData[nodeTaintsKey(nodepoolName)]
+	if !saved {
+		return nil
+	}
+	nodeTaints := make(map[string][]corev1.Taint)
+	if err := json.Unmarshal([]byte(taintsData), &nodeTaints); err != nil {
+		c.Logger.Error("Failed to parse saved node taints", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return fmt.Errorf("failed to parse saved node taints of nodepool '%s': %w", nodepoolName, err)
+	}
+	if err := r.target.nodepoolController.RestoreNodeTaintsByAgentPool(ctx, nodepoolName, nodeTaints); err != nil {
+		c.Logger.Error("Failed to restore node taints of the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return err
+	}
+	return nil
+}
+
+// drain evicts the idle pods from the outdated nodepools and starts the node image upgrade of every nodepool without
+// running pods. Every nodepool is acted on in every reconcile, a failing one does not hold back the others, and with
+// MaxConcurrentPools the queued ones are started as the rotated ones are upgraded. It moves on once every outdated
+// nodepool is upgrading.
+// When the SafeEvict requires approval, the drained nodepools are not upgraded until the rotation is approved.
+func (c *SafeEvictReconciler) drain(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	// a nodepool may become outdated while the rotation is already draining, its scaling is saved before it is changed
+	if err := c.saveScaling(ctx, r); err != nil {
+		return c.failIn(updatev1.PhaseDraining, err)
+	}
+	if err := c.watchPendingPods(ctx, r); err != nil {
+		return c.failIn(updatev1.PhaseDraining, err)
+	}
+
+	approved := r.safeEvict.ApprovedRotation(r.status.StartTime)
+	admitted := c.admitNodepools(r)
+	pending := false
+	var backoff time.Duration
+	var awaitingApproval, blocked, unplaceable []string
+	var errs []error
+	for _, nodepoolName := range slices.Sorted(maps.Keys(r.outdatedNodePools)) {
+		agentPool := r.outdatedNodePools[nodepoolName]
+		provisioningState := nodepool.GetProvisioningState(agentPool)
+		if provisioningState == nodepool.ProvisioningStateUpgradingNodeImageVersion {
+			c.Logger.Debug("Node pool is already running a node image upgrade", zap.String("nodepoolName", nodepoolName))
+			continue
+		}
+		pending = true
+		if !admitted[nodepoolName] {
+			continue
+		}
+		if provisioningState.Failed() {
+			// the nodepool stays in the state until it is fixed in AKS, draining it again would not upgrade it
+			errs = append(errs, r.nodepoolFailed(nodepoolName, fmt.Errorf("%w: node pool '%s' is in provisioning state '%s'", nodepool.ErrProvisioningFailed, nodepoolName, provisioningState)))
+			continue
+		}
+
+		// a nodepool scaled to zero has no instance to reimage, it is upgraded as a whole
+		if r.safeEvict.Spec.UpgradeStrategy == updatev1.UpgradeStrategyNodeReimage && nodeCount(agentPool) > 0 {
+			node, err := c.drainNextNode(ctx, r, agentPool)
+			if nodepool.IsRetryable(err) {
+				backoff = max(backoff, c.conflictBackoff(r, nodepoolName, err))
+				continue
+			}
+			if errors.Is(err, errPlacementImpossible) {
+				unplaceable = append(unplaceable, err.Error())
+				r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateInProgress, err.Error())
+				continue
+			}
+			if err != nil {
+				c.Logger.Error("Failed to roll nodepool node by node", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+				errs = append(errs, r.nodepoolFailed(nodepoolName, err))
+				continue
+			}
+			r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateInProgress, "")
+			if node == nil {
+				continue
+			}
+			if !approved {
+				awaitingApproval = append(awaitingApproval, nodepoolName)
+				r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateInProgress, fmt.Sprintf("Node '%s' drained, waiting for approval", node.Name))
+				continue
+			}
+			if err := r.target.nodepoolController.ReimageNode(ctx, *node); err != nil {
+				errs = append(errs, r.nodepoolFailed(nodepoolName, err))
+			}
+			continue
+		}
+
+		drained, err := c.drainNodePool(ctx, r, agentPool)
+		if nodepool.IsRetryable(err) {
+			backoff = max(backoff, c.conflictBackoff(r, nodepoolName, err))
+			continue
+		}
+		if errors.Is(err, errPlacementImpossible) {
+			// the workload would be Pending on the temporary nodepool, the nodepool waits uncordoned until it fits
+			unplaceable = append(unplaceable, err.Error())
+			r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateInProgress, err.Error())
+			continue
+		}
+		if err != nil {
+			c.Logger.Error("Failed to drain nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+			errs = append(errs, r.nodepoolFailed(nodepoolName, err))
+			continue
+		}
+		r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateInProgress, "")
+		if !drained {
+			continue
+		}
+		if !approved {
+			awaitingApproval = append(awaitingApproval, nodepoolName)
+			r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateInProgress, "Drained, waiting for approval")
+			continue
+		}
+
+		c.Logger.Debug("Starting to upgrade node image version", zap.String("nodepoolName", nodepoolName))
+		err = r.target.nodepoolController.UpgradeNodeImageVersion(ctx, &agentPool)
+		if err != nil {
+			c.Logger.Error("Failed to upgrade node image version", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+			if errors.Is(err, nodepool.ErrUpgradeNotPermitted) {
+				blocked = append(blocked, err.Error())
+				r.blockedNodepools = append(r.blockedNodepools, nodepoolName)
+			}
+			errs = append(errs, r.nodepoolFailed(nodepoolName, err))
+		}
+	}
+
+	c.setAwaitingApproval(r, awaitingApproval)
+	c.setUpgradeBlocked(r, blocked)
+	c.setPlacementImpossible(r, unplaceable)
+
+	if len(errs) > 0 {
+		return c.failIn(updatev1.PhaseDraining, errors.Join(errs...))
+	}
+	if backoff > 0 {
+		return c.backOffIn(updatev1.PhaseDraining, backoff)
+	}
+	if pending {
+		return c.waitIn(updatev1.PhaseDraining)
+	}
+	return updatev1.PhaseUpgrading, nil, nil
+}
+
+// watchPendingPods reacts to agent pods which the scheduler could not place for longer than the pending timeout of the
+// watchdog, e.g. because the temporary nodepool is too small for the evicted agents. Depending on its action, the
+// temporary nodepool is scaled up or the evictions are paused; they are paused as well once the temporary nodepool
+// reached its maximum count. The PodsPending condition reports the stuck pods.
+func (c *SafeEvictReconciler) watchPendingPods(ctx context.Context, r *rotation) error {
+	watchdog := r.safeEvict.Spec.PendingPodWatchdog
+	if watchdog == nil {
+		return nil
+	}
+	pendingTimeout := r.safeEvict.GetPendingTimeout()
+	pendingPods, err := r.target.podController.GetUnschedulablePods(ctx, r.safeEvict.Spec.Namespaces, pendingTimeout)
+	if err != nil {
+		c.Logger.Error("Failed to get the unschedulable agent pods", zap.Error(err))
+		return err
+	}
+	if len(pendingPods) == 0 {
+		c.setPodsPending(r, metav1.ConditionFalse, updatev1.ReasonPodsScheduled, "No agent pod is stuck Pending")
+		return nil
+	}
+	podNames := make([]string, 0, len(pendingPods))
+	for _, pendingPod := range pendingPods {
+		podNames = append(podNames, pendingPod.Namespace+"/"+pendingPod.Name)
+	}
+	c.Logger.Info("Agent pods are stuck Pending", zap.Strings("pods", podNames), zap.Duration("pendingTimeout", pendingTimeout))
+	stuck := fmt.Sprintf("Agent pods %s are Pending for more than %s", strings.Join(podNames, ", "), pendingTimeout)
+
+	if watchdog.Action == updatev1.PendingPodActionScaleUpBackupPool && watchdog.MaxBackupPoolCount != nil {
+		temporaryNodepoolName := r.temporaryNodepoolName()
+		scaled, err := r.target.nodepoolController.ScaleUpNodePool(ctx, temporaryNodepoolName, *watchdog.MaxBackupPoolCount)
+		if nodepool.IsRetryable(err) {
+			c.Logger.Info("Scale up of the temporary nodepool conflicted, pausing the evictions until it is retried", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+			scaled, err = false, nil
+		}
+		if err != nil {
+			return err
+		}
+		if scaled {
+			c.setPodsPending(r, metav1.ConditionTrue, updatev1.ReasonBackupPoolScaledUp,
+				fmt.Sprintf("%s, temporary nodepool '%s' is scaled up to at most %d nodes", stuck, temporaryNodepoolName, *watchdog.MaxBackupPoolCount))
+			return nil
+		}
+	}
+	r.evictionsPaused = true
+	c.setPodsPending(r, metav1.ConditionTrue, updatev1.ReasonEvictionsPaused, stuck+", evictions are paused until they are scheduled")
+	return nil
+}
+
+// setPodsPending reports the agent pods stuck Pending in the PodsPending condition, the condition is only added to the
+// status once pods were stuck
+func (c *SafeEvictReconciler) setPodsPending(r *rotation, status metav1.ConditionStatus, reason, message string) {
+	if status == metav1.ConditionFalse && meta.FindStatusCondition(r.status.Conditions, updatev1.ConditionPodsPending) == nil {
+		return
+	}
+	meta.SetStatusCondition(&r.status.Conditions, metav1.Condition{
+		Type:               updatev1.ConditionPodsPending,
+		Status:             status,
+		ObservedGeneration: r.safeEvict.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// admitNodepools returns the outdated nodepools the drain acts on in this reconcile. The nodepools which are rotated
+// already keep their slot until they are upgraded, even when their last step failed, unless ARM failed their
+// provisioning: such a nodepool stays failed until it is fixed in AKS, so it is still acted on but does not hold back
+// the queued ones. The queued ones are admitted in name order while fewer than MaxConcurrentPools nodepools hold a slot.
+func (c *SafeEvictReconciler) admitNodepools(r *rotation) map[string]bool {
+	admitted := make(map[string]bool, len(r.outdatedNodePools))
+	slots := 0
+	var queued []string
+	for _, nodepoolName := range slices.Sorted(maps.Keys(r.outdatedNodePools)) {
+		switch r.status.GetNodepoolState(nodepoolName) {
+		case updatev1.NodepoolStateInProgress:
+			admitted[nodepoolName] = true
+			slots++
+		case updatev1.NodepoolStateFailed:
+			admitted[nodepoolName] = true
+			if !nodepool.GetProvisioningState(r.outdatedNodePools[nodepoolName]).Failed() {
+				slots++
+			}
+		default:
+			// a nodepool which became outdated during the rotation has no state yet
+			queued = append(queued, nodepoolName)
+		}
+	}
+
+	maxConcurrentPools := r.safeEvict.Spec.MaxConcurrentPools
+	for _, nodepoolName := range queued {
+		if maxConcurrentPools != nil && slots >= int(*maxConcurrentPools) {
+			r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateQueued, fmt.Sprintf("Waiting until fewer than %d nodepools are rotated", *maxConcurrentPools))
+			continue
+		}
+		if maxConcurrentPools != nil {
+			c.Logger.Info("Starting the rotation of the queued nodepool", zap.String("nodepoolName", nodepoolName), zap.Int("rotatedNodepools", slots))
+		}
+		r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateInProgress, "")
+		admitted[nodepoolName] = true
+		slots++
+	}
+	return admitted
+}
+
+// setAwaitingApproval reports the drained nodepools which wait for the approval of their upgrade in the
+// AwaitingApproval condition, the condition is only added to the status of SafeEvicts which require approval
+func (c *SafeEvictReconciler) setAwaitingApproval(r *rotation, nodepoolNames []string) {
+	if !r.safeEvict.Spec.RequireApproval {
+		return
+	}
+	condition := metav1.Condition{
+		Type:               updatev1.ConditionAwaitingApproval,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: r.safeEvict.Generation,
+		Reason:             updatev1.ReasonApproved,
+		Message:            "Upgrade of the drained nodepools is approved",
+	}
+	if len(nodepoolNames) > 0 {
+		c.Logger.Info("Drained nodepools are waiting for approval", zap.Strings("nodepools", nodepoolNames), zap.String("annotation", updatev1.ApprovedAnnotation))
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = updatev1.ReasonApprovalRequired
+		condition.Message = fmt.Sprintf("Nodepools %s are drained, annotate the SafeEvict with %s set to the current time in RFC 3339 to upgrade them",
+			strings.Join(nodepoolNames, ", "), updatev1.ApprovedAnnotation)
+	}
+	meta.SetStatusCondition(&r.status.Conditions, condition)
+}
+
+// setUpgradeBlocked reports the upgrades which were not permitted in the UpgradeBlocked condition, the condition is
+// only added to the status once an upgrade was blocked
+func (c *SafeEvictReconciler) setUpgradeBlocked(r *rotation, reasons []string) {
+	if len(reasons) == 0 && meta.FindStatusCondition(r.status.Conditions, updatev1.ConditionUpgradeBlocked) == nil {
+		return
+	}
+	condition := metav1.Condition{
+		Type:               updatev1.ConditionUpgradeBlocked,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: r.safeEvict.Generation,
+		Reason:             updatev1.ReasonUpgradePermitted,
+		Message:            "Node image upgrades are permitted",
+	}
+	if len(reasons) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = updatev1.ReasonUpgradeNotPermitted
+		condition.Message = strings.Join(reasons, "; ")
+	}
+	meta.SetStatusCondition(&r.status.Conditions, condition)
+}
+
+// setPlacementImpossible reports the nodepools whose agent pods could not be scheduled on the temporary nodepool in the
+// PlacementImpossible condition, the condition is only added to the status once a placement was impossible
+func (c *SafeEvictReconciler) setPlacementImpossible(r *rotation, reasons []string) {
+	if len(reasons) == 0 && meta.FindStatusCondition(r.status.Conditions, updatev1.ConditionPlacementImpossible) == nil {
+		return
+	}
+	condition := metav1.Condition{
+		Type:               updatev1.ConditionPlacementImpossible,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: r.safeEvict.Generation,
+		Reason:             updatev1.ReasonSchedulable,
+		Message:            "Agent pods can be placed on the temporary nodepool",
+	}
+	if len(reasons) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = updatev1.ReasonUnschedulable
+		condition.Message = strings.Join(reasons, "; ")
+	}
+	meta.SetStatusCondition(&r.status.Conditions, condition)
+}
+
+// awaitUpgrade waits until the node image upgrade of every outdated nodepool is finished. A nodepool which is ready
+// but still outdated is sent back to draining, which starts its upgrade again. Once the upgrades are finished, the
+// nodes which missed them are replaced before the nodepools are restored.
+func (c *SafeEvictReconciler) awaitUpgrade(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	if len(r.outdatedNodePools) == 0 {
+		c.Logger.Info("Node image upgrades are finished")
+		replaced, err := c.replaceStragglers(ctx, r)
+		if err != nil {
+			return c.failIn(updatev1.PhaseUpgrading, err)
+		}
+		if !replaced {
+			return c.waitIn(updatev1.PhaseUpgrading)
+		}
+		return updatev1.PhaseRestoring, nil, nil
+	}
+	redrain := false
+	var errs []error
+	var upgrading []nodepool.ProvisioningState
+	for _, nodepoolName := range slices.Sorted(maps.Keys(r.outdatedNodePools)) {
+		provisioningState := nodepool.GetProvisioningState(r.outdatedNodePools[nodepoolName])
+		upgrading = append(upgrading, provisioningState)
+		if provisioningState.Failed() {
+			// the nodepool stays in the state until it is fixed in AKS, waiting for its upgrade would never end
+			c.Logger.Error("Node image upgrade of nodepool failed", zap.String("nodepoolName", nodepoolName), zap.String("provisioningState", string(provisioningState)))
+			errs = append(errs, r.nodepoolFailed(nodepoolName, fmt.Errorf("%w: node pool '%s' is in provisioning state '%s'", nodepool.ErrProvisioningFailed, nodepoolName, provisioningState)))
+			continue
+		}
+		if provisioningState == nodepool.ProvisioningStateSucceeded {
+			c.Logger.Info("Node pool is ready but still outdated, draining it again", zap.String("nodepoolName", nodepoolName))
+			redrain = true
+		}
+	}
+	// the drain acts on every nodepool, the failed ones are reported by it again
+	if redrain {
+		return updatev1.PhaseDraining, nil, nil
+	}
+	if len(errs) > 0 {
+		return c.failIn(updatev1.PhaseUpgrading, errors.Join(errs...))
+	}
+	c.Logger.Info("Node image upgrades are still running, requeuing...")
+	return c.waitForStates(updatev1.PhaseUpgrading, upgrading...)
+}
+
+// replaceStragglers drains the nodes whose node image version label lags behind the upgraded version of their
+// nodepool and deletes them, so the scale set replaces them with nodes of the upgraded image. The outdatedness of the
+// nodepools themselves is decided by the version ARM reports. It returns true once the stragglers are deleted, the
+// replacements are not waited for, so a node which misses the upgrade again does not hold the rotation back.
+func (c *SafeEvictReconciler) replaceStragglers(ctx context.Context, r *rotation) (bool, error) {
+	// the NodeReimage strategy compares every node with the latest node image while it rolls the nodepool, ARM keeps
+	// reporting the version of the last nodepool upgrade
+	if r.safeEvict.Spec.UpgradeStrategy == updatev1.UpgradeStrategyNodeReimage {
+		return true, nil
+	}
+	nodepoolController := r.target.nodepoolController
+
+	nodepools := slices.DeleteFunc(slices.Clone(r.nodepools), func(nodepoolName string) bool {
+		return r.status.GetNodepoolState(nodepoolName) == updatev1.NodepoolStateSkipped
+	})
+	stragglers, err := nodepoolController.GetStragglerNodes(ctx, nodepools)
+	if err != nil {
+		c.Logger.Error("Failed to check the nodes for stragglers", zap.Error(err))
+		return false, err
+	}
+	if len(stragglers) > 0 {
+		r.status.StragglerNodes = int32(len(stragglers))
+	}
+	metrics.RecordStragglers(r.req.Namespace, r.req.Name, r.target.clusterName, int(r.status.StragglerNodes))
+	if len(stragglers) == 0 {
+		return true, nil
+	}
+
+	drained := true
+	for _, nodepoolName := range nodepools {
+		nodes := slices.DeleteFunc(slices.Clone(stragglers), func(node corev1.Node) bool {
+			return node.Labels[nodepool.AgentPoolLabel] != nodepoolName
+		})
+		for _, node := range nodes {
+			c.Logger.Warn("Node missed the node image upgrade of its nodepool, replacing it", zap.String("nodeName", node.Name), zap.String("nodepoolName", nodepoolName), zap.String("nodeImageVersion", node.Labels[nodepool.NodeImageVersionLabel]))
+		}
+		free, err := c.drainNodes(ctx, r, nodepoolName, nodes)
+		if err != nil {
+			return false, err
+		}
+		drained = drained && free
+	}
+	if !drained {
+		return false, nil
+	}
+
+	for _, node := range stragglers {
+		if err := nodepoolController.DeleteNode(ctx, node.Name); err != nil {
+			return false, err
+		}
+		c.Logger.Info("Deleted straggler node, the scale set replaces it", zap.String("nodeName", node.Name), zap.String("nodepoolName", node.Labels[nodepool.AgentPoolLabel]))
+	}
+	metrics.RecordStragglersReplaced(r.req.Namespace, r.req.Name, r.target.clusterName, len(stragglers))
+	return true, nil
+}
+
+// restore brings back the saved scaling of the upgraded nodepools and makes them schedulable again. A failing nodepool
+// does not hold back the others. It waits until every nodepool is ready, otherwise the next rotation would start on a
+// nodepool which is still updating.
+func (c *SafeEvictReconciler) restore(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	configMapData, err := c.ConfigmapController.GetConfigMapData(ctx, r.target.configmapNamespace, r.target.configmapName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		c.Logger.Error("Failed to retrieve ConfigMap data", zap.Error(err))
+		return c.failIn(updatev1.PhaseRestoring, err)
+	}
+	if err := c.verifyScaling(r, configMapData); err != nil {
+		return c.failIn(updatev1.PhaseRestoring, err)
+	}
+
+	pending := false
+	var backoff time.Duration
+	var errs []error
+	for _, nodepoolName := range slices.Sorted(maps.Keys(configMapData)) {
+		if !isScalingKey(nodepoolName) {
+			continue
+		}
+		restored, err := c.restoreNodePool(ctx, r, nodepoolName, configMapData)
+		if nodepool.IsRetryable(err) {
+			backoff = max(backoff, c.conflictBackoff(r, nodepoolName, err))
+			continue
+		}
+		if err != nil {
+			errs = append(errs, r.nodepoolFailed(nodepoolName, err))
+			continue
+		}
+		if !restored {
+			pending = true
+			continue
+		}
+		if r.status.GetNodepoolState(nodepoolName) != updatev1.NodepoolStateSucceeded {
+			if err := c.labelRotatedNodes(ctx, r, nodepoolName); err != nil {
+				errs = append(errs, r.nodepoolFailed(nodepoolName, err))
+				continue
+			}
+			r.status.Counters.DowntimeFreeUpgrades++
+			r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateSucceeded, "")
+			c.recordImageVersion(ctx, r, nodepoolName)
+		}
+	}
+
+	if len(errs) > 0 {
+		return c.failIn(updatev1.PhaseRestoring, errors.Join(errs...))
+	}
+	if backoff > 0 {
+		return c.backOffIn(updatev1.PhaseRestoring, backoff)
+	}
+	if pending {
+		return c.waitIn(updatev1.PhaseRestoring)
+	}
+	return updatev1.PhaseCleaningUp, nil, nil
+}
+
+// labelRotatedNodes marks the nodes of the restored nodepool with the time of the rotation and the SafeEvict which
+// ran it, so the rotated nodes can be found by it. The labels are left out when the name or the namespace is no valid
+// label value, the annotations are always set.
+func (c *SafeEvictReconciler) labelRotatedNodes(ctx context.Context, r *rotation, nodepoolName string) error {
+	annotations := map[string]string{
+		updatev1.LastRotationAnnotation: time.Now().UTC().Format(time.RFC3339),
+		updatev1.RotatedByAnnotation:    r.safeEvict.GetOwnerTag(),
+	}
+	labels := map[string]string{}
+	if len(validation.IsValidLabelValue(r.safeEvict.Name)) == 0 && len(validation.IsValidLabelValue(r.safeEvict.Namespace)) == 0 {
+		labels[updatev1.RotatedByLabel] = r.safeEvict.Name
+		labels[updatev1.RotatedByNamespaceLabel] = r.safeEvict.Namespace
+	} else {
+		c.Logger.Info("SafeEvict name is no valid label value, the rotated nodes are only annotated", zap.String("nodepoolName", nodepoolName))
+	}
+	if err := r.target.nodepoolController.LabelNodesByAgentPool(ctx, nodepoolName, labels, annotations); err != nil {
+		c.Logger.Error("Failed to label the nodes of the restored nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return err
+	}
+	return nil
+}
+
+// recordImageVersion adds the node image version of the restored nodepool to its image history. The history is
+// informational, a failed lookup of the version is logged and does not hold the rotation back.
+func (c *SafeEvictReconciler) recordImageVersion(ctx context.Context, r *rotation, nodepoolName string) {
+	agentPool, err := r.target.nodepoolController.GetNodePoolByName(ctx, nodepoolName)
+	if err != nil {
+		c.Logger.Warn("Failed to get the node image version of the restored nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return
+	}
+	if agentPool.Properties == nil || agentPool.Properties.NodeImageVersion == nil {
+		return
+	}
+	r.status.RecordImageVersion(nodepoolName, updatev1.ImageVersionRecord{
+		Version:     *agentPool.Properties.NodeImageVersion,
+		UpgradedAt:  metav1.Now(),
+		InitiatedBy: r.safeEvict.GetOwnerTag(),
+	}, r.safeEvict.GetImageHistoryLimit())
+}
+
+// restoreNodePool restores the saved scaling and node taints of one nodepool and uncordons it. It returns true when
+// the nodepool is ready with the restored scaling.
+func (c *SafeEvictReconciler) restoreNodePool(ctx context.Context, r *rotation, nodepoolName string, configMapData map[string]string) (bool, error) {
+	nodepoolController := r.target.nodepoolController
+
+	c.Logger.Debug("Nodepool is ready to take workload again", zap.String("nodepoolName", nodepoolName))
+	agentPool, err := nodepoolController.GetNodePoolByName(ctx, nodepoolName)
+	if err != nil {
+		c.Logger.Error("Failed to get nodepool by name", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	provisioningState := nodepool.GetProvisioningState(*agentPool)
+	if provisioningState.Failed() {
+		return false, fmt.Errorf("%w: node pool '%s' is in provisioning state '%s', its scaling cannot be restored", nodepool.ErrProvisioningFailed, nodepoolName, provisioningState)
+	}
+	if provisioningState != nodepool.ProvisioningStateSucceeded {
+		c.Logger.Debug("Node pool is still updating", zap.String("nodepoolName", nodepoolName), zap.String("provisioningState", string(provisioningState)))
+		return false, nil
+	}
+
+	c.Logger.Debug("Restoring original scaling settings for the nodepool", zap.String("nodepoolName", nodepoolName), zap.String("scalingSettings", configMapData[nodepoolName]))
+	err = nodepoolController.SetDefaultScaling(ctx, agentPool, configMapData[nodepoolName])
+	if err != nil {
+		c.Logger.Error("Failed to restore original scaling settings for the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	c.Logger.Debug("Restore of original scaling settings is completed", zap.String("nodepoolName", nodepoolName))
+
+	err = nodepoolController.CordonNodesByAgentPool(ctx, nodepoolName, false)
+	if err != nil {
+		c.Logger.Error("Failed to uncordon nodes in the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	c.Logger.Debug("Nodes in the nodepool have been uncordoned", zap.String("nodepoolName", nodepoolName))
+	if err := c.restoreNodeTaints(ctx, r, nodepoolName, configMapData); err != nil {
+		return false, err
+	}
+	err = nodepoolController.SetScaleDownDisabledByAgentPool(ctx, nodepoolName, false)
+	if err != nil {
+		c.Logger.Error("Failed to enable autoscaler scale down for the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+
+	// the scaling update is running when the saved scaling differed from the current one, the nodepool is restored
+	// once a later reconcile sees the update landed
+	return nodepoolController.ScalingRestored(ctx, nodepoolName, configMapData[nodepoolName])
+}
+
+// cleanUp drains and removes the temporary nodepool, then deletes the saved scaling which ends the rotation. A shared
+// temporary nodepool is drained once every SafeEvict using it released it, and removed by the last one whose pods left.
+func (c *SafeEvictReconciler) cleanUp(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	nodepoolController := r.target.nodepoolController
+	temporaryNodepoolName := r.temporaryNodepoolName()
+
+	temporaryNodepoolExists, err := nodepoolController.NodePoolExists(ctx, temporaryNodepoolName)
+	if err != nil {
+		c.Logger.Error("Failed to check if temporary nodepool exists", zap.Error(err))
+		return c.failIn(updatev1.PhaseCleaningUp, err)
+	}
+	if !temporaryNodepoolExists {
+		return c.finishRotation(ctx, r)
+	}
+
+	temporaryNodepool, err := nodepoolController.GetNodePoolByName(ctx, temporaryNodepoolName)
+	if err != nil {
+		c.Logger.Error("Failed to get temporary nodepool by name", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return c.failIn(updatev1.PhaseCleaningUp, err)
+	}
+	if nodepool.GetProvisioningState(*temporaryNodepool) == nodepool.ProvisioningStateDeleting {
+		c.Logger.Info("Temporary node pool is being removed, requeuing...")
+		return c.waitForStates(updatev1.PhaseCleaningUp, nodepool.ProvisioningStateDeleting)
+	}
+	if meta.IsStatusConditionTrue(r.status.Conditions, updatev1.ConditionRolledBackToBackupPool) {
+		return c.holdOnTemporaryNodepool(ctx, r)
+	}
+
+	if r.safeEvict.SharesBackupPool() {
+		released, err := nodepoolController.ReleaseSharedNodePool(ctx, temporaryNodepoolName, r.safeEvict.GetOwnerTag())
+		if errors.Is(err, nodepool.ErrNodePoolNotManaged) {
+			c.Logger.Info("Shared temporary nodepool is not used by this SafeEvict anymore", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+			return c.finishRotation(ctx, r)
+		}
+		if nodepool.IsRetryable(err) {
+			return c.backOffIn(updatev1.PhaseCleaningUp, c.conflictBackoff(r, temporaryNodepoolName, err))
+		}
+		if err != nil {
+			c.Logger.Error("Failed to release the shared temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+			return c.failIn(updatev1.PhaseCleaningUp, err)
+		}
+		// draining cordons the nodes, the pods of the other rotations would have nowhere to go
+		if !released {
+			c.Logger.Info("Shared temporary nodepool is still used by other SafeEvicts, waiting for their rotations", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+			return c.waitIn(updatev1.PhaseCleaningUp)
+		}
+	}
+
+	c.Logger.Debug("Starting to drain the temporary nodepool", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+	drained, err := c.drainNodePool(ctx, r, *temporaryNodepool)
+	if err != nil {
+		c.Logger.Error("Failed to drain the temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return c.failIn(updatev1.PhaseCleaningUp, err)
+	}
+	if !drained {
+		return c.waitIn(updatev1.PhaseCleaningUp)
+	}
+
+	if r.safeEvict.SharesBackupPool() {
+		last, err := nodepoolController.DropSharedNodePoolReference(ctx, temporaryNodepoolName, r.safeEvict.GetOwnerTag())
+		if nodepool.IsRetryable(err) {
+			return c.backOffIn(updatev1.PhaseCleaningUp, c.conflictBackoff(r, temporaryNodepoolName, err))
+		}
+		if err != nil {
+			c.Logger.Error("Failed to drop the reference of the shared temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+			return c.failIn(updatev1.PhaseCleaningUp, err)
+		}
+		if !last {
+			c.Logger.Info("Pods left the shared temporary nodepool, it is removed by the last SafeEvict using it", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+			return c.finishRotation(ctx, r)
+		}
+	}
+
+	retained, err := c.retainTemporaryNodepool(ctx, r, temporaryNodepoolName)
+	if nodepool.IsRetryable(err) {
+		return c.backOffIn(updatev1.PhaseCleaningUp, c.conflictBackoff(r, temporaryNodepoolName, err))
+	}
+	if err != nil {
+		c.Logger.Error("Failed to scale down the temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return c.failIn(updatev1.PhaseCleaningUp, err)
+	}
+	if retained > 0 {
+		degraded, err := c.agentsDegraded(ctx, r)
+		if err != nil {
+			c.Logger.Error("Failed to check the agents on the upgraded nodepools", zap.Error(err))
+			return c.failIn(updatev1.PhaseCleaningUp, err)
+		}
+		if degraded != "" {
+			return c.rollBackToTemporaryNodepool(ctx, r, degraded)
+		}
+		return updatev1.PhaseCleaningUp, &ctrl.Result{RequeueAfter: min(retained, c.Config.Load().SuccessReconcileTime)}, nil
+	}
+
+	c.Logger.Debug("All stateful pods have been evicted from the temporary nodepool, removing it...", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+	err = nodepoolController.RemoveTemporaryNodePool(ctx, temporaryNodepoolName, r.safeEvict.GetOwnerTag())
+	if errors.Is(err, nodepool.ErrProvisioningTimeout) {
+		c.Logger.Info("Temporary nodepool is still being removed, requeuing...", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return c.waitIn(updatev1.PhaseCleaningUp)
+	}
+	if err != nil {
+		c.Logger.Error("Failed to remove temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return c.retryIn(updatev1.PhaseCleaningUp)
+	}
+
+	temporaryNodepoolExists, err = nodepoolController.NodePoolExists(ctx, temporaryNodepoolName)
+	if err != nil {
+		return c.failIn(updatev1.PhaseCleaningUp, err)
+	}
+	if temporaryNodepoolExists {
+		c.Logger.Info("Temporary nodepool removal has been started", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return c.waitIn(updatev1.PhaseCleaningUp)
+	}
+	return c.finishRotation(ctx, r)
+}
+
+// retainTemporaryNodepool keeps the drained temporary nodepool of a completed rotation for spec.backupPool.retainFor.
+// The nodepool is scaled down when the retention starts. It returns how long the nodepool is still retained, zero once
+// it can be removed.
+func (c *SafeEvictReconciler) retainTemporaryNodepool(ctx context.Context, r *rotation, temporaryNodepoolName string) (time.Duration, error) {
+	retention := r.safeEvict.GetBackupPoolRetention()
+	if retention <= 0 || meta.IsStatusConditionTrue(r.status.Conditions, updatev1.ConditionFailed) {
+		return 0, nil
+	}
+	if r.status.TemporaryNodepoolRetainedUntil == nil {
+		count, err := r.target.nodepoolController.ScaleNodePool(ctx, temporaryNodepoolName, 0)
+		if err != nil {
+			return 0, err
+		}
+		r.status.TemporaryNodepoolCount = count
+		r.status.TemporaryNodepoolRetainedUntil = &metav1.Time{Time: time.Now().Add(retention).Truncate(time.Second)}
+		c.Logger.Info("Temporary nodepool is scaled down and retained", zap.String("temporaryNodepoolName", temporaryNodepoolName), zap.Time("retainedUntil", r.status.TemporaryNodepoolRetainedUntil.Time))
+	}
+	return max(time.Until(r.status.TemporaryNodepoolRetainedUntil.Time), 0), nil
+}
+
+// upgradedNodepools returns the nodepools the rotation upgraded and restored
+func (r *rotation) upgradedNodepools() []string {
+	var upgraded []string
+	for _, pool := range r.status.Pools {
+		if pool.State == updatev1.NodepoolStateSucceeded {
+			upgraded = append(upgraded, pool.Name)
+		}
+	}
+	return upgraded
+}
+
+// agentsDegraded checks the agents on the upgraded nodepools against the thresholds of the automatic rollback while the
+// temporary nodepool is retained. It returns why they count as degraded, empty when they are healthy or not checked.
+func (c *SafeEvictReconciler) agentsDegraded(ctx context.Context, r *rotation) (string, error) {
+	autoRollback := r.safeEvict.GetAutoRollback()
+	upgraded := r.upgradedNodepools()
+	if autoRollback == nil || len(upgraded) == 0 {
+		return "", nil
+	}
+	health, err := r.target.podController.GetAgentHealth(ctx, r.safeEvict.Spec, upgraded, autoRollback.GetGracePeriod())
+	if err != nil {
+		return "", err
+	}
+	c.Logger.Debug("Checked the agents on the upgraded nodepools", zap.Strings("nodepools", upgraded), zap.Int("agents", health.Agents), zap.Int("ready", health.Ready), zap.Int("online", health.Online))
+	if health.Agents == 0 {
+		return "", nil
+	}
+	if minReady := autoRollback.GetMinReadyPercent(); health.Ready*100 < int(minReady)*health.Agents {
+		return fmt.Sprintf("only %d of %d agent pods on the upgraded nodepools are ready, at least %d%% are required", health.Ready, health.Agents, minReady), nil
+	}
+	if minOnline := autoRollback.GetMinOnlinePercent(); health.Online >= 0 && health.Online*100 < int(minOnline)*health.Agents {
+		return fmt.Sprintf("only %d of %d agents on the upgraded nodepools are online in Azure DevOps, at least %d%% are required", health.Online, health.Agents, minOnline), nil
+	}
+	return "", nil
+}
+
+// rollBackToTemporaryNodepool moves the workload back onto the retained temporary nodepool when the agents on the
+// upgraded nodepools degraded. The temporary nodepool is scaled back to its node count, the rotation is marked as failed
+// and the notifiers are told about it, then the workload is held on the temporary nodepool.
+func (c *SafeEvictReconciler) rollBackToTemporaryNodepool(ctx context.Context, r *rotation, degraded string) (updatev1.Phase, *ctrl.Result, error) {
+	temporaryNodepoolName := r.temporaryNodepoolName()
+	c.Logger.Error("Agents on the upgraded nodepools degraded, moving the workload back onto the temporary nodepool", zap.String("reason", degraded), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+	_, err := r.target.nodepoolController.ScaleNodePool(ctx, temporaryNodepoolName, r.status.TemporaryNodepoolCount)
+	if nodepool.IsRetryable(err) {
+		return c.backOffIn(updatev1.PhaseCleaningUp, c.conflictBackoff(r, temporaryNodepoolName, err))
+	}
+	if err != nil {
+		c.Logger.Error("Failed to scale up the temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return c.failIn(updatev1.PhaseCleaningUp, err)
+	}
+
+	message := fmt.Sprintf("Workload was moved back onto temporary nodepool %s: %s", temporaryNodepoolName, degraded)
+	meta.SetStatusCondition(&r.status.Conditions, metav1.Condition{
+		Type:               updatev1.ConditionFailed,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: r.safeEvict.Generation,
+		Reason:             updatev1.ReasonAgentsDegraded,
+		Message:            message,
+	})
+	meta.SetStatusCondition(&r.status.Conditions, metav1.Condition{
+		Type:               updatev1.ConditionRolledBackToBackupPool,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: r.safeEvict.Generation,
+		Reason:             updatev1.ReasonHeld,
+		Message:            fmt.Sprintf("%s, annotate the SafeEvict with %s=true to remove it", message, updatev1.AbortAnnotation),
+	})
+	// the rollback does not wait for the notification, it is not sent again
+	c.notify(ctx, r, notify.Notification{Event: notify.EventRolledBack, Nodepools: r.upgradedNodepools(), Error: degraded, Message: message})
+	return c.holdOnTemporaryNodepool(ctx, r)
+}
+
+// notify sends the notification with the metadata of the rotation, its message is rendered by the notification
+// template of its event. A notification which fails is logged, the rotation goes on without it.
+func (c *SafeEvictReconciler) notify(ctx context.Context, r *rotation, notification notify.Notification) {
+	if c.Notifier == nil {
+		return
+	}
+	notification.Namespace = r.safeEvict.Namespace
+	notification.Name = r.safeEvict.Name
+	notification.Cluster = r.target.clusterName
+	notification.Versions = r.imageVersions(notification.Nodepools)
+	if r.status.StartTime != nil {
+		notification.Duration = time.Since(r.status.StartTime.Time).Round(time.Second)
+	}
+	notification, err := notify.Format(notification, c.Config.Load().NotificationTemplates)
+	if err != nil {
+		c.Logger.Warn("Failed to format the notification, it is sent with the message of the controller", zap.Error(err), zap.String("event", string(notification.Event)))
+	}
+	if err := c.Notifier.Notify(ctx, notification); err != nil {
+		c.Logger.Error("Failed to send the notification", zap.Error(err), zap.String("event", string(notification.Event)))
+	}
+}
+
+// imageVersions returns the node image versions the rotations upgraded the nodepools to last, by nodepool
+func (r *rotation) imageVersions(nodepools []string) map[string]string {
+	versions := make(map[string]string, len(nodepools))
+	for _, pool := range r.status.Pools {
+		if slices.Contains(nodepools, pool.Name) && len(pool.ImageHistory) > 0 {
+			versions[pool.Name] = pool.ImageHistory[len(pool.ImageHistory)-1].Version
+		}
+	}
+	return versions
+}
+
+// holdOnTemporaryNodepool keeps the upgraded nodepools cordoned and evicts their idle agents, so the workload runs on
+// the temporary nodepool, until the abort annotation releases the hold. The released upgraded nodepools are uncordoned,
+// and the temporary nodepool is drained and removed as for any failed rotation.
+func (c *SafeEvictReconciler) holdOnTemporaryNodepool(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	nodepoolController := r.target.nodepoolController
+	release := r.safeEvict.Annotations[updatev1.AbortAnnotation] == "true"
+	for _, nodepoolName := range r.upgradedNodepools() {
+		if err := nodepoolController.CordonNodesByAgentPool(ctx, nodepoolName, !release); err != nil {
+			c.Logger.Error("Failed to change the cordon of the upgraded nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName), zap.Bool("release", release))
+			return c.failIn(updatev1.PhaseCleaningUp, err)
+		}
+		if release {
+			continue
+		}
+		if err := c.evictIdleAgentsOf(ctx, r, nodepoolName); err != nil {
+			c.Logger.Error("Failed to evict the idle agents of the upgraded nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+			return c.failIn(updatev1.PhaseCleaningUp, err)
+		}
+	}
+	if !release {
+		return c.waitIn(updatev1.PhaseCleaningUp)
+	}
+
+	c.Logger.Info("Hold on the temporary nodepool is released with annotation", zap.String("annotation", updatev1.AbortAnnotation))
+	meta.SetStatusCondition(&r.status.Conditions, metav1.Condition{
+		Type:               updatev1.ConditionRolledBackToBackupPool,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: r.safeEvict.Generation,
+		Reason:             updatev1.ReasonReleased,
+		Message:            fmt.Sprintf("Hold was released with annotation %s", updatev1.AbortAnnotation),
+	})
+	return c.waitIn(updatev1.PhaseCleaningUp)
+}
+
+// evictIdleAgentsOf evicts the idle agent pods of the cordoned nodepool with the pacing of the drains
+func (c *SafeEvictReconciler) evictIdleAgentsOf(ctx context.Context, r *rotation, nodepoolName string) error {
+	nodes, err := r.target.nodepoolController.GetNodesByNodePool(ctx, nodepoolName)
+	if err != nil {
+		return err
+	}
+	safeToEvictPods, err := r.target.podController.GetSafeToEvictPods(ctx, r.safeEvict.Spec)
+	if err != nil {
+		return err
+	}
+	safeToEvictPods = filterPodsOnNodes(safeToEvictPods, nodes)
+	if r.target.selfExclusionController != nil {
+		safeToEvictPods = r.target.selfExclusionController.ExcludeOwnPod(safeToEvictPods)
+	}
+	pod.SortForEviction(safeToEvictPods, r.safeEvict.GetEvictionOrder())
+	safeToEvictPods, err = c.limitEvictions(ctx, r, nodepoolName, safeToEvictPods)
+	if err != nil {
+		return err
+	}
+	return c.evictIdlePods(ctx, r, safeToEvictPods)
+}
+
+// finishRotation resumes the suspended CronJobs and deletes the saved scaling once the temporary nodepool is gone. A
+// rolled back rotation already deleted the saved scaling when it could be restored, so it moves on to the failed phase
+// instead.
+func (c *SafeEvictReconciler) finishRotation(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	c.Logger.Info("Temporary nodepool has been removed successfully", zap.String("temporaryNodepoolName", r.temporaryNodepoolName()))
+	metrics.ForgetTemporaryNodepool(r.req.Namespace, r.req.Name, r.target.clusterName)
+	r.status.TemporaryNodepool = ""
+	r.status.TemporaryNodepoolRetainedUntil = nil
+	r.status.TemporaryNodepoolCount = 0
+	if err := r.target.podController.ResumeCronJobs(ctx, r.safeEvict.Spec.Namespaces); err != nil {
+		c.Logger.Error("Failed to resume the CronJobs suspended by the rotation", zap.Error(err))
+		return c.failIn(updatev1.PhaseCleaningUp, err)
+	}
+	if meta.IsStatusConditionTrue(r.status.Conditions, updatev1.ConditionFailed) {
+		return c.rollBackFinished(r)
+	}
+	c.Logger.Debug("Starting to delete temporary ConfigMap", zap.String("configMapName", r.target.configmapName))
+	err := c.ConfigmapController.DeleteConfigMap(ctx, r.target.configmapNamespace, r.target.configmapName)
+	if err != nil {
+		return c.failIn(updatev1.PhaseCleaningUp, err)
+	}
+	c.Logger.Info("ConfigMap deleted successfully", zap.String("configMapName", r.target.configmapName))
+	r.status.Counters.Rotations++
+	r.status.Counters.SucceededRotations++
+	return updatev1.PhaseDetecting, &ctrl.Result{RequeueAfter: c.Config.Load().SuccessReconcileTime}, nil
+}
+
+// rollBack stops a rotation which exceeded the upgrade timeout or was aborted. The nodes of the nodepools are
+// uncordoned and get their saved taints back, and the saved scaling is restored on every nodepool which is ready. The
+// saved scaling of a nodepool which is still updating is kept, the next rotation or the next check which finds the
+// cluster up to date restores it. The temporary nodepool of a timed out rotation is removed only when the SafeEvict
+// asks for it, otherwise the next rotation reuses it; an aborted rotation always removes it, and a shared one is always
+// released.
+func (c *SafeEvictReconciler) rollBack(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	stopped := "Upgrade timed out"
+	if r.aborted() {
+		stopped = "Rotation was aborted"
+	}
+	configMapData, err := c.ConfigmapController.GetConfigMapData(ctx, r.target.configmapNamespace, r.target.configmapName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		c.Logger.Error("Failed to retrieve ConfigMap data", zap.Error(err))
+		return c.failIn(updatev1.PhaseRollingBack, err)
+	}
+	if err := c.verifyScaling(r, configMapData); err != nil {
+		return c.failIn(updatev1.PhaseRollingBack, err)
+	}
+
+	keepState := false
+	var errs []error
+	for _, nodepoolName := range slices.Sorted(maps.Keys(configMapData)) {
+		if !isScalingKey(nodepoolName) {
+			continue
+		}
+		restored, err := c.rollBackNodePool(ctx, r, nodepoolName, configMapData)
+		if err != nil {
+			errs = append(errs, r.nodepoolFailed(nodepoolName, err))
+			continue
+		}
+		if !restored {
+			keepState = true
+			r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateFailed, stopped+" while the nodepool was updating, its saved scaling is restored once it is ready")
+			continue
+		}
+		if r.status.GetNodepoolState(nodepoolName) != updatev1.NodepoolStateSucceeded {
+			r.status.SetNodepoolState(nodepoolName, updatev1.NodepoolStateFailed, stopped+", the saved scaling of the nodepool was restored")
+		}
+	}
+	if len(errs) > 0 {
+		return c.failIn(updatev1.PhaseRollingBack, errors.Join(errs...))
+	}
+
+	if keepState {
+		c.Logger.Warn("Saved scaling is kept for the nodepools which are still updating", zap.String("configMapName", r.target.configmapName))
+	} else {
+		err = c.ConfigmapController.DeleteConfigMap(ctx, r.target.configmapNamespace, r.target.configmapName)
+		if err != nil {
+			c.Logger.Error("Failed to delete ConfigMap", zap.Error(err))
+			return c.failIn(updatev1.PhaseRollingBack, err)
+		}
+	}
+
+	// a shared temporary nodepool is not kept for the next rotation, the other SafeEvicts wait for its release
+	if r.safeEvict.Spec.RemoveBackupPoolOnTimeout || r.aborted() || r.safeEvict.SharesBackupPool() {
+		c.Logger.Info("Removing the temporary nodepool of the rolled back rotation", zap.String("temporaryNodepoolName", r.temporaryNodepoolName()))
+		return updatev1.PhaseCleaningUp, nil, nil
+	}
+	return c.rollBackFinished(r)
+}
+
+// rollBackNodePool makes the nodes of one nodepool schedulable again and restores its saved scaling. It returns false
+// when the nodepool is still updating, its scaling cannot be changed then.
+func (c *SafeEvictReconciler) rollBackNodePool(ctx context.Context, r *rotation, nodepoolName string, configMapData map[string]string) (bool, error) {
+	nodepoolController := r.target.nodepoolController
+
+	err := nodepoolController.CordonNodesByAgentPool(ctx, nodepoolName, false)
+	if err != nil {
+		c.Logger.Error("Failed to uncordon nodes in the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	if err := c.restoreNodeTaints(ctx, r, nodepoolName, configMapData); err != nil {
+		return false, err
+	}
+	err = nodepoolController.SetScaleDownDisabledByAgentPool(ctx, nodepoolName, false)
+	if err != nil {
+		c.Logger.Error("Failed to enable autoscaler scale down for the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+
+	agentPool, err := nodepoolController.GetNodePoolByName(ctx, nodepoolName)
+	if err != nil {
+		c.Logger.Error("Failed to get nodepool by name", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	if provisioningState := nodepool.GetProvisioningState(*agentPool); provisioningState != nodepool.ProvisioningStateSucceeded {
+		c.Logger.Warn("Node pool is still updating, its scaling cannot be restored", zap.String("nodepoolName", nodepoolName), zap.String("provisioningState", string(provisioningState)))
+		return false, nil
+	}
+	c.Logger.Debug("Restoring original scaling settings for the nodepool", zap.String("nodepoolName", nodepoolName), zap.String("scalingSettings", configMapData[nodepoolName]))
+	err = nodepoolController.SetDefaultScaling(ctx, agentPool, configMapData[nodepoolName])
+	if err != nil {
+		c.Logger.Error("Failed to restore original scaling settings for the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	return true, nil
+}
+
+// restoreKeptScaling restores the saved scaling a rolled back rotation kept for the nodepools which were still updating.
+// Once their upgrade finished the cluster is up to date and no rotation restores it, so it is restored before the
+// saved scaling is deleted. It returns false while one of the nodepools is still updating.
+func (c *SafeEvictReconciler) restoreKeptScaling(ctx context.Context, r *rotation) (bool, error) {
+	configMapData, err := c.ConfigmapController.GetConfigMapData(ctx, r.target.configmapNamespace, r.target.configmapName)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := c.verifyScaling(r, configMapData); err != nil {
+		return false, err
+	}
+
+	pending := false
+	var errs []error
+	for _, nodepoolName := range slices.Sorted(maps.Keys(configMapData)) {
+		if !isScalingKey(nodepoolName) {
+			continue
+		}
+		// a nodepool which was removed from the cluster or from the spec has no scaling to restore
+		if !slices.Contains(r.nodepools, nodepoolName) {
+			c.Logger.Info("Nodepool of the kept saved scaling is not rotated anymore, its scaling is dropped", zap.String("nodepoolName", nodepoolName))
+			continue
+		}
+		restored, err := c.rollBackNodePool(ctx, r, nodepoolName, configMapData)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("nodepool '%s': %w", nodepoolName, err))
+			continue
+		}
+		if !restored {
+			pending = true
+			continue
+		}
+		c.Logger.Info("Saved scaling kept by the rolled back rotation is restored", zap.String("nodepoolName", nodepoolName))
+	}
+	if len(errs) > 0 {
+		return false, errors.Join(errs...)
+	}
+	return !pending, nil
+}
+
+// rollBackFinished parks a rolled back rotation in the failed phase until the next upgrade check
+func (c *SafeEvictReconciler) rollBackFinished(r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	r.status.Counters.Rotations++
+	c.Logger.Info("Rotation has been rolled back, it is retried at the next upgrade check")
+	return updatev1.PhaseFailed, &ctrl.Result{RequeueAfter: c.Config.Load().UpgradeFrequency}, nil
+}
+
+// awaitRetry keeps a rolled back rotation in the failed phase for the upgrade frequency, then the rotation starts again
+// from detection. The check-now annotation retries it right away.
+func (c *SafeEvictReconciler) awaitRetry(ctx context.Context, r *rotation) (updatev1.Phase, *ctrl.Result, error) {
+	failed := meta.FindStatusCondition(r.status.Conditions, updatev1.ConditionFailed)
+	if failed != nil && failed.Status == metav1.ConditionTrue && r.safeEvict.Annotations[updatev1.CheckNowAnnotation] == "" {
+		if wait := time.Until(failed.LastTransitionTime.Add(c.Config.Load().UpgradeFrequency)); wait > 0 {
+			c.Logger.Debug("Rotation has failed, waiting for the next upgrade check", zap.Duration("wait", wait))
+			return updatev1.PhaseFailed, &ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+	c.Logger.Info("Retrying the failed rotation")
+	return updatev1.PhaseDetecting, nil, nil
+}
+
+// drainNodePool disables the autoscaling of the nodepool, cordons its nodes and evicts the idle pods from them. It is
+// used for the outdated nodepools and for the temporary nodepool alike. The nodepool is drained when no pods are
+// running on it anymore and the controller itself has been moved off it.
+func (c *SafeEvictReconciler) drainNodePool(ctx context.Context, r *rotation, agentPool armcontainerservice.AgentPool) (bool, error) {
+	podController := r.target.podController
+	nodepoolController := r.target.nodepoolController
+	nodepoolName := *agentPool.Name
+
+	c.Logger.Debug("Disabling auto-scaling for node pool", zap.String("nodepoolName", nodepoolName))
+	// the agent pool was read before the update, the nodes are drained once a later reconcile sees the update landed
+	autoScalingDisabled := nodepool.AutoScalingDisabled(agentPool)
+	err := nodepoolController.DisableAutoScaling(ctx, map[string]armcontainerservice.AgentPool{nodepoolName: agentPool})
+	if err != nil {
+		c.Logger.Error("Failed to disable auto-scaling for node pool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	if !autoScalingDisabled {
+		c.Logger.Info("Waiting for the autoscaling of the nodepool to be disabled", zap.String("nodepoolName", nodepoolName))
+		return false, nil
+	}
+
+	nodes, err := nodepoolController.GetNodesByNodePool(ctx, nodepoolName)
+	if err != nil {
+		c.Logger.Error("Failed to get nodes by nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	// a nodepool scaled to zero runs no pods, and with autoscaling disabled it gets no new nodes
+	if len(nodes) == 0 {
+		c.Logger.Debug("Nodepool has no nodes, nothing to drain", zap.String("nodepoolName", nodepoolName))
+		return true, nil
+	}
+
+	if err := c.checkPlacement(ctx, r, nodepoolName, nodes); err != nil {
+		return false, err
+	}
+
+	err = nodepoolController.CordonNodesByAgentPool(ctx, nodepoolName, true)
+	if err != nil {
+		c.Logger.Error("Failed to cordon nodes", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+
+	err = nodepoolController.SetScaleDownDisabledByAgentPool(ctx, nodepoolName, true)
+	if err != nil {
+		c.Logger.Error("Failed to disable autoscaler scale down for nodes", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+
+	safeToEvictPods, err := podController.GetSafeToEvictPods(ctx, r.safeEvict.Spec)
+	if err != nil {
+		c.Logger.Error("Failed to get safe-to-evict pods", zap.Error(err))
+		return false, err
+	}
+	//only pods which runs on the nodes of the nodepool
+	safeToEvictPods = filterPodsOnNodes(safeToEvictPods, nodes)
+	if r.target.selfExclusionController != nil {
+		safeToEvictPods = r.target.selfExclusionController.ExcludeOwnPod(safeToEvictPods)
+	}
+
+	pod.SortForEviction(safeToEvictPods, r.safeEvict.GetEvictionOrder())
+	safeToEvictPods, err = c.limitEvictions(ctx, r, nodepoolName, safeToEvictPods)
+	if err != nil {
+		return false, err
+	}
+
+	err = c.evictIdlePods(ctx, r, safeToEvictPods)
+	if err != nil {
+		c.Logger.Error("Failed to evict idle pods", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+
+	c.Logger.Debug("Checking for running stateful pods in the nodepool", zap.String("nodepoolName", nodepoolName), zap.Int("nodesCount", len(nodes)))
+	hasRunningPods, err := nodepoolController.HasRunningStatefulPods(ctx, nodes, r.safeEvict.Spec.Namespaces, r.safeEvict.Spec.BlockingPodSelector)
+	if err != nil {
+		c.Logger.Error("Error checking for running stateful pods in the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	if hasRunningPods {
+		c.Logger.Info("Nodepool still has running stateful pods", zap.String("nodepoolName", nodepoolName))
+		return false, nil
+	}
+
+	rescheduled, err := c.rescheduleControllerFrom(ctx, r.target, nodepoolName)
+	if err != nil {
+		return false, err
+	}
+	return !rescheduled, nil
+}
+
+// evictIdlePods evicts the idle pods and adds the pods which were evicted to the counters of the cluster
+func (c *SafeEvictReconciler) evictIdlePods(ctx context.Context, r *rotation, pods []corev1.Pod) error {
+	stats := metrics.StatsFrom(ctx)
+	evicted := stats.PodsEvicted.Load()
+	err := r.target.podController.EvictIdlePods(ctx, pods, r.safeEvict.Spec)
+	r.status.Counters.PodsEvicted += stats.PodsEvicted.Load() - evicted
+	return err
+}
+
+// checkPlacement simulates whether the agent pods on the nodes of an outdated nodepool could be scheduled on the
+// temporary nodepool, which takes them over once the nodes are cordoned. Their node selectors, required node affinities
+// and tolerations are compared with the labels and the taints of the temporary nodepool, the first pod which does not
+// fit is returned as errPlacementImpossible. The temporary nodepool itself is drained onto the upgraded nodepools.
+func (c *SafeEvictReconciler) checkPlacement(ctx context.Context, r *rotation, nodepoolName string, nodes []corev1.Node) error {
+	nodepoolController := r.target.nodepoolController
+	temporaryNodepoolName := r.temporaryNodepoolName()
+	if nodepoolName == temporaryNodepoolName {
+		return nil
+	}
+	agentPods, err := nodepoolController.GetPodsOnNodes(ctx, nodes, r.safeEvict.Spec.Namespaces)
+	if err != nil {
+		c.Logger.Error("Failed to get the pods of the nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return err
+	}
+	if len(agentPods) == 0 {
+		return nil
+	}
+	target, err := nodepoolController.GetPlacementNode(ctx, temporaryNodepoolName)
+	if err != nil {
+		c.Logger.Error("Failed to get the placement node of the temporary nodepool", zap.Error(err), zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return err
+	}
+	if target == nil {
+		c.Logger.Debug("Temporary nodepool does not exist, the placement of the agent pods is not checked", zap.String("temporaryNodepoolName", temporaryNodepoolName))
+		return nil
+	}
+	for _, agentPod := range agentPods {
+		if reason := nodepool.UnschedulableReason(agentPod, *target); reason != "" {
+			c.Logger.Info("Agent pod cannot be placed on the temporary nodepool, the nodepool is not drained", zap.String("nodepoolName", nodepoolName),
+				zap.String("pod", agentPod.Namespace+"/"+agentPod.Name), zap.String("temporaryNodepoolName", temporaryNodepoolName), zap.String("reason", reason))
+			return fmt.Errorf("%w: pod '%s/%s' of nodepool '%s' cannot be scheduled on temporary nodepool '%s', %s",
+				errPlacementImpossible, agentPod.Namespace, agentPod.Name, nodepoolName, temporaryNodepoolName, reason)
+		}
+	}
+	return nil
+}
+
+// drainNodes cordons the nodes of the nodepool and evicts their idle pods, keeping MinAvailableAgents. It returns true
+// once no stateful pod runs on them anymore.
+func (c *SafeEvictReconciler) drainNodes(ctx context.Context, r *rotation, nodepoolName string, nodes []corev1.Node) (bool, error) {
+	if len(nodes) == 0 {
+		return true, nil
+	}
+	podController := r.target.podController
+	nodepoolController := r.target.nodepoolController
+
+	for _, node := range nodes {
+		if err := nodepoolController.CordonNode(ctx, node); err != nil {
+			return false, err
+		}
+	}
+
+	safeToEvictPods, err := podController.GetSafeToEvictPods(ctx, r.safeEvict.Spec)
+	if err != nil {
+		c.Logger.Error("Failed to get safe-to-evict pods", zap.Error(err))
+		return false, err
+	}
+	safeToEvictPods = filterPodsOnNodes(safeToEvictPods, nodes)
+	if r.target.selfExclusionController != nil {
+		safeToEvictPods = r.target.selfExclusionController.ExcludeOwnPod(safeToEvictPods)
+	}
+	pod.SortForEviction(safeToEvictPods, r.safeEvict.GetEvictionOrder())
+	safeToEvictPods, err = c.limitEvictions(ctx, r, nodepoolName, safeToEvictPods)
+	if err != nil {
+		return false, err
+	}
+	if err := c.evictIdlePods(ctx, r, safeToEvictPods); err != nil {
+		c.Logger.Error("Failed to evict idle pods", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+
+	hasRunningPods, err := nodepoolController.HasRunningStatefulPods(ctx, nodes, r.safeEvict.Spec.Namespaces, r.safeEvict.Spec.BlockingPodSelector)
+	if err != nil {
+		c.Logger.Error("Error checking for running stateful pods on the nodes", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return false, err
+	}
+	if hasRunningPods {
+		c.Logger.Info("Nodes still have running stateful pods", zap.String("nodepoolName", nodepoolName), zap.Int("nodesCount", len(nodes)))
+		return false, nil
+	}
+	return true, nil
+}
+
+// drainNextNode rolls the nodepool one node at a time for the NodeReimage strategy. The node being reimaged is made
+// schedulable again once it is back with the latest node image, then the next outdated node is drained. It returns
+// the drained node which is ready to be reimaged, nil while a node is still drained or reimaged and when every node
+// runs the latest node image.
+func (c *SafeEvictReconciler) drainNextNode(ctx context.Context, r *rotation, agentPool armcontainerservice.AgentPool) (*corev1.Node, error) {
+	nodepoolController := r.target.nodepoolController
+	nodepoolName := *agentPool.Name
+
+	// the autoscaler must not add or remove nodes while they are rolled
+	autoScalingDisabled := nodepool.AutoScalingDisabled(agentPool)
+	err := nodepoolController.DisableAutoScaling(ctx, map[string]armcontainerservice.AgentPool{nodepoolName: agentPool})
+	if err != nil {
+		c.Logger.Error("Failed to disable auto-scaling for node pool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return nil, err
+	}
+	if !autoScalingDisabled {
+		c.Logger.Info("Waiting for the autoscaling of the nodepool to be disabled", zap.String("nodepoolName", nodepoolName))
+		return nil, nil
+	}
+	latestNodeImageVersion, err := nodepoolController.GetLatestNodeImageVersion(ctx, nodepoolName)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := nodepoolController.GetNodesByNodePool(ctx, nodepoolName)
+	if err != nil {
+		c.Logger.Error("Failed to get nodes by nodepool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return nil, err
+	}
+	slices.SortFunc(nodes, func(a, b corev1.Node) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	var outdated []corev1.Node
+	for _, node := range nodes {
+		if _, reimaging := node.Annotations[nodepool.ReimageStartedAnnotation]; reimaging {
+			finished, err := c.finishReimage(ctx, r, node, latestNodeImageVersion)
+			if err != nil || !finished {
+				return nil, err
+			}
+			continue
+		}
+		if node.Labels[nodepool.NodeImageVersionLabel] != latestNodeImageVersion {
+			outdated = append(outdated, node)
+		}
+	}
+	if len(outdated) == 0 {
+		c.Logger.Debug("Every node of the nodepool runs the latest node image", zap.String("nodepoolName", nodepoolName))
+		return nil, nil
+	}
+
+	next := outdated[0]
+	if err := c.checkPlacement(ctx, r, nodepoolName, []corev1.Node{next}); err != nil {
+		return nil, err
+	}
+	drained, err := c.drainNodes(ctx, r, nodepoolName, []corev1.Node{next})
+	if err != nil || !drained {
+		return nil, err
+	}
+	// only the next node is cordoned, a controller on another node of the nodepool stays where it is
+	rescheduled, err := c.rescheduleControllerFromNode(ctx, r.target, next.Name)
+	if err != nil || rescheduled {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// finishReimage makes the reimaged node schedulable again once its scale set instance is provisioned and the node is
+// ready with the latest node image. It returns false while the reimage is running.
+func (c *SafeEvictReconciler) finishReimage(ctx context.Context, r *rotation, node corev1.Node, latestNodeImageVersion string) (bool, error) {
+	nodepoolController := r.target.nodepoolController
+	provisioningState, err := nodepoolController.GetReimageProvisioningState(ctx, node)
+	if err != nil {
+		return false, err
+	}
+	if provisioningState.Failed() {
+		return false, fmt.Errorf("%w: the scale set instance of node '%s' is in provisioning state '%s' after its reimage", nodepool.ErrProvisioningFailed, node.Name, provisioningState)
+	}
+	if provisioningState != nodepool.ProvisioningStateSucceeded || !nodeReady(node) {
+		c.Logger.Info("Node is still being reimaged", zap.String("nodeName", node.Name), zap.String("provisioningState", string(provisioningState)))
+		return false, nil
+	}
+	if nodeImageVersion := node.Labels[nodepool.NodeImageVersionLabel]; nodeImageVersion != latestNodeImageVersion {
+		return false, fmt.Errorf("node '%s' runs node image version '%s' after its reimage instead of '%s', the scale set model of its nodepool has an older image",
+			node.Name, nodeImageVersion, latestNodeImageVersion)
+	}
+	c.Logger.Info("Node is reimaged with the latest node image", zap.String("nodeName", node.Name), zap.String("nodeImageVersion", latestNodeImageVersion))
+	return true, nodepoolController.FinishReimage(ctx, node)
+}
+
+// nodeReady returns true when the kubelet of the node reports it ready
+func nodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// nodeCount returns the number of nodes ARM reports for the nodepool
+func nodeCount(agentPool armcontainerservice.AgentPool) int32 {
+	if agentPool.Properties == nil || agentPool.Properties.Count == nil {
+		return 0
+	}
+	return *agentPool.Properties.Count
+}
+
+// limitEvictions returns the idle pods which can be evicted without the ready agents dropping below the
+// MinAvailableAgents of the SafeEvict. The ready agents are counted again for every nodepool, so the evictions of a
+// reconcile add up, and the remaining pods are evicted by a later reconcile once replacement agents are ready. None of
+// them is evicted while the pending pod watchdog paused the evictions.
+func (c *SafeEvictReconciler) limitEvictions(ctx context.Context, r *rotation, nodepoolName string, pods []corev1.Pod) ([]corev1.Pod, error) {
+	if r.evictionsPaused {
+		if len(pods) > 0 {
+			c.Logger.Info("Holding back evictions while agent pods are stuck Pending", zap.String("nodepoolName", nodepoolName), zap.Int("idlePods", len(pods)))
+		}
+		return nil, nil
+	}
+	minAvailable := int(r.safeEvict.Spec.MinAvailableAgents)
+	if minAvailable == 0 || len(pods) == 0 {
+		return pods, nil
+	}
+	ready, err := r.target.podController.CountReadyPods(ctx, r.safeEvict.Spec.Namespaces)
+	if err != nil {
+		c.Logger.Error("Failed to count the ready agents", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+		return nil, err
+	}
+	allowed := max(ready-minAvailable, 0)
+	if allowed < len(pods) {
+		c.Logger.Info("Holding back evictions until replacement agents are ready", zap.String("nodepoolName", nodepoolName),
+			zap.Int("readyAgents", ready), zap.Int("minAvailableAgents", minAvailable), zap.Int("idlePods", len(pods)), zap.Int("evictedPods", allowed))
+		return pods[:allowed], nil
+	}
+	return pods, nil
+}
+
+// waitIn keeps the rotation in the phase and checks it again after the success reconcile time
+func (c *SafeEvictReconciler) waitIn(phase updatev1.Phase) (updatev1.Phase, *ctrl.Result, error) {
+	return phase, &ctrl.Result{RequeueAfter: c.Config.Load().SuccessReconcileTime}, nil
+}
+
+// waitForStates keeps the rotation in the phase while ARM provisions nodepools in the provisioning states, it requeues
+// after the shortest requeue time of the states, so a nodepool which takes minutes to build is not polled every few
+// seconds
+func (c *SafeEvictReconciler) waitForStates(phase updatev1.Phase, states ...nodepool.ProvisioningState) (updatev1.Phase, *ctrl.Result, error) {
+	config := c.Config.Load()
+	if len(states) == 0 {
+		return c.waitIn(phase)
+	}
+	requeueAfter := config.RequeueTimeIn(string(states[0]))
+	for _, state := range states[1:] {
+		requeueAfter = min(requeueAfter, config.RequeueTimeIn(string(state)))
+	}
+	return phase, &ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// retryIn keeps the rotation in the phase and retries it after the error reconcile time
+func (c *SafeEvictReconciler) retryIn(phase updatev1.Phase) (updatev1.Phase, *ctrl.Result, error) {
+	return phase, &ctrl.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, nil
+}
+
+// backOffIn keeps the rotation in the phase and retries it after the backoff of a refused change
+func (c *SafeEvictReconciler) backOffIn(phase updatev1.Phase, backoff time.Duration) (updatev1.Phase, *ctrl.Result, error) {
+	c.Logger.Info("ARM refused a change with a conflict, backing off", zap.String("phase", string(phase)), zap.Duration("backoff", backoff))
+	return phase, &ctrl.Result{RequeueAfter: backoff}, nil
+}
+
+// conflictBackoff records that ARM refused a change of the nodepool with a conflict and returns how long to wait
+// before the change is retried, the wait doubles with every consecutive conflict of the nodepool
+func (c *SafeEvictReconciler) conflictBackoff(r *rotation, nodepoolName string, err error) time.Duration {
+	conflicts := r.status.RecordConflict(nodepoolName, err.Error())
+	r.status.SetNodepoolError(nodepoolName, nodepoolError(err))
+	c.Logger.Warn("Change of nodepool conflicts with a running operation", zap.Error(err), zap.String("nodepoolName", nodepoolName), zap.Int32("conflicts", conflicts))
+	return min(c.Config.Load().ErrorReconcileTime<<min(conflicts-1, 10), maxConflictBackoff)
+}
+
+// failIn keeps the rotation in the phase and returns the error
+func (c *SafeEvictReconciler) failIn(phase updatev1.Phase, err error) (updatev1.Phase, *ctrl.Result, error) {
+	return phase, &ctrl.Result{RequeueAfter: c.Config.Load().ErrorReconcileTime}, err
+}
@@ -0,0 +1,2446 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/appconfig"
+	"norbinto/node-updater/internal/azuredevops"
+	"norbinto/node-updater/internal/configmap"
+	"norbinto/node-updater/internal/job"
+	"norbinto/node-updater/internal/metrics"
+	"norbinto/node-updater/internal/nodepool"
+	"norbinto/node-updater/internal/pod"
+	"norbinto/node-updater/internal/selfexclusion"
+	"norbinto/node-updater/internal/utilization"
+	"norbinto/node-updater/pkg/notify"
+	"norbinto/node-updater/pkg/plan"
+	"norbinto/node-updater/pkg/testing/fake"
+)
+
+const (
+	testNodepoolName    = "agentpool"
+	testAgentNamespace  = "agents"
+	testOldNodeImage    = "AKSUbuntu-2204gen2containerd-202501.01.0"
+	testLatestNodeImage = "AKSUbuntu-2204gen2containerd-202502.01.0"
+	nodeImageLabel      = "kubernetes.azure.com/node-image-version"
+)
+
+type phaseFixture struct {
+	reconciler      *SafeEvictReconciler
+	kubeClient      *kubefake.Clientset
+	agentPoolClient *fake.AgentPoolClient
+	clock           *testingclock.FakePassiveClock
+	safeEvict       *updatev1.SafeEvict
+	target          *clusterTarget
+	status          updatev1.RotationStatus
+}
+
+// newPhaseFixture returns a cluster with one outdated nodepool of a single node, the fake clientset answers every log
+// request with "fake logs", so running pods without the busy label are idle
+func newPhaseFixture(t *testing.T) *phaseFixture {
+	logger := zaptest.NewLogger(t)
+	kubeClient := kubefake.NewSimpleClientset()
+	agentPoolClient, err := fake.NewAgentPoolClient(testLatestNodeImage)
+	if err != nil {
+		t.Fatalf("NewAgentPoolClient returned error: %v", err)
+	}
+	fakeClock := testingclock.NewFakePassiveClock(time.Now())
+	agentPoolClient.Clock = fakeClock
+	agentPoolClient.AddAgentPool(testNodepoolName, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		Count:             to.Ptr(int32(2)),
+		EnableAutoScaling: to.Ptr(false),
+		Mode:              to.Ptr(armcontainerservice.AgentPoolModeUser),
+		NodeImageVersion:  to.Ptr(testOldNodeImage),
+	})
+	agentPoolClient.OnCreate = func(name string) {
+		createNode(t, kubeClient, name+"-0", name, testLatestNodeImage)
+	}
+	agentPoolClient.OnUpgrade = func(name, nodeImageVersion string) {
+		nodeList, _ := kubeClient.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: "agentpool=" + name})
+		for _, node := range nodeList.Items {
+			node.Labels[nodeImageLabel] = nodeImageVersion
+			_, _ = kubeClient.CoreV1().Nodes().Update(context.Background(), &node, metav1.UpdateOptions{})
+		}
+	}
+	createNode(t, kubeClient, testNodepoolName+"-0", testNodepoolName, testOldNodeImage)
+
+	safeEvict := &updatev1.SafeEvict{
+		ObjectMeta: metav1.ObjectMeta{Name: "rotation", Namespace: "default", UID: types.UID("uid")},
+		Spec: updatev1.SafeEvictSpec{
+			LabelSelector:     map[string]string{"busy": "true"},
+			LastLogLines:      []string{"fake logs"},
+			Nodepools:         []string{testNodepoolName},
+			Namespaces:        []string{testAgentNamespace},
+			BaseForBackupPool: testNodepoolName,
+			AgentProvider:     updatev1.AgentProviderNone,
+		},
+	}
+
+	podController := pod.NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger.Named("job")), time.Second, logger.Named("pod"))
+	nodepoolController := nodepool.NewNodePoolController(kubeClient, agentPoolClient, fake.SubscriptionID, "rg", "cluster", logger.Named("nodepool"))
+	return &phaseFixture{
+		reconciler: &SafeEvictReconciler{
+			KubeClient:          kubeClient,
+			PodController:       podController,
+			NodepoolController:  nodepoolController,
+			ConfigmapController: configmap.NewConfigMapController(kubeClient, logger.Named("configmap")),
+			Config:              appconfig.NewStore(appconfig.NewConfig(time.Second, 2*time.Second, time.Hour)),
+			Logger:              logger.Named("safeEvict"),
+		},
+		kubeClient:      kubeClient,
+		agentPoolClient: agentPoolClient,
+		clock:           fakeClock,
+		safeEvict:       safeEvict,
+		target: &clusterTarget{
+			podController:      podController,
+			nodepoolController: nodepoolController,
+			configmapName:      safeEvict.GetConfigmapName(),
+			configmapNamespace: safeEvict.Namespace,
+			usageConfigmapName: safeEvict.GetUsageConfigmapName(),
+		},
+	}
+}
+
+func createNode(t *testing.T, kubeClient *kubefake.Clientset, name, agentPool, nodeImageVersion string) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{"agentpool": agentPool, nodeImageLabel: nodeImageVersion},
+	}}
+	if _, err := kubeClient.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+}
+
+// createPod creates a running agent pod owned by a job of the same name
+func (f *phaseFixture) createPod(t *testing.T, name, nodeName string, labels map[string]string) {
+	agentJob := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testAgentNamespace}}
+	if _, err := f.kubeClient.BatchV1().Jobs(testAgentNamespace).Create(context.Background(), agentJob, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	agentPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       testAgentNamespace,
+			UID:             types.UID(name),
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: name}},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if _, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Create(context.Background(), agentPod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+}
+
+func (f *phaseFixture) saveScaling(t *testing.T, data map[string]string) {
+	if err := f.reconciler.ConfigmapController.CreateConfigMap(context.Background(), f.safeEvict.Namespace, f.target.configmapName, data, f.safeEvict); err != nil {
+		t.Fatalf("failed to create ConfigMap: %v", err)
+	}
+}
+
+// runPhase observes the cluster and runs a single phase on it
+func (f *phaseFixture) runPhase(t *testing.T, step phaseStep) (updatev1.Phase, *ctrl.Result) {
+	phase, result, err := f.runFailingPhase(t, step)
+	if err != nil {
+		t.Fatalf("phase returned error: %v", err)
+	}
+	return phase, result
+}
+
+// runFailingPhase observes the cluster and runs a single phase on it, the error of the phase is returned
+func (f *phaseFixture) runFailingPhase(t *testing.T, step phaseStep) (updatev1.Phase, *ctrl.Result, error) {
+	ctx, _ := metrics.WithReconcileStats(context.Background())
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: f.safeEvict.Name, Namespace: f.safeEvict.Namespace}}
+	r, result, err := f.reconciler.observeRotation(ctx, req, f.safeEvict, f.target, &f.status)
+	if err != nil || result != nil {
+		t.Fatalf("observeRotation returned %v, %v", result, err)
+	}
+	return step(ctx, r)
+}
+
+// addOutdatedNodepool adds a second outdated nodepool with a single node to the rotation
+func (f *phaseFixture) addOutdatedNodepool(t *testing.T, name string, count int32) {
+	f.agentPoolClient.AddAgentPool(name, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		Count:             to.Ptr(count),
+		EnableAutoScaling: to.Ptr(false),
+		Mode:              to.Ptr(armcontainerservice.AgentPoolModeUser),
+		NodeImageVersion:  to.Ptr(testOldNodeImage),
+	})
+	createNode(t, f.kubeClient, name+"-0", name, testOldNodeImage)
+	f.safeEvict.Spec.Nodepools = append(f.safeEvict.Spec.Nodepools, name)
+}
+
+func expectNodepoolState(t *testing.T, status updatev1.RotationStatus, nodepoolName string, expectedState updatev1.NodepoolState) {
+	t.Helper()
+	for _, nodepoolStatus := range status.Pools {
+		if nodepoolStatus.Name == nodepoolName {
+			if nodepoolStatus.State != expectedState {
+				t.Errorf("expected nodepool '%s' to be %s, got %s (%s)", nodepoolName, expectedState, nodepoolStatus.State, nodepoolStatus.Message)
+			}
+			return
+		}
+	}
+	t.Errorf("expected nodepool '%s' to be %s, it has no status", nodepoolName, expectedState)
+}
+
+func expectLastError(t *testing.T, status updatev1.RotationStatus, nodepoolName string, expectedCategory updatev1.ErrorCategory, expectedRetryable bool) {
+	t.Helper()
+	for _, nodepoolStatus := range status.Pools {
+		if nodepoolStatus.Name != nodepoolName {
+			continue
+		}
+		lastError := nodepoolStatus.LastError
+		if lastError == nil {
+			t.Errorf("expected nodepool '%s' to record its last error", nodepoolName)
+			return
+		}
+		if lastError.Category != expectedCategory || lastError.Retryable != expectedRetryable {
+			t.Errorf("expected the last error of nodepool '%s' to be %s with retryable %t, got %s with retryable %t (%s)", nodepoolName, expectedCategory, expectedRetryable, lastError.Category, lastError.Retryable, lastError.Message)
+		}
+		if lastError.Time.IsZero() || lastError.Message == "" {
+			t.Errorf("expected the last error of nodepool '%s' to have a time and a message, got %+v", nodepoolName, lastError)
+		}
+		return
+	}
+	t.Errorf("expected nodepool '%s' to record its last error, it has no status", nodepoolName)
+}
+
+func (f *phaseFixture) getNode(t *testing.T, name string) *corev1.Node {
+	node, err := f.kubeClient.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	return node
+}
+
+func expectPhase(t *testing.T, phase updatev1.Phase, result *ctrl.Result, expectedPhase updatev1.Phase, expectWait bool) {
+	t.Helper()
+	if phase != expectedPhase {
+		t.Errorf("expected phase %s, got %s", expectedPhase, phase)
+	}
+	if expectWait && result == nil {
+		t.Error("expected the phase to wait for a requeue")
+	}
+	if !expectWait && result != nil {
+		t.Errorf("expected the phase to continue, got requeue after %v", result.RequeueAfter)
+	}
+}
+
+func TestDetect_UpToDateClusterWaitsForNextCheck(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.agentPoolClient.OnUpgrade(testNodepoolName, testLatestNodeImage)
+	f.agentPoolClient.AddAgentPool(testNodepoolName, armcontainerservice.ManagedClusterAgentPoolProfileProperties{NodeImageVersion: to.Ptr(testLatestNodeImage)})
+
+	phase, result := f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if result.RequeueAfter != time.Hour {
+		t.Errorf("expected requeue after the upgrade frequency, got %v", result.RequeueAfter)
+	}
+}
+
+func TestDetect_OutdatedNodepoolStartsRotation(t *testing.T) {
+	f := newPhaseFixture(t)
+
+	phase, result := f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseProvisioningBackup, false)
+}
+
+func TestObserveRotation_CountsCheckedPools(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.addOutdatedNodepool(t, "userpool", 1)
+	f.safeEvict.Spec.Nodepools = append(f.safeEvict.Spec.Nodepools, "uptodate")
+	f.agentPoolClient.AddAgentPool("uptodate", armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		Count:            to.Ptr(int32(1)),
+		NodeImageVersion: to.Ptr(testLatestNodeImage),
+	})
+	ctx, stats := metrics.WithReconcileStats(context.Background())
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: f.safeEvict.Name, Namespace: f.safeEvict.Namespace}}
+
+	if _, result, err := f.reconciler.observeRotation(ctx, req, f.safeEvict, f.target, &f.status); result != nil || err != nil {
+		t.Fatalf("observeRotation returned %v, %v", result, err)
+	}
+
+	if stats.PoolsChecked.Load() != 3 || stats.OutdatedPools.Load() != 2 {
+		t.Errorf("expected 2 of 3 nodepools to be outdated, got %d of %d", stats.OutdatedPools.Load(), stats.PoolsChecked.Load())
+	}
+}
+
+func TestObserveRotation_LeavesOutMissingNodepools(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Spec.Nodepools = append(f.safeEvict.Spec.Nodepools, "deleted")
+	f.safeEvict.Spec.PruneMissingNodepools = true
+	f.status.SetNodepoolState("deleted", updatev1.NodepoolStateFailed, "node pool not found")
+	ctx, stats := metrics.WithReconcileStats(context.Background())
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: f.safeEvict.Name, Namespace: f.safeEvict.Namespace}}
+
+	r, result, err := f.reconciler.observeRotation(ctx, req, f.safeEvict, f.target, &f.status)
+	if result != nil || err != nil {
+		t.Fatalf("observeRotation returned %v, %v", result, err)
+	}
+
+	if !slices.Equal(r.nodepools, []string{testNodepoolName}) || stats.PoolsChecked.Load() != 1 {
+		t.Errorf("expected only '%s' to be checked, got %v", testNodepoolName, r.nodepools)
+	}
+	if !meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionNodepoolsMissing) {
+		t.Errorf("expected the NodepoolsMissing condition to be true, got %v", f.status.Conditions)
+	}
+	if f.status.GetNodepoolState("deleted") != "" {
+		t.Errorf("expected the status of the missing nodepool to be pruned, got %v", f.status.Pools)
+	}
+
+	f.safeEvict.Spec.Nodepools = []string{testNodepoolName}
+	if _, result, err := f.reconciler.observeRotation(ctx, req, f.safeEvict, f.target, &f.status); result != nil || err != nil {
+		t.Fatalf("observeRotation returned %v, %v", result, err)
+	}
+	if condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionNodepoolsMissing); condition == nil || condition.Status != metav1.ConditionFalse {
+		t.Errorf("expected the NodepoolsMissing condition to be false, got %v", condition)
+	}
+}
+
+func TestDetect_SkipsSystemNodepools(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          updatev1.SystemNodepoolPolicy
+		otherSystemNode bool
+		expectSkipped   bool
+		expectedReason  string
+	}{
+		{name: "rotated next to another system node", otherSystemNode: true},
+		{name: "only system node", expectSkipped: true, expectedReason: updatev1.ReasonNoOtherSystemNode},
+		{name: "skipped by spec", policy: updatev1.SystemNodepoolPolicySkip, otherSystemNode: true, expectSkipped: true, expectedReason: updatev1.ReasonSkippedBySpec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPhaseFixture(t)
+			f.safeEvict.Spec.SystemNodepools = tt.policy
+			f.agentPoolClient.AddAgentPool("system", armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				Count:             to.Ptr(int32(1)),
+				EnableAutoScaling: to.Ptr(true),
+				Mode:              to.Ptr(armcontainerservice.AgentPoolModeSystem),
+				NodeImageVersion:  to.Ptr(testOldNodeImage),
+			})
+			f.safeEvict.Spec.Nodepools = append(f.safeEvict.Spec.Nodepools, "system")
+			createSystemNode(t, f.kubeClient, "system-0", "system")
+			if tt.otherSystemNode {
+				createSystemNode(t, f.kubeClient, "extra-0", "extra")
+			}
+
+			phase, result := f.runPhase(t, f.reconciler.detect)
+
+			expectPhase(t, phase, result, updatev1.PhaseProvisioningBackup, false)
+			expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateInProgress)
+			if tt.expectSkipped {
+				expectNodepoolState(t, f.status, "system", updatev1.NodepoolStateSkipped)
+			} else {
+				expectNodepoolState(t, f.status, "system", updatev1.NodepoolStateInProgress)
+			}
+			// the condition is only added once a system nodepool was skipped
+			condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionSystemNodepoolsSkipped)
+			if tt.expectSkipped && (condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != tt.expectedReason) {
+				t.Errorf("expected the SystemNodepoolsSkipped condition with reason %s, got %v", tt.expectedReason, condition)
+			}
+			if !tt.expectSkipped && condition != nil {
+				t.Errorf("expected no SystemNodepoolsSkipped condition, got %v", condition)
+			}
+
+			// the skipped system nodepool is not drained while the rotation is in progress
+			f.status.Phase = updatev1.PhaseDraining
+			ctx, _ := metrics.WithReconcileStats(context.Background())
+			r, _, err := f.reconciler.observeRotation(ctx, ctrl.Request{}, f.safeEvict, f.target, &f.status)
+			if err != nil {
+				t.Fatalf("observeRotation returned error: %v", err)
+			}
+			if _, rotated := r.outdatedNodePools["system"]; rotated == tt.expectSkipped {
+				t.Errorf("expected the system nodepool to be rotated %t, got %v", !tt.expectSkipped, slices.Sorted(maps.Keys(r.outdatedNodePools)))
+			}
+		})
+	}
+}
+
+func TestDetect_OnlySkippedSystemNodepoolsAreUpToDate(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.agentPoolClient.AddAgentPool(testNodepoolName, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		Mode:             to.Ptr(armcontainerservice.AgentPoolModeSystem),
+		NodeImageVersion: to.Ptr(testOldNodeImage),
+	})
+	f.safeEvict.Spec.SystemNodepools = updatev1.SystemNodepoolPolicySkip
+
+	phase, result := f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if !meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionSystemNodepoolsSkipped) {
+		t.Errorf("expected the SystemNodepoolsSkipped condition to be true, got %v", f.status.Conditions)
+	}
+}
+
+// createSystemNode creates a ready node of a system nodepool
+func createSystemNode(t *testing.T, kubeClient *kubefake.Clientset, name, agentPool string) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"agentpool": agentPool, nodeImageLabel: testOldNodeImage, nodepool.AKSModeLabel: "system"},
+		},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	}
+	if _, err := kubeClient.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+}
+
+func TestProvisionBackup_WaitsWhileTemporaryNodepoolIsCreating(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+	config := f.reconciler.Config.Load().WithProvisioningRequeueTimes(map[string]time.Duration{string(nodepool.ProvisioningStateCreating): 90 * time.Second})
+	f.reconciler.Config.Update(&config)
+
+	phase, result := f.runPhase(t, f.reconciler.provisionBackup)
+
+	expectPhase(t, phase, result, updatev1.PhaseProvisioningBackup, true)
+	if result.RequeueAfter != 90*time.Second {
+		t.Errorf("expected requeue after the requeue time of Creating, got %v", result.RequeueAfter)
+	}
+	if f.agentPoolClient.AgentPool(f.safeEvict.GetTemporaryNodepoolName()) == nil {
+		t.Error("expected the temporary nodepool to be created")
+	}
+	if _, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.target.configmapName); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the scaling not to be saved before the temporary nodepool is ready, got %v", err)
+	}
+}
+
+func TestProvisionBackup_SavesScalingOnceTemporaryNodepoolIsReady(t *testing.T) {
+	f := newPhaseFixture(t)
+
+	phase, result := f.runPhase(t, f.reconciler.provisionBackup)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, false)
+	data, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.target.configmapName)
+	if err != nil {
+		t.Fatalf("expected the scaling to be saved, got %v", err)
+	}
+	if data[testNodepoolName] != `{"Version":2,"EnableAutoScaling":false,"Count":2}` {
+		t.Errorf("expected the saved scaling of the nodepool, got %v", data)
+	}
+	if data[scalingChecksumKey] != scalingChecksum(data) {
+		t.Errorf("expected the saved scaling to match its checksum, got %v", data)
+	}
+}
+
+func TestProvisionBackup_AddsExtraNodeLabelsAndTaints(t *testing.T) {
+	f := newPhaseFixture(t)
+	baseProperties := *f.agentPoolClient.AgentPool(testNodepoolName).Properties
+	baseProperties.NodeLabels = map[string]*string{"pool": to.Ptr(testNodepoolName), "team": to.Ptr("ci")}
+	baseProperties.NodeTaints = []*string{to.Ptr("dedicated=agents:NoSchedule"), to.Ptr("spot=true:NoExecute")}
+	f.agentPoolClient.AddAgentPool(testNodepoolName, baseProperties)
+	f.safeEvict.Spec.BackupPool = &updatev1.BackupPoolSpec{
+		ExtraNodeLabels: map[string]string{"pool": updatev1.SubstitutionTemporaryPool, "replaces": updatev1.SubstitutionBasePool},
+		ExtraNodeTaints: []string{"dedicated=" + updatev1.SubstitutionTemporaryPool + ":NoSchedule"},
+	}
+
+	phase, result := f.runPhase(t, f.reconciler.provisionBackup)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, false)
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+	properties := f.agentPoolClient.AgentPool(temporaryNodepoolName).Properties
+	nodeLabels := map[string]string{}
+	for key, value := range properties.NodeLabels {
+		nodeLabels[key] = *value
+	}
+	expectedLabels := map[string]string{"pool": temporaryNodepoolName, "team": "ci", "replaces": testNodepoolName}
+	if !maps.Equal(nodeLabels, expectedLabels) {
+		t.Errorf("expected node labels %v, got %v", expectedLabels, nodeLabels)
+	}
+	var nodeTaints []string
+	for _, nodeTaint := range properties.NodeTaints {
+		nodeTaints = append(nodeTaints, *nodeTaint)
+	}
+	expectedTaints := []string{"spot=true:NoExecute", "dedicated=" + temporaryNodepoolName + ":NoSchedule"}
+	if !slices.Equal(nodeTaints, expectedTaints) {
+		t.Errorf("expected node taints %v, got %v", expectedTaints, nodeTaints)
+	}
+	if baseLabels := f.agentPoolClient.AgentPool(testNodepoolName).Properties.NodeLabels; len(baseLabels) != 2 || *baseLabels["pool"] != testNodepoolName {
+		t.Errorf("expected the labels of the base pool to be left alone, got %v", baseLabels)
+	}
+}
+
+func TestProvisionBackup_RightSizesTemporaryNodepool(t *testing.T) {
+	f := newPhaseFixture(t)
+	baseProperties := *f.agentPoolClient.AgentPool(testNodepoolName).Properties
+	baseProperties.VMSize = to.Ptr("Standard_D4s_v5")
+	baseProperties.EnableAutoScaling = to.Ptr(true)
+	baseProperties.MinCount = to.Ptr(int32(1))
+	baseProperties.MaxCount = to.Ptr(int32(2))
+	f.agentPoolClient.AddAgentPool(testNodepoolName, baseProperties)
+	node := f.getNode(t, testNodepoolName+"-0")
+	node.Labels[corev1.LabelInstanceTypeStable] = "Standard_D4s_v5"
+	node.Status.Allocatable = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4"), corev1.ResourceMemory: resource.MustParse("16Gi")}
+	if _, err := f.kubeClient.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update node: %v", err)
+	}
+	for i := range 5 {
+		name := fmt.Sprintf("agent-%d", i)
+		f.createPod(t, name, testNodepoolName+"-0", map[string]string{"busy": "true"})
+		agentPod, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get pod: %v", err)
+		}
+		agentPod.Spec.Containers = []corev1.Container{{Name: "agent", Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+		}}}
+		if _, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Update(context.Background(), agentPod, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update pod: %v", err)
+		}
+	}
+	f.safeEvict.Spec.BackupPool = &updatev1.BackupPoolSpec{RightSizing: &updatev1.RightSizingSpec{}}
+
+	f.runPhase(t, f.reconciler.provisionBackup)
+
+	// 10 CPUs with 20% headroom need 3 nodes of 4 CPUs, the autoscaler of the clone is widened to allow them
+	properties := f.agentPoolClient.AgentPool(f.safeEvict.GetTemporaryNodepoolName()).Properties
+	if *properties.Count != 3 || *properties.MinCount != 1 || *properties.MaxCount != 3 {
+		t.Errorf("expected 3 nodes within 1-3, got %d within %d-%d", *properties.Count, *properties.MinCount, *properties.MaxCount)
+	}
+}
+
+// useArm64Nodepool moves the outdated nodepool to arm64 VMs
+func (f *phaseFixture) useArm64Nodepool(t *testing.T) {
+	f.agentPoolClient.AgentPool(testNodepoolName).Properties.VMSize = to.Ptr("Standard_D4ps_v5")
+	node := f.getNode(t, testNodepoolName+"-0")
+	node.Labels[corev1.LabelArchStable] = "arm64"
+	if _, err := f.kubeClient.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update node: %v", err)
+	}
+}
+
+func TestProvisionBackup_ClonesVMSizeOverrideOfSameArchitecture(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.useArm64Nodepool(t)
+	f.safeEvict.Spec.BackupPool = &updatev1.BackupPoolSpec{VMSize: "Standard_D8pds_v5"}
+
+	phase, result := f.runPhase(t, f.reconciler.provisionBackup)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, false)
+	temporaryNodepool := f.agentPoolClient.AgentPool(f.safeEvict.GetTemporaryNodepoolName())
+	if temporaryNodepool == nil {
+		t.Fatal("expected the temporary nodepool to be created")
+	}
+	if vmSize := *temporaryNodepool.Properties.VMSize; vmSize != "Standard_D8pds_v5" {
+		t.Errorf("expected the VM size override, got %s", vmSize)
+	}
+}
+
+func TestProvisionBackup_FailsOnVMSizeOfOtherArchitecture(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.useArm64Nodepool(t)
+	f.safeEvict.Spec.BackupPool = &updatev1.BackupPoolSpec{VMSize: "Standard_D8ds_v5"}
+
+	phase, result, err := f.runFailingPhase(t, f.reconciler.provisionBackup)
+
+	if !errors.Is(err, nodepool.ErrArchitectureMismatch) {
+		t.Fatalf("expected an architecture mismatch, got %v", err)
+	}
+	expectPhase(t, phase, result, updatev1.PhaseProvisioningBackup, true)
+	if f.agentPoolClient.AgentPool(f.safeEvict.GetTemporaryNodepoolName()) != nil {
+		t.Error("expected no temporary nodepool of another architecture")
+	}
+}
+
+func TestGetVMSizeArchitecture(t *testing.T) {
+	for vmSize, expected := range map[string]string{
+		"Standard_D4ps_v5":     "arm64",
+		"Standard_E16pds_v5":   "arm64",
+		"Standard_D2plds_v6":   "arm64",
+		"Standard_D4s_v5":      "amd64",
+		"Standard_D4ads_v5":    "amd64",
+		"Standard_NP10s":       "amd64",
+		"Standard_M8-2ms":      "amd64",
+		"Standard_NC4as_T4_v3": "amd64",
+	} {
+		if architecture := nodepool.GetVMSizeArchitecture(vmSize); architecture != expected {
+			t.Errorf("expected %s to be %s, got %s", vmSize, expected, architecture)
+		}
+	}
+}
+
+func TestDrain_WaitsForBusyPods(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.createPod(t, "busy-agent", testNodepoolName+"-0", map[string]string{"busy": "true"})
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if !f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the outdated node to be cordoned")
+	}
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 0 {
+		t.Error("expected no upgrade while a busy pod is running")
+	}
+}
+
+func TestDrain_EvictsIdlePodsAndUpgradesDrainedNodepool(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+	f.createPod(t, "idle-agent", testNodepoolName+"-0", nil)
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if _, err := f.kubeClient.BatchV1().Jobs(testAgentNamespace).Get(context.Background(), "idle-agent", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the job of the idle pod to be killed, got %v", err)
+	}
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 1 {
+		t.Errorf("expected the drained nodepool to be upgraded once, got %d", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+
+	phase, result = f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseUpgrading, false)
+}
+
+func TestDrain_CountsTerminatingPodsAsDrained(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.createPod(t, "terminating-agent", testNodepoolName+"-0", map[string]string{"busy": "true"})
+	agentPod, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Get(context.Background(), "terminating-agent", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	agentPod.DeletionTimestamp = &metav1.Time{Time: f.clock.Now()}
+	if _, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Update(context.Background(), agentPod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	f.runPhase(t, f.reconciler.drain)
+
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 1 {
+		t.Errorf("expected the nodepool to be upgraded while its last pod terminates, got %d upgrades", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+}
+
+func TestDrain_KeepsNodepoolUncordonedWhilePlacementIsImpossible(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.runPhase(t, f.reconciler.provisionBackup)
+	f.createPod(t, "idle-agent", testNodepoolName+"-0", nil)
+	agentPod, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Get(context.Background(), "idle-agent", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	agentPod.Spec.NodeSelector = map[string]string{"team": "ci"}
+	if _, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Update(context.Background(), agentPod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the nodepool not to be cordoned while its pods cannot be placed")
+	}
+	if _, err := f.kubeClient.BatchV1().Jobs(testAgentNamespace).Get(context.Background(), "idle-agent", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the idle pod to be kept, got %v", err)
+	}
+	condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionPlacementImpossible)
+	if condition == nil || condition.Status != metav1.ConditionTrue || !strings.Contains(condition.Message, "node selector team=ci") {
+		t.Fatalf("expected the impossible placement to be reported, got %v", f.status.Conditions)
+	}
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateInProgress)
+
+	temporaryNode := f.getNode(t, f.safeEvict.GetTemporaryNodepoolName()+"-0")
+	temporaryNode.Labels["team"] = "ci"
+	if _, err := f.kubeClient.CoreV1().Nodes().Update(context.Background(), temporaryNode, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update node: %v", err)
+	}
+	f.runPhase(t, f.reconciler.drain)
+
+	if !f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the nodepool to be cordoned once its pods can be placed")
+	}
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 1 {
+		t.Errorf("expected the drained nodepool to be upgraded once, got %d", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+	if meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionPlacementImpossible) {
+		t.Error("expected the impossible placement to be cleared")
+	}
+}
+
+// createPendingPod creates an agent pod which the scheduler could not place since pendingSince
+func (f *phaseFixture) createPendingPod(t *testing.T, name string, pendingSince time.Time) {
+	pendingPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testAgentNamespace, UID: types.UID(name)},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			Conditions: []corev1.PodCondition{{
+				Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable, LastTransitionTime: metav1.NewTime(pendingSince),
+			}},
+		},
+	}
+	if _, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Create(context.Background(), pendingPod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+}
+
+func TestDrain_PausesEvictionsWhilePodsAreStuckPending(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Spec.PendingPodWatchdog = &updatev1.PendingPodWatchdogSpec{Action: updatev1.PendingPodActionPauseEvictions}
+	f.createPod(t, "idle-agent", testNodepoolName+"-0", nil)
+	f.createPendingPod(t, "recent-agent", time.Now())
+	f.createPendingPod(t, "stuck-agent", time.Now().Add(-updatev1.DefaultPendingTimeout))
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if _, err := f.kubeClient.BatchV1().Jobs(testAgentNamespace).Get(context.Background(), "idle-agent", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the idle pod to be kept while evictions are paused, got %v", err)
+	}
+	condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionPodsPending)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != updatev1.ReasonEvictionsPaused ||
+		!strings.Contains(condition.Message, "stuck-agent") || strings.Contains(condition.Message, "recent-agent") {
+		t.Fatalf("expected the paused evictions to be reported, got %v", f.status.Conditions)
+	}
+
+	if err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Delete(context.Background(), "stuck-agent", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+	f.runPhase(t, f.reconciler.drain)
+
+	if _, err := f.kubeClient.BatchV1().Jobs(testAgentNamespace).Get(context.Background(), "idle-agent", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the idle pod to be evicted once no pod is stuck, got %v", err)
+	}
+	if condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionPodsPending); condition.Status != metav1.ConditionFalse {
+		t.Errorf("expected the stuck pods to be cleared, got %s", condition.Status)
+	}
+}
+
+func TestDrain_ScalesUpTemporaryNodepoolWhilePodsAreStuckPending(t *testing.T) {
+	f := newPhaseFixture(t)
+	maxBackupPoolCount := int32(3)
+	f.safeEvict.Spec.PendingPodWatchdog = &updatev1.PendingPodWatchdogSpec{
+		Action:             updatev1.PendingPodActionScaleUpBackupPool,
+		MaxBackupPoolCount: &maxBackupPoolCount,
+	}
+	f.runPhase(t, f.reconciler.provisionBackup)
+	f.createPendingPod(t, "stuck-agent", time.Now().Add(-time.Hour))
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+
+	f.runPhase(t, f.reconciler.drain)
+
+	if count := *f.agentPoolClient.AgentPool(temporaryNodepoolName).Properties.Count; count != 3 {
+		t.Errorf("expected the temporary nodepool to be scaled up to 3 nodes, got %d", count)
+	}
+	if condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionPodsPending); condition == nil || condition.Reason != updatev1.ReasonBackupPoolScaledUp {
+		t.Fatalf("expected the scale up to be reported, got %v", f.status.Conditions)
+	}
+
+	f.createPod(t, "idle-agent", testNodepoolName+"-0", nil)
+	f.runPhase(t, f.reconciler.drain)
+
+	if count := *f.agentPoolClient.AgentPool(temporaryNodepoolName).Properties.Count; count != 3 {
+		t.Errorf("expected the temporary nodepool to stay at its maximum count, got %d", count)
+	}
+	if condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionPodsPending); condition.Reason != updatev1.ReasonEvictionsPaused {
+		t.Errorf("expected the evictions to be paused at the maximum count, got %s", condition.Reason)
+	}
+	if _, err := f.kubeClient.BatchV1().Jobs(testAgentNamespace).Get(context.Background(), "idle-agent", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the idle pod to be kept while evictions are paused, got %v", err)
+	}
+}
+
+func TestAwaitUpgrade(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+
+	phase, result := f.runPhase(t, f.reconciler.awaitUpgrade)
+	expectPhase(t, phase, result, updatev1.PhaseDraining, false)
+
+	if _, err := f.agentPoolClient.BeginUpgradeNodeImageVersion(context.Background(), "rg", "cluster", testNodepoolName, nil); err != nil {
+		t.Fatalf("BeginUpgradeNodeImageVersion returned error: %v", err)
+	}
+	phase, result = f.runPhase(t, f.reconciler.awaitUpgrade)
+	expectPhase(t, phase, result, updatev1.PhaseUpgrading, true)
+
+	f.clock.SetTime(f.clock.Now().Add(time.Minute))
+	phase, result = f.runPhase(t, f.reconciler.awaitUpgrade)
+	expectPhase(t, phase, result, updatev1.PhaseRestoring, false)
+}
+
+func TestAwaitUpgrade_ReplacesStragglerNodes(t *testing.T) {
+	f := newPhaseFixture(t)
+	// the nodepool is upgraded, but one of its nodes still runs the old node image
+	f.agentPoolClient.AddAgentPool(testNodepoolName, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		Count:            to.Ptr(int32(2)),
+		NodeImageVersion: to.Ptr(testLatestNodeImage),
+	})
+	createNode(t, f.kubeClient, testNodepoolName+"-1", testNodepoolName, testLatestNodeImage)
+	f.createPod(t, "busy-agent", testNodepoolName+"-0", map[string]string{"busy": "true"})
+
+	phase, result := f.runPhase(t, f.reconciler.awaitUpgrade)
+
+	expectPhase(t, phase, result, updatev1.PhaseUpgrading, true)
+	if !f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the straggler node to be cordoned")
+	}
+	if f.getNode(t, testNodepoolName+"-1").Spec.Unschedulable {
+		t.Error("expected the upgraded node to stay schedulable")
+	}
+	if f.status.StragglerNodes != 1 {
+		t.Errorf("expected 1 straggler node in the status, got %d", f.status.StragglerNodes)
+	}
+
+	if err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Delete(context.Background(), "busy-agent", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+	phase, result = f.runPhase(t, f.reconciler.awaitUpgrade)
+
+	expectPhase(t, phase, result, updatev1.PhaseRestoring, false)
+	if _, err := f.kubeClient.CoreV1().Nodes().Get(context.Background(), testNodepoolName+"-0", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the straggler node to be deleted, got %v", err)
+	}
+	f.getNode(t, testNodepoolName+"-1")
+}
+
+func TestAwaitUpgrade_FailsNodepoolInFailedState(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.agentPoolClient.SetFailedState(testNodepoolName, fake.ProvisioningStateFailed)
+
+	phase, _, err := f.runFailingPhase(t, f.reconciler.awaitUpgrade)
+
+	if !errors.Is(err, nodepool.ErrProvisioningFailed) {
+		t.Fatalf("expected ErrProvisioningFailed, got %v", err)
+	}
+	if phase != updatev1.PhaseUpgrading {
+		t.Errorf("expected the rotation to stay in %s, got %s", updatev1.PhaseUpgrading, phase)
+	}
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateFailed)
+	expectLastError(t, f.status, testNodepoolName, updatev1.ErrorCategoryAzure, false)
+}
+
+func TestAwaitUpgrade_EvaluatesEveryNodepool(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.addOutdatedNodepool(t, "userpool", 1)
+	f.agentPoolClient.SetFailedState("userpool", fake.ProvisioningStateFailed)
+
+	phase, result := f.runPhase(t, f.reconciler.awaitUpgrade)
+
+	// the ready but outdated nodepool goes back to draining, the failed one is recorded on the way
+	expectPhase(t, phase, result, updatev1.PhaseDraining, false)
+	expectNodepoolState(t, f.status, "userpool", updatev1.NodepoolStateFailed)
+}
+
+func TestDrain_RotatesAtMostMaxConcurrentPools(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.addOutdatedNodepool(t, "userpool1", 1)
+	f.addOutdatedNodepool(t, "userpool2", 1)
+	f.safeEvict.Spec.MaxConcurrentPools = to.Ptr(int32(1))
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+	f.runPhase(t, f.reconciler.detect)
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateQueued)
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateInProgress)
+	expectNodepoolState(t, f.status, "userpool1", updatev1.NodepoolStateQueued)
+	expectNodepoolState(t, f.status, "userpool2", updatev1.NodepoolStateQueued)
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 1 || f.agentPoolClient.UpgradeCount("userpool1") != 0 {
+		t.Fatalf("expected only the first nodepool to be upgraded, got %d and %d upgrades", f.agentPoolClient.UpgradeCount(testNodepoolName), f.agentPoolClient.UpgradeCount("userpool1"))
+	}
+	if f.getNode(t, "userpool1-0").Spec.Unschedulable {
+		t.Error("expected the queued nodepool not to be cordoned")
+	}
+
+	// the upgrading nodepool keeps its slot
+	f.runPhase(t, f.reconciler.drain)
+
+	if f.agentPoolClient.UpgradeCount("userpool1") != 0 {
+		t.Fatal("expected the queued nodepool to wait while the first one is upgrading")
+	}
+
+	// the first nodepool is upgraded, the next one in name order takes its slot
+	f.clock.SetTime(f.clock.Now().Add(time.Minute))
+	f.runPhase(t, f.reconciler.drain)
+
+	expectNodepoolState(t, f.status, "userpool1", updatev1.NodepoolStateInProgress)
+	expectNodepoolState(t, f.status, "userpool2", updatev1.NodepoolStateQueued)
+	if f.agentPoolClient.UpgradeCount("userpool1") != 1 || f.agentPoolClient.UpgradeCount("userpool2") != 0 {
+		t.Errorf("expected the next nodepool to be upgraded, got %d and %d upgrades", f.agentPoolClient.UpgradeCount("userpool1"), f.agentPoolClient.UpgradeCount("userpool2"))
+	}
+}
+
+func TestDrain_FailedNodepoolReleasesItsSlot(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.addOutdatedNodepool(t, "userpool1", 1)
+	f.safeEvict.Spec.MaxConcurrentPools = to.Ptr(int32(1))
+	f.runPhase(t, f.reconciler.detect)
+	f.agentPoolClient.SetFailedState(testNodepoolName, fake.ProvisioningStateFailed)
+
+	_, _, err := f.runFailingPhase(t, f.reconciler.drain)
+
+	if err == nil {
+		t.Fatal("expected an error for the failed nodepool")
+	}
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateFailed)
+	expectNodepoolState(t, f.status, "userpool1", updatev1.NodepoolStateQueued)
+
+	// the nodepool stays failed in ARM, the queued one is rotated meanwhile
+	f.runFailingPhase(t, f.reconciler.drain)
+
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateFailed)
+	expectNodepoolState(t, f.status, "userpool1", updatev1.NodepoolStateInProgress)
+	if f.agentPoolClient.UpgradeCount("userpool1") != 1 {
+		t.Errorf("expected the queued nodepool to be upgraded while the failed one holds no slot, got %d upgrades", f.agentPoolClient.UpgradeCount("userpool1"))
+	}
+}
+
+func TestWaitForState(t *testing.T) {
+	f := newPhaseFixture(t)
+	nodepoolController := f.target.nodepoolController.WithStatePolling(time.Millisecond, time.Second)
+
+	if err := nodepoolController.WaitForState(context.Background(), testNodepoolName, nodepool.ProvisioningStateSucceeded, time.Second); err != nil {
+		t.Errorf("expected the settled nodepool to be in the state, got %v", err)
+	}
+
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+	if _, err := f.agentPoolClient.BeginCreateOrUpdate(context.Background(), "rg", "cluster", testNodepoolName, *f.agentPoolClient.AgentPool(testNodepoolName), nil); err != nil {
+		t.Fatalf("BeginCreateOrUpdate returned error: %v", err)
+	}
+	if err := nodepoolController.WaitForState(context.Background(), testNodepoolName, nodepool.ProvisioningStateSucceeded, 10*time.Millisecond); !errors.Is(err, nodepool.ErrProvisioningTimeout) {
+		t.Errorf("expected ErrProvisioningTimeout for an updating nodepool, got %v", err)
+	}
+
+	f.agentPoolClient.SetFailedState(testNodepoolName, fake.ProvisioningStateCanceled)
+	if err := nodepoolController.WaitForState(context.Background(), testNodepoolName, nodepool.ProvisioningStateSucceeded, time.Second); !errors.Is(err, nodepool.ErrProvisioningFailed) {
+		t.Errorf("expected ErrProvisioningFailed for a canceled nodepool, got %v", err)
+	}
+}
+
+func TestRestore_RestoresScalingAndUncordonsNodepool(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 3}`})
+	if err := f.target.nodepoolController.CordonNodesByAgentPool(context.Background(), testNodepoolName, true); err != nil {
+		t.Fatalf("failed to cordon nodes: %v", err)
+	}
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+
+	phase, result := f.runPhase(t, f.reconciler.restore)
+
+	expectPhase(t, phase, result, updatev1.PhaseRestoring, true)
+	if count := *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count; count != 3 {
+		t.Errorf("expected the saved count to be restored, got %d", count)
+	}
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the node to be uncordoned")
+	}
+
+	f.clock.SetTime(f.clock.Now().Add(time.Minute))
+	phase, result = f.runPhase(t, f.reconciler.restore)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, false)
+}
+
+func TestRestore_RestoresVersion2Scaling(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Version": 2, "EnableAutoScaling": true, "Count": 2, "MinCount": 1, "MaxCount": 4, "ScaleDownMode": "Deallocate"}`})
+
+	f.runPhase(t, f.reconciler.restore)
+
+	properties := f.agentPoolClient.AgentPool(testNodepoolName).Properties
+	if !*properties.EnableAutoScaling || *properties.MinCount != 1 || *properties.MaxCount != 4 {
+		t.Errorf("expected autoscaling within 1-4 to be restored, got %s", nodepool.SavedScalingOf(armcontainerservice.AgentPool{Properties: properties}).JSON())
+	}
+	if properties.ScaleDownMode == nil || *properties.ScaleDownMode != armcontainerservice.ScaleDownModeDeallocate {
+		t.Errorf("expected the scale down mode to be restored, got %v", properties.ScaleDownMode)
+	}
+}
+
+func TestRestore_RecordsImageHistory(t *testing.T) {
+	f := newPhaseFixture(t)
+	limit := int32(1)
+	f.safeEvict.Spec.ImageHistoryLimit = &limit
+	f.status.SetNodepoolState(testNodepoolName, updatev1.NodepoolStateInProgress, "")
+	f.status.RecordImageVersion(testNodepoolName, updatev1.ImageVersionRecord{Version: "AKSUbuntu-2204gen2containerd-202412.01.0"}, 1)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 2}`})
+
+	phase, result := f.runPhase(t, f.reconciler.restore)
+	f.runPhase(t, f.reconciler.restore)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, false)
+	history := f.status.Pools[0].ImageHistory
+	if len(history) != 1 || history[0].Version != testOldNodeImage || history[0].InitiatedBy != "default/rotation" {
+		t.Errorf("expected the version of the restored nodepool to replace the oldest one, got %+v", history)
+	}
+}
+
+func TestRestore_LabelsTheRotatedNodes(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.status.SetNodepoolState(testNodepoolName, updatev1.NodepoolStateInProgress, "")
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 2}`})
+
+	phase, result := f.runPhase(t, f.reconciler.restore)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, false)
+	node := f.getNode(t, testNodepoolName+"-0")
+	if node.Labels[updatev1.RotatedByLabel] != "rotation" || node.Labels[updatev1.RotatedByNamespaceLabel] != "default" {
+		t.Errorf("expected the node to be labeled with the SafeEvict, got %v", node.Labels)
+	}
+	if node.Annotations[updatev1.RotatedByAnnotation] != "default/rotation" {
+		t.Errorf("expected the node to be annotated with the SafeEvict, got %v", node.Annotations)
+	}
+	if _, err := time.Parse(time.RFC3339, node.Annotations[updatev1.LastRotationAnnotation]); err != nil {
+		t.Errorf("expected the time of the rotation on the node, got %v", err)
+	}
+	if node.Labels[nodepool.AgentPoolLabel] != testNodepoolName {
+		t.Errorf("expected the other labels of the node to be kept, got %v", node.Labels)
+	}
+}
+
+func TestRestore_BacksOffWhileScalingUpdateConflicts(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 3}`})
+	f.agentPoolClient.RejectUpdates(testNodepoolName, 2)
+
+	for _, expectedBackoff := range []time.Duration{time.Second, 2 * time.Second} {
+		phase, result := f.runPhase(t, f.reconciler.restore)
+
+		expectPhase(t, phase, result, updatev1.PhaseRestoring, true)
+		if result.RequeueAfter != expectedBackoff {
+			t.Errorf("expected a backoff of %v, got %v", expectedBackoff, result.RequeueAfter)
+		}
+		expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateInProgress)
+	}
+	if count := *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count; count != 2 {
+		t.Errorf("expected the refused scaling not to be applied, got count %d", count)
+	}
+
+	phase, result := f.runPhase(t, f.reconciler.restore)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, false)
+	if count := *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count; count != 3 {
+		t.Errorf("expected the saved count to be restored, got %d", count)
+	}
+	if conflicts := f.status.Pools[0].Conflicts; conflicts != 0 {
+		t.Errorf("expected the conflicts to be cleared once the scaling is restored, got %d", conflicts)
+	}
+	// the last conflict stays visible after the nodepool recovered
+	expectLastError(t, f.status, testNodepoolName, updatev1.ErrorCategoryAzure, true)
+}
+
+func TestDrain_WaitsUntilDisabledAutoScalingLanded(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.agentPoolClient.AddAgentPool(testNodepoolName, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		Count:             to.Ptr(int32(1)),
+		MinCount:          to.Ptr(int32(1)),
+		MaxCount:          to.Ptr(int32(3)),
+		EnableAutoScaling: to.Ptr(true),
+		Mode:              to.Ptr(armcontainerservice.AgentPoolModeUser),
+		NodeImageVersion:  to.Ptr(testOldNodeImage),
+	})
+	f.agentPoolClient.RejectUpdates(testNodepoolName, 1)
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if result.RequeueAfter != time.Second {
+		t.Errorf("expected a backoff of 1s after the conflict, got %v", result.RequeueAfter)
+	}
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the node not to be cordoned while the autoscaling is enabled")
+	}
+
+	// the update lands, but the nodepool was read before it
+	phase, result = f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if *f.agentPoolClient.AgentPool(testNodepoolName).Properties.EnableAutoScaling {
+		t.Error("expected the autoscaling to be disabled")
+	}
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the node not to be cordoned before the disabled autoscaling is read back")
+	}
+
+	f.runPhase(t, f.reconciler.drain)
+
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 1 {
+		t.Errorf("expected the nodepool to be upgraded once its autoscaling is disabled, got %d upgrades", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+}
+
+func TestCleanUp_RemovesTemporaryNodepoolAndScaling(t *testing.T) {
+	f := newPhaseFixture(t)
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+	if err := f.target.nodepoolController.CreateTemporaryNodePool(context.Background(), temporaryNodepoolName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{}, f.safeEvict.GetOwnerTag()); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 2}`})
+	f.createPod(t, "busy-agent", temporaryNodepoolName+"-0", map[string]string{"busy": "true"})
+
+	phase, result := f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, true)
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) == nil {
+		t.Error("expected the temporary nodepool to be kept while a busy pod is running on it")
+	}
+
+	if err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Delete(context.Background(), "busy-agent", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) != nil {
+		t.Error("expected the temporary nodepool to be removed")
+	}
+	if _, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.target.configmapName); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the saved scaling to be deleted, got %v", err)
+	}
+}
+
+func TestCleanUp_RemovesTheTemporaryNodepoolOfTheLegacyName(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.status.Phase = updatev1.PhaseCleaningUp
+	legacyName := f.safeEvict.GetLegacyTemporaryNodepoolName()
+	if err := f.target.nodepoolController.CreateTemporaryNodePool(context.Background(), legacyName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{}, f.safeEvict.GetOwnerTag()); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 2}`})
+	f.createPod(t, "busy-agent", legacyName+"-0", map[string]string{"busy": "true"})
+
+	phase, result := f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, true)
+	if f.status.TemporaryNodepool != legacyName {
+		t.Errorf("expected the temporary nodepool of the legacy name '%s' to be adopted, got '%s'", legacyName, f.status.TemporaryNodepool)
+	}
+
+	if err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Delete(context.Background(), "busy-agent", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if f.agentPoolClient.AgentPool(legacyName) != nil {
+		t.Error("expected the temporary nodepool of the legacy name to be removed")
+	}
+	if f.status.TemporaryNodepool != "" {
+		t.Errorf("expected the next rotation to use the derived name, got '%s'", f.status.TemporaryNodepool)
+	}
+}
+
+func TestProvisionBackup_IgnoresTheLegacyNameOfAnotherSafeEvict(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.status.Phase = updatev1.PhaseProvisioningBackup
+	legacyName := f.safeEvict.GetLegacyTemporaryNodepoolName()
+	if err := f.target.nodepoolController.CreateTemporaryNodePool(context.Background(), legacyName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{}, "default/other"); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+
+	f.runPhase(t, f.reconciler.provisionBackup)
+
+	if f.status.TemporaryNodepool != "" {
+		t.Errorf("expected the nodepool of another SafeEvict not to be adopted, got '%s'", f.status.TemporaryNodepool)
+	}
+	if f.agentPoolClient.AgentPool(f.safeEvict.GetTemporaryNodepoolName()) == nil {
+		t.Error("expected the temporary nodepool to be created under the derived name")
+	}
+}
+
+func TestCleanUp_WaitsForTheTemporaryNodepoolDeletion(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.target.nodepoolController = f.target.nodepoolController.WithStatePolling(time.Millisecond, 50*time.Millisecond)
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+	if err := f.target.nodepoolController.CreateTemporaryNodePool(context.Background(), temporaryNodepoolName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{}, f.safeEvict.GetOwnerTag()); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+
+	phase, result := f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, true)
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) == nil {
+		t.Fatal("expected the temporary nodepool to still be deleting")
+	}
+
+	f.clock.SetTime(f.clock.Now().Add(f.agentPoolClient.ProvisioningDuration))
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) != nil {
+		t.Error("expected the temporary nodepool to be removed")
+	}
+}
+
+func TestCleanUp_RetainsTheScaledDownTemporaryNodepool(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Spec.BackupPool = &updatev1.BackupPoolSpec{RetainFor: &metav1.Duration{Duration: time.Hour}}
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+	if err := f.target.nodepoolController.CreateTemporaryNodePool(context.Background(), temporaryNodepoolName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{}, f.safeEvict.GetOwnerTag()); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+	f.status.StartTime = &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}
+	f.safeEvict.Spec.UpgradeTimeout = &metav1.Duration{Duration: time.Hour}
+
+	phase, result := f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, true)
+	temporaryNodepool := f.agentPoolClient.AgentPool(temporaryNodepoolName)
+	if temporaryNodepool == nil {
+		t.Fatal("expected the temporary nodepool to be retained")
+	}
+	if count := *temporaryNodepool.Properties.Count; count != 0 {
+		t.Errorf("expected the retained temporary nodepool to be scaled down, got %d nodes", count)
+	}
+	if f.status.TemporaryNodepoolRetainedUntil == nil || time.Until(f.status.TemporaryNodepoolRetainedUntil.Time) <= 0 {
+		t.Fatalf("expected the retention to be recorded, got %v", f.status.TemporaryNodepoolRetainedUntil)
+	}
+	if upgradeTimedOut(f.safeEvict, &f.status, phase) {
+		t.Error("expected the retained rotation not to time out")
+	}
+
+	f.status.TemporaryNodepoolRetainedUntil = &metav1.Time{Time: time.Now().Add(-time.Second)}
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) != nil {
+		t.Error("expected the temporary nodepool to be removed after the retention")
+	}
+	if f.status.TemporaryNodepoolRetainedUntil != nil {
+		t.Error("expected the retention to be cleared")
+	}
+}
+
+// notifierFunc delivers the notifications with a function
+type notifierFunc func(ctx context.Context, notification notify.Notification) error
+
+func (f notifierFunc) Notify(ctx context.Context, notification notify.Notification) error {
+	return f(ctx, notification)
+}
+
+func TestCleanUp_RollsBackOntoTheRetainedTemporaryNodepool(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Spec.BackupPool = &updatev1.BackupPoolSpec{RetainFor: &metav1.Duration{Duration: time.Hour}, AutoRollback: &updatev1.AutoRollbackSpec{}}
+	var notifications []notify.Notification
+	f.reconciler.Notifier = notifierFunc(func(_ context.Context, notification notify.Notification) error {
+		notifications = append(notifications, notification)
+		return nil
+	})
+	config := *f.reconciler.Config.Load()
+	config.NotificationTemplates = map[string]string{string(notify.EventRolledBack): "{{.Namespace}}/{{.Name}} rolled back: {{.Error}}"}
+	f.reconciler.Config.Update(&config)
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+	if err := f.target.nodepoolController.CreateTemporaryNodePool(context.Background(), temporaryNodepoolName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{}, f.safeEvict.GetOwnerTag()); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+	f.status.SetNodepoolState(testNodepoolName, updatev1.NodepoolStateSucceeded, "")
+
+	phase, result := f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, true)
+	if meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionFailed) {
+		t.Fatal("expected the rotation without agents not to be rolled back")
+	}
+
+	// an agent on the upgraded nodepool which does not get ready
+	f.createPod(t, "broken-agent", testNodepoolName+"-0", nil)
+	agentPod, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Get(context.Background(), "broken-agent", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	agentPod.Annotations = map[string]string{pod.AgentPoolAnnotation: "pool"}
+	if _, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Update(context.Background(), agentPod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, true)
+	if failed := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionFailed); failed == nil || failed.Status != metav1.ConditionTrue || failed.Reason != updatev1.ReasonAgentsDegraded {
+		t.Fatalf("expected the rotation to fail with degraded agents, got %+v", failed)
+	}
+	if !meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionRolledBackToBackupPool) {
+		t.Error("expected the workload to be held on the temporary nodepool")
+	}
+	if count := *f.agentPoolClient.AgentPool(temporaryNodepoolName).Properties.Count; count != f.status.TemporaryNodepoolCount || count == 0 {
+		t.Errorf("expected the temporary nodepool to be scaled back to %d nodes, got %d", f.status.TemporaryNodepoolCount, count)
+	}
+	if !f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the upgraded nodepool to be cordoned")
+	}
+	if len(notifications) != 1 || notifications[0].Event != notify.EventRolledBack || !slices.Equal(notifications[0].Nodepools, []string{testNodepoolName}) {
+		t.Errorf("expected a notification of the rollback, got %+v", notifications)
+	}
+	if expected := fmt.Sprintf("%s/%s rolled back: ", f.safeEvict.Namespace, f.safeEvict.Name); len(notifications) == 1 &&
+		(!strings.HasPrefix(notifications[0].Message, expected) || notifications[0].Error == "") {
+		t.Errorf("expected the message of the notification template with the cause of the rollback, got %q", notifications[0].Message)
+	}
+
+	// the hold lasts until the abort annotation releases it
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, true)
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) == nil || len(notifications) != 1 {
+		t.Fatal("expected the temporary nodepool to be held without another notification")
+	}
+
+	f.safeEvict.Annotations = map[string]string{updatev1.AbortAnnotation: "true"}
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, true)
+	if meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionRolledBackToBackupPool) {
+		t.Error("expected the hold to be released")
+	}
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the upgraded nodepool to be uncordoned")
+	}
+
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseFailed, true)
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) != nil {
+		t.Error("expected the temporary nodepool to be removed after the release")
+	}
+}
+
+func TestCleanUp_SharedTemporaryNodepoolIsRemovedByLastSafeEvict(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Spec.BackupPool = &updatev1.BackupPoolSpec{Shared: true}
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+	nodepoolController := f.target.nodepoolController
+	const otherOwner = "other/rotation"
+	if err := nodepoolController.CreateTemporaryNodePool(context.Background(), temporaryNodepoolName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{Shared: true}, otherOwner); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+
+	phase, result := f.runPhase(t, f.reconciler.provisionBackup)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, false)
+	if err := nodepoolController.VerifyTemporaryNodePoolOwnership(context.Background(), temporaryNodepoolName, f.safeEvict.GetOwnerTag()); err != nil {
+		t.Fatalf("expected the shared temporary nodepool to be acquired, got %v", err)
+	}
+
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, true)
+	if node := f.getNode(t, temporaryNodepoolName+"-0"); node.Spec.Unschedulable {
+		t.Error("expected the shared temporary nodepool not to be drained while another SafeEvict uses it")
+	}
+
+	if _, err := nodepoolController.ReleaseSharedNodePool(context.Background(), temporaryNodepoolName, otherOwner); err != nil {
+		t.Fatalf("failed to release the shared temporary nodepool: %v", err)
+	}
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) == nil {
+		t.Fatal("expected the shared temporary nodepool to be kept for the SafeEvict which still references it")
+	}
+	if err := nodepoolController.VerifyTemporaryNodePoolOwnership(context.Background(), temporaryNodepoolName, f.safeEvict.GetOwnerTag()); !errors.Is(err, nodepool.ErrNodePoolNotManaged) {
+		t.Errorf("expected the reference of the SafeEvict to be dropped, got %v", err)
+	}
+	last, err := nodepoolController.DropSharedNodePoolReference(context.Background(), temporaryNodepoolName, otherOwner)
+	if err != nil || !last {
+		t.Errorf("expected the other SafeEvict to hold the last reference, got %t, %v", last, err)
+	}
+}
+
+func TestAcquireSharedNodePool_ConcurrentSafeEvictsKeepEveryReference(t *testing.T) {
+	f := newPhaseFixture(t)
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+	nodepoolController := f.target.nodepoolController
+	if err := nodepoolController.CreateTemporaryNodePool(context.Background(), temporaryNodepoolName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{Shared: true}, "creator/rotation"); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+
+	owners := make([]string, 10)
+	for i := range owners {
+		owners[i] = fmt.Sprintf("default/rotation-%d", i)
+	}
+	// every rotation reads the tags before the others wrote theirs, each works on its own copy of the controller
+	slowClient := slowUpdateAgentPoolClient{AgentPoolClientInterface: f.agentPoolClient, delay: 20 * time.Millisecond}
+	var wg sync.WaitGroup
+	for _, owner := range owners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			controller := nodepoolController.WithCluster(f.kubeClient, slowClient, fake.SubscriptionID, "rg", "cluster")
+			if acquired, err := controller.AcquireSharedNodePool(context.Background(), temporaryNodepoolName, owner); err != nil || !acquired {
+				t.Errorf("expected %s to acquire the shared temporary nodepool, got %t, %v", owner, acquired, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, owner := range owners {
+		if err := nodepoolController.VerifyTemporaryNodePoolOwnership(context.Background(), temporaryNodepoolName, owner); err != nil {
+			t.Errorf("expected the reference of %s to be kept, got %v", owner, err)
+		}
+	}
+}
+
+// slowUpdateAgentPoolClient delays the updates of the agent pools
+type slowUpdateAgentPoolClient struct {
+	nodepool.AgentPoolClientInterface
+	delay time.Duration
+}
+
+func (c slowUpdateAgentPoolClient) BeginCreateOrUpdate(ctx context.Context, resourceGroup, clusterName, nodePoolName string, parameters armcontainerservice.AgentPool, options *armcontainerservice.AgentPoolsClientBeginCreateOrUpdateOptions) (*azruntime.Poller[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse], error) {
+	time.Sleep(c.delay)
+	return c.AgentPoolClientInterface.BeginCreateOrUpdate(ctx, resourceGroup, clusterName, nodePoolName, parameters, options)
+}
+
+func TestDrain_SavesScalingOfNodepoolOutdatedDuringRotation(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 5}`})
+	f.addOutdatedNodepool(t, "userpool", 3)
+
+	f.runPhase(t, f.reconciler.drain)
+
+	data, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.target.configmapName)
+	if err != nil {
+		t.Fatalf("failed to get ConfigMap data: %v", err)
+	}
+	if data[testNodepoolName] != `{"Count": 5}` {
+		t.Errorf("expected the saved scaling to be kept, got %s", data[testNodepoolName])
+	}
+	if data["userpool"] != `{"Version":2,"EnableAutoScaling":false,"Count":3}` {
+		t.Errorf("expected the scaling of the newly outdated nodepool to be saved, got %v", data)
+	}
+}
+
+func TestRestore_RefusesInvalidScaling(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"MinCount": 1}`})
+	if err := f.target.nodepoolController.CordonNodesByAgentPool(context.Background(), testNodepoolName, true); err != nil {
+		t.Fatalf("failed to cordon nodes: %v", err)
+	}
+
+	phase, _, err := f.runFailingPhase(t, f.reconciler.restore)
+
+	if !nodepool.IsInvalidScaling(err) || phase != updatev1.PhaseRestoring {
+		t.Fatalf("expected the restore to fail on the invalid scaling, got %s, %v", phase, err)
+	}
+	if count := *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count; count != 2 {
+		t.Errorf("expected the scaling of the nodepool to be kept, got count %d", count)
+	}
+	if !f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the node to stay cordoned")
+	}
+	condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionScalingStateInvalid)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != updatev1.ReasonInvalidScaling {
+		t.Errorf("expected the ScalingStateInvalid condition with reason %s, got %v", updatev1.ReasonInvalidScaling, condition)
+	}
+}
+
+func TestRestore_RefusesModifiedScaling(t *testing.T) {
+	f := newPhaseFixture(t)
+	saved := map[string]string{testNodepoolName: `{"Count": 3}`}
+	saved[scalingChecksumKey] = scalingChecksum(saved)
+	saved[testNodepoolName] = `{"Count": 30}`
+	f.saveScaling(t, saved)
+
+	phase, _, err := f.runFailingPhase(t, f.reconciler.restore)
+
+	if err == nil || phase != updatev1.PhaseRestoring {
+		t.Fatalf("expected the restore to fail on the modified scaling, got %s, %v", phase, err)
+	}
+	if count := *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count; count == 30 {
+		t.Error("expected the modified scaling not to be restored")
+	}
+	if !meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionScalingStateInvalid) {
+		t.Errorf("expected the ScalingStateInvalid condition, got %v", f.status.Conditions)
+	}
+
+	delete(saved, scalingChecksumKey)
+	if err := f.reconciler.ConfigmapController.UpdateConfigMap(context.Background(), f.target.configmapNamespace, f.target.configmapName, saved); err != nil {
+		t.Fatalf("failed to update ConfigMap: %v", err)
+	}
+	f.runPhase(t, f.reconciler.restore)
+
+	if count := *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count; count != 30 {
+		t.Errorf("expected the scaling to be restored once its checksum was removed, got %d", count)
+	}
+	if meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionScalingStateInvalid) {
+		t.Error("expected the ScalingStateInvalid condition to be cleared")
+	}
+}
+
+func TestRestore_RestoresSavedNodeTaints(t *testing.T) {
+	f := newPhaseFixture(t)
+	nodeName := testNodepoolName + "-0"
+	userTaint := corev1.Taint{Key: "dedicated", Value: "agents", Effect: corev1.TaintEffectNoSchedule}
+	node := f.getNode(t, nodeName)
+	node.Spec.Taints = []corev1.Taint{userTaint}
+	if _, err := f.kubeClient.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update node: %v", err)
+	}
+	f.runPhase(t, f.reconciler.provisionBackup)
+
+	// the rotation cordons the node and someone changes its taints meanwhile
+	unschedulableTaint := corev1.Taint{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule}
+	node = f.getNode(t, nodeName)
+	node.Spec.Taints = []corev1.Taint{unschedulableTaint, {Key: "other", Effect: corev1.TaintEffectNoExecute}}
+	if _, err := f.kubeClient.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update node: %v", err)
+	}
+
+	f.runPhase(t, f.reconciler.restore)
+
+	taints := f.getNode(t, nodeName).Spec.Taints
+	if len(taints) != 2 || taints[0] != unschedulableTaint || taints[1] != userTaint {
+		t.Errorf("expected the saved taints to be restored next to the managed ones, got %v", taints)
+	}
+}
+
+func TestDetect_MarksOutdatedNodepoolsInProgress(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.status.SetNodepoolState("removed", updatev1.NodepoolStateSucceeded, "")
+
+	f.runPhase(t, f.reconciler.detect)
+
+	if len(f.status.Pools) != 1 {
+		t.Errorf("expected the state of the previous rotation to be dropped, got %v", f.status.Pools)
+	}
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateInProgress)
+}
+
+func TestDrain_ContinuesWithHealthyNodepools(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.addOutdatedNodepool(t, "userpool", 1)
+	f.kubeClient.PrependReactor("update", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.UpdateAction).GetObject().(*corev1.Node).Name == testNodepoolName+"-0" {
+			return true, nil, errors.New("mock update error")
+		}
+		return false, nil, nil
+	})
+
+	phase, result, err := f.runFailingPhase(t, f.reconciler.drain)
+
+	if err == nil {
+		t.Fatal("expected the failure of the nodepool to be returned")
+	}
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateFailed)
+	expectNodepoolState(t, f.status, "userpool", updatev1.NodepoolStateInProgress)
+	if f.agentPoolClient.UpgradeCount("userpool") != 1 {
+		t.Errorf("expected the healthy nodepool to be upgraded, got %d upgrades", f.agentPoolClient.UpgradeCount("userpool"))
+	}
+}
+
+func TestRestore_MarksRestoredNodepoolsSucceeded(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 2}`, "missing": `{"Count": 1}`})
+
+	phase, result, err := f.runFailingPhase(t, f.reconciler.restore)
+
+	if err == nil {
+		t.Fatal("expected the failure of the missing nodepool to be returned")
+	}
+	expectPhase(t, phase, result, updatev1.PhaseRestoring, true)
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateSucceeded)
+	expectNodepoolState(t, f.status, "missing", updatev1.NodepoolStateFailed)
+}
+
+func TestRotation_CountsWorkInStatus(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.createPod(t, "idle-agent", testNodepoolName+"-0", nil)
+
+	f.runPhase(t, f.reconciler.drain)
+	for range 2 {
+		phase, result := f.runPhase(t, f.reconciler.restore)
+		expectPhase(t, phase, result, updatev1.PhaseCleaningUp, false)
+	}
+	phase, result := f.runPhase(t, f.reconciler.cleanUp)
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	phase, result = f.runPhase(t, f.reconciler.rollBack)
+	expectPhase(t, phase, result, updatev1.PhaseFailed, true)
+
+	expected := updatev1.RotationCounters{Rotations: 2, SucceededRotations: 1, PodsEvicted: 1, DowntimeFreeUpgrades: 1}
+	if f.status.Counters != expected {
+		t.Errorf("expected counters %+v, got %+v", expected, f.status.Counters)
+	}
+}
+
+// timeOut marks the rotation in the phase as started before the upgrade timeout of the SafeEvict
+func (f *phaseFixture) timeOut(phase updatev1.Phase) {
+	f.safeEvict.Spec.UpgradeTimeout = &metav1.Duration{Duration: time.Hour}
+	f.status.Phase = phase
+	f.status.StartTime = &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}
+}
+
+func TestReconcileCluster_RollsBackRotationExceedingUpgradeTimeout(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 3}`})
+	if err := f.target.nodepoolController.CordonNodesByAgentPool(context.Background(), testNodepoolName, true); err != nil {
+		t.Fatalf("failed to cordon nodes: %v", err)
+	}
+	f.timeOut(updatev1.PhaseDraining)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: f.safeEvict.Name, Namespace: f.safeEvict.Namespace}}
+
+	result, err := f.reconciler.reconcileCluster(context.Background(), req, f.safeEvict, f.target, &f.status)
+
+	if err != nil {
+		t.Fatalf("reconcileCluster returned error: %v", err)
+	}
+	if f.status.Phase != updatev1.PhaseFailed || result.RequeueAfter != time.Hour {
+		t.Errorf("expected the rotation to wait in phase Failed for the upgrade frequency, got %s after %v", f.status.Phase, result.RequeueAfter)
+	}
+	condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionFailed)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != updatev1.ReasonUpgradeTimeout {
+		t.Errorf("expected the Failed condition to be set by the timeout, got %v", condition)
+	}
+	if count := *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count; count != 3 {
+		t.Errorf("expected the saved count to be restored, got %d", count)
+	}
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the node to be uncordoned")
+	}
+	if _, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.target.configmapName); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the saved scaling to be deleted, got %v", err)
+	}
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateFailed)
+}
+
+func TestReconcileCluster_KeepsRotationWithinUpgradeTimeout(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 2}`})
+	f.timeOut(updatev1.PhaseRestoring)
+	f.status.StartTime = &metav1.Time{Time: time.Now()}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: f.safeEvict.Name, Namespace: f.safeEvict.Namespace}}
+
+	if _, err := f.reconciler.reconcileCluster(context.Background(), req, f.safeEvict, f.target, &f.status); err != nil {
+		t.Fatalf("reconcileCluster returned error: %v", err)
+	}
+
+	if meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionFailed) {
+		t.Error("expected the rotation not to time out")
+	}
+}
+
+func TestRollBack_KeepsScalingOfUpdatingNodepool(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 3}`})
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+	nodepool, err := f.target.nodepoolController.GetNodePoolByName(context.Background(), testNodepoolName)
+	if err != nil {
+		t.Fatalf("failed to get nodepool: %v", err)
+	}
+	if err := f.target.nodepoolController.UpgradeNodeImageVersion(context.Background(), nodepool); err != nil {
+		t.Fatalf("failed to upgrade nodepool: %v", err)
+	}
+
+	phase, result := f.runPhase(t, f.reconciler.rollBack)
+
+	expectPhase(t, phase, result, updatev1.PhaseFailed, true)
+	data, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.target.configmapName)
+	if err != nil || data[testNodepoolName] != `{"Count": 3}` {
+		t.Errorf("expected the saved scaling to be kept for the next rotation, got %v, %v", data, err)
+	}
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateFailed)
+}
+
+func TestDetect_RestoresScalingKeptByRollBackOnceUpToDate(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 3}`})
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+	nodepool, err := f.target.nodepoolController.GetNodePoolByName(context.Background(), testNodepoolName)
+	if err != nil {
+		t.Fatalf("failed to get nodepool: %v", err)
+	}
+	if err := f.target.nodepoolController.UpgradeNodeImageVersion(context.Background(), nodepool); err != nil {
+		t.Fatalf("failed to upgrade nodepool: %v", err)
+	}
+	f.status.Phase = updatev1.PhaseRollingBack
+	phase, result := f.runPhase(t, f.reconciler.rollBack)
+	expectPhase(t, phase, result, updatev1.PhaseFailed, true)
+
+	f.clock.SetTime(f.clock.Now().Add(2 * time.Minute))
+	f.status.Phase = updatev1.PhaseDetecting
+	phase, result = f.runPhase(t, f.reconciler.detect)
+
+	if phase != updatev1.PhaseDetecting || result == nil || result.RequeueAfter != time.Hour {
+		t.Fatalf("expected the up to date cluster to be checked again after the upgrade frequency, got %s after %v", phase, result)
+	}
+	if count := *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count; count != 3 {
+		t.Errorf("expected the kept saved count to be restored, got %d", count)
+	}
+	if _, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.target.configmapName); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the saved scaling to be deleted once it is restored, got %v", err)
+	}
+}
+
+func TestRollBack_RemovesTemporaryNodepoolWhenRequested(t *testing.T) {
+	f := newPhaseFixture(t)
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+	if err := f.target.nodepoolController.CreateTemporaryNodePool(context.Background(), temporaryNodepoolName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{}, f.safeEvict.GetOwnerTag()); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 2}`})
+	f.safeEvict.Spec.RemoveBackupPoolOnTimeout = true
+	meta.SetStatusCondition(&f.status.Conditions, metav1.Condition{Type: updatev1.ConditionFailed, Status: metav1.ConditionTrue, Reason: updatev1.ReasonUpgradeTimeout})
+
+	phase, result := f.runPhase(t, f.reconciler.rollBack)
+
+	expectPhase(t, phase, result, updatev1.PhaseCleaningUp, false)
+
+	phase, result = f.runPhase(t, f.reconciler.cleanUp)
+
+	expectPhase(t, phase, result, updatev1.PhaseFailed, true)
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) != nil {
+		t.Error("expected the temporary nodepool to be removed")
+	}
+}
+
+func TestAwaitRetry(t *testing.T) {
+	f := newPhaseFixture(t)
+	meta.SetStatusCondition(&f.status.Conditions, metav1.Condition{Type: updatev1.ConditionFailed, Status: metav1.ConditionTrue, Reason: updatev1.ReasonUpgradeTimeout})
+
+	phase, result := f.runPhase(t, f.reconciler.awaitRetry)
+
+	expectPhase(t, phase, result, updatev1.PhaseFailed, true)
+
+	f.status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	phase, result = f.runPhase(t, f.reconciler.awaitRetry)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, false)
+
+	f.runPhase(t, f.reconciler.detect)
+
+	if meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionFailed) || f.status.StartTime == nil {
+		t.Errorf("expected the retried rotation to be started, got %v", f.status)
+	}
+}
+
+func TestDrain_WaitsForApprovalBeforeUpgrade(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Spec.RequireApproval = true
+	f.status.StartTime = &metav1.Time{Time: time.Now()}
+	f.safeEvict.Annotations = map[string]string{updatev1.ApprovedAnnotation: time.Now().Add(-time.Hour).Format(time.RFC3339)}
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 0 {
+		t.Error("expected no upgrade before the rotation is approved")
+	}
+	if !meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionAwaitingApproval) {
+		t.Errorf("expected the rotation to wait for approval, got %v", f.status.Conditions)
+	}
+
+	f.safeEvict.Annotations[updatev1.ApprovedAnnotation] = time.Now().Add(time.Second).Format(time.RFC3339)
+	f.runPhase(t, f.reconciler.drain)
+
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 1 {
+		t.Errorf("expected the approved nodepool to be upgraded once, got %d", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+	if meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionAwaitingApproval) {
+		t.Error("expected the approval to be reported")
+	}
+}
+
+func TestSetReadiness(t *testing.T) {
+	tests := []struct {
+		name                string
+		phases              []updatev1.Phase
+		err                 error
+		expectedReady       metav1.ConditionStatus
+		expectedProgressing metav1.ConditionStatus
+		expectedReason      string
+	}{
+		{name: "up to date", phases: []updatev1.Phase{updatev1.PhaseDetecting}, expectedReady: metav1.ConditionTrue, expectedProgressing: metav1.ConditionFalse, expectedReason: updatev1.ReasonReconciled},
+		{name: "rotating", phases: []updatev1.Phase{updatev1.PhaseDetecting, updatev1.PhaseDraining}, expectedReady: metav1.ConditionTrue, expectedProgressing: metav1.ConditionTrue, expectedReason: updatev1.ReasonReconciled},
+		{name: "rolled back", phases: []updatev1.Phase{updatev1.PhaseFailed}, expectedReady: metav1.ConditionFalse, expectedProgressing: metav1.ConditionFalse, expectedReason: updatev1.ReasonRotationFailed},
+		{name: "reconcile error", phases: []updatev1.Phase{updatev1.PhaseDraining}, err: errors.New("mock error"), expectedReady: metav1.ConditionFalse, expectedProgressing: metav1.ConditionTrue, expectedReason: updatev1.ReasonReconcileError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &updatev1.SafeEvictStatus{}
+
+			setReadiness(status, 3, tt.phases, tt.err)
+
+			if status.ObservedGeneration != 3 {
+				t.Errorf("expected observed generation 3, got %d", status.ObservedGeneration)
+			}
+			ready := meta.FindStatusCondition(status.Conditions, updatev1.ConditionReady)
+			if ready == nil || ready.Status != tt.expectedReady || ready.Reason != tt.expectedReason || ready.ObservedGeneration != 3 {
+				t.Errorf("expected Ready %s with reason %s, got %v", tt.expectedReady, tt.expectedReason, ready)
+			}
+			if progressing := meta.FindStatusCondition(status.Conditions, updatev1.ConditionProgressing); progressing == nil || progressing.Status != tt.expectedProgressing {
+				t.Errorf("expected Progressing %s, got %v", tt.expectedProgressing, progressing)
+			}
+		})
+	}
+}
+
+func TestAwaitRetry_CheckNowRetriesRightAway(t *testing.T) {
+	f := newPhaseFixture(t)
+	meta.SetStatusCondition(&f.status.Conditions, metav1.Condition{Type: updatev1.ConditionFailed, Status: metav1.ConditionTrue, Reason: updatev1.ReasonUpgradeTimeout})
+	f.safeEvict.Annotations = map[string]string{updatev1.CheckNowAnnotation: time.Now().Format(time.RFC3339)}
+
+	phase, result := f.runPhase(t, f.reconciler.awaitRetry)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, false)
+}
+
+func TestReconcile_RemovesCheckNowAnnotation(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Annotations = map[string]string{updatev1.CheckNowAnnotation: time.Now().Format(time.RFC3339)}
+	scheme := runtime.NewScheme()
+	if err := updatev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add the scheme: %v", err)
+	}
+	f.reconciler.Client = crfake.NewClientBuilder().WithScheme(scheme).WithObjects(f.safeEvict).WithStatusSubresource(f.safeEvict).Build()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: f.safeEvict.Name, Namespace: f.safeEvict.Namespace}}
+
+	if _, err := f.reconciler.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+
+	safeEvict := &updatev1.SafeEvict{}
+	if err := f.reconciler.Client.Get(context.Background(), req.NamespacedName, safeEvict); err != nil {
+		t.Fatalf("failed to get SafeEvict: %v", err)
+	}
+	if _, found := safeEvict.Annotations[updatev1.CheckNowAnnotation]; found {
+		t.Error("expected the check-now annotation to be removed after the check")
+	}
+	if safeEvict.Status.Phase != updatev1.PhaseProvisioningBackup && safeEvict.Status.Phase != updatev1.PhaseDraining {
+		t.Errorf("expected the check to start the rotation of the outdated nodepool, got phase %s", safeEvict.Status.Phase)
+	}
+}
+
+// markPodsReady sets the Ready condition of the agent pods
+func (f *phaseFixture) markPodsReady(t *testing.T, names ...string) {
+	for _, name := range names {
+		agentPod, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get pod: %v", err)
+		}
+		agentPod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		if _, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Update(context.Background(), agentPod, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update pod: %v", err)
+		}
+	}
+}
+
+func (f *phaseFixture) countPods(t *testing.T) int {
+	podList, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list pods: %v", err)
+	}
+	return len(podList.Items)
+}
+
+func TestDrain_KeepsMinAvailableAgents(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Spec.MinAvailableAgents = 2
+	createNode(t, f.kubeClient, "tmpagentpool-0", "tmpagentpool", testLatestNodeImage)
+	for _, name := range []string{"idle-agent-0", "idle-agent-1", "idle-agent-2"} {
+		f.createPod(t, name, testNodepoolName+"-0", nil)
+	}
+	f.markPodsReady(t, "idle-agent-0", "idle-agent-1", "idle-agent-2")
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if count := f.countPods(t); count != 2 {
+		t.Errorf("expected a single idle pod to be evicted, %d pods are left", count)
+	}
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 0 {
+		t.Error("expected no upgrade while idle pods are held back")
+	}
+
+	// a replacement agent on the temporary nodepool allows the next eviction
+	f.createPod(t, "replacement-agent", "tmpagentpool-0", map[string]string{"busy": "true"})
+	f.markPodsReady(t, "replacement-agent")
+
+	phase, result = f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if count := f.countPods(t); count != 2 {
+		t.Errorf("expected one more idle pod to be evicted, %d pods are left", count)
+	}
+}
+
+func TestDrain_EvictsInEvictionOrder(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Spec.MinAvailableAgents = 2
+	f.safeEvict.Spec.Eviction = &updatev1.EvictionSpec{Order: updatev1.EvictionOrderNewestFirst}
+	created := f.clock.Now().Add(-time.Hour)
+	for i, name := range []string{"cache-agent", "new-agent", "old-agent"} {
+		f.createPod(t, name, testNodepoolName+"-0", nil)
+		agentPod, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get pod: %v", err)
+		}
+		agentPod.CreationTimestamp = metav1.NewTime(created.Add(time.Duration([]int{-24, 1, -2}[i]) * time.Hour))
+		if _, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Update(context.Background(), agentPod, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update pod: %v", err)
+		}
+	}
+	f.markPodsReady(t, "cache-agent", "new-agent", "old-agent")
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if _, err := f.kubeClient.CoreV1().Pods(testAgentNamespace).Get(context.Background(), "new-agent", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the newest pod to be evicted first, got %v", err)
+	}
+	if count := f.countPods(t); count != 2 {
+		t.Errorf("expected a single idle pod to be evicted, %d pods are left", count)
+	}
+}
+
+func TestDetect_AutoScheduleWaitsForQuietHour(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Spec.AutoSchedule = true
+	// the agents are busy in the current hour only
+	now := time.Now()
+	var history utilization.History
+	for hour := range 24 {
+		history.Record(now.Add(time.Duration(hour)*time.Hour), 0)
+	}
+	history.Record(now, 100)
+	if err := f.reconciler.ConfigmapController.CreateConfigMap(context.Background(), f.safeEvict.Namespace, f.target.usageConfigmapName, history.Data(), f.safeEvict); err != nil {
+		t.Fatalf("failed to create ConfigMap: %v", err)
+	}
+
+	phase, result := f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if result.RequeueAfter > time.Hour {
+		t.Errorf("expected to check again at the next hour at the latest, got %v", result.RequeueAfter)
+	}
+	if f.status.StartTime != nil {
+		t.Error("expected no rotation to be started outside of the quiet hours")
+	}
+
+	// check-now starts the rotation regardless of the schedule
+	f.safeEvict.Annotations = map[string]string{updatev1.CheckNowAnnotation: "now"}
+
+	phase, result = f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseProvisioningBackup, false)
+}
+
+func TestReconcileCluster_WaitsForNextCheckTime(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.status.Phase = updatev1.PhaseDetecting
+	f.status.NextCheckTime = &metav1.Time{Time: time.Now().Add(time.Hour)}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: f.safeEvict.Name, Namespace: f.safeEvict.Namespace}}
+
+	result, err := f.reconciler.reconcileCluster(context.Background(), req, f.safeEvict, f.target, &f.status)
+
+	if err != nil {
+		t.Fatalf("reconcileCluster returned error: %v", err)
+	}
+	if result.RequeueAfter <= 59*time.Minute || result.RequeueAfter > time.Hour {
+		t.Errorf("expected to requeue at the next check time, got %v", result.RequeueAfter)
+	}
+	if f.status.StartTime != nil || f.status.NextCheckTime == nil {
+		t.Error("expected the nodepools not to be checked before the next check time")
+	}
+
+	// a changed spec is checked right away
+	f.safeEvict.Generation = 2
+
+	if _, err := f.reconciler.reconcileCluster(context.Background(), req, f.safeEvict, f.target, &f.status); err != nil {
+		t.Fatalf("reconcileCluster returned error: %v", err)
+	}
+
+	if f.status.StartTime == nil {
+		t.Error("expected the rotation of the outdated nodepool to be started")
+	}
+	if f.status.NextCheckTime != nil {
+		t.Errorf("expected no next check time while the rotation is in progress, got %v", f.status.NextCheckTime)
+	}
+}
+
+// reviewerFunc reviews the plans with a function
+type reviewerFunc func(ctx context.Context, proposed plan.Plan) (plan.Decision, error)
+
+func (f reviewerFunc) Review(ctx context.Context, proposed plan.Plan) (plan.Decision, error) {
+	return f(ctx, proposed)
+}
+
+func TestDetect_VetoedPlanWaitsForNextReview(t *testing.T) {
+	f := newPhaseFixture(t)
+	var reviewed plan.Plan
+	f.reconciler.PlanReviewer = reviewerFunc(func(_ context.Context, proposed plan.Plan) (plan.Decision, error) {
+		reviewed = proposed
+		return plan.Decision{Reason: "change freeze", RetryAfter: 10 * time.Minute}, nil
+	})
+
+	phase, result := f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if result.RequeueAfter != 10*time.Minute {
+		t.Errorf("expected requeue after the retry time of the reviewer, got %v", result.RequeueAfter)
+	}
+	if len(reviewed.Nodepools) != 1 || reviewed.Nodepools[0].Name != testNodepoolName || reviewed.Nodepools[0].NodeImageVersion != testOldNodeImage {
+		t.Errorf("expected the outdated nodepool to be reviewed, got %v", reviewed.Nodepools)
+	}
+	if f.status.StartTime != nil {
+		t.Error("expected the vetoed rotation not to be started")
+	}
+	condition := meta.FindStatusCondition(f.status.Conditions, updatev1.ConditionPlanVetoed)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Message != "change freeze" {
+		t.Fatalf("expected the veto to be reported, got %v", f.status.Conditions)
+	}
+
+	f.reconciler.PlanReviewer = reviewerFunc(func(context.Context, plan.Plan) (plan.Decision, error) {
+		return plan.Decision{Allowed: true}, nil
+	})
+
+	phase, result = f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseProvisioningBackup, false)
+	if meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionPlanVetoed) {
+		t.Error("expected the veto to be cleared")
+	}
+}
+
+func TestDetect_FailingReviewKeepsRotationWaiting(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.reconciler.PlanReviewer = reviewerFunc(func(context.Context, plan.Plan) (plan.Decision, error) {
+		return plan.Decision{}, errors.New("mock review error")
+	})
+
+	phase, result, err := f.runFailingPhase(t, f.reconciler.detect)
+
+	if err == nil {
+		t.Fatal("expected the failure of the review to be returned")
+	}
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if f.status.StartTime != nil {
+		t.Error("expected the rotation not to be started without a review")
+	}
+}
+
+func TestDrain_LeavesNodepoolsRemovedFromPlan(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.addOutdatedNodepool(t, "userpool", 1)
+	f.reconciler.PlanReviewer = reviewerFunc(func(context.Context, plan.Plan) (plan.Decision, error) {
+		return plan.Decision{Allowed: true, Nodepools: []string{"userpool"}}, nil
+	})
+
+	phase, result := f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseProvisioningBackup, false)
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateSkipped)
+	expectNodepoolState(t, f.status, "userpool", updatev1.NodepoolStateInProgress)
+
+	f.status.Phase = updatev1.PhaseDraining
+	f.runPhase(t, f.reconciler.drain)
+
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the skipped nodepool not to be cordoned")
+	}
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 0 {
+		t.Error("expected the skipped nodepool not to be upgraded")
+	}
+	if f.agentPoolClient.UpgradeCount("userpool") != 1 {
+		t.Errorf("expected the planned nodepool to be upgraded once, got %d", f.agentPoolClient.UpgradeCount("userpool"))
+	}
+}
+
+func TestReconcile_AbortRollsBackRotationAndRemovesAnnotation(t *testing.T) {
+	f := newPhaseFixture(t)
+	temporaryNodepoolName := f.safeEvict.GetTemporaryNodepoolName()
+	if err := f.target.nodepoolController.CreateTemporaryNodePool(context.Background(), temporaryNodepoolName, testNodepoolName, nodepool.TemporaryNodePoolOverrides{}, f.safeEvict.GetOwnerTag()); err != nil {
+		t.Fatalf("failed to create temporary nodepool: %v", err)
+	}
+	f.saveScaling(t, map[string]string{testNodepoolName: `{"Count": 3}`})
+	if err := f.target.nodepoolController.CordonNodesByAgentPool(context.Background(), testNodepoolName, true); err != nil {
+		t.Fatalf("failed to cordon nodes: %v", err)
+	}
+	f.safeEvict.Annotations = map[string]string{updatev1.AbortAnnotation: "true"}
+	f.safeEvict.Status.Phase = updatev1.PhaseDraining
+	f.safeEvict.Status.StartTime = &metav1.Time{Time: time.Now()}
+	scheme := runtime.NewScheme()
+	if err := updatev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add the scheme: %v", err)
+	}
+	f.reconciler.Client = crfake.NewClientBuilder().WithScheme(scheme).WithObjects(f.safeEvict).WithStatusSubresource(f.safeEvict).Build()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: f.safeEvict.Name, Namespace: f.safeEvict.Namespace}}
+
+	if _, err := f.reconciler.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+
+	safeEvict := &updatev1.SafeEvict{}
+	if err := f.reconciler.Client.Get(context.Background(), req.NamespacedName, safeEvict); err != nil {
+		t.Fatalf("failed to get SafeEvict: %v", err)
+	}
+	if safeEvict.Status.Phase != updatev1.PhaseFailed {
+		t.Errorf("expected the aborted rotation to wait in phase Failed, got %s", safeEvict.Status.Phase)
+	}
+	condition := meta.FindStatusCondition(safeEvict.Status.Conditions, updatev1.ConditionAborted)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != updatev1.ReasonAbortRequested {
+		t.Errorf("expected the Aborted condition to be set, got %v", safeEvict.Status.Conditions)
+	}
+	if _, found := safeEvict.Annotations[updatev1.AbortAnnotation]; found {
+		t.Error("expected the abort annotation to be removed once the rotation is rolled back")
+	}
+	if count := *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count; count != 3 {
+		t.Errorf("expected the saved count to be restored, got %d", count)
+	}
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the node to be uncordoned")
+	}
+	if f.agentPoolClient.AgentPool(temporaryNodepoolName) != nil {
+		t.Error("expected the temporary nodepool of the aborted rotation to be removed")
+	}
+}
+
+func TestDetect_AbortStartsNoRotation(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.safeEvict.Annotations = map[string]string{updatev1.AbortAnnotation: "true"}
+
+	phase, result := f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	if f.status.StartTime != nil {
+		t.Error("expected no rotation to be started while an abort is requested")
+	}
+}
+
+func TestDrain_ReportsUpgradeBlockedByManagedCluster(t *testing.T) {
+	f := newPhaseFixture(t)
+	managedClusterClient, err := fake.NewManagedClusterClient()
+	if err != nil {
+		t.Fatalf("NewManagedClusterClient returned error: %v", err)
+	}
+	managedClusterClient.SetState(fake.ProvisioningStateUpdating, armcontainerservice.CodeRunning)
+	f.target.nodepoolController = f.target.nodepoolController.WithManagedClusterClient(managedClusterClient)
+
+	phase, result, err := f.runFailingPhase(t, f.reconciler.drain)
+
+	if !errors.Is(err, nodepool.ErrUpgradeNotPermitted) {
+		t.Fatalf("expected the upgrade not to be permitted, got %v", err)
+	}
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 0 {
+		t.Error("expected no upgrade while the managed cluster is updating")
+	}
+	if !meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionUpgradeBlocked) {
+		t.Errorf("expected the blocked upgrade to be reported, got %v", f.status.Conditions)
+	}
+
+	managedClusterClient.SetState(fake.ProvisioningStateSucceeded, armcontainerservice.CodeRunning)
+	f.runPhase(t, f.reconciler.drain)
+
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 1 {
+		t.Errorf("expected the nodepool to be upgraded once, got %d", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+	if meta.IsStatusConditionTrue(f.status.Conditions, updatev1.ConditionUpgradeBlocked) {
+		t.Error("expected the blocked upgrade to be cleared")
+	}
+}
+
+func TestDrain_UpgradesOutdatedNodepoolWithoutNodes(t *testing.T) {
+	f := newPhaseFixture(t)
+	f.agentPoolClient.AddAgentPool("emptypool", armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		Count:             to.Ptr(int32(0)),
+		EnableAutoScaling: to.Ptr(false),
+		Mode:              to.Ptr(armcontainerservice.AgentPoolModeUser),
+		NodeImageVersion:  to.Ptr(testOldNodeImage),
+	})
+	f.safeEvict.Spec.Nodepools = append(f.safeEvict.Spec.Nodepools, "emptypool")
+
+	f.runPhase(t, f.reconciler.detect)
+
+	expectNodepoolState(t, f.status, "emptypool", updatev1.NodepoolStateInProgress)
+
+	f.runPhase(t, f.reconciler.drain)
+
+	if f.agentPoolClient.UpgradeCount("emptypool") != 1 {
+		t.Errorf("expected the nodepool without nodes to be upgraded once, got %d", f.agentPoolClient.UpgradeCount("emptypool"))
+	}
+}
+
+func TestDetect_ComparesNodeImageVersionReportedByARM(t *testing.T) {
+	f := newPhaseFixture(t)
+	// the nodepool is upgraded, but its node still carries the label of the old node image
+	f.agentPoolClient.AddAgentPool(testNodepoolName, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		Count:            to.Ptr(int32(1)),
+		NodeImageVersion: to.Ptr(testLatestNodeImage),
+	})
+
+	phase, result := f.runPhase(t, f.reconciler.detect)
+
+	expectPhase(t, phase, result, updatev1.PhaseDetecting, true)
+	stragglers, err := f.target.nodepoolController.GetStragglerNodes(context.Background(), f.safeEvict.Spec.Nodepools)
+	if err != nil {
+		t.Fatalf("GetStragglerNodes returned error: %v", err)
+	}
+	if len(stragglers) != 1 || stragglers[0].Name != testNodepoolName+"-0" {
+		t.Errorf("expected the node with the old label to be a straggler, got %v", stragglers)
+	}
+}
+
+// useScaleSetVMs rolls the nodepool with the NodeReimage strategy, its nodes are ready scale set instances which get
+// the latest node image when they are reimaged
+func (f *phaseFixture) useScaleSetVMs(t *testing.T) *fake.ScaleSetVMsClient {
+	scaleSetVMsClient, err := fake.NewScaleSetVMsClient()
+	if err != nil {
+		t.Fatalf("NewScaleSetVMsClient returned error: %v", err)
+	}
+	f.safeEvict.Spec.UpgradeStrategy = updatev1.UpgradeStrategyNodeReimage
+	f.target.nodepoolController = f.target.nodepoolController.WithScaleSetVMsClient(scaleSetVMsClient)
+
+	createNode(t, f.kubeClient, testNodepoolName+"-1", testNodepoolName, testOldNodeImage)
+	for _, instanceID := range []string{"0", "1"} {
+		node := f.getNode(t, testNodepoolName+"-"+instanceID)
+		node.Spec.ProviderID = "azure:///subscriptions/" + fake.SubscriptionID + "/resourceGroups/mc_rg/providers/Microsoft.Compute/virtualMachineScaleSets/aks-" + testNodepoolName + "-vmss/virtualMachines/" + instanceID
+		node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+		if _, err := f.kubeClient.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update node: %v", err)
+		}
+	}
+	scaleSetVMsClient.OnReimage = func(_, instanceID string) {
+		node := f.getNode(t, testNodepoolName+"-"+instanceID)
+		node.Labels[nodeImageLabel] = testLatestNodeImage
+		_, _ = f.kubeClient.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{})
+	}
+	return scaleSetVMsClient
+}
+
+func TestDrain_ReimagesNodesOneByOne(t *testing.T) {
+	f := newPhaseFixture(t)
+	scaleSetVMsClient := f.useScaleSetVMs(t)
+	scaleSet := "aks-" + testNodepoolName + "-vmss"
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if scaleSetVMsClient.ReimageCount(scaleSet, "0") != 1 || scaleSetVMsClient.ReimageCount(scaleSet, "1") != 0 {
+		t.Errorf("expected only the first node to be reimaged, got %d and %d reimages", scaleSetVMsClient.ReimageCount(scaleSet, "0"), scaleSetVMsClient.ReimageCount(scaleSet, "1"))
+	}
+	if first := f.getNode(t, testNodepoolName+"-0"); !first.Spec.Unschedulable || first.Annotations[nodepool.ReimageStartedAnnotation] == "" {
+		t.Errorf("expected the reimaged node to be cordoned and annotated, got %v and %v", first.Spec.Unschedulable, first.Annotations)
+	}
+	if f.getNode(t, testNodepoolName+"-1").Spec.Unschedulable {
+		t.Error("expected the second node to stay schedulable while the first one is reimaged")
+	}
+
+	phase, result = f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the first node to be schedulable once it runs the latest node image")
+	}
+	if scaleSetVMsClient.ReimageCount(scaleSet, "1") != 1 {
+		t.Errorf("expected the second node to be reimaged after the first one, got %d reimages", scaleSetVMsClient.ReimageCount(scaleSet, "1"))
+	}
+
+	f.runPhase(t, f.reconciler.drain)
+	phase, result = f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseUpgrading, false)
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 0 {
+		t.Errorf("expected no nodepool upgrade with the NodeReimage strategy, got %d", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+}
+
+func TestDrain_ReimageReschedulesOnlyControllerOnTheNextNode(t *testing.T) {
+	tests := []struct {
+		name           string
+		controllerNode string
+		rescheduled    bool
+	}{
+		{"controller on another node of the nodepool", testNodepoolName + "-1", false},
+		{"controller on the next node", testNodepoolName + "-0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPhaseFixture(t)
+			scaleSetVMsClient := f.useScaleSetVMs(t)
+			scaleSet := "aks-" + testNodepoolName + "-vmss"
+			controllerPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "controller", Namespace: "node-updater-system"}, Spec: corev1.PodSpec{NodeName: tt.controllerNode}}
+			if _, err := f.kubeClient.CoreV1().Pods(controllerPod.Namespace).Create(context.Background(), controllerPod, metav1.CreateOptions{}); err != nil {
+				t.Fatalf("failed to create controller pod: %v", err)
+			}
+			f.target.selfExclusionController = selfexclusion.NewSelfExclusionController(f.kubeClient, controllerPod.Name, controllerPod.Namespace, tt.controllerNode, zaptest.NewLogger(t))
+
+			f.runPhase(t, f.reconciler.drain)
+
+			_, err := f.kubeClient.CoreV1().Pods(controllerPod.Namespace).Get(context.Background(), controllerPod.Name, metav1.GetOptions{})
+			if deleted := err != nil; deleted != tt.rescheduled {
+				t.Errorf("expected the controller pod deleted %t, got %v", tt.rescheduled, err)
+			}
+			expectedReimages := 1
+			if tt.rescheduled {
+				expectedReimages = 0
+			}
+			if reimages := scaleSetVMsClient.ReimageCount(scaleSet, "0"); reimages != expectedReimages {
+				t.Errorf("expected %d reimages of the next node, got %d", expectedReimages, reimages)
+			}
+		})
+	}
+}
+
+func TestDrain_FailsNodepoolWhenReimageKeepsOldImage(t *testing.T) {
+	f := newPhaseFixture(t)
+	scaleSetVMsClient := f.useScaleSetVMs(t)
+	// the scale set model still has the old image
+	scaleSetVMsClient.OnReimage = nil
+
+	f.runPhase(t, f.reconciler.drain)
+	_, _, err := f.runFailingPhase(t, f.reconciler.drain)
+
+	if err == nil {
+		t.Fatal("expected an error for a node which kept the old node image")
+	}
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateFailed)
+}
+
+// mockPodController scripts the pods a rotation sees instead of reading them from the cluster, the pods to evict are
+// recorded and left running
+type mockPodController struct {
+	pod.PodControllerInterface
+	safeToEvictPods []corev1.Pod
+	safeToEvictErr  error
+	evictErr        error
+	evicted         []string
+}
+
+func (m *mockPodController) GetSafeToEvictPods(_ context.Context, _ updatev1.SafeEvictSpec) ([]corev1.Pod, error) {
+	return m.safeToEvictPods, m.safeToEvictErr
+}
+
+func (m *mockPodController) EvictIdlePods(_ context.Context, pods []corev1.Pod, _ updatev1.SafeEvictSpec) error {
+	for _, agentPod := range pods {
+		m.evicted = append(m.evicted, agentPod.Name)
+	}
+	return m.evictErr
+}
+
+// useMockPodController replaces the pod controller of the rotation, the unscripted calls go to the real one
+func (f *phaseFixture) useMockPodController() *mockPodController {
+	mock := &mockPodController{PodControllerInterface: f.target.podController}
+	f.reconciler.PodController = mock
+	f.target.podController = mock
+	return mock
+}
+
+func TestDrain_FailsNodepoolWhenPodsCannotBeListed(t *testing.T) {
+	f := newPhaseFixture(t)
+	mock := f.useMockPodController()
+	mock.safeToEvictErr = errors.New("mock list error")
+
+	phase, result, err := f.runFailingPhase(t, f.reconciler.drain)
+
+	if err == nil {
+		t.Fatal("expected the failure of the nodepool to be returned")
+	}
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateFailed)
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 0 {
+		t.Error("expected no upgrade of a nodepool which could not be drained")
+	}
+}
+
+func TestDrain_EvictsOnlyPodsOfTheNodepool(t *testing.T) {
+	f := newPhaseFixture(t)
+	mock := f.useMockPodController()
+	mock.safeToEvictPods = []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "elsewhere", Namespace: testAgentNamespace}, Spec: corev1.PodSpec{NodeName: "other-0"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "idle-agent", Namespace: testAgentNamespace}, Spec: corev1.PodSpec{NodeName: testNodepoolName + "-0"}},
+	}
+
+	phase, result := f.runPhase(t, f.reconciler.drain)
+
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	if len(mock.evicted) != 1 || mock.evicted[0] != "idle-agent" {
+		t.Errorf("expected only the pod of the nodepool to be evicted, got %v", mock.evicted)
+	}
+}
+
+func TestDrain_FailsNodepoolWhenEvictionFails(t *testing.T) {
+	f := newPhaseFixture(t)
+	mock := f.useMockPodController()
+	mock.safeToEvictPods = []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "idle-agent", Namespace: testAgentNamespace}, Spec: corev1.PodSpec{NodeName: testNodepoolName + "-0"}},
+	}
+	mock.evictErr = errors.New("mock evict error")
+
+	phase, result, err := f.runFailingPhase(t, f.reconciler.drain)
+
+	if err == nil {
+		t.Fatal("expected the failure of the nodepool to be returned")
+	}
+	expectPhase(t, phase, result, updatev1.PhaseDraining, true)
+	expectNodepoolState(t, f.status, testNodepoolName, updatev1.NodepoolStateFailed)
+}
+
+func TestDrain_RecordsFailingDependencyOfNodepool(t *testing.T) {
+	f := newPhaseFixture(t)
+	mock := f.useMockPodController()
+	mock.safeToEvictPods = []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "idle-agent", Namespace: testAgentNamespace}, Spec: corev1.PodSpec{NodeName: testNodepoolName + "-0"}},
+	}
+	mock.evictErr = fmt.Errorf("failed to remove agent: %w", &azuredevops.RequestError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("failed to remove agent: status code 503")})
+
+	_, _, err := f.runFailingPhase(t, f.reconciler.drain)
+
+	if err == nil {
+		t.Fatal("expected the failure of the nodepool to be returned")
+	}
+	expectLastError(t, f.status, testNodepoolName, updatev1.ErrorCategoryDevOps, true)
+}
+
+func TestClassifyError(t *testing.T) {
+	node := schema.GroupResource{Resource: "nodes"}
+	tests := []struct {
+		name              string
+		err               error
+		expectedCategory  updatev1.ErrorCategory
+		expectedRetryable bool
+	}{
+		{"throttled by ARM", &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, updatev1.ErrorCategoryAzure, true},
+		{"refused by ARM", fmt.Errorf("failed to update: %w", &azcore.ResponseError{StatusCode: http.StatusBadRequest}), updatev1.ErrorCategoryAzure, false},
+		{"conflict with a running operation", &nodepool.RetryableError{NodePoolName: testNodepoolName, Err: &azcore.ResponseError{StatusCode: http.StatusConflict}}, updatev1.ErrorCategoryAzure, true},
+		{"failed agent pool", fmt.Errorf("%w: agent pool is Failed", nodepool.ErrProvisioningFailed), updatev1.ErrorCategoryAzure, false},
+		{"slow agent pool", fmt.Errorf("%w: agent pool is Updating", nodepool.ErrProvisioningTimeout), updatev1.ErrorCategoryAzure, true},
+		{"unreachable Azure DevOps", &azuredevops.RequestError{Err: errors.New("failed to send HTTP request")}, updatev1.ErrorCategoryDevOps, true},
+		{"unknown DevOps pool", &azuredevops.RequestError{StatusCode: http.StatusNotFound, Err: errors.New("pool with name 'agents' not found")}, updatev1.ErrorCategoryDevOps, false},
+		{"conflicting node update", fmt.Errorf("failed to cordon node: %w", apierrors.NewConflict(node, "agentpool-0", errors.New("modified"))), updatev1.ErrorCategoryKubernetes, true},
+		{"forbidden node update", apierrors.NewForbidden(node, "agentpool-0", errors.New("denied")), updatev1.ErrorCategoryKubernetes, false},
+		{"anything else", errors.New("boom"), updatev1.ErrorCategoryUnknown, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category, retryable := classifyError(tt.err)
+			if category != tt.expectedCategory || retryable != tt.expectedRetryable {
+				t.Errorf("expected %s with retryable %t, got %s with retryable %t", tt.expectedCategory, tt.expectedRetryable, category, retryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/priorityqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+// RequestedCheckPriority is the priority of the reconciles requested with the check-now or the abort annotation, they
+// are taken from the work queue before the periodic reconciles of the other SafeEvicts
+const RequestedCheckPriority = 100
+
+// requestAnnotations are the annotations a user requests a reconcile with
+var requestAnnotations = []string{updatev1.CheckNowAnnotation, updatev1.AbortAnnotation}
+
+// requeuePriorityQueue resets the priority of the requeues, the priority of the reconcile requested with an annotation
+// would otherwise be kept by every periodic reconcile which follows it
+type requeuePriorityQueue struct {
+	priorityqueue.PriorityQueue[reconcile.Request]
+}
+
+// newRequeuePriorityQueue is the NewQueue of the SafeEvict controller
+func newRequeuePriorityQueue(controllerName string, rateLimiter workqueue.TypedRateLimiter[reconcile.Request]) workqueue.TypedRateLimitingInterface[reconcile.Request] {
+	return requeuePriorityQueue{PriorityQueue: priorityqueue.New(controllerName, func(opts *priorityqueue.Opts[reconcile.Request]) {
+		opts.RateLimiter = rateLimiter
+	})}
+}
+
+// AddWithOpts implements priorityqueue.PriorityQueue, the controller requeues after a delay or with the rate limiter
+func (q requeuePriorityQueue) AddWithOpts(opts priorityqueue.AddOpts, items ...reconcile.Request) {
+	if opts.After > 0 || opts.RateLimited {
+		opts.Priority = 0
+	}
+	q.PriorityQueue.AddWithOpts(opts, items...)
+}
+
+// requestedCheckHandler enqueues the SafeEvicts like handler.EnqueueRequestForObject, a SafeEvict which got a check-now
+// or an abort annotation is enqueued with RequestedCheckPriority
+type requestedCheckHandler struct {
+	handler.EventHandler
+}
+
+func (h requestedCheckHandler) Create(ctx context.Context, e event.CreateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if requestsCheck(nil, e.Object) && addWithRequestedCheckPriority(q, e.Object) {
+		return
+	}
+	h.EventHandler.Create(ctx, e, q)
+}
+
+func (h requestedCheckHandler) Update(ctx context.Context, e event.UpdateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	if requestsCheck(e.ObjectOld, e.ObjectNew) && addWithRequestedCheckPriority(q, e.ObjectNew) {
+		return
+	}
+	h.EventHandler.Update(ctx, e, q)
+}
+
+// requestsCheck returns true when the new object got a request annotation the old object did not have
+func requestsCheck(oldObject, newObject client.Object) bool {
+	if newObject == nil {
+		return false
+	}
+	for _, annotation := range requestAnnotations {
+		value := newObject.GetAnnotations()[annotation]
+		if value != "" && (oldObject == nil || oldObject.GetAnnotations()[annotation] != value) {
+			return true
+		}
+	}
+	return false
+}
+
+// addWithRequestedCheckPriority enqueues the object with RequestedCheckPriority, it returns false when the queue has no
+// priorities
+func addWithRequestedCheckPriority(q workqueue.TypedRateLimitingInterface[reconcile.Request], object client.Object) bool {
+	priorityQueue, ok := q.(priorityqueue.PriorityQueue[reconcile.Request])
+	if !ok {
+		return false
+	}
+	priorityQueue.AddWithOpts(priorityqueue.AddOpts{Priority: RequestedCheckPriority}, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(object)})
+	return true
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/priorityqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+func newTestQueue(t *testing.T) priorityqueue.PriorityQueue[reconcile.Request] {
+	queue := newRequeuePriorityQueue("test", workqueue.DefaultTypedControllerRateLimiter[reconcile.Request]())
+	t.Cleanup(queue.ShutDown)
+	return queue.(priorityqueue.PriorityQueue[reconcile.Request])
+}
+
+func testSafeEvict(name, resourceVersion string, annotations map[string]string) *updatev1.SafeEvict {
+	return &updatev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{
+		Name: name, Namespace: "default", ResourceVersion: resourceVersion, Annotations: annotations,
+	}}
+}
+
+func TestRequestedCheckHandler_PrioritizesRequestedCheck(t *testing.T) {
+	queue := newTestQueue(t)
+	eventHandler := requestedCheckHandler{EventHandler: &handler.EnqueueRequestForObject{}}
+	checkNow := map[string]string{updatev1.CheckNowAnnotation: time.Now().Format(time.RFC3339)}
+
+	eventHandler.Update(context.Background(), event.UpdateEvent{
+		ObjectOld: testSafeEvict("periodic", "1", nil),
+		ObjectNew: testSafeEvict("periodic", "2", nil),
+	}, queue)
+	eventHandler.Update(context.Background(), event.UpdateEvent{
+		ObjectOld: testSafeEvict("already-requested", "1", checkNow),
+		ObjectNew: testSafeEvict("already-requested", "2", checkNow),
+	}, queue)
+	eventHandler.Update(context.Background(), event.UpdateEvent{
+		ObjectOld: testSafeEvict("requested", "1", nil),
+		ObjectNew: testSafeEvict("requested", "2", checkNow),
+	}, queue)
+
+	item, priority, _ := queue.GetWithPriority()
+	if item.Name != "requested" || priority != RequestedCheckPriority {
+		t.Errorf("expected the SafeEvict which got the annotation first with priority %d, got %s with %d", RequestedCheckPriority, item.Name, priority)
+	}
+	for range 2 {
+		item, priority, _ = queue.GetWithPriority()
+		if priority != 0 {
+			t.Errorf("expected %s to be enqueued with the default priority, got %d", item.Name, priority)
+		}
+	}
+}
+
+func TestRequeuePriorityQueue_ResetsPriorityOfRequeues(t *testing.T) {
+	queue := newTestQueue(t)
+	req := reconcile.Request{NamespacedName: client.ObjectKey{Namespace: "default", Name: "requested"}}
+
+	queue.AddWithOpts(priorityqueue.AddOpts{After: time.Millisecond, Priority: RequestedCheckPriority}, req)
+
+	if _, priority, _ := queue.GetWithPriority(); priority != 0 {
+		t.Errorf("expected the requeue to have the default priority, got %d", priority)
+	}
+}
@@ -0,0 +1,353 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/configmap"
+	"norbinto/node-updater/internal/nodepool"
+)
+
+// maxReconciles bounds the reconciles a rotation of the fixture may take, a rotation which never settles fails the test
+const maxReconciles = 20
+
+// failingNodePoolController fails the upgrade of the node pools, every other call goes to the real controller
+type failingNodePoolController struct {
+	nodepool.NodePoolControllerInterface
+	upgradeErr error
+	// hangUpgrade makes the upgrade hang until the context of the reconcile is done
+	hangUpgrade bool
+}
+
+func (c *failingNodePoolController) UpgradeNodeImageVersion(ctx context.Context, agentPool *armcontainerservice.AgentPool) error {
+	if c.hangUpgrade {
+		<-ctx.Done()
+		return errors.New("mock upgrade is cut")
+	}
+	if c.upgradeErr != nil {
+		return c.upgradeErr
+	}
+	return c.NodePoolControllerInterface.UpgradeNodeImageVersion(ctx, agentPool)
+}
+
+// WithLogFields keeps the failures in the copy the reconcile works with
+func (c *failingNodePoolController) WithLogFields(fields ...zap.Field) nodepool.NodePoolControllerInterface {
+	controller := *c
+	controller.NodePoolControllerInterface = c.NodePoolControllerInterface.WithLogFields(fields...)
+	return &controller
+}
+
+// failingConfigMapController fails the creation of ConfigMaps, every other call goes to the real controller
+type failingConfigMapController struct {
+	configmap.ConfigMapControllerInterface
+	createErr error
+}
+
+func (c *failingConfigMapController) CreateConfigMap(ctx context.Context, namespace string, name string, data map[string]string, owner *updatev1.SafeEvict) error {
+	if c.createErr != nil {
+		return c.createErr
+	}
+	return c.ConfigMapControllerInterface.CreateConfigMap(ctx, namespace, name, data, owner)
+}
+
+// WithLogFields keeps the failures in the copy the reconcile works with
+func (c *failingConfigMapController) WithLogFields(fields ...zap.Field) configmap.ConfigMapControllerInterface {
+	controller := *c
+	controller.ConfigMapControllerInterface = c.ConfigMapControllerInterface.WithLogFields(fields...)
+	return &controller
+}
+
+// reconcileFixture runs whole reconciles of the SafeEvict of a phaseFixture, the SafeEvict is stored in a fake client.
+// Every ARM operation takes a minute and the clock moves a minute between two reconciles, so an operation started by a
+// reconcile is finished by the next one and every phase of the rotation is seen.
+type reconcileFixture struct {
+	*phaseFixture
+	req ctrl.Request
+	// phases are the phases the rotation went through, in order
+	phases []updatev1.Phase
+}
+
+func newReconcileFixture(t *testing.T) *reconcileFixture {
+	f := newPhaseFixture(t)
+	scheme := runtime.NewScheme()
+	if err := updatev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add the scheme: %v", err)
+	}
+	f.reconciler.Client = crfake.NewClientBuilder().WithScheme(scheme).WithObjects(f.safeEvict).WithStatusSubresource(f.safeEvict).Build()
+	f.agentPoolClient.ProvisioningDuration = time.Minute
+	return &reconcileFixture{
+		phaseFixture: f,
+		req:          ctrl.Request{NamespacedName: types.NamespacedName{Name: f.safeEvict.Name, Namespace: f.safeEvict.Namespace}},
+	}
+}
+
+// reconcile runs a single reconcile and returns the SafeEvict it stored
+func (f *reconcileFixture) reconcile(t *testing.T) (*updatev1.SafeEvict, ctrl.Result, error) {
+	result, err := f.reconciler.Reconcile(context.Background(), f.req)
+	f.clock.SetTime(f.clock.Now().Add(f.agentPoolClient.ProvisioningDuration))
+	safeEvict := &updatev1.SafeEvict{}
+	if getErr := f.reconciler.Client.Get(context.Background(), f.req.NamespacedName, safeEvict); getErr != nil {
+		t.Fatalf("failed to get SafeEvict: %v", getErr)
+	}
+	if len(f.phases) == 0 || f.phases[len(f.phases)-1] != safeEvict.Status.Phase {
+		f.phases = append(f.phases, safeEvict.Status.Phase)
+	}
+	return safeEvict, result, err
+}
+
+// reconcileUntil reconciles until the rotation settles in the phase, it fails the test when a reconcile returns an error
+func (f *reconcileFixture) reconcileUntil(t *testing.T, phase updatev1.Phase) *updatev1.SafeEvict {
+	t.Helper()
+	for range maxReconciles {
+		safeEvict, _, err := f.reconcile(t)
+		if err != nil {
+			t.Fatalf("Reconcile returned error in phase %s: %v", safeEvict.Status.Phase, err)
+		}
+		if safeEvict.Status.Phase == phase {
+			return safeEvict
+		}
+	}
+	t.Fatalf("expected the rotation to reach %s within %d reconciles, it went through %v", phase, maxReconciles, f.phases)
+	return nil
+}
+
+// annotate sets the annotation on the stored SafeEvict
+func (f *reconcileFixture) annotate(t *testing.T, annotation, value string) {
+	safeEvict := &updatev1.SafeEvict{}
+	if err := f.reconciler.Client.Get(context.Background(), f.req.NamespacedName, safeEvict); err != nil {
+		t.Fatalf("failed to get SafeEvict: %v", err)
+	}
+	if safeEvict.Annotations == nil {
+		safeEvict.Annotations = map[string]string{}
+	}
+	safeEvict.Annotations[annotation] = value
+	if err := f.reconciler.Client.Update(context.Background(), safeEvict); err != nil {
+		t.Fatalf("failed to annotate SafeEvict: %v", err)
+	}
+}
+
+func TestReconcile_RotatesOutdatedNodepool(t *testing.T) {
+	f := newReconcileFixture(t)
+	f.createPod(t, "idle-agent", testNodepoolName+"-0", nil)
+
+	f.reconcileUntil(t, updatev1.PhaseCleaningUp)
+	safeEvict := f.reconcileUntil(t, updatev1.PhaseDetecting)
+
+	// the upgrade and the restore of the nodepool do not wait for ARM, they pass within the reconcile which cleans up
+	expected := []updatev1.Phase{updatev1.PhaseProvisioningBackup, updatev1.PhaseDraining, updatev1.PhaseCleaningUp, updatev1.PhaseDetecting}
+	if !slices.Equal(f.phases, expected) {
+		t.Errorf("expected the rotation to go through %v, got %v", expected, f.phases)
+	}
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 1 {
+		t.Errorf("expected the nodepool to be upgraded once, got %d", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+	if exists, _ := f.target.nodepoolController.NodePoolExists(context.Background(), f.safeEvict.GetTemporaryNodepoolName()); exists {
+		t.Error("expected the temporary nodepool to be removed")
+	}
+	if *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count != 2 {
+		t.Errorf("expected the scaling of the nodepool to be restored, got count %d", *f.agentPoolClient.AgentPool(testNodepoolName).Properties.Count)
+	}
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the upgraded node to be uncordoned")
+	}
+	if _, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.target.configmapName); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the saved scaling to be deleted, got %v", err)
+	}
+	expectNodepoolState(t, safeEvict.Status.RotationStatus, testNodepoolName, updatev1.NodepoolStateSucceeded)
+	if safeEvict.Status.NextCheckTime == nil {
+		t.Error("expected the next check to be scheduled after the rotation")
+	}
+}
+
+func TestReconcile_DoesNothingForUpToDateNodepools(t *testing.T) {
+	f := newReconcileFixture(t)
+	f.agentPoolClient.AddAgentPool(testNodepoolName, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		Count:             to.Ptr(int32(2)),
+		EnableAutoScaling: to.Ptr(false),
+		Mode:              to.Ptr(armcontainerservice.AgentPoolModeUser),
+		NodeImageVersion:  to.Ptr(testLatestNodeImage),
+	})
+	node := f.getNode(t, testNodepoolName+"-0")
+	node.Labels[nodeImageLabel] = testLatestNodeImage
+	if _, err := f.kubeClient.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update node: %v", err)
+	}
+
+	safeEvict, result, err := f.reconcile(t)
+
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+	if safeEvict.Status.Phase != updatev1.PhaseDetecting || result.RequeueAfter != f.reconciler.Config.Load().UpgradeFrequency {
+		t.Errorf("expected the rotation to wait in %s for the upgrade frequency, got %s after %v", updatev1.PhaseDetecting, safeEvict.Status.Phase, result.RequeueAfter)
+	}
+	if exists, _ := f.target.nodepoolController.NodePoolExists(context.Background(), f.safeEvict.GetTemporaryNodepoolName()); exists {
+		t.Error("expected no temporary nodepool without an outdated nodepool")
+	}
+}
+
+func TestReconcile_AttachesTheSafeEvictToTheLogEntries(t *testing.T) {
+	f := newReconcileFixture(t)
+	core, logs := observer.New(zapcore.DebugLevel)
+	f.reconciler.Logger = zap.New(core)
+
+	f.reconcile(t)
+
+	if logs.Len() == 0 {
+		t.Fatal("expected the reconcile to log")
+	}
+	for _, entry := range logs.All() {
+		fields := entry.ContextMap()
+		if fields["safeEvictNamespace"] != f.safeEvict.Namespace || fields["safeEvictName"] != f.safeEvict.Name || fields["reconcileID"] == nil {
+			t.Errorf("expected the SafeEvict and the reconcile ID on %q, got %v", entry.Message, fields)
+		}
+	}
+}
+
+func TestReconcile_FailsNodepoolWhoseUpgradeFails(t *testing.T) {
+	f := newReconcileFixture(t)
+	f.reconciler.NodepoolController = &failingNodePoolController{NodePoolControllerInterface: f.reconciler.NodepoolController, upgradeErr: errors.New("mock upgrade error")}
+
+	f.reconcileUntil(t, updatev1.PhaseProvisioningBackup)
+	safeEvict, result, err := f.reconcile(t)
+
+	if err == nil {
+		t.Fatalf("expected the failed upgrade to be returned, the rotation went through %v", f.phases)
+	}
+	if safeEvict.Status.Phase != updatev1.PhaseDraining || result.RequeueAfter != f.reconciler.Config.Load().ErrorReconcileTime {
+		t.Errorf("expected the rotation to retry in %s, got %s after %v", updatev1.PhaseDraining, safeEvict.Status.Phase, result.RequeueAfter)
+	}
+	expectNodepoolState(t, safeEvict.Status.RotationStatus, testNodepoolName, updatev1.NodepoolStateFailed)
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 0 {
+		t.Errorf("expected no upgrade to land, got %d", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+}
+
+func TestReconcile_RequeuesReconcileWhichRunsOutOfItsDeadline(t *testing.T) {
+	f := newReconcileFixture(t)
+	f.reconciler.NodepoolController = &failingNodePoolController{NodePoolControllerInterface: f.reconciler.NodepoolController, hangUpgrade: true}
+	f.reconcileUntil(t, updatev1.PhaseProvisioningBackup)
+	f.reconciler.ReconcileTimeout = 50 * time.Millisecond
+
+	safeEvict, result, err := f.reconcile(t)
+
+	if !errors.Is(err, errReconcileTimedOut) {
+		t.Fatalf("expected the reconcile to run out of its deadline, got %v", err)
+	}
+	if result.RequeueAfter != f.reconciler.Config.Load().ErrorReconcileTime {
+		t.Errorf("expected the reconcile to be requeued after %v, got %v", f.reconciler.Config.Load().ErrorReconcileTime, result.RequeueAfter)
+	}
+	ready := meta.FindStatusCondition(safeEvict.Status.Conditions, updatev1.ConditionReady)
+	if ready == nil || ready.Reason != updatev1.ReasonReconcileTimedOut {
+		t.Errorf("expected the Ready condition to record the timeout, got %v", safeEvict.Status.Conditions)
+	}
+	if safeEvict.Status.Phase != updatev1.PhaseDraining {
+		t.Errorf("expected the status to be saved after the deadline, got %s", safeEvict.Status.Phase)
+	}
+}
+
+func TestReconcile_RetriesWhenScalingCannotBeSaved(t *testing.T) {
+	f := newReconcileFixture(t)
+	f.reconciler.ConfigmapController = &failingConfigMapController{ConfigMapControllerInterface: f.reconciler.ConfigmapController, createErr: errors.New("mock create error")}
+
+	f.reconcileUntil(t, updatev1.PhaseProvisioningBackup)
+	f.annotate(t, updatev1.CheckNowAnnotation, time.Now().Format(time.RFC3339))
+	safeEvict, result, err := f.reconcile(t)
+
+	if err == nil {
+		t.Fatal("expected the failure to save the scaling to be returned")
+	}
+	if safeEvict.Status.Phase != updatev1.PhaseProvisioningBackup || result.RequeueAfter != f.reconciler.Config.Load().ErrorReconcileTime {
+		t.Errorf("expected the rotation to retry in %s, got %s after %v", updatev1.PhaseProvisioningBackup, safeEvict.Status.Phase, result.RequeueAfter)
+	}
+	if _, found := safeEvict.Annotations[updatev1.CheckNowAnnotation]; !found {
+		t.Error("expected the check-now annotation to be kept for the retry")
+	}
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the node not to be cordoned before the scaling is saved")
+	}
+	if !meta.IsStatusConditionFalse(safeEvict.Status.Conditions, updatev1.ConditionReady) {
+		t.Errorf("expected the SafeEvict not to be ready after the failed reconcile, got %v", safeEvict.Status.Conditions)
+	}
+}
+
+func TestReconcile_RollsBackAbortedRotation(t *testing.T) {
+	f := newReconcileFixture(t)
+	f.createPod(t, "busy-agent", testNodepoolName+"-0", map[string]string{"busy": "true"})
+	f.reconcileUntil(t, updatev1.PhaseDraining)
+	f.reconcile(t)
+	if !f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Fatal("expected the node to be cordoned while the busy pod is running")
+	}
+
+	f.annotate(t, updatev1.AbortAnnotation, "true")
+	safeEvict := f.reconcileUntil(t, updatev1.PhaseFailed)
+
+	if !meta.IsStatusConditionTrue(safeEvict.Status.Conditions, updatev1.ConditionAborted) {
+		t.Errorf("expected the Aborted condition to be set, got %v", safeEvict.Status.Conditions)
+	}
+	if f.getNode(t, testNodepoolName+"-0").Spec.Unschedulable {
+		t.Error("expected the node to be uncordoned by the rollback")
+	}
+	if exists, _ := f.target.nodepoolController.NodePoolExists(context.Background(), f.safeEvict.GetTemporaryNodepoolName()); exists {
+		t.Error("expected the temporary nodepool to be removed by the rollback")
+	}
+	if f.agentPoolClient.UpgradeCount(testNodepoolName) != 0 {
+		t.Errorf("expected the aborted nodepool not to be upgraded, got %d", f.agentPoolClient.UpgradeCount(testNodepoolName))
+	}
+	if _, found := safeEvict.Annotations[updatev1.AbortAnnotation]; found {
+		t.Error("expected the abort annotation to be removed once the rotation is rolled back")
+	}
+}
+
+func TestReconcile_MovesStateIntoStateNamespace(t *testing.T) {
+	f := newReconcileFixture(t)
+	f.createPod(t, "busy-agent", testNodepoolName+"-0", map[string]string{"busy": "true"})
+	f.reconcileUntil(t, updatev1.PhaseDraining)
+	saved, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.safeEvict.GetConfigmapName())
+	if err != nil {
+		t.Fatalf("expected the scaling to be saved next to the SafeEvict: %v", err)
+	}
+
+	f.reconciler.StateNamespace = "node-updater-system"
+	if _, _, err := f.reconcile(t); err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+
+	stateName := f.safeEvict.Namespace + "." + f.safeEvict.GetConfigmapName()
+	moved, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), "node-updater-system", stateName)
+	if err != nil || !maps.Equal(moved, saved) {
+		t.Fatalf("expected the saved scaling to be moved to the state namespace, got %v: %v", moved, err)
+	}
+	if _, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), f.safeEvict.Namespace, f.safeEvict.GetConfigmapName()); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the legacy ConfigMap to be deleted, got %v", err)
+	}
+
+	if err := f.reconciler.Client.Delete(context.Background(), f.safeEvict); err != nil {
+		t.Fatalf("failed to delete SafeEvict: %v", err)
+	}
+	if _, err := f.reconciler.Reconcile(context.Background(), f.req); err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+	if _, err := f.reconciler.ConfigmapController.GetConfigMapData(context.Background(), "node-updater-system", stateName); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the state of the deleted SafeEvict to be deleted, got %v", err)
+	}
+}
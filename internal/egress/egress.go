@@ -0,0 +1,88 @@
+// Package egress builds the HTTP transport of the outbound calls of the controller to ARM, Microsoft Entra ID, Azure
+// DevOps and the webhooks. It honours HTTPS_PROXY and NO_PROXY and trusts an additional CA bundle, many enterprise
+// clusters route their egress through a TLS-inspecting proxy.
+package egress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+)
+
+// directHosts are reached without the proxy: the instance metadata service and the wire server of Azure are link-local
+// addresses of the node, a proxy cannot reach them
+var directHosts = []string{"169.254.169.254", "168.63.129.16"}
+
+// Doer sends HTTP requests, it is satisfied by *http.Client and by the transports wrapping it, e.g. the chaos transport
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Proxy returns the proxy of the request from HTTPS_PROXY, HTTP_PROXY and NO_PROXY, the instance metadata service is
+// always reached directly
+func Proxy(req *http.Request) (*url.URL, error) {
+	if slices.Contains(directHosts, req.URL.Hostname()) {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// Timeouts bound the steps of an outbound call, so a hung endpoint cannot stall a reconcile. A zero timeout does not
+// bound its step.
+type Timeouts struct {
+	// Connect is the time the TCP connection may take to establish
+	Connect time.Duration
+	// TLSHandshake is the time the TLS handshake may take
+	TLSHandshake time.Duration
+	// ResponseHeader is the time the server may take to answer with its headers after the request was sent
+	ResponseHeader time.Duration
+	// Request is the time a whole call may take, including reading the body of the response. It is the timeout of the
+	// http.Client of the calls.
+	Request time.Duration
+}
+
+// DefaultTimeouts are the timeouts of the outbound calls unless the flags change them
+var DefaultTimeouts = Timeouts{
+	Connect:        10 * time.Second,
+	TLSHandshake:   10 * time.Second,
+	ResponseHeader: 30 * time.Second,
+	Request:        30 * time.Second,
+}
+
+// NewClient returns the client of the outbound calls through transport, its calls take Request at most
+func (t Timeouts) NewClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{Timeout: t.Request, Transport: transport}
+}
+
+// NewTransport returns the transport of the outbound calls. The certificates of the PEM file at caBundlePath are
+// trusted on top of the system certificates, an empty path trusts the system certificates only.
+func NewTransport(caBundlePath string, timeouts Timeouts) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = Proxy
+	transport.DialContext = (&net.Dialer{Timeout: timeouts.Connect, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeouts.TLSHandshake
+	transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+	if caBundlePath == "" {
+		return transport, nil
+	}
+
+	bundle, err := os.ReadFile(caBundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA bundle '%s': %w", caBundlePath, err)
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("CA bundle '%s' contains no PEM certificate", caBundlePath)
+	}
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	return transport, nil
+}
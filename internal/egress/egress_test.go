@@ -0,0 +1,97 @@
+package egress
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProxy_InstanceMetadataIsDirect(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://169.254.169.254/metadata/instance", nil)
+
+	proxy, err := Proxy(req)
+
+	if err != nil || proxy != nil {
+		t.Errorf("expected no proxy for the instance metadata service, got %v, %v", proxy, err)
+	}
+}
+
+func TestNewTransport_TrustsCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+	caBundlePath := filepath.Join(t.TempDir(), "ca.crt")
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caBundlePath, bundle, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name         string
+		caBundlePath string
+		trusted      bool
+	}{
+		{"system certificates", "", false},
+		{"CA bundle", caBundlePath, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := NewTransport(tt.caBundlePath, DefaultTimeouts)
+			if err != nil {
+				t.Fatalf("NewTransport returned error: %v", err)
+			}
+
+			resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+
+			if trusted := err == nil; trusted != tt.trusted {
+				t.Errorf("expected trusted %t, got %v", tt.trusted, err)
+			}
+		})
+	}
+}
+
+func TestNewTransport_InvalidCABundle(t *testing.T) {
+	caBundlePath := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caBundlePath, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTransport(caBundlePath, DefaultTimeouts); err == nil {
+		t.Error("expected an error for a CA bundle without certificates")
+	}
+}
+
+func TestNewTransport_TimesOutHungServer(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	for _, tt := range []struct {
+		name     string
+		timeouts Timeouts
+	}{
+		{"response header", Timeouts{ResponseHeader: 50 * time.Millisecond}},
+		{"request", Timeouts{Request: 50 * time.Millisecond}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := NewTransport("", tt.timeouts)
+			if err != nil {
+				t.Fatalf("NewTransport returned error: %v", err)
+			}
+
+			resp, err := tt.timeouts.NewClient(transport).Get(server.URL)
+			if err == nil {
+				_ = resp.Body.Close()
+				t.Fatal("expected the call to the hung server to time out")
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/azuredevops"
+)
+
+// armScope is the token scope of the Azure Resource Manager API
+const armScope = "https://management.azure.com/.default"
+
+// HealthChecker provides the readiness and liveness checks of the controller manager
+type HealthChecker struct {
+	client    client.Reader
+	azureCred azcore.TokenCredential
+	// azureDevopsController is checked by AzureDevOpsReadinessCheck, it may be nil when the integration is disabled
+	azureDevopsController azuredevops.AzureDevopsControllerInterface
+	livenessThreshold     time.Duration
+	logger                *zap.Logger
+	// elected is closed once the replica leads, a standby replica does not reconcile and is alive without progress
+	elected <-chan struct{}
+
+	mu        sync.Mutex
+	startedAt time.Time
+	// standby is set while the replica waits for the election, electedAt is when a standby replica was seen elected
+	standby              bool
+	electedAt            time.Time
+	lastFinishedAt       time.Time
+	inFlightReconcileSet map[types.NamespacedName]time.Time
+}
+
+// NewHealthChecker creates a HealthChecker. The liveness check fails when a reconcile runs longer than livenessThreshold, or when
+// SafeEvict resources exist and no reconcile has finished within livenessThreshold.
+func NewHealthChecker(client client.Reader, azureCred azcore.TokenCredential, livenessThreshold time.Duration, logger *zap.Logger) *HealthChecker {
+	elected := make(chan struct{})
+	close(elected)
+	return &HealthChecker{
+		client:               client,
+		azureCred:            azureCred,
+		livenessThreshold:    livenessThreshold,
+		logger:               logger,
+		elected:              elected,
+		startedAt:            time.Now(),
+		inFlightReconcileSet: make(map[types.NamespacedName]time.Time),
+	}
+}
+
+// WithElected sets the channel which is closed once the replica is elected leader, e.g. the Elected of the manager, and
+// returns the HealthChecker. Until then the liveness check does not expect any reconcile to finish.
+func (c *HealthChecker) WithElected(elected <-chan struct{}) *HealthChecker {
+	c.elected = elected
+	return c
+}
+
+// ReconcileStarted records the start of a reconcile for the given resource
+func (c *HealthChecker) ReconcileStarted(name types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlightReconcileSet[name] = time.Now()
+}
+
+// ReconcileFinished records that the reconcile of the given resource returned
+func (c *HealthChecker) ReconcileFinished(name types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inFlightReconcileSet, name)
+	c.lastFinishedAt = time.Now()
+}
+
+// WithAzureDevopsController sets the Azure DevOps integration which AzureDevOpsReadinessCheck verifies and returns the
+// HealthChecker
+func (c *HealthChecker) WithAzureDevopsController(azureDevopsController azuredevops.AzureDevopsControllerInterface) *HealthChecker {
+	c.azureDevopsController = azureDevopsController
+	return c
+}
+
+// ReadinessCheck verifies that a token can be acquired for ARM. Azure DevOps is checked by AzureDevOpsReadinessCheck,
+// which is registered on its own so it can be left out of the readiness.
+func (c *HealthChecker) ReadinessCheck(req *http.Request) error {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	_, err := c.azureCred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{armScope}})
+	if err != nil {
+		c.logger.Warn("Readiness check failed, unable to acquire token for Azure Resource Manager", zap.Error(err))
+		return fmt.Errorf("unable to acquire token for Azure Resource Manager: %w", err)
+	}
+	return nil
+}
+
+// AzureDevOpsReadinessCheck verifies that the Azure DevOps API is reachable and accepts the access token, it passes
+// while the integration is disabled. The readiness also admits the pod to the endpoints of the webhooks, which then stop
+// serving while Azure DevOps is down.
+func (c *HealthChecker) AzureDevOpsReadinessCheck(req *http.Request) error {
+	if !azuredevops.IsEnabled(c.azureDevopsController) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := c.azureDevopsController.CheckConnection(ctx); err != nil {
+		c.logger.Warn("Readiness check failed, Azure DevOps API is not reachable", zap.Error(err))
+		return fmt.Errorf("azure DevOps API is not reachable: %w", err)
+	}
+	return nil
+}
+
+// LivenessCheck detects a wedged reconcile loop of the leader, a standby replica is always alive
+func (c *HealthChecker) LivenessCheck(req *http.Request) error {
+	c.mu.Lock()
+	now := time.Now()
+	select {
+	case <-c.elected:
+	default:
+		c.standby = true
+		c.mu.Unlock()
+		return nil
+	}
+	// the progress of a former standby replica is measured from its election, it did not reconcile before
+	if c.standby {
+		c.standby = false
+		c.electedAt = now
+	}
+	for name, startedAt := range c.inFlightReconcileSet {
+		if now.Sub(startedAt) > c.livenessThreshold {
+			c.mu.Unlock()
+			c.logger.Error("Liveness check failed, reconcile is running for too long", zap.String("namespace", name.Namespace), zap.String("name", name.Name), zap.Duration("runningFor", now.Sub(startedAt)))
+			return fmt.Errorf("reconcile of '%s' is running for %s", name, now.Sub(startedAt))
+		}
+	}
+	lastProgress := c.lastFinishedAt
+	if lastProgress.IsZero() {
+		lastProgress = c.startedAt
+	}
+	if c.electedAt.After(lastProgress) {
+		lastProgress = c.electedAt
+	}
+	c.mu.Unlock()
+
+	if now.Sub(lastProgress) <= c.livenessThreshold {
+		return nil
+	}
+
+	// without SafeEvict resources there is nothing to reconcile, so an idle loop is healthy
+	safeEvictList := &updatev1.SafeEvictList{}
+	if err := c.client.List(req.Context(), safeEvictList, client.Limit(1)); err != nil {
+		c.logger.Warn("Unable to list SafeEvict resources for liveness check", zap.Error(err))
+		return nil
+	}
+	if len(safeEvictList.Items) == 0 {
+		return nil
+	}
+
+	c.logger.Error("Liveness check failed, no reconcile has finished recently", zap.Duration("sinceLastReconcile", now.Sub(lastProgress)))
+	return fmt.Errorf("no reconcile has finished in the last %s", now.Sub(lastProgress))
+}
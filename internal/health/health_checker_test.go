@@ -0,0 +1,149 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.uber.org/zap/zaptest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/azuredevops"
+)
+
+type fakeCredential struct {
+	err error
+}
+
+func (f *fakeCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, f.err
+}
+
+type fakeAzureDevopsController struct {
+	azuredevops.AzureDevopsControllerInterface
+	err error
+}
+
+func (f *fakeAzureDevopsController) CheckConnection(ctx context.Context) error {
+	return f.err
+}
+
+func newFakeClient(t *testing.T, objects ...*updatev1.SafeEvict) *fake.ClientBuilder {
+	scheme := runtime.NewScheme()
+	if err := updatev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add scheme: %v", err)
+	}
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for _, object := range objects {
+		builder = builder.WithObjects(object)
+	}
+	return builder
+}
+
+func TestReadinessCheck_TokenError(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	checker := NewHealthChecker(newFakeClient(t).Build(), &fakeCredential{err: errors.New("mock token error")}, time.Minute, logger)
+
+	err := checker.ReadinessCheck(httptest.NewRequest("GET", "/readyz", nil))
+	if err == nil {
+		t.Fatalf("Expected readiness check to fail, got nil")
+	}
+}
+
+func TestReadinessCheck_Success(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	checker := NewHealthChecker(newFakeClient(t).Build(), &fakeCredential{}, time.Minute, logger)
+
+	err := checker.ReadinessCheck(httptest.NewRequest("GET", "/readyz", nil))
+	if err != nil {
+		t.Fatalf("ReadinessCheck failed: %v", err)
+	}
+}
+
+func TestAzureDevOpsReadinessCheck(t *testing.T) {
+	tests := []struct {
+		name                  string
+		azureDevopsController azuredevops.AzureDevopsControllerInterface
+		expectError           bool
+	}{
+		{"disabled integration", nil, false},
+		{"reachable API", &fakeAzureDevopsController{}, false},
+		{"unreachable API", &fakeAzureDevopsController{err: errors.New("mock connection error")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewHealthChecker(newFakeClient(t).Build(), &fakeCredential{}, time.Minute, zaptest.NewLogger(t)).
+				WithAzureDevopsController(tt.azureDevopsController)
+
+			err := checker.AzureDevOpsReadinessCheck(httptest.NewRequest("GET", "/readyz", nil))
+			if tt.expectError != (err != nil) {
+				t.Fatalf("Expected error %v, got: %v", tt.expectError, err)
+			}
+		})
+	}
+}
+
+func TestLivenessCheck_WedgedReconcile(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	checker := NewHealthChecker(newFakeClient(t).Build(), &fakeCredential{}, time.Minute, logger)
+	name := types.NamespacedName{Namespace: "default", Name: "test"}
+	checker.inFlightReconcileSet[name] = time.Now().Add(-2 * time.Minute)
+
+	err := checker.LivenessCheck(httptest.NewRequest("GET", "/healthz", nil))
+	if err == nil {
+		t.Fatalf("Expected liveness check to fail, got nil")
+	}
+
+	checker.ReconcileFinished(name)
+	err = checker.LivenessCheck(httptest.NewRequest("GET", "/healthz", nil))
+	if err != nil {
+		t.Fatalf("LivenessCheck failed: %v", err)
+	}
+}
+
+func TestLivenessCheck_NoRecentReconcile(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	safeEvict := &updatev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	idleChecker := NewHealthChecker(newFakeClient(t).Build(), &fakeCredential{}, time.Minute, logger)
+	idleChecker.startedAt = time.Now().Add(-2 * time.Minute)
+	if err := idleChecker.LivenessCheck(httptest.NewRequest("GET", "/healthz", nil)); err != nil {
+		t.Fatalf("Expected idle controller without SafeEvicts to be alive, got: %v", err)
+	}
+
+	checker := NewHealthChecker(newFakeClient(t, safeEvict).Build(), &fakeCredential{}, time.Minute, logger)
+	checker.startedAt = time.Now().Add(-2 * time.Minute)
+	if err := checker.LivenessCheck(httptest.NewRequest("GET", "/healthz", nil)); err == nil {
+		t.Fatalf("Expected liveness check to fail, got nil")
+	}
+}
+
+func TestLivenessCheck_StandbyReplica(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	safeEvict := &updatev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	elected := make(chan struct{})
+	checker := NewHealthChecker(newFakeClient(t, safeEvict).Build(), &fakeCredential{}, time.Minute, logger).WithElected(elected)
+	checker.startedAt = time.Now().Add(-2 * time.Minute)
+
+	if err := checker.LivenessCheck(httptest.NewRequest("GET", "/healthz", nil)); err != nil {
+		t.Fatalf("Expected a standby replica without reconciles to be alive, got: %v", err)
+	}
+
+	// once elected, the replica gets the threshold from its election to finish a reconcile
+	close(elected)
+	if err := checker.LivenessCheck(httptest.NewRequest("GET", "/healthz", nil)); err != nil {
+		t.Fatalf("Expected a freshly elected replica to be alive, got: %v", err)
+	}
+	checker.electedAt = time.Now().Add(-2 * time.Minute)
+	if err := checker.LivenessCheck(httptest.NewRequest("GET", "/healthz", nil)); err == nil {
+		t.Fatalf("Expected the liveness check of the leader to fail without progress, got nil")
+	}
+}
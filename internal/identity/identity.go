@@ -0,0 +1,72 @@
+// Package identity tells Azure who is calling: the User-Agent of the controller and the correlation ID of the reconcile
+// which sent a request, so Azure support tickets and activity logs can be matched with the actions of the controller.
+package identity
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"norbinto/node-updater/internal/egress"
+)
+
+const (
+	// Name is the product name of the controller in its User-Agent
+	Name = "node-updater"
+	// CorrelationIDHeader carries the correlation ID of the reconcile, ARM records it in the activity log
+	CorrelationIDHeader = "x-ms-correlation-request-id"
+	// userAgentHeader is the header of the User-Agent
+	userAgentHeader = "User-Agent"
+)
+
+// Version is the version of the controller, it is set at build time with
+// -ldflags "-X norbinto/node-updater/internal/identity.Version=v1.2.3"
+var Version = "dev"
+
+// UserAgent returns the User-Agent of the controller, e.g. node-updater/v1.2.3. ARM clients take it as the application
+// ID of their telemetry, which is cut at 24 characters.
+func UserAgent() string {
+	return Name + "/" + Version
+}
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context whose requests to Azure carry the correlation ID
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, correlationID)
+}
+
+// CorrelationID returns the correlation ID of ctx, it is empty outside of a reconcile
+func CorrelationID(ctx context.Context) string {
+	correlationID, _ := ctx.Value(correlationIDKey{}).(string)
+	return correlationID
+}
+
+// CorrelationPolicy sends the correlation ID of the context of the request to ARM, it is added to the per-call
+// policies of the ARM clients so the retries of a request share it
+type CorrelationPolicy struct{}
+
+// Do implements policy.Policy
+func (CorrelationPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if correlationID := CorrelationID(req.Raw().Context()); correlationID != "" {
+		req.Raw().Header.Set(CorrelationIDHeader, correlationID)
+	}
+	return req.Next()
+}
+
+// UserAgentTransport sends the User-Agent of the controller with every request, it wraps the HTTP client of the Azure
+// DevOps calls
+type UserAgentTransport struct {
+	next egress.Doer
+}
+
+func NewUserAgentTransport(next egress.Doer) *UserAgentTransport {
+	return &UserAgentTransport{next: next}
+}
+
+// Do implements egress.Doer
+func (t *UserAgentTransport) Do(req *http.Request) (*http.Response, error) {
+	req.Header.Set(userAgentHeader, UserAgent())
+	return t.next.Do(req)
+}
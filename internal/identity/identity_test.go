@@ -0,0 +1,72 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// recordingTransport answers every request with 200 and keeps the last request
+type recordingTransport struct {
+	request *http.Request
+}
+
+func (t *recordingTransport) Do(req *http.Request) (*http.Response, error) {
+	t.request = req
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+}
+
+func TestCorrelationPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		correlationID string
+	}{
+		{name: "reconcile", correlationID: "0f8fad5b-d9cb-469f-a165-70867728950e"},
+		{name: "outside of a reconcile"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &recordingTransport{}
+			pipeline := runtime.NewPipeline("test", "v1", runtime.PipelineOptions{PerCall: []policy.Policy{CorrelationPolicy{}}},
+				&policy.ClientOptions{Transport: transport, Telemetry: policy.TelemetryOptions{ApplicationID: UserAgent()}})
+			ctx := context.Background()
+			if tt.correlationID != "" {
+				ctx = WithCorrelationID(ctx, tt.correlationID)
+			}
+			req, err := runtime.NewRequest(ctx, http.MethodGet, "https://management.azure.com/")
+			if err != nil {
+				t.Fatalf("NewRequest returned error: %v", err)
+			}
+
+			if _, err := pipeline.Do(req); err != nil {
+				t.Fatalf("Do returned error: %v", err)
+			}
+
+			if correlationID := transport.request.Header.Get(CorrelationIDHeader); correlationID != tt.correlationID {
+				t.Errorf("Expected correlation ID %q, got %q", tt.correlationID, correlationID)
+			}
+			if userAgent := transport.request.Header.Get("User-Agent"); !strings.HasPrefix(userAgent, "node-updater/dev ") {
+				t.Errorf("Expected the User-Agent of the controller before the one of the SDK, got %q", userAgent)
+			}
+		})
+	}
+}
+
+func TestUserAgentTransport(t *testing.T) {
+	transport := &recordingTransport{}
+	req, err := http.NewRequest(http.MethodGet, "https://dev.azure.com/org/_apis/distributedtask/pools", nil)
+	if err != nil {
+		t.Fatalf("NewRequest returned error: %v", err)
+	}
+
+	if _, err := NewUserAgentTransport(transport).Do(req); err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+
+	if userAgent := transport.request.Header.Get("User-Agent"); userAgent != "node-updater/dev" {
+		t.Errorf("Expected the User-Agent of the controller, got %q", userAgent)
+	}
+}
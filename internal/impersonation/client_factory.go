@@ -0,0 +1,66 @@
+package impersonation
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// serviceAccountUserNameFormat is the user name of a ServiceAccount as seen by the API server
+const serviceAccountUserNameFormat = "system:serviceaccount:%s:%s"
+
+// ClientFactory creates Kubernetes clients which impersonate a ServiceAccount, so the mutations of a SafeEvict are
+// authorized by the RBAC granted to its ServiceAccount instead of the controller's own identity
+type ClientFactory struct {
+	restConfig *rest.Config
+	logger     *zap.Logger
+
+	mu        sync.Mutex
+	clientSet map[string]kubernetes.Interface
+}
+
+func NewClientFactory(restConfig *rest.Config, logger *zap.Logger) *ClientFactory {
+	return &ClientFactory{
+		restConfig: restConfig,
+		logger:     logger,
+		clientSet:  make(map[string]kubernetes.Interface),
+	}
+}
+
+// ForServiceAccount returns a client impersonating the given ServiceAccount, clients are cached per ServiceAccount
+func (f *ClientFactory) ForServiceAccount(namespace, name string) (kubernetes.Interface, error) {
+	userName := fmt.Sprintf(serviceAccountUserNameFormat, namespace, name)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if kubeClient, exists := f.clientSet[userName]; exists {
+		return kubeClient, nil
+	}
+
+	f.logger.Debug("Creating impersonating client", zap.String("userName", userName))
+	kubeClient, err := kubernetes.NewForConfig(impersonatingConfig(f.restConfig, namespace, name))
+	if err != nil {
+		f.logger.Error("Failed to create impersonating client", zap.Error(err), zap.String("userName", userName))
+		return nil, fmt.Errorf("failed to create client impersonating '%s': %w", userName, err)
+	}
+	f.clientSet[userName] = kubeClient
+	return kubeClient, nil
+}
+
+// ConfigForServiceAccount returns a copy of the config of the factory which impersonates the given ServiceAccount, e.g.
+// to execute commands in pods in its name
+func (f *ClientFactory) ConfigForServiceAccount(namespace, name string) *rest.Config {
+	return impersonatingConfig(f.restConfig, namespace, name)
+}
+
+func impersonatingConfig(restConfig *rest.Config, namespace, name string) *rest.Config {
+	config := rest.CopyConfig(restConfig)
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: fmt.Sprintf(serviceAccountUserNameFormat, namespace, name),
+		Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace},
+	}
+	return config
+}
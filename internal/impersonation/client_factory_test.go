@@ -0,0 +1,46 @@
+package impersonation
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+	"k8s.io/client-go/rest"
+)
+
+func TestImpersonatingConfig(t *testing.T) {
+	restConfig := &rest.Config{Host: "https://cluster.example"}
+
+	config := impersonatingConfig(restConfig, "agents", "node-updater")
+	if config.Impersonate.UserName != "system:serviceaccount:agents:node-updater" {
+		t.Fatalf("Unexpected impersonated user name: %s", config.Impersonate.UserName)
+	}
+	if len(config.Impersonate.Groups) != 2 || config.Impersonate.Groups[1] != "system:serviceaccounts:agents" {
+		t.Fatalf("Unexpected impersonated groups: %v", config.Impersonate.Groups)
+	}
+	if restConfig.Impersonate.UserName != "" {
+		t.Fatalf("Expected the original config to be left untouched")
+	}
+}
+
+func TestForServiceAccount_CachesClients(t *testing.T) {
+	factory := NewClientFactory(&rest.Config{Host: "https://cluster.example"}, zaptest.NewLogger(t))
+
+	first, err := factory.ForServiceAccount("agents", "node-updater")
+	if err != nil {
+		t.Fatalf("ForServiceAccount failed: %v", err)
+	}
+	second, err := factory.ForServiceAccount("agents", "node-updater")
+	if err != nil {
+		t.Fatalf("ForServiceAccount failed: %v", err)
+	}
+	if first != second {
+		t.Fatalf("Expected the client to be cached")
+	}
+	other, err := factory.ForServiceAccount("other", "node-updater")
+	if err != nil {
+		t.Fatalf("ForServiceAccount failed: %v", err)
+	}
+	if other == first {
+		t.Fatalf("Expected a different client for a different ServiceAccount")
+	}
+}
@@ -0,0 +1,109 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"norbinto/node-updater/internal/audit"
+)
+
+// SuspendedByAnnotation holds the owner of the rotation which suspended a CronJob, only the CronJobs with the
+// annotation are resumed when the rotation is finished
+const SuspendedByAnnotation = "update.norbinto/suspended-by"
+
+// WithOwner returns a copy of the JobController which records the given owner on the CronJobs it suspends
+func (c *JobController) WithOwner(owner string) *JobController {
+	controller := *c
+	controller.owner = owner
+	return &controller
+}
+
+// suspendCronJob suspends the CronJob which owns the job until the rotation is finished. A CronJob which is already
+// suspended is left alone, so it is not resumed by the rotation either.
+func (c *JobController) suspendCronJob(ctx context.Context, agentJob *batchv1.Job) error {
+	var cronJobName string
+	for _, ownerRef := range agentJob.OwnerReferences {
+		if strings.ToLower(ownerRef.Kind) == "cronjob" {
+			cronJobName = ownerRef.Name
+			break
+		}
+	}
+	if cronJobName == "" {
+		return nil
+	}
+	if c.owner == "" {
+		// a CronJob suspended without an owner would never be resumed
+		c.logger.Debug("No owner to suspend the CronJob for, leaving it running", zap.String("cronJobName", cronJobName), zap.String("namespace", agentJob.Namespace))
+		return nil
+	}
+
+	cronJob, err := c.kubeClient.BatchV1().CronJobs(agentJob.Namespace).Get(ctx, cronJobName, metav1.GetOptions{})
+	if err != nil {
+		c.logger.Error("Failed to get CronJob", zap.String("cronJobName", cronJobName), zap.Error(err))
+		return fmt.Errorf("failed to get CronJob '%s' of job '%s': %w", cronJobName, agentJob.Name, err)
+	}
+	if cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend {
+		c.logger.Debug("CronJob is already suspended", zap.String("cronJobName", cronJobName), zap.String("namespace", agentJob.Namespace))
+		return nil
+	}
+
+	if err := c.patchCronJob(ctx, agentJob.Namespace, cronJobName, true, c.owner); err != nil {
+		return err
+	}
+	c.logger.Info("Suspended CronJob until the rotation is finished", zap.String("cronJobName", cronJobName), zap.String("namespace", agentJob.Namespace))
+	return nil
+}
+
+// ResumeCronJobs resumes the CronJobs of the namespaces which were suspended by the owner of the JobController
+func (c *JobController) ResumeCronJobs(ctx context.Context, namespaces []string) error {
+	for _, namespace := range namespaces {
+		cronJobs, err := c.kubeClient.BatchV1().CronJobs(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			c.logger.Error("Failed to list CronJobs", zap.String("namespace", namespace), zap.Error(err))
+			return fmt.Errorf("failed to list CronJobs in namespace %s: %w", namespace, err)
+		}
+		for _, cronJob := range cronJobs.Items {
+			if suspendedBy, exists := cronJob.Annotations[SuspendedByAnnotation]; !exists || suspendedBy != c.owner {
+				continue
+			}
+			if err := c.patchCronJob(ctx, namespace, cronJob.Name, false, ""); err != nil {
+				return err
+			}
+			c.logger.Info("Resumed CronJob suspended by the rotation", zap.String("cronJobName", cronJob.Name), zap.String("namespace", namespace))
+		}
+	}
+	return nil
+}
+
+// patchCronJob sets the suspension of the CronJob and its SuspendedByAnnotation, an empty owner removes the annotation
+func (c *JobController) patchCronJob(ctx context.Context, namespace, name string, suspend bool, owner string) error {
+	var suspendedBy any
+	if owner != "" {
+		suspendedBy = owner
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": map[string]any{SuspendedByAnnotation: suspendedBy}},
+		"spec":     map[string]any{"suspend": suspend},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create the patch of CronJob '%s': %w", name, err)
+	}
+	_, err = c.mutationClient.BatchV1().CronJobs(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	action := audit.ActionResumeCronJob
+	if suspend {
+		action = audit.ActionSuspendCronJob
+	}
+	audit.Write(ctx, action, audit.Target(audit.KindCronJob, namespace, name), err)
+	if err != nil {
+		c.logger.Error("Failed to patch CronJob", zap.String("cronJobName", name), zap.String("namespace", namespace), zap.Bool("suspend", suspend), zap.Error(err))
+		return fmt.Errorf("failed to patch CronJob '%s' in namespace %s: %w", name, namespace, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newCronJobPod(cronJobName string) (corev1.Pod, *batchv1.Job) {
+	agentJob := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:            cronJobName + "-1",
+		Namespace:       "default",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: cronJobName}},
+	}}
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:            cronJobName + "-1-pod",
+		Namespace:       "default",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: agentJob.Name}},
+	}}
+	return pod, agentJob
+}
+
+func TestKillJobByPod_SuspendsAndResumesCronJob(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newCronJobPod("agents")
+	pausedPod, pausedJob := newCronJobPod("paused")
+	kubeClient := fake.NewSimpleClientset(agentJob, pausedJob,
+		&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "agents", Namespace: "default"}},
+		&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "paused", Namespace: "default"}, Spec: batchv1.CronJobSpec{Suspend: to.Ptr(true)}})
+	controller := NewJobController(kubeClient, logger).WithOwner("default/rotation")
+
+	for _, agentPod := range []corev1.Pod{pod, pausedPod} {
+		if _, err := controller.KillJobByPod(context.TODO(), agentPod); err != nil {
+			t.Fatalf("KillJobByPod failed: %v", err)
+		}
+	}
+	cronJob, _ := kubeClient.BatchV1().CronJobs("default").Get(context.TODO(), "agents", metav1.GetOptions{})
+	if cronJob.Spec.Suspend == nil || !*cronJob.Spec.Suspend || cronJob.Annotations[SuspendedByAnnotation] != "default/rotation" {
+		t.Fatalf("Expected the CronJob to be suspended by the rotation, got: %v, %v", cronJob.Spec.Suspend, cronJob.Annotations)
+	}
+
+	// the CronJobs of another rotation are not resumed
+	if err := controller.WithOwner("default/other").ResumeCronJobs(context.TODO(), []string{"default"}); err != nil {
+		t.Fatalf("ResumeCronJobs failed: %v", err)
+	}
+	if cronJob, _ := kubeClient.BatchV1().CronJobs("default").Get(context.TODO(), "agents", metav1.GetOptions{}); !*cronJob.Spec.Suspend {
+		t.Fatalf("Expected the CronJob to stay suspended for its rotation")
+	}
+
+	if err := controller.ResumeCronJobs(context.TODO(), []string{"default"}); err != nil {
+		t.Fatalf("ResumeCronJobs failed: %v", err)
+	}
+	cronJob, _ = kubeClient.BatchV1().CronJobs("default").Get(context.TODO(), "agents", metav1.GetOptions{})
+	if *cronJob.Spec.Suspend || cronJob.Annotations[SuspendedByAnnotation] != "" {
+		t.Fatalf("Expected the CronJob to be resumed, got: %v, %v", *cronJob.Spec.Suspend, cronJob.Annotations)
+	}
+	if paused, _ := kubeClient.BatchV1().CronJobs("default").Get(context.TODO(), "paused", metav1.GetOptions{}); !*paused.Spec.Suspend {
+		t.Fatalf("Expected the CronJob suspended by the user to stay suspended")
+	}
+}
+
+func TestKillJobByPod_KeepsParallelIndexedJob(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	indexedJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "indexed", Namespace: "default"},
+		Spec: batchv1.JobSpec{
+			CompletionMode: to.Ptr(batchv1.IndexedCompletion),
+			Parallelism:    to.Ptr(int32(3)),
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(indexedJob)
+	controller := NewJobController(kubeClient, logger).WithPropagationPolicy(metav1.DeletePropagationBackground)
+
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:            "indexed-1",
+		Namespace:       "default",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: "indexed"}},
+	}}
+	cascades, err := controller.KillJobByPod(context.TODO(), pod)
+	if err != nil {
+		t.Fatalf("KillJobByPod failed: %v", err)
+	}
+	if cascades {
+		t.Fatalf("Expected the pod of the indexed job to be deleted on its own")
+	}
+	if _, err := kubeClient.BatchV1().Jobs("default").Get(context.TODO(), "indexed", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected the indexed job to be kept, got: %v", err)
+	}
+}
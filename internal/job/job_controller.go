@@ -7,30 +7,93 @@ import (
 
 	"go.uber.org/zap"
 
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+
+	"norbinto/node-updater/internal/audit"
 )
 
+// DefaultPropagationPolicy orphans the pods of a killed job like the API server does for a batch/v1 Job without a
+// policy, the pods are deleted on their own
+const DefaultPropagationPolicy = metav1.DeletePropagationOrphan
+
 type JobController struct {
 	kubeClient kubernetes.Interface
-	logger     *zap.Logger
+	// mutationClient deletes the jobs, it is the kubeClient unless WithMutationClient is used
+	mutationClient kubernetes.Interface
+	// propagationPolicy is sent with the deletion of a job, it decides whether its pods are deleted with it
+	propagationPolicy metav1.DeletionPropagation
+	// owner is recorded on the CronJobs suspended by the JobController, it is set with WithOwner
+	owner  string
+	logger *zap.Logger
 }
 
 func NewJobController(kubeClient kubernetes.Interface, logger *zap.Logger) *JobController {
 	return &JobController{
-		kubeClient: kubeClient,
-		logger:     logger,
+		kubeClient:        kubeClient,
+		mutationClient:    kubeClient,
+		propagationPolicy: DefaultPropagationPolicy,
+		logger:            logger,
+	}
+}
+
+// ParsePropagationPolicy returns the deletion propagation policy of the name, Background, Foreground or Orphan
+func ParsePropagationPolicy(name string) (metav1.DeletionPropagation, error) {
+	for _, policy := range []metav1.DeletionPropagation{metav1.DeletePropagationBackground, metav1.DeletePropagationForeground, metav1.DeletePropagationOrphan} {
+		if strings.EqualFold(name, string(policy)) {
+			return policy, nil
+		}
 	}
+	return "", fmt.Errorf("unknown propagation policy '%s', expected Background, Foreground or Orphan", name)
+}
+
+// WithPropagationPolicy returns a copy of the JobController which deletes jobs with the given propagation policy
+func (c *JobController) WithPropagationPolicy(propagationPolicy metav1.DeletionPropagation) *JobController {
+	controller := *c
+	controller.propagationPolicy = propagationPolicy
+	return &controller
+}
+
+// Cascades returns true when the deletion of a job also deletes its pods, they do not need to be deleted on their own
+func (c *JobController) Cascades() bool {
+	return c.propagationPolicy != metav1.DeletePropagationOrphan
+}
+
+// WithMutationClient returns a copy of the JobController which deletes jobs with the given client
+func (c *JobController) WithMutationClient(mutationClient kubernetes.Interface) *JobController {
+	controller := *c
+	controller.mutationClient = mutationClient
+	return &controller
 }
 
-func (c *JobController) KillJobByPod(ctx context.Context, pod v1.Pod) error {
+// WithLogFields returns a copy of the JobController which attaches the fields to every log entry
+func (c *JobController) WithLogFields(fields ...zap.Field) *JobController {
+	controller := *c
+	controller.logger = c.logger.With(fields...)
+	return &controller
+}
+
+// WithKubeClient returns a copy of the JobController which works on the cluster of the given client
+func (c *JobController) WithKubeClient(kubeClient kubernetes.Interface) *JobController {
+	controller := *c
+	controller.kubeClient = kubeClient
+	controller.mutationClient = kubeClient
+	return &controller
+}
+
+// KillJobByPod deletes the job of the pod and returns true when the deletion also deletes the pod. The CronJob of the
+// job is suspended first, so it does not start a new agent on an outdated node. An indexed job which runs agents in
+// parallel is kept, the pod of its index has to be deleted on its own and the job recreates it elsewhere.
+func (c *JobController) KillJobByPod(ctx context.Context, pod v1.Pod) (bool, error) {
 	c.logger.Debug("Attempting to kill job", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
 
 	// Check if the pod has an owner reference (e.g., a job)
 	if len(pod.OwnerReferences) == 0 {
 		c.logger.Warn("Pod has no owner references", zap.String("podName", pod.Name))
-		return fmt.Errorf("pod %s has no owner references", pod.Name)
+		return false, fmt.Errorf("pod %s has no owner references", pod.Name)
 	}
 
 	// Find the owner reference of kind "Job"
@@ -44,16 +107,43 @@ func (c *JobController) KillJobByPod(ctx context.Context, pod v1.Pod) error {
 
 	if jobName == "" {
 		c.logger.Warn("No job owner found for pod", zap.String("podName", pod.Name))
-		return fmt.Errorf("no job owner found for pod %s", pod.Name)
+		return false, fmt.Errorf("no job owner found for pod %s", pod.Name)
+	}
+
+	// a job which is already gone is deleted anyway, the deletion reports it
+	agentJob, err := c.kubeClient.BatchV1().Jobs(pod.Namespace).Get(ctx, jobName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		c.logger.Error("Failed to get job", zap.String("jobName", jobName), zap.Error(err))
+		return false, fmt.Errorf("failed to get job: %w", err)
+	}
+	if err == nil {
+		if runsParallelIndexes(agentJob) {
+			c.logger.Info("Job runs indexed agents in parallel, keeping it", zap.String("jobName", jobName), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+			return false, nil
+		}
+		if err := c.suspendCronJob(ctx, agentJob); err != nil {
+			return false, err
+		}
 	}
 
 	// Delete the job
-	err := c.kubeClient.BatchV1().Jobs(pod.Namespace).Delete(ctx, jobName, metav1.DeleteOptions{})
+	err = c.mutationClient.BatchV1().Jobs(pod.Namespace).Delete(ctx, jobName, metav1.DeleteOptions{PropagationPolicy: &c.propagationPolicy})
+	audit.Write(ctx, audit.ActionDeleteJob, audit.Target(audit.KindJob, pod.Namespace, jobName), err)
 	if err != nil {
 		c.logger.Error("Failed to delete job", zap.String("jobName", jobName), zap.Error(err))
-		return fmt.Errorf("failed to delete job: %w", err)
+		return false, fmt.Errorf("failed to delete job: %w", err)
 	}
 
-	c.logger.Debug("Successfully killed job", zap.String("jobName", jobName))
-	return nil
+	c.logger.Debug("Successfully killed job", zap.String("jobName", jobName), zap.String("propagationPolicy", string(c.propagationPolicy)))
+	return c.Cascades(), nil
+}
+
+// runsParallelIndexes returns true when the job is indexed and runs more than one pod at a time, deleting it would kill
+// the agents of the other indexes, which may run on up to date nodes
+func runsParallelIndexes(agentJob *batchv1.Job) bool {
+	if agentJob.Spec.CompletionMode == nil || *agentJob.Spec.CompletionMode != batchv1.IndexedCompletion {
+		return false
+	}
+	// the API server defaults the parallelism to 1
+	return agentJob.Spec.Parallelism != nil && *agentJob.Spec.Parallelism > 1
 }
@@ -37,7 +37,7 @@ func TestKillJobByPod_Success(t *testing.T) {
 		},
 	}
 
-	err := controller.KillJobByPod(context.TODO(), pod)
+	_, err := controller.KillJobByPod(context.TODO(), pod)
 	if err != nil {
 		t.Fatalf("KillJobByPod failed: %v", err)
 	}
@@ -61,7 +61,7 @@ func TestKillJobByPod_NoOwnerReferences(t *testing.T) {
 		},
 	}
 
-	err := controller.KillJobByPod(context.TODO(), pod)
+	_, err := controller.KillJobByPod(context.TODO(), pod)
 	if err == nil || err.Error() != "pod test-pod has no owner references" {
 		t.Fatalf("Expected no owner references error, got: %v", err)
 	}
@@ -85,7 +85,7 @@ func TestKillJobByPod_NoJobOwner(t *testing.T) {
 		},
 	}
 
-	err := controller.KillJobByPod(context.TODO(), pod)
+	_, err := controller.KillJobByPod(context.TODO(), pod)
 	if err == nil || err.Error() != "no job owner found for pod test-pod" {
 		t.Fatalf("Expected no job owner error, got: %v", err)
 	}
@@ -112,8 +112,43 @@ func TestKillJobByPod_DeleteError(t *testing.T) {
 		},
 	}
 
-	err := controller.KillJobByPod(context.TODO(), pod)
+	_, err := controller.KillJobByPod(context.TODO(), pod)
 	if err == nil || err.Error() != "failed to delete job: mock delete error" {
 		t.Fatalf("Expected mock delete error, got: %v", err)
 	}
 }
+
+func TestKillJobByPod_PropagationPolicy(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset(&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test-job", Namespace: "default"}})
+	var propagationPolicy *metav1.DeletionPropagation
+	kubeClient.PrependReactor("delete", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		propagationPolicy = action.(k8stesting.DeleteActionImpl).DeleteOptions.PropagationPolicy
+		return false, nil, nil
+	})
+	controller := NewJobController(kubeClient, logger).WithPropagationPolicy(metav1.DeletePropagationForeground)
+
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:            "test-pod",
+		Namespace:       "default",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: "test-job"}},
+	}}
+	if _, err := controller.KillJobByPod(context.TODO(), pod); err != nil {
+		t.Fatalf("KillJobByPod failed: %v", err)
+	}
+	if propagationPolicy == nil || *propagationPolicy != metav1.DeletePropagationForeground {
+		t.Fatalf("Expected the job to be deleted in the foreground, got: %v", propagationPolicy)
+	}
+	if !controller.Cascades() || NewJobController(kubeClient, logger).Cascades() {
+		t.Fatalf("Expected only the deletion in the foreground to cascade")
+	}
+}
+
+func TestParsePropagationPolicy(t *testing.T) {
+	if policy, err := ParsePropagationPolicy("background"); err != nil || policy != metav1.DeletePropagationBackground {
+		t.Fatalf("Expected Background, got: %v, %v", policy, err)
+	}
+	if _, err := ParsePropagationPolicy("cascade"); err == nil {
+		t.Fatalf("Expected an unknown policy to fail")
+	}
+}
@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/utils/clock"
+)
+
+// Deduper drops the repeats of the Info and Debug entries which are logged by every reconcile of a steady cluster, e.g.
+// that a nodepool is up to date. An entry is a repeat when its logger, level, message and fields equal those of an entry
+// written less than the window ago. The first entry after the window is written with the number of dropped repeats
+// in the repeated field. Warnings and errors are always written.
+type Deduper struct {
+	window time.Duration
+	// ignoredFields are left out of the comparison, e.g. the reconcile ID which differs between two reconciles
+	ignoredFields map[string]bool
+	clock         clock.PassiveClock
+
+	mu   sync.Mutex
+	seen map[string]*seenEntry
+	// swept is the time the entries which were not repeated were removed last
+	swept time.Time
+}
+
+type seenEntry struct {
+	written time.Time
+	dropped int
+}
+
+// NewDeduper creates a Deduper which drops the repeats within window, the ignoredFields do not tell two entries apart
+func NewDeduper(window time.Duration, ignoredFields ...string) *Deduper {
+	ignored := make(map[string]bool, len(ignoredFields))
+	for _, field := range ignoredFields {
+		ignored[field] = true
+	}
+	return &Deduper{
+		window:        window,
+		ignoredFields: ignored,
+		clock:         clock.RealClock{},
+		seen:          map[string]*seenEntry{},
+	}
+}
+
+// Core wraps core so the repeats of its entries are dropped, it is passed to zap.WrapCore
+func (d *Deduper) Core(core zapcore.Core) zapcore.Core {
+	return &dedupeCore{Core: core, deduper: d}
+}
+
+// key identifies the entry with its fields and the fields of its logger
+func (d *Deduper) key(entry zapcore.Entry, context string, fields []zapcore.Field) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", entry.LoggerName, entry.Level, entry.Message, context, d.encode(fields))
+}
+
+// encode returns the fields which are not ignored in a comparable form, the keys are sorted
+func (d *Deduper) encode(fields []zapcore.Field) string {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		if !d.ignoredFields[field.Key] {
+			field.AddTo(encoder)
+		}
+	}
+	if len(encoder.Fields) == 0 {
+		return ""
+	}
+	return fmt.Sprint(encoder.Fields)
+}
+
+// record returns whether the entry is written and the number of its repeats which were dropped before
+func (d *Deduper) record(key string) (bool, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.clock.Now()
+	if now.Sub(d.swept) >= d.window {
+		// an entry with dropped repeats is kept for another window, so its next write reports them
+		for seenKey, seen := range d.seen {
+			age := now.Sub(seen.written)
+			if (age >= d.window && seen.dropped == 0) || age >= 2*d.window {
+				delete(d.seen, seenKey)
+			}
+		}
+		d.swept = now
+	}
+
+	seen, ok := d.seen[key]
+	if ok && now.Sub(seen.written) < d.window {
+		seen.dropped++
+		return false, 0
+	}
+	dropped := 0
+	if ok {
+		dropped = seen.dropped
+	}
+	d.seen[key] = &seenEntry{written: now}
+	return true, dropped
+}
+
+type dedupeCore struct {
+	zapcore.Core
+	deduper *Deduper
+	// context is the encoded fields of the logger
+	context string
+}
+
+func (c *dedupeCore) With(fields []zapcore.Field) zapcore.Core {
+	return &dedupeCore{
+		Core:    c.Core.With(fields),
+		deduper: c.deduper,
+		context: c.context + c.deduper.encode(fields),
+	}
+}
+
+func (c *dedupeCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(entry.Level) {
+		return checked
+	}
+	if entry.Level >= zapcore.WarnLevel {
+		return c.Core.Check(entry, checked)
+	}
+	return checked.AddCore(entry, c)
+}
+
+func (c *dedupeCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	written, dropped := c.deduper.record(c.deduper.key(entry, c.context, fields))
+	if !written {
+		return nil
+	}
+	if dropped > 0 {
+		fields = append(fields, zap.Int("repeated", dropped))
+	}
+	return c.Core.Write(entry, fields)
+}
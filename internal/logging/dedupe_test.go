@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func newDedupedLogger(deduper *Deduper) (*zap.Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	return zap.New(core, zap.WrapCore(deduper.Core)), logs
+}
+
+func TestDeduper_DropsRepeatsWithinTheWindow(t *testing.T) {
+	fakeClock := testingclock.NewFakePassiveClock(time.Now())
+	deduper := NewDeduper(10*time.Minute, "reconcileID")
+	deduper.clock = fakeClock
+	logger, logs := newDedupedLogger(deduper)
+
+	for _, reconcileID := range []string{"1", "2", "3"} {
+		reconcileLogger := logger.With(zap.String("reconcileID", reconcileID))
+		reconcileLogger.Info("Node pool is already up to date", zap.String("nodepoolName", "np1"))
+		reconcileLogger.Info("Node pool is already up to date", zap.String("nodepoolName", "np2"))
+		reconcileLogger.Warn("Node pool is still updating", zap.String("nodepoolName", "np1"))
+		fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+	}
+	if up := logs.FilterMessage("Node pool is already up to date").Len(); up != 2 {
+		t.Errorf("expected the entry of every nodepool once, got %d entries", up)
+	}
+	if warnings := logs.FilterLevelExact(zapcore.WarnLevel).Len(); warnings != 3 {
+		t.Errorf("expected every warning, got %d", warnings)
+	}
+
+	fakeClock.SetTime(fakeClock.Now().Add(10 * time.Minute))
+	logger.Info("Node pool is already up to date", zap.String("nodepoolName", "np1"))
+
+	entries := logs.FilterMessage("Node pool is already up to date").FilterField(zap.String("nodepoolName", "np1")).All()
+	if len(entries) != 2 {
+		t.Fatalf("expected the entry to be written again after the window, got %d entries", len(entries))
+	}
+	if repeated := entries[1].ContextMap()["repeated"]; repeated != int64(2) {
+		t.Errorf("expected the dropped repeats to be counted, got %v", repeated)
+	}
+}
+
+func TestDeduper_TellsLoggersApart(t *testing.T) {
+	logger, logs := newDedupedLogger(NewDeduper(time.Hour))
+
+	logger.Named("nodepool").Debug("Retrieving node pool")
+	logger.Named("pod").Debug("Retrieving node pool")
+	logger.Named("nodepool").With(zap.String("cluster", "a")).Debug("Retrieving node pool")
+	logger.Named("nodepool").Debug("Retrieving node pool")
+
+	if logs.Len() != 3 {
+		t.Errorf("expected the entries of other loggers and fields to be written, got %d entries", logs.Len())
+	}
+}
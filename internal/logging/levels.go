@@ -0,0 +1,194 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Levels holds the log level of the components of the controller, so a single subsystem can be debugged at runtime
+// without the Debug logs of the others. A component is the first name of a named logger, e.g. nodepool for
+// logger.Named("nodepool") and its children. The components without a level of their own log at the default level.
+type Levels struct {
+	defaultLevel zap.AtomicLevel
+	mu           sync.RWMutex
+	components   map[string]zap.AtomicLevel
+}
+
+// NewLevels creates Levels which log every component at defaultLevel
+func NewLevels(defaultLevel zapcore.Level) *Levels {
+	return &Levels{
+		defaultLevel: zap.NewAtomicLevelAt(defaultLevel),
+		components:   map[string]zap.AtomicLevel{},
+	}
+}
+
+// Level returns the level the component logs at
+func (l *Levels) Level(component string) zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if level, ok := l.components[component]; ok {
+		return level.Level()
+	}
+	return l.defaultLevel.Level()
+}
+
+// SetDefault changes the level of the components without a level of their own
+func (l *Levels) SetDefault(level zapcore.Level) {
+	l.defaultLevel.SetLevel(level)
+}
+
+// Set changes the level of the component
+func (l *Levels) Set(component string, level zapcore.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if current, ok := l.components[component]; ok {
+		current.SetLevel(level)
+		return
+	}
+	l.components[component] = zap.NewAtomicLevelAt(level)
+}
+
+// Reset makes the component log at the default level again
+func (l *Levels) Reset(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.components, component)
+}
+
+// Apply replaces the levels of all components, the components missing from levels log at the default level again
+func (l *Levels) Apply(levels map[string]zapcore.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	components := make(map[string]zap.AtomicLevel, len(levels))
+	for component, level := range levels {
+		components[component] = zap.NewAtomicLevelAt(level)
+	}
+	l.components = components
+}
+
+// Components returns the levels of the components which do not log at the default level
+func (l *Levels) Components() map[string]zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	levels := make(map[string]zapcore.Level, len(l.components))
+	for component, level := range l.components {
+		levels[component] = level.Level()
+	}
+	return levels
+}
+
+// Enabled implements zapcore.LevelEnabler, a level is enabled when any component logs at it. It is the level of the
+// core wrapped by Core, which drops the entries of the components logging at a higher level.
+func (l *Levels) Enabled(level zapcore.Level) bool {
+	if l.defaultLevel.Enabled(level) {
+		return true
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, componentLevel := range l.components {
+		if componentLevel.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Core wraps core so it only writes the entries enabled for the component of their logger, it is passed to
+// zap.WrapCore
+func (l *Levels) Core(core zapcore.Core) zapcore.Core {
+	return &levelCore{Core: core, levels: l}
+}
+
+// component returns the component of a logger name, names of child loggers are joined with a dot
+func component(loggerName string) string {
+	name, _, _ := strings.Cut(loggerName, ".")
+	return name
+}
+
+type levelCore struct {
+	zapcore.Core
+	levels *Levels
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.levels.Enabled(level) && c.Core.Enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level < c.levels.Level(component(entry.LoggerName)) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// levelsPayload is the body of the debug endpoint
+type levelsPayload struct {
+	Default    string            `json:"default"`
+	Components map[string]string `json:"components,omitempty"`
+}
+
+// levelRequest changes the level of a component, or the default level without a component. An empty level makes the
+// component log at the default level again.
+type levelRequest struct {
+	Component string `json:"component,omitempty"`
+	Level     string `json:"level,omitempty"`
+}
+
+// ServeHTTP is the debug endpoint of the levels. GET returns the default level and the levels of the components, PUT
+// changes one level with a body like {"component": "nodepool", "level": "debug"} and returns the levels.
+func (l *Levels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if err := l.update(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload := levelsPayload{Default: l.defaultLevel.Level().String(), Components: map[string]string{}}
+	for component, level := range l.Components() {
+		payload.Components[component] = level.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func (l *Levels) update(r *http.Request) error {
+	var request levelRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	if request.Level == "" {
+		if request.Component == "" {
+			return errors.New("level is required for the default level")
+		}
+		l.Reset(request.Component)
+		return nil
+	}
+	level, err := zapcore.ParseLevel(request.Level)
+	if err != nil {
+		return err
+	}
+	if request.Component == "" {
+		l.SetDefault(level)
+		return nil
+	}
+	l.Set(request.Component, level)
+	return nil
+}
@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newObservedLogger(levels *Levels) (*zap.Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(levels)
+	return zap.New(core, zap.WrapCore(levels.Core)), logs
+}
+
+func TestLevels_FiltersByComponent(t *testing.T) {
+	levels := NewLevels(zapcore.InfoLevel)
+	logger, logs := newObservedLogger(levels)
+	levels.Set("nodepool", zapcore.DebugLevel)
+	levels.Set("pod", zapcore.ErrorLevel)
+
+	logger.Named("nodepool").Debug("nodepool debug")
+	logger.Named("nodepool").Named("provisioning").Debug("child debug")
+	logger.Named("pod").Warn("pod warn")
+	logger.Named("safeEvict").Debug("safeEvict debug")
+	logger.Named("safeEvict").With(zap.String("nodepoolName", "np")).Info("safeEvict info")
+
+	var messages []string
+	for _, entry := range logs.All() {
+		messages = append(messages, entry.Message)
+	}
+	if got := strings.Join(messages, ","); got != "nodepool debug,child debug,safeEvict info" {
+		t.Errorf("expected only the entries enabled for their component, got %s", got)
+	}
+}
+
+func TestLevels_Apply(t *testing.T) {
+	levels := NewLevels(zapcore.InfoLevel)
+	levels.Set("pod", zapcore.DebugLevel)
+
+	levels.Apply(map[string]zapcore.Level{"nodepool": zapcore.WarnLevel})
+
+	if level := levels.Level("pod"); level != zapcore.InfoLevel {
+		t.Errorf("expected a component missing from the applied levels to log at the default level, got %s", level)
+	}
+	if level := levels.Level("nodepool"); level != zapcore.WarnLevel {
+		t.Errorf("expected the applied level, got %s", level)
+	}
+	if levels.Enabled(zapcore.DebugLevel) {
+		t.Error("expected debug to be disabled when no component logs at it")
+	}
+}
+
+func TestLevels_ServeHTTP(t *testing.T) {
+	levels := NewLevels(zapcore.InfoLevel)
+
+	for _, tc := range []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+		expected       levelsPayload
+	}{
+		{"get", http.MethodGet, "", http.StatusOK, levelsPayload{Default: "info"}},
+		{"set component", http.MethodPut, `{"component": "nodepool", "level": "debug"}`, http.StatusOK,
+			levelsPayload{Default: "info", Components: map[string]string{"nodepool": "debug"}}},
+		{"set default", http.MethodPut, `{"level": "warn"}`, http.StatusOK,
+			levelsPayload{Default: "warn", Components: map[string]string{"nodepool": "debug"}}},
+		{"reset component", http.MethodPut, `{"component": "nodepool"}`, http.StatusOK, levelsPayload{Default: "warn"}},
+		{"invalid level", http.MethodPut, `{"component": "pod", "level": "verbose"}`, http.StatusBadRequest, levelsPayload{}},
+		{"missing default level", http.MethodPut, `{}`, http.StatusBadRequest, levelsPayload{}},
+		{"other method", http.MethodPost, "", http.StatusMethodNotAllowed, levelsPayload{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			levels.ServeHTTP(recorder, httptest.NewRequest(tc.method, "/debug/loglevel", strings.NewReader(tc.body)))
+
+			if recorder.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+			var payload levelsPayload
+			if err := json.Unmarshal(recorder.Body.Bytes(), &payload); err != nil {
+				t.Fatalf("failed to decode the levels: %v", err)
+			}
+			if payload.Default != tc.expected.Default || len(payload.Components) != len(tc.expected.Components) {
+				t.Fatalf("expected %+v, got %+v", tc.expected, payload)
+			}
+			for component, level := range tc.expected.Components {
+				if payload.Components[component] != level {
+					t.Errorf("expected %s to log at %s, got %s", component, level, payload.Components[component])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"fmt"
+)
+
+// reconcileController is the name of the SafeEvict controller in the controller-runtime metrics
+const reconcileController = "safeevict"
+
+// Dashboard is the subset of the Grafana dashboard model which the controller dashboard uses
+type Dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          TimeRange  `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []Panel    `json:"panels"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+type Variable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type Panel struct {
+	ID          int         `json:"id"`
+	Title       string      `json:"title"`
+	Type        string      `json:"type"`
+	Description string      `json:"description,omitempty"`
+	Datasource  Datasource  `json:"datasource"`
+	GridPos     GridPos     `json:"gridPos"`
+	FieldConfig FieldConfig `json:"fieldConfig"`
+	Targets     []Target    `json:"targets"`
+}
+
+type Datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type FieldConfig struct {
+	Defaults FieldDefaults `json:"defaults"`
+}
+
+type FieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+type Target struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+}
+
+// rotationLegend names the series of a rotation by its SafeEvict and workload cluster
+const rotationLegend = "{{namespace}}/{{name}} {{cluster}}"
+
+// NewDashboard returns the Grafana dashboard of the metrics of the controller. The Prometheus datasource is chosen
+// with the datasource variable of the dashboard.
+func NewDashboard(title string) Dashboard {
+	panels := []Panel{
+		{
+			Title:   "Running rotations",
+			Type:    "stat",
+			Targets: []Target{{Expr: fmt.Sprintf("count(%s) or vector(0)", RotationStartTimeName)}},
+		},
+		{
+			Title:       "Rotation duration",
+			Type:        "timeseries",
+			Description: fmt.Sprintf("Rotations running for more than %s are reported as stuck.", StuckRotationThreshold),
+			FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: "s"}},
+			Targets:     []Target{{Expr: fmt.Sprintf("time() - %s", RotationStartTimeName), LegendFormat: rotationLegend}},
+		},
+		{
+			Title:       "Temporary nodepool age",
+			Type:        "timeseries",
+			Description: fmt.Sprintf("Temporary nodepools older than %s are reported as left behind.", TemporaryNodepoolMaxAge),
+			FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: "s"}},
+			Targets:     []Target{{Expr: fmt.Sprintf("time() - %s", TemporaryNodepoolCreatedTimeName), LegendFormat: rotationLegend}},
+		},
+		{
+			Title:       "Throttled ARM requests",
+			Type:        "timeseries",
+			FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: "reqps"}},
+			Targets:     []Target{{Expr: fmt.Sprintf("sum(rate(%s[5m]))", ARMThrottledRequestsName), LegendFormat: "throttled"}},
+		},
+		{
+			Title:       "Reconciles",
+			Type:        "timeseries",
+			FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: "ops"}},
+			Targets: []Target{{
+				Expr:         fmt.Sprintf(`sum by (result) (rate(controller_runtime_reconcile_total{controller=%q}[5m]))`, reconcileController),
+				LegendFormat: "{{result}}",
+			}},
+		},
+		{
+			Title:       "Reconcile errors",
+			Type:        "timeseries",
+			FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: "ops"}},
+			Targets: []Target{{
+				Expr:         fmt.Sprintf(`sum(rate(controller_runtime_reconcile_errors_total{controller=%q}[5m]))`, reconcileController),
+				LegendFormat: "errors",
+			}},
+		},
+	}
+	// two panels per row, the stat panel is as large as the others to keep the grid simple
+	for i := range panels {
+		panels[i].ID = i + 1
+		panels[i].Datasource = Datasource{Type: "prometheus", UID: "${datasource}"}
+		panels[i].GridPos = GridPos{H: 8, W: 12, X: (i % 2) * 12, Y: (i / 2) * 8}
+		for j := range panels[i].Targets {
+			panels[i].Targets[j].RefID = string(rune('A' + j))
+		}
+	}
+
+	return Dashboard{
+		UID:           "node-updater",
+		Title:         title,
+		Tags:          []string{"node-updater"},
+		SchemaVersion: 39,
+		Refresh:       "1m",
+		Time:          TimeRange{From: "now-24h", To: "now"},
+		Templating: Templating{List: []Variable{
+			{Name: "datasource", Label: "Datasource", Type: "datasource", Query: "prometheus"},
+		}},
+		Panels: panels,
+	}
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewDashboard(t *testing.T) {
+	dashboard := NewDashboard("Node updater")
+
+	if dashboard.Title != "Node updater" {
+		t.Errorf("Expected the title to be set, got '%s'", dashboard.Title)
+	}
+	used := make(map[string]bool)
+	for _, panel := range dashboard.Panels {
+		if panel.Datasource.UID != "${datasource}" {
+			t.Errorf("Expected panel '%s' to use the datasource variable, got '%s'", panel.Title, panel.Datasource.UID)
+		}
+		for _, target := range panel.Targets {
+			for _, name := range []string{RotationStartTimeName, TemporaryNodepoolCreatedTimeName, ARMThrottledRequestsName} {
+				if strings.Contains(target.Expr, name) {
+					used[name] = true
+				}
+			}
+		}
+	}
+	if len(used) != 3 {
+		t.Errorf("Expected every metric of the controller on the dashboard, got %v", used)
+	}
+}
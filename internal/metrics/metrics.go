@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+const (
+	// RotationStartTimeName is the start time of the running rotations, a rotation has no series while it is idle
+	RotationStartTimeName = "node_updater_rotation_start_time_seconds"
+	// ARMThrottledRequestsName counts the ARM requests which were answered with 429 Too Many Requests
+	ARMThrottledRequestsName = "node_updater_arm_throttled_requests_total"
+	// TemporaryNodepoolCreatedTimeName is the creation time of the temporary nodepools, taken from their created-at tag
+	TemporaryNodepoolCreatedTimeName = "node_updater_temporary_nodepool_created_time_seconds"
+	// AgentPoolQueuedJobsName is the number of pipeline jobs waiting for an agent in an Azure DevOps pool
+	AgentPoolQueuedJobsName = "node_updater_agent_pool_queued_jobs"
+	// BusyAgentsName is the number of busy agents sampled for the usage history of a SafeEvict with auto schedule
+	BusyAgentsName = "node_updater_busy_agents"
+	// StragglerNodesName is the number of nodes which missed the upgrade of their nodepool in the last rotation
+	StragglerNodesName = "node_updater_straggler_nodes"
+	// StragglerNodesReplacedName counts the straggler nodes which were deleted to be replaced by the scale set
+	StragglerNodesReplacedName = "node_updater_straggler_nodes_replaced_total"
+	// ReconcileDurationName is the duration of the reconciles of the SafeEvicts
+	ReconcileDurationName = "node_updater_reconcile_duration_seconds"
+	// ReconcileTimeoutsName counts the reconciles of the SafeEvicts which were cut by their deadline
+	ReconcileTimeoutsName = "node_updater_reconcile_timeouts_total"
+)
+
+// rotationLabels identify the rotation of a cluster, cluster is empty for the cluster of the controller
+var rotationLabels = []string{"namespace", "name", "cluster"}
+
+var (
+	rotationStartTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: RotationStartTimeName,
+		Help: "Start time of the running rotation in unix seconds.",
+	}, rotationLabels)
+	armThrottledRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: ARMThrottledRequestsName,
+		Help: "Number of ARM requests which were throttled with 429 Too Many Requests.",
+	})
+	temporaryNodepoolCreatedTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: TemporaryNodepoolCreatedTimeName,
+		Help: "Creation time of the temporary nodepool of the rotation in unix seconds.",
+	}, rotationLabels)
+	agentPoolQueuedJobs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: AgentPoolQueuedJobsName,
+		Help: "Number of pipeline jobs waiting for an agent in the Azure DevOps pool, as seen by the last eviction.",
+	}, []string{"pool"})
+	busyAgents = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: BusyAgentsName,
+		Help: "Number of busy agents in the Azure DevOps pools of the SafeEvict at the last sample of its usage history.",
+	}, rotationLabels)
+	stragglerNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: StragglerNodesName,
+		Help: "Number of nodes which missed the node image upgrade of their nodepool in the last rotation.",
+	}, rotationLabels)
+	stragglerNodesReplaced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: StragglerNodesReplacedName,
+		Help: "Number of straggler nodes which were drained and deleted to be replaced by the scale set.",
+	}, rotationLabels)
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    ReconcileDurationName,
+		Help:    "Duration of the reconciles of the SafeEvicts in seconds, by result (success or error).",
+		Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"result"})
+	reconcileTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: ReconcileTimeoutsName,
+		Help: "Number of reconciles of the SafeEvict which ran out of their deadline and were requeued.",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(rotationStartTime, armThrottledRequests, temporaryNodepoolCreatedTime, agentPoolQueuedJobs, busyAgents, stragglerNodes, stragglerNodesReplaced, reconcileDuration, reconcileTimeouts)
+}
+
+// RecordRotation exposes the start time of the rotation while it is in progress and drops it otherwise
+func RecordRotation(namespace, name, cluster string, phase updatev1.Phase, startTime *metav1.Time) {
+	if !phase.InProgress() || startTime == nil {
+		rotationStartTime.DeleteLabelValues(namespace, name, cluster)
+		return
+	}
+	rotationStartTime.WithLabelValues(namespace, name, cluster).Set(float64(startTime.Unix()))
+}
+
+// RecordTemporaryNodepool exposes the creation time of the temporary nodepool of the rotation
+func RecordTemporaryNodepool(namespace, name, cluster string, createdAt time.Time) {
+	temporaryNodepoolCreatedTime.WithLabelValues(namespace, name, cluster).Set(float64(createdAt.Unix()))
+}
+
+// ForgetTemporaryNodepool drops the creation time of the temporary nodepool once it is removed
+func ForgetTemporaryNodepool(namespace, name, cluster string) {
+	temporaryNodepoolCreatedTime.DeleteLabelValues(namespace, name, cluster)
+}
+
+// RecordQueuedJobs exposes the queue depth of the Azure DevOps pool
+func RecordQueuedJobs(pool string, queuedJobs int) {
+	agentPoolQueuedJobs.WithLabelValues(pool).Set(float64(queuedJobs))
+}
+
+// RecordBusyAgents exposes the last sample of the usage history of the SafeEvict
+func RecordBusyAgents(namespace, name, cluster string, busy int) {
+	busyAgents.WithLabelValues(namespace, name, cluster).Set(float64(busy))
+}
+
+// RecordStragglers exposes the number of straggler nodes found after the upgrade of the rotation
+func RecordStragglers(namespace, name, cluster string, stragglers int) {
+	stragglerNodes.WithLabelValues(namespace, name, cluster).Set(float64(stragglers))
+}
+
+// RecordStragglersReplaced counts the straggler nodes deleted by the rotation
+func RecordStragglersReplaced(namespace, name, cluster string, replaced int) {
+	stragglerNodesReplaced.WithLabelValues(namespace, name, cluster).Add(float64(replaced))
+}
+
+// ObserveReconcile records the duration of a reconcile
+func ObserveReconcile(duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	reconcileDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// RecordReconcileTimeout counts a reconcile of the SafeEvict which ran out of its deadline
+func RecordReconcileTimeout(namespace, name string) {
+	reconcileTimeouts.WithLabelValues(namespace, name).Inc()
+}
+
+// Forget drops every series of a deleted SafeEvict
+func Forget(namespace, name string) {
+	labels := prometheus.Labels{"namespace": namespace, "name": name}
+	rotationStartTime.DeletePartialMatch(labels)
+	temporaryNodepoolCreatedTime.DeletePartialMatch(labels)
+	busyAgents.DeletePartialMatch(labels)
+	stragglerNodes.DeletePartialMatch(labels)
+	stragglerNodesReplaced.DeletePartialMatch(labels)
+	reconcileTimeouts.DeletePartialMatch(labels)
+}
+
+// ThrottlingPolicy counts the throttled ARM requests, it is added to the per-retry policies of the ARM clients so
+// every retry of a throttled request is counted
+type ThrottlingPolicy struct{}
+
+// Do implements policy.Policy
+func (ThrottlingPolicy) Do(req *policy.Request) (*http.Response, error) {
+	response, err := req.Next()
+	if response != nil && response.StatusCode == http.StatusTooManyRequests {
+		armThrottledRequests.Inc()
+	}
+	return response, err
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+type statusTransport int
+
+func (t statusTransport) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: int(t), Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+}
+
+func TestThrottlingPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		statusCode int
+		expected   float64
+	}{
+		{statusCode: http.StatusOK, expected: 0},
+		{statusCode: http.StatusTooManyRequests, expected: 1},
+	} {
+		pipeline := runtime.NewPipeline("test", "v1", runtime.PipelineOptions{PerRetry: []policy.Policy{ThrottlingPolicy{}}},
+			&policy.ClientOptions{Transport: statusTransport(tt.statusCode), Retry: policy.RetryOptions{MaxRetries: -1}})
+		req, err := runtime.NewRequest(context.Background(), http.MethodGet, "https://management.azure.com/")
+		if err != nil {
+			t.Fatalf("NewRequest returned error: %v", err)
+		}
+		before := testutil.ToFloat64(armThrottledRequests)
+
+		if _, err := pipeline.Do(req); err != nil {
+			t.Fatalf("Do returned error: %v", err)
+		}
+
+		if counted := testutil.ToFloat64(armThrottledRequests) - before; counted != tt.expected {
+			t.Errorf("Expected %v throttled requests for status %d, got %v", tt.expected, tt.statusCode, counted)
+		}
+	}
+}
+
+func TestRecordRotation(t *testing.T) {
+	startTime := metav1.NewTime(time.Unix(1700000000, 0))
+
+	RecordRotation("default", "rotation", "", updatev1.PhaseDraining, &startTime)
+
+	if value := testutil.ToFloat64(rotationStartTime.WithLabelValues("default", "rotation", "")); value != 1700000000 {
+		t.Errorf("Expected the start time of the running rotation, got %v", value)
+	}
+
+	RecordRotation("default", "rotation", "", updatev1.PhaseDetecting, &startTime)
+
+	if count := testutil.CollectAndCount(rotationStartTime); count != 0 {
+		t.Errorf("Expected no series for an idle rotation, got %d", count)
+	}
+}
+
+func TestForget(t *testing.T) {
+	RecordTemporaryNodepool("default", "rotation", "first", time.Now())
+	RecordTemporaryNodepool("default", "rotation", "second", time.Now())
+	RecordTemporaryNodepool("default", "other", "", time.Now())
+
+	Forget("default", "rotation")
+
+	if count := testutil.CollectAndCount(temporaryNodepoolCreatedTime); count != 1 {
+		t.Errorf("Expected only the series of the other SafeEvict to be kept, got %d", count)
+	}
+}
+
+func TestRecordQueuedJobs(t *testing.T) {
+	RecordQueuedJobs("pool", 5)
+	RecordQueuedJobs("pool", 2)
+
+	if value := testutil.ToFloat64(agentPoolQueuedJobs.WithLabelValues("pool")); value != 2 {
+		t.Errorf("Expected the last queue depth of the pool, got %v", value)
+	}
+}
+
+func TestARMCallPolicy(t *testing.T) {
+	pipeline := runtime.NewPipeline("test", "v1", runtime.PipelineOptions{PerRetry: []policy.Policy{ARMCallPolicy{}}},
+		&policy.ClientOptions{Transport: statusTransport(http.StatusOK), Retry: policy.RetryOptions{MaxRetries: -1}})
+	ctx, stats := WithReconcileStats(context.Background())
+	for _, ctx := range []context.Context{ctx, ctx, context.Background()} {
+		req, err := runtime.NewRequest(ctx, http.MethodGet, "https://management.azure.com/")
+		if err != nil {
+			t.Fatalf("NewRequest returned error: %v", err)
+		}
+		if _, err := pipeline.Do(req); err != nil {
+			t.Fatalf("Do returned error: %v", err)
+		}
+	}
+
+	if calls := stats.ARMCalls.Load(); calls != 2 {
+		t.Errorf("Expected the 2 calls of the reconcile to be counted, got %d", calls)
+	}
+}
+
+func TestObserveReconcile(t *testing.T) {
+	ObserveReconcile(2*time.Second, nil)
+	ObserveReconcile(time.Second, errors.New("failed"))
+
+	if count := testutil.CollectAndCount(reconcileDuration); count != 2 {
+		t.Errorf("Expected a series per result, got %d", count)
+	}
+}
+
+func TestRecordReconcileTimeout(t *testing.T) {
+	RecordReconcileTimeout("default", "timeout")
+	RecordReconcileTimeout("default", "timeout")
+
+	if value := testutil.ToFloat64(reconcileTimeouts.WithLabelValues("default", "timeout")); value != 2 {
+		t.Errorf("Expected every timeout to be counted, got %v", value)
+	}
+
+	Forget("default", "timeout")
+
+	if count := testutil.CollectAndCount(reconcileTimeouts); count != 0 {
+		t.Errorf("Expected the timeouts of the deleted SafeEvict to be dropped, got %d", count)
+	}
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// ReconcileStats counts the work of a single reconcile for its summary. It travels in the context of the reconcile, so
+// the ARM pipeline and the evictions add to it without being passed the reconcile.
+type ReconcileStats struct {
+	PoolsChecked  atomic.Int64
+	OutdatedPools atomic.Int64
+	PodsEvicted   atomic.Int64
+	ARMCalls      atomic.Int64
+}
+
+type reconcileStatsKey struct{}
+
+// WithReconcileStats returns a context which collects the stats of a reconcile
+func WithReconcileStats(ctx context.Context) (context.Context, *ReconcileStats) {
+	stats := &ReconcileStats{}
+	return context.WithValue(ctx, reconcileStatsKey{}, stats), stats
+}
+
+// StatsFrom returns the stats of the reconcile of ctx, outside of a reconcile the counts are discarded
+func StatsFrom(ctx context.Context) *ReconcileStats {
+	if stats, ok := ctx.Value(reconcileStatsKey{}).(*ReconcileStats); ok {
+		return stats
+	}
+	return &ReconcileStats{}
+}
+
+// ARMCallPolicy counts the ARM requests of a reconcile, it is added to the per-retry policies of the ARM clients so
+// every retry is counted as a call
+type ARMCallPolicy struct{}
+
+// Do implements policy.Policy
+func (ARMCallPolicy) Do(req *policy.Request) (*http.Response, error) {
+	StatsFrom(req.Raw().Context()).ARMCalls.Add(1)
+	return req.Next()
+}
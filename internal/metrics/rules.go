@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// StuckRotationThreshold is how long a rotation may run before it is reported as stuck
+	StuckRotationThreshold = 6 * time.Hour
+	// ARMThrottlingWindow is the window in which the throttled ARM requests are counted
+	ARMThrottlingWindow = 15 * time.Minute
+	// ARMThrottlingThreshold is the number of throttled ARM requests in the window which is reported
+	ARMThrottlingThreshold = 10
+	// TemporaryNodepoolMaxAge is how long a temporary nodepool may exist before it is reported as left behind
+	TemporaryNodepoolMaxAge = 12 * time.Hour
+)
+
+// PrometheusRule is the subset of the PrometheusRule resource of the Prometheus operator which the alerts use
+type PrometheusRule struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        RuleMetadata       `json:"metadata"`
+	Spec            PrometheusRuleSpec `json:"spec"`
+}
+
+type RuleMetadata struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type PrometheusRuleSpec struct {
+	Groups []RuleGroup `json:"groups"`
+}
+
+type RuleGroup struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+type Rule struct {
+	Record      string            `json:"record,omitempty"`
+	Alert       string            `json:"alert,omitempty"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// NewPrometheusRule returns the recording and alerting rules of the metrics of the controller, the thresholds come
+// from the constants of this package
+func NewPrometheusRule(namespace, name string) PrometheusRule {
+	return PrometheusRule{
+		TypeMeta: metav1.TypeMeta{APIVersion: "monitoring.coreos.com/v1", Kind: "PrometheusRule"},
+		Metadata: RuleMetadata{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/name": "node-updater"},
+		},
+		Spec: PrometheusRuleSpec{Groups: []RuleGroup{
+			{
+				Name: "node-updater.rules",
+				Rules: []Rule{
+					{
+						Record: "node_updater:rotation_duration_seconds",
+						Expr:   fmt.Sprintf("time() - %s", RotationStartTimeName),
+					},
+					{
+						Record: "node_updater:temporary_nodepool_age_seconds",
+						Expr:   fmt.Sprintf("time() - %s", TemporaryNodepoolCreatedTimeName),
+					},
+					{
+						Record: "node_updater:arm_throttled_requests:increase" + promDuration(ARMThrottlingWindow),
+						Expr:   fmt.Sprintf("increase(%s[%s])", ARMThrottledRequestsName, promDuration(ARMThrottlingWindow)),
+					},
+				},
+			},
+			{
+				Name: "node-updater.alerts",
+				Rules: []Rule{
+					{
+						Alert:  "NodeUpdaterRotationStuck",
+						Expr:   fmt.Sprintf("node_updater:rotation_duration_seconds > %d", int(StuckRotationThreshold.Seconds())),
+						For:    "5m",
+						Labels: map[string]string{"severity": "warning"},
+						Annotations: map[string]string{
+							"summary":     "Node image rotation is running for too long",
+							"description": fmt.Sprintf("The rotation of SafeEvict {{ $labels.namespace }}/{{ $labels.name }} (cluster '{{ $labels.cluster }}') is running for more than %s.", StuckRotationThreshold),
+						},
+					},
+					{
+						Alert:  "NodeUpdaterARMThrottling",
+						Expr:   fmt.Sprintf("node_updater:arm_throttled_requests:increase%s > %d", promDuration(ARMThrottlingWindow), ARMThrottlingThreshold),
+						Labels: map[string]string{"severity": "warning"},
+						Annotations: map[string]string{
+							"summary":     "ARM requests of the node updater are throttled",
+							"description": fmt.Sprintf("More than %d ARM requests were throttled in the last %s.", ARMThrottlingThreshold, ARMThrottlingWindow),
+						},
+					},
+					{
+						Alert:  "NodeUpdaterTemporaryNodepoolLeftBehind",
+						Expr:   fmt.Sprintf("node_updater:temporary_nodepool_age_seconds > %d", int(TemporaryNodepoolMaxAge.Seconds())),
+						For:    "5m",
+						Labels: map[string]string{"severity": "warning"},
+						Annotations: map[string]string{
+							"summary":     "Temporary nodepool exists for too long",
+							"description": fmt.Sprintf("The temporary nodepool of SafeEvict {{ $labels.namespace }}/{{ $labels.name }} (cluster '{{ $labels.cluster }}') exists for more than %s.", TemporaryNodepoolMaxAge),
+						},
+					},
+				},
+			},
+		}},
+	}
+}
+
+// promDuration formats a duration in whole minutes for PromQL
+func promDuration(duration time.Duration) string {
+	return fmt.Sprintf("%dm", int(duration.Minutes()))
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewPrometheusRule(t *testing.T) {
+	rule := NewPrometheusRule("monitoring", "alerts")
+
+	if rule.Kind != "PrometheusRule" || rule.Metadata.Namespace != "monitoring" || rule.Metadata.Name != "alerts" {
+		t.Fatalf("Unexpected PrometheusRule %s %s/%s", rule.Kind, rule.Metadata.Namespace, rule.Metadata.Name)
+	}
+	alerts := make(map[string]string)
+	for _, group := range rule.Spec.Groups {
+		for _, r := range group.Rules {
+			if r.Alert != "" {
+				alerts[r.Alert] = r.Expr
+			}
+		}
+	}
+	expected := map[string]string{
+		"NodeUpdaterRotationStuck":               "> 21600",
+		"NodeUpdaterARMThrottling":               "increase15m > 10",
+		"NodeUpdaterTemporaryNodepoolLeftBehind": "> 43200",
+	}
+	for alert, threshold := range expected {
+		if !strings.HasSuffix(alerts[alert], threshold) {
+			t.Errorf("Expected alert %s to end with '%s', got '%s'", alert, threshold, alerts[alert])
+		}
+	}
+}
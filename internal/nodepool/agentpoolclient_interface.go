@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
 )
 
@@ -13,4 +14,16 @@ type AgentPoolClientInterface interface {
 	BeginDelete(ctx context.Context, resourceGroup, clusterName, nodePoolName string, options *armcontainerservice.AgentPoolsClientBeginDeleteOptions) (*runtime.Poller[armcontainerservice.AgentPoolsClientDeleteResponse], error)
 	GetUpgradeProfile(ctx context.Context, resourceGroup, clusterName, nodePoolName string, options *armcontainerservice.AgentPoolsClientGetUpgradeProfileOptions) (armcontainerservice.AgentPoolsClientGetUpgradeProfileResponse, error)
 	BeginUpgradeNodeImageVersion(ctx context.Context, resourceGroupName string, resourceName string, agentPoolName string, options *armcontainerservice.AgentPoolsClientBeginUpgradeNodeImageVersionOptions) (*runtime.Poller[armcontainerservice.AgentPoolsClientUpgradeNodeImageVersionResponse], error)
+	NewListPager(resourceGroupName string, resourceName string, options *armcontainerservice.AgentPoolsClientListOptions) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse]
+}
+
+// ManagedClusterClientInterface reads the managed cluster of the node pools, e.g. its provisioning state
+type ManagedClusterClientInterface interface {
+	Get(ctx context.Context, resourceGroupName string, resourceName string, options *armcontainerservice.ManagedClustersClientGetOptions) (armcontainerservice.ManagedClustersClientGetResponse, error)
+}
+
+// ScaleSetVMsClientInterface reimages the scale set instances of the nodes of a node pool
+type ScaleSetVMsClientInterface interface {
+	Get(ctx context.Context, resourceGroupName string, vmScaleSetName string, instanceID string, options *armcompute.VirtualMachineScaleSetVMsClientGetOptions) (armcompute.VirtualMachineScaleSetVMsClientGetResponse, error)
+	BeginReimage(ctx context.Context, resourceGroupName string, vmScaleSetName string, instanceID string, options *armcompute.VirtualMachineScaleSetVMsClientBeginReimageOptions) (*runtime.Poller[armcompute.VirtualMachineScaleSetVMsClientReimageResponse], error)
 }
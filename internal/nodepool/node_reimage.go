@@ -0,0 +1,112 @@
+package nodepool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"norbinto/node-updater/internal/audit"
+)
+
+// ReimageStartedAnnotation marks a node whose scale set instance is being reimaged, its value is the start time of the
+// reimage in RFC 3339
+const ReimageStartedAnnotation = "update.norbinto/reimage-started"
+
+// azureProviderIDPrefix is the prefix of the provider ID of an Azure node, the resource ID of its VM follows it
+const azureProviderIDPrefix = "azure://"
+
+// ErrReimageNotSupported is returned when a node cannot be reimaged, e.g. it is not a scale set instance
+var ErrReimageNotSupported = errors.New("node cannot be reimaged")
+
+// GetLatestNodeImageVersion returns the latest node image version of the upgrade profile of the node pool
+func (c *NodePoolController) GetLatestNodeImageVersion(ctx context.Context, nodePoolName string) (string, error) {
+	return c.getNodePoolUpgradeProfile(ctx, nodePoolName)
+}
+
+// ReimageNode starts the reimage of the scale set instance of the node and marks the node with the
+// ReimageStartedAnnotation. The instance gets the image of the model of its scale set, the reimage is not waited for.
+func (c *NodePoolController) ReimageNode(ctx context.Context, node corev1.Node) error {
+	instance, err := c.scaleSetInstance(node)
+	if err != nil {
+		return err
+	}
+	c.logger.Info("Reimaging the scale set instance of node", zap.String("nodeName", node.Name), zap.String("scaleSet", instance.Parent.Name), zap.String("instanceID", instance.Name))
+	_, err = c.scaleSetVMsClient.BeginReimage(ctx, instance.ResourceGroupName, instance.Parent.Name, instance.Name, nil)
+	audit.Write(ctx, audit.ActionReimageNode, audit.Target(audit.KindNode, node.Name), err)
+	if err != nil {
+		c.logger.Error("Failed to start the reimage of node", zap.Error(err), zap.String("nodeName", node.Name))
+		return fmt.Errorf("failed to reimage node '%s': %w", node.Name, err)
+	}
+
+	// the node was cordoned since it was listed, the annotation is added to its current version
+	current, err := c.kubeClient.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get reimaged node '%s': %w", node.Name, err)
+	}
+	if current.Annotations == nil {
+		current.Annotations = make(map[string]string)
+	}
+	current.Annotations[ReimageStartedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if _, err := c.mutationClient.CoreV1().Nodes().Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+		c.logger.Error("Failed to annotate reimaged node", zap.Error(err), zap.String("nodeName", node.Name))
+		return fmt.Errorf("failed to annotate reimaged node '%s': %w", node.Name, err)
+	}
+	return nil
+}
+
+// GetReimageProvisioningState returns the provisioning state of the scale set instance of the node, it is "Succeeded"
+// once a reimage is finished
+func (c *NodePoolController) GetReimageProvisioningState(ctx context.Context, node corev1.Node) (ProvisioningState, error) {
+	instance, err := c.scaleSetInstance(node)
+	if err != nil {
+		return "", err
+	}
+	vm, err := c.scaleSetVMsClient.Get(ctx, instance.ResourceGroupName, instance.Parent.Name, instance.Name, nil)
+	if err != nil {
+		c.logger.Error("Failed to get the scale set instance of node", zap.Error(err), zap.String("nodeName", node.Name))
+		return "", fmt.Errorf("failed to get the scale set instance of node '%s': %w", node.Name, err)
+	}
+	if vm.Properties == nil || vm.Properties.ProvisioningState == nil {
+		return "", nil
+	}
+	return ProvisioningState(*vm.Properties.ProvisioningState), nil
+}
+
+// FinishReimage makes the reimaged node schedulable again and removes its ReimageStartedAnnotation
+func (c *NodePoolController) FinishReimage(ctx context.Context, node corev1.Node) error {
+	node.Spec.Unschedulable = false
+	delete(node.Annotations, ReimageStartedAnnotation)
+	_, err := c.mutationClient.CoreV1().Nodes().Update(ctx, &node, metav1.UpdateOptions{})
+	audit.Write(ctx, audit.ActionUncordonNode, audit.Target(audit.KindNode, node.Name), err)
+	if err != nil {
+		c.logger.Error("Failed to uncordon reimaged node", zap.Error(err), zap.String("nodeName", node.Name))
+		return fmt.Errorf("failed to uncordon reimaged node '%s': %w", node.Name, err)
+	}
+	c.logger.Debug("Node is reimaged and schedulable again", zap.String("nodeName", node.Name))
+	return nil
+}
+
+// scaleSetInstance returns the resource ID of the scale set instance of the node, taken from its provider ID
+func (c *NodePoolController) scaleSetInstance(node corev1.Node) (*arm.ResourceID, error) {
+	if c.scaleSetVMsClient == nil {
+		return nil, fmt.Errorf("%w: no scale set VMs client is configured", ErrReimageNotSupported)
+	}
+	if !strings.HasPrefix(node.Spec.ProviderID, azureProviderIDPrefix) {
+		return nil, fmt.Errorf("%w: node '%s' has provider ID '%s'", ErrReimageNotSupported, node.Name, node.Spec.ProviderID)
+	}
+	instance, err := arm.ParseResourceID(strings.TrimPrefix(node.Spec.ProviderID, azureProviderIDPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid provider ID of node '%s': %w", ErrReimageNotSupported, node.Name, err)
+	}
+	if !strings.EqualFold(instance.ResourceType.String(), "Microsoft.Compute/virtualMachineScaleSets/virtualMachines") || instance.Parent == nil {
+		return nil, fmt.Errorf("%w: node '%s' is not a scale set instance", ErrReimageNotSupported, node.Name)
+	}
+	return instance, nil
+}
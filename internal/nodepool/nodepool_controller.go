@@ -2,25 +2,132 @@ package nodepool
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	armcontainerservice "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+
+	"norbinto/node-updater/internal/audit"
+)
+
+const (
+	// ManagedByTagKey is the ARM tag which marks a node pool as created by this controller
+	ManagedByTagKey = "managed-by"
+	// ManagedByTagValue is the value of the ManagedByTagKey tag
+	ManagedByTagValue = "node-updater"
+	// OwnerTagKey is the ARM tag which holds the SafeEvict resource owning the node pool
+	OwnerTagKey = "owner-cr"
+	// CreatedAtTagKey is the ARM tag which holds the creation time of the node pool
+	CreatedAtTagKey = "created-at"
+	// ArchitectureAMD64 is the kubernetes.io/arch label of the x86-64 nodes
+	ArchitectureAMD64 = "amd64"
+	// ArchitectureARM64 is the kubernetes.io/arch label of the arm64 nodes
+	ArchitectureARM64 = "arm64"
+	// AgentPoolLabel holds the name of the node pool of an AKS node
+	AgentPoolLabel = "agentpool"
+	// NodeImageVersionLabel holds the node image version an AKS node runs
+	NodeImageVersionLabel = "kubernetes.azure.com/node-image-version"
+	// ScaleDownDisabledAnnotation prevents the cluster-autoscaler from removing the annotated node
+	ScaleDownDisabledAnnotation = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
 )
 
+// managedTaintKeys are the taints which are added and removed by Kubernetes, the cloud provider and the
+// cluster-autoscaler, they are never saved and never restored
+var managedTaintKeys = []string{"ToBeDeletedByClusterAutoscaler", "DeletionCandidateOfClusterAutoscaler"}
+
+// managedTaintPrefixes are the key prefixes of the taints managed by Kubernetes and the cloud provider, e.g. the
+// node.kubernetes.io/unschedulable taint of a cordoned node
+var managedTaintPrefixes = []string{"node.kubernetes.io/", "node.cloudprovider.kubernetes.io/"}
+
+// ErrArchitectureMismatch is returned when the temporary node pool would not have the architecture of its source
+var ErrArchitectureMismatch = errors.New("architecture of the VM size does not match the source node pool")
+
+// arm64VMSizePattern matches the arm64 Azure VM sizes: family, vCPUs, optional constrained vCPUs, then the lower case
+// additive features containing "p" for the Ampere Altra processor
+var arm64VMSizePattern = regexp.MustCompile(`^Standard_[A-Z]+[0-9]+(-[0-9]+)?[a-z]*p[a-z]*(_|$)`)
+
+// ErrUpgradeNotPermitted is returned when the node image upgrade of a node pool would be refused by ARM, e.g. because
+// the node pool failed or another operation is running on the managed cluster
+var ErrUpgradeNotPermitted = errors.New("node image upgrade is not permitted")
+
+// RetryableError is returned when ARM refused a change of a node pool with a conflict, e.g. because another operation
+// is running on the node pool. The change did not land, it has to be retried once the node pool settled.
+type RetryableError struct {
+	NodePoolName string
+	Err          error
+}
+
+func (e *RetryableError) Error() string {
+	return fmt.Sprintf("change of node pool '%s' conflicts with a running operation: %v", e.NodePoolName, e.Err)
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable returns true when the error is or wraps a RetryableError
+func IsRetryable(err error) bool {
+	var retryableErr *RetryableError
+	return errors.As(err, &retryableErr)
+}
+
+// InvalidScalingError is returned when the scaling JSON saved by the controller is no valid scaling, e.g. it lacks the
+// counts of its scaling. The scaling is not applied, retrying does not help until the saved data is fixed.
+type InvalidScalingError struct {
+	ScalingData string
+	Err         error
+}
+
+func (e *InvalidScalingError) Error() string {
+	return fmt.Sprintf("invalid scaling data '%s': %v", e.ScalingData, e.Err)
+}
+
+func (e *InvalidScalingError) Unwrap() error {
+	return e.Err
+}
+
+// IsInvalidScaling returns true when the error is or wraps an InvalidScalingError
+func IsInvalidScaling(err error) bool {
+	var invalidErr *InvalidScalingError
+	return errors.As(err, &invalidErr)
+}
+
+// ErrNodePoolNotManaged is returned when a node pool is not tagged as owned by the given SafeEvict resource
+var ErrNodePoolNotManaged = errors.New("node pool is not managed by node-updater")
+
 type NodePoolController struct {
-	kubeClient           kubernetes.Interface
-	agentPoolClient      AgentPoolClientInterface
+	kubeClient kubernetes.Interface
+	// mutationClient cordons and annotates the nodes, it is the kubeClient unless WithMutationClient is used
+	mutationClient  kubernetes.Interface
+	agentPoolClient AgentPoolClientInterface
+	// managedClusterClient checks the managed cluster before an upgrade, the check is skipped when it is nil
+	managedClusterClient ManagedClusterClientInterface
+	// scaleSetVMsClient reimages single nodes, the NodeReimage strategy fails when it is nil
+	scaleSetVMsClient ScaleSetVMsClientInterface
+	// statePollInterval and stateTimeout configure how the node pool is waited for after the controller changed it
+	statePollInterval time.Duration
+	stateTimeout      time.Duration
+	// operationTags tags the updated node pools with the operation of the controller, see WithOperationTags
+	operationTags bool
+	// sharedNodePoolLocks serialises the reference updates of the shared node pools, see lockSharedNodePool
+	sharedNodePoolLocks  *sharedNodePoolLocks
 	subscriptionID       string
 	clusterResourceGroup string
 	clusterName          string
@@ -30,68 +137,163 @@ type NodePoolController struct {
 func NewNodePoolController(kubeClient kubernetes.Interface, agentPoolClient AgentPoolClientInterface, subscriptionID, clusterResourceGroup, clusterName string, logger *zap.Logger) *NodePoolController {
 	return &NodePoolController{
 		kubeClient:           kubeClient,
+		mutationClient:       kubeClient,
 		agentPoolClient:      agentPoolClient,
 		subscriptionID:       subscriptionID,
 		clusterResourceGroup: clusterResourceGroup,
 		clusterName:          clusterName,
+		statePollInterval:    DefaultStatePollInterval,
+		sharedNodePoolLocks:  newSharedNodePoolLocks(),
 		logger:               logger,
 	}
 }
 
+// WithMutationClient returns a copy of the NodePoolController which cordons and annotates nodes with the given client
+func (c *NodePoolController) WithMutationClient(mutationClient kubernetes.Interface) NodePoolControllerInterface {
+	controller := *c
+	controller.mutationClient = mutationClient
+	return &controller
+}
+
+// WithLogFields returns a copy of the NodePoolController which attaches the fields to every log entry
+func (c *NodePoolController) WithLogFields(fields ...zap.Field) NodePoolControllerInterface {
+	controller := *c
+	controller.logger = c.logger.With(fields...)
+	return &controller
+}
+
+// WithManagedClusterClient returns a copy of the NodePoolController which checks the managed cluster with the given
+// client before it upgrades a node pool
+func (c *NodePoolController) WithManagedClusterClient(managedClusterClient ManagedClusterClientInterface) NodePoolControllerInterface {
+	controller := *c
+	controller.managedClusterClient = managedClusterClient
+	return &controller
+}
+
+// WithScaleSetVMsClient returns a copy of the NodePoolController which reimages single nodes with the given client
+func (c *NodePoolController) WithScaleSetVMsClient(scaleSetVMsClient ScaleSetVMsClientInterface) NodePoolControllerInterface {
+	controller := *c
+	controller.scaleSetVMsClient = scaleSetVMsClient
+	return &controller
+}
+
+// WithCluster returns a copy of the NodePoolController which works on the given AKS cluster, the managed cluster client
+// and the scale set VMs client of the original cluster are dropped
+func (c *NodePoolController) WithCluster(kubeClient kubernetes.Interface, agentPoolClient AgentPoolClientInterface, subscriptionID, clusterResourceGroup, clusterName string) NodePoolControllerInterface {
+	controller := *c
+	controller.kubeClient = kubeClient
+	controller.mutationClient = kubeClient
+	controller.agentPoolClient = agentPoolClient
+	controller.managedClusterClient = nil
+	controller.scaleSetVMsClient = nil
+	controller.subscriptionID = subscriptionID
+	controller.clusterResourceGroup = clusterResourceGroup
+	controller.clusterName = clusterName
+	controller.logger = c.logger.With(zap.String("cluster", clusterName))
+	return &controller
+}
+
+// UpdateNeeded returns the node pools whose node image version, as reported by ARM, is not the latest one of their
+// upgrade profile, together with their nodes. Only the nodes of the outdated node pools are listed.
 func (c *NodePoolController) UpdateNeeded(ctx context.Context, nodePools []string) (map[string]corev1.Node, map[string]armcontainerservice.AgentPool, error) {
 	var outdatedNodes = make(map[string]corev1.Node)
 	var outdatedNodePools = make(map[string]armcontainerservice.AgentPool)
 
-	nodepoolNodeImageVersions, err := c.getNodeImageVersions(ctx, nodePools)
-	if err != nil {
-		c.logger.Error("Could not get node image versions for pools", zap.Error(err))
-		return nil, nil, err
-	}
-
-	for nodepoolName, nodeImageVersion := range nodepoolNodeImageVersions {
-		c.logger.Debug(fmt.Sprintf("Processing node pool '%s' with current image version '%s'", nodepoolName, nodeImageVersion))
-		nodepoolLatestImageVersions, err := c.getNodePoolUpgradeProfile(ctx, nodepoolName)
+	for _, nodepoolName := range nodePools {
+		nodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodepoolName, nil)
+		if err != nil {
+			var responseErr *azcore.ResponseError
+			if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+				c.logger.Debug("Node pool does not exist, skipping it", zap.String("nodepoolName", nodepoolName))
+				continue
+			}
+			c.logger.Error("Failed to retrieve the node pool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
+			return nil, nil, fmt.Errorf("unable to get node pool '%s': %w", nodepoolName, err)
+		}
+		nodeImageVersion := GetNodeImageVersion(nodePool.AgentPool)
+		nodepoolLatestImageVersion, err := c.getNodePoolUpgradeProfile(ctx, nodepoolName)
 		if err != nil {
 			c.logger.Error("Failed to retrieve the latest node image version for node pool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
 			return nil, nil, err
 		}
+		c.logger.Debug("Retrieved the image versions of the node pool", zap.String("nodepoolName", nodepoolName), zap.String("nodeImageVersion", nodeImageVersion), zap.String("latestNodeImageVersion", nodepoolLatestImageVersion))
+		if nodeImageVersion == nodepoolLatestImageVersion {
+			continue
+		}
+
 		nodes, err := c.GetNodesByNodePool(ctx, nodepoolName)
 		if err != nil {
 			c.logger.Error("Failed to retrieve the nodes for node pool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
 			return nil, nil, err
 		}
-		if nodeImageVersion != nodepoolLatestImageVersions {
-			for _, node := range nodes {
-				outdatedNodes[node.Name] = node
-			}
+		for _, node := range nodes {
+			outdatedNodes[node.Name] = node
+		}
+		outdatedNodePools[nodepoolName] = nodePool.AgentPool
+	}
+	return outdatedNodes, outdatedNodePools, nil
+}
 
-			nodePool, err := c.GetNodePoolByName(ctx, nodepoolName)
-			if err != nil {
-				c.logger.Error("Failed to retrieve the node pool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
-				return nil, nil, err
+// GetStragglerNodes returns the nodes which missed the upgrade of their node pool: their node image version label
+// differs from the node image version ARM reports for the node pool. Node pools which are still provisioning are
+// skipped, their nodes are being replaced.
+func (c *NodePoolController) GetStragglerNodes(ctx context.Context, nodePools []string) ([]corev1.Node, error) {
+	var stragglers []corev1.Node
+	for _, nodepoolName := range nodePools {
+		nodePool, err := c.GetNodePoolByName(ctx, nodepoolName)
+		if err != nil {
+			return nil, err
+		}
+		nodeImageVersion := GetNodeImageVersion(*nodePool)
+		if nodeImageVersion == "" || GetProvisioningState(*nodePool) != ProvisioningStateSucceeded {
+			continue
+		}
+		nodes, err := c.GetNodesByNodePool(ctx, nodepoolName)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			if nodeVersion, exists := node.Labels[NodeImageVersionLabel]; exists && nodeVersion != nodeImageVersion {
+				c.logger.Debug("Node has another node image version than its node pool", zap.String("nodeName", node.Name), zap.String("nodeImageVersion", nodeVersion), zap.String("nodepoolName", nodepoolName), zap.String("nodepoolImageVersion", nodeImageVersion))
+				stragglers = append(stragglers, node)
 			}
-			outdatedNodePools[nodepoolName] = *nodePool
 		}
-		c.logger.Debug(fmt.Sprintf("Node pool '%s' has current image version '%s' and latest image version '%s'", nodepoolName, nodeImageVersion, nodepoolLatestImageVersions))
 	}
-	return outdatedNodes, outdatedNodePools, nil
+	return stragglers, nil
+}
+
+// GetNodeImageVersion returns the node image version ARM reports for the node pool, it is empty when it is unknown
+func GetNodeImageVersion(nodePool armcontainerservice.AgentPool) string {
+	if nodePool.Properties == nil || nodePool.Properties.NodeImageVersion == nil {
+		return ""
+	}
+	return *nodePool.Properties.NodeImageVersion
 }
 
-func (c *NodePoolController) HasRunningStatefulPods(ctx context.Context, nodes []corev1.Node, namespaces []string) (bool, error) {
+// HasRunningStatefulPods returns true when a running pod of the namespaces which matches the selector is on one of the
+// nodes, a nil selector matches every pod
+func (c *NodePoolController) HasRunningStatefulPods(ctx context.Context, nodes []corev1.Node, namespaces []string, selector *metav1.LabelSelector) (bool, error) {
+	labelSelector := labels.Everything()
+	if selector != nil {
+		var err error
+		if labelSelector, err = metav1.LabelSelectorAsSelector(selector); err != nil {
+			return false, fmt.Errorf("invalid blocking pod selector: %w", err)
+		}
+	}
 	for _, namespace := range namespaces {
-		c.logger.Debug(fmt.Sprintf("Checking for running stateful pods in namespace '%s'", namespace))
-		podList, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		c.logger.Debug("Checking for running stateful pods", zap.String("namespace", namespace))
+		podList, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector.String()})
 		if err != nil {
 			c.logger.Error("Failed to list pods in namespace", zap.Error(err), zap.String("namespace", namespace))
 			return false, err
 		}
-		c.logger.Debug(fmt.Sprintf("Found %d pods in namespace '%s'", len(podList.Items), namespace))
+		c.logger.Debug("Found pods", zap.Int("pods", len(podList.Items)), zap.String("namespace", namespace))
 		for _, pod := range podList.Items {
-			// Check if the pod is running and belongs to one of the specified nodes
-			if pod.Status.Phase == corev1.PodRunning {
+			// Check if the pod is running and belongs to one of the specified nodes, a terminating pod is already drained
+			if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
 				for _, node := range nodes {
 					if pod.Spec.NodeName == node.Name {
-						c.logger.Info(fmt.Sprintf("Found running stateful pod '%s' on node '%s'", pod.Name, node.Name))
+						c.logger.Info("Found running stateful pod", zap.String("podName", pod.Name), zap.String("nodeName", node.Name))
 						return true, nil
 					}
 				}
@@ -104,68 +306,43 @@ func (c *NodePoolController) HasRunningStatefulPods(ctx context.Context, nodes [
 
 func (c *NodePoolController) GetNodePoolByName(ctx context.Context, nodePoolName string) (*armcontainerservice.AgentPool, error) {
 	// Get the node pool by name
-	c.logger.Debug(fmt.Sprintf("Retrieving node pool '%s'", nodePoolName))
+	c.logger.Debug("Retrieving node pool", zap.String("nodepoolName", nodePoolName))
 	nodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
 	if apierrors.IsNotFound(err) {
-		c.logger.Debug(fmt.Sprintf("Node pool '%s' not found", nodePoolName))
+		c.logger.Debug("Node pool not found", zap.String("nodepoolName", nodePoolName))
 		return nil, err
 	}
 	if err != nil {
 		c.logger.Error("Error occurred while getting node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
-		return nil, fmt.Errorf("unable to get node pool '%s': %v", nodePoolName, err)
+		return nil, fmt.Errorf("unable to get node pool '%s': %w", nodePoolName, err)
 	}
-	c.logger.Debug(fmt.Sprintf("Successfully retrieved node pool '%s'", nodePoolName))
+	c.logger.Debug("Successfully retrieved node pool", zap.String("nodepoolName", nodePoolName))
 	return &nodePool.AgentPool, nil
 }
 
-func (c *NodePoolController) getNodeImageVersions(ctx context.Context, nodePoolNames []string) (map[string]string, error) {
-	// List all nodes in the cluster
-	nodeList := &corev1.NodeList{}
-	nodeList, err := c.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
-	if err != nil {
-		c.logger.Error("Failed to list nodes", zap.Error(err))
-		return nil, fmt.Errorf("failed to list nodes: %v", err)
-	}
-
-	// Map to store node pool names and their node image versions
-	nodeImageVersions := make(map[string]string)
-
-	// Iterate through the nodes and group them by node pool
-	for _, node := range nodeList.Items {
-		// Extract the node pool name from the "agentpool" label
-		nodePoolName, exists := node.Labels["agentpool"]
-		if !exists {
-			// Skip nodes without an "agentpool" label
-			continue
+// GetAllAgentPools returns every node pool of the cluster in name order, not only the node pools of a spec, e.g. to find
+// the node pools a selector matches or the temporary node pools left behind
+func (c *NodePoolController) GetAllAgentPools(ctx context.Context) ([]armcontainerservice.AgentPool, error) {
+	c.logger.Debug("Listing node pools of the cluster", zap.String("clusterName", c.clusterName))
+	var agentPools []armcontainerservice.AgentPool
+	pager := c.agentPoolClient.NewListPager(c.clusterResourceGroup, c.clusterName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			c.logger.Error("Failed to list node pools", zap.Error(err), zap.String("clusterName", c.clusterName))
+			return nil, fmt.Errorf("unable to list node pools of cluster '%s': %w", c.clusterName, err)
 		}
-
-		// Check if the node pool name is in the nodePoolNames array
-		found := false
-		for _, name := range nodePoolNames {
-			if name == nodePoolName {
-				found = true
-				break
+		for _, agentPool := range page.Value {
+			if agentPool != nil && agentPool.Name != nil {
+				agentPools = append(agentPools, *agentPool)
 			}
 		}
-		if !found {
-			// Skip nodes that are not part of the specified node pools
-			continue
-		}
-
-		// Extract the node image version from the "kubernetes.azure.com/node-image-version" label
-		nodeImageVersion, exists := node.Labels["kubernetes.azure.com/node-image-version"]
-		if !exists {
-			// Skip nodes without a node image version label
-			continue
-		}
-
-		// Add the node image version to the map if the node pool is not already present
-		if _, found := nodeImageVersions[nodePoolName]; !found {
-			nodeImageVersions[nodePoolName] = nodeImageVersion
-		}
 	}
-
-	return nodeImageVersions, nil
+	slices.SortFunc(agentPools, func(a, b armcontainerservice.AgentPool) int {
+		return strings.Compare(*a.Name, *b.Name)
+	})
+	c.logger.Debug("Found node pools of the cluster", zap.Int("nodepools", len(agentPools)), zap.String("clusterName", c.clusterName))
+	return agentPools, nil
 }
 
 func (c *NodePoolController) getNodePoolUpgradeProfile(ctx context.Context, nodePoolName string) (string, error) {
@@ -174,12 +351,12 @@ func (c *NodePoolController) getNodePoolUpgradeProfile(ctx context.Context, node
 	upgradeProfile, err := c.agentPoolClient.GetUpgradeProfile(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
 	if err != nil {
 		c.logger.Error("Failed to get upgrade profile for node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
-		return "", fmt.Errorf("unable to get upgrade profile for node pool '%s': %v", nodePoolName, err)
+		return "", fmt.Errorf("unable to get upgrade profile for node pool '%s': %w", nodePoolName, err)
 	}
 
 	// Extract the latest node image version
 	if upgradeProfile.Properties != nil && upgradeProfile.Properties.LatestNodeImageVersion != nil {
-		c.logger.Debug(fmt.Sprintf("Latest node image version for node pool '%s' is '%s'", nodePoolName, *upgradeProfile.Properties.LatestNodeImageVersion))
+		c.logger.Debug("Retrieved the latest node image version of the node pool", zap.String("nodepoolName", nodePoolName), zap.String("latestNodeImageVersion", *upgradeProfile.Properties.LatestNodeImageVersion))
 		return *upgradeProfile.Properties.LatestNodeImageVersion, nil
 	}
 
@@ -187,38 +364,66 @@ func (c *NodePoolController) getNodePoolUpgradeProfile(ctx context.Context, node
 }
 
 func (c *NodePoolController) GetNodesByNodePool(ctx context.Context, nodePoolName string) ([]corev1.Node, error) {
-	c.logger.Debug(fmt.Sprintf("Retrieving nodes for node pool '%s'", nodePoolName))
-	// List all nodes in the cluster
-	nodeList := &corev1.NodeList{}
-	nodeList, err := c.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	c.logger.Debug("Retrieving nodes of node pool", zap.String("nodepoolName", nodePoolName))
+	// List only the nodes of the node pool
+	nodeList, err := c.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: labels.Set{AgentPoolLabel: nodePoolName}.String()})
 	if err != nil {
 		c.logger.Error("Failed to list nodes for node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
-		return nil, fmt.Errorf("failed to list nodes: %v", err)
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
 	}
+	nodes := nodeList.Items
 
-	// Slice to store nodes
-	var nodes []corev1.Node
+	c.logger.Debug("Found nodes of node pool", zap.Int("nodes", len(nodes)), zap.String("nodepoolName", nodePoolName))
+	return nodes, nil
+}
 
-	// Iterate through the nodes and filter by the specified node pool
+// HasSchedulableSystemNodes tells whether a ready and schedulable system node exists outside of the excluded node
+// pools, which keeps the pods of kube-system running while the excluded system node pools are rotated
+func (c *NodePoolController) HasSchedulableSystemNodes(ctx context.Context, excludedNodePools []string) (bool, error) {
+	nodeList, err := c.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: labels.Set{AKSModeLabel: "system"}.String()})
+	if err != nil {
+		c.logger.Error("Failed to list system nodes", zap.Error(err))
+		return false, fmt.Errorf("failed to list system nodes: %w", err)
+	}
 	for _, node := range nodeList.Items {
-		// Check if the node belongs to the specified node pool
-		if poolName, exists := node.Labels["agentpool"]; exists && poolName == nodePoolName {
-			nodes = append(nodes, node)
+		if slices.Contains(excludedNodePools, node.Labels[AgentPoolLabel]) || node.Spec.Unschedulable {
+			continue
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				return true, nil
+			}
 		}
 	}
+	c.logger.Debug("No schedulable system node outside of the node pools", zap.Strings("excludedNodePools", excludedNodePools))
+	return false, nil
+}
 
-	c.logger.Debug(fmt.Sprintf("Found %d nodes in node pool '%s'", len(nodes), nodePoolName))
-	return nodes, nil
+// TemporaryNodePoolOverrides change the configuration cloned from the source node pool into the temporary node pool
+type TemporaryNodePoolOverrides struct {
+	// VMSize overrides the VM size of the source, it must have the architecture of the source node pool, whose nodes
+	// run the agent images today
+	VMSize string
+	// NodeLabels are added to the cloned node labels, they replace the cloned labels of the same key
+	NodeLabels map[string]string
+	// NodeTaints in the AKS format key=value:Effect are added to the cloned node taints, they replace the cloned taints
+	// of the same key and effect
+	NodeTaints []string
+	// Count replaces the cloned node count when it is set, the limits of a cloned autoscaler are widened to include it
+	Count *int32
+	// Shared tags the node pool as shared by several SafeEvicts, the owner holds its first reference
+	Shared bool
 }
 
-func (c *NodePoolController) CreateTemporaryNodePool(ctx context.Context, newNodePoolName string, sourceNodePoolName string) error {
-	c.logger.Debug(fmt.Sprintf("Creating temporary node pool '%s' based on source node pool '%s'", newNodePoolName, sourceNodePoolName))
+// CreateTemporaryNodePool creates a clone of the source node pool with the overrides applied
+func (c *NodePoolController) CreateTemporaryNodePool(ctx context.Context, newNodePoolName string, sourceNodePoolName string, overrides TemporaryNodePoolOverrides, owner string) error {
+	c.logger.Debug("Creating temporary node pool", zap.String("temporaryNodepoolName", newNodePoolName), zap.String("sourceNodepoolName", sourceNodePoolName))
 
 	// Get the source node pool configuration
 	sourceNodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, sourceNodePoolName, nil)
 	if err != nil {
 		c.logger.Error("Failed to get source node pool", zap.Error(err), zap.String("sourceNodePoolName", sourceNodePoolName))
-		return fmt.Errorf("unable to get source node pool '%s': %v", sourceNodePoolName, err)
+		return fmt.Errorf("unable to get source node pool '%s': %w", sourceNodePoolName, err)
 	}
 
 	// Ensure the source node pool configuration is valid
@@ -227,47 +432,171 @@ func (c *NodePoolController) CreateTemporaryNodePool(ctx context.Context, newNod
 		return fmt.Errorf("source node pool '%s' has no properties", sourceNodePoolName)
 	}
 
+	// An arm64 node pool must be cloned into arm64 VMs, otherwise the agent images would not start on the new nodes
+	architecture, err := c.getNodePoolArchitecture(ctx, sourceNodePoolName, sourceNodePool.Properties.VMSize)
+	if err != nil {
+		return err
+	}
+	newVMSize := sourceNodePool.Properties.VMSize
+	if vmSize := overrides.VMSize; vmSize != "" {
+		if vmSizeArchitecture := GetVMSizeArchitecture(vmSize); vmSizeArchitecture != architecture {
+			c.logger.Error("VM size of the temporary node pool does not match the architecture of the source node pool", zap.String("vmSize", vmSize), zap.String("architecture", vmSizeArchitecture), zap.String("sourceArchitecture", architecture))
+			return fmt.Errorf("%w: VM size '%s' is %s, source node pool '%s' is %s", ErrArchitectureMismatch, vmSize, vmSizeArchitecture, sourceNodePoolName, architecture)
+		}
+		newVMSize = to.Ptr(vmSize)
+	}
+
+	count, minCount, maxCount := sourceNodePool.Properties.Count, sourceNodePool.Properties.MinCount, sourceNodePool.Properties.MaxCount
+	if overrides.Count != nil {
+		count = overrides.Count
+		if minCount != nil {
+			minCount = to.Ptr(min(*minCount, *count))
+		}
+		if maxCount != nil {
+			maxCount = to.Ptr(max(*maxCount, *count))
+		}
+	}
+
 	// Create a new node pool configuration based on the source node pool
 	newNodePool := armcontainerservice.AgentPool{
 		Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
-			VMSize:   sourceNodePool.Properties.VMSize,
-			Count:    sourceNodePool.Properties.Count,
-			MinCount: sourceNodePool.Properties.MinCount,
-			MaxCount: sourceNodePool.Properties.MaxCount,
+			VMSize:   newVMSize,
+			Count:    count,
+			MinCount: minCount,
+			MaxCount: maxCount,
 			// VnetSubnetID:        sourceNodePool.Properties.VnetSubnetID,
 			Mode:                sourceNodePool.Properties.Mode,
 			EnableAutoScaling:   sourceNodePool.Properties.EnableAutoScaling,
 			OrchestratorVersion: sourceNodePool.Properties.OrchestratorVersion,
-			NodeLabels:          sourceNodePool.Properties.NodeLabels,
-			NodeTaints:          sourceNodePool.Properties.NodeTaints,
+			NodeLabels:          mergeNodeLabels(sourceNodePool.Properties.NodeLabels, overrides.NodeLabels),
+			NodeTaints:          mergeNodeTaints(sourceNodePool.Properties.NodeTaints, overrides.NodeTaints),
 			OSType:              sourceNodePool.Properties.OSType,
+			OSSKU:               sourceNodePool.Properties.OSSKU,
+			Tags: map[string]*string{
+				ManagedByTagKey: to.Ptr(ManagedByTagValue),
+				OwnerTagKey:     to.Ptr(owner),
+				CreatedAtTagKey: to.Ptr(time.Now().UTC().Format(time.RFC3339)),
+			},
 		},
 	}
+	if overrides.Shared {
+		usedKey, _ := referenceTagKeys(owner)
+		newNodePool.Properties.Tags[SharedTagKey] = to.Ptr(SharedTagValue)
+		newNodePool.Properties.Tags[usedKey] = to.Ptr(owner)
+	}
+
+	c.tagOperation(ctx, &newNodePool, OperationCreateTemporaryNodePool)
 
 	// Create the new node pool
 	_, err = c.agentPoolClient.BeginCreateOrUpdate(ctx, c.clusterResourceGroup, c.clusterName, newNodePoolName, newNodePool, nil)
+	audit.Write(ctx, audit.ActionCreatePool, audit.Target(audit.KindNodepool, newNodePoolName), err)
 	if err != nil {
 		c.logger.Error("Failed to create new node pool", zap.Error(err), zap.String("newNodePoolName", newNodePoolName))
-		return fmt.Errorf("failed to create new node pool '%s': %v", newNodePoolName, err)
+		return fmt.Errorf("failed to create new node pool '%s': %w", newNodePoolName, err)
 	}
 
-	c.logger.Debug(fmt.Sprintf("Temporary node pool '%s' creation initiated successfully", newNodePoolName))
+	c.logger.Debug("Temporary node pool creation initiated successfully", zap.String("temporaryNodepoolName", newNodePoolName))
 	return nil
 }
 
-func (c *NodePoolController) GetNodePoolProvisioningState(ctx context.Context, nodePoolName string) (string, error) {
-	c.logger.Debug(fmt.Sprintf("Retrieving provisioning state for node pool '%s'", nodePoolName))
+// mergeNodeLabels adds the extra labels to a copy of the cloned labels
+func mergeNodeLabels(cloned map[string]*string, extra map[string]string) map[string]*string {
+	if len(extra) == 0 {
+		return cloned
+	}
+	nodeLabels := make(map[string]*string, len(cloned)+len(extra))
+	maps.Copy(nodeLabels, cloned)
+	for key, value := range extra {
+		nodeLabels[key] = to.Ptr(value)
+	}
+	return nodeLabels
+}
+
+// mergeNodeTaints adds the extra taints to the cloned taints, a cloned taint with the key and the effect of an extra
+// taint is dropped
+func mergeNodeTaints(cloned []*string, extra []string) []*string {
+	if len(extra) == 0 {
+		return cloned
+	}
+	replaced := make(map[string]bool, len(extra))
+	for _, nodeTaint := range extra {
+		replaced[taintIdentity(nodeTaint)] = true
+	}
+	nodeTaints := make([]*string, 0, len(cloned)+len(extra))
+	for _, nodeTaint := range cloned {
+		if nodeTaint != nil && !replaced[taintIdentity(*nodeTaint)] {
+			nodeTaints = append(nodeTaints, nodeTaint)
+		}
+	}
+	for _, nodeTaint := range extra {
+		nodeTaints = append(nodeTaints, to.Ptr(nodeTaint))
+	}
+	return nodeTaints
+}
+
+// taintIdentity returns the key and the effect of a taint in the AKS format key=value:Effect, which identify it
+func taintIdentity(nodeTaint string) string {
+	keyValue, effect, _ := strings.Cut(nodeTaint, ":")
+	key, _, _ := strings.Cut(keyValue, "=")
+	return key + ":" + effect
+}
+
+// getNodePoolArchitecture returns the architecture of the node pool from the kubernetes.io/arch label of its nodes, or
+// from its VM size when it has no nodes
+func (c *NodePoolController) getNodePoolArchitecture(ctx context.Context, nodePoolName string, vmSize *string) (string, error) {
+	nodes, err := c.GetNodesByNodePool(ctx, nodePoolName)
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes {
+		if architecture := node.Labels[corev1.LabelArchStable]; architecture != "" {
+			return architecture, nil
+		}
+	}
+	if vmSize == nil {
+		return ArchitectureAMD64, nil
+	}
+	return GetVMSizeArchitecture(*vmSize), nil
+}
+
+// GetVMSizeArchitecture returns the architecture of an Azure VM size. The additive features of the arm64 sizes contain
+// a "p", e.g. Standard_D4ps_v5 or Standard_E8pds_v5, every other size is amd64.
+func GetVMSizeArchitecture(vmSize string) string {
+	if arm64VMSizePattern.MatchString(vmSize) {
+		return ArchitectureARM64
+	}
+	return ArchitectureAMD64
+}
+
+// GetNodePoolCreationTime returns the creation time of a node pool created by this controller from its created-at tag
+func (c *NodePoolController) GetNodePoolCreationTime(ctx context.Context, nodePoolName string) (time.Time, error) {
+	nodePool, err := c.GetNodePoolByName(ctx, nodePoolName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if nodePool.Properties == nil || nodePool.Properties.Tags[CreatedAtTagKey] == nil {
+		return time.Time{}, fmt.Errorf("node pool '%s' is not tagged with %s", nodePoolName, CreatedAtTagKey)
+	}
+	createdAt, err := time.Parse(time.RFC3339, *nodePool.Properties.Tags[CreatedAtTagKey])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s tag of node pool '%s': %w", CreatedAtTagKey, nodePoolName, err)
+	}
+	return createdAt, nil
+}
+
+func (c *NodePoolController) GetNodePoolProvisioningState(ctx context.Context, nodePoolName string) (ProvisioningState, error) {
+	c.logger.Debug("Retrieving provisioning state of node pool", zap.String("nodepoolName", nodePoolName))
 	// Get the node pool details
 	nodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
 	if err != nil {
 		c.logger.Error("Error occurred while getting node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
-		return "", fmt.Errorf("unable to get node pool '%s': %v", nodePoolName, err)
+		return "", fmt.Errorf("unable to get node pool '%s': %w", nodePoolName, err)
 	}
 
 	// Check the provisioning state
 	if nodePool.Properties != nil && nodePool.Properties.ProvisioningState != nil {
-		c.logger.Debug(fmt.Sprintf("Provisioning state for node pool '%s' is '%s'", nodePoolName, *nodePool.Properties.ProvisioningState))
-		return *nodePool.Properties.ProvisioningState, nil
+		c.logger.Debug("Retrieved provisioning state of node pool", zap.String("nodepoolName", nodePoolName), zap.String("provisioningState", *nodePool.Properties.ProvisioningState))
+		return ProvisioningState(*nodePool.Properties.ProvisioningState), nil
 	}
 
 	c.logger.Error("Provisioning state not available", zap.Error(fmt.Errorf("provisioning state not available")), zap.String("nodePoolName", nodePoolName))
@@ -275,7 +604,7 @@ func (c *NodePoolController) GetNodePoolProvisioningState(ctx context.Context, n
 }
 
 func (c *NodePoolController) NodePoolExists(ctx context.Context, nodePoolName string) (bool, error) {
-	c.logger.Debug(fmt.Sprintf("Checking if node pool '%s' exists", nodePoolName))
+	c.logger.Debug("Checking if node pool exists", zap.String("nodepoolName", nodePoolName))
 	// Try to get the node pool
 	_, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
 	if err != nil {
@@ -286,58 +615,84 @@ func (c *NodePoolController) NodePoolExists(ctx context.Context, nodePoolName st
 		}
 		c.logger.Error("Error occurred while checking if node pool exists", zap.Error(err), zap.String("nodePoolName", nodePoolName))
 		// For other errors, return the error
-		return false, fmt.Errorf("error checking if node pool exists: %v", err)
+		return false, fmt.Errorf("error checking if node pool exists: %w", err)
 	}
 
-	c.logger.Debug(fmt.Sprintf("Node pool '%s' exists", nodePoolName))
+	c.logger.Debug("Node pool exists", zap.String("nodepoolName", nodePoolName))
 	// If no error, the node pool exists
 	return true, nil
 }
 
 func (c *NodePoolController) UpgradeNodeImageVersion(ctx context.Context, nodepool *armcontainerservice.AgentPool) error {
-	c.logger.Debug(fmt.Sprintf("Starting node image version upgrade for node pool '%s'", *nodepool.Name))
+	c.logger.Debug("Starting node image version upgrade", zap.String("nodepoolName", *nodepool.Name))
 
-	if nodepool.Properties != nil && nodepool.Properties.ProvisioningState != nil && (*nodepool.Properties.ProvisioningState == "UpgradingNodeImageVersion" || *nodepool.Properties.ProvisioningState == "Updating") {
-		c.logger.Debug(fmt.Sprintf("Node pool '%s' is currently upgrading its node image version. Skipping further upgrade actions.", *nodepool.Name))
+	if provisioningState := GetProvisioningState(*nodepool); provisioningState == ProvisioningStateUpgradingNodeImageVersion || provisioningState == ProvisioningStateUpdating {
+		c.logger.Debug("Node pool is currently upgrading its node image version, skipping further upgrade actions", zap.String("nodepoolName", *nodepool.Name))
 		return nil
 	}
 
-	nodepoolNodeImageVersions, err := c.getNodeImageVersions(ctx, []string{*nodepool.Name})
-	if err != nil {
-		c.logger.Error("Failed to get node image versions for node pool", zap.Error(err), zap.String("nodePoolName", *nodepool.Name))
-		return err
-	}
-	nodepoolLatestImageVersions, err := c.getNodePoolUpgradeProfile(ctx, *nodepool.Name)
+	nodeImageVersion := GetNodeImageVersion(*nodepool)
+	nodepoolLatestImageVersion, err := c.getNodePoolUpgradeProfile(ctx, *nodepool.Name)
 	if err != nil {
 		c.logger.Error("Failed to retrieve the latest node image version for node pool", zap.Error(err), zap.String("nodePoolName", *nodepool.Name))
 		return err
 	}
-	if nodepoolNodeImageVersions[*nodepool.Name] == nodepoolLatestImageVersions {
-		c.logger.Debug(fmt.Sprintf("Node pool '%s' is already up to date. No upgrade needed.", *nodepool.Name))
+	if nodeImageVersion == nodepoolLatestImageVersion {
+		c.logger.Debug("Node pool is already up to date, no upgrade needed", zap.String("nodepoolName", *nodepool.Name))
 		return nil
 	}
-	c.logger.Info(fmt.Sprintf("Node pool '%s' does not have the latest image version. Current: '%s', Latest: '%s'", *nodepool.Name, nodepoolNodeImageVersions[*nodepool.Name], nodepoolLatestImageVersions))
-	c.logger.Info(fmt.Sprintf("Initiating node image version upgrade for node pool '%s'", *nodepool.Name))
+	c.logger.Info("Node pool does not have the latest image version", zap.String("nodepoolName", *nodepool.Name), zap.String("nodeImageVersion", nodeImageVersion), zap.String("latestNodeImageVersion", nodepoolLatestImageVersion))
+	if err := c.validateUpgrade(ctx, nodepool); err != nil {
+		c.logger.Warn("Node image version upgrade is not permitted", zap.Error(err), zap.String("nodePoolName", *nodepool.Name))
+		return err
+	}
+	c.logger.Info("Initiating node image version upgrade", zap.String("nodepoolName", *nodepool.Name))
 	_, err = c.agentPoolClient.BeginUpgradeNodeImageVersion(ctx, c.clusterResourceGroup, c.clusterName, *nodepool.Name, nil)
+	audit.Write(ctx, audit.ActionUpgradePool, audit.Target(audit.KindNodepool, *nodepool.Name), err)
 	if err != nil {
 		c.logger.Error("Failed to initiate node image version upgrade for node pool", zap.Error(err), zap.String("nodePoolName", *nodepool.Name))
-		return fmt.Errorf("failed to upgrade node image version for node pool '%s': %v", *nodepool.Name, err)
+		var responseErr *azcore.ResponseError
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%w: node pool '%s' was refused by ARM with %s", ErrUpgradeNotPermitted, *nodepool.Name, responseErr.ErrorCode)
+		}
+		return fmt.Errorf("failed to upgrade node image version for node pool '%s': %w", *nodepool.Name, err)
+	}
+
+	c.logger.Debug("Node pool is upgrading to the latest node image version", zap.String("nodepoolName", *nodepool.Name))
+	return nil
+}
+
+// validateUpgrade checks the states ARM requires for a node image upgrade: the node pool must not be failed, and the
+// managed cluster must be running without another operation in progress
+func (c *NodePoolController) validateUpgrade(ctx context.Context, nodepool *armcontainerservice.AgentPool) error {
+	if GetProvisioningState(*nodepool).Failed() {
+		return fmt.Errorf("%w: node pool '%s' is in provisioning state Failed", ErrUpgradeNotPermitted, *nodepool.Name)
+	}
+	if c.managedClusterClient == nil {
+		return nil
 	}
 
-	c.logger.Debug(fmt.Sprintf("Node pool '%s' is upgrading to the latest node image version", *nodepool.Name))
+	managedCluster, err := c.managedClusterClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nil)
+	if err != nil {
+		c.logger.Error("Error occurred while getting managed cluster", zap.Error(err), zap.String("clusterName", c.clusterName))
+		return fmt.Errorf("unable to get managed cluster '%s': %w", c.clusterName, err)
+	}
+	if managedCluster.Properties == nil {
+		return nil
+	}
+	if powerState := managedCluster.Properties.PowerState; powerState != nil && powerState.Code != nil && *powerState.Code == armcontainerservice.CodeStopped {
+		return fmt.Errorf("%w: managed cluster '%s' is stopped", ErrUpgradeNotPermitted, c.clusterName)
+	}
+	if provisioningState := managedCluster.Properties.ProvisioningState; provisioningState != nil && ProvisioningState(*provisioningState) != ProvisioningStateSucceeded {
+		return fmt.Errorf("%w: managed cluster '%s' is in provisioning state %s", ErrUpgradeNotPermitted, c.clusterName, *provisioningState)
+	}
 	return nil
 }
 
 func (c *NodePoolController) DisableAutoScaling(ctx context.Context, agentPools map[string]armcontainerservice.AgentPool) error {
 	for _, agentPool := range agentPools {
-		// Skip processing if the agent pool is a system pool
-		if agentPool.Properties != nil && agentPool.Properties.Mode != nil && *agentPool.Properties.Mode == armcontainerservice.AgentPoolModeSystem {
-			c.logger.Debug(fmt.Sprintf("Skipping disabling autoscaling for system agent pool '%s'", *agentPool.Name))
-			continue
-		}
-
-		if agentPool.Properties != nil && agentPool.Properties.Mode != nil && *agentPool.Properties.ProvisioningState != "Succeeded" {
-			c.logger.Debug(fmt.Sprintf("Skipping disabling autoscaling for agent pool '%s' as its provisioning state is '%s'", *agentPool.Name, *agentPool.Properties.ProvisioningState))
+		if agentPool.Properties != nil && agentPool.Properties.Mode != nil && GetProvisioningState(agentPool) != ProvisioningStateSucceeded {
+			c.logger.Debug("Skipping disabling autoscaling for agent pool", zap.String("nodepoolName", *agentPool.Name), zap.String("provisioningState", *agentPool.Properties.ProvisioningState))
 			continue
 		}
 
@@ -347,155 +702,502 @@ func (c *NodePoolController) DisableAutoScaling(ctx context.Context, agentPools
 			return fmt.Errorf("agent pool '%s' has no properties", *agentPool.Name)
 		}
 
+		// an update would put the pool into Updating again, which blocks its node image upgrade
+		if agentPool.Properties.EnableAutoScaling != nil && !*agentPool.Properties.EnableAutoScaling {
+			c.logger.Debug("Autoscaling is already disabled for agent pool", zap.String("nodepoolName", *agentPool.Name))
+			continue
+		}
+
 		// Update the autoscaling setting
 		agentPool.Properties.EnableAutoScaling = to.Ptr(false)
 
-		c.logger.Debug(fmt.Sprintf("Disabling autoscaling for agent pool '%s'", *agentPool.Name))
+		c.logger.Debug("Disabling autoscaling for agent pool", zap.String("nodepoolName", *agentPool.Name))
+		c.tagOperation(ctx, &agentPool, OperationDisableAutoScaling)
 		// Apply the update
 		_, err := c.agentPoolClient.BeginCreateOrUpdate(ctx, c.clusterResourceGroup, c.clusterName, *agentPool.Name, agentPool, nil)
+		audit.Write(ctx, audit.ActionDisableAutoScaling, audit.Target(audit.KindNodepool, *agentPool.Name), err)
 		if err != nil {
 			var responseErr *azcore.ResponseError
-			if errors.As(err, &responseErr) && responseErr.StatusCode == 409 {
-				c.logger.Debug(fmt.Sprintf("Conflict error (409) encountered for agent pool '%s'. Reconciliation will be attempted.", *agentPool.Name))
-				return nil
+			if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusConflict {
+				c.logger.Debug("Conflict error (409) encountered for agent pool, reconciliation will be attempted", zap.String("nodepoolName", *agentPool.Name))
+				return &RetryableError{NodePoolName: *agentPool.Name, Err: err}
 			}
 			c.logger.Error("Failed to disable autoscaling for agent pool", zap.Error(err), zap.String("agentPoolName", *agentPool.Name))
-			return fmt.Errorf("failed to update autoscaling for agent pool '%s': %v", *agentPool.Name, err)
+			return fmt.Errorf("failed to update autoscaling for agent pool '%s': %w", *agentPool.Name, err)
+		}
+		if err := c.waitUntilSettled(ctx, *agentPool.Name); err != nil {
+			return err
 		}
-		c.logger.Debug(fmt.Sprintf("Autoscaling for agent pool '%s' has been successfully disabled", *agentPool.Name))
+		c.logger.Debug("Autoscaling for agent pool has been successfully disabled", zap.String("nodepoolName", *agentPool.Name))
 	}
 
 	c.logger.Debug("Disabling autoscaling for agent pools completed")
 	return nil
 }
 
-func (c *NodePoolController) RemoveTemporaryNodePool(ctx context.Context, nodePoolName string) error {
+// ScaleUpNodePool adds a node to the node pool, or raises the maximum count of its autoscaler by one when it is
+// autoscaled, up to maxCount. A node pool which is still updating is not changed. It returns false once maxCount is
+// reached.
+func (c *NodePoolController) ScaleUpNodePool(ctx context.Context, nodePoolName string, maxCount int32) (bool, error) {
+	nodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
+	if err != nil {
+		c.logger.Error("Failed to get node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
+		return false, fmt.Errorf("unable to get node pool '%s': %w", nodePoolName, err)
+	}
+	if nodePool.Properties == nil {
+		return false, fmt.Errorf("agent pool '%s' has no properties", nodePoolName)
+	}
+	properties := nodePool.Properties
+	count := properties.Count
+	if properties.EnableAutoScaling != nil && *properties.EnableAutoScaling {
+		count = properties.MaxCount
+	}
+	var current int32
+	if count != nil {
+		current = *count
+	}
+	if current >= maxCount {
+		c.logger.Debug("Node pool is already scaled", zap.String("nodepoolName", nodePoolName), zap.Int32("count", current))
+		return false, nil
+	}
+	// the previous scale up is still running, another node is added once it landed
+	if state := GetProvisioningState(nodePool.AgentPool); state != ProvisioningStateSucceeded {
+		c.logger.Debug("Skipping the scale up of node pool", zap.String("nodepoolName", nodePoolName), zap.String("provisioningState", string(state)))
+		return true, nil
+	}
+
+	scaled := current + 1
+	if properties.EnableAutoScaling != nil && *properties.EnableAutoScaling {
+		properties.MaxCount = to.Ptr(scaled)
+	} else {
+		properties.Count = to.Ptr(scaled)
+	}
+	c.logger.Info("Scaling up node pool", zap.String("nodePoolName", nodePoolName), zap.Int32("count", scaled), zap.Int32("maxCount", maxCount))
+	c.tagOperation(ctx, &nodePool.AgentPool, OperationScaleUp)
+	_, err = c.agentPoolClient.BeginCreateOrUpdate(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nodePool.AgentPool, nil)
+	audit.Write(ctx, audit.ActionScalePool, audit.Target(audit.KindNodepool, nodePoolName), err)
+	if err != nil {
+		var responseErr *azcore.ResponseError
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusConflict {
+			return false, &RetryableError{NodePoolName: nodePoolName, Err: err}
+		}
+		c.logger.Error("Failed to scale up node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
+		return false, fmt.Errorf("failed to scale up node pool '%s': %w", nodePoolName, err)
+	}
+	return true, nil
+}
+
+// ScaleNodePool disables the autoscaler of the node pool and scales it to count nodes, at least to the smallest count
+// AKS accepts for its mode: no node for a user node pool and one node for a system node pool. It returns the node count
+// of the node pool before the change, a node pool which already has the count is not changed.
+func (c *NodePoolController) ScaleNodePool(ctx context.Context, nodePoolName string, count int32) (int32, error) {
+	nodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
+	if err != nil {
+		c.logger.Error("Failed to get node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
+		return 0, fmt.Errorf("unable to get node pool '%s': %w", nodePoolName, err)
+	}
+	if nodePool.Properties == nil {
+		return 0, fmt.Errorf("agent pool '%s' has no properties", nodePoolName)
+	}
+	properties := nodePool.Properties
+	if properties.Mode != nil && *properties.Mode == armcontainerservice.AgentPoolModeSystem {
+		count = max(count, 1)
+	}
+	var current int32
+	if properties.Count != nil {
+		current = *properties.Count
+	}
+	autoscaled := properties.EnableAutoScaling != nil && *properties.EnableAutoScaling
+	if !autoscaled && current == count {
+		c.logger.Debug("Node pool is already scaled", zap.String("nodepoolName", nodePoolName), zap.Int32("count", count))
+		return current, nil
+	}
+
+	properties.EnableAutoScaling = to.Ptr(false)
+	properties.MinCount = nil
+	properties.MaxCount = nil
+	properties.Count = to.Ptr(count)
+	operation := OperationScaleDown
+	if count > current {
+		operation = OperationScaleUp
+	}
+	c.logger.Info("Scaling node pool", zap.String("nodePoolName", nodePoolName), zap.Int32("count", count), zap.Int32("previousCount", current))
+	c.tagOperation(ctx, &nodePool.AgentPool, operation)
+	_, err = c.agentPoolClient.BeginCreateOrUpdate(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nodePool.AgentPool, nil)
+	audit.Write(ctx, audit.ActionScalePool, audit.Target(audit.KindNodepool, nodePoolName), err)
+	if err != nil {
+		var responseErr *azcore.ResponseError
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusConflict {
+			return 0, &RetryableError{NodePoolName: nodePoolName, Err: err}
+		}
+		c.logger.Error("Failed to scale node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
+		return 0, fmt.Errorf("failed to scale node pool '%s': %w", nodePoolName, err)
+	}
+	return current, nil
+}
+
+// VerifyTemporaryNodePoolOwnership checks that the node pool carries the tags written by CreateTemporaryNodePool for the given owner
+func (c *NodePoolController) VerifyTemporaryNodePoolOwnership(ctx context.Context, nodePoolName string, owner string) error {
+	c.logger.Debug("Verifying ownership tags of node pool", zap.String("nodepoolName", nodePoolName))
+	nodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
+	if err != nil {
+		c.logger.Error("Error occurred while getting node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
+		return fmt.Errorf("unable to get node pool '%s': %w", nodePoolName, err)
+	}
+
+	if !isOwnedBy(&nodePool.AgentPool, owner) {
+		c.logger.Error("Node pool is not owned by the SafeEvict resource", zap.Error(ErrNodePoolNotManaged), zap.String("nodePoolName", nodePoolName), zap.String("owner", owner))
+		return fmt.Errorf("node pool '%s' is not tagged with %s=%s and %s=%s: %w", nodePoolName, ManagedByTagKey, ManagedByTagValue, OwnerTagKey, owner, ErrNodePoolNotManaged)
+	}
+
+	c.logger.Debug("Node pool is managed by owner", zap.String("nodepoolName", nodePoolName), zap.String("owner", owner))
+	return nil
+}
+
+// isOwnedBy returns true when the node pool was created for the owner, a shared node pool is owned by every SafeEvict
+// which holds a reference
+func isOwnedBy(nodePool *armcontainerservice.AgentPool, owner string) bool {
+	if !isManaged(nodePool) {
+		return false
+	}
+	if isShared(nodePool) {
+		return isReferencedBy(nodePool, owner)
+	}
+	ownerTag, ok := nodePool.Properties.Tags[OwnerTagKey]
+	return ok && ownerTag != nil && *ownerTag == owner
+}
+
+func isManaged(nodePool *armcontainerservice.AgentPool) bool {
+	if nodePool.Properties == nil || nodePool.Properties.Tags == nil {
+		return false
+	}
+	managedBy, ok := nodePool.Properties.Tags[ManagedByTagKey]
+	return ok && managedBy != nil && *managedBy == ManagedByTagValue
+}
+
+func (c *NodePoolController) RemoveTemporaryNodePool(ctx context.Context, nodePoolName string, owner string) error {
+	// Never delete a node pool which was not created by us
+	if err := c.VerifyTemporaryNodePoolOwnership(ctx, nodePoolName, owner); err != nil {
+		return err
+	}
+
 	// Delete the node pool
-	c.logger.Debug(fmt.Sprintf("Starting to delete node pool '%s'", nodePoolName))
+	c.logger.Debug("Starting to delete node pool", zap.String("nodepoolName", nodePoolName))
 	_, err := c.agentPoolClient.BeginDelete(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
+	audit.Write(ctx, audit.ActionDeletePool, audit.Target(audit.KindNodepool, nodePoolName), err)
 	if err != nil {
 		c.logger.Error("Failed to delete node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
-		return fmt.Errorf("failed to delete node pool '%s': %v", nodePoolName, err)
+		return fmt.Errorf("failed to delete node pool '%s': %w", nodePoolName, err)
 	}
-	c.logger.Debug(fmt.Sprintf("Node pool '%s' deletion initiated successfully", nodePoolName))
-	return nil
+	c.logger.Debug("Node pool deletion initiated successfully", zap.String("nodepoolName", nodePoolName))
+	return c.waitUntilDeleted(ctx, nodePoolName)
 }
 
 func (c *NodePoolController) CordonNodesByAgentPool(ctx context.Context, nodePoolName string, toCordon bool) error {
-	c.logger.Debug(fmt.Sprintf("Starting to uncordon nodes for agent pool '%s'", nodePoolName))
+	c.logger.Debug("Starting to uncordon nodes of agent pool", zap.String("nodepoolName", nodePoolName))
 
 	nodes, err := c.GetNodesByNodePool(ctx, nodePoolName)
 	if err != nil {
-		return fmt.Errorf("failed to get nodes for agent pool '%s': %v", nodePoolName, err)
+		return fmt.Errorf("failed to get nodes for agent pool '%s': %w", nodePoolName, err)
 	}
 
 	for _, node := range nodes {
-		c.logger.Debug(fmt.Sprintf("Processing node '%s' for uncordoning", node.Name))
+		c.logger.Debug("Processing node for uncordoning", zap.String("nodeName", node.Name))
 		// Check if the node is cordoned
 
 		// Uncordon the node
 		node.Spec.Unschedulable = toCordon
-		_, err := c.kubeClient.CoreV1().Nodes().Update(ctx, &node, metav1.UpdateOptions{})
+		_, err := c.mutationClient.CoreV1().Nodes().Update(ctx, &node, metav1.UpdateOptions{})
+		action := audit.ActionUncordonNode
+		if toCordon {
+			action = audit.ActionCordonNode
+		}
+		audit.Write(ctx, action, audit.Target(audit.KindNode, node.Name), err)
 		if err != nil {
 			c.logger.Error("Failed to set Unschedulable for node", zap.Error(err), zap.String("nodeName", node.Name), zap.Bool("toCordon", toCordon))
-			return fmt.Errorf("failed to set Unschedulable for node '%s': %v", node.Name, err)
+			return fmt.Errorf("failed to set Unschedulable for node '%s': %w", node.Name, err)
 		}
-		c.logger.Debug(fmt.Sprintf("Successfully set Unschedulable to '%t' for node '%s'", toCordon, node.Name))
+		c.logger.Debug("Successfully set Unschedulable of node", zap.Bool("unschedulable", toCordon), zap.String("nodeName", node.Name))
+	}
+
+	c.logger.Debug("Successfully processed all nodes Unschedulable settings of agent pool", zap.String("nodepoolName", nodePoolName))
+	return nil
+}
+
+// CordonNode marks a single node unschedulable, e.g. a straggler which is drained to be replaced
+func (c *NodePoolController) CordonNode(ctx context.Context, node corev1.Node) error {
+	if node.Spec.Unschedulable {
+		return nil
+	}
+	node.Spec.Unschedulable = true
+	_, err := c.mutationClient.CoreV1().Nodes().Update(ctx, &node, metav1.UpdateOptions{})
+	audit.Write(ctx, audit.ActionCordonNode, audit.Target(audit.KindNode, node.Name), err)
+	if err != nil {
+		c.logger.Error("Failed to cordon node", zap.Error(err), zap.String("nodeName", node.Name))
+		return fmt.Errorf("failed to cordon node '%s': %w", node.Name, err)
+	}
+	c.logger.Debug("Successfully cordoned node", zap.String("nodeName", node.Name))
+	return nil
+}
+
+// LabelNodesByAgentPool sets the labels and the annotations on every node of the agent pool, the other labels and
+// annotations of the nodes are kept
+func (c *NodePoolController) LabelNodesByAgentPool(ctx context.Context, nodePoolName string, labels, annotations map[string]string) error {
+	nodes, err := c.GetNodesByNodePool(ctx, nodePoolName)
+	if err != nil {
+		return fmt.Errorf("failed to get nodes for agent pool '%s': %w", nodePoolName, err)
+	}
+	for _, node := range nodes {
+		if node.Labels == nil {
+			node.Labels = map[string]string{}
+		}
+		maps.Copy(node.Labels, labels)
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		maps.Copy(node.Annotations, annotations)
+		_, err := c.mutationClient.CoreV1().Nodes().Update(ctx, &node, metav1.UpdateOptions{})
+		audit.Write(ctx, audit.ActionLabelNode, audit.Target(audit.KindNode, node.Name), err)
+		if err != nil {
+			c.logger.Error("Failed to label node", zap.Error(err), zap.String("nodeName", node.Name))
+			return fmt.Errorf("failed to label node '%s': %w", node.Name, err)
+		}
+	}
+	c.logger.Debug("Labeled the nodes of agent pool", zap.Int("nodes", len(nodes)), zap.String("nodepoolName", nodePoolName))
+	return nil
+}
+
+// DeleteNode deletes the node object, the scale set of its node pool replaces the VM with one of the node image of the
+// node pool. A node which is already gone is not an error.
+func (c *NodePoolController) DeleteNode(ctx context.Context, nodeName string) error {
+	err := c.mutationClient.CoreV1().Nodes().Delete(ctx, nodeName, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		c.logger.Debug("Node is already deleted", zap.String("nodeName", nodeName))
+		return nil
+	}
+	audit.Write(ctx, audit.ActionDeleteNode, audit.Target(audit.KindNode, nodeName), err)
+	if err != nil {
+		c.logger.Error("Failed to delete node", zap.Error(err), zap.String("nodeName", nodeName))
+		return fmt.Errorf("failed to delete node '%s': %w", nodeName, err)
+	}
+	c.logger.Debug("Successfully deleted node", zap.String("nodeName", nodeName))
+	return nil
+}
+
+// GetNodeTaintsByAgentPool returns the taints of every node of the agent pool by node name. The taints managed by
+// Kubernetes, the cloud provider and the cluster-autoscaler are left out.
+func (c *NodePoolController) GetNodeTaintsByAgentPool(ctx context.Context, nodePoolName string) (map[string][]corev1.Taint, error) {
+	nodes, err := c.GetNodesByNodePool(ctx, nodePoolName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes for agent pool '%s': %w", nodePoolName, err)
+	}
+
+	nodeTaints := make(map[string][]corev1.Taint)
+	for _, node := range nodes {
+		nodeTaints[node.Name] = slices.DeleteFunc(slices.Clone(node.Spec.Taints), isManagedTaint)
+	}
+	return nodeTaints, nil
+}
+
+// RestoreNodeTaintsByAgentPool sets the taints of the nodes of the agent pool to the given ones, the taints managed by
+// Kubernetes, the cloud provider and the cluster-autoscaler are kept. Nodes without saved taints, e.g. the nodes
+// added by the upgrade, are not changed.
+func (c *NodePoolController) RestoreNodeTaintsByAgentPool(ctx context.Context, nodePoolName string, nodeTaints map[string][]corev1.Taint) error {
+	c.logger.Debug("Restoring node taints of agent pool", zap.String("nodepoolName", nodePoolName))
+
+	nodes, err := c.GetNodesByNodePool(ctx, nodePoolName)
+	if err != nil {
+		return fmt.Errorf("failed to get nodes for agent pool '%s': %w", nodePoolName, err)
+	}
+
+	for _, node := range nodes {
+		savedTaints, saved := nodeTaints[node.Name]
+		if !saved {
+			continue
+		}
+		if equality.Semantic.DeepEqual(slices.DeleteFunc(slices.Clone(node.Spec.Taints), isManagedTaint), savedTaints) {
+			continue
+		}
+
+		managedTaints := slices.DeleteFunc(slices.Clone(node.Spec.Taints), func(taint corev1.Taint) bool {
+			return !isManagedTaint(taint)
+		})
+		node.Spec.Taints = append(managedTaints, savedTaints...)
+		_, err := c.mutationClient.CoreV1().Nodes().Update(ctx, &node, metav1.UpdateOptions{})
+		audit.Write(ctx, audit.ActionRestoreNodeTaints, audit.Target(audit.KindNode, node.Name), err)
+		if err != nil {
+			c.logger.Error("Failed to restore taints of node", zap.Error(err), zap.String("nodeName", node.Name))
+			return fmt.Errorf("failed to restore taints of node '%s': %w", node.Name, err)
+		}
+		c.logger.Debug("Successfully restored taints of node", zap.String("nodeName", node.Name))
+	}
+	return nil
+}
+
+func isManagedTaint(taint corev1.Taint) bool {
+	if slices.Contains(managedTaintKeys, taint.Key) {
+		return true
+	}
+	return slices.ContainsFunc(managedTaintPrefixes, func(prefix string) bool {
+		return strings.HasPrefix(taint.Key, prefix)
+	})
+}
+
+// SetScaleDownDisabledByAgentPool adds or removes the cluster-autoscaler scale-down-disabled annotation on every node of the agent pool,
+// so the autoscaler does not remove capacity or fight the cordons while the pool is rotated
+func (c *NodePoolController) SetScaleDownDisabledByAgentPool(ctx context.Context, nodePoolName string, disabled bool) error {
+	c.logger.Debug("Setting scale-down-disabled annotation for nodes of agent pool", zap.Bool("disabled", disabled), zap.String("nodepoolName", nodePoolName))
+
+	nodes, err := c.GetNodesByNodePool(ctx, nodePoolName)
+	if err != nil {
+		return fmt.Errorf("failed to get nodes for agent pool '%s': %w", nodePoolName, err)
+	}
+
+	for _, node := range nodes {
+		value, annotated := node.Annotations[ScaleDownDisabledAnnotation]
+		if disabled == (annotated && value == "true") {
+			continue
+		}
+
+		if disabled {
+			if node.Annotations == nil {
+				node.Annotations = make(map[string]string)
+			}
+			node.Annotations[ScaleDownDisabledAnnotation] = "true"
+		} else {
+			delete(node.Annotations, ScaleDownDisabledAnnotation)
+		}
+
+		_, err := c.mutationClient.CoreV1().Nodes().Update(ctx, &node, metav1.UpdateOptions{})
+		audit.Write(ctx, audit.ActionSetScaleDownDisabled, audit.Target(audit.KindNode, node.Name), err)
+		if err != nil {
+			c.logger.Error("Failed to set scale-down-disabled annotation for node", zap.Error(err), zap.String("nodeName", node.Name), zap.Bool("disabled", disabled))
+			return fmt.Errorf("failed to set scale-down-disabled annotation for node '%s': %w", node.Name, err)
+		}
+		c.logger.Debug("Successfully set scale-down-disabled annotation of node", zap.Bool("disabled", disabled), zap.String("nodeName", node.Name))
 	}
 
-	c.logger.Debug(fmt.Sprintf("Successfully processed all nodes Unschedulable settings for agent pool '%s'", nodePoolName))
 	return nil
 }
 
 func (c *NodePoolController) SetDefaultScaling(ctx context.Context, nodepool *armcontainerservice.AgentPool, scalingData string) error {
 
-	if nodepool.Properties != nil && nodepool.Properties.Mode != nil && *nodepool.Properties.ProvisioningState != "Succeeded" {
-		c.logger.Debug(fmt.Sprintf("Skipping scaling settings for agent pool '%s' as its provisioning state is '%s'", *nodepool.Name, *nodepool.Properties.ProvisioningState))
+	if nodepool.Properties != nil && nodepool.Properties.Mode != nil && GetProvisioningState(*nodepool) != ProvisioningStateSucceeded {
+		c.logger.Debug("Skipping scaling settings for agent pool", zap.String("nodepoolName", *nodepool.Name), zap.String("provisioningState", *nodepool.Properties.ProvisioningState))
 		return fmt.Errorf("node pool '%s' is still updating with provisioning state '%s'", *nodepool.Name, *nodepool.Properties.ProvisioningState)
 	}
 
-	c.logger.Debug(fmt.Sprintf("Setting default scaling configuration for node pool '%s'", *nodepool.Name))
-
-	// Parse the scalingData JSON
-	var scalingConfig map[string]int
-	err := json.Unmarshal([]byte(scalingData), &scalingConfig)
-	if err != nil {
-		c.logger.Error("Failed to unmarshal scalingData JSON", zap.Error(err))
-		return fmt.Errorf("failed to parse scalingData JSON: %v", err)
-	}
-
-	// Check if MinCount and MaxCount are present in the JSON
-	minCount, hasMinCount := scalingConfig["MinCount"]
-	maxCount, hasMaxCount := scalingConfig["MaxCount"]
-	count, hasCount := scalingConfig["Count"]
-
-	if hasMinCount && hasMaxCount {
-		// Check if the current scaling configuration matches the desired configuration
-		if nodepool.Properties.EnableAutoScaling != nil &&
-			*nodepool.Properties.EnableAutoScaling &&
-			nodepool.Properties.MinCount != nil &&
-			nodepool.Properties.MaxCount != nil &&
-			*nodepool.Properties.MinCount == int32(minCount) &&
-			*nodepool.Properties.MaxCount == int32(maxCount) {
-			c.logger.Debug(fmt.Sprintf("Node pool '%s' already has autoscaling enabled with MinCount: %d, MaxCount: %d", *nodepool.Name, minCount, maxCount))
-			return nil
-		}
-		// Enable autoscaling and set MinCount and MaxCount
-		nodepool.Properties.EnableAutoScaling = to.Ptr(true)
-		nodepool.Properties.MinCount = to.Ptr(int32(minCount))
-		nodepool.Properties.MaxCount = to.Ptr(int32(maxCount))
-		c.logger.Debug(fmt.Sprintf("Autoscaling enabled for node pool '%s' with MinCount: %d, MaxCount: %d", *nodepool.Name, minCount, maxCount))
-	} else if hasCount {
-		// Disable autoscaling and set Count
-		if nodepool.Properties.EnableAutoScaling != nil &&
-			!*nodepool.Properties.EnableAutoScaling &&
-			nodepool.Properties.Count != nil &&
-			*nodepool.Properties.Count == int32(count) {
-			c.logger.Debug(fmt.Sprintf("Node pool '%s' has been set to manual scaling set with Count: %d", *nodepool.Name, count))
-			return nil
-		}
-		nodepool.Properties.EnableAutoScaling = to.Ptr(false)
-		nodepool.Properties.Count = to.Ptr(int32(count))
-		c.logger.Debug(fmt.Sprintf("Manual scaling set for node pool '%s' with Count: %d", *nodepool.Name, count))
+	c.logger.Debug("Setting default scaling configuration of node pool", zap.String("nodepoolName", *nodepool.Name))
+
+	scaling, err := ParseScaling(scalingData)
+	if err != nil {
+		c.logger.Error("Saved scaling of node pool is invalid, it is not applied", zap.Error(err), zap.String("nodepoolName", *nodepool.Name))
+		return err
+	}
+	if scalingMatches(*nodepool, scaling) {
+		c.logger.Debug("Node pool already has the saved scaling", zap.String("nodepoolName", *nodepool.Name), zap.Bool("autoScaling", scaling.EnableAutoScaling))
+		return nil
+	}
+
+	properties := nodepool.Properties
+	properties.EnableAutoScaling = to.Ptr(scaling.EnableAutoScaling)
+	if scaling.EnableAutoScaling {
+		properties.MinCount = scaling.MinCount
+		properties.MaxCount = scaling.MaxCount
+		c.logger.Debug("Autoscaling enabled for node pool", zap.String("nodepoolName", *nodepool.Name), zap.Int32("minCount", *scaling.MinCount), zap.Int32("maxCount", *scaling.MaxCount))
 	} else {
-		c.logger.Error("ScalingData JSON must contain either MinCount and MaxCount or Count", zap.Error(fmt.Errorf("invalid scalingData JSON")))
+		// AKS refuses the counts of the autoscaler while it is disabled
+		properties.Count = scaling.Count
+		properties.MinCount = nil
+		properties.MaxCount = nil
+		c.logger.Debug("Manual scaling set for node pool", zap.String("nodepoolName", *nodepool.Name), zap.Int32("count", *scaling.Count))
+	}
+	if scaling.ScaleDownMode != nil {
+		properties.ScaleDownMode = scaling.ScaleDownMode
 	}
 
-	c.logger.Debug(fmt.Sprintf("Applying scaling configuration for node pool '%s'", *nodepool.Name))
+	c.logger.Debug("Applying scaling configuration of node pool", zap.String("nodepoolName", *nodepool.Name))
 	// Apply the update
-
+	c.tagOperation(ctx, nodepool, OperationRestoreScaling)
 	_, err = c.agentPoolClient.BeginCreateOrUpdate(ctx, c.clusterResourceGroup, c.clusterName, *nodepool.Name, *nodepool, nil)
+	audit.Write(ctx, audit.ActionRestoreScaling, audit.Target(audit.KindNodepool, *nodepool.Name), err)
 	if err != nil {
 		var responseErr *azcore.ResponseError
-		if errors.As(err, &responseErr) && responseErr.StatusCode == 409 {
-			c.logger.Debug(fmt.Sprintf("Conflict error (409) encountered for agent pool '%s'. Reconciliation will be attempted.", *nodepool.Name))
-			return nil
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusConflict {
+			c.logger.Debug("Conflict error (409) encountered for agent pool, reconciliation will be attempted", zap.String("nodepoolName", *nodepool.Name))
+			return &RetryableError{NodePoolName: *nodepool.Name, Err: err}
 		}
 		c.logger.Error("Failed to update scaling for node pool", zap.Error(err), zap.String("nodePoolName", *nodepool.Name))
-		return fmt.Errorf("failed to update scaling for node pool '%s': %v", *nodepool.Name, err)
+		return fmt.Errorf("failed to update scaling for node pool '%s': %w", *nodepool.Name, err)
+	}
+	if err := c.waitUntilSettled(ctx, *nodepool.Name); err != nil {
+		return err
 	}
 
-	c.logger.Debug(fmt.Sprintf("Scaling configuration successfully updated for node pool '%s'", *nodepool.Name))
+	c.logger.Debug("Scaling configuration successfully updated for node pool", zap.String("nodepoolName", *nodepool.Name))
 	return nil
 }
 
+// ScalingRestored returns true when the node pool finished its updates and has the scaling of the scalingData JSON
+// saved by the controller. It verifies that a scaling update started by SetDefaultScaling actually landed.
+func (c *NodePoolController) ScalingRestored(ctx context.Context, nodePoolName string, scalingData string) (bool, error) {
+	scaling, err := ParseScaling(scalingData)
+	if err != nil {
+		return false, err
+	}
+	nodePool, err := c.GetNodePoolByName(ctx, nodePoolName)
+	if err != nil {
+		return false, err
+	}
+	if GetProvisioningState(*nodePool) != ProvisioningStateSucceeded {
+		return false, nil
+	}
+	if !scalingMatches(*nodePool, scaling) {
+		c.logger.Debug("Node pool does not have the restored scaling yet", zap.String("nodepoolName", nodePoolName))
+		return false, nil
+	}
+	return true, nil
+}
+
+// AutoScalingDisabled returns true when the node pool has autoscaling disabled, the agent pool must be read after
+// DisableAutoScaling to verify that its update landed
+func AutoScalingDisabled(agentPool armcontainerservice.AgentPool) bool {
+	return agentPool.Properties != nil && (agentPool.Properties.EnableAutoScaling == nil || !*agentPool.Properties.EnableAutoScaling)
+}
+
 func (c *NodePoolController) GetNotReadyNodePools(ctx context.Context, nodepools []string) (map[string]armcontainerservice.AgentPool, error) {
 	notReadyNodePools := make(map[string]armcontainerservice.AgentPool)
 
 	for _, nodepoolName := range nodepools {
-		c.logger.Debug(fmt.Sprintf("Checking readiness of node pool '%s'", nodepoolName))
+		c.logger.Debug("Checking readiness of node pool", zap.String("nodepoolName", nodepoolName))
 
 		nodePool, err := c.GetNodePoolByName(ctx, nodepoolName)
 		if err != nil {
 			c.logger.Error("Failed to retrieve node pool", zap.Error(err), zap.String("nodepoolName", nodepoolName))
-			return nil, fmt.Errorf("failed to retrieve node pool '%s': %v", nodepoolName, err)
+			return nil, fmt.Errorf("failed to retrieve node pool '%s': %w", nodepoolName, err)
 		}
 
-		if nodePool.Properties != nil && nodePool.Properties.ProvisioningState != nil && *nodePool.Properties.ProvisioningState != "Succeeded" {
-			c.logger.Debug(fmt.Sprintf("Node pool '%s' is not in a ready state. Current provisioning state: '%s'", nodepoolName, *nodePool.Properties.ProvisioningState))
+		if provisioningState := GetProvisioningState(*nodePool); provisioningState != "" && provisioningState != ProvisioningStateSucceeded {
+			c.logger.Debug("Node pool is not in a ready state", zap.String("nodepoolName", nodepoolName), zap.String("provisioningState", *nodePool.Properties.ProvisioningState))
 			notReadyNodePools[nodepoolName] = *nodePool
 		}
 	}
 
 	return notReadyNodePools, nil
 }
+
+// GetMissingNodePools returns the node pools which do not exist in the cluster, e.g. because they were deleted after
+// they were listed in a SafeEvict
+func (c *NodePoolController) GetMissingNodePools(ctx context.Context, nodepools []string) ([]string, error) {
+	var missingNodePools []string
+	for _, nodepoolName := range nodepools {
+		exists, err := c.NodePoolExists(ctx, nodepoolName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missingNodePools = append(missingNodePools, nodepoolName)
+		}
+	}
+	return missingNodePools, nil
+}
@@ -0,0 +1,70 @@
+package nodepool
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NodePoolControllerInterface upgrades the node pools of an AKS cluster and manages their nodes, it is implemented by
+// NodePoolController
+type NodePoolControllerInterface interface {
+	UpdateNeeded(ctx context.Context, nodePools []string) (map[string]corev1.Node, map[string]armcontainerservice.AgentPool, error)
+	GetStragglerNodes(ctx context.Context, nodePools []string) ([]corev1.Node, error)
+	GetNotReadyNodePools(ctx context.Context, nodepools []string) (map[string]armcontainerservice.AgentPool, error)
+	GetMissingNodePools(ctx context.Context, nodepools []string) ([]string, error)
+	HasRunningStatefulPods(ctx context.Context, nodes []corev1.Node, namespaces []string, selector *metav1.LabelSelector) (bool, error)
+	GetPodsOnNodes(ctx context.Context, nodes []corev1.Node, namespaces []string) ([]corev1.Pod, error)
+	GetPlacementNode(ctx context.Context, nodePoolName string) (*corev1.Node, error)
+	GetNodeAllocatable(ctx context.Context, vmSize string) (corev1.ResourceList, error)
+	GetNodePoolByName(ctx context.Context, nodePoolName string) (*armcontainerservice.AgentPool, error)
+	GetAllAgentPools(ctx context.Context) ([]armcontainerservice.AgentPool, error)
+	GetNodesByNodePool(ctx context.Context, nodePoolName string) ([]corev1.Node, error)
+	HasSchedulableSystemNodes(ctx context.Context, excludedNodePools []string) (bool, error)
+	GetNodePoolCreationTime(ctx context.Context, nodePoolName string) (time.Time, error)
+	GetNodePoolProvisioningState(ctx context.Context, nodePoolName string) (ProvisioningState, error)
+	NodePoolExists(ctx context.Context, nodePoolName string) (bool, error)
+	WaitForState(ctx context.Context, nodePoolName string, state ProvisioningState, timeout time.Duration) error
+
+	CreateTemporaryNodePool(ctx context.Context, newNodePoolName string, sourceNodePoolName string, overrides TemporaryNodePoolOverrides, owner string) error
+	VerifyTemporaryNodePoolOwnership(ctx context.Context, nodePoolName string, owner string) error
+	RemoveTemporaryNodePool(ctx context.Context, nodePoolName string, owner string) error
+	AcquireSharedNodePool(ctx context.Context, nodePoolName string, owner string) (bool, error)
+	ReleaseSharedNodePool(ctx context.Context, nodePoolName string, owner string) (bool, error)
+	DropSharedNodePoolReference(ctx context.Context, nodePoolName string, owner string) (bool, error)
+
+	UpgradeNodeImageVersion(ctx context.Context, nodepool *armcontainerservice.AgentPool) error
+	GetLatestNodeImageVersion(ctx context.Context, nodePoolName string) (string, error)
+	ReimageNode(ctx context.Context, node corev1.Node) error
+	GetReimageProvisioningState(ctx context.Context, node corev1.Node) (ProvisioningState, error)
+	FinishReimage(ctx context.Context, node corev1.Node) error
+
+	DisableAutoScaling(ctx context.Context, agentPools map[string]armcontainerservice.AgentPool) error
+	SetDefaultScaling(ctx context.Context, nodepool *armcontainerservice.AgentPool, scalingData string) error
+	ScalingRestored(ctx context.Context, nodePoolName string, scalingData string) (bool, error)
+	SetScaleDownDisabledByAgentPool(ctx context.Context, nodePoolName string, disabled bool) error
+	ScaleUpNodePool(ctx context.Context, nodePoolName string, maxCount int32) (bool, error)
+	ScaleNodePool(ctx context.Context, nodePoolName string, count int32) (int32, error)
+
+	CordonNodesByAgentPool(ctx context.Context, nodePoolName string, toCordon bool) error
+	CordonNode(ctx context.Context, node corev1.Node) error
+	LabelNodesByAgentPool(ctx context.Context, nodePoolName string, labels, annotations map[string]string) error
+	DeleteNode(ctx context.Context, nodeName string) error
+	GetNodeTaintsByAgentPool(ctx context.Context, nodePoolName string) (map[string][]corev1.Taint, error)
+	RestoreNodeTaintsByAgentPool(ctx context.Context, nodePoolName string, nodeTaints map[string][]corev1.Taint) error
+
+	WithMutationClient(mutationClient kubernetes.Interface) NodePoolControllerInterface
+	WithManagedClusterClient(managedClusterClient ManagedClusterClientInterface) NodePoolControllerInterface
+	WithScaleSetVMsClient(scaleSetVMsClient ScaleSetVMsClientInterface) NodePoolControllerInterface
+	WithCluster(kubeClient kubernetes.Interface, agentPoolClient AgentPoolClientInterface, subscriptionID, clusterResourceGroup, clusterName string) NodePoolControllerInterface
+	WithStatePolling(interval, timeout time.Duration) NodePoolControllerInterface
+	WithOperationTags(enabled bool) NodePoolControllerInterface
+	WithLogFields(fields ...zap.Field) NodePoolControllerInterface
+}
+
+var _ NodePoolControllerInterface = &NodePoolController{}
@@ -0,0 +1,595 @@
+package nodepool
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	"norbinto/node-updater/internal/audit"
+	"norbinto/node-updater/internal/identity"
+)
+
+const (
+	testOldNodeImage    = "AKSUbuntu-2204gen2containerd-202501.01.0"
+	testLatestNodeImage = "AKSUbuntu-2204gen2containerd-202502.01.0"
+)
+
+func newTestController(t *testing.T, client *scriptedAgentPoolClient, objects ...runtime.Object) *NodePoolController {
+	return NewNodePoolController(kubefake.NewSimpleClientset(objects...), client, "sub", "rg", "cluster", zaptest.NewLogger(t))
+}
+
+func testNode(name, agentPool string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{AgentPoolLabel: agentPool}}}
+}
+
+func TestUpdateNeeded(t *testing.T) {
+	tests := []struct {
+		name          string
+		pools         map[string]string
+		expectedPools []string
+		expectedNodes []string
+	}{
+		{name: "up to date", pools: map[string]string{"pool1": testLatestNodeImage}},
+		{name: "outdated", pools: map[string]string{"pool1": testOldNodeImage}, expectedPools: []string{"pool1"}, expectedNodes: []string{"pool1-0"}},
+		{name: "only outdated pools", pools: map[string]string{"pool1": testLatestNodeImage, "pool2": testOldNodeImage}, expectedPools: []string{"pool2"}, expectedNodes: []string{"pool2-0"}},
+		{name: "missing pool is skipped", pools: map[string]string{"pool2": testOldNodeImage}, expectedPools: []string{"pool2"}, expectedNodes: []string{"pool2-0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newScriptedAgentPoolClient(testLatestNodeImage)
+			for name, nodeImageVersion := range tt.pools {
+				client.script(name, agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{NodeImageVersion: to.Ptr(nodeImageVersion)}))
+			}
+			controller := newTestController(t, client, testNode("pool1-0", "pool1"), testNode("pool2-0", "pool2"))
+
+			outdatedNodes, outdatedNodePools, err := controller.UpdateNeeded(context.Background(), []string{"pool1", "pool2"})
+
+			if err != nil {
+				t.Fatalf("UpdateNeeded returned error: %v", err)
+			}
+			if pools := slices.Sorted(maps.Keys(outdatedNodePools)); !slices.Equal(pools, tt.expectedPools) {
+				t.Errorf("expected outdated pools %v, got %v", tt.expectedPools, pools)
+			}
+			if nodes := slices.Sorted(maps.Keys(outdatedNodes)); !slices.Equal(nodes, tt.expectedNodes) {
+				t.Errorf("expected outdated nodes %v, got %v", tt.expectedNodes, nodes)
+			}
+		})
+	}
+}
+
+func TestGetAllAgentPools(t *testing.T) {
+	client := newScriptedAgentPoolClient(testLatestNodeImage)
+	client.listPageSize = 2
+	for _, name := range []string{"userpool", "agentpool", "system"} {
+		client.script(name, agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{}))
+	}
+	controller := newTestController(t, client)
+
+	agentPools, err := controller.GetAllAgentPools(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllAgentPools returned error: %v", err)
+	}
+	var names []string
+	for _, agentPool := range agentPools {
+		names = append(names, *agentPool.Name)
+	}
+	if !slices.Equal(names, []string{"agentpool", "system", "userpool"}) {
+		t.Errorf("expected every node pool of every page in name order, got %v", names)
+	}
+
+	client.listErrors = []error{nil, responseError(http.StatusTooManyRequests, "TooManyRequests")}
+	if _, err := controller.GetAllAgentPools(context.Background()); err == nil {
+		t.Error("expected the error of the second page")
+	}
+}
+
+func TestHasRunningStatefulPods_BlockingPodSelector(t *testing.T) {
+	runningPod := func(name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "agents", Labels: labels},
+			Spec:       corev1.PodSpec{NodeName: "pool1-0"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	node := testNode("pool1-0", "pool1")
+	controller := newTestController(t, newScriptedAgentPoolClient(testLatestNodeImage), node, runningPod("cache", map[string]string{"app": "cache"}))
+	nodes := []corev1.Node{*node}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "agent"}}
+
+	if blocked, err := controller.HasRunningStatefulPods(context.Background(), nodes, []string{"agents"}, nil); err != nil || !blocked {
+		t.Errorf("expected every running pod to block without a selector, got %t, %v", blocked, err)
+	}
+	if blocked, err := controller.HasRunningStatefulPods(context.Background(), nodes, []string{"agents"}, selector); err != nil || blocked {
+		t.Errorf("expected the unrelated pod not to block, got %t, %v", blocked, err)
+	}
+
+	if _, err := controller.kubeClient.CoreV1().Pods("agents").Create(context.Background(), runningPod("agent", map[string]string{"app": "agent"}), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+	if blocked, err := controller.HasRunningStatefulPods(context.Background(), nodes, []string{"agents"}, selector); err != nil || !blocked {
+		t.Errorf("expected the agent to block, got %t, %v", blocked, err)
+	}
+}
+
+func TestHasSchedulableSystemNodes(t *testing.T) {
+	systemNode := func(name, pool string, ready, unschedulable bool) *corev1.Node {
+		node := testNode(name, pool)
+		node.Labels[AKSModeLabel] = "system"
+		node.Spec.Unschedulable = unschedulable
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}
+		return node
+	}
+	tests := []struct {
+		name     string
+		nodes    []runtime.Object
+		expected bool
+	}{
+		{name: "other system pool", nodes: []runtime.Object{systemNode("system-0", "system", true, false), systemNode("extra-0", "extra", true, false)}, expected: true},
+		{name: "only the excluded pool", nodes: []runtime.Object{systemNode("system-0", "system", true, false)}},
+		{name: "cordoned", nodes: []runtime.Object{systemNode("system-0", "system", true, false), systemNode("extra-0", "extra", true, true)}},
+		{name: "not ready", nodes: []runtime.Object{systemNode("system-0", "system", true, false), systemNode("extra-0", "extra", false, false)}},
+		{name: "user node", nodes: []runtime.Object{systemNode("system-0", "system", true, false), testNode("pool1-0", "pool1")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := newTestController(t, newScriptedAgentPoolClient(testLatestNodeImage), tt.nodes...)
+
+			found, err := controller.HasSchedulableSystemNodes(context.Background(), []string{"system"})
+
+			if err != nil || found != tt.expected {
+				t.Errorf("expected %t, got %t, %v", tt.expected, found, err)
+			}
+		})
+	}
+}
+
+func TestDisableAutoScaling(t *testing.T) {
+	tests := []struct {
+		name            string
+		agentPool       armcontainerservice.AgentPool
+		updateErr       error
+		expectUpdate    bool
+		expectRetryable bool
+		expectErr       bool
+	}{
+		{
+			name:         "enabled",
+			agentPool:    agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(true)}),
+			expectUpdate: true,
+		},
+		{
+			name:      "already disabled",
+			agentPool: agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(false)}),
+		},
+		{
+			name: "system pool",
+			agentPool: agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				EnableAutoScaling: to.Ptr(true),
+				Mode:              to.Ptr(armcontainerservice.AgentPoolModeSystem),
+			}),
+			expectUpdate: true,
+		},
+		{
+			name:      "updating",
+			agentPool: agentPool(ProvisioningStateUpdating, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(true)}),
+		},
+		{
+			name:            "conflict",
+			agentPool:       agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(true)}),
+			updateErr:       responseError(http.StatusConflict, "OperationNotAllowed"),
+			expectRetryable: true,
+			expectErr:       true,
+		},
+		{
+			name:      "server error",
+			agentPool: agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(true)}),
+			updateErr: responseError(http.StatusInternalServerError, "InternalServerError"),
+			expectErr: true,
+		},
+		{
+			name:      "no properties",
+			agentPool: armcontainerservice.AgentPool{},
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newScriptedAgentPoolClient(testLatestNodeImage)
+			client.updateErrors = []error{tt.updateErr}
+			controller := newTestController(t, client)
+			tt.agentPool.Name = to.Ptr("pool1")
+
+			err := controller.DisableAutoScaling(context.Background(), map[string]armcontainerservice.AgentPool{"pool1": tt.agentPool})
+
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expected error %v, got %v", tt.expectErr, err)
+			}
+			if IsRetryable(err) != tt.expectRetryable {
+				t.Errorf("expected retryable %v, got %v", tt.expectRetryable, err)
+			}
+			if (len(client.updates) > 0) != tt.expectUpdate {
+				t.Fatalf("expected update %v, got %d updates", tt.expectUpdate, len(client.updates))
+			}
+			if tt.expectUpdate && *client.updates[0].Properties.EnableAutoScaling {
+				t.Error("expected the update to disable the autoscaling")
+			}
+		})
+	}
+}
+
+func TestDisableAutoScaling_WaitsUntilSettled(t *testing.T) {
+	tests := []struct {
+		name        string
+		states      []ProvisioningState
+		expectedErr error
+	}{
+		{name: "settles", states: []ProvisioningState{ProvisioningStateUpdating, ProvisioningStateUpdating, ProvisioningStateSucceeded}},
+		{name: "fails", states: []ProvisioningState{ProvisioningStateUpdating, ProvisioningStateFailed}, expectedErr: ErrProvisioningFailed},
+		{name: "keeps updating", states: []ProvisioningState{ProvisioningStateUpdating}, expectedErr: ErrProvisioningTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newScriptedAgentPoolClient(testLatestNodeImage)
+			for _, state := range tt.states {
+				client.script("pool1", agentPool(state, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(false)}))
+			}
+			controller := newTestController(t, client).WithStatePolling(time.Millisecond, 100*time.Millisecond)
+			enabled := agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(true)})
+			enabled.Name = to.Ptr("pool1")
+
+			err := controller.DisableAutoScaling(context.Background(), map[string]armcontainerservice.AgentPool{"pool1": enabled})
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected error %v, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestSetDefaultScaling(t *testing.T) {
+	autoScaling := armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(true), MinCount: to.Ptr(int32(1)), MaxCount: to.Ptr(int32(3))}
+	manualScaling := armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(false), Count: to.Ptr(int32(2))}
+	tests := []struct {
+		name              string
+		provisioningState ProvisioningState
+		properties        armcontainerservice.ManagedClusterAgentPoolProfileProperties
+		scalingData       string
+		updateErr         error
+		expected          *armcontainerservice.ManagedClusterAgentPoolProfileProperties
+		expectRetryable   bool
+		expectInvalid     bool
+		expectErr         bool
+	}{
+		{
+			name:        "restores autoscaling",
+			properties:  manualScaling,
+			scalingData: `{"MinCount": 1, "MaxCount": 3}`,
+			expected:    &armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(true), MinCount: to.Ptr(int32(1)), MaxCount: to.Ptr(int32(3))},
+		},
+		{
+			name:        "autoscaling already restored",
+			properties:  autoScaling,
+			scalingData: `{"MinCount": 1, "MaxCount": 3}`,
+		},
+		{
+			name:        "changes autoscaling bounds",
+			properties:  autoScaling,
+			scalingData: `{"MinCount": 2, "MaxCount": 5}`,
+			expected:    &armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(true), MinCount: to.Ptr(int32(2)), MaxCount: to.Ptr(int32(5))},
+		},
+		{
+			name:        "restores manual scaling",
+			properties:  autoScaling,
+			scalingData: `{"Count": 4}`,
+			expected:    &armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(false), Count: to.Ptr(int32(4))},
+		},
+		{
+			name:        "manual scaling already restored",
+			properties:  manualScaling,
+			scalingData: `{"Count": 2}`,
+		},
+		{
+			name:          "invalid JSON",
+			properties:    manualScaling,
+			scalingData:   `{"Count": "two"}`,
+			expectInvalid: true,
+			expectErr:     true,
+		},
+		{
+			name:        "restores version 2 scaling",
+			properties:  autoScaling,
+			scalingData: `{"Version": 2, "EnableAutoScaling": false, "Count": 4, "ScaleDownMode": "Deallocate"}`,
+			expected: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				EnableAutoScaling: to.Ptr(false), Count: to.Ptr(int32(4)), ScaleDownMode: to.Ptr(armcontainerservice.ScaleDownModeDeallocate),
+			},
+		},
+		{
+			name:          "newer schema version",
+			properties:    manualScaling,
+			scalingData:   `{"Version": 3, "EnableAutoScaling": false, "Count": 4}`,
+			expectInvalid: true,
+			expectErr:     true,
+		},
+		{
+			name:          "neither counts",
+			properties:    manualScaling,
+			scalingData:   `{"MinCount": 1}`,
+			expectInvalid: true,
+			expectErr:     true,
+		},
+		{
+			name:              "updating",
+			provisioningState: ProvisioningStateUpdating,
+			properties:        manualScaling,
+			scalingData:       `{"Count": 4}`,
+			expectErr:         true,
+		},
+		{
+			name:            "conflict",
+			properties:      manualScaling,
+			scalingData:     `{"Count": 4}`,
+			updateErr:       responseError(http.StatusConflict, "OperationNotAllowed"),
+			expectRetryable: true,
+			expectErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newScriptedAgentPoolClient(testLatestNodeImage)
+			client.updateErrors = []error{tt.updateErr}
+			controller := newTestController(t, client)
+			provisioningState := tt.provisioningState
+			if provisioningState == "" {
+				provisioningState = ProvisioningStateSucceeded
+			}
+			nodePool := agentPool(provisioningState, tt.properties)
+			nodePool.Name = to.Ptr("pool1")
+
+			err := controller.SetDefaultScaling(context.Background(), &nodePool, tt.scalingData)
+
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expected error %v, got %v", tt.expectErr, err)
+			}
+			if IsRetryable(err) != tt.expectRetryable {
+				t.Errorf("expected retryable %v, got %v", tt.expectRetryable, err)
+			}
+			if IsInvalidScaling(err) != tt.expectInvalid {
+				t.Errorf("expected invalid scaling %v, got %v", tt.expectInvalid, err)
+			}
+			if tt.expected == nil {
+				if len(client.updates) > 0 {
+					t.Errorf("expected no update, got %d", len(client.updates))
+				}
+				return
+			}
+			if len(client.updates) != 1 {
+				t.Fatalf("expected 1 update, got %d", len(client.updates))
+			}
+			if properties := client.updates[0].Properties; !*properties.EnableAutoScaling && (properties.MinCount != nil || properties.MaxCount != nil) {
+				t.Errorf("expected the counts of the autoscaler to be cleared without autoscaling, got %s", scalingConfigOf(properties).JSON())
+			}
+			if !scalingMatches(client.updates[0], scalingConfigOf(tt.expected)) {
+				t.Errorf("expected the scaling of %s to be applied", tt.scalingData)
+			}
+		})
+	}
+}
+
+func TestUpgradeNodeImageVersion(t *testing.T) {
+	tests := []struct {
+		name              string
+		provisioningState ProvisioningState
+		nodeImageVersion  string
+		upgradeErr        error
+		expectUpgrade     bool
+		expectedErr       error
+		expectedAudit     audit.Outcome
+	}{
+		{name: "outdated", provisioningState: ProvisioningStateSucceeded, nodeImageVersion: testOldNodeImage, expectUpgrade: true, expectedAudit: audit.OutcomeSucceeded},
+		{name: "already upgrading", provisioningState: ProvisioningStateUpgradingNodeImageVersion, nodeImageVersion: testOldNodeImage},
+		{name: "updating", provisioningState: ProvisioningStateUpdating, nodeImageVersion: testOldNodeImage},
+		{name: "up to date", provisioningState: ProvisioningStateSucceeded, nodeImageVersion: testLatestNodeImage},
+		{name: "failed", provisioningState: ProvisioningStateFailed, nodeImageVersion: testOldNodeImage, expectedErr: ErrUpgradeNotPermitted},
+		{
+			name:              "conflict",
+			provisioningState: ProvisioningStateSucceeded,
+			nodeImageVersion:  testOldNodeImage,
+			upgradeErr:        responseError(http.StatusConflict, "OperationNotAllowed"),
+			expectedErr:       ErrUpgradeNotPermitted,
+			expectedAudit:     audit.OutcomeFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newScriptedAgentPoolClient(testLatestNodeImage)
+			client.upgradeErrors = []error{tt.upgradeErr}
+			controller := newTestController(t, client)
+			nodePool := agentPool(tt.provisioningState, armcontainerservice.ManagedClusterAgentPoolProfileProperties{NodeImageVersion: to.Ptr(tt.nodeImageVersion)})
+			nodePool.Name = to.Ptr("pool1")
+			var records []audit.Record
+			ctx := audit.WithSafeEvict(context.Background(), audit.NewAuditor(zaptest.NewLogger(t), auditSinkFunc(func(record audit.Record) {
+				records = append(records, record)
+			})), "default", "rotation")
+
+			err := controller.UpgradeNodeImageVersion(ctx, &nodePool)
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if upgraded := len(client.upgrades) > 0; upgraded != tt.expectUpgrade {
+				t.Errorf("expected upgrade %v, got upgrades %v", tt.expectUpgrade, client.upgrades)
+			}
+			if tt.expectedAudit == "" && len(records) > 0 {
+				t.Errorf("expected no audit record without an upgrade call, got %+v", records)
+			}
+			if tt.expectedAudit != "" && (len(records) != 1 || records[0].Action != audit.ActionUpgradePool || records[0].Target != "nodepool/pool1" || records[0].Outcome != tt.expectedAudit) {
+				t.Errorf("expected the upgrade to be audited as %s, got %+v", tt.expectedAudit, records)
+			}
+		})
+	}
+}
+
+type auditSinkFunc func(record audit.Record)
+
+func (f auditSinkFunc) Write(_ context.Context, record audit.Record) error {
+	f(record)
+	return nil
+}
+
+func TestScalingRestored(t *testing.T) {
+	client := newScriptedAgentPoolClient(testLatestNodeImage)
+	client.script("pool1",
+		agentPool(ProvisioningStateUpdating, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(false), Count: to.Ptr(int32(4))}),
+		agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(false), Count: to.Ptr(int32(2))}),
+		agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{EnableAutoScaling: to.Ptr(false), Count: to.Ptr(int32(4))}))
+	controller := newTestController(t, client)
+
+	for i, expected := range []bool{false, false, true} {
+		restored, err := controller.ScalingRestored(context.Background(), "pool1", `{"Count": 4}`)
+		if err != nil {
+			t.Fatalf("ScalingRestored returned error: %v", err)
+		}
+		if restored != expected {
+			t.Errorf("expected restored %v at call %d, got %v", expected, i, restored)
+		}
+	}
+}
+
+func TestParseScaling(t *testing.T) {
+	autoScaling := armcontainerservice.AgentPool{Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+		EnableAutoScaling: to.Ptr(true), Count: to.Ptr(int32(2)), MinCount: to.Ptr(int32(1)), MaxCount: to.Ptr(int32(3)),
+		ScaleDownMode: to.Ptr(armcontainerservice.ScaleDownModeDelete),
+	}}
+	for _, tc := range []struct {
+		name        string
+		scalingData string
+		expected    SavedScaling
+	}{
+		{"version 1 autoscaling", `{"MinCount": 1, "MaxCount": 3}`, SavedScaling{EnableAutoScaling: true, MinCount: to.Ptr(int32(1)), MaxCount: to.Ptr(int32(3))}},
+		{"version 1 manual scaling", `{"Count": 2}`, SavedScaling{Count: to.Ptr(int32(2))}},
+		{"version 2", SavedScalingOf(autoScaling).JSON(), SavedScalingOf(autoScaling)},
+		{"unknown field", `{"Version": 2, "EnableAutoScaling": false, "Count": 2, "Future": true}`, SavedScaling{Version: 2, Count: to.Ptr(int32(2))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scaling, err := ParseScaling(tc.scalingData)
+			if err != nil {
+				t.Fatalf("ParseScaling returned error: %v", err)
+			}
+			if scaling.JSON() != tc.expected.JSON() {
+				t.Errorf("expected %s, got %s", tc.expected.JSON(), scaling.JSON())
+			}
+		})
+	}
+	for _, scalingData := range []string{
+		`{"Version": 2, "EnableAutoScaling": true, "Count": 2}`,
+		`{"Version": 2, "EnableAutoScaling": false, "MinCount": 1, "MaxCount": 3}`,
+	} {
+		if _, err := ParseScaling(scalingData); !IsInvalidScaling(err) {
+			t.Errorf("expected %s to be invalid, got %v", scalingData, err)
+		}
+	}
+}
+
+// scalingConfigOf returns the saved scaling of the properties
+func scalingConfigOf(properties *armcontainerservice.ManagedClusterAgentPoolProfileProperties) SavedScaling {
+	return SavedScalingOf(armcontainerservice.AgentPool{Properties: properties})
+}
+
+func TestScaleNodePool(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		mode     armcontainerservice.AgentPoolMode
+		current  int32
+		count    int32
+		expected int32
+		updated  bool
+	}{
+		{"scale down user pool", armcontainerservice.AgentPoolModeUser, 3, 0, 0, true},
+		{"scale down system pool", armcontainerservice.AgentPoolModeSystem, 3, 0, 1, true},
+		{"scale up", armcontainerservice.AgentPoolModeUser, 0, 3, 3, true},
+		{"scaled", armcontainerservice.AgentPoolModeUser, 0, 0, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newScriptedAgentPoolClient(testLatestNodeImage)
+			client.script("pool1", agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+				Mode:  to.Ptr(tt.mode),
+				Count: to.Ptr(tt.current),
+			}))
+			controller := newTestController(t, client)
+
+			previous, err := controller.ScaleNodePool(context.Background(), "pool1", tt.count)
+			if err != nil {
+				t.Fatalf("ScaleNodePool returned error: %v", err)
+			}
+
+			if previous != tt.current {
+				t.Errorf("expected the previous count %d, got %d", tt.current, previous)
+			}
+			if !tt.updated {
+				if len(client.updates) != 0 {
+					t.Errorf("expected no update, got %d", len(client.updates))
+				}
+				return
+			}
+			if len(client.updates) != 1 {
+				t.Fatalf("expected one update, got %d", len(client.updates))
+			}
+			properties := client.updates[0].Properties
+			if *properties.EnableAutoScaling || *properties.Count != tt.expected {
+				t.Errorf("expected %d nodes without autoscaling, got %s", tt.expected, scalingConfigOf(properties).JSON())
+			}
+		})
+	}
+}
+
+func TestScaleUpNodePool_OperationTags(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		client := newScriptedAgentPoolClient(testLatestNodeImage)
+		client.script("pool1", agentPool(ProvisioningStateSucceeded, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+			Count: to.Ptr(int32(1)),
+			Tags:  map[string]*string{"team": to.Ptr("ci")},
+		}))
+		controller := newTestController(t, client).WithOperationTags(enabled)
+		ctx := identity.WithCorrelationID(context.Background(), "reconcile-1")
+
+		if _, err := controller.ScaleUpNodePool(ctx, "pool1", 2); err != nil {
+			t.Fatalf("ScaleUpNodePool returned error: %v", err)
+		}
+
+		tags := client.updates[0].Properties.Tags
+		if tags["team"] == nil || *tags["team"] != "ci" {
+			t.Errorf("expected the tags of the node pool to be kept, got %v", tags)
+		}
+		if !enabled {
+			if _, ok := tags[OperationTagKey]; ok {
+				t.Errorf("expected no operation tags, got %v", tags)
+			}
+			continue
+		}
+		if tags[OperationTagKey] == nil || *tags[OperationTagKey] != OperationScaleUp {
+			t.Errorf("expected the operation %s, got %v", OperationScaleUp, tags[OperationTagKey])
+		}
+		if tags[OperationCorrelationTagKey] == nil || *tags[OperationCorrelationTagKey] != "reconcile-1" {
+			t.Errorf("expected the correlation ID of the reconcile, got %v", tags[OperationCorrelationTagKey])
+		}
+		if _, ok := tags[OperationAtTagKey]; !ok {
+			t.Error("expected the time of the operation")
+		}
+	}
+}
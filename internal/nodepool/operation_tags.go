@@ -0,0 +1,60 @@
+package nodepool
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+
+	"norbinto/node-updater/internal/identity"
+)
+
+const (
+	// OperationTagKey is the ARM tag which holds the last operation the controller started on a node pool
+	OperationTagKey = "node-updater-operation"
+	// OperationAtTagKey is the ARM tag which holds the start time of the last operation of the controller
+	OperationAtTagKey = "node-updater-operation-at"
+	// OperationCorrelationTagKey is the ARM tag which holds the correlation ID of the reconcile which started the last
+	// operation of the controller
+	OperationCorrelationTagKey = "node-updater-correlation-id"
+)
+
+// The operations the controller records in the OperationTagKey tag
+const (
+	OperationCreateTemporaryNodePool = "create-temporary-nodepool"
+	OperationDisableAutoScaling      = "disable-autoscaling"
+	OperationScaleUp                 = "scale-up"
+	OperationScaleDown               = "scale-down"
+	OperationRestoreScaling          = "restore-scaling"
+	OperationUpdateSharedReferences  = "update-shared-references"
+)
+
+// WithOperationTags returns a copy of the NodePoolController which tags every node pool it updates with the operation,
+// its start time and the correlation ID of the reconcile. The tags are part of the request ARM records in the activity
+// log, so a change of the controller can be told apart from a manual one.
+func (c *NodePoolController) WithOperationTags(enabled bool) NodePoolControllerInterface {
+	controller := *c
+	controller.operationTags = enabled
+	return &controller
+}
+
+// tagOperation adds the operation tags to the node pool which is about to be sent to ARM, when they are enabled
+func (c *NodePoolController) tagOperation(ctx context.Context, nodePool *armcontainerservice.AgentPool, operation string) {
+	if !c.operationTags || nodePool.Properties == nil {
+		return
+	}
+	tags := maps.Clone(nodePool.Properties.Tags)
+	if tags == nil {
+		tags = make(map[string]*string)
+	}
+	tags[OperationTagKey] = to.Ptr(operation)
+	tags[OperationAtTagKey] = to.Ptr(time.Now().UTC().Format(time.RFC3339))
+	if correlationID := identity.CorrelationID(ctx); correlationID != "" {
+		tags[OperationCorrelationTagKey] = to.Ptr(correlationID)
+	} else {
+		delete(tags, OperationCorrelationTagKey)
+	}
+	nodePool.Properties.Tags = tags
+}
@@ -0,0 +1,218 @@
+package nodepool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const (
+	// AKSAgentPoolLabel holds the name of the node pool of an AKS node next to the AgentPoolLabel
+	AKSAgentPoolLabel = "kubernetes.azure.com/agentpool"
+	// AKSModeLabel holds the mode of the node pool of an AKS node, "system" or "user"
+	AKSModeLabel = "kubernetes.azure.com/mode"
+)
+
+// nodeSelectorOperators translates the operators of the node selector requirements into label selector operators
+var nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// GetPlacementNode returns a node which stands for the nodes the pods of the node pool would be scheduled on: one of its
+// nodes, or a node with the labels and the taints AKS gives its nodes when it has none yet. It returns nil when the node
+// pool does not exist.
+func (c *NodePoolController) GetPlacementNode(ctx context.Context, nodePoolName string) (*corev1.Node, error) {
+	nodes, err := c.GetNodesByNodePool(ctx, nodePoolName)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) > 0 {
+		return &nodes[0], nil
+	}
+	nodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		c.logger.Error("Failed to get node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
+		return nil, fmt.Errorf("unable to get node pool '%s': %w", nodePoolName, err)
+	}
+	c.logger.Debug("Node pool has no nodes, using the node labels and taints of its configuration", zap.String("nodepoolName", nodePoolName))
+	node := NodeTemplate(nodePool.AgentPool)
+	return &node, nil
+}
+
+// GetPodsOnNodes returns the pods of the namespaces which are scheduled on the nodes and not terminating, the pods of
+// DaemonSets are left out as they are not moved to other nodes
+func (c *NodePoolController) GetPodsOnNodes(ctx context.Context, nodes []corev1.Node, namespaces []string) ([]corev1.Pod, error) {
+	nodeNames := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		nodeNames[node.Name] = true
+	}
+	var pods []corev1.Pod
+	for _, namespace := range namespaces {
+		podList, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			c.logger.Error("Failed to list pods in namespace", zap.Error(err), zap.String("namespace", namespace))
+			return nil, fmt.Errorf("failed to list pods in namespace '%s': %w", namespace, err)
+		}
+		for _, pod := range podList.Items {
+			if !nodeNames[pod.Spec.NodeName] || pod.DeletionTimestamp != nil || isDaemonSetPod(pod) {
+				continue
+			}
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+func isDaemonSetPod(pod corev1.Pod) bool {
+	owner := metav1.GetControllerOf(&pod)
+	return owner != nil && owner.Kind == "DaemonSet"
+}
+
+// NodeTemplate returns a node with the labels and the taints AKS gives the nodes of the node pool
+func NodeTemplate(agentPool armcontainerservice.AgentPool) corev1.Node {
+	node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{}}}
+	if agentPool.Name != nil {
+		node.Name = *agentPool.Name
+		node.Labels[AgentPoolLabel] = *agentPool.Name
+		node.Labels[AKSAgentPoolLabel] = *agentPool.Name
+	}
+	properties := agentPool.Properties
+	if properties == nil {
+		return node
+	}
+	node.Labels[corev1.LabelOSStable] = "linux"
+	if properties.OSType != nil {
+		node.Labels[corev1.LabelOSStable] = strings.ToLower(string(*properties.OSType))
+	}
+	if properties.VMSize != nil {
+		node.Labels[corev1.LabelInstanceTypeStable] = *properties.VMSize
+		node.Labels[corev1.LabelArchStable] = GetVMSizeArchitecture(*properties.VMSize)
+	}
+	if properties.Mode != nil {
+		node.Labels[AKSModeLabel] = strings.ToLower(string(*properties.Mode))
+	}
+	for key, value := range properties.NodeLabels {
+		if value != nil {
+			node.Labels[key] = *value
+		}
+	}
+	for _, nodeTaint := range properties.NodeTaints {
+		if nodeTaint != nil {
+			node.Spec.Taints = append(node.Spec.Taints, parseNodeTaint(*nodeTaint))
+		}
+	}
+	return node
+}
+
+// parseNodeTaint parses a taint in the AKS format key=value:Effect
+func parseNodeTaint(nodeTaint string) corev1.Taint {
+	keyValue, effect, _ := strings.Cut(nodeTaint, ":")
+	key, value, _ := strings.Cut(keyValue, "=")
+	return corev1.Taint{Key: key, Value: value, Effect: corev1.TaintEffect(effect)}
+}
+
+// UnschedulableReason returns why the pod cannot be scheduled on the node because of its node selector, its required
+// node affinity or a taint of the node it does not tolerate. It is empty when the pod fits, the resources of the node
+// are not compared.
+func UnschedulableReason(pod corev1.Pod, node corev1.Node) string {
+	nodeLabels := labels.Set(node.Labels)
+	for key, value := range pod.Spec.NodeSelector {
+		if !nodeLabels.Has(key) || nodeLabels.Get(key) != value {
+			return fmt.Sprintf("node selector %s=%s does not match", key, value)
+		}
+	}
+	if affinity := pod.Spec.Affinity; affinity != nil && affinity.NodeAffinity != nil {
+		if required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil && !matchesNodeSelector(*required, node) {
+			return "required node affinity does not match"
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect != corev1.TaintEffectNoSchedule && taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		// the taints of a cordoned or not ready node go away, the pod is not kept off the node pool by them
+		if isManagedTaint(taint) {
+			continue
+		}
+		if !toleratesTaint(pod.Spec.Tolerations, taint) {
+			return fmt.Sprintf("taint %s does not have a toleration", taint.ToString())
+		}
+	}
+	return ""
+}
+
+// matchesNodeSelector returns whether any term of the node selector matches the node
+func matchesNodeSelector(nodeSelector corev1.NodeSelector, node corev1.Node) bool {
+	for _, term := range nodeSelector.NodeSelectorTerms {
+		if matchesNodeSelectorTerm(term, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNodeSelectorTerm returns whether every requirement of the term matches the node, a term without requirements
+// matches no node
+func matchesNodeSelectorTerm(term corev1.NodeSelectorTerm, node corev1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	nodeLabels := labels.Set(node.Labels)
+	for _, expression := range term.MatchExpressions {
+		if !matchesRequirement(expression, nodeLabels) {
+			return false
+		}
+	}
+	// metadata.name is the only field which can be selected
+	nodeFields := labels.Set{"metadata.name": node.Name}
+	for _, field := range term.MatchFields {
+		if !matchesRequirement(field, nodeFields) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesRequirement returns whether the requirement matches the labels, an invalid requirement matches nothing
+func matchesRequirement(requirement corev1.NodeSelectorRequirement, set labels.Set) bool {
+	operator, ok := nodeSelectorOperators[requirement.Operator]
+	if !ok {
+		return false
+	}
+	selector, err := labels.NewRequirement(requirement.Key, operator, requirement.Values)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(set)
+}
+
+func toleratesTaint(tolerations []corev1.Toleration, taint corev1.Taint) bool {
+	for _, toleration := range tolerations {
+		if toleration.ToleratesTaint(&taint) {
+			return true
+		}
+	}
+	return false
+}
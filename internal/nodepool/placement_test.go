@@ -0,0 +1,63 @@
+package nodepool
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestUnschedulableReason(t *testing.T) {
+	node := NodeTemplate(armcontainerservice.AgentPool{
+		Name: to.Ptr("tmpagents"),
+		Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+			VMSize:     to.Ptr("Standard_D4ps_v5"),
+			OSType:     to.Ptr(armcontainerservice.OSTypeLinux),
+			Mode:       to.Ptr(armcontainerservice.AgentPoolModeUser),
+			NodeLabels: map[string]*string{"team": to.Ptr("ci"), "cores": to.Ptr("4")},
+			NodeTaints: []*string{to.Ptr("dedicated=agents:NoSchedule"), to.Ptr("spot=true:PreferNoSchedule")},
+		},
+	})
+	tolerateAgents := []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "agents", Effect: corev1.TaintEffectNoSchedule}}
+	requireNode := func(terms ...corev1.NodeSelectorTerm) *corev1.Affinity {
+		return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+		}}
+	}
+	term := func(key string, operator corev1.NodeSelectorOperator, values ...string) corev1.NodeSelectorTerm {
+		return corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: key, Operator: operator, Values: values}}}
+	}
+
+	tests := []struct {
+		name        string
+		spec        corev1.PodSpec
+		schedulable bool
+	}{
+		{"tolerated taint", corev1.PodSpec{Tolerations: tolerateAgents}, true},
+		{"untolerated taint", corev1.PodSpec{}, false},
+		{"matching node selector", corev1.PodSpec{Tolerations: tolerateAgents, NodeSelector: map[string]string{"team": "ci", corev1.LabelArchStable: ArchitectureARM64}}, true},
+		{"node selector of another architecture", corev1.PodSpec{Tolerations: tolerateAgents, NodeSelector: map[string]string{corev1.LabelArchStable: ArchitectureAMD64}}, false},
+		{"node selector of the agent pool", corev1.PodSpec{Tolerations: tolerateAgents, NodeSelector: map[string]string{AgentPoolLabel: "agents"}}, false},
+		{"matching affinity term", corev1.PodSpec{Tolerations: tolerateAgents, Affinity: requireNode(term("team", corev1.NodeSelectorOpNotIn, "ci"), term(AKSModeLabel, corev1.NodeSelectorOpIn, "user"))}, true},
+		{"affinity without matching term", corev1.PodSpec{Tolerations: tolerateAgents, Affinity: requireNode(term("gpu", corev1.NodeSelectorOpExists))}, false},
+		{"greater than affinity", corev1.PodSpec{Tolerations: tolerateAgents, Affinity: requireNode(term("cores", corev1.NodeSelectorOpGt, "2"))}, true},
+		{"invalid affinity", corev1.PodSpec{Tolerations: tolerateAgents, Affinity: requireNode(term("cores", corev1.NodeSelectorOpGt, "many"))}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := UnschedulableReason(corev1.Pod{Spec: tt.spec}, node)
+			if schedulable := reason == ""; schedulable != tt.schedulable {
+				t.Errorf("expected schedulable %t, got reason %q", tt.schedulable, reason)
+			}
+		})
+	}
+}
+
+func TestUnschedulableReason_IgnoresCordon(t *testing.T) {
+	node := corev1.Node{Spec: corev1.NodeSpec{Taints: []corev1.Taint{{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule}}}}
+
+	if reason := UnschedulableReason(corev1.Pod{}, node); reason != "" {
+		t.Errorf("expected the cordon of the node to be ignored, got %q", reason)
+	}
+}
@@ -0,0 +1,146 @@
+package nodepool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	armcontainerservice "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"go.uber.org/zap"
+)
+
+// ProvisioningState is the provisioning state ARM reports for a node pool, or for a scale set instance
+type ProvisioningState string
+
+const (
+	ProvisioningStateSucceeded                 ProvisioningState = "Succeeded"
+	ProvisioningStateFailed                    ProvisioningState = "Failed"
+	ProvisioningStateCanceled                  ProvisioningState = "Canceled"
+	ProvisioningStateCreating                  ProvisioningState = "Creating"
+	ProvisioningStateUpdating                  ProvisioningState = "Updating"
+	ProvisioningStateScaling                   ProvisioningState = "Scaling"
+	ProvisioningStateDeleting                  ProvisioningState = "Deleting"
+	ProvisioningStateUpgradingNodeImageVersion ProvisioningState = "UpgradingNodeImageVersion"
+)
+
+const (
+	// DefaultStatePollInterval is how often WaitForState checks the provisioning state
+	DefaultStatePollInterval = 10 * time.Second
+)
+
+// ErrProvisioningFailed is returned when a node pool ends in the Failed or Canceled provisioning state, it stays there
+// until it is fixed in AKS, e.g. by reconciling the node pool
+var ErrProvisioningFailed = errors.New("node pool provisioning failed")
+
+// ErrProvisioningTimeout is returned when a node pool does not reach the awaited provisioning state in time
+var ErrProvisioningTimeout = errors.New("timed out waiting for the node pool provisioning state")
+
+// Failed returns true for the terminal states of a failed operation
+func (s ProvisioningState) Failed() bool {
+	return s == ProvisioningStateFailed || s == ProvisioningStateCanceled
+}
+
+// Terminal returns true when no operation is running, the last one succeeded or failed
+func (s ProvisioningState) Terminal() bool {
+	return s == ProvisioningStateSucceeded || s.Failed()
+}
+
+// GetProvisioningState returns the provisioning state of the node pool, it is empty when ARM did not report one
+func GetProvisioningState(agentPool armcontainerservice.AgentPool) ProvisioningState {
+	if agentPool.Properties == nil || agentPool.Properties.ProvisioningState == nil {
+		return ""
+	}
+	return ProvisioningState(*agentPool.Properties.ProvisioningState)
+}
+
+// WithStatePolling returns a copy of the NodePoolController which waits up to timeout for a node pool to settle after
+// it changed the node pool, checking its state every interval. A zero timeout does not wait, the next reconcile checks
+// the state instead.
+func (c *NodePoolController) WithStatePolling(interval, timeout time.Duration) NodePoolControllerInterface {
+	controller := *c
+	controller.statePollInterval = interval
+	controller.stateTimeout = timeout
+	return &controller
+}
+
+// WaitForState polls the provisioning state of the node pool until it is the given state. It fails with
+// ErrProvisioningFailed when the node pool ends in Failed or Canceled instead, and with ErrProvisioningTimeout when the
+// state is not reached within the timeout.
+func (c *NodePoolController) WaitForState(ctx context.Context, nodePoolName string, state ProvisioningState, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	interval := c.statePollInterval
+	if interval <= 0 {
+		interval = DefaultStatePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		current, err := c.GetNodePoolProvisioningState(ctx, nodePoolName)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if current == state {
+			return nil
+		}
+		if current.Failed() {
+			c.logger.Warn("Node pool provisioning failed", zap.String("nodePoolName", nodePoolName), zap.String("provisioningState", string(current)))
+			return fmt.Errorf("%w: node pool '%s' is in provisioning state '%s'", ErrProvisioningFailed, nodePoolName, current)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: node pool '%s' is in provisioning state '%s' instead of '%s' after %s", ErrProvisioningTimeout, nodePoolName, current, state, timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitUntilSettled waits for the node pool to finish the operation started by the controller, when waiting is enabled
+func (c *NodePoolController) waitUntilSettled(ctx context.Context, nodePoolName string) error {
+	if c.stateTimeout <= 0 {
+		return nil
+	}
+	return c.WaitForState(ctx, nodePoolName, ProvisioningStateSucceeded, c.stateTimeout)
+}
+
+// waitUntilDeleted waits for the deletion of the node pool started by the controller, when waiting is enabled. It
+// fails with ErrProvisioningFailed when the deletion fails, and with ErrProvisioningTimeout when the node pool still
+// exists after the timeout, the next reconcile checks it again.
+func (c *NodePoolController) waitUntilDeleted(ctx context.Context, nodePoolName string) error {
+	if c.stateTimeout <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.stateTimeout)
+	defer cancel()
+	interval := c.statePollInterval
+	if interval <= 0 {
+		interval = DefaultStatePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		nodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
+		var responseErr *azcore.ResponseError
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("failed to check the deletion of node pool '%s': %w", nodePoolName, err)
+		}
+		current := GetProvisioningState(nodePool.AgentPool)
+		if err == nil && current.Failed() {
+			c.logger.Warn("Node pool deletion failed", zap.String("nodePoolName", nodePoolName), zap.String("provisioningState", string(current)))
+			return fmt.Errorf("%w: node pool '%s' is in provisioning state '%s' instead of being deleted", ErrProvisioningFailed, nodePoolName, current)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: node pool '%s' is still being deleted after %s", ErrProvisioningTimeout, nodePoolName, c.stateTimeout)
+		case <-ticker.C:
+		}
+	}
+}
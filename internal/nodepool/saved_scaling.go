@@ -0,0 +1,102 @@
+package nodepool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	armcontainerservice "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+)
+
+// ScalingSchemaVersion is the version of the scaling JSON the controller saves before a rotation. Version 1 has either
+// MinCount and MaxCount or Count, version 2 has the autoscaling flag, all counts and the scale down mode of the node
+// pool.
+const ScalingSchemaVersion = 2
+
+// SavedScaling is the scaling of a node pool saved before a rotation and restored after it. A field added later is
+// ignored by the controllers which do not know it, the version is only raised when older controllers would restore
+// the scaling wrongly without it.
+type SavedScaling struct {
+	// Version is missing from the scaling saved with version 1
+	Version           int                                `json:"Version,omitempty"`
+	EnableAutoScaling bool                               `json:"EnableAutoScaling"`
+	Count             *int32                             `json:"Count,omitempty"`
+	MinCount          *int32                             `json:"MinCount,omitempty"`
+	MaxCount          *int32                             `json:"MaxCount,omitempty"`
+	ScaleDownMode     *armcontainerservice.ScaleDownMode `json:"ScaleDownMode,omitempty"`
+}
+
+// SavedScalingOf returns the current scaling of the node pool in the latest schema version, the counts of the
+// autoscaler are only saved while it is enabled
+func SavedScalingOf(nodePool armcontainerservice.AgentPool) SavedScaling {
+	scaling := SavedScaling{Version: ScalingSchemaVersion}
+	properties := nodePool.Properties
+	if properties == nil {
+		return scaling
+	}
+	scaling.Count = properties.Count
+	scaling.ScaleDownMode = properties.ScaleDownMode
+	if properties.EnableAutoScaling != nil && *properties.EnableAutoScaling {
+		scaling.EnableAutoScaling = true
+		scaling.MinCount = properties.MinCount
+		scaling.MaxCount = properties.MaxCount
+	}
+	return scaling
+}
+
+// JSON returns the scaling JSON saved by the controller
+func (s SavedScaling) JSON() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// ParseScaling parses the scaling JSON saved by the controller in any schema version. It fails with an
+// InvalidScalingError when the JSON is malformed, has a newer version than this controller supports or lacks the counts
+// of its scaling: MinCount and MaxCount with autoscaling, Count without it.
+func ParseScaling(scalingData string) (SavedScaling, error) {
+	var scaling SavedScaling
+	if err := json.Unmarshal([]byte(scalingData), &scaling); err != nil {
+		return SavedScaling{}, &InvalidScalingError{ScalingData: scalingData, Err: err}
+	}
+	var err error
+	switch {
+	case scaling.Version > ScalingSchemaVersion:
+		err = fmt.Errorf("schema version %d is newer than the supported version %d", scaling.Version, ScalingSchemaVersion)
+	case scaling.Version >= 2 && scaling.EnableAutoScaling && (scaling.MinCount == nil || scaling.MaxCount == nil):
+		err = errors.New("it must contain MinCount and MaxCount with autoscaling enabled")
+	case scaling.Version >= 2 && !scaling.EnableAutoScaling && scaling.Count == nil:
+		err = errors.New("it must contain Count with autoscaling disabled")
+	case scaling.Version < 2 && scaling.MinCount != nil && scaling.MaxCount != nil:
+		// version 1 autoscales the node pool when it has both MinCount and MaxCount
+		scaling.EnableAutoScaling = true
+	case scaling.Version < 2 && scaling.Count != nil:
+		scaling.MinCount, scaling.MaxCount = nil, nil
+	case scaling.Version < 2:
+		err = errors.New("it must contain either MinCount and MaxCount or Count")
+	}
+	if err != nil {
+		return SavedScaling{}, &InvalidScalingError{ScalingData: scalingData, Err: err}
+	}
+	return scaling, nil
+}
+
+// scalingMatches returns true when the node pool has the saved scaling: autoscaling between MinCount and MaxCount, or
+// manual scaling with Count, and the saved scale down mode
+func scalingMatches(nodePool armcontainerservice.AgentPool, scaling SavedScaling) bool {
+	properties := nodePool.Properties
+	if properties == nil {
+		return false
+	}
+	if scaling.ScaleDownMode != nil && (properties.ScaleDownMode == nil || *properties.ScaleDownMode != *scaling.ScaleDownMode) {
+		return false
+	}
+	if scaling.EnableAutoScaling {
+		return properties.EnableAutoScaling != nil && *properties.EnableAutoScaling &&
+			equalCount(properties.MinCount, scaling.MinCount) && equalCount(properties.MaxCount, scaling.MaxCount)
+	}
+	return properties.EnableAutoScaling != nil && !*properties.EnableAutoScaling && equalCount(properties.Count, scaling.Count)
+}
+
+func equalCount(current, saved *int32) bool {
+	return current != nil && saved != nil && *current == *saved
+}
@@ -0,0 +1,166 @@
+package nodepool
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+)
+
+var _ AgentPoolClientInterface = &scriptedAgentPoolClient{}
+
+// scriptedAgentPoolClient is an AgentPoolClientInterface which plays a script instead of modelling ARM: every Get of
+// an agent pool returns the next of its scripted states and then repeats the last one, so a test decides how the pool
+// moves through its provisioning states over sequential calls. The answers of the mutations are scripted per call,
+// the accepted mutations are recorded. The returned pollers are nil, the controller never polls them.
+type scriptedAgentPoolClient struct {
+	// agentPools are the scripted states of every agent pool, an agent pool without states does not exist
+	agentPools map[string][]armcontainerservice.AgentPool
+	// latestNodeImageVersion is reported by the upgrade profile of every agent pool
+	latestNodeImageVersion string
+	// updateErrors are returned by the successive calls of BeginCreateOrUpdate, a nil error accepts the update
+	updateErrors []error
+	// upgradeErrors are returned by the successive calls of BeginUpgradeNodeImageVersion
+	upgradeErrors []error
+	// listPageSize is the number of agent pools on a page of NewListPager, zero lists every agent pool on one page
+	listPageSize int
+	// listErrors are returned by the successive pages of NewListPager
+	listErrors []error
+
+	gets     map[string]int
+	updates  []armcontainerservice.AgentPool
+	upgrades []string
+}
+
+func newScriptedAgentPoolClient(latestNodeImageVersion string) *scriptedAgentPoolClient {
+	return &scriptedAgentPoolClient{
+		agentPools:             make(map[string][]armcontainerservice.AgentPool),
+		latestNodeImageVersion: latestNodeImageVersion,
+		gets:                   make(map[string]int),
+	}
+}
+
+// script adds the states the agent pool goes through, one per Get
+func (c *scriptedAgentPoolClient) script(name string, states ...armcontainerservice.AgentPool) {
+	for i := range states {
+		states[i].Name = to.Ptr(name)
+	}
+	c.agentPools[name] = append(c.agentPools[name], states...)
+}
+
+func (c *scriptedAgentPoolClient) Get(_ context.Context, _, _, nodePoolName string, _ *armcontainerservice.AgentPoolsClientGetOptions) (armcontainerservice.AgentPoolsClientGetResponse, error) {
+	states := c.agentPools[nodePoolName]
+	if len(states) == 0 {
+		return armcontainerservice.AgentPoolsClientGetResponse{}, responseError(http.StatusNotFound, "NotFound")
+	}
+	state := states[min(c.gets[nodePoolName], len(states)-1)]
+	c.gets[nodePoolName]++
+	return armcontainerservice.AgentPoolsClientGetResponse{AgentPool: copyAgentPool(state)}, nil
+}
+
+func (c *scriptedAgentPoolClient) BeginCreateOrUpdate(_ context.Context, _, _, _ string, parameters armcontainerservice.AgentPool, _ *armcontainerservice.AgentPoolsClientBeginCreateOrUpdateOptions) (*runtime.Poller[armcontainerservice.AgentPoolsClientCreateOrUpdateResponse], error) {
+	if err := next(&c.updateErrors); err != nil {
+		return nil, err
+	}
+	c.updates = append(c.updates, copyAgentPool(parameters))
+	return nil, nil
+}
+
+func (c *scriptedAgentPoolClient) BeginDelete(_ context.Context, _, _, nodePoolName string, _ *armcontainerservice.AgentPoolsClientBeginDeleteOptions) (*runtime.Poller[armcontainerservice.AgentPoolsClientDeleteResponse], error) {
+	delete(c.agentPools, nodePoolName)
+	return nil, nil
+}
+
+func (c *scriptedAgentPoolClient) GetUpgradeProfile(_ context.Context, _, _, _ string, _ *armcontainerservice.AgentPoolsClientGetUpgradeProfileOptions) (armcontainerservice.AgentPoolsClientGetUpgradeProfileResponse, error) {
+	return armcontainerservice.AgentPoolsClientGetUpgradeProfileResponse{
+		AgentPoolUpgradeProfile: armcontainerservice.AgentPoolUpgradeProfile{
+			Properties: &armcontainerservice.AgentPoolUpgradeProfileProperties{LatestNodeImageVersion: to.Ptr(c.latestNodeImageVersion)},
+		},
+	}, nil
+}
+
+func (c *scriptedAgentPoolClient) BeginUpgradeNodeImageVersion(_ context.Context, _, _, agentPoolName string, _ *armcontainerservice.AgentPoolsClientBeginUpgradeNodeImageVersionOptions) (*runtime.Poller[armcontainerservice.AgentPoolsClientUpgradeNodeImageVersionResponse], error) {
+	if err := next(&c.upgradeErrors); err != nil {
+		return nil, err
+	}
+	c.upgrades = append(c.upgrades, agentPoolName)
+	return nil, nil
+}
+
+// NewListPager lists the agent pools in the state of their next Get in name order, a listed agent pool does not move
+// on to its next state
+func (c *scriptedAgentPoolClient) NewListPager(_, _ string, _ *armcontainerservice.AgentPoolsClientListOptions) *runtime.Pager[armcontainerservice.AgentPoolsClientListResponse] {
+	var agentPools []*armcontainerservice.AgentPool
+	for _, name := range slices.Sorted(maps.Keys(c.agentPools)) {
+		states := c.agentPools[name]
+		if len(states) == 0 {
+			continue
+		}
+		state := copyAgentPool(states[min(c.gets[name], len(states)-1)])
+		agentPools = append(agentPools, &state)
+	}
+	pageSize := c.listPageSize
+	if pageSize == 0 {
+		pageSize = max(len(agentPools), 1)
+	}
+	return runtime.NewPager(runtime.PagingHandler[armcontainerservice.AgentPoolsClientListResponse]{
+		More: func(page armcontainerservice.AgentPoolsClientListResponse) bool {
+			return page.NextLink != nil
+		},
+		Fetcher: func(_ context.Context, page *armcontainerservice.AgentPoolsClientListResponse) (armcontainerservice.AgentPoolsClientListResponse, error) {
+			if err := next(&c.listErrors); err != nil {
+				return armcontainerservice.AgentPoolsClientListResponse{}, err
+			}
+			start := 0
+			if page != nil {
+				start, _ = strconv.Atoi(*page.NextLink)
+			}
+			end := min(start+pageSize, len(agentPools))
+			response := armcontainerservice.AgentPoolsClientListResponse{
+				AgentPoolListResult: armcontainerservice.AgentPoolListResult{Value: agentPools[start:end]},
+			}
+			if end < len(agentPools) {
+				response.NextLink = to.Ptr(strconv.Itoa(end))
+			}
+			return response, nil
+		},
+	})
+}
+
+// next pops the next scripted error, nil once the script is played
+func next(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+// copyAgentPool copies the properties of the agent pool, so the controller cannot change the script
+func copyAgentPool(agentPool armcontainerservice.AgentPool) armcontainerservice.AgentPool {
+	if agentPool.Properties != nil {
+		properties := *agentPool.Properties
+		agentPool.Properties = &properties
+	}
+	return agentPool
+}
+
+func responseError(statusCode int, errorCode string) error {
+	return &azcore.ResponseError{StatusCode: statusCode, ErrorCode: errorCode}
+}
+
+// agentPool returns an agent pool of user mode in the provisioning state
+func agentPool(provisioningState ProvisioningState, properties armcontainerservice.ManagedClusterAgentPoolProfileProperties) armcontainerservice.AgentPool {
+	if properties.Mode == nil {
+		properties.Mode = to.Ptr(armcontainerservice.AgentPoolModeUser)
+	}
+	properties.ProvisioningState = to.Ptr(string(provisioningState))
+	return armcontainerservice.AgentPool{Properties: &properties}
+}
@@ -0,0 +1,230 @@
+package nodepool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"go.uber.org/zap"
+
+	"norbinto/node-updater/internal/audit"
+)
+
+const (
+	// SharedTagKey is the ARM tag which marks a temporary node pool as shared by several SafeEvict resources
+	SharedTagKey = "shared"
+	// SharedTagValue is the value of the SharedTagKey tag
+	SharedTagValue = "true"
+	// referenceTagPrefix starts the ARM tags of the SafeEvict resources whose rotation uses a shared node pool
+	referenceTagPrefix = "used-by-"
+	// releasedTagPrefix starts the ARM tags of the SafeEvict resources whose rotation is cleaning up a shared node pool
+	releasedTagPrefix = "released-by-"
+	// ownerHashLength is the number of hash characters of the owner in the reference tags, ARM tag names must not
+	// contain the slash of the owner
+	ownerHashLength = 12
+)
+
+// referenceTagKeys returns the tags which hold the reference of the owner while its rotation uses a shared node pool
+// and once it released it
+func referenceTagKeys(owner string) (string, string) {
+	sum := sha256.Sum256([]byte(owner))
+	hash := hex.EncodeToString(sum[:])[:ownerHashLength]
+	return referenceTagPrefix + hash, releasedTagPrefix + hash
+}
+
+// isShared returns true when the node pool is a temporary node pool shared by several SafeEvict resources
+func isShared(nodePool *armcontainerservice.AgentPool) bool {
+	if nodePool.Properties == nil || !isManaged(nodePool) {
+		return false
+	}
+	shared, ok := nodePool.Properties.Tags[SharedTagKey]
+	return ok && shared != nil && *shared == SharedTagValue
+}
+
+// isReferencedBy returns true when the owner uses the shared node pool or released it without dropping its reference
+func isReferencedBy(nodePool *armcontainerservice.AgentPool, owner string) bool {
+	usedKey, releasedKey := referenceTagKeys(owner)
+	_, isUsed := nodePool.Properties.Tags[usedKey]
+	_, isReleased := nodePool.Properties.Tags[releasedKey]
+	return isUsed || isReleased
+}
+
+// countReferences returns how many SafeEvict resources use the shared node pool and how many released it
+func countReferences(nodePool *armcontainerservice.AgentPool) (int, int) {
+	used, released := 0, 0
+	for key := range nodePool.Properties.Tags {
+		switch {
+		case strings.HasPrefix(key, referenceTagPrefix):
+			used++
+		case strings.HasPrefix(key, releasedTagPrefix):
+			released++
+		}
+	}
+	return used, released
+}
+
+// sharedNodePoolLocks serialises the reference updates of the shared node pools. The tags are read, changed and
+// written back as a whole, two rotations which update the references of the same node pool at once would otherwise
+// overwrite each other's reference.
+type sharedNodePoolLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sharedNodePoolLock
+}
+
+type sharedNodePoolLock struct {
+	sync.Mutex
+	// holders is the number of callers which hold or wait for the lock, the lock is forgotten when it drops to zero
+	holders int
+}
+
+func newSharedNodePoolLocks() *sharedNodePoolLocks {
+	return &sharedNodePoolLocks{locks: make(map[string]*sharedNodePoolLock)}
+}
+
+// lock locks the node pool and returns the function which unlocks it
+func (l *sharedNodePoolLocks) lock(key string) func() {
+	l.mu.Lock()
+	nodePoolLock, ok := l.locks[key]
+	if !ok {
+		nodePoolLock = &sharedNodePoolLock{}
+		l.locks[key] = nodePoolLock
+	}
+	nodePoolLock.holders++
+	l.mu.Unlock()
+
+	nodePoolLock.Lock()
+	return func() {
+		nodePoolLock.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		nodePoolLock.holders--
+		if nodePoolLock.holders == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// lockSharedNodePool locks the references of the node pool in the cluster of the controller, the copies of the
+// controller for other clusters share the locks
+func (c *NodePoolController) lockSharedNodePool(nodePoolName string) func() {
+	return c.sharedNodePoolLocks.lock(strings.Join([]string{c.subscriptionID, c.clusterResourceGroup, c.clusterName, nodePoolName}, "/"))
+}
+
+// getSharedNodePool returns the node pool when it is a shared temporary node pool
+func (c *NodePoolController) getSharedNodePool(ctx context.Context, nodePoolName string) (*armcontainerservice.AgentPool, error) {
+	nodePool, err := c.agentPoolClient.Get(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, nil)
+	if err != nil {
+		c.logger.Error("Error occurred while getting node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
+		return nil, fmt.Errorf("unable to get node pool '%s': %w", nodePoolName, err)
+	}
+	if !isShared(&nodePool.AgentPool) {
+		return nil, fmt.Errorf("node pool '%s' is not tagged with %s=%s and %s=%s: %w", nodePoolName, ManagedByTagKey, ManagedByTagValue, SharedTagKey, SharedTagValue, ErrNodePoolNotManaged)
+	}
+	return &nodePool.AgentPool, nil
+}
+
+// updateTags writes the tags of the node pool, a conflict with a running operation is returned as RetryableError
+func (c *NodePoolController) updateTags(ctx context.Context, nodePool *armcontainerservice.AgentPool) error {
+	nodePoolName := *nodePool.Name
+	c.tagOperation(ctx, nodePool, OperationUpdateSharedReferences)
+	_, err := c.agentPoolClient.BeginCreateOrUpdate(ctx, c.clusterResourceGroup, c.clusterName, nodePoolName, *nodePool, nil)
+	audit.Write(ctx, audit.ActionUpdatePoolReferences, audit.Target(audit.KindNodepool, nodePoolName), err)
+	if err != nil {
+		var responseErr *azcore.ResponseError
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusConflict {
+			return &RetryableError{NodePoolName: nodePoolName, Err: err}
+		}
+		c.logger.Error("Failed to update the tags of node pool", zap.Error(err), zap.String("nodePoolName", nodePoolName))
+		return fmt.Errorf("failed to update the tags of node pool '%s': %w", nodePoolName, err)
+	}
+	return nil
+}
+
+// AcquireSharedNodePool adds the reference of the owner to a shared temporary node pool which another SafeEvict
+// created. It returns false without a change while the other rotations clean the node pool up, a new rotation waits
+// for its removal then and creates it again.
+func (c *NodePoolController) AcquireSharedNodePool(ctx context.Context, nodePoolName string, owner string) (bool, error) {
+	defer c.lockSharedNodePool(nodePoolName)()
+	nodePool, err := c.getSharedNodePool(ctx, nodePoolName)
+	if err != nil {
+		return false, err
+	}
+	usedKey, releasedKey := referenceTagKeys(owner)
+	_, released := countReferences(nodePool)
+	if _, ok := nodePool.Properties.Tags[releasedKey]; ok {
+		released--
+	}
+	if released > 0 {
+		c.logger.Debug("Shared node pool is being cleaned up by other SafeEvicts", zap.String("nodepoolName", nodePoolName), zap.Int("safeEvicts", released))
+		return false, nil
+	}
+	if _, ok := nodePool.Properties.Tags[usedKey]; ok {
+		return true, nil
+	}
+
+	delete(nodePool.Properties.Tags, releasedKey)
+	nodePool.Properties.Tags[usedKey] = to.Ptr(owner)
+	c.logger.Info("Acquiring shared node pool", zap.String("nodePoolName", nodePoolName), zap.String("owner", owner))
+	if err := c.updateTags(ctx, nodePool); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseSharedNodePool marks the reference of the owner as released, its rotation does not need the shared node pool
+// anymore. It returns true once every SafeEvict released the node pool, it can be drained then without cordoning the
+// nodes of a rotation which still uses it. An owner without reference gets ErrNodePoolNotManaged.
+func (c *NodePoolController) ReleaseSharedNodePool(ctx context.Context, nodePoolName string, owner string) (bool, error) {
+	defer c.lockSharedNodePool(nodePoolName)()
+	nodePool, err := c.getSharedNodePool(ctx, nodePoolName)
+	if err != nil {
+		return false, err
+	}
+	if !isReferencedBy(nodePool, owner) {
+		return false, fmt.Errorf("node pool '%s' is not used by '%s': %w", nodePoolName, owner, ErrNodePoolNotManaged)
+	}
+	usedKey, releasedKey := referenceTagKeys(owner)
+	if _, isUsed := nodePool.Properties.Tags[usedKey]; isUsed {
+		delete(nodePool.Properties.Tags, usedKey)
+		nodePool.Properties.Tags[releasedKey] = to.Ptr(owner)
+		c.logger.Info("Releasing shared node pool", zap.String("nodePoolName", nodePoolName), zap.String("owner", owner))
+		if err := c.updateTags(ctx, nodePool); err != nil {
+			return false, err
+		}
+	}
+	used, _ := countReferences(nodePool)
+	return used == 0, nil
+}
+
+// DropSharedNodePoolReference removes the released reference of the owner once its pods left the shared node pool. It
+// returns true without a change when the owner holds the last reference, the owner removes the node pool then.
+func (c *NodePoolController) DropSharedNodePoolReference(ctx context.Context, nodePoolName string, owner string) (bool, error) {
+	defer c.lockSharedNodePool(nodePoolName)()
+	nodePool, err := c.getSharedNodePool(ctx, nodePoolName)
+	if err != nil {
+		return false, err
+	}
+	if !isReferencedBy(nodePool, owner) {
+		return false, fmt.Errorf("node pool '%s' is not used by '%s': %w", nodePoolName, owner, ErrNodePoolNotManaged)
+	}
+	usedKey, releasedKey := referenceTagKeys(owner)
+	used, released := countReferences(nodePool)
+	if used+released <= 1 {
+		return true, nil
+	}
+	delete(nodePool.Properties.Tags, usedKey)
+	delete(nodePool.Properties.Tags, releasedKey)
+	c.logger.Info("Dropping the reference of shared node pool", zap.String("nodePoolName", nodePoolName), zap.String("owner", owner), zap.Int("references", used+released-1))
+	if err := c.updateTags(ctx, nodePool); err != nil {
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,87 @@
+package nodepool
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// MaxNodePoolCount is the largest node count of an AKS node pool
+const MaxNodePoolCount = 1000
+
+// GetNodeAllocatable returns the allocatable resources of a node of the cluster which runs the VM size, nil when no
+// node runs it
+func (c *NodePoolController) GetNodeAllocatable(ctx context.Context, vmSize string) (corev1.ResourceList, error) {
+	nodeList, err := c.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: labels.Set{corev1.LabelInstanceTypeStable: vmSize}.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes of VM size '%s': %w", vmSize, err)
+	}
+	for _, node := range nodeList.Items {
+		if len(node.Status.Allocatable) > 0 {
+			return node.Status.Allocatable, nil
+		}
+	}
+	return nil, nil
+}
+
+// RequiredNodeCount returns the number of nodes with the allocatable resources which fit the resource requests of the
+// pods with the headroom in percent on top. CPU, memory and the number of pods are compared, resources the node does
+// not report are not. At least one node is required.
+func RequiredNodeCount(pods []corev1.Pod, allocatable corev1.ResourceList, headroomPercent int32) int32 {
+	total := corev1.ResourceList{}
+	for _, pod := range pods {
+		for name, quantity := range podRequests(pod) {
+			sum := total[name]
+			sum.Add(quantity)
+			total[name] = sum
+		}
+	}
+	total[corev1.ResourcePods] = *resource.NewQuantity(int64(len(pods)), resource.DecimalSI)
+
+	count := int64(1)
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourcePods} {
+		available, ok := allocatable[name]
+		if !ok || available.IsZero() {
+			continue
+		}
+		requested := total[name]
+		// CPU is compared in millicores, the others in their units
+		required, capacity := requested.Value(), available.Value()
+		if name == corev1.ResourceCPU {
+			required, capacity = requested.MilliValue(), available.MilliValue()
+		}
+		required += required * int64(headroomPercent) / 100
+		count = max(count, (required+capacity-1)/capacity)
+	}
+	return int32(min(count, MaxNodePoolCount))
+}
+
+// podRequests returns the resource requests of the pod: the sum of its containers, or the largest init container when
+// it requests more, plus the overhead of the pod
+func podRequests(pod corev1.Pod) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			sum := requests[name]
+			sum.Add(quantity)
+			requests[name] = sum
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if current, ok := requests[name]; !ok || quantity.Cmp(current) > 0 {
+				requests[name] = quantity
+			}
+		}
+	}
+	for name, quantity := range pod.Spec.Overhead {
+		sum := requests[name]
+		sum.Add(quantity)
+		requests[name] = sum
+	}
+	return requests
+}
@@ -0,0 +1,57 @@
+package nodepool
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestRequiredNodeCount(t *testing.T) {
+	allocatable := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("3860m"),
+		corev1.ResourceMemory: resource.MustParse("12Gi"),
+		corev1.ResourcePods:   resource.MustParse("10"),
+	}
+	agentPod := func(cpu, memory string) corev1.Pod {
+		return corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			}},
+		}}}}
+	}
+	repeat := func(count int, pod corev1.Pod) []corev1.Pod {
+		pods := make([]corev1.Pod, count)
+		for i := range pods {
+			pods[i] = pod
+		}
+		return pods
+	}
+	withInitContainer := agentPod("500m", "1Gi")
+	withInitContainer.Spec.InitContainers = []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("4"),
+	}}}}
+
+	tests := []struct {
+		name            string
+		pods            []corev1.Pod
+		headroomPercent int32
+		expected        int32
+	}{
+		{"no pods", nil, 20, 1},
+		{"cpu bound", repeat(4, agentPod("2", "1Gi")), 0, 3},
+		{"headroom", repeat(4, agentPod("1800m", "1Gi")), 20, 3},
+		{"memory bound", repeat(3, agentPod("100m", "8Gi")), 0, 2},
+		{"pod count bound", repeat(25, agentPod("10m", "10Mi")), 0, 3},
+		{"init container", []corev1.Pod{withInitContainer, withInitContainer}, 0, 3},
+		{"capped at the node pool limit", repeat(MaxNodePoolCount+1, agentPod("4", "1Gi")), 0, MaxNodePoolCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if count := RequiredNodeCount(tt.pods, allocatable, tt.headroomPercent); count != tt.expected {
+				t.Errorf("expected %d nodes, got %d", tt.expected, count)
+			}
+		})
+	}
+}
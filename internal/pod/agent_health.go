@@ -0,0 +1,93 @@
+package pod
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	safev1 "norbinto/node-updater/api/v1"
+)
+
+// AgentHealth counts the agent pods running on a set of nodepools and how many of them work
+type AgentHealth struct {
+	// Agents is the number of agent pods which run for at least the grace period
+	Agents int
+	// Ready is the number of those agent pods which are ready
+	Ready int
+	// Online is the number of those agent pods whose agent is online in Azure DevOps, it is -1 when the agent provider
+	// is disabled
+	Online int
+}
+
+// GetAgentHealth checks the agent pods in the namespaces of the spec which run on the nodepools. The pods which started
+// less than gracePeriod ago are not counted, they may still be registering.
+func (c *PodController) GetAgentHealth(ctx context.Context, spec safev1.SafeEvictSpec, nodepools []string, gracePeriod time.Duration) (AgentHealth, error) {
+	health := AgentHealth{Online: -1}
+	nodePools := map[string]string{}
+	agentPods := map[string][]corev1.Pod{}
+	for _, namespace := range spec.Namespaces {
+		podList, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			c.logger.Error("Error listing pods", zap.Error(err), zap.String("namespace", namespace))
+			return AgentHealth{}, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+		}
+		for _, pod := range podList.Items {
+			if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil || c.podAge(pod) < gracePeriod {
+				continue
+			}
+			nodePoolName, known := nodePools[pod.Spec.NodeName]
+			if !known {
+				node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, pod.Spec.NodeName, metav1.GetOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					return AgentHealth{}, fmt.Errorf("failed to get node %s of pod %s/%s: %w", pod.Spec.NodeName, pod.Namespace, pod.Name, err)
+				}
+				if err == nil {
+					nodePoolName = node.Labels[nodePoolLabel]
+				}
+				nodePools[pod.Spec.NodeName] = nodePoolName
+			}
+			if !slices.Contains(nodepools, nodePoolName) {
+				continue
+			}
+			poolName, err := c.getAgentPool(ctx, pod)
+			if err != nil {
+				c.logger.Debug("Pod is not an agent of a known pool, skipping it", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+				continue
+			}
+			health.Agents++
+			if isPodReady(pod) {
+				health.Ready++
+			}
+			agentPods[poolName] = append(agentPods[poolName], pod)
+		}
+	}
+	if !c.agentProviderEnabled(spec) {
+		return health, nil
+	}
+
+	health.Online = 0
+	for poolName, pods := range agentPods {
+		agents, err := c.azureDevopsController.GetOnlineAgents(ctx, poolName)
+		if err != nil {
+			c.logger.Error("Failed to get online agents", zap.Error(err), zap.String("poolName", poolName))
+			return AgentHealth{}, err
+		}
+		// the pod of an agent is named after its host name
+		online := map[string]bool{}
+		for _, agent := range agents {
+			online[agent.HostName] = true
+		}
+		for _, pod := range pods {
+			if online[pod.Name] {
+				health.Online++
+			}
+		}
+	}
+	return health, nil
+}
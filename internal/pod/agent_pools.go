@@ -0,0 +1,109 @@
+package pod
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// nodePoolLabel holds the name of the AKS nodepool of a node
+const nodePoolLabel = "agentpool"
+
+type cachedAgentPool struct {
+	namespace string
+	poolName  string
+}
+
+// agentPoolCache remembers the Azure DevOps pool every agent pod resolved from its AZP_POOL. The environment of a pod
+// does not change, so the pool is resolved once: a ConfigMap or Secret edited after the pod started does not move the
+// pod into another pool, and the later reconciles do not read them again.
+type agentPoolCache struct {
+	mu    sync.Mutex
+	pools map[types.UID]cachedAgentPool
+}
+
+func newAgentPoolCache() *agentPoolCache {
+	return &agentPoolCache{pools: make(map[types.UID]cachedAgentPool)}
+}
+
+func (c *agentPoolCache) get(uid types.UID) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, found := c.pools[uid]
+	return cached.poolName, found
+}
+
+func (c *agentPoolCache) set(uid types.UID, namespace, poolName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pools[uid] = cachedAgentPool{namespace: namespace, poolName: poolName}
+}
+
+// prune forgets the pods of the namespaces which are not listed anymore
+func (c *agentPoolCache) prune(namespaces []string, listed map[types.UID]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for uid, cached := range c.pools {
+		if slices.Contains(namespaces, cached.namespace) && !listed[uid] {
+			delete(c.pools, uid)
+		}
+	}
+}
+
+// DiscoverAgentPools returns the Azure DevOps pools of the agent pods in the namespaces per nodepool of their nodes,
+// read from their AZP_POOL or the agent pool annotation. The pods which are not scheduled or are no agents are skipped.
+func (c *PodController) DiscoverAgentPools(ctx context.Context, namespaces []string) (map[string][]string, error) {
+	nodePools := map[string]string{}
+	discovered := map[string]map[string]bool{}
+	listed := map[types.UID]bool{}
+	for _, namespace := range namespaces {
+		podList, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			c.logger.Error("Error listing pods", zap.Error(err), zap.String("namespace", namespace))
+			return nil, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+		}
+		for _, pod := range podList.Items {
+			listed[pod.UID] = true
+			if pod.Spec.NodeName == "" {
+				continue
+			}
+			poolName, err := c.getAgentPool(ctx, pod)
+			if err != nil {
+				c.logger.Debug("Pod is not an agent of a known pool, skipping it", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+				continue
+			}
+			nodePoolName, known := nodePools[pod.Spec.NodeName]
+			if !known {
+				node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, pod.Spec.NodeName, metav1.GetOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					return nil, fmt.Errorf("failed to get node %s of pod %s/%s: %w", pod.Spec.NodeName, pod.Namespace, pod.Name, err)
+				}
+				if err == nil {
+					nodePoolName = node.Labels[nodePoolLabel]
+				}
+				nodePools[pod.Spec.NodeName] = nodePoolName
+			}
+			if nodePoolName == "" {
+				continue
+			}
+			if discovered[nodePoolName] == nil {
+				discovered[nodePoolName] = map[string]bool{}
+			}
+			discovered[nodePoolName][poolName] = true
+		}
+	}
+	c.agentPools.prune(namespaces, listed)
+
+	agentPools := make(map[string][]string, len(discovered))
+	for nodePoolName, poolNames := range discovered {
+		agentPools[nodePoolName] = slices.Sorted(maps.Keys(poolNames))
+	}
+	return agentPools, nil
+}
@@ -0,0 +1,167 @@
+package pod
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+
+	safev1 "norbinto/node-updater/api/v1"
+)
+
+// ErrExecNeedsServiceAccount is returned for an exec drain signal of a SafeEvict without a ServiceAccountRef. The command
+// is written by the author of the SafeEvict, so it is never executed in the name of the controller.
+var ErrExecNeedsServiceAccount = errors.New("exec drain signal needs a serviceAccountRef")
+
+// DrainSignaler notifies the agent of a pod that the pod is going to be evicted
+type DrainSignaler interface {
+	Signal(ctx context.Context, pod corev1.Pod, signal safev1.DrainSignal) error
+	// WithExecConfig returns a copy of the DrainSignaler which executes the commands with the given config, e.g. one
+	// impersonating the ServiceAccount of the SafeEvict
+	WithExecConfig(execConfig *rest.Config) DrainSignaler
+}
+
+// podDrainSignaler executes the command of the signal in the agent container, or sends its HTTP request to the pod IP
+type podDrainSignaler struct {
+	// execConfig executes the commands, it is nil unless WithExecConfig is used and commands are refused then
+	execConfig *rest.Config
+	kubeClient kubernetes.Interface
+	httpClient *http.Client
+}
+
+// NewDrainSignaler creates a DrainSignaler for the cluster of kubeClient. Commands are only executed once WithExecConfig
+// is used, the identity of that config needs create on pods/exec.
+func NewDrainSignaler(kubeClient kubernetes.Interface, httpClient *http.Client) DrainSignaler {
+	return &podDrainSignaler{kubeClient: kubeClient, httpClient: httpClient}
+}
+
+func (s *podDrainSignaler) WithExecConfig(execConfig *rest.Config) DrainSignaler {
+	signaler := *s
+	signaler.execConfig = execConfig
+	return &signaler
+}
+
+func (s *podDrainSignaler) Signal(ctx context.Context, pod corev1.Pod, signal safev1.DrainSignal) error {
+	switch {
+	case signal.Exec != nil:
+		return s.exec(ctx, pod, signalContainer(pod, signal), signal.Exec.Command)
+	case signal.HTTPGet != nil:
+		return s.httpGet(ctx, pod, signalContainer(pod, signal), signal.HTTPGet)
+	}
+	return fmt.Errorf("drain signal of pod '%s' in namespace %s has neither exec nor httpGet", pod.Name, pod.Namespace)
+}
+
+func (s *podDrainSignaler) exec(ctx context.Context, pod corev1.Pod, container string, command []string) error {
+	if s.execConfig == nil {
+		return fmt.Errorf("%w, the command is not executed in pod '%s' in namespace %s", ErrExecNeedsServiceAccount, pod.Name, pod.Namespace)
+	}
+	// the request only provides the URL of the exec, it is sent with the execConfig
+	req := s.kubeClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(s.execConfig, http.MethodPost, req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create the executor of the drain signal of pod '%s' in namespace %s: %w", pod.Name, pod.Namespace, err)
+	}
+	var output bytes.Buffer
+	if err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &output, Stderr: &output}); err != nil {
+		return fmt.Errorf("drain signal command failed in pod '%s' in namespace %s: %w: %s", pod.Name, pod.Namespace, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+func (s *podDrainSignaler) httpGet(ctx context.Context, pod corev1.Pod, container string, action *corev1.HTTPGetAction) error {
+	signalURL, err := httpGetURL(pod, container, action)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, signalURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create the drain signal request of pod '%s' in namespace %s: %w", pod.Name, pod.Namespace, err)
+	}
+	for _, header := range action.HTTPHeaders {
+		req.Header.Add(header.Name, header.Value)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send the drain signal to pod '%s' in namespace %s: %w", pod.Name, pod.Namespace, err)
+	}
+	defer resp.Body.Close()
+	// as for an HTTP probe, every status below 400 is a success
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("drain signal of pod '%s' in namespace %s returned status %d: %s", pod.Name, pod.Namespace, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// httpGetURL returns the URL of the HTTP drain signal, the host defaults to the pod IP and a named port is looked up in
+// the ports of the container
+func httpGetURL(pod corev1.Pod, container string, action *corev1.HTTPGetAction) (string, error) {
+	host := action.Host
+	if host == "" {
+		host = pod.Status.PodIP
+	}
+	if host == "" {
+		return "", fmt.Errorf("pod '%s' in namespace %s has no IP for the drain signal", pod.Name, pod.Namespace)
+	}
+	port := action.Port.IntValue()
+	if action.Port.Type == intstr.String {
+		port = containerPort(pod, container, action.Port.StrVal)
+	}
+	if port <= 0 {
+		return "", fmt.Errorf("pod '%s' in namespace %s has no port '%s' for the drain signal", pod.Name, pod.Namespace, action.Port.String())
+	}
+	urlScheme := strings.ToLower(string(action.Scheme))
+	if urlScheme == "" {
+		urlScheme = "http"
+	}
+	path := action.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return urlScheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + path, nil
+}
+
+// containerPort returns the number of the named port of the container, 0 when it has no such port
+func containerPort(pod corev1.Pod, container, name string) int {
+	for _, podContainer := range pod.Spec.Containers {
+		if podContainer.Name != container {
+			continue
+		}
+		for _, port := range podContainer.Ports {
+			if port.Name == name {
+				return int(port.ContainerPort)
+			}
+		}
+	}
+	return 0
+}
+
+// signalContainer returns the container which receives the drain signal, the first container of the pod by default
+func signalContainer(pod corev1.Pod, signal safev1.DrainSignal) string {
+	if signal.Container != "" || len(pod.Spec.Containers) == 0 {
+		return signal.Container
+	}
+	return pod.Spec.Containers[0].Name
+}
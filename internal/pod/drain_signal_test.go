@@ -0,0 +1,75 @@
+package pod
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+
+	safev1 "norbinto/node-updater/api/v1"
+)
+
+func TestSignal_HTTPGet(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+" "+r.Header.Get("X-Reason"))
+		if r.URL.Path != "/drain" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse the address of the server: %v", err)
+	}
+	portNumber, _ := strconv.Atoi(port)
+	pod := newAgentPod(nil, corev1.Container{
+		Name:  "agent",
+		Ports: []corev1.ContainerPort{{Name: "control", ContainerPort: int32(portNumber)}},
+	})
+	pod.Status.PodIP = host
+	signaler := NewDrainSignaler(nil, server.Client())
+
+	err = signaler.Signal(context.TODO(), *pod, safev1.DrainSignal{HTTPGet: &corev1.HTTPGetAction{
+		Path:        "drain",
+		Port:        intstr.FromString("control"),
+		HTTPHeaders: []corev1.HTTPHeader{{Name: "X-Reason", Value: "node-image-upgrade"}},
+	}})
+	if err != nil {
+		t.Fatalf("Signal failed: %v", err)
+	}
+	if len(requests) != 1 || requests[0] != "/drain node-image-upgrade" {
+		t.Fatalf("Expected the drain request with its header, got: %v", requests)
+	}
+
+	err = signaler.Signal(context.TODO(), *pod, safev1.DrainSignal{HTTPGet: &corev1.HTTPGetAction{Path: "/missing", Port: intstr.FromInt32(int32(portNumber))}})
+	if err == nil {
+		t.Fatalf("Expected an error for a failing drain request")
+	}
+}
+
+func TestSignal_ExecWithoutExecConfig(t *testing.T) {
+	pod := newAgentPod(nil, corev1.Container{Name: "agent"})
+	signaler := NewDrainSignaler(fake.NewSimpleClientset(), http.DefaultClient)
+
+	err := signaler.Signal(context.TODO(), *pod, safev1.DrainSignal{Exec: &corev1.ExecAction{Command: []string{"touch", "/tmp/drain"}}})
+	if !errors.Is(err, ErrExecNeedsServiceAccount) {
+		t.Fatalf("Expected ErrExecNeedsServiceAccount, got: %v", err)
+	}
+}
+
+func TestHTTPGetURL_MissingPort(t *testing.T) {
+	pod := newAgentPod(nil, corev1.Container{Name: "agent"})
+	pod.Status.PodIP = "10.0.0.1"
+
+	if _, err := httpGetURL(*pod, "agent", &corev1.HTTPGetAction{Port: intstr.FromString("control")}); err == nil {
+		t.Fatalf("Expected an error for a port the container does not have")
+	}
+}
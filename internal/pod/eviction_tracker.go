@@ -0,0 +1,98 @@
+package pod
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
+)
+
+// evictionTTL is how long a pod is remembered after a step of its eviction succeeded. A pod which is still around after
+// the TTL is processed again, in case it was recreated under the same UID by a broken operator or the delete was lost.
+const evictionTTL = 10 * time.Minute
+
+type evictionStage int
+
+const (
+	// stageDeregistered means the agent of the pod was disabled and removed from Azure DevOps
+	stageDeregistered evictionStage = iota + 1
+	// stageSignaled means the drain signal was sent to the agent of the pod
+	stageSignaled
+	// stageJobKilled means the job of the pod was deleted
+	stageJobKilled
+	// stageEvicted means the pod was deleted
+	stageEvicted
+)
+
+type trackedEviction struct {
+	stage evictionStage
+	// cascades is true when the deletion of the job of the pod also deletes the pod
+	cascades bool
+	recorded time.Time
+	expires  time.Time
+}
+
+// evictionTracker remembers the evictions which are in flight across reconciles, so a pod which is already being torn
+// down does not get its agent disabled and removed, or its job killed, a second time
+type evictionTracker struct {
+	mu        sync.Mutex
+	evictions map[types.UID]trackedEviction
+	clock     clock.PassiveClock
+}
+
+func newEvictionTracker(clock clock.PassiveClock) *evictionTracker {
+	return &evictionTracker{evictions: make(map[types.UID]trackedEviction), clock: clock}
+}
+
+// reached returns true when the eviction of the pod got to the given stage within the TTL
+func (t *evictionTracker) reached(uid types.UID, stage evictionStage) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	eviction, found := t.evictions[uid]
+	if !found {
+		return false
+	}
+	if t.clock.Now().After(eviction.expires) {
+		delete(t.evictions, uid)
+		return false
+	}
+	return eviction.stage >= stage
+}
+
+// record marks that the eviction of the pod got to the given stage, it also drops the expired evictions
+func (t *evictionTracker) record(uid types.UID, stage evictionStage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock.Now()
+	for trackedUID, eviction := range t.evictions {
+		if now.After(eviction.expires) {
+			delete(t.evictions, trackedUID)
+		}
+	}
+	t.evictions[uid] = trackedEviction{stage: stage, recorded: now, expires: now.Add(evictionTTL)}
+}
+
+// recordJobKilled marks that the job of the pod was deleted, cascades tells whether the deletion also deletes the pod
+func (t *evictionTracker) recordJobKilled(uid types.UID, cascades bool) {
+	t.record(uid, stageJobKilled)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	eviction := t.evictions[uid]
+	eviction.cascades = cascades
+	t.evictions[uid] = eviction
+}
+
+// cascades returns true when the deletion of the job of the pod also deletes the pod
+func (t *evictionTracker) cascades(uid types.UID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.evictions[uid].cascades
+}
+
+// since returns how long ago the last stage of the eviction of the pod was recorded
+func (t *evictionTracker) since(uid types.UID) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.clock.Since(t.evictions[uid].recorded)
+}
@@ -1,78 +1,426 @@
 package pod
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"norbinto/node-updater/internal/azuredevops"
 	job "norbinto/node-updater/internal/job"
+	"norbinto/node-updater/internal/metrics"
 	"strings"
+	"time"
 
 	"slices"
 
 	"go.uber.org/zap"
 
 	safev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/audit"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/clock"
 )
 
+const (
+	// AgentPoolAnnotation overrides the Azure DevOps pool read from the environment of the agent
+	AgentPoolAnnotation = "update.norbinto/agent-pool"
+	// agentPoolEnvName is the environment variable of the agent which holds the Azure DevOps pool
+	agentPoolEnvName = "AZP_POOL"
+	// agentNameEnvName is the environment variable of the agent which holds the name it registers with
+	agentNameEnvName = "AZP_AGENT_NAME"
+	// jobCascadeTimeout is how long the deletion of a job gets to delete its pod before the pod is deleted on its own
+	jobCascadeTimeout = 2 * time.Minute
+)
+
+// ErrAgentProviderDisabled is returned for the queries of the agent provider when it is not used
+var ErrAgentProviderDisabled = errors.New("agent provider is disabled")
+
 type PodController struct {
-	kubeClient            kubernetes.Interface
+	kubeClient kubernetes.Interface
+	// mutationClient deletes the pods, it is the kubeClient unless WithMutationClient is used
+	mutationClient        kubernetes.Interface
 	azureDevopsController azuredevops.AzureDevopsControllerInterface
 	jobController         *job.JobController
-	logger                *zap.Logger
+	// drainSignaler sends the drain signal of the spec to the agents, it is nil unless WithDrainSignaler is used
+	drainSignaler DrainSignaler
+	drainBudget   time.Duration
+	// evictions is shared by the copies of the PodController, so a pod is not evicted twice by consecutive reconciles
+	evictions *evictionTracker
+	// agentPools is shared by the copies of the PodController, it caches the pool resolved from AZP_POOL per pod
+	agentPools *agentPoolCache
+	clock      clock.PassiveClock
+	logger     *zap.Logger
 }
 
-func NewPodController(kubeClient kubernetes.Interface, azureDevopsController azuredevops.AzureDevopsControllerInterface, jobController *job.JobController, logger *zap.Logger) *PodController {
+// NewPodController creates a PodController. drainBudget is the time an eviction sequence which is already in progress
+// gets to finish after ctx is cancelled, e.g. because the manager is shutting down.
+func NewPodController(kubeClient kubernetes.Interface, azureDevopsController azuredevops.AzureDevopsControllerInterface, jobController *job.JobController, drainBudget time.Duration, logger *zap.Logger) *PodController {
 	return &PodController{
 		kubeClient:            kubeClient,
+		mutationClient:        kubeClient,
 		azureDevopsController: azureDevopsController,
 		jobController:         jobController,
+		drainBudget:           drainBudget,
+		evictions:             newEvictionTracker(clock.RealClock{}),
+		agentPools:            newAgentPoolCache(),
+		clock:                 clock.RealClock{},
 		logger:                logger,
 	}
 }
 
-func (c *PodController) EvictIdlePods(ctx context.Context, pods []corev1.Pod) error {
+// WithMutationClient returns a copy of the PodController which deletes pods and jobs with the given client
+func (c *PodController) WithMutationClient(mutationClient kubernetes.Interface) PodControllerInterface {
+	controller := *c
+	controller.mutationClient = mutationClient
+	controller.jobController = c.jobController.WithMutationClient(mutationClient)
+	return &controller
+}
+
+// WithOwner returns a copy of the PodController which suspends the CronJobs of the evicted agents for the given owner,
+// ResumeCronJobs resumes them
+func (c *PodController) WithOwner(owner string) PodControllerInterface {
+	controller := *c
+	controller.jobController = c.jobController.WithOwner(owner)
+	return &controller
+}
+
+// ResumeCronJobs resumes the CronJobs of the namespaces which were suspended by the evictions of the owner
+func (c *PodController) ResumeCronJobs(ctx context.Context, namespaces []string) error {
+	return c.jobController.ResumeCronJobs(ctx, namespaces)
+}
+
+// WithDrainSignaler returns a copy of the PodController which notifies the agents with the given signaler before their
+// pods are evicted
+func (c *PodController) WithDrainSignaler(drainSignaler DrainSignaler) PodControllerInterface {
+	controller := *c
+	controller.drainSignaler = drainSignaler
+	return &controller
+}
+
+// WithExecConfig returns a copy of the PodController whose drain signals execute their commands with the given config
+func (c *PodController) WithExecConfig(execConfig *rest.Config) PodControllerInterface {
+	controller := *c
+	if c.drainSignaler != nil {
+		controller.drainSignaler = c.drainSignaler.WithExecConfig(execConfig)
+	}
+	return &controller
+}
+
+// WithLogFields returns a copy of the PodController which attaches the fields to every log entry, also to those of its
+// JobController
+func (c *PodController) WithLogFields(fields ...zap.Field) PodControllerInterface {
+	controller := *c
+	controller.logger = c.logger.With(fields...)
+	controller.jobController = c.jobController.WithLogFields(fields...)
+	return &controller
+}
+
+// WithKubeClient returns a copy of the PodController which works on the cluster of the given client
+func (c *PodController) WithKubeClient(kubeClient kubernetes.Interface) PodControllerInterface {
+	controller := *c
+	controller.kubeClient = kubeClient
+	controller.mutationClient = kubeClient
+	controller.jobController = c.jobController.WithKubeClient(kubeClient)
+	return &controller
+}
+
+func (c *PodController) EvictIdlePods(ctx context.Context, pods []corev1.Pod, spec safev1.SafeEvictSpec) error {
 	c.logger.Debug("Starting eviction of idle pods", zap.Int("podCount", len(pods)))
+	var replacements *replacementAgents
+	if spec.VerifyReplacementAgents && c.agentProviderEnabled(spec) {
+		replacements = newReplacementAgents()
+	}
+	var backlogs map[string]bool
+	if spec.MaxQueuedJobs != nil && c.agentProviderEnabled(spec) {
+		backlogs = map[string]bool{}
+	}
 	for _, pod := range pods {
-		poolName, err := c.getPodsPool(ctx, pod.Name, pod.Namespace)
-		if err != nil {
-			c.logger.Error("Failed to get pod pool", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
-			return err
+		if ctx.Err() != nil {
+			c.logger.Info("Shutdown in progress, not starting the eviction of further pods", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+			return ctx.Err()
 		}
-		c.logger.Debug("Processing pod", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace), zap.String("poolName", poolName))
-		if err := c.azureDevopsController.DisableAgent(poolName, pod.Name); err != nil {
-			c.logger.Error("Failed to disable agent in Azure DevOps", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace), zap.String("poolName", poolName))
-			return err
+		if pod.DeletionTimestamp != nil || c.evictions.reached(pod.UID, stageEvicted) {
+			c.logger.Debug("Pod is already being evicted, skipping it", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+			continue
 		}
-		c.logger.Debug("Disabled agent in Azure DevOps", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace), zap.String("poolName", poolName))
-		c.logger.Debug("Removing agent from Azure DevOps", zap.String("podName", pod.Name), zap.String("poolName", poolName))
-		if err := c.azureDevopsController.RemoveAgent(poolName, pod.Name); err != nil {
-			c.logger.Error("Failed to remove agent from Azure DevOps", zap.Error(err), zap.String("podName", pod.Name), zap.String("poolName", poolName))
+		if backlogs != nil && !c.evictions.reached(pod.UID, stageDeregistered) {
+			backlogged, err := c.poolBacklogged(ctx, pod, int(*spec.MaxQueuedJobs), backlogs)
+			if err != nil {
+				return err
+			}
+			if backlogged {
+				c.logger.Info("Too many jobs are queued in the pool, keeping the agent of the pod", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+				continue
+			}
+		}
+		if replacements != nil && !c.evictions.reached(pod.UID, stageDeregistered) {
+			replaced, err := c.reserveReplacementAgent(ctx, pod, replacements)
+			if err != nil {
+				return err
+			}
+			if !replaced {
+				c.logger.Info("No replacement agent is online, keeping the agent of the pod", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+				continue
+			}
+		}
+		if err := c.evictPod(ctx, pod, spec); err != nil {
 			return err
 		}
-		c.logger.Debug("Agent removed from Azure DevOps", zap.String("podName", pod.Name), zap.String("poolName", poolName))
-		c.logger.Info("Starting to evict pod", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+	}
+
+	c.logger.Debug("Finished eviction of idle pods")
+	return nil
+}
+
+// evictPod runs the eviction sequence of a single pod. The sequence is not interrupted when ctx is cancelled, it gets
+// drainBudget to finish, so the agent is not left disabled in Azure DevOps while its pod keeps running.
+// The steps which already succeeded in an earlier reconcile are not repeated.
+func (c *PodController) evictPod(ctx context.Context, pod corev1.Pod, spec safev1.SafeEvictSpec) error {
+	drainCtx, cancel := withDrainBudget(ctx, c.drainBudget)
+	defer cancel()
+
+	if !c.agentProviderEnabled(spec) {
+		c.logger.Debug("Agent provider integration is disabled, skipping agent deregistration", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+	} else if c.evictions.reached(pod.UID, stageDeregistered) {
+		c.logger.Debug("Agent is already deregistered, skipping agent deregistration", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+	} else {
+		if err := c.deregisterAgent(drainCtx, pod); err != nil {
+			if !isCrashLooping(pod) {
+				return err
+			}
+			// a crash-looping agent may never have registered, it must not block the rotation
+			c.logger.Warn("Failed to deregister the agent of the crash-looping pod, evicting it anyway", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+		}
+		c.evictions.record(pod.UID, stageDeregistered)
+	}
+	if spec.DrainSignal != nil && !isCrashLooping(pod) && !c.evictions.reached(pod.UID, stageJobKilled) {
+		gracePeriod := time.Duration(spec.DrainSignal.GracePeriodSeconds) * time.Second
+		if !c.evictions.reached(pod.UID, stageSignaled) {
+			signaled := c.signalDrain(drainCtx, pod, *spec.DrainSignal)
+			c.evictions.record(pod.UID, stageSignaled)
+			if !signaled {
+				// the agent did not get the signal, there is nothing to wait for
+				gracePeriod = 0
+			}
+		}
+		if c.evictions.since(pod.UID) < gracePeriod {
+			c.logger.Info("Waiting for the agent to shut down after the drain signal", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace), zap.Duration("gracePeriod", gracePeriod))
+			return nil
+		}
+	}
+	c.logger.Info("Starting to evict pod", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
 
-		if err := c.jobController.KillJobByPod(ctx, pod); err != nil {
+	if !c.evictions.reached(pod.UID, stageJobKilled) {
+		cascades, err := c.jobController.KillJobByPod(drainCtx, pod)
+		if err != nil {
 			c.logger.Error("Failed to kill job associated with pod", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
 			return err
 		}
-
-		if err := c.KillPod(ctx, pod); err != nil {
+		c.evictions.recordJobKilled(pod.UID, cascades)
+		c.logger.Debug("Job killed successfully", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+		if cascades {
+			// the garbage collector deletes the pod with its job
+			metrics.StatsFrom(ctx).PodsEvicted.Add(1)
+		}
+	}
+	if c.evictions.cascades(pod.UID) {
+		// a terminating pod is skipped by the next reconcile, the pod is only deleted here when the cascade is stuck
+		if c.evictions.since(pod.UID) < jobCascadeTimeout {
+			c.logger.Debug("Waiting for the deletion of the job to cascade to the pod", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+			return nil
+		}
+		c.logger.Warn("The deletion of the job did not cascade to the pod, deleting the pod", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace), zap.Duration("timeout", jobCascadeTimeout))
+		if err := c.KillPod(drainCtx, pod); err != nil && !apierrors.IsNotFound(err) {
 			c.logger.Error("Failed to kill pod", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
 			return err
 		}
+		c.evictions.record(pod.UID, stageEvicted)
+		return nil
+	}
 
-		c.logger.Debug("Job killed successfully", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+	if err := c.KillPod(drainCtx, pod); err != nil {
+		c.logger.Error("Failed to kill pod", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+		return err
+	}
+	c.evictions.record(pod.UID, stageEvicted)
+	metrics.StatsFrom(ctx).PodsEvicted.Add(1)
 
-		c.logger.Debug("Pod eviction completed", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+	c.logger.Debug("Pod eviction completed", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+	return nil
+}
+
+// signalDrain sends the drain signal to the agent of the pod. A failed signal is logged and does not stop the
+// eviction, the agent is killed as without a signal.
+func (c *PodController) signalDrain(ctx context.Context, pod corev1.Pod, signal safev1.DrainSignal) bool {
+	if c.drainSignaler == nil {
+		c.logger.Warn("Drain signals are not configured for the controller, evicting the pod without a signal", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+		return false
+	}
+	if err := c.drainSignaler.Signal(ctx, pod, signal); err != nil {
+		c.logger.Warn("Failed to send the drain signal, evicting the pod without it", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+		return false
 	}
+	c.logger.Info("Sent the drain signal to the agent", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+	return true
+}
 
-	c.logger.Debug("Finished eviction of idle pods")
+// poolBacklogged returns true when more than maxQueuedJobs jobs wait in the pool of the agent. The queue depth is
+// checked once per pool and eviction sequence and exposed as a metric.
+func (c *PodController) poolBacklogged(ctx context.Context, pod corev1.Pod, maxQueuedJobs int, backlogs map[string]bool) (bool, error) {
+	poolName, err := c.getPodsPool(ctx, pod.Name, pod.Namespace)
+	if err != nil {
+		c.logger.Error("Failed to get pod pool", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+		return false, err
+	}
+	if backlogged, checked := backlogs[poolName]; checked {
+		return backlogged, nil
+	}
+	queuedJobs, err := c.azureDevopsController.GetQueuedJobCount(ctx, poolName)
+	if err != nil {
+		c.logger.Error("Failed to get queued jobs", zap.Error(err), zap.String("poolName", poolName))
+		return false, err
+	}
+	metrics.RecordQueuedJobs(poolName, queuedJobs)
+	backlogs[poolName] = queuedJobs > maxQueuedJobs
+	if backlogs[poolName] {
+		c.logger.Info("Pausing evictions until the backlog of the pool is cleared", zap.String("poolName", poolName), zap.Int("queuedJobs", queuedJobs), zap.Int("maxQueuedJobs", maxQueuedJobs))
+	}
+	return backlogs[poolName], nil
+}
+
+// replacementAgents counts the replacement agents per Azure DevOps pool which are not yet matched with an evicted agent
+type replacementAgents struct {
+	available map[string]int
+	// unschedulable caches whether the nodes are cordoned
+	unschedulable map[string]bool
+}
+
+func newReplacementAgents() *replacementAgents {
+	return &replacementAgents{available: map[string]int{}, unschedulable: map[string]bool{}}
+}
+
+// reserveReplacementAgent matches the agent of the pod with an online agent of the same pool, which does not run on a
+// cordoned node. Every replacement agent is matched with a single evicted agent, so the pool swaps capacity instead of
+// losing it. It returns false when no replacement agent is left.
+func (c *PodController) reserveReplacementAgent(ctx context.Context, pod corev1.Pod, replacements *replacementAgents) (bool, error) {
+	poolName, err := c.getPodsPool(ctx, pod.Name, pod.Namespace)
+	if err != nil {
+		c.logger.Error("Failed to get pod pool", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+		return false, err
+	}
+	available, counted := replacements.available[poolName]
+	if !counted {
+		agents, err := c.azureDevopsController.GetOnlineAgents(ctx, poolName)
+		if err != nil {
+			c.logger.Error("Failed to get online agents", zap.Error(err), zap.String("poolName", poolName))
+			return false, err
+		}
+		for _, agent := range agents {
+			cordoned, err := c.runsOnCordonedNode(ctx, agent, pod.Namespace, replacements)
+			if err != nil {
+				return false, err
+			}
+			if !cordoned {
+				available++
+			}
+		}
+		c.logger.Debug("Counted replacement agents", zap.String("poolName", poolName), zap.Int("onlineAgents", len(agents)), zap.Int("replacementAgents", available))
+	}
+	if available == 0 {
+		replacements.available[poolName] = 0
+		return false, nil
+	}
+	replacements.available[poolName] = available - 1
+	return true, nil
+}
+
+// runsOnCordonedNode returns true when the pod of the agent, which is named after the host name of the agent, runs on
+// a cordoned node. An agent without a pod in the namespace runs outside of the drained nodes.
+func (c *PodController) runsOnCordonedNode(ctx context.Context, agent azuredevops.Agent, namespace string, replacements *replacementAgents) (bool, error) {
+	if agent.HostName == "" {
+		return false, nil
+	}
+	agentPod, err := c.kubeClient.CoreV1().Pods(namespace).Get(ctx, agent.HostName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get pod %s of agent %s: %w", agent.HostName, agent.Name, err)
+	}
+	if agentPod.Spec.NodeName == "" {
+		return false, nil
+	}
+	unschedulable, cached := replacements.unschedulable[agentPod.Spec.NodeName]
+	if !cached {
+		node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, agentPod.Spec.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get node %s: %w", agentPod.Spec.NodeName, err)
+		}
+		unschedulable = node.Spec.Unschedulable
+		replacements.unschedulable[agentPod.Spec.NodeName] = unschedulable
+	}
+	return unschedulable, nil
+}
+
+// withDrainBudget returns a context which is cancelled drainBudget after ctx is done, instead of together with it
+func withDrainBudget(ctx context.Context, drainBudget time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		timer := time.NewTimer(drainBudget)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-drainCtx.Done():
+		}
+	})
+	return drainCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// agentProviderEnabled returns false when Azure DevOps is not configured for the controller or it is switched off in the spec
+func (c *PodController) agentProviderEnabled(spec safev1.SafeEvictSpec) bool {
+	return azuredevops.IsEnabled(c.azureDevopsController) && spec.AgentProvider != safev1.AgentProviderNone
+}
+
+// deregisterAgent disables and removes the agent running in the pod from its Azure DevOps pool
+func (c *PodController) deregisterAgent(ctx context.Context, pod corev1.Pod) error {
+	poolName, err := c.getPodsPool(ctx, pod.Name, pod.Namespace)
+	if err != nil {
+		c.logger.Error("Failed to get pod pool", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+		return err
+	}
+	agent, err := c.getPodsAgent(ctx, pod)
+	if err != nil {
+		c.logger.Error("Failed to get agent of pod", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+		return err
+	}
+	c.logger.Debug("Processing pod", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace), zap.String("poolName", poolName), zap.String("agentName", agent.Name))
+	if err := c.azureDevopsController.DisableAgent(ctx, poolName, agent); err != nil {
+		c.logger.Error("Failed to disable agent in Azure DevOps", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace), zap.String("poolName", poolName))
+		return err
+	}
+	c.logger.Debug("Disabled agent in Azure DevOps", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace), zap.String("poolName", poolName))
+	c.logger.Debug("Removing agent from Azure DevOps", zap.String("podName", pod.Name), zap.String("poolName", poolName))
+	if err := c.azureDevopsController.RemoveAgent(ctx, poolName, agent); err != nil {
+		c.logger.Error("Failed to remove agent from Azure DevOps", zap.Error(err), zap.String("podName", pod.Name), zap.String("poolName", poolName))
+		// roll back, so the agent does not stay disabled while its pod keeps running, e.g. when the manager is shutting down.
+		// The rollback is sent even when the removal failed because ctx is done, the timeout of the client bounds it.
+		c.logger.Warn("Rolling back agent deregistration", zap.String("podName", pod.Name), zap.String("poolName", poolName))
+		if enableErr := c.azureDevopsController.EnableAgent(context.WithoutCancel(ctx), poolName, agent); enableErr != nil {
+			c.logger.Error("Failed to re-enable agent in Azure DevOps", zap.Error(enableErr), zap.String("podName", pod.Name), zap.String("poolName", poolName))
+		}
+		return err
+	}
+	c.logger.Debug("Agent removed from Azure DevOps", zap.String("podName", pod.Name), zap.String("poolName", poolName))
 	return nil
 }
 
@@ -85,6 +433,11 @@ func (c *PodController) GetSafeToEvictPods(ctx context.Context, spec safev1.Safe
 		return nil, fmt.Errorf("failed to list pods: %w", err)
 	}
 
+	var minPodAge time.Duration
+	if spec.Eviction != nil && spec.Eviction.MinPodAge != nil {
+		minPodAge = spec.Eviction.MinPodAge.Duration
+	}
+
 	// Filter pods that do not have the specified labels and are in the namespaces array
 	var filteredPods []corev1.Pod
 	for _, pod := range podList.Items {
@@ -93,22 +446,36 @@ func (c *PodController) GetSafeToEvictPods(ctx context.Context, spec safev1.Safe
 			continue
 		}
 
-		// Check if the pod does not have all the specified labels with matching values
-		for key, value := range spec.LabelSelector {
-			if pod.Labels[key] != value && pod.Status.Phase == corev1.PodRunning {
-				logs, err := c.fetchPodLogs(ctx, pod.Name, pod.Namespace)
-				if err != nil {
-					c.logger.Error("Failed to fetch pod logs", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
-					continue
-				}
+		switch classifyPod(pod) {
+		case podStateTerminating, podStateOther:
+			continue
+		case podStateCrashLooping:
+			// the logs of a crash-looping agent tell nothing, and it runs no job
+			c.logger.Info("Agent pod is crash-looping, evicting it without checking its logs", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+			filteredPods = append(filteredPods, pod)
+			continue
+		}
 
-				for _, line := range spec.LastLogLines {
-					if strings.HasSuffix(logs, line) {
-						filteredPods = append(filteredPods, pod)
-						break
-					}
-				}
-				continue
+		// a young agent may be about to pick up a job while its logs are still short
+		if age := c.podAge(pod); age < minPodAge {
+			c.logger.Debug("Pod is younger than the minimum pod age, not evaluating it", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace), zap.Duration("age", age))
+			continue
+		}
+
+		// A pod with all the specified labels is busy
+		if hasLabels(pod, spec.LabelSelector) {
+			continue
+		}
+		logs, err := c.fetchPodLogs(ctx, pod.Name, pod.Namespace)
+		if err != nil {
+			c.logger.Error("Failed to fetch pod logs", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+			continue
+		}
+		for _, line := range spec.LastLogLines {
+			// a blank line, which the validation refuses, would be the suffix of the logs of every pod
+			if strings.TrimSpace(line) != "" && strings.HasSuffix(logs, line) {
+				filteredPods = append(filteredPods, pod)
+				break
 			}
 		}
 	}
@@ -117,9 +484,190 @@ func (c *PodController) GetSafeToEvictPods(ctx context.Context, spec safev1.Safe
 	return filteredPods, nil
 }
 
+// CountBusyAgents returns the number of busy agents in the Azure DevOps pools of the pods in the monitored namespaces.
+// ErrAgentProviderDisabled is returned when the agents are not registered in Azure DevOps.
+func (c *PodController) CountBusyAgents(ctx context.Context, spec safev1.SafeEvictSpec) (int, error) {
+	if !c.agentProviderEnabled(spec) {
+		return 0, ErrAgentProviderDisabled
+	}
+	poolNames := map[string]bool{}
+	for _, namespace := range spec.Namespaces {
+		podList, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			c.logger.Error("Error listing pods", zap.Error(err), zap.String("namespace", namespace))
+			return 0, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+		}
+		for _, pod := range podList.Items {
+			poolName, err := c.getAgentPool(ctx, pod)
+			if err != nil {
+				c.logger.Debug("Pod is not an agent of a known pool, skipping it", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+				continue
+			}
+			poolNames[poolName] = true
+		}
+	}
+
+	busy := 0
+	for poolName := range poolNames {
+		count, err := c.azureDevopsController.GetBusyAgentCount(ctx, poolName)
+		if err != nil {
+			c.logger.Error("Failed to get busy agents", zap.Error(err), zap.String("poolName", poolName))
+			return 0, err
+		}
+		busy += count
+	}
+	return busy, nil
+}
+
+// CountReadyPods returns the number of ready pods in the namespaces. The pods which are terminating or which were
+// evicted by the controller are not counted, as they do not pick up new pipeline jobs anymore.
+func (c *PodController) CountReadyPods(ctx context.Context, namespaces []string) (int, error) {
+	ready := 0
+	for _, namespace := range namespaces {
+		podList, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			c.logger.Error("Error listing pods", zap.Error(err), zap.String("namespace", namespace))
+			return 0, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+		}
+		for _, pod := range podList.Items {
+			if pod.DeletionTimestamp != nil || c.evictions.reached(pod.UID, stageEvicted) {
+				continue
+			}
+			if isPodReady(pod) {
+				ready++
+			}
+		}
+	}
+	return ready, nil
+}
+
+// GetUnschedulablePods returns the pods of the namespaces which the scheduler could not place for at least pendingFor,
+// e.g. because no node with free capacity matches them
+func (c *PodController) GetUnschedulablePods(ctx context.Context, namespaces []string, pendingFor time.Duration) ([]corev1.Pod, error) {
+	var unschedulable []corev1.Pod
+	for _, namespace := range namespaces {
+		podList, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			c.logger.Error("Error listing pods", zap.Error(err), zap.String("namespace", namespace))
+			return nil, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+		}
+		for _, pod := range podList.Items {
+			if pod.Status.Phase != corev1.PodPending || pod.Spec.NodeName != "" || pod.DeletionTimestamp != nil {
+				continue
+			}
+			for _, condition := range pod.Status.Conditions {
+				if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse &&
+					condition.Reason == corev1.PodReasonUnschedulable && c.clock.Since(condition.LastTransitionTime.Time) >= pendingFor {
+					unschedulable = append(unschedulable, pod)
+				}
+			}
+		}
+	}
+	return unschedulable, nil
+}
+
+// podState decides how a pod is drained
+type podState int
+
+const (
+	// podStateOther is a pod which is not running, e.g. pending or completed, it is not evicted
+	podStateOther podState = iota
+	// podStateTerminating is a pod which is already being deleted, it counts as drained
+	podStateTerminating
+	// podStateCrashLooping is a running pod with a container in CrashLoopBackOff, it is evicted without log checks
+	podStateCrashLooping
+	// podStateRunning is a healthy running pod, its logs tell whether its agent is idle
+	podStateRunning
+)
+
+func classifyPod(pod corev1.Pod) podState {
+	switch {
+	case pod.DeletionTimestamp != nil:
+		return podStateTerminating
+	case pod.Status.Phase != corev1.PodRunning:
+		return podStateOther
+	case isCrashLooping(pod):
+		return podStateCrashLooping
+	}
+	return podStateRunning
+}
+
+// podAge returns how long the pod has been running, the creation time is used until the kubelet started the pod
+func (c *PodController) podAge(pod corev1.Pod) time.Duration {
+	started := pod.CreationTimestamp.Time
+	if pod.Status.StartTime != nil {
+		started = pod.Status.StartTime.Time
+	}
+	return c.clock.Since(started)
+}
+
+// isCrashLooping returns true when a container of the pod waits to be restarted after crashing
+func isCrashLooping(pod corev1.Pod) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLabels returns true when the pod has every label of the selector with the same value, a pod which does not
+// have them is checked for being idle. With an empty selector no pod is checked.
+func hasLabels(pod corev1.Pod, selector map[string]string) bool {
+	for key, value := range selector {
+		if pod.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func isPodReady(pod corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// SortForEviction orders the pods in the eviction order, the pods keep their order when it is empty
+func SortForEviction(pods []corev1.Pod, order safev1.EvictionOrder) {
+	newestFirst := func(a, b corev1.Pod) int {
+		return b.CreationTimestamp.Compare(a.CreationTimestamp.Time)
+	}
+	switch order {
+	case safev1.EvictionOrderOldestFirst:
+		slices.SortStableFunc(pods, func(a, b corev1.Pod) int {
+			return a.CreationTimestamp.Compare(b.CreationTimestamp.Time)
+		})
+	case safev1.EvictionOrderNewestFirst:
+		slices.SortStableFunc(pods, newestFirst)
+	case safev1.EvictionOrderByPriorityClass:
+		slices.SortStableFunc(pods, func(a, b corev1.Pod) int {
+			if priority := cmp.Compare(podPriority(a), podPriority(b)); priority != 0 {
+				return priority
+			}
+			return newestFirst(a, b)
+		})
+	}
+}
+
+// podPriority returns the priority resolved from the priority class of the pod at admission
+func podPriority(pod corev1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
 func (c *PodController) KillPod(ctx context.Context, pod corev1.Pod) error {
 	// Delete the pod
-	err := c.kubeClient.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	err := c.mutationClient.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	audit.Write(ctx, audit.ActionEvictPod, audit.Target(audit.KindPod, pod.Namespace, pod.Name), err)
 	if err != nil {
 		c.logger.Error("Error deleting pod", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
 		return fmt.Errorf("failed to delete pod '%s' in namespace %s: %w", pod.Name, pod.Namespace, err)
@@ -153,18 +701,124 @@ func (c *PodController) getPodsPool(ctx context.Context, podName, namespace stri
 	// Get the pod details
 	pod, err := c.kubeClient.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
 	if err != nil {
-		c.logger.Error("Error getting pod details", zap.Error(err), zap.String("podName", pod.Name), zap.String("namespace", namespace))
+		c.logger.Error("Error getting pod details", zap.Error(err), zap.String("podName", podName), zap.String("namespace", namespace))
 		return "", fmt.Errorf("failed to get pod '%s' in namespace %s: %w", podName, namespace, err)
 	}
+	return c.getAgentPool(ctx, *pod)
+}
+
+// getAgentPool returns the Azure DevOps pool of the agent pod, the pool resolved from its environment is cached
+func (c *PodController) getAgentPool(ctx context.Context, pod corev1.Pod) (string, error) {
+	podName, namespace := pod.Name, pod.Namespace
+	// The annotation overrides whatever is configured in the environment of the agent
+	if poolName, exists := pod.Annotations[AgentPoolAnnotation]; exists && poolName != "" {
+		c.logger.Debug("Agent pool is set by annotation", zap.String("podName", podName), zap.String("namespace", namespace), zap.String("poolName", poolName))
+		if err := azuredevops.ValidatePoolName(poolName); err != nil {
+			return "", fmt.Errorf("annotation %s of pod '%s' in namespace %s: %w", AgentPoolAnnotation, podName, namespace, err)
+		}
+		return poolName, nil
+	}
+
+	if poolName, cached := c.agentPools.get(pod.UID); cached {
+		return poolName, nil
+	}
 
 	// Iterate through the pod's environment variables to find AZP_POOL
 	for _, container := range pod.Spec.Containers {
-		for _, envVar := range container.Env {
-			if envVar.Name == "AZP_POOL" {
-				return envVar.Value, nil
+		poolName, found, err := c.resolveContainerEnv(ctx, namespace, container, agentPoolEnvName)
+		if err != nil {
+			c.logger.Error("Error resolving environment variable", zap.Error(err), zap.String("podName", podName), zap.String("namespace", namespace), zap.String("container", container.Name))
+			return "", fmt.Errorf("failed to resolve %s in pod '%s' in namespace %s: %w", agentPoolEnvName, podName, namespace, err)
+		}
+		if found {
+			if err := azuredevops.ValidatePoolName(poolName); err != nil {
+				return "", fmt.Errorf("%s of pod '%s' in namespace %s: %w", agentPoolEnvName, podName, namespace, err)
+			}
+			if pod.UID != "" {
+				c.agentPools.set(pod.UID, namespace, poolName)
 			}
+			return poolName, nil
 		}
 	}
 	c.logger.Debug("AZP_POOL environment variable not found", zap.String("podName", podName), zap.String("namespace", namespace))
 	return "", fmt.Errorf("environment variable AZP_POOL not found in pod '%s' in namespace %s", podName, namespace)
 }
+
+// getPodsAgent returns the agent registered by the pod, its name comes from AZP_AGENT_NAME and defaults to the pod name
+func (c *PodController) getPodsAgent(ctx context.Context, pod corev1.Pod) (azuredevops.Agent, error) {
+	agent := azuredevops.Agent{Name: pod.Name, HostName: pod.Name}
+	for _, container := range pod.Spec.Containers {
+		agentName, found, err := c.resolveContainerEnv(ctx, pod.Namespace, container, agentNameEnvName)
+		if err != nil {
+			return agent, fmt.Errorf("failed to resolve %s in pod '%s' in namespace %s: %w", agentNameEnvName, pod.Name, pod.Namespace, err)
+		}
+		if found && agentName != "" {
+			agent.Name = agentName
+			break
+		}
+	}
+	return agent, nil
+}
+
+// resolveContainerEnv returns the value of an environment variable of the container. Variables defined in env take precedence
+// over envFrom sources, and later envFrom sources override earlier ones, the same way as the kubelet resolves them.
+func (c *PodController) resolveContainerEnv(ctx context.Context, namespace string, container corev1.Container, name string) (string, bool, error) {
+	for _, envVar := range container.Env {
+		if envVar.Name != name {
+			continue
+		}
+		if envVar.ValueFrom == nil {
+			return envVar.Value, true, nil
+		}
+		if ref := envVar.ValueFrom.ConfigMapKeyRef; ref != nil {
+			return c.getConfigMapValue(ctx, namespace, ref.Name, ref.Key, ref.Optional)
+		}
+		if ref := envVar.ValueFrom.SecretKeyRef; ref != nil {
+			return c.getSecretValue(ctx, namespace, ref.Name, ref.Key, ref.Optional)
+		}
+	}
+
+	for i := len(container.EnvFrom) - 1; i >= 0; i-- {
+		envFrom := container.EnvFrom[i]
+		if !strings.HasPrefix(name, envFrom.Prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(name, envFrom.Prefix)
+		var value string
+		var found bool
+		var err error
+		if ref := envFrom.ConfigMapRef; ref != nil {
+			value, found, err = c.getConfigMapValue(ctx, namespace, ref.Name, key, ref.Optional)
+		} else if ref := envFrom.SecretRef; ref != nil {
+			value, found, err = c.getSecretValue(ctx, namespace, ref.Name, key, ref.Optional)
+		}
+		if err != nil || found {
+			return value, found, err
+		}
+	}
+	return "", false, nil
+}
+
+func (c *PodController) getConfigMapValue(ctx context.Context, namespace, name, key string, optional *bool) (string, bool, error) {
+	configMap, err := c.kubeClient.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) && optional != nil && *optional {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get ConfigMap '%s': %w", name, err)
+	}
+	value, found := configMap.Data[key]
+	return value, found, nil
+}
+
+func (c *PodController) getSecretValue(ctx context.Context, namespace, name, key string, optional *bool) (string, bool, error) {
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) && optional != nil && *optional {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get Secret '%s': %w", name, err)
+	}
+	value, found := secret.Data[key]
+	return string(value), found, nil
+}
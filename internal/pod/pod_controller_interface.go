@@ -0,0 +1,34 @@
+package pod
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	safev1 "norbinto/node-updater/api/v1"
+)
+
+// PodControllerInterface finds the idle agent pods of a cluster and evicts them, it is implemented by PodController
+type PodControllerInterface interface {
+	GetSafeToEvictPods(ctx context.Context, spec safev1.SafeEvictSpec) ([]corev1.Pod, error)
+	EvictIdlePods(ctx context.Context, pods []corev1.Pod, spec safev1.SafeEvictSpec) error
+	CountBusyAgents(ctx context.Context, spec safev1.SafeEvictSpec) (int, error)
+	DiscoverAgentPools(ctx context.Context, namespaces []string) (map[string][]string, error)
+	CountReadyPods(ctx context.Context, namespaces []string) (int, error)
+	GetAgentHealth(ctx context.Context, spec safev1.SafeEvictSpec, nodepools []string, gracePeriod time.Duration) (AgentHealth, error)
+	GetUnschedulablePods(ctx context.Context, namespaces []string, pendingFor time.Duration) ([]corev1.Pod, error)
+	KillPod(ctx context.Context, pod corev1.Pod) error
+	ResumeCronJobs(ctx context.Context, namespaces []string) error
+	WithOwner(owner string) PodControllerInterface
+	WithMutationClient(mutationClient kubernetes.Interface) PodControllerInterface
+	WithDrainSignaler(drainSignaler DrainSignaler) PodControllerInterface
+	WithExecConfig(execConfig *rest.Config) PodControllerInterface
+	WithKubeClient(kubeClient kubernetes.Interface) PodControllerInterface
+	WithLogFields(fields ...zap.Field) PodControllerInterface
+}
+
+var _ PodControllerInterface = &PodController{}
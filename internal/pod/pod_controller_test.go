@@ -0,0 +1,1251 @@
+package pod
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+
+	safev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/azuredevops"
+	job "norbinto/node-updater/internal/job"
+	"norbinto/node-updater/internal/metrics"
+	testingfake "norbinto/node-updater/pkg/testing/fake"
+)
+
+func newAgentPod(annotations map[string]string, container corev1.Container) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "agent-pod",
+			Namespace:   "agents",
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{container},
+		},
+	}
+}
+
+func TestGetPodsPool_LiteralValue(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset(newAgentPod(nil, corev1.Container{
+		Name: "agent",
+		Env:  []corev1.EnvVar{{Name: "AZP_POOL", Value: "literal-pool"}},
+	}))
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	poolName, err := controller.getPodsPool(context.TODO(), "agent-pod", "agents")
+	if err != nil {
+		t.Fatalf("getPodsPool failed: %v", err)
+	}
+	if poolName != "literal-pool" {
+		t.Fatalf("Expected pool 'literal-pool', got: %s", poolName)
+	}
+}
+
+func TestGetPodsPool_Annotation(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset(newAgentPod(map[string]string{AgentPoolAnnotation: "annotated-pool"}, corev1.Container{
+		Name: "agent",
+		Env:  []corev1.EnvVar{{Name: "AZP_POOL", Value: "literal-pool"}},
+	}))
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	poolName, err := controller.getPodsPool(context.TODO(), "agent-pod", "agents")
+	if err != nil {
+		t.Fatalf("getPodsPool failed: %v", err)
+	}
+	if poolName != "annotated-pool" {
+		t.Fatalf("Expected pool 'annotated-pool', got: %s", poolName)
+	}
+}
+
+func TestGetPodsPool_SecretKeyRef(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent-secret", Namespace: "agents"},
+			Data:       map[string][]byte{"pool": []byte("secret-pool")},
+		},
+		newAgentPod(nil, corev1.Container{
+			Name: "agent",
+			Env: []corev1.EnvVar{{
+				Name: "AZP_POOL",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "agent-secret"},
+						Key:                  "pool",
+					},
+				},
+			}},
+		}))
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	poolName, err := controller.getPodsPool(context.TODO(), "agent-pod", "agents")
+	if err != nil {
+		t.Fatalf("getPodsPool failed: %v", err)
+	}
+	if poolName != "secret-pool" {
+		t.Fatalf("Expected pool 'secret-pool', got: %s", poolName)
+	}
+}
+
+func TestGetPodsPool_ConfigMapEnvFrom(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent-config", Namespace: "agents"},
+			Data:       map[string]string{"AZP_POOL": "configmap-pool"},
+		},
+		newAgentPod(nil, corev1.Container{
+			Name: "agent",
+			EnvFrom: []corev1.EnvFromSource{{
+				ConfigMapRef: &corev1.ConfigMapEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "agent-config"},
+				},
+			}},
+		}))
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	poolName, err := controller.getPodsPool(context.TODO(), "agent-pod", "agents")
+	if err != nil {
+		t.Fatalf("getPodsPool failed: %v", err)
+	}
+	if poolName != "configmap-pool" {
+		t.Fatalf("Expected pool 'configmap-pool', got: %s", poolName)
+	}
+}
+
+func TestGetPodsPool_NotFound(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset(newAgentPod(nil, corev1.Container{Name: "agent"}))
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	_, err := controller.getPodsPool(context.TODO(), "agent-pod", "agents")
+	if err == nil || err.Error() != "environment variable AZP_POOL not found in pod 'agent-pod' in namespace agents" {
+		t.Fatalf("Expected AZP_POOL not found error, got: %v", err)
+	}
+}
+
+func TestGetPodsPool_InvalidPoolName(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		container   corev1.Container
+	}{
+		{"environment with a trailing newline", nil, corev1.Container{
+			Name: "agent",
+			Env:  []corev1.EnvVar{{Name: "AZP_POOL", Value: "Linux Agents\n"}},
+		}},
+		{"annotation with a leading space", map[string]string{AgentPoolAnnotation: " Linux Agents"}, corev1.Container{Name: "agent"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zaptest.NewLogger(t)
+			kubeClient := fake.NewSimpleClientset(newAgentPod(tt.annotations, tt.container))
+			controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+			_, err := controller.getPodsPool(context.TODO(), "agent-pod", "agents")
+
+			if !errors.Is(err, azuredevops.ErrInvalidPoolName) {
+				t.Fatalf("Expected ErrInvalidPoolName, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetPodsAgent_AgentNameEnv(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset()
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	pod := newAgentPod(nil, corev1.Container{
+		Name: "agent",
+		Env:  []corev1.EnvVar{{Name: "AZP_AGENT_NAME", Value: "custom-agent"}},
+	})
+	agent, err := controller.getPodsAgent(context.TODO(), *pod)
+	if err != nil {
+		t.Fatalf("getPodsAgent failed: %v", err)
+	}
+	if agent.Name != "custom-agent" || agent.HostName != "agent-pod" {
+		t.Fatalf("Expected agent 'custom-agent' on host 'agent-pod', got: %+v", agent)
+	}
+}
+
+func TestGetPodsAgent_PodNameFallback(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewSimpleClientset()
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	pod := newAgentPod(nil, corev1.Container{Name: "agent"})
+	agent, err := controller.getPodsAgent(context.TODO(), *pod)
+	if err != nil {
+		t.Fatalf("getPodsAgent failed: %v", err)
+	}
+	if agent.Name != "agent-pod" {
+		t.Fatalf("Expected agent name to fall back to the pod name, got: %s", agent.Name)
+	}
+}
+
+type fakeAzureDevopsController struct {
+	removeErr error
+	// removeErrs fail the removal of single agents by name
+	removeErrs   map[string]error
+	enabledState map[string]bool
+	disableCount int
+}
+
+func (f *fakeAzureDevopsController) DisableAgent(_ context.Context, poolName string, agent azuredevops.Agent) error {
+	f.disableCount++
+	f.enabledState[agent.Name] = false
+	return nil
+}
+
+func (f *fakeAzureDevopsController) EnableAgent(_ context.Context, poolName string, agent azuredevops.Agent) error {
+	f.enabledState[agent.Name] = true
+	return nil
+}
+
+func (f *fakeAzureDevopsController) RemoveAgent(_ context.Context, poolName string, agent azuredevops.Agent) error {
+	if err, exists := f.removeErrs[agent.Name]; exists {
+		return err
+	}
+	return f.removeErr
+}
+
+func (f *fakeAzureDevopsController) CheckConnection(_ context.Context) error {
+	return nil
+}
+
+func (f *fakeAzureDevopsController) GetOnlineAgents(_ context.Context, poolName string) ([]azuredevops.Agent, error) {
+	return nil, nil
+}
+
+func (f *fakeAzureDevopsController) GetQueuedJobCount(_ context.Context, poolName string) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeAzureDevopsController) GetBusyAgentCount(_ context.Context, poolName string) (int, error) {
+	return 0, nil
+}
+
+func TestEvictIdlePods_RollsBackDisabledAgent(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod := newAgentPod(nil, corev1.Container{
+		Name: "agent",
+		Env:  []corev1.EnvVar{{Name: "AZP_POOL", Value: "pool"}},
+	})
+	kubeClient := fake.NewSimpleClientset(pod)
+	adoController := &fakeAzureDevopsController{removeErr: errors.New("mock remove error"), enabledState: map[string]bool{}}
+	controller := NewPodController(kubeClient, adoController, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, safev1.SafeEvictSpec{})
+	if err == nil {
+		t.Fatalf("Expected eviction to fail, got nil")
+	}
+	if !adoController.enabledState["agent-pod"] {
+		t.Fatalf("Expected agent to be re-enabled after failed removal")
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected pod to be kept, got: %v", err)
+	}
+}
+
+func TestEvictIdlePods_StopsOnCancelledContext(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod := newAgentPod(nil, corev1.Container{Name: "agent"})
+	kubeClient := fake.NewSimpleClientset(pod)
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err := controller.EvictIdlePods(ctx, []corev1.Pod{*pod}, safev1.SafeEvictSpec{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context cancelled error, got: %v", err)
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected pod to be kept, got: %v", err)
+	}
+}
+
+func TestWithDrainBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	drainCtx, drainCancel := withDrainBudget(ctx, 50*time.Millisecond)
+	defer drainCancel()
+
+	cancel()
+	if drainCtx.Err() != nil {
+		t.Fatalf("Expected drain context to outlive its parent, got: %v", drainCtx.Err())
+	}
+	select {
+	case <-drainCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected drain context to be cancelled after the drain budget")
+	}
+}
+
+func TestKillPod_WithMutationClient(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod := newAgentPod(nil, corev1.Container{Name: "agent"})
+	kubeClient := fake.NewSimpleClientset(pod)
+	mutationClient := fake.NewSimpleClientset(pod)
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger).WithMutationClient(mutationClient)
+
+	if err := controller.KillPod(context.TODO(), *pod); err != nil {
+		t.Fatalf("KillPod failed: %v", err)
+	}
+	if _, err := mutationClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err == nil {
+		t.Fatalf("Expected pod to be deleted with the mutation client")
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected pod to be kept in the default client, got: %v", err)
+	}
+}
+
+func newEvictablePod() (*corev1.Pod, *batchv1.Job) {
+	pod := newAgentPod(nil, corev1.Container{
+		Name: "agent",
+		Env:  []corev1.EnvVar{{Name: "AZP_POOL", Value: "pool"}},
+	})
+	pod.UID = types.UID("agent-pod-uid")
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "Job", Name: "agent-job"}}
+	return pod, &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "agent-job", Namespace: "agents"}}
+}
+
+func TestEvictIdlePods_SkipsAlreadyEvictedPod(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	adoController := &fakeAzureDevopsController{enabledState: map[string]bool{}}
+	controller := NewPodController(kubeClient, adoController, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	// the next reconcile still lists the pod, e.g. because the informer cache is behind
+	if err := controller.WithKubeClient(kubeClient).EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("Expected the evicted pod to be skipped, got: %v", err)
+	}
+	if adoController.disableCount != 1 {
+		t.Fatalf("Expected the agent to be disabled once, got: %d", adoController.disableCount)
+	}
+}
+
+func TestEvictIdlePods_SkipsTerminatingPod(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	pod.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	adoController := &fakeAzureDevopsController{enabledState: map[string]bool{}}
+	controller := NewPodController(kubeClient, adoController, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if adoController.disableCount != 0 {
+		t.Fatalf("Expected the agent of a terminating pod not to be disabled")
+	}
+	if _, err := kubeClient.BatchV1().Jobs("agents").Get(context.TODO(), "agent-job", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected the job of a terminating pod to be kept, got: %v", err)
+	}
+}
+
+func TestEvictIdlePods_ResumesPartialEviction(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	failedDeletes := 0
+	kubeClient.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failedDeletes > 0 {
+			return false, nil, nil
+		}
+		failedDeletes++
+		return true, nil, errors.New("mock delete error")
+	})
+	adoController := &fakeAzureDevopsController{enabledState: map[string]bool{}}
+	controller := NewPodController(kubeClient, adoController, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err == nil {
+		t.Fatalf("Expected eviction to fail, got nil")
+	}
+	// the job is gone, so killing it again would fail
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("Expected the eviction to resume, got: %v", err)
+	}
+	if adoController.disableCount != 1 {
+		t.Fatalf("Expected the agent to be disabled once, got: %d", adoController.disableCount)
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err == nil {
+		t.Fatalf("Expected pod to be deleted")
+	}
+}
+
+func TestEvictionTracker_Expires(t *testing.T) {
+	fakeClock := testingclock.NewFakePassiveClock(time.Now())
+	tracker := newEvictionTracker(fakeClock)
+
+	tracker.record("uid", stageJobKilled)
+	if !tracker.reached("uid", stageDeregistered) || !tracker.reached("uid", stageJobKilled) {
+		t.Fatalf("Expected the recorded stage and the stages before it to be reached")
+	}
+	if tracker.reached("uid", stageEvicted) {
+		t.Fatalf("Expected the stages after the recorded one not to be reached")
+	}
+
+	fakeClock.SetTime(fakeClock.Now().Add(evictionTTL + time.Second))
+	if tracker.reached("uid", stageDeregistered) {
+		t.Fatalf("Expected the eviction to be forgotten after the TTL")
+	}
+}
+
+func TestCountReadyPods(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	readyPod := func(name, namespace string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(name)},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+	terminating := readyPod("terminating", "agents")
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	terminating.Finalizers = []string{"test"}
+	notReady := readyPod("not-ready", "agents")
+	notReady.Status.Conditions[0].Status = corev1.ConditionFalse
+	pending := readyPod("pending", "agents")
+	pending.Status.Phase = corev1.PodPending
+	kubeClient := fake.NewSimpleClientset(readyPod("ready", "agents"), readyPod("other-ready", "other"),
+		readyPod("unmonitored", "default"), terminating, notReady, pending)
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	ready, err := controller.CountReadyPods(context.TODO(), []string{"agents", "other"})
+	if err != nil {
+		t.Fatalf("CountReadyPods failed: %v", err)
+	}
+	if ready != 2 {
+		t.Fatalf("Expected 2 ready pods, got: %d", ready)
+	}
+}
+
+func TestGetUnschedulablePods(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	now := time.Now()
+	pendingPod := func(name string, pendingSince time.Time, reason string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "agents"},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{{
+					Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: reason, LastTransitionTime: metav1.NewTime(pendingSince),
+				}},
+			},
+		}
+	}
+	pulling := pendingPod("pulling", now.Add(-time.Hour), "")
+	pulling.Spec.NodeName = "node-0"
+	pulling.Status.Conditions = nil
+	kubeClient := fake.NewSimpleClientset(pendingPod("stuck", now.Add(-15*time.Minute), corev1.PodReasonUnschedulable),
+		pendingPod("recent", now.Add(-time.Minute), corev1.PodReasonUnschedulable), pendingPod("gated", now.Add(-time.Hour), corev1.PodReasonSchedulingGated), pulling)
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+	controller.clock = testingclock.NewFakePassiveClock(now)
+
+	pods, err := controller.GetUnschedulablePods(context.TODO(), []string{"agents"}, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("GetUnschedulablePods failed: %v", err)
+	}
+	if len(pods) != 1 || pods[0].Name != "stuck" {
+		t.Fatalf("Expected only the pod unschedulable for longer than the timeout, got: %v", pods)
+	}
+}
+
+func TestEvictIdlePods_WaitsForReplacementAgents(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	cordoned := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "outdated-0"}, Spec: corev1.NodeSpec{Unschedulable: true}}
+	schedulable := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "temporary-0"}}
+	agentPod := func(name, nodeName string) *corev1.Pod {
+		pod := newAgentPod(nil, corev1.Container{
+			Name: "agent",
+			Env:  []corev1.EnvVar{{Name: "AZP_POOL", Value: "pool"}},
+		})
+		pod.Name = name
+		pod.UID = types.UID(name)
+		pod.Spec.NodeName = nodeName
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: "Job", Name: name}}
+		return pod
+	}
+	idlePods := []corev1.Pod{*agentPod("idle-0", cordoned.Name), *agentPod("idle-1", cordoned.Name)}
+	replacement := agentPod("replacement", schedulable.Name)
+	kubeClient := fake.NewSimpleClientset(cordoned, schedulable, &idlePods[0], &idlePods[1], replacement,
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "idle-0", Namespace: "agents"}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "idle-1", Namespace: "agents"}})
+	agentProvider := testingfake.NewAgentProvider()
+	for _, name := range []string{"idle-0", "idle-1", "replacement"} {
+		agentProvider.AddAgent("pool", azuredevops.Agent{Name: name, HostName: name})
+	}
+	if err := agentProvider.SetOnline("pool", azuredevops.Agent{Name: "replacement"}, false); err != nil {
+		t.Fatalf("SetOnline failed: %v", err)
+	}
+	controller := NewPodController(kubeClient, agentProvider, job.NewJobController(kubeClient, logger), time.Second, logger)
+	spec := safev1.SafeEvictSpec{VerifyReplacementAgents: true}
+
+	// the agents on the cordoned node do not replace each other
+	if err := controller.EvictIdlePods(context.TODO(), idlePods, spec); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if agentProvider.Agent("pool", azuredevops.Agent{Name: "idle-0"}) == nil || agentProvider.Agent("pool", azuredevops.Agent{Name: "idle-1"}) == nil {
+		t.Fatal("Expected the agents to be kept while no replacement agent is online")
+	}
+
+	// a single replacement agent swaps a single agent
+	if err := agentProvider.SetOnline("pool", azuredevops.Agent{Name: "replacement"}, true); err != nil {
+		t.Fatalf("SetOnline failed: %v", err)
+	}
+	if err := controller.EvictIdlePods(context.TODO(), idlePods, spec); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if agentProvider.Agent("pool", azuredevops.Agent{Name: "idle-0"}) != nil {
+		t.Error("Expected the first agent to be removed")
+	}
+	if agentProvider.Agent("pool", azuredevops.Agent{Name: "idle-1"}) == nil {
+		t.Error("Expected the second agent to be kept")
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "idle-1", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the pod of the second agent to be kept, got: %v", err)
+	}
+}
+
+func TestEvictIdlePods_PausesWhileJobsAreQueued(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	agentProvider := testingfake.NewAgentProvider()
+	agentProvider.AddAgent("pool", azuredevops.Agent{Name: pod.Name})
+	agentProvider.SetQueuedJobs("pool", 5)
+	controller := NewPodController(kubeClient, agentProvider, job.NewJobController(kubeClient, logger), time.Second, logger)
+	maxQueuedJobs := int32(3)
+	spec := safev1.SafeEvictSpec{MaxQueuedJobs: &maxQueuedJobs}
+
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, spec); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if agentProvider.Agent("pool", azuredevops.Agent{Name: pod.Name}) == nil {
+		t.Fatal("Expected the agent to be kept while the backlog exceeds the threshold")
+	}
+
+	agentProvider.SetQueuedJobs("pool", 3)
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, spec); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if agentProvider.Agent("pool", azuredevops.Agent{Name: pod.Name}) != nil {
+		t.Error("Expected the agent to be removed once the backlog is cleared")
+	}
+}
+
+func TestCountBusyAgents(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	agentPod := func(name, poolName string) *corev1.Pod {
+		pod := newAgentPod(nil, corev1.Container{
+			Name: "agent",
+			Env:  []corev1.EnvVar{{Name: "AZP_POOL", Value: poolName}},
+		})
+		pod.Name = name
+		return pod
+	}
+	kubeClient := fake.NewSimpleClientset(agentPod("first-0", "first"), agentPod("first-1", "first"), agentPod("second-0", "second"),
+		newAgentPod(nil, corev1.Container{Name: "sidecar"}))
+	agentProvider := testingfake.NewAgentProvider()
+	for _, agent := range []struct{ pool, name string }{{"first", "first-0"}, {"first", "first-1"}, {"second", "second-0"}, {"unmonitored", "other"}} {
+		agentProvider.AddAgent(agent.pool, azuredevops.Agent{Name: agent.name})
+		if err := agentProvider.SetBusy(agent.pool, azuredevops.Agent{Name: agent.name}, agent.name != "first-1"); err != nil {
+			t.Fatalf("SetBusy failed: %v", err)
+		}
+	}
+	controller := NewPodController(kubeClient, agentProvider, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	busy, err := controller.CountBusyAgents(context.TODO(), safev1.SafeEvictSpec{Namespaces: []string{"agents"}})
+	if err != nil {
+		t.Fatalf("CountBusyAgents failed: %v", err)
+	}
+	if busy != 2 {
+		t.Fatalf("Expected 2 busy agents in the pools of the pods, got: %d", busy)
+	}
+
+	_, err = controller.CountBusyAgents(context.TODO(), safev1.SafeEvictSpec{Namespaces: []string{"agents"}, AgentProvider: safev1.AgentProviderNone})
+	if !errors.Is(err, ErrAgentProviderDisabled) {
+		t.Fatalf("Expected ErrAgentProviderDisabled, got: %v", err)
+	}
+}
+
+type fakeDrainSignaler struct {
+	signaled []string
+	err      error
+}
+
+func (f *fakeDrainSignaler) WithExecConfig(execConfig *rest.Config) DrainSignaler {
+	return f
+}
+
+func (f *fakeDrainSignaler) Signal(ctx context.Context, pod corev1.Pod, signal safev1.DrainSignal) error {
+	f.signaled = append(f.signaled, pod.Name)
+	return f.err
+}
+
+func TestEvictIdlePods_WaitsForAgentAfterDrainSignal(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	signaler := &fakeDrainSignaler{}
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger).WithDrainSignaler(signaler).(*PodController)
+	fakeClock := testingclock.NewFakePassiveClock(time.Now())
+	controller.evictions = newEvictionTracker(fakeClock)
+	spec := safev1.SafeEvictSpec{DrainSignal: &safev1.DrainSignal{
+		Exec:               &corev1.ExecAction{Command: []string{"touch", "/tmp/drain"}},
+		GracePeriodSeconds: 60,
+	}}
+
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, spec); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	fakeClock.SetTime(fakeClock.Now().Add(30 * time.Second))
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, spec); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if len(signaler.signaled) != 1 {
+		t.Fatalf("Expected the agent to be signaled once, got: %v", signaler.signaled)
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected the pod to be kept within the grace period, got: %v", err)
+	}
+
+	fakeClock.SetTime(fakeClock.Now().Add(31 * time.Second))
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, spec); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err == nil {
+		t.Fatalf("Expected the pod to be deleted after the grace period")
+	}
+}
+
+func TestEvictIdlePods_EvictsPodWhenDrainSignalFails(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	signaler := &fakeDrainSignaler{err: errors.New("container not found")}
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger).WithDrainSignaler(signaler)
+	spec := safev1.SafeEvictSpec{DrainSignal: &safev1.DrainSignal{
+		Exec:               &corev1.ExecAction{Command: []string{"touch", "/tmp/drain"}},
+		GracePeriodSeconds: 60,
+	}}
+
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, spec); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err == nil {
+		t.Fatalf("Expected the pod to be deleted without waiting for an agent which was not signaled")
+	}
+}
+
+func TestSortForEviction(t *testing.T) {
+	now := time.Now()
+	newPod := func(name string, age time.Duration, priority int32) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))},
+			Spec:       corev1.PodSpec{Priority: &priority},
+		}
+	}
+	pods := []corev1.Pod{
+		newPod("cache", 48*time.Hour, 1000),
+		newPod("new", time.Minute, 0),
+		newPod("old", 2*time.Hour, 0),
+	}
+	names := func(pods []corev1.Pod) []string {
+		var names []string
+		for _, pod := range pods {
+			names = append(names, pod.Name)
+		}
+		return names
+	}
+
+	for order, expected := range map[safev1.EvictionOrder][]string{
+		"":                                  {"cache", "new", "old"},
+		safev1.EvictionOrderOldestFirst:     {"cache", "old", "new"},
+		safev1.EvictionOrderNewestFirst:     {"new", "old", "cache"},
+		safev1.EvictionOrderByPriorityClass: {"new", "old", "cache"},
+	} {
+		sorted := slices.Clone(pods)
+		SortForEviction(sorted, order)
+		if !slices.Equal(names(sorted), expected) {
+			t.Errorf("Expected %v for order %q, got: %v", expected, order, names(sorted))
+		}
+	}
+
+	// a lower priority goes first even when the pod is older
+	pods[0].Spec.Priority = nil
+	pods[1].Spec.Priority = ptrInt32(10)
+	SortForEviction(pods, safev1.EvictionOrderByPriorityClass)
+	if !slices.Equal(names(pods), []string{"old", "cache", "new"}) {
+		t.Errorf("Expected the pods without priority first, got: %v", names(pods))
+	}
+}
+
+func ptrInt32(value int32) *int32 {
+	return &value
+}
+
+func TestGetSafeToEvictPods_ClassifiesPodsBeforeLogChecks(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	newPod := func(name string, labels map[string]string, status corev1.PodStatus) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "agents", Labels: labels}, Status: status}
+	}
+	running := corev1.PodStatus{Phase: corev1.PodRunning}
+	terminating := newPod("terminating", nil, running)
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	terminating.Finalizers = []string{"test"}
+	crashLooping := corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{{
+		Name:  "agent",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+	}}}
+	kubeClient := fake.NewSimpleClientset(
+		newPod("idle", nil, running),
+		newPod("busy", map[string]string{"busy": "true"}, running),
+		newPod("pending", nil, corev1.PodStatus{Phase: corev1.PodPending}),
+		newPod("crash-looping", map[string]string{"busy": "true"}, crashLooping),
+		terminating,
+	)
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	pods, err := controller.GetSafeToEvictPods(context.TODO(), safev1.SafeEvictSpec{
+		Namespaces:    []string{"agents"},
+		LabelSelector: map[string]string{"busy": "true"},
+		LastLogLines:  []string{"fake logs"},
+	})
+	if err != nil {
+		t.Fatalf("GetSafeToEvictPods failed: %v", err)
+	}
+
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"crash-looping", "idle"}) {
+		t.Fatalf("Expected the idle and the crash-looping pod, got: %v", names)
+	}
+	logRequests := 0
+	for _, action := range kubeClient.Actions() {
+		if action.GetSubresource() == "log" {
+			logRequests++
+		}
+	}
+	if logRequests != 1 {
+		t.Fatalf("Expected the logs of the idle pod only to be fetched, got %d requests", logRequests)
+	}
+}
+
+func TestEvictIdlePods_EvictsCrashLoopingPodWithoutRegisteredAgent(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "agent",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+	}}
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	adoController := &fakeAzureDevopsController{removeErr: errors.New("agent with name 'agent-pod' not found"), enabledState: map[string]bool{}}
+	controller := NewPodController(kubeClient, adoController, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err == nil {
+		t.Fatalf("Expected the crash-looping pod to be deleted")
+	}
+}
+
+func TestEvictIdlePods_CountsEvictedPods(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+	ctx, stats := metrics.WithReconcileStats(context.TODO())
+
+	if err := controller.EvictIdlePods(ctx, []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if evicted := stats.PodsEvicted.Load(); evicted != 1 {
+		t.Fatalf("Expected the eviction to be counted, got: %d", evicted)
+	}
+}
+
+func TestEvictIdlePods_WaitsForJobDeletionToCascade(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	jobController := job.NewJobController(kubeClient, logger).WithPropagationPolicy(metav1.DeletePropagationBackground)
+	controller := NewPodController(kubeClient, nil, jobController, time.Second, logger)
+	fakeClock := testingclock.NewFakePassiveClock(time.Now())
+	controller.evictions = newEvictionTracker(fakeClock)
+	ctx, stats := metrics.WithReconcileStats(context.TODO())
+
+	if err := controller.EvictIdlePods(ctx, []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if _, err := kubeClient.BatchV1().Jobs("agents").Get(context.TODO(), "agent-job", metav1.GetOptions{}); err == nil {
+		t.Fatalf("Expected the job to be deleted")
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected the pod to be left to the garbage collector, got: %v", err)
+	}
+
+	// the fake clientset has no garbage collector, so the cascade never arrives
+	fakeClock.SetTime(fakeClock.Now().Add(jobCascadeTimeout))
+	if err := controller.EvictIdlePods(ctx, []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err == nil {
+		t.Fatalf("Expected the pod to be deleted once the cascade timed out")
+	}
+	if evicted := stats.PodsEvicted.Load(); evicted != 1 {
+		t.Fatalf("Expected the eviction to be counted once, got: %d", evicted)
+	}
+}
+
+func TestEvictIdlePods_DeletesPodOfParallelIndexedJob(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pod, agentJob := newEvictablePod()
+	agentJob.Spec.CompletionMode = to.Ptr(batchv1.IndexedCompletion)
+	agentJob.Spec.Parallelism = to.Ptr(int32(2))
+	kubeClient := fake.NewSimpleClientset(pod, agentJob)
+	jobController := job.NewJobController(kubeClient, logger).WithPropagationPolicy(metav1.DeletePropagationBackground)
+	controller := NewPodController(kubeClient, nil, jobController, time.Second, logger)
+
+	if err := controller.EvictIdlePods(context.TODO(), []corev1.Pod{*pod}, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if _, err := kubeClient.BatchV1().Jobs("agents").Get(context.TODO(), "agent-job", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected the indexed job to be kept for its other agents, got: %v", err)
+	}
+	if _, err := kubeClient.CoreV1().Pods("agents").Get(context.TODO(), "agent-pod", metav1.GetOptions{}); err == nil {
+		t.Fatalf("Expected the pod to be deleted right away")
+	}
+}
+
+func TestGetSafeToEvictPods_Filtering(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	newPod := func(name, namespace string, labels map[string]string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	busy := map[string]string{"busy": "true", "job": "running"}
+	// the fake clientset answers every log request with "fake logs"
+	for _, tc := range []struct {
+		name     string
+		spec     safev1.SafeEvictSpec
+		expected []string
+	}{
+		{
+			name:     "idle running pods of the monitored namespaces",
+			spec:     safev1.SafeEvictSpec{Namespaces: []string{"agents"}, LabelSelector: busy, LastLogLines: []string{"fake logs"}},
+			expected: []string{"idle", "partially-labelled"},
+		},
+		{
+			name:     "every monitored namespace",
+			spec:     safev1.SafeEvictSpec{Namespaces: []string{"agents", "other"}, LabelSelector: busy, LastLogLines: []string{"fake logs"}},
+			expected: []string{"elsewhere", "idle", "partially-labelled"},
+		},
+		{
+			name:     "pods with any of the busy labels are skipped by a single label selector",
+			spec:     safev1.SafeEvictSpec{Namespaces: []string{"agents"}, LabelSelector: map[string]string{"busy": "true"}, LastLogLines: []string{"fake logs"}},
+			expected: []string{"idle"},
+		},
+		{
+			name:     "an empty label selector matches every pod",
+			spec:     safev1.SafeEvictSpec{Namespaces: []string{"agents"}, LastLogLines: []string{"fake logs"}},
+			expected: nil,
+		},
+		{
+			name:     "any of the last log lines matches",
+			spec:     safev1.SafeEvictSpec{Namespaces: []string{"agents"}, LabelSelector: busy, LastLogLines: []string{"Listening for Jobs", "logs"}},
+			expected: []string{"idle", "partially-labelled"},
+		},
+		{
+			name:     "logs ending differently",
+			spec:     safev1.SafeEvictSpec{Namespaces: []string{"agents"}, LabelSelector: busy, LastLogLines: []string{"Listening for Jobs"}},
+			expected: nil,
+		},
+		{
+			name:     "no monitored namespace",
+			spec:     safev1.SafeEvictSpec{LabelSelector: busy, LastLogLines: []string{"fake logs"}},
+			expected: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset(
+				newPod("idle", "agents", nil, corev1.PodRunning),
+				newPod("labelled", "agents", busy, corev1.PodRunning),
+				newPod("partially-labelled", "agents", map[string]string{"busy": "true"}, corev1.PodRunning),
+				newPod("pending", "agents", nil, corev1.PodPending),
+				newPod("succeeded", "agents", nil, corev1.PodSucceeded),
+				newPod("elsewhere", "other", nil, corev1.PodRunning),
+			)
+			controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+			pods, err := controller.GetSafeToEvictPods(context.TODO(), tc.spec)
+			if err != nil {
+				t.Fatalf("GetSafeToEvictPods failed: %v", err)
+			}
+			var names []string
+			for _, pod := range pods {
+				names = append(names, pod.Name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tc.expected) {
+				t.Fatalf("Expected %v, got: %v", tc.expected, names)
+			}
+		})
+	}
+}
+
+func TestGetPodsPool_EnvResolution(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	optional := true
+	configMapRef := func(name string, optional *bool) *corev1.ConfigMapEnvSource {
+		return &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Optional: optional}
+	}
+	for _, tc := range []struct {
+		name      string
+		container corev1.Container
+		expected  string
+		expectErr bool
+	}{
+		{
+			name: "configmap key reference",
+			container: corev1.Container{Env: []corev1.EnvVar{{
+				Name: "AZP_POOL",
+				ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "agent-config"},
+					Key:                  "pool",
+				}},
+			}}},
+			expected: "referenced-pool",
+		},
+		{
+			name: "env takes precedence over envFrom",
+			container: corev1.Container{
+				Env:     []corev1.EnvVar{{Name: "AZP_POOL", Value: "literal-pool"}},
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: configMapRef("agent-config", nil)}},
+			},
+			expected: "literal-pool",
+		},
+		{
+			name: "later envFrom sources override earlier ones",
+			container: corev1.Container{EnvFrom: []corev1.EnvFromSource{
+				{ConfigMapRef: configMapRef("agent-config", nil)},
+				{ConfigMapRef: configMapRef("override-config", nil)},
+			}},
+			expected: "override-pool",
+		},
+		{
+			name: "envFrom prefix",
+			container: corev1.Container{EnvFrom: []corev1.EnvFromSource{
+				{ConfigMapRef: configMapRef("prefixed-config", nil), Prefix: "AZP_"},
+			}},
+			expected: "prefixed-pool",
+		},
+		{
+			name: "optional missing configmap is skipped",
+			container: corev1.Container{EnvFrom: []corev1.EnvFromSource{
+				{ConfigMapRef: configMapRef("agent-config", nil)},
+				{ConfigMapRef: configMapRef("missing-config", &optional)},
+			}},
+			expected: "configmap-pool",
+		},
+		{
+			name: "required missing configmap fails",
+			container: corev1.Container{EnvFrom: []corev1.EnvFromSource{
+				{ConfigMapRef: configMapRef("missing-config", nil)},
+			}},
+			expectErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.container.Name = "agent"
+			kubeClient := fake.NewSimpleClientset(
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "agent-config", Namespace: "agents"},
+					Data:       map[string]string{"AZP_POOL": "configmap-pool", "pool": "referenced-pool"},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "override-config", Namespace: "agents"},
+					Data:       map[string]string{"AZP_POOL": "override-pool"},
+				},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "prefixed-config", Namespace: "agents"},
+					Data:       map[string]string{"POOL": "prefixed-pool"},
+				},
+				newAgentPod(nil, tc.container),
+			)
+			controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+			poolName, err := controller.getPodsPool(context.TODO(), "agent-pod", "agents")
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expected getPodsPool to fail, got pool: %s", poolName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getPodsPool failed: %v", err)
+			}
+			if poolName != tc.expected {
+				t.Fatalf("Expected pool '%s', got: %s", tc.expected, poolName)
+			}
+		})
+	}
+}
+
+func newEvictablePods(names ...string) ([]corev1.Pod, []runtime.Object) {
+	var pods []corev1.Pod
+	var objects []runtime.Object
+	for _, name := range names {
+		pod, agentJob := newEvictablePod()
+		pod.Name = name
+		pod.UID = types.UID(name + "-uid")
+		pod.OwnerReferences[0].Name = name + "-job"
+		agentJob.Name = name + "-job"
+		pods = append(pods, *pod)
+		objects = append(objects, pod, agentJob)
+	}
+	return pods, objects
+}
+
+func deletedPods(kubeClient *fake.Clientset) []string {
+	var names []string
+	for _, action := range kubeClient.Actions() {
+		if deleteAction, ok := action.(k8stesting.DeleteAction); ok && action.GetResource().Resource == "pods" {
+			names = append(names, deleteAction.GetName())
+		}
+	}
+	return names
+}
+
+func TestEvictIdlePods_EvictsPodsInOrder(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pods, objects := newEvictablePods("agent-c", "agent-a", "agent-b")
+	kubeClient := fake.NewSimpleClientset(objects...)
+	adoController := &fakeAzureDevopsController{enabledState: map[string]bool{}}
+	controller := NewPodController(kubeClient, adoController, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	if err := controller.EvictIdlePods(context.TODO(), pods, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if deleted := deletedPods(kubeClient); !slices.Equal(deleted, []string{"agent-c", "agent-a", "agent-b"}) {
+		t.Fatalf("Expected the pods to be evicted in the given order, got: %v", deleted)
+	}
+}
+
+func TestEvictIdlePods_StopsAndRollsBackAtFailingPod(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	pods, objects := newEvictablePods("agent-a", "agent-b", "agent-c")
+	kubeClient := fake.NewSimpleClientset(objects...)
+	adoController := &fakeAzureDevopsController{
+		removeErrs:   map[string]error{"agent-b": errors.New("mock remove error")},
+		enabledState: map[string]bool{},
+	}
+	controller := NewPodController(kubeClient, adoController, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	if err := controller.EvictIdlePods(context.TODO(), pods, safev1.SafeEvictSpec{}); err == nil {
+		t.Fatalf("Expected eviction to fail, got nil")
+	}
+	if deleted := deletedPods(kubeClient); !slices.Equal(deleted, []string{"agent-a"}) {
+		t.Fatalf("Expected only the pod before the failing one to be evicted, got: %v", deleted)
+	}
+	if !adoController.enabledState["agent-b"] {
+		t.Fatalf("Expected the agent of the failing pod to be re-enabled")
+	}
+	if _, touched := adoController.enabledState["agent-c"]; touched {
+		t.Fatalf("Expected the agent after the failing pod to be left alone")
+	}
+
+	// the next reconcile resumes with the failing pod, the evicted one is skipped
+	delete(adoController.removeErrs, "agent-b")
+	if err := controller.EvictIdlePods(context.TODO(), pods, safev1.SafeEvictSpec{}); err != nil {
+		t.Fatalf("EvictIdlePods failed: %v", err)
+	}
+	if deleted := deletedPods(kubeClient); !slices.Equal(deleted, []string{"agent-a", "agent-b", "agent-c"}) {
+		t.Fatalf("Expected the remaining pods to be evicted in order, got: %v", deleted)
+	}
+}
+
+func TestGetSafeToEvictPods_SkipsPodsYoungerThanMinPodAge(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	now := time.Now()
+	newPod := func(name string, created time.Time, started *time.Time) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "agents", CreationTimestamp: metav1.NewTime(created)},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		if started != nil {
+			pod.Status.StartTime = &metav1.Time{Time: *started}
+		}
+		return pod
+	}
+	startedLate := now.Add(-time.Minute)
+	crashLooping := newPod("young-crash-looping", now.Add(-time.Minute), nil)
+	crashLooping.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "agent",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+	}}
+	kubeClient := fake.NewSimpleClientset(
+		newPod("old", now.Add(-time.Hour), nil),
+		newPod("young", now.Add(-time.Minute), nil),
+		// the pod was scheduled long ago, but its containers only started recently, e.g. after a slow image pull
+		newPod("started-late", now.Add(-time.Hour), &startedLate),
+		crashLooping,
+	)
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+	controller.clock = testingclock.NewFakePassiveClock(now)
+	spec := safev1.SafeEvictSpec{
+		Namespaces:    []string{"agents"},
+		LabelSelector: map[string]string{"busy": "true"},
+		LastLogLines:  []string{"fake logs"},
+		Eviction:      &safev1.EvictionSpec{MinPodAge: &metav1.Duration{Duration: 5 * time.Minute}},
+	}
+
+	pods, err := controller.GetSafeToEvictPods(context.TODO(), spec)
+	if err != nil {
+		t.Fatalf("GetSafeToEvictPods failed: %v", err)
+	}
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"old", "young-crash-looping"}) {
+		t.Fatalf("Expected the old and the crash-looping pod, got: %v", names)
+	}
+
+	// the young pods are evaluated once they are old enough
+	controller.clock = testingclock.NewFakePassiveClock(now.Add(5 * time.Minute))
+	pods, err = controller.GetSafeToEvictPods(context.TODO(), spec)
+	if err != nil {
+		t.Fatalf("GetSafeToEvictPods failed: %v", err)
+	}
+	if len(pods) != 4 {
+		t.Fatalf("Expected every pod once it is old enough, got %d", len(pods))
+	}
+}
+
+func TestDiscoverAgentPools(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	agentPod := func(name, nodeName, poolName string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "agents", UID: types.UID(name)},
+			Spec: corev1.PodSpec{NodeName: nodeName, Containers: []corev1.Container{{
+				Name: "agent",
+				Env: []corev1.EnvVar{{Name: "AZP_POOL", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: poolName},
+					Key:                  "pool",
+				}}}},
+			}}},
+		}
+	}
+	node := func(name, nodePoolName string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{nodePoolLabel: nodePoolName}}}
+	}
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "linux", Namespace: "agents"}, Data: map[string]string{"pool": "linux-pool"}}
+	kubeClient := fake.NewSimpleClientset(
+		configMap,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "gpu", Namespace: "agents"}, Data: map[string]string{"pool": "gpu-pool"}},
+		node("node-1", "agents1"), node("node-2", "agents2"),
+		agentPod("agent-1", "node-1", "linux"), agentPod("agent-2", "node-2", "linux"), agentPod("agent-3", "node-2", "gpu"),
+		agentPod("pending", "", "linux"),
+	)
+	controller := NewPodController(kubeClient, nil, job.NewJobController(kubeClient, logger), time.Second, logger)
+
+	agentPools, err := controller.DiscoverAgentPools(context.TODO(), []string{"agents"})
+	if err != nil {
+		t.Fatalf("DiscoverAgentPools failed: %v", err)
+	}
+	expected := map[string][]string{"agents1": {"linux-pool"}, "agents2": {"gpu-pool", "linux-pool"}}
+	if len(agentPools) != len(expected) || !slices.Equal(agentPools["agents1"], expected["agents1"]) || !slices.Equal(agentPools["agents2"], expected["agents2"]) {
+		t.Fatalf("expected agent pools %v, got %v", expected, agentPools)
+	}
+
+	// the environment of a running pod does not change with its ConfigMap
+	configMap.Data["pool"] = "moved-pool"
+	if _, err := kubeClient.CoreV1().ConfigMaps("agents").Update(context.TODO(), configMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	poolName, err := controller.getPodsPool(context.TODO(), "agent-1", "agents")
+	if err != nil || poolName != "linux-pool" {
+		t.Errorf("expected the cached pool linux-pool, got %q, %v", poolName, err)
+	}
+}
+
+func TestGetAgentHealth(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	now := time.Now()
+	agentPod := func(name, nodeName string, ready bool, started time.Time) *corev1.Pod {
+		readyStatus := corev1.ConditionFalse
+		if ready {
+			readyStatus = corev1.ConditionTrue
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "agents", UID: types.UID(name), Annotations: map[string]string{AgentPoolAnnotation: "pool"}},
+			Spec:       corev1.PodSpec{NodeName: nodeName},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				StartTime:  &metav1.Time{Time: started},
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: readyStatus}},
+			},
+		}
+	}
+	node := func(name, nodePoolName string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{nodePoolLabel: nodePoolName}}}
+	}
+	started := now.Add(-time.Hour)
+	kubeClient := fake.NewSimpleClientset(
+		node("upgraded-0", "upgraded"), node("temporary-0", "tmp"),
+		agentPod("ready", "upgraded-0", true, started), agentPod("not-ready", "upgraded-0", false, started),
+		agentPod("young", "upgraded-0", false, now), agentPod("elsewhere", "temporary-0", false, started),
+	)
+	agentProvider := testingfake.NewAgentProvider()
+	agentProvider.AddAgent("pool", azuredevops.Agent{Name: "ready", HostName: "ready"})
+	controller := NewPodController(kubeClient, agentProvider, job.NewJobController(kubeClient, logger), time.Second, logger)
+	controller.clock = testingclock.NewFakePassiveClock(now)
+	spec := safev1.SafeEvictSpec{Namespaces: []string{"agents"}}
+
+	health, err := controller.GetAgentHealth(context.TODO(), spec, []string{"upgraded"}, time.Minute)
+	if err != nil {
+		t.Fatalf("GetAgentHealth failed: %v", err)
+	}
+	if health != (AgentHealth{Agents: 2, Ready: 1, Online: 1}) {
+		t.Errorf("expected 2 agents with 1 ready and 1 online, got %+v", health)
+	}
+
+	spec.AgentProvider = safev1.AgentProviderNone
+	health, err = controller.GetAgentHealth(context.TODO(), spec, []string{"upgraded"}, time.Minute)
+	if err != nil {
+		t.Fatalf("GetAgentHealth failed: %v", err)
+	}
+	if health.Online != -1 {
+		t.Errorf("expected the online agents not to be counted without agent provider, got %d", health.Online)
+	}
+}
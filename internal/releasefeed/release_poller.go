@@ -0,0 +1,166 @@
+package releasefeed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/egress"
+)
+
+// DefaultFeedURL lists the releases of AKS, their notes announce the new node images and the CVEs fixed by them
+const DefaultFeedURL = "https://api.github.com/repos/Azure/AKS/releases"
+
+var (
+	cvePattern       = regexp.MustCompile(`CVE-\d{4}-\d{4,}`)
+	nodeImagePattern = regexp.MustCompile(`(?i)node ?image|VHD`)
+)
+
+// release is the subset of a GitHub release which the poller uses
+type release struct {
+	TagName     string    `json:"tag_name"`
+	PublishedAt time.Time `json:"published_at"`
+	Body        string    `json:"body"`
+}
+
+// Poller polls the AKS release feed and requests the check of every SafeEvict with the check-now annotation as soon
+// as a release ships a node image with CVE fixes, instead of waiting for the upgrade frequency. Whether the new image
+// is available for the SKU of a nodepool is decided by the reconcile, which compares the nodepool with its upgrade
+// profile.
+type Poller struct {
+	httpClient egress.Doer
+	url        string
+	interval   time.Duration
+	client     client.Client
+	logger     *zap.Logger
+	// lastPublished is the publish time of the newest release seen, the releases before the first poll are ignored
+	lastPublished time.Time
+}
+
+func NewPoller(httpClient egress.Doer, url string, interval time.Duration, client client.Client, logger *zap.Logger) *Poller {
+	return &Poller{
+		httpClient: httpClient,
+		url:        url,
+		interval:   interval,
+		client:     client,
+		logger:     logger,
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, only the leader requests checks
+func (p *Poller) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable, it polls the feed until the context is cancelled. A failing poll is retried at
+// the next interval.
+func (p *Poller) Start(ctx context.Context) error {
+	p.logger.Info("Polling the AKS release feed", zap.String("url", p.url), zap.Duration("interval", p.interval))
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.poll(ctx); err != nil {
+			p.logger.Error("Failed to poll the AKS release feed", zap.Error(err), zap.String("url", p.url))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll fetches the releases and requests the check of every SafeEvict when a new release fixes CVEs in a node image
+func (p *Poller) poll(ctx context.Context) error {
+	releases, err := p.fetchReleases(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the releases published before the first poll were out before the controller started
+	first := p.lastPublished.IsZero()
+	newest := p.lastPublished
+	var securityRelease *release
+	for i := range releases {
+		release := &releases[i]
+		if !release.PublishedAt.After(p.lastPublished) {
+			continue
+		}
+		if release.PublishedAt.After(newest) {
+			newest = release.PublishedAt
+		}
+		if first || securityRelease != nil {
+			continue
+		}
+		if cves := securityFixes(release.Body); len(cves) > 0 {
+			p.logger.Info("AKS release fixes CVEs in a node image", zap.String("release", release.TagName), zap.Strings("cves", cves))
+			securityRelease = release
+		}
+	}
+	p.lastPublished = newest
+	if securityRelease == nil {
+		return nil
+	}
+	return p.requestChecks(ctx, securityRelease.TagName)
+}
+
+func (p *Poller) fetchReleases(ctx context.Context) ([]release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the request of the release feed: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the release feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("release feed returned status %d: %s", resp.StatusCode, string(body))
+	}
+	var releases []release
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("failed to decode the release feed: %w", err)
+	}
+	return releases, nil
+}
+
+// requestChecks sets the check-now annotation on every SafeEvict, the reconcile checks the nodepools right away
+func (p *Poller) requestChecks(ctx context.Context, releaseName string) error {
+	safeEvicts := &updatev1.SafeEvictList{}
+	if err := p.client.List(ctx, safeEvicts); err != nil {
+		return fmt.Errorf("failed to list SafeEvicts: %w", err)
+	}
+	var errs []error
+	for i := range safeEvicts.Items {
+		safeEvict := &safeEvicts.Items[i]
+		p.logger.Info("Requesting the check of the nodepools for the security release", zap.String("release", releaseName), zap.String("namespace", safeEvict.Namespace), zap.String("name", safeEvict.Name))
+		original := safeEvict.DeepCopy()
+		if safeEvict.Annotations == nil {
+			safeEvict.Annotations = map[string]string{}
+		}
+		safeEvict.Annotations[updatev1.CheckNowAnnotation] = releaseName
+		if err := p.client.Patch(ctx, safeEvict, client.MergeFrom(original)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to annotate SafeEvict '%s/%s': %w", safeEvict.Namespace, safeEvict.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// securityFixes returns the CVEs fixed by the release, a release which does not ship a node image has none
+func securityFixes(body string) []string {
+	if !nodeImagePattern.MatchString(body) {
+		return nil
+	}
+	return cvePattern.FindAllString(body, -1)
+}
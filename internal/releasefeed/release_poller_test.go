@@ -0,0 +1,159 @@
+package releasefeed
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+// fakeFeed serves the releases as the GitHub API does
+type fakeFeed struct {
+	releases []release
+	status   int
+}
+
+func (f *fakeFeed) Do(req *http.Request) (*http.Response, error) {
+	status := f.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body, err := json.Marshal(f.releases)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(string(body)))}, nil
+}
+
+func newTestPoller(t *testing.T, feed *fakeFeed) *Poller {
+	scheme := runtime.NewScheme()
+	if err := updatev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add scheme: %v", err)
+	}
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&updatev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "default"}},
+		&updatev1.SafeEvict{ObjectMeta: metav1.ObjectMeta{Name: "second", Namespace: "agents"}},
+	).Build()
+	return NewPoller(feed, DefaultFeedURL, time.Minute, client, zaptest.NewLogger(t))
+}
+
+// requestedChecks returns the names of the SafeEvicts with the check-now annotation
+func requestedChecks(t *testing.T, poller *Poller) map[string]string {
+	safeEvicts := &updatev1.SafeEvictList{}
+	if err := poller.client.List(context.Background(), safeEvicts); err != nil {
+		t.Fatalf("failed to list SafeEvicts: %v", err)
+	}
+	checks := map[string]string{}
+	for _, safeEvict := range safeEvicts.Items {
+		if release, found := safeEvict.Annotations[updatev1.CheckNowAnnotation]; found {
+			checks[safeEvict.Name] = release
+		}
+	}
+	return checks
+}
+
+func clearChecks(t *testing.T, poller *Poller) {
+	for _, key := range []client.ObjectKey{{Namespace: "default", Name: "first"}, {Namespace: "agents", Name: "second"}} {
+		safeEvict := &updatev1.SafeEvict{}
+		if err := poller.client.Get(context.Background(), key, safeEvict); err != nil {
+			t.Fatalf("failed to get SafeEvict: %v", err)
+		}
+		delete(safeEvict.Annotations, updatev1.CheckNowAnnotation)
+		if err := poller.client.Update(context.Background(), safeEvict); err != nil {
+			t.Fatalf("failed to update SafeEvict: %v", err)
+		}
+	}
+}
+
+func TestPoll_IgnoresReleasesBeforeFirstPoll(t *testing.T) {
+	published := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	feed := &fakeFeed{releases: []release{{TagName: "2025-05-01", PublishedAt: published, Body: "Node image fixes CVE-2025-1234"}}}
+	poller := newTestPoller(t, feed)
+
+	if err := poller.poll(context.Background()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	if checks := requestedChecks(t, poller); len(checks) != 0 {
+		t.Errorf("expected no check for the releases before the first poll, got %v", checks)
+	}
+	if !poller.lastPublished.Equal(published) {
+		t.Errorf("expected the newest release to be recorded, got %v", poller.lastPublished)
+	}
+}
+
+func TestPoll_RequestsChecksForSecurityRelease(t *testing.T) {
+	published := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	feed := &fakeFeed{releases: []release{{TagName: "2025-05-01", PublishedAt: published, Body: "Features"}}}
+	poller := newTestPoller(t, feed)
+	if err := poller.poll(context.Background()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	feed.releases = append([]release{{
+		TagName:     "2025-05-08",
+		PublishedAt: published.Add(7 * 24 * time.Hour),
+		Body:        "Linux node image AKSUbuntu-2204gen2containerd-202505.08.0 fixes CVE-2025-21756 and CVE-2025-37750",
+	}}, feed.releases...)
+	if err := poller.poll(context.Background()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	checks := requestedChecks(t, poller)
+	if checks["first"] != "2025-05-08" || checks["second"] != "2025-05-08" {
+		t.Errorf("expected checks of first and second for the release, got %v", checks)
+	}
+
+	// the same release does not request the checks again
+	clearChecks(t, poller)
+	if err := poller.poll(context.Background()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	if checks := requestedChecks(t, poller); len(checks) != 0 {
+		t.Errorf("expected no check for a release seen before, got %v", checks)
+	}
+}
+
+func TestPoll_IgnoresReleaseWithoutSecurityFixes(t *testing.T) {
+	published := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	feed := &fakeFeed{}
+	poller := newTestPoller(t, feed)
+	poller.lastPublished = published
+
+	feed.releases = []release{
+		{TagName: "2025-05-08", PublishedAt: published.Add(time.Hour), Body: "Node image update with bug fixes"},
+		{TagName: "2025-05-09", PublishedAt: published.Add(2 * time.Hour), Body: "Kubernetes 1.33 fixes CVE-2025-1234 in the API server"},
+	}
+	if err := poller.poll(context.Background()); err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+
+	if checks := requestedChecks(t, poller); len(checks) != 0 {
+		t.Errorf("expected no check, got %v", checks)
+	}
+	if !poller.lastPublished.Equal(published.Add(2 * time.Hour)) {
+		t.Errorf("expected the newest release to be recorded, got %v", poller.lastPublished)
+	}
+}
+
+func TestPoll_FeedError(t *testing.T) {
+	poller := newTestPoller(t, &fakeFeed{status: http.StatusForbidden})
+
+	if err := poller.poll(context.Background()); err == nil {
+		t.Fatal("expected an error for a failing feed")
+	}
+	if !poller.lastPublished.IsZero() {
+		t.Errorf("expected no release to be recorded, got %v", poller.lastPublished)
+	}
+}
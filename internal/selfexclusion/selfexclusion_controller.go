@@ -0,0 +1,110 @@
+package selfexclusion
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// PodNameEnvName is the environment variable which holds the name of the controller pod, set with the downward API
+	PodNameEnvName = "POD_NAME"
+	// PodNamespaceEnvName is the environment variable which holds the namespace of the controller pod, set with the downward API
+	PodNamespaceEnvName = "POD_NAMESPACE"
+	// NodeNameEnvName is the environment variable which holds the node of the controller pod, set with the downward API
+	NodeNameEnvName = "NODE_NAME"
+	// agentPoolLabel is the node label which holds the name of the AKS nodepool
+	agentPoolLabel = "agentpool"
+)
+
+// SelfExclusionController keeps the controller from interrupting a rotation by cordoning, evicting or reimaging the node it runs on
+type SelfExclusionController struct {
+	kubeClient   kubernetes.Interface
+	podName      string
+	podNamespace string
+	nodeName     string
+	logger       *zap.Logger
+}
+
+// NewSelfExclusionController creates a SelfExclusionController. When podName or nodeName is empty, e.g. the controller runs
+// outside of the cluster, the controller has no own node and pod to protect.
+func NewSelfExclusionController(kubeClient kubernetes.Interface, podName, podNamespace, nodeName string, logger *zap.Logger) *SelfExclusionController {
+	return &SelfExclusionController{
+		kubeClient:   kubeClient,
+		podName:      podName,
+		podNamespace: podNamespace,
+		nodeName:     nodeName,
+		logger:       logger,
+	}
+}
+
+// GetOwnNodePool returns the nodepool the controller runs on, or an empty string when it is unknown
+func (c *SelfExclusionController) GetOwnNodePool(ctx context.Context) (string, error) {
+	if c.nodeName == "" {
+		return "", nil
+	}
+	node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		c.logger.Error("Failed to get the node of the controller", zap.Error(err), zap.String("nodeName", c.nodeName))
+		return "", fmt.Errorf("failed to get node '%s' of the controller: %w", c.nodeName, err)
+	}
+	return node.Labels[agentPoolLabel], nil
+}
+
+// ExcludeOwnPod removes the controller pod from the pods which are going to be evicted
+func (c *SelfExclusionController) ExcludeOwnPod(pods []corev1.Pod) []corev1.Pod {
+	if c.podName == "" {
+		return pods
+	}
+	filteredPods := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if pod.Name == c.podName && pod.Namespace == c.podNamespace {
+			c.logger.Warn("Controller pod matches the SafeEvict, it is excluded from the eviction", zap.String("podName", pod.Name), zap.String("namespace", pod.Namespace))
+			continue
+		}
+		filteredPods = append(filteredPods, pod)
+	}
+	return filteredPods
+}
+
+// RescheduleFromNodePool deletes the controller pod when it runs on the given nodepool, so its Deployment starts it on
+// another node before the nodepool is upgraded or removed. The nodepool has to be cordoned already. It returns true when the
+// controller is being rescheduled and the current reconcile should stop.
+func (c *SelfExclusionController) RescheduleFromNodePool(ctx context.Context, nodePoolName string) (bool, error) {
+	ownNodePool, err := c.GetOwnNodePool(ctx)
+	if err != nil {
+		return false, err
+	}
+	if c.podName == "" || ownNodePool != nodePoolName {
+		return false, nil
+	}
+
+	c.logger.Info("Controller runs on the nodepool which is about to be upgraded, rescheduling it first", zap.String("nodePoolName", nodePoolName), zap.String("nodeName", c.nodeName), zap.String("podName", c.podName))
+	return c.deleteOwnPod(ctx)
+}
+
+// RescheduleFromNode deletes the controller pod when it runs on the given node, so its Deployment starts it on another
+// node before the node is reimaged. Only the node has to be cordoned, the other nodes of its nodepool stay schedulable and
+// a controller running on them is left alone. It returns true when the controller is being rescheduled and the current
+// reconcile should stop.
+func (c *SelfExclusionController) RescheduleFromNode(ctx context.Context, nodeName string) (bool, error) {
+	if c.podName == "" || c.nodeName != nodeName {
+		return false, nil
+	}
+
+	c.logger.Info("Controller runs on the node which is about to be reimaged, rescheduling it first", zap.String("nodeName", c.nodeName), zap.String("podName", c.podName))
+	return c.deleteOwnPod(ctx)
+}
+
+func (c *SelfExclusionController) deleteOwnPod(ctx context.Context) (bool, error) {
+	err := c.kubeClient.CoreV1().Pods(c.podNamespace).Delete(ctx, c.podName, metav1.DeleteOptions{})
+	if err != nil {
+		c.logger.Error("Failed to delete the controller pod", zap.Error(err), zap.String("podName", c.podName), zap.String("namespace", c.podNamespace))
+		return false, fmt.Errorf("failed to delete controller pod '%s' in namespace %s: %w", c.podName, c.podNamespace, err)
+	}
+	return true, nil
+}
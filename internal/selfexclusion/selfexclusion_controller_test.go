@@ -0,0 +1,105 @@
+package selfexclusion
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newControllerObjects() (*corev1.Node, *corev1.Pod) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"agentpool": "system"}}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "controller", Namespace: "node-updater-system"}}
+	return node, pod
+}
+
+func TestRescheduleFromNodePool_OwnNodePool(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	node, pod := newControllerObjects()
+	kubeClient := fake.NewSimpleClientset(node, pod)
+	controller := NewSelfExclusionController(kubeClient, "controller", "node-updater-system", "node-1", logger)
+
+	rescheduled, err := controller.RescheduleFromNodePool(context.TODO(), "system")
+	if err != nil {
+		t.Fatalf("RescheduleFromNodePool failed: %v", err)
+	}
+	if !rescheduled {
+		t.Fatalf("Expected controller to be rescheduled")
+	}
+	if _, err := kubeClient.CoreV1().Pods("node-updater-system").Get(context.TODO(), "controller", metav1.GetOptions{}); err == nil {
+		t.Fatalf("Expected controller pod to be deleted")
+	}
+}
+
+func TestRescheduleFromNodePool_OtherNodePool(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	node, pod := newControllerObjects()
+	kubeClient := fake.NewSimpleClientset(node, pod)
+	controller := NewSelfExclusionController(kubeClient, "controller", "node-updater-system", "node-1", logger)
+
+	rescheduled, err := controller.RescheduleFromNodePool(context.TODO(), "agents")
+	if err != nil {
+		t.Fatalf("RescheduleFromNodePool failed: %v", err)
+	}
+	if rescheduled {
+		t.Fatalf("Expected controller not to be rescheduled")
+	}
+}
+
+func TestRescheduleFromNodePool_OutsideOfCluster(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	controller := NewSelfExclusionController(fake.NewSimpleClientset(), "", "", "", logger)
+
+	rescheduled, err := controller.RescheduleFromNodePool(context.TODO(), "system")
+	if err != nil || rescheduled {
+		t.Fatalf("Expected no rescheduling outside of the cluster, got: %t, %v", rescheduled, err)
+	}
+}
+
+func TestExcludeOwnPod(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	controller := NewSelfExclusionController(fake.NewSimpleClientset(), "controller", "node-updater-system", "node-1", logger)
+
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "controller", Namespace: "node-updater-system"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "controller", Namespace: "agents"}},
+	}
+	filteredPods := controller.ExcludeOwnPod(pods)
+	if len(filteredPods) != 1 || filteredPods[0].Namespace != "agents" {
+		t.Fatalf("Expected only the controller pod to be excluded, got: %v", filteredPods)
+	}
+}
+
+func TestRescheduleFromNode(t *testing.T) {
+	tests := []struct {
+		name        string
+		nodeName    string
+		rescheduled bool
+	}{
+		{"own node", "node-1", true},
+		{"other node of the same nodepool", "node-2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zaptest.NewLogger(t)
+			node, pod := newControllerObjects()
+			kubeClient := fake.NewSimpleClientset(node, pod)
+			controller := NewSelfExclusionController(kubeClient, "controller", "node-updater-system", "node-1", logger)
+
+			rescheduled, err := controller.RescheduleFromNode(context.TODO(), tt.nodeName)
+			if err != nil {
+				t.Fatalf("RescheduleFromNode failed: %v", err)
+			}
+			if rescheduled != tt.rescheduled {
+				t.Fatalf("Expected rescheduled %t, got %t", tt.rescheduled, rescheduled)
+			}
+			_, err = kubeClient.CoreV1().Pods("node-updater-system").Get(context.TODO(), "controller", metav1.GetOptions{})
+			if deleted := err != nil; deleted != tt.rescheduled {
+				t.Fatalf("Expected controller pod deleted %t, got %v", tt.rescheduled, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package utilization
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSamples caps the weight of the past in the average of an hour, so the history follows a changing usage
+	maxSamples = 30
+	// lastSampleKey holds the time of the last sample in the data of the history
+	lastSampleKey = "lastSample"
+)
+
+// History is the average number of busy agents in every hour of the day (UTC). It is stored in the data of a
+// ConfigMap, one key per hour holding "<average>/<samples>".
+type History struct {
+	Average    [24]float64
+	Samples    [24]int
+	LastSample time.Time
+}
+
+// ParseHistory reads the history from the data of its ConfigMap, invalid entries are dropped
+func ParseHistory(data map[string]string) History {
+	var history History
+	for hour := range 24 {
+		average, samples, found := strings.Cut(data[hourKey(hour)], "/")
+		if !found {
+			continue
+		}
+		averageValue, err := strconv.ParseFloat(average, 64)
+		if err != nil {
+			continue
+		}
+		samplesValue, err := strconv.Atoi(samples)
+		if err != nil || samplesValue < 0 {
+			continue
+		}
+		history.Average[hour] = averageValue
+		history.Samples[hour] = min(samplesValue, maxSamples)
+	}
+	if lastSample, err := time.Parse(time.RFC3339, data[lastSampleKey]); err == nil {
+		history.LastSample = lastSample
+	}
+	return history
+}
+
+// Data returns the history as the data of its ConfigMap
+func (h History) Data() map[string]string {
+	data := map[string]string{}
+	for hour := range 24 {
+		if h.Samples[hour] == 0 {
+			continue
+		}
+		data[hourKey(hour)] = strconv.FormatFloat(h.Average[hour], 'f', 2, 64) + "/" + strconv.Itoa(h.Samples[hour])
+	}
+	if !h.LastSample.IsZero() {
+		data[lastSampleKey] = h.LastSample.UTC().Format(time.RFC3339)
+	}
+	return data
+}
+
+// Record adds the number of busy agents seen at the time to the average of its hour
+func (h *History) Record(at time.Time, busyAgents int) {
+	hour := at.UTC().Hour()
+	samples := min(h.Samples[hour]+1, maxSamples)
+	h.Average[hour] += (float64(busyAgents) - h.Average[hour]) / float64(samples)
+	h.Samples[hour] = samples
+	h.LastSample = at
+}
+
+// Complete returns true when every hour of the day has been sampled
+func (h History) Complete() bool {
+	return !slices.Contains(h.Samples[:], 0)
+}
+
+// QuietHours returns the count hours of the day with the fewest busy agents, an earlier hour wins a tie
+func (h History) QuietHours(count int) []int {
+	hours := make([]int, 24)
+	for hour := range hours {
+		hours[hour] = hour
+	}
+	slices.SortStableFunc(hours, func(a, b int) int {
+		switch {
+		case h.Average[a] < h.Average[b]:
+			return -1
+		case h.Average[a] > h.Average[b]:
+			return 1
+		}
+		return 0
+	})
+	return hours[:min(count, 24)]
+}
+
+// NextQuietTime returns the start of the next of the count quiet hours, or now when it is in one of them
+func (h History) NextQuietTime(now time.Time, count int) time.Time {
+	quietHours := h.QuietHours(count)
+	now = now.UTC()
+	if slices.Contains(quietHours, now.Hour()) {
+		return now
+	}
+	next := now.Truncate(time.Hour)
+	for range 24 {
+		next = next.Add(time.Hour)
+		if slices.Contains(quietHours, next.Hour()) {
+			return next
+		}
+	}
+	return now
+}
+
+func hourKey(hour int) string {
+	return fmt.Sprintf("%02d", hour)
+}
@@ -0,0 +1,78 @@
+package utilization
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHistory_RoundTrip(t *testing.T) {
+	var history History
+	at := time.Date(2025, 5, 1, 3, 30, 0, 0, time.UTC)
+	history.Record(at, 4)
+	history.Record(at.Add(time.Minute), 2)
+
+	parsed := ParseHistory(history.Data())
+
+	if parsed.Average[3] != 3 || parsed.Samples[3] != 2 {
+		t.Errorf("Expected an average of 3 from 2 samples at 03:00, got %v from %d", parsed.Average[3], parsed.Samples[3])
+	}
+	if !parsed.LastSample.Equal(at.Add(time.Minute)) {
+		t.Errorf("Expected the time of the last sample, got %v", parsed.LastSample)
+	}
+}
+
+func TestParseHistory_DropsInvalidEntries(t *testing.T) {
+	history := ParseHistory(map[string]string{"00": "1.5/3", "01": "busy", "02": "1/x", "lastSample": "yesterday"})
+
+	if history.Average[0] != 1.5 || history.Samples[0] != 3 {
+		t.Errorf("Expected the valid entry to be kept, got %v from %d", history.Average[0], history.Samples[0])
+	}
+	if history.Samples[1] != 0 || history.Samples[2] != 0 || !history.LastSample.IsZero() {
+		t.Error("Expected the invalid entries to be dropped")
+	}
+}
+
+func TestHistory_RecordCapsSamples(t *testing.T) {
+	var history History
+	at := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	for range maxSamples * 2 {
+		history.Record(at, 10)
+	}
+	history.Record(at, 10+maxSamples)
+
+	if history.Samples[0] != maxSamples {
+		t.Errorf("Expected the samples to be capped at %d, got %d", maxSamples, history.Samples[0])
+	}
+	if history.Average[0] != 11 {
+		t.Errorf("Expected a new sample to move the average by 1/%d of its difference, got %v", maxSamples, history.Average[0])
+	}
+}
+
+func TestHistory_NextQuietTime(t *testing.T) {
+	var history History
+	day := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	for hour := range 24 {
+		history.Record(day.Add(time.Duration(hour)*time.Hour), 10)
+	}
+	history.Record(day.Add(2*time.Hour), 0)
+	history.Record(day.Add(22*time.Hour), 0)
+
+	if !history.Complete() {
+		t.Fatal("Expected the history to be complete")
+	}
+	if quietHours := history.QuietHours(2); !slices.Equal(quietHours, []int{2, 22}) {
+		t.Errorf("Expected 02:00 and 22:00 to be the quiet hours, got %v", quietHours)
+	}
+
+	now := day.Add(2*time.Hour + 15*time.Minute)
+	if next := history.NextQuietTime(now, 2); !next.Equal(now) {
+		t.Errorf("Expected to start right away in a quiet hour, got %v", next)
+	}
+	if next := history.NextQuietTime(day.Add(5*time.Hour+time.Minute), 2); !next.Equal(day.Add(22 * time.Hour)) {
+		t.Errorf("Expected to wait until 22:00, got %v", next)
+	}
+	if next := history.NextQuietTime(day.Add(23*time.Hour), 2); !next.Equal(day.Add(26 * time.Hour)) {
+		t.Errorf("Expected to wait until 02:00 of the next day, got %v", next)
+	}
+}
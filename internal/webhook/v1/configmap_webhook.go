@@ -0,0 +1,113 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+// SetupConfigMapWebhookWithManager registers the webhook which protects the state ConfigMaps in the manager.
+func SetupConfigMapWebhookWithManager(mgr ctrl.Manager, logger *zap.Logger) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&corev1.ConfigMap{}).
+		WithValidator(NewConfigMapCustomValidator(mgr.GetClient(), logger)).
+		Complete()
+}
+
+// The webhook only receives the ConfigMaps labeled with update.norbinto/safeevict, the objectSelector is added by
+// config/webhook/configmap_webhook_patch.yaml. It fails open, so an outage of the controller never blocks ConfigMaps.
+// +kubebuilder:webhook:path=/validate--v1-configmap,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=configmaps,verbs=delete,versions=v1,name=vconfigmap-v1.kb.io,admissionReviewVersions=v1
+
+// ConfigMapCustomValidator denies the deletion of a state ConfigMap while its rotation is in progress, because the
+// ConfigMap holds the only copy of the original scaling of the outdated nodepools.
+type ConfigMapCustomValidator struct {
+	client client.Client
+	logger *zap.Logger
+}
+
+var _ webhook.CustomValidator = &ConfigMapCustomValidator{}
+
+func NewConfigMapCustomValidator(client client.Client, logger *zap.Logger) *ConfigMapCustomValidator {
+	return &ConfigMapCustomValidator{
+		client: client,
+		logger: logger,
+	}
+}
+
+// ValidateCreate implements webhook.CustomValidator, creation is not validated.
+func (v *ConfigMapCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// ValidateUpdate implements webhook.CustomValidator, updates are not validated.
+func (v *ConfigMapCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type ConfigMap.
+func (v *ConfigMapCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return nil, fmt.Errorf("expected a ConfigMap object but got %T", obj)
+	}
+	safeEvictName := configMap.Labels[updatev1.StateConfigMapLabel]
+	if safeEvictName == "" || configMap.Annotations[updatev1.ReleaseStateAnnotation] == "true" {
+		return nil, nil
+	}
+	v.logger.Debug("Validation for state ConfigMap upon deletion", zap.String("namespace", configMap.Namespace), zap.String("name", configMap.Name))
+
+	// a ConfigMap in the state namespace of the controller is labeled with the namespace of its SafeEvict, and its name
+	// is prefixed with it
+	safeEvictNamespace := configMap.Namespace
+	configMapName := configMap.Name
+	if namespace := configMap.Labels[updatev1.StateNamespaceLabel]; namespace != "" {
+		safeEvictNamespace = namespace
+		configMapName = strings.TrimPrefix(configMap.Name, namespace+".")
+	}
+	safeEvict := &updatev1.SafeEvict{}
+	err := v.client.Get(ctx, client.ObjectKey{Namespace: safeEvictNamespace, Name: safeEvictName}, safeEvict)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		v.logger.Error("Failed to get SafeEvict", zap.Error(err), zap.String("namespace", safeEvictNamespace), zap.String("name", safeEvictName))
+		return nil, fmt.Errorf("failed to get SafeEvict '%s': %w", safeEvictName, err)
+	}
+	// the garbage collector deletes the ConfigMap together with its SafeEvict
+	if safeEvict.DeletionTimestamp != nil {
+		return nil, nil
+	}
+
+	phase, found := safeEvict.GetConfigmapPhase(configMapName)
+	if !found || !phase.InProgress() {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("ConfigMap '%s' holds the original scaling of the nodepools while SafeEvict '%s' is in phase %s, annotate it with %s=true to delete it anyway",
+		configMap.Name, safeEvictName, phase, updatev1.ReleaseStateAnnotation)
+}
@@ -0,0 +1,90 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+func newStateConfigMap(safeEvict *updatev1.SafeEvict, name string, annotations map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   safeEvict.Namespace,
+			Labels:      map[string]string{updatev1.StateConfigMapLabel: safeEvict.Name},
+			Annotations: annotations,
+		},
+	}
+}
+
+// newStateNamespaceConfigMap returns a state ConfigMap kept in the state namespace of the controller
+func newStateNamespaceConfigMap(safeEvict *updatev1.SafeEvict, name string) *corev1.ConfigMap {
+	configMap := newStateConfigMap(safeEvict, safeEvict.Namespace+"."+name, nil)
+	configMap.Namespace = "node-updater-system"
+	configMap.Labels[updatev1.StateNamespaceLabel] = safeEvict.Namespace
+	return configMap
+}
+
+func TestValidateDelete_StateConfigMap(t *testing.T) {
+	safeEvict := newSafeEvict("rotation", "uid-1", "agentpool")
+	safeEvict.Status.Phase = updatev1.PhaseDraining
+	safeEvict.Status.SetClusterPhase("workload", updatev1.PhaseDetecting)
+
+	tests := []struct {
+		name      string
+		configMap *corev1.ConfigMap
+		expectErr bool
+	}{
+		{
+			name:      "rotation in progress",
+			configMap: newStateConfigMap(safeEvict, safeEvict.GetConfigmapName(), nil),
+			expectErr: true,
+		},
+		{
+			name:      "released",
+			configMap: newStateConfigMap(safeEvict, safeEvict.GetConfigmapName(), map[string]string{updatev1.ReleaseStateAnnotation: "true"}),
+		},
+		{
+			name:      "workload cluster is up to date",
+			configMap: newStateConfigMap(safeEvict, safeEvict.GetClusterConfigmapName("workload"), nil),
+		},
+		{
+			name:      "not a state ConfigMap",
+			configMap: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: safeEvict.GetConfigmapName(), Namespace: safeEvict.Namespace}},
+		},
+		{
+			name:      "rotation in progress with the state in the state namespace",
+			configMap: newStateNamespaceConfigMap(safeEvict, safeEvict.GetConfigmapName()),
+			expectErr: true,
+		},
+		{
+			name:      "workload cluster is up to date with the state in the state namespace",
+			configMap: newStateNamespaceConfigMap(safeEvict, safeEvict.GetClusterConfigmapName("workload")),
+		},
+		{
+			name:      "SafeEvict is gone",
+			configMap: newStateConfigMap(newSafeEvict("deleted", "uid-2", "agentpool"), "tmpdeleted", nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(safeEvict).Build()
+			validator := NewConfigMapCustomValidator(kubeClient, zaptest.NewLogger(t))
+
+			_, err := validator.ValidateDelete(context.TODO(), tt.configMap)
+			if tt.expectErr && err == nil {
+				t.Fatalf("Expected the deletion to be denied")
+			}
+			if !tt.expectErr && err != nil {
+				t.Fatalf("Expected the deletion to be allowed, got: %v", err)
+			}
+		})
+	}
+}
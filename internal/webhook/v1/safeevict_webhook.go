@@ -0,0 +1,195 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+// SetupSafeEvictWebhookWithManager registers the webhook for SafeEvict in the manager.
+func SetupSafeEvictWebhookWithManager(mgr ctrl.Manager, logger *zap.Logger) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&updatev1.SafeEvict{}).
+		WithValidator(NewSafeEvictCustomValidator(mgr.GetClient(), logger)).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-update-norbinto-v1-safeevict,mutating=false,failurePolicy=fail,sideEffects=None,groups=update.norbinto,resources=safeevicts,verbs=create;update,versions=v1,name=vsafeevict-v1.kb.io,admissionReviewVersions=v1
+
+// SafeEvictCustomValidator struct is responsible for validating the SafeEvict resource
+// when it is created, updated, or deleted.
+type SafeEvictCustomValidator struct {
+	client client.Client
+	// temporaryNodepoolName returns the name of the temporary nodepool of a SafeEvict, the tests replace it to collide
+	temporaryNodepoolName func(safeEvict *updatev1.SafeEvict) string
+	logger                *zap.Logger
+}
+
+var _ webhook.CustomValidator = &SafeEvictCustomValidator{}
+
+func NewSafeEvictCustomValidator(client client.Client, logger *zap.Logger) *SafeEvictCustomValidator {
+	return &SafeEvictCustomValidator{
+		client:                client,
+		temporaryNodepoolName: (*updatev1.SafeEvict).GetTemporaryNodepoolName,
+		logger:                logger,
+	}
+}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type SafeEvict.
+func (v *SafeEvictCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	safeEvict, ok := obj.(*updatev1.SafeEvict)
+	if !ok {
+		return nil, fmt.Errorf("expected a SafeEvict object but got %T", obj)
+	}
+	v.logger.Debug("Validation for SafeEvict upon creation", zap.String("namespace", safeEvict.Namespace), zap.String("name", safeEvict.Name))
+
+	return basePoolWarnings(safeEvict), v.validateSafeEvict(ctx, safeEvict)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type SafeEvict.
+func (v *SafeEvictCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	safeEvict, ok := newObj.(*updatev1.SafeEvict)
+	if !ok {
+		return nil, fmt.Errorf("expected a SafeEvict object for the newObj but got %T", newObj)
+	}
+	v.logger.Debug("Validation for SafeEvict upon update", zap.String("namespace", safeEvict.Namespace), zap.String("name", safeEvict.Name))
+
+	return basePoolWarnings(safeEvict), v.validateSafeEvict(ctx, safeEvict)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type SafeEvict.
+func (v *SafeEvictCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *SafeEvictCustomValidator) validateSafeEvict(ctx context.Context, safeEvict *updatev1.SafeEvict) error {
+	if err := validateLastLogLines(safeEvict); err != nil {
+		return err
+	}
+	if selector := safeEvict.Spec.ClusterSelector; selector != nil {
+		// an empty selector matches every Secret of the namespace, not only the kubeconfigs of the workload clusters
+		if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+			return fmt.Errorf("cluster selector must not be empty")
+		}
+		if _, err := metav1.LabelSelectorAsSelector(safeEvict.Spec.ClusterSelector); err != nil {
+			return fmt.Errorf("invalid cluster selector: %w", err)
+		}
+	}
+	if safeEvict.Spec.BlockingPodSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(safeEvict.Spec.BlockingPodSelector); err != nil {
+			return fmt.Errorf("invalid blocking pod selector: %w", err)
+		}
+	}
+	if drainSignal := safeEvict.Spec.DrainSignal; drainSignal != nil && (drainSignal.Exec == nil) == (drainSignal.HTTPGet == nil) {
+		return fmt.Errorf("drain signal must have exactly one of exec and httpGet")
+	}
+	if drainSignal := safeEvict.Spec.DrainSignal; drainSignal != nil && drainSignal.Exec != nil && safeEvict.Spec.ServiceAccountRef == nil {
+		return fmt.Errorf("drain signal exec requires a serviceAccountRef, the command is executed in the name of that ServiceAccount")
+	}
+	if watchdog := safeEvict.Spec.PendingPodWatchdog; watchdog != nil && watchdog.Action == updatev1.PendingPodActionScaleUpBackupPool && watchdog.MaxBackupPoolCount == nil {
+		return fmt.Errorf("pending pod watchdog with action %s must have a maxBackupPoolCount", updatev1.PendingPodActionScaleUpBackupPool)
+	}
+	if backupPool := safeEvict.Spec.BackupPool; backupPool != nil && backupPool.RetainFor != nil {
+		if backupPool.RetainFor.Duration < 0 {
+			return fmt.Errorf("retainFor of the backup pool must not be negative")
+		}
+		if backupPool.Shared {
+			return fmt.Errorf("retainFor is not supported for a shared backup pool")
+		}
+	}
+	if autoRollback := safeEvict.GetAutoRollback(); autoRollback != nil && safeEvict.GetBackupPoolRetention() <= 0 {
+		return fmt.Errorf("autoRollback of the backup pool requires retainFor")
+	}
+	if err := validateBackupPoolNodeLabels(safeEvict); err != nil {
+		return err
+	}
+	return v.validateTemporaryNodepoolNameIsUnique(ctx, safeEvict)
+}
+
+// basePoolWarnings warns when the base pool is not one of the monitored nodepools. The CRD checks the format of the
+// names only, whether another nodepool of that name exists is known once the temporary nodepool is created.
+func basePoolWarnings(safeEvict *updatev1.SafeEvict) admission.Warnings {
+	if len(safeEvict.Spec.Nodepools) == 0 || slices.Contains(safeEvict.Spec.Nodepools, safeEvict.Spec.BaseForBackupPool) {
+		return nil
+	}
+	return admission.Warnings{fmt.Sprintf("baseForBackupPoolName '%s' is not one of the nodepools, it must exist in the cluster", safeEvict.Spec.BaseForBackupPool)}
+}
+
+// validateLastLogLines makes sure an idle agent can be recognized by its logs without matching every pod, a blank line
+// is the suffix of any logs
+func validateLastLogLines(safeEvict *updatev1.SafeEvict) error {
+	if len(safeEvict.Spec.LastLogLines) == 0 {
+		return fmt.Errorf("lastLogLines must have at least one line")
+	}
+	for i, line := range safeEvict.Spec.LastLogLines {
+		if strings.TrimSpace(line) == "" {
+			return fmt.Errorf("lastLogLines[%d] must contain a non-whitespace character", i)
+		}
+	}
+	return nil
+}
+
+// validateBackupPoolNodeLabels makes sure the extra node labels of the temporary nodepool are valid once their
+// substitutions are replaced, AKS would refuse to create the nodepool otherwise
+func validateBackupPoolNodeLabels(safeEvict *updatev1.SafeEvict) error {
+	for key, value := range safeEvict.GetBackupPoolNodeLabels() {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid extra node label key '%s' of the backup pool: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value '%s' of extra node label '%s' of the backup pool: %s", value, key, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// validateTemporaryNodepoolNameIsUnique makes sure no other SafeEvict would create or delete the same temporary nodepool,
+// unless both of them share it
+func (v *SafeEvictCustomValidator) validateTemporaryNodepoolNameIsUnique(ctx context.Context, safeEvict *updatev1.SafeEvict) error {
+	safeEvictList := &updatev1.SafeEvictList{}
+	if err := v.client.List(ctx, safeEvictList); err != nil {
+		v.logger.Error("Failed to list SafeEvict resources", zap.Error(err))
+		return fmt.Errorf("failed to list SafeEvict resources: %w", err)
+	}
+
+	temporaryNodepoolName := v.temporaryNodepoolName(safeEvict)
+	for _, other := range safeEvictList.Items {
+		if other.UID == safeEvict.UID {
+			continue
+		}
+		if safeEvict.SharesBackupPool() && other.SharesBackupPool() {
+			continue
+		}
+		if v.temporaryNodepoolName(&other) == temporaryNodepoolName {
+			return fmt.Errorf("temporary nodepool name '%s' is already used by SafeEvict '%s/%s'", temporaryNodepoolName, other.Namespace, other.Name)
+		}
+	}
+	return nil
+}
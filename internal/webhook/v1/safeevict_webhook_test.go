@@ -0,0 +1,277 @@
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	updatev1 "norbinto/node-updater/api/v1"
+)
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := updatev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add scheme: %v", err)
+	}
+	return scheme
+}
+
+func newSafeEvict(name, uid, basePool string) *updatev1.SafeEvict {
+	return &updatev1.SafeEvict{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(uid),
+		},
+		Spec: updatev1.SafeEvictSpec{
+			BaseForBackupPool: basePool,
+			LastLogLines:      []string{"Listening for Jobs\n"},
+		},
+	}
+}
+
+func TestValidateCreate_UniqueTemporaryNodepoolName(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	existing := newSafeEvict("existing", "uid-1", "agentpool")
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(existing).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	_, err := validator.ValidateCreate(context.TODO(), newSafeEvict("new", "uid-2", "agentpool"))
+	if err != nil {
+		t.Fatalf("ValidateCreate failed: %v", err)
+	}
+}
+
+func TestValidateCreate_DuplicateTemporaryNodepoolName(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	existing := newSafeEvict("existing", "uid-1", "agentpool")
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(existing).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+	// the hashes of the UIDs collide
+	validator.temporaryNodepoolName = func(safeEvict *updatev1.SafeEvict) string {
+		return "tmpage000000"
+	}
+	safeEvict := newSafeEvict("new", "uid-2", "agentpool")
+	if validator.temporaryNodepoolName(safeEvict) != validator.temporaryNodepoolName(existing) {
+		t.Fatalf("Expected the temporary nodepool names of the SafeEvicts to collide")
+	}
+
+	_, err := validator.ValidateCreate(context.TODO(), safeEvict)
+	if err == nil {
+		t.Fatalf("Expected duplicate temporary nodepool name error, got nil")
+	}
+	if !strings.Contains(err.Error(), "'tmpage000000' is already used by SafeEvict 'default/existing'") {
+		t.Errorf("Expected the error to name the colliding SafeEvict, got: %v", err)
+	}
+}
+
+func TestValidateCreate_SharedTemporaryNodepoolName(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	existing := newSafeEvict("existing", "uid-1", "agentpool")
+	existing.Spec.BackupPool = &updatev1.BackupPoolSpec{Shared: true}
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(existing).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+	safeEvict := newSafeEvict("new", "uid-2", "agentpool")
+	safeEvict.Spec.BackupPool = &updatev1.BackupPoolSpec{Shared: true}
+	if safeEvict.GetTemporaryNodepoolName() != existing.GetTemporaryNodepoolName() {
+		t.Fatalf("Expected the SafeEvicts to share the temporary nodepool")
+	}
+
+	_, err := validator.ValidateCreate(context.TODO(), safeEvict)
+	if err != nil {
+		t.Fatalf("ValidateCreate failed: %v", err)
+	}
+}
+
+func TestValidateUpdate_SameResource(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	existing := newSafeEvict("existing", "uid-1", "agentpool")
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(existing).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	_, err := validator.ValidateUpdate(context.TODO(), existing, existing)
+	if err != nil {
+		t.Fatalf("ValidateUpdate failed: %v", err)
+	}
+}
+
+func TestValidateCreate_DrainSignalNeedsOneAction(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	safeEvict := newSafeEvict("new", "uid-1", "agentpool")
+	safeEvict.Spec.DrainSignal = &updatev1.DrainSignal{}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err == nil {
+		t.Error("Expected an error for a drain signal without action, got nil")
+	}
+
+	safeEvict.Spec.DrainSignal.Exec = &corev1.ExecAction{Command: []string{"touch", "/tmp/drain"}}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err == nil {
+		t.Error("Expected an error for an exec drain signal without serviceAccountRef, got nil")
+	}
+
+	safeEvict.Spec.ServiceAccountRef = &updatev1.ServiceAccountReference{Name: "node-updater-tenant"}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err != nil {
+		t.Errorf("ValidateCreate failed: %v", err)
+	}
+
+	safeEvict.Spec.DrainSignal.HTTPGet = &corev1.HTTPGetAction{Path: "/drain"}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err == nil {
+		t.Error("Expected an error for a drain signal with two actions, got nil")
+	}
+}
+
+func TestValidateCreate_BackupPoolNodeLabels(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	safeEvict := newSafeEvict("new", "uid-1", "agentpool")
+	safeEvict.Spec.BackupPool = &updatev1.BackupPoolSpec{ExtraNodeLabels: map[string]string{"pool": updatev1.SubstitutionTemporaryPool}}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err != nil {
+		t.Errorf("ValidateCreate failed: %v", err)
+	}
+
+	safeEvict.Spec.BackupPool.ExtraNodeLabels = map[string]string{"pool": "temporary pool"}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err == nil {
+		t.Error("Expected an error for a label value with a space, got nil")
+	}
+
+	safeEvict.Spec.BackupPool.ExtraNodeLabels = map[string]string{"pool/" + updatev1.SubstitutionSafeEvict + "/name": "temporary"}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err == nil {
+		t.Error("Expected an error for an invalid label key, got nil")
+	}
+}
+
+func TestValidateCreate_PendingPodWatchdogScaleUpNeedsMaxCount(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	safeEvict := newSafeEvict("new", "uid-1", "agentpool")
+	safeEvict.Spec.PendingPodWatchdog = &updatev1.PendingPodWatchdogSpec{Action: updatev1.PendingPodActionScaleUpBackupPool}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err == nil {
+		t.Error("Expected an error for a scale up without maximum count, got nil")
+	}
+
+	maxBackupPoolCount := int32(5)
+	safeEvict.Spec.PendingPodWatchdog.MaxBackupPoolCount = &maxBackupPoolCount
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err != nil {
+		t.Errorf("ValidateCreate failed: %v", err)
+	}
+}
+
+func TestValidateCreate_BlockingPodSelector(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	safeEvict := newSafeEvict("new", "uid-1", "agentpool")
+	safeEvict.Spec.BlockingPodSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Matches"}}}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err == nil {
+		t.Error("Expected an error for an invalid blocking pod selector, got nil")
+	}
+
+	safeEvict.Spec.BlockingPodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "agent"}}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err != nil {
+		t.Errorf("ValidateCreate failed: %v", err)
+	}
+}
+
+func TestValidateCreate_ClusterSelector(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	safeEvict := newSafeEvict("new", "uid-1", "agentpool")
+	safeEvict.Spec.ClusterSelector = &metav1.LabelSelector{}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err == nil {
+		t.Error("Expected an error for an empty cluster selector, got nil")
+	}
+
+	safeEvict.Spec.ClusterSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"fleet": "ci"}}
+	if _, err := validator.ValidateCreate(context.TODO(), safeEvict); err != nil {
+		t.Errorf("ValidateCreate failed: %v", err)
+	}
+}
+
+func TestValidateCreate_BackupPoolRetainFor(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	for _, tt := range []struct {
+		name       string
+		backupPool updatev1.BackupPoolSpec
+		valid      bool
+	}{
+		{"retained", updatev1.BackupPoolSpec{RetainFor: &metav1.Duration{Duration: time.Hour}}, true},
+		{"negative", updatev1.BackupPoolSpec{RetainFor: &metav1.Duration{Duration: -time.Hour}}, false},
+		{"shared", updatev1.BackupPoolSpec{Shared: true, RetainFor: &metav1.Duration{Duration: time.Hour}}, false},
+		{"auto rollback", updatev1.BackupPoolSpec{RetainFor: &metav1.Duration{Duration: time.Hour}, AutoRollback: &updatev1.AutoRollbackSpec{}}, true},
+		{"auto rollback without retention", updatev1.BackupPoolSpec{AutoRollback: &updatev1.AutoRollbackSpec{}}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			safeEvict := newSafeEvict("new", "uid-1", "agentpool")
+			safeEvict.Spec.BackupPool = &tt.backupPool
+			_, err := validator.ValidateCreate(context.TODO(), safeEvict)
+			if (err == nil) != tt.valid {
+				t.Errorf("expected valid=%t, got %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestValidateCreate_LastLogLines(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	for _, tt := range []struct {
+		name         string
+		lastLogLines []string
+		valid        bool
+	}{
+		{"no lines", nil, false},
+		{"empty line", []string{"Listening for Jobs\n", ""}, false},
+		{"whitespace line", []string{" \n"}, false},
+		{"lines", []string{"Listening for Jobs\n", "Agent reconnected.\n"}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			safeEvict := newSafeEvict("new", "uid-1", "agentpool")
+			safeEvict.Spec.LastLogLines = tt.lastLogLines
+
+			_, err := validator.ValidateCreate(context.TODO(), safeEvict)
+
+			if valid := err == nil; valid != tt.valid {
+				t.Errorf("expected valid %t, got %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestValidateCreate_WarnsAboutBasePoolOutsideOfNodepools(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	kubeClient := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	validator := NewSafeEvictCustomValidator(kubeClient, logger)
+
+	safeEvict := newSafeEvict("new", "uid-1", "agentpool")
+	safeEvict.Spec.Nodepools = []string{"agentpool"}
+	if warnings, _ := validator.ValidateCreate(context.TODO(), safeEvict); len(warnings) != 0 {
+		t.Errorf("expected no warning for a monitored base pool, got %v", warnings)
+	}
+
+	safeEvict.Spec.Nodepools = []string{"userpool"}
+	if warnings, _ := validator.ValidateCreate(context.TODO(), safeEvict); len(warnings) != 1 {
+		t.Errorf("expected a warning for a base pool outside of the nodepools, got %v", warnings)
+	}
+}
@@ -0,0 +1,183 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"norbinto/node-updater/internal/egress"
+)
+
+const (
+	// PagerDutyEventsURL is the Events API v2 endpoint of PagerDuty
+	PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	// OpsgenieURL is the API of Opsgenie, the EU instance is https://api.eu.opsgenie.com
+	OpsgenieURL = "https://api.opsgenie.com"
+
+	// alertSource is the source of the incidents in the alerting services
+	alertSource = "node-updater"
+)
+
+// alertAction returns whether the event opens or resolves incidents, the other events are informational and do not
+// reach the alerting services
+func alertAction(event Event) (trigger bool, ok bool) {
+	switch event {
+	case EventRotationFailed, EventUpgradeBlocked:
+		return true, true
+	case EventRecovered:
+		return false, true
+	}
+	return false, false
+}
+
+// summary is the title of the incident of an alert key
+func summary(notification Notification) string {
+	target := notification.Namespace + "/" + notification.Name
+	if notification.Cluster != "" {
+		target += " on cluster " + notification.Cluster
+	}
+	return fmt.Sprintf("SafeEvict %s: %s", target, notification.Message)
+}
+
+// PagerDutyNotifier opens a PagerDuty incident per alert key of the RotationFailed and UpgradeBlocked events and
+// resolves them with the Recovered event, PagerDuty deduplicates the incidents by the key. It ignores the other events.
+type PagerDutyNotifier struct {
+	httpClient egress.Doer
+	url        string
+	routingKey string
+}
+
+// NewPagerDutyNotifier creates a PagerDutyNotifier which sends the events to the service of the integration key
+func NewPagerDutyNotifier(httpClient egress.Doer, routingKey string) *PagerDutyNotifier {
+	return &PagerDutyNotifier{httpClient: httpClient, url: PagerDutyEventsURL, routingKey: routingKey}
+}
+
+// WithURL returns a copy of the PagerDutyNotifier which sends the events to another Events API v2 endpoint
+func (p *PagerDutyNotifier) WithURL(url string) *PagerDutyNotifier {
+	copied := *p
+	copied.url = url
+	return &copied
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string       `json:"summary"`
+	Source        string       `json:"source"`
+	Severity      string       `json:"severity"`
+	Component     string       `json:"component,omitempty"`
+	CustomDetails Notification `json:"custom_details"`
+}
+
+// Notify implements Notifier
+func (p *PagerDutyNotifier) Notify(ctx context.Context, notification Notification) error {
+	trigger, ok := alertAction(notification.Event)
+	if !ok {
+		return nil
+	}
+	var errs []error
+	for _, alertKey := range notification.AlertKeys {
+		event := pagerDutyEvent{RoutingKey: p.routingKey, EventAction: "resolve", DedupKey: alertKey}
+		if trigger {
+			severity := "error"
+			if notification.Event == EventUpgradeBlocked {
+				severity = "warning"
+			}
+			event.EventAction = "trigger"
+			event.Payload = &pagerDutyPayload{
+				Summary:       summary(notification),
+				Source:        alertSource,
+				Severity:      severity,
+				Component:     notification.Namespace + "/" + notification.Name,
+				CustomDetails: notification,
+			}
+		}
+		errs = append(errs, postJSON(ctx, p.httpClient, "PagerDuty Events API", p.url, nil, event))
+	}
+	return errors.Join(errs...)
+}
+
+// OpsgenieNotifier opens an Opsgenie alert per alert key of the RotationFailed and UpgradeBlocked events and closes
+// them with the Recovered event, Opsgenie deduplicates the alerts by the key as their alias. It ignores the other
+// events.
+type OpsgenieNotifier struct {
+	httpClient egress.Doer
+	url        string
+	apiKey     string
+}
+
+// NewOpsgenieNotifier creates an OpsgenieNotifier which creates the alerts with the key of an API integration
+func NewOpsgenieNotifier(httpClient egress.Doer, apiKey string) *OpsgenieNotifier {
+	return &OpsgenieNotifier{httpClient: httpClient, url: OpsgenieURL, apiKey: apiKey}
+}
+
+// WithURL returns a copy of the OpsgenieNotifier which calls another Opsgenie instance, e.g. the EU one
+func (o *OpsgenieNotifier) WithURL(url string) *OpsgenieNotifier {
+	copied := *o
+	copied.url = strings.TrimSuffix(url, "/")
+	return &copied
+}
+
+type opsgenieAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description,omitempty"`
+	Source      string            `json:"source"`
+	Priority    string            `json:"priority"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+type opsgenieClose struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+// Notify implements Notifier
+func (o *OpsgenieNotifier) Notify(ctx context.Context, notification Notification) error {
+	trigger, ok := alertAction(notification.Event)
+	if !ok {
+		return nil
+	}
+	header := http.Header{"Authorization": {"GenieKey " + o.apiKey}}
+	var errs []error
+	for _, alertKey := range notification.AlertKeys {
+		if !trigger {
+			closeURL := fmt.Sprintf("%s/v2/alerts/%s/close?identifierType=alias", o.url, url.PathEscape(alertKey))
+			errs = append(errs, postJSON(ctx, o.httpClient, "Opsgenie Alert API", closeURL, header, opsgenieClose{Source: alertSource, Note: notification.Message}))
+			continue
+		}
+		priority := "P2"
+		if notification.Event == EventUpgradeBlocked {
+			priority = "P3"
+		}
+		// Opsgenie truncates the message after 130 characters, the description has the whole message and the error
+		description := notification.Message
+		if notification.Error != "" {
+			description += "\n\n" + notification.Error
+		}
+		alert := opsgenieAlert{
+			Message:     summary(notification),
+			Alias:       alertKey,
+			Description: description,
+			Source:      alertSource,
+			Priority:    priority,
+			Details: map[string]string{
+				"event":     string(notification.Event),
+				"namespace": notification.Namespace,
+				"name":      notification.Name,
+				"cluster":   notification.Cluster,
+				"nodepools": strings.Join(notification.Nodepools, ","),
+			},
+		}
+		errs = append(errs, postJSON(ctx, o.httpClient, "Opsgenie Alert API", o.url+"/v2/alerts", header, alert))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testAlert(event Event) Notification {
+	return Notification{
+		Event: event, Namespace: "default", Name: "rotation", Nodepools: []string{"userpool", "batchpool"},
+		AlertKeys: []string{"node-updater/failed/default/rotation/userpool", "node-updater/failed/default/rotation/batchpool"},
+		Message:   "Rotation was rolled back",
+	}
+}
+
+func TestPagerDutyNotifier_Notify(t *testing.T) {
+	var received []pagerDutyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerDutyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode the event: %v", err)
+		}
+		received = append(received, event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	notifier := NewPagerDutyNotifier(server.Client(), "routing-key").WithURL(server.URL)
+
+	for _, event := range []Event{EventRotationFailed, EventRolledBack, EventRecovered} {
+		if err := notifier.Notify(context.Background(), testAlert(event)); err != nil {
+			t.Fatalf("Notify returned error: %v", err)
+		}
+	}
+
+	if len(received) != 4 {
+		t.Fatalf("expected a trigger and a resolve per alert key and the informational event to be ignored, got %+v", received)
+	}
+	trigger, resolve := received[0], received[2]
+	if trigger.EventAction != "trigger" || trigger.RoutingKey != "routing-key" || trigger.DedupKey != "node-updater/failed/default/rotation/userpool" ||
+		trigger.Payload == nil || trigger.Payload.Severity != "error" {
+		t.Errorf("expected an incident of the userpool, got %+v", trigger)
+	}
+	if resolve.EventAction != "resolve" || resolve.DedupKey != trigger.DedupKey || resolve.Payload != nil {
+		t.Errorf("expected the incident of the userpool to be resolved, got %+v", resolve)
+	}
+}
+
+func TestOpsgenieNotifier_Notify(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "GenieKey api-key" {
+			t.Errorf("expected the API key, got %q", r.Header.Get("Authorization"))
+		}
+		paths = append(paths, r.URL.RequestURI())
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	notifier := NewOpsgenieNotifier(server.Client(), "api-key").WithURL(server.URL + "/")
+	blocked := testAlert(EventUpgradeBlocked)
+	blocked.AlertKeys = blocked.AlertKeys[:1]
+	recovered := testAlert(EventRecovered)
+	recovered.AlertKeys = blocked.AlertKeys
+
+	for _, notification := range []Notification{blocked, recovered} {
+		if err := notifier.Notify(context.Background(), notification); err != nil {
+			t.Fatalf("Notify returned error: %v", err)
+		}
+	}
+
+	expected := []string{"/v2/alerts", "/v2/alerts/node-updater%2Ffailed%2Fdefault%2Frotation%2Fuserpool/close?identifierType=alias"}
+	if len(paths) != len(expected) || paths[0] != expected[0] || paths[1] != expected[1] {
+		t.Errorf("expected the alert to be created and closed by its alias, got %v", paths)
+	}
+}
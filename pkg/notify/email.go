@@ -0,0 +1,249 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The keys of the Secret the SMTPConfig is read from
+const (
+	SMTPServerKey     = "server"
+	SMTPUsernameKey   = "username"
+	SMTPPasswordKey   = "password"
+	SMTPFromKey       = "from"
+	SMTPRecipientsKey = "to"
+)
+
+// smtpsPort is the port of SMTP over implicit TLS, the other ports upgrade the connection with STARTTLS when the server
+// offers it
+const smtpsPort = "465"
+
+// SMTPConfig is the server, the credentials and the recipients of the EmailNotifier
+type SMTPConfig struct {
+	// Server is the host:port of the SMTP server, e.g. smtp.example.com:587
+	Server string
+	// Username and Password authenticate with PLAIN auth, the notifier does not authenticate when Username is empty
+	Username string
+	Password string
+	// From is the sender address of the emails
+	From string
+	// Recipients are the addresses the emails are sent to
+	Recipients []string
+}
+
+// LoadSMTPConfig reads the SMTPConfig from the files of the keys of a Secret mounted at dir, the recipients are comma
+// separated. The error wraps fs.ErrNotExist when the Secret is not mounted.
+func LoadSMTPConfig(dir string) (SMTPConfig, error) {
+	read := func(key string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(dir, key))
+		return strings.TrimSpace(string(data)), err
+	}
+	server, err := read(SMTPServerKey)
+	if err != nil {
+		return SMTPConfig{}, fmt.Errorf("unable to read the SMTP server: %w", err)
+	}
+	config := SMTPConfig{Server: server}
+	if config.Username, err = read(SMTPUsernameKey); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return SMTPConfig{}, fmt.Errorf("unable to read the SMTP username: %w", err)
+	}
+	// the password may end with a space, only the newline an editor adds is dropped
+	password, err := os.ReadFile(filepath.Join(dir, SMTPPasswordKey))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return SMTPConfig{}, fmt.Errorf("unable to read the SMTP password: %w", err)
+	}
+	config.Password = strings.TrimRight(string(password), "\r\n")
+	if config.From, err = read(SMTPFromKey); err != nil {
+		return SMTPConfig{}, fmt.Errorf("unable to read the sender of the emails: %w", err)
+	}
+	recipients, err := read(SMTPRecipientsKey)
+	if err != nil {
+		return SMTPConfig{}, fmt.Errorf("unable to read the recipients of the emails: %w", err)
+	}
+	for _, recipient := range strings.Split(recipients, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			config.Recipients = append(config.Recipients, recipient)
+		}
+	}
+	return config, config.Validate()
+}
+
+// Validate returns an error when the server is not a host:port or an address does not parse
+func (c SMTPConfig) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		return fmt.Errorf("SMTP server '%s' is not a host:port: %w", c.Server, err)
+	}
+	if c.Username == "" && c.Password != "" {
+		return errors.New("SMTP password is set without a username")
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("sender '%s' is not an email address: %w", c.From, err)
+	}
+	if len(c.Recipients) == 0 {
+		return errors.New("no recipient of the emails is set")
+	}
+	for _, recipient := range c.Recipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return fmt.Errorf("recipient '%s' is not an email address: %w", recipient, err)
+		}
+	}
+	return nil
+}
+
+// EmailNotifier sends the notifications as plain text emails through an SMTP server, for the environments which cannot
+// reach a webhook
+type EmailNotifier struct {
+	config    SMTPConfig
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+// NewEmailNotifier creates an EmailNotifier which sends the emails with the config, it trusts the system certificates
+func NewEmailNotifier(config SMTPConfig) *EmailNotifier {
+	return &EmailNotifier{config: config}
+}
+
+// WithTLSConfig returns a copy of the EmailNotifier which verifies the certificate of the server with the tlsConfig,
+// e.g. to trust the CA of an internal relay
+func (e *EmailNotifier) WithTLSConfig(tlsConfig *tls.Config) *EmailNotifier {
+	copied := *e
+	copied.tlsConfig = tlsConfig
+	return &copied
+}
+
+// WithTimeout returns a copy of the EmailNotifier whose emails may take the timeout to send, zero does not bound them
+func (e *EmailNotifier) WithTimeout(timeout time.Duration) *EmailNotifier {
+	copied := *e
+	copied.timeout = timeout
+	return &copied
+}
+
+// Notify implements Notifier
+func (e *EmailNotifier) Notify(ctx context.Context, notification Notification) error {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	if err := e.send(ctx, e.message(notification)); err != nil {
+		return fmt.Errorf("failed to send the email through '%s': %w", e.config.Server, err)
+	}
+	return nil
+}
+
+// message returns the email of the notification with its headers
+func (e *EmailNotifier) message(notification Notification) []byte {
+	target := notification.Namespace + "/" + notification.Name
+	if notification.Cluster != "" {
+		target += " on cluster " + notification.Cluster
+	}
+	// the names end up in a header, a line break in them must not start another header
+	subject := strings.Join(strings.Fields(fmt.Sprintf("[node-updater] %s: SafeEvict %s", notification.Event, target)), " ")
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(e.config.Recipients, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+
+	message.WriteString(notification.Message + "\r\n\r\n")
+	if len(notification.Nodepools) > 0 {
+		fmt.Fprintf(&message, "Nodepools: %s\r\n", strings.Join(notification.Nodepools, ", "))
+	}
+	for _, nodepool := range slices.Sorted(maps.Keys(notification.Versions)) {
+		fmt.Fprintf(&message, "Node image of %s: %s\r\n", nodepool, notification.Versions[nodepool])
+	}
+	if notification.Duration > 0 {
+		fmt.Fprintf(&message, "Duration: %s\r\n", notification.Duration.Round(time.Second))
+	}
+	if notification.Error != "" {
+		fmt.Fprintf(&message, "Error: %s\r\n", notification.Error)
+	}
+	return message.Bytes()
+}
+
+// send delivers the message to every recipient in one SMTP session
+func (e *EmailNotifier) send(ctx context.Context, message []byte) error {
+	host, port, err := net.SplitHostPort(e.config.Server)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if e.tlsConfig != nil {
+		tlsConfig = e.tlsConfig.Clone()
+	}
+	tlsConfig.ServerName = host
+
+	var conn net.Conn
+	if port == smtpsPort {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", e.config.Server)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", e.config.Server)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != smtpsPort {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	// PlainAuth refuses to send the password over a connection which is neither TLS nor to localhost
+	if e.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.config.Username, e.config.Password, host)); err != nil {
+			return err
+		}
+	}
+	// the envelope has the bare addresses, the headers keep their display names
+	if err := client.Mail(envelopeAddress(e.config.From)); err != nil {
+		return err
+	}
+	for _, recipient := range e.config.Recipients {
+		if err := client.Rcpt(envelopeAddress(recipient)); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// envelopeAddress returns the address without the display name, e.g. ops@example.com of "Ops <ops@example.com>"
+func envelopeAddress(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return parsed.Address
+	}
+	return address
+}
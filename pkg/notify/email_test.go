@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receivedEmail is what the fake SMTP server got in a session
+type receivedEmail struct {
+	auth       string
+	from       string
+	recipients []string
+	data       string
+}
+
+// newSMTPServer starts a fake SMTP server on localhost which accepts one session and sends what it got to the channel
+func newSMTPServer(t *testing.T) (string, <-chan receivedEmail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	received := make(chan receivedEmail, 1)
+	go func() {
+		netConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn := textproto.NewConn(netConn)
+		defer conn.Close()
+		var email receivedEmail
+		_ = conn.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := conn.ReadLine()
+			if err != nil {
+				return
+			}
+			command, argument, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(command) {
+			case "EHLO":
+				_ = conn.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+			case "AUTH":
+				email.auth = argument
+				_ = conn.PrintfLine("235 authenticated")
+			case "MAIL":
+				email.from = argument
+				_ = conn.PrintfLine("250 OK")
+			case "RCPT":
+				email.recipients = append(email.recipients, argument)
+				_ = conn.PrintfLine("250 OK")
+			case "DATA":
+				_ = conn.PrintfLine("354 go ahead")
+				data, err := conn.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				email.data = string(data)
+				_ = conn.PrintfLine("250 queued")
+			case "QUIT":
+				_ = conn.PrintfLine("221 bye")
+				received <- email
+				return
+			default:
+				_ = conn.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestEmailNotifier_Notify(t *testing.T) {
+	server, received := newSMTPServer(t)
+	config := SMTPConfig{
+		Server:     server,
+		Username:   "node-updater",
+		Password:   "secret",
+		From:       "Node Updater <node-updater@example.com>",
+		Recipients: []string{"ops@example.com", "Platform <platform@example.com>"},
+	}
+	notification := testNotification()
+	notification.Namespace = "default\r\nBcc: attacker@example.com"
+	notification.Versions = map[string]string{"userpool": "AKSUbuntu-2204gen2containerd-202510.01.0"}
+	notification.Error = "agents degraded on userpool"
+
+	if err := NewEmailNotifier(config).WithTimeout(5*time.Second).Notify(context.Background(), notification); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	email := <-received
+	if email.auth == "" {
+		t.Error("expected the notifier to authenticate")
+	}
+	if email.from != "FROM:<node-updater@example.com>" {
+		t.Errorf("expected the bare sender address in the envelope, got %s", email.from)
+	}
+	if strings.Join(email.recipients, ",") != "TO:<ops@example.com>,TO:<platform@example.com>" {
+		t.Errorf("expected every recipient in the envelope, got %v", email.recipients)
+	}
+	if !strings.Contains(email.data, "Subject: [node-updater] RolledBack: SafeEvict default Bcc: attacker@example.com/rotation\n") {
+		t.Errorf("expected the subject of the event on a single line, got %s", email.data)
+	}
+	for _, expected := range []string{"agents degraded\n", "Nodepools: userpool\n", "Node image of userpool: AKSUbuntu-2204gen2containerd-202510.01.0\n", "Error: agents degraded on userpool\n"} {
+		if !strings.Contains(email.data, expected) {
+			t.Errorf("expected the email to contain %q, got %s", expected, email.data)
+		}
+	}
+}
+
+func TestEmailNotifier_FailsWhenTheServerIsUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := listener.Addr().String()
+	_ = listener.Close()
+
+	config := SMTPConfig{Server: server, From: "node-updater@example.com", Recipients: []string{"ops@example.com"}}
+	if err := NewEmailNotifier(config).Notify(context.Background(), testNotification()); err == nil {
+		t.Error("expected an error for an unreachable server")
+	}
+}
+
+func TestLoadSMTPConfig(t *testing.T) {
+	write := func(t *testing.T, files map[string]string) string {
+		dir := t.TempDir()
+		for key, value := range files {
+			if err := os.WriteFile(filepath.Join(dir, key), []byte(value), 0o600); err != nil {
+				t.Fatalf("failed to write %s: %v", key, err)
+			}
+		}
+		return dir
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		dir := write(t, map[string]string{
+			SMTPServerKey: "smtp.example.com:587\n", SMTPUsernameKey: "node-updater", SMTPPasswordKey: "secret \n",
+			SMTPFromKey: "node-updater@example.com", SMTPRecipientsKey: "ops@example.com, platform@example.com,",
+		})
+		config, err := LoadSMTPConfig(dir)
+		if err != nil {
+			t.Fatalf("LoadSMTPConfig returned error: %v", err)
+		}
+		if config.Server != "smtp.example.com:587" || config.Password != "secret " || len(config.Recipients) != 2 {
+			t.Errorf("unexpected config %+v", config)
+		}
+	})
+	t.Run("not mounted", func(t *testing.T) {
+		if _, err := LoadSMTPConfig(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected fs.ErrNotExist, got %v", err)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		dir := write(t, map[string]string{SMTPServerKey: "smtp.example.com", SMTPFromKey: "node-updater@example.com", SMTPRecipientsKey: "ops@example.com"})
+		if _, err := LoadSMTPConfig(dir); err == nil {
+			t.Error("expected an error for a server without port")
+		}
+	})
+	t.Run("without recipients", func(t *testing.T) {
+		dir := write(t, map[string]string{SMTPServerKey: "smtp.example.com:25", SMTPFromKey: "node-updater@example.com", SMTPRecipientsKey: " "})
+		if _, err := LoadSMTPConfig(dir); err == nil {
+			t.Error("expected an error without recipients")
+		}
+	})
+}
@@ -0,0 +1,90 @@
+// Package notify tells the teams running the agents about the events of the rotations which need their attention, e.g.
+// when a rotation moved the workload back onto the temporary nodepool. A Notifier is either compiled into the binary
+// with Register, or called as a webhook.
+package notify
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event is the kind of a notification
+type Event string
+
+const (
+	// EventRolledBack is sent when the agents on the upgraded nodepools degraded and the workload was moved back onto
+	// the retained temporary nodepool
+	EventRolledBack Event = "RolledBack"
+	// EventRotationFailed is sent when a rotation was rolled back and waits in the Failed phase for its retry
+	EventRotationFailed Event = "RotationFailed"
+	// EventUpgradeBlocked is sent when ARM did not permit the node image upgrade of the nodepools
+	EventUpgradeBlocked Event = "UpgradeBlocked"
+	// EventRecovered is sent when the failure or the blocked upgrade of an earlier RotationFailed or UpgradeBlocked
+	// event is over, its AlertKeys are those of the earlier event
+	EventRecovered Event = "Recovered"
+)
+
+// events are the events the controller sends notifications of
+var events = []Event{EventRolledBack, EventRotationFailed, EventUpgradeBlocked, EventRecovered}
+
+// Notification is an event of the rotation of a SafeEvict
+type Notification struct {
+	Event Event `json:"event"`
+	// Namespace and Name identify the SafeEvict
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Cluster is the workload cluster in management cluster mode, empty for the cluster of the controller
+	Cluster string `json:"cluster,omitempty"`
+	// Nodepools are the nodepools the event is about
+	Nodepools []string `json:"nodepools,omitempty"`
+	// Versions are the node image versions the nodepools were upgraded to, by nodepool
+	Versions map[string]string `json:"versions,omitempty"`
+	// Duration is the time since the rotation started, in nanoseconds in JSON
+	Duration time.Duration `json:"duration,omitempty"`
+	// Error is the error which caused the event, empty when there is none
+	Error string `json:"error,omitempty"`
+	// AlertKeys deduplicate the incidents of the RotationFailed and UpgradeBlocked events, one per nodepool, the
+	// Recovered event resolves the incidents of its keys
+	AlertKeys []string `json:"alertKeys,omitempty"`
+	// Message describes the event, it is rendered by the template of the event when there is one
+	Message string `json:"message"`
+}
+
+// Notifier delivers the notifications
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+var (
+	mu         sync.Mutex
+	registered []Notifier
+)
+
+// Register adds a notifier compiled into the binary, it is meant to be called from an init function
+func Register(notifier Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	registered = append(registered, notifier)
+}
+
+// Registered returns the notifiers added with Register
+func Registered() []Notifier {
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Clone(registered)
+}
+
+// Notifiers delivers the notification to every notifier, a failing notifier does not keep the others from getting it
+type Notifiers []Notifier
+
+// Notify implements Notifier
+func (n Notifiers) Notify(ctx context.Context, notification Notification) error {
+	var errs []error
+	for _, notifier := range n {
+		errs = append(errs, notifier.Notify(ctx, notification))
+	}
+	return errors.Join(errs...)
+}
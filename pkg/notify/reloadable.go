@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"norbinto/node-updater/internal/egress"
+)
+
+// Sinks are the notification services which are configured at runtime, an empty field disables its service
+type Sinks struct {
+	// WebhookURL is the address the notifications are posted to as JSON
+	WebhookURL string
+	// PagerDutyRoutingKey is the integration key of the PagerDuty service the incidents are opened in
+	PagerDutyRoutingKey string
+	// OpsgenieAPIKey is the API key of the Opsgenie integration the alerts are opened with
+	OpsgenieAPIKey string
+	// OpsgenieURL is the Opsgenie API, OpsgenieURL of the package when it is empty
+	OpsgenieURL string
+}
+
+// ReloadableNotifier is a Notifier whose sinks are replaced at runtime, e.g. when the NodeUpdaterConfig changes. The
+// notifiers which are not configured at runtime, e.g. those compiled into the binary, are kept. The notifications which
+// were already sent finish with the previous sinks.
+type ReloadableNotifier struct {
+	httpClient egress.Doer
+	static     []Notifier
+
+	mu       sync.RWMutex
+	sinks    Sinks
+	notifier Notifiers
+}
+
+func NewReloadableNotifier(httpClient egress.Doer, static []Notifier, sinks Sinks) *ReloadableNotifier {
+	n := &ReloadableNotifier{httpClient: httpClient, static: slices.Clone(static)}
+	n.configure(sinks)
+	return n
+}
+
+// Configure replaces the sinks
+func (n *ReloadableNotifier) Configure(sinks Sinks) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if sinks == n.sinks {
+		return
+	}
+	n.configure(sinks)
+}
+
+func (n *ReloadableNotifier) configure(sinks Sinks) {
+	notifiers := slices.Clone(n.static)
+	if sinks.WebhookURL != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(n.httpClient, sinks.WebhookURL))
+	}
+	// the alerting services open an incident for a failed or a blocked rotation and resolve it once it recovered
+	if sinks.PagerDutyRoutingKey != "" {
+		notifiers = append(notifiers, NewPagerDutyNotifier(n.httpClient, sinks.PagerDutyRoutingKey))
+	}
+	if sinks.OpsgenieAPIKey != "" {
+		opsgenie := NewOpsgenieNotifier(n.httpClient, sinks.OpsgenieAPIKey)
+		if sinks.OpsgenieURL != "" {
+			opsgenie = opsgenie.WithURL(sinks.OpsgenieURL)
+		}
+		notifiers = append(notifiers, opsgenie)
+	}
+	n.sinks = sinks
+	n.notifier = notifiers
+}
+
+// Configured returns true when the notifications are sent to at least one notifier
+func (n *ReloadableNotifier) Configured() bool {
+	return len(n.current()) > 0
+}
+
+func (n *ReloadableNotifier) current() Notifiers {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.notifier
+}
+
+// Notify implements Notifier
+func (n *ReloadableNotifier) Notify(ctx context.Context, notification Notification) error {
+	return n.current().Notify(ctx, notification)
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReloadableNotifier_Configure(t *testing.T) {
+	var first, second atomic.Int32
+	newServer := func(received *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+	firstServer, secondServer := newServer(&first), newServer(&second)
+	defer firstServer.Close()
+	defer secondServer.Close()
+	delivered := 0
+	static := notifierFunc(func(context.Context, Notification) error {
+		delivered++
+		return nil
+	})
+	notifier := NewReloadableNotifier(http.DefaultClient, []Notifier{static}, Sinks{WebhookURL: firstServer.URL})
+
+	if err := notifier.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+	notifier.Configure(Sinks{WebhookURL: secondServer.URL})
+	if err := notifier.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	if first.Load() != 1 || second.Load() != 1 {
+		t.Errorf("expected one notification per webhook, got %d and %d", first.Load(), second.Load())
+	}
+	if delivered != 2 {
+		t.Errorf("expected the static notifier to get every notification, got %d", delivered)
+	}
+}
+
+func TestReloadableNotifier_Configured(t *testing.T) {
+	notifier := NewReloadableNotifier(http.DefaultClient, nil, Sinks{})
+	if notifier.Configured() {
+		t.Error("expected a notifier without sinks not to be configured")
+	}
+	notifier.Configure(Sinks{OpsgenieAPIKey: "key"})
+	if !notifier.Configured() {
+		t.Error("expected the Opsgenie sink to configure the notifier")
+	}
+	if err := NewReloadableNotifier(http.DefaultClient, nil, Sinks{}).Notify(context.Background(), testNotification()); err != nil {
+		t.Errorf("expected a notifier without sinks to drop the notification, got %v", err)
+	}
+}
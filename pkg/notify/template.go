@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the functions the templates can call besides the builtins of text/template
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// ValidateTemplates checks the message templates by event, e.g. {"RolledBack": "{{.Name}} rolled back {{join .Nodepools
+// \", \"}}"}. It fails on an unknown event and on a template which does not parse or does not execute on a
+// notification, e.g. because it refers to an unknown field.
+func ValidateTemplates(templates map[string]string) error {
+	for _, event := range slices.Sorted(maps.Keys(templates)) {
+		if !slices.Contains(events, Event(event)) {
+			return fmt.Errorf("notification template of unknown event '%s', the events are %v", event, events)
+		}
+		tmpl, err := parseTemplate(event, templates[event])
+		if err != nil {
+			return err
+		}
+		if err := tmpl.Execute(io.Discard, Notification{Event: Event(event)}); err != nil {
+			return fmt.Errorf("notification template of event '%s' cannot be executed: %w", event, err)
+		}
+	}
+	return nil
+}
+
+// Format returns the notification with its message rendered by the template of its event, the notification is the
+// data of the template and its .Message is the message of the controller. A notification whose event has no template
+// keeps its message, so does a notification whose template fails.
+func Format(notification Notification, templates map[string]string) (Notification, error) {
+	text, found := templates[string(notification.Event)]
+	if !found {
+		return notification, nil
+	}
+	tmpl, err := parseTemplate(string(notification.Event), text)
+	if err != nil {
+		return notification, err
+	}
+	var message strings.Builder
+	if err := tmpl.Execute(&message, notification); err != nil {
+		return notification, fmt.Errorf("failed to execute the notification template of event '%s': %w", notification.Event, err)
+	}
+	notification.Message = message.String()
+	return notification, nil
+}
+
+func parseTemplate(event, text string) (*template.Template, error) {
+	tmpl, err := template.New(event).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification template of event '%s': %w", event, err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	notification := testNotification()
+	notification.Versions = map[string]string{"userpool": "AKSUbuntu-2204gen2containerd-202510.01.0"}
+	notification.Duration = 90 * time.Minute
+	templates := map[string]string{
+		string(EventRolledBack): `{{.Namespace}}/{{.Name}}: {{join .Nodepools ","}} on {{index .Versions "userpool"}} after {{.Duration}}: {{.Message}}`,
+	}
+
+	formatted, err := Format(notification, templates)
+
+	if err != nil {
+		t.Fatalf("Format returned error: %v", err)
+	}
+	expected := "default/rotation: userpool on AKSUbuntu-2204gen2containerd-202510.01.0 after 1h30m0s: agents degraded"
+	if formatted.Message != expected {
+		t.Errorf("expected %q, got %q", expected, formatted.Message)
+	}
+	if unformatted, err := Format(notification, nil); err != nil || unformatted.Message != notification.Message {
+		t.Errorf("expected the message of an event without template to be kept, got %q, %v", unformatted.Message, err)
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		templates map[string]string
+		expectErr bool
+	}{
+		{"valid", map[string]string{"RolledBack": "{{.Name}} rolled back: {{.Error}}"}, false},
+		{"unknown event", map[string]string{"Started": "{{.Name}}"}, true},
+		{"syntax error", map[string]string{"RolledBack": "{{.Name"}, true},
+		{"unknown field", map[string]string{"RolledBack": "{{.Pools}}"}, true},
+		{"unknown version", map[string]string{"RolledBack": `{{index .Versions "userpool"}}`}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ValidateTemplates(tc.templates); (err != nil) != tc.expectErr {
+				t.Errorf("expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
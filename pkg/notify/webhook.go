@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"norbinto/node-updater/internal/egress"
+)
+
+// WebhookNotifier posts the notifications as JSON to an external service, e.g. a chat integration
+type WebhookNotifier struct {
+	httpClient egress.Doer
+	url        string
+}
+
+func NewWebhookNotifier(httpClient egress.Doer, url string) *WebhookNotifier {
+	return &WebhookNotifier{httpClient: httpClient, url: url}
+}
+
+// Notify implements Notifier
+func (w *WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	return postJSON(ctx, w.httpClient, "notification webhook", w.url, nil, notification)
+}
+
+// postJSON posts the body as JSON to the service, every 2xx answer counts as delivered
+func postJSON(ctx context.Context, httpClient egress.Doer, service, url string, header http.Header, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode the request of the %s: %w", service, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create the request of the %s: %w", service, err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call the %s: %w", service, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned status %d: %s", service, resp.StatusCode, string(message))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testNotification() Notification {
+	return Notification{Event: EventRolledBack, Namespace: "default", Name: "rotation", Nodepools: []string{"userpool"}, Message: "agents degraded"}
+}
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	var received Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON POST, got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode the notification: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := NewWebhookNotifier(server.Client(), server.URL).Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+	if received.Event != EventRolledBack || received.Name != "rotation" || len(received.Nodepools) != 1 {
+		t.Errorf("expected the notification to be posted, got %+v", received)
+	}
+}
+
+func TestWebhookNotifier_FailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if err := NewWebhookNotifier(server.Client(), server.URL).Notify(context.Background(), testNotification()); err == nil {
+		t.Error("expected an error for a failing webhook")
+	}
+}
+
+type notifierFunc func(context.Context, Notification) error
+
+func (f notifierFunc) Notify(ctx context.Context, notification Notification) error {
+	return f(ctx, notification)
+}
+
+func TestNotifiers_NotifiesEveryNotifier(t *testing.T) {
+	delivered := 0
+	failing := notifierFunc(func(context.Context, Notification) error { return errors.New("unavailable") })
+	counting := notifierFunc(func(context.Context, Notification) error {
+		delivered++
+		return nil
+	})
+
+	err := Notifiers{failing, counting}.Notify(context.Background(), testNotification())
+
+	if err == nil {
+		t.Error("expected the error of the failing notifier")
+	}
+	if delivered != 1 {
+		t.Errorf("expected the notification to reach the other notifier, got %d deliveries", delivered)
+	}
+}
@@ -0,0 +1,107 @@
+// Package plan lets advanced users review the rotations before the controller starts them, e.g. to integrate a
+// corporate change management. A Reviewer is either compiled into the binary with Register, or called as a webhook.
+package plan
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Nodepool is an outdated nodepool of a plan
+type Nodepool struct {
+	Name string `json:"name"`
+	// NodeImageVersion is the current node image version of the nodepool
+	NodeImageVersion string `json:"nodeImageVersion,omitempty"`
+}
+
+// Plan is a rotation which the controller is about to start
+type Plan struct {
+	// Namespace and Name identify the SafeEvict
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Cluster is the workload cluster in management cluster mode, empty for the cluster of the controller
+	Cluster string `json:"cluster,omitempty"`
+	// Nodepools are drained, upgraded and restored by the rotation
+	Nodepools []Nodepool `json:"nodepools"`
+	// BaseNodepool is cloned into TemporaryNodepool, which takes the workload of the drained nodepools
+	BaseNodepool      string `json:"baseNodepool"`
+	TemporaryNodepool string `json:"temporaryNodepool"`
+}
+
+// Decision is the answer of a Reviewer to a plan
+type Decision struct {
+	// Allowed starts the rotation, otherwise it is vetoed and reviewed again later
+	Allowed bool
+	Reason  string
+	// Nodepools, when set, are the only nodepools of the plan which are rotated, the others are skipped by the rotation
+	Nodepools []string
+	// RetryAfter is when a vetoed plan is reviewed again, by default after the upgrade frequency
+	RetryAfter time.Duration
+}
+
+// Reviewer vetoes or modifies the plans of the rotations. An error is handled as a veto and the plan is reviewed again.
+type Reviewer interface {
+	Review(ctx context.Context, plan Plan) (Decision, error)
+}
+
+var (
+	mu         sync.Mutex
+	registered []Reviewer
+)
+
+// Register adds a reviewer compiled into the binary, it is meant to be called from an init function
+func Register(reviewer Reviewer) {
+	mu.Lock()
+	defer mu.Unlock()
+	registered = append(registered, reviewer)
+}
+
+// Registered returns the reviewers added with Register
+func Registered() []Reviewer {
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Clone(registered)
+}
+
+// Reviewers asks every reviewer in turn. The plan is vetoed by the first veto, and every reviewer gets the nodepools
+// kept by the reviewers before it.
+type Reviewers []Reviewer
+
+// Review implements Reviewer
+func (r Reviewers) Review(ctx context.Context, plan Plan) (Decision, error) {
+	decision := Decision{Allowed: true}
+	for _, reviewer := range r {
+		next, err := reviewer.Review(ctx, plan)
+		if err != nil {
+			return Decision{}, err
+		}
+		if !next.Allowed {
+			return next, nil
+		}
+		if next.Nodepools != nil {
+			plan.Nodepools = slices.DeleteFunc(slices.Clone(plan.Nodepools), func(nodepool Nodepool) bool {
+				return !slices.Contains(next.Nodepools, nodepool.Name)
+			})
+			decision.Nodepools = plan.NodepoolNames()
+		}
+		if next.Reason != "" {
+			decision.Reason = next.Reason
+		}
+	}
+	if len(plan.Nodepools) == 0 {
+		return Decision{Reason: fmt.Sprintf("no nodepool is left in the plan: %s", decision.Reason)}, nil
+	}
+	return decision, nil
+}
+
+// NodepoolNames returns the names of the nodepools of the plan
+func (p Plan) NodepoolNames() []string {
+	names := make([]string, 0, len(p.Nodepools))
+	for _, nodepool := range p.Nodepools {
+		names = append(names, nodepool.Name)
+	}
+	return names
+}
@@ -0,0 +1,89 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// reviewerFunc reviews the plans with a function
+type reviewerFunc func(ctx context.Context, plan Plan) (Decision, error)
+
+func (f reviewerFunc) Review(ctx context.Context, plan Plan) (Decision, error) {
+	return f(ctx, plan)
+}
+
+func keep(names ...string) Reviewer {
+	return reviewerFunc(func(context.Context, Plan) (Decision, error) {
+		return Decision{Allowed: true, Nodepools: names}, nil
+	})
+}
+
+func testPlan() Plan {
+	return Plan{Namespace: "default", Name: "rotation", Nodepools: []Nodepool{{Name: "agentpool"}, {Name: "userpool"}, {Name: "gpupool"}}}
+}
+
+func TestReviewers_NarrowPlan(t *testing.T) {
+	var seen []string
+	last := reviewerFunc(func(_ context.Context, plan Plan) (Decision, error) {
+		seen = plan.NodepoolNames()
+		return Decision{Allowed: true, Reason: "approved"}, nil
+	})
+
+	decision, err := Reviewers{keep("agentpool", "userpool"), keep("userpool", "gpupool"), last}.Review(context.Background(), testPlan())
+
+	if err != nil {
+		t.Fatalf("Review returned error: %v", err)
+	}
+	if !decision.Allowed || !slices.Equal(decision.Nodepools, []string{"userpool"}) || decision.Reason != "approved" {
+		t.Errorf("expected userpool to be kept, got %+v", decision)
+	}
+	if !slices.Equal(seen, []string{"userpool"}) {
+		t.Errorf("expected the last reviewer to get the nodepools kept before it, got %v", seen)
+	}
+}
+
+func TestReviewers_FirstVetoWins(t *testing.T) {
+	called := false
+	veto := reviewerFunc(func(context.Context, Plan) (Decision, error) {
+		return Decision{Reason: "change freeze"}, nil
+	})
+	after := reviewerFunc(func(context.Context, Plan) (Decision, error) {
+		called = true
+		return Decision{Allowed: true}, nil
+	})
+
+	decision, err := Reviewers{veto, after}.Review(context.Background(), testPlan())
+
+	if err != nil {
+		t.Fatalf("Review returned error: %v", err)
+	}
+	if decision.Allowed || decision.Reason != "change freeze" {
+		t.Errorf("expected the plan to be vetoed, got %+v", decision)
+	}
+	if called {
+		t.Error("expected the reviewers after the veto not to be asked")
+	}
+}
+
+func TestReviewers_EmptyPlanIsVetoed(t *testing.T) {
+	decision, err := Reviewers{keep("agentpool"), keep("userpool")}.Review(context.Background(), testPlan())
+
+	if err != nil {
+		t.Fatalf("Review returned error: %v", err)
+	}
+	if decision.Allowed {
+		t.Errorf("expected a plan without nodepools to be vetoed, got %+v", decision)
+	}
+}
+
+func TestReviewers_ReturnsError(t *testing.T) {
+	failing := reviewerFunc(func(context.Context, Plan) (Decision, error) {
+		return Decision{}, errors.New("mock review error")
+	})
+
+	if _, err := (Reviewers{failing}).Review(context.Background(), testPlan()); err == nil {
+		t.Error("expected the error of the reviewer to be returned")
+	}
+}
@@ -0,0 +1,63 @@
+package plan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"norbinto/node-updater/internal/egress"
+)
+
+// webhookDecision is the body of the answer of a webhook, RetryAfter is given in seconds
+type webhookDecision struct {
+	Allowed           bool     `json:"allowed"`
+	Reason            string   `json:"reason,omitempty"`
+	Nodepools         []string `json:"nodepools,omitempty"`
+	RetryAfterSeconds int64    `json:"retryAfterSeconds,omitempty"`
+}
+
+// WebhookReviewer posts the plan as JSON to an external service and reads the decision from its answer
+type WebhookReviewer struct {
+	httpClient egress.Doer
+	url        string
+}
+
+func NewWebhookReviewer(httpClient egress.Doer, url string) *WebhookReviewer {
+	return &WebhookReviewer{httpClient: httpClient, url: url}
+}
+
+// Review implements Reviewer
+func (w *WebhookReviewer) Review(ctx context.Context, plan Plan) (Decision, error) {
+	body, err := json.Marshal(plan)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to encode the plan: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to create the request of the plan webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to call the plan webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Decision{}, fmt.Errorf("plan webhook returned status %d: %s", resp.StatusCode, string(message))
+	}
+	var decision webhookDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return Decision{}, fmt.Errorf("failed to decode the answer of the plan webhook: %w", err)
+	}
+	return Decision{
+		Allowed:    decision.Allowed,
+		Reason:     decision.Reason,
+		Nodepools:  decision.Nodepools,
+		RetryAfter: time.Duration(decision.RetryAfterSeconds) * time.Second,
+	}, nil
+}
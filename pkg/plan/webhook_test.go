@@ -0,0 +1,48 @@
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWebhookReviewer_Review(t *testing.T) {
+	var received Plan
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON POST, got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode the plan: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"allowed":false,"reason":"change freeze","nodepools":["userpool"],"retryAfterSeconds":600}`))
+	}))
+	defer server.Close()
+
+	decision, err := NewWebhookReviewer(server.Client(), server.URL).Review(context.Background(), testPlan())
+
+	if err != nil {
+		t.Fatalf("Review returned error: %v", err)
+	}
+	if received.Name != "rotation" || len(received.Nodepools) != 3 {
+		t.Errorf("expected the plan to be posted, got %+v", received)
+	}
+	if decision.Allowed || decision.Reason != "change freeze" || decision.RetryAfter != 10*time.Minute || !slices.Equal(decision.Nodepools, []string{"userpool"}) {
+		t.Errorf("expected the decision of the webhook, got %+v", decision)
+	}
+}
+
+func TestWebhookReviewer_FailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := NewWebhookReviewer(server.Client(), server.URL).Review(context.Background(), testPlan()); err == nil {
+		t.Error("expected an error for a failing webhook")
+	}
+}
@@ -0,0 +1,185 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"norbinto/node-updater/internal/azuredevops"
+)
+
+var _ azuredevops.AzureDevopsControllerInterface = &AgentProvider{}
+
+// Agent is an agent registered in a pool of the AgentProvider
+type Agent struct {
+	Name     string
+	HostName string
+	Enabled  bool
+	// Online is false for an agent which is registered but not connected to Azure DevOps
+	Online bool
+	// Busy is true while the agent runs a job
+	Busy bool
+}
+
+// AgentProvider is an in-memory agent provider which implements azuredevops.AzureDevopsControllerInterface.
+// Agents are looked up by name first and then by host name, the same way as in Azure DevOps.
+type AgentProvider struct {
+	// DisableErr, EnableErr, RemoveErr, CheckConnectionErr, GetOnlineAgentsErr, GetQueuedJobCountErr and
+	// GetBusyAgentCountErr are returned by the matching method when they are set
+	DisableErr           error
+	EnableErr            error
+	RemoveErr            error
+	CheckConnectionErr   error
+	GetOnlineAgentsErr   error
+	GetQueuedJobCountErr error
+	GetBusyAgentCountErr error
+
+	mu       sync.Mutex
+	agentSet map[string][]*Agent
+	// queuedJobs is the number of jobs waiting for an agent per pool
+	queuedJobs map[string]int
+}
+
+// NewAgentProvider creates an AgentProvider without agents
+func NewAgentProvider() *AgentProvider {
+	return &AgentProvider{agentSet: make(map[string][]*Agent), queuedJobs: make(map[string]int)}
+}
+
+// AddAgent registers an enabled, online agent in the pool
+func (p *AgentProvider) AddAgent(poolName string, agent azuredevops.Agent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.agentSet[poolName] = append(p.agentSet[poolName], &Agent{Name: agent.Name, HostName: agent.HostName, Enabled: true, Online: true})
+}
+
+// SetOnline connects or disconnects the agent registered in the pool
+func (p *AgentProvider) SetOnline(poolName string, agent azuredevops.Agent, online bool) error {
+	return p.updateAgent(poolName, agent, func(registered *Agent) { registered.Online = online })
+}
+
+// SetBusy assigns a job to the agent registered in the pool or finishes it
+func (p *AgentProvider) SetBusy(poolName string, agent azuredevops.Agent, busy bool) error {
+	return p.updateAgent(poolName, agent, func(registered *Agent) { registered.Busy = busy })
+}
+
+// Agent returns a copy of the agent registered in the pool, or nil when it is not registered
+func (p *AgentProvider) Agent(poolName string, agent azuredevops.Agent) *Agent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, registered := p.findAgent(poolName, agent)
+	if registered == nil {
+		return nil
+	}
+	agentCopy := *registered
+	return &agentCopy
+}
+
+func (p *AgentProvider) DisableAgent(_ context.Context, poolName string, agent azuredevops.Agent) error {
+	if p.DisableErr != nil {
+		return p.DisableErr
+	}
+	return p.setAgentEnabled(poolName, agent, false)
+}
+
+func (p *AgentProvider) EnableAgent(_ context.Context, poolName string, agent azuredevops.Agent) error {
+	if p.EnableErr != nil {
+		return p.EnableErr
+	}
+	return p.setAgentEnabled(poolName, agent, true)
+}
+
+func (p *AgentProvider) RemoveAgent(_ context.Context, poolName string, agent azuredevops.Agent) error {
+	if p.RemoveErr != nil {
+		return p.RemoveErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	index, registered := p.findAgent(poolName, agent)
+	if registered == nil {
+		return fmt.Errorf("agent with name '%s' not found", agent.Name)
+	}
+	p.agentSet[poolName] = append(p.agentSet[poolName][:index], p.agentSet[poolName][index+1:]...)
+	return nil
+}
+
+func (p *AgentProvider) CheckConnection(_ context.Context) error {
+	return p.CheckConnectionErr
+}
+
+func (p *AgentProvider) GetOnlineAgents(_ context.Context, poolName string) ([]azuredevops.Agent, error) {
+	if p.GetOnlineAgentsErr != nil {
+		return nil, p.GetOnlineAgentsErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var agents []azuredevops.Agent
+	for _, registered := range p.agentSet[poolName] {
+		if registered.Enabled && registered.Online {
+			agents = append(agents, azuredevops.Agent{Name: registered.Name, HostName: registered.HostName})
+		}
+	}
+	return agents, nil
+}
+
+// SetQueuedJobs sets the number of jobs waiting for an agent in the pool
+func (p *AgentProvider) SetQueuedJobs(poolName string, queuedJobs int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queuedJobs[poolName] = queuedJobs
+}
+
+func (p *AgentProvider) GetQueuedJobCount(_ context.Context, poolName string) (int, error) {
+	if p.GetQueuedJobCountErr != nil {
+		return 0, p.GetQueuedJobCountErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queuedJobs[poolName], nil
+}
+
+func (p *AgentProvider) GetBusyAgentCount(_ context.Context, poolName string) (int, error) {
+	if p.GetBusyAgentCountErr != nil {
+		return 0, p.GetBusyAgentCountErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	busy := 0
+	for _, registered := range p.agentSet[poolName] {
+		if registered.Busy {
+			busy++
+		}
+	}
+	return busy, nil
+}
+
+func (p *AgentProvider) setAgentEnabled(poolName string, agent azuredevops.Agent, enabled bool) error {
+	return p.updateAgent(poolName, agent, func(registered *Agent) { registered.Enabled = enabled })
+}
+
+func (p *AgentProvider) updateAgent(poolName string, agent azuredevops.Agent, update func(registered *Agent)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, registered := p.findAgent(poolName, agent)
+	if registered == nil {
+		return fmt.Errorf("agent with name '%s' not found", agent.Name)
+	}
+	update(registered)
+	return nil
+}
+
+func (p *AgentProvider) findAgent(poolName string, agent azuredevops.Agent) (int, *Agent) {
+	for i, registered := range p.agentSet[poolName] {
+		if registered.Name == agent.Name {
+			return i, registered
+		}
+	}
+	if agent.HostName == "" {
+		return -1, nil
+	}
+	for i, registered := range p.agentSet[poolName] {
+		if registered.HostName == agent.HostName {
+			return i, registered
+		}
+	}
+	return -1, nil
+}
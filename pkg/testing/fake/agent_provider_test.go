@@ -0,0 +1,45 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"norbinto/node-updater/internal/azuredevops"
+)
+
+func TestAgentProvider_FindsAgentByHostName(t *testing.T) {
+	provider := NewAgentProvider()
+	provider.AddAgent("pool", azuredevops.Agent{Name: "registered-name", HostName: "agent-0"})
+	agent := azuredevops.Agent{Name: "agent-0", HostName: "agent-0"}
+
+	if err := provider.DisableAgent(context.Background(), "pool", agent); err != nil {
+		t.Fatalf("DisableAgent returned error: %v", err)
+	}
+	if registered := provider.Agent("pool", agent); registered == nil || registered.Enabled {
+		t.Fatalf("expected the agent to be disabled, got %+v", registered)
+	}
+
+	if err := provider.RemoveAgent(context.Background(), "pool", agent); err != nil {
+		t.Fatalf("RemoveAgent returned error: %v", err)
+	}
+	if registered := provider.Agent("pool", agent); registered != nil {
+		t.Errorf("expected the agent to be removed, got %+v", registered)
+	}
+	if err := provider.EnableAgent(context.Background(), "pool", agent); err == nil {
+		t.Error("expected an error for a removed agent")
+	}
+}
+
+func TestAgentProvider_ReturnsInjectedErrors(t *testing.T) {
+	provider := NewAgentProvider()
+	provider.AddAgent("pool", azuredevops.Agent{Name: "agent-0"})
+	provider.RemoveErr = errors.New("remove failed")
+
+	if err := provider.RemoveAgent(context.Background(), "pool", azuredevops.Agent{Name: "agent-0"}); !errors.Is(err, provider.RemoveErr) {
+		t.Errorf("expected the injected error, got %v", err)
+	}
+	if provider.Agent("pool", azuredevops.Agent{Name: "agent-0"}) == nil {
+		t.Error("expected the agent to stay registered")
+	}
+}
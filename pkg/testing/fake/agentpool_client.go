@@ -0,0 +1,338 @@
+// Package fake contains in-memory implementations of the Azure clients of the controller, so strategies built on top
+// of the controller can be unit-tested without an AKS cluster or an Azure DevOps organization.
+package fake
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	azfake "github.com/Azure/azure-sdk-for-go/sdk/azcore/fake"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	"k8s.io/utils/clock"
+
+	"norbinto/node-updater/internal/nodepool"
+)
+
+const (
+	// SubscriptionID is the subscription of the fake AKS cluster
+	SubscriptionID = "00000000-0000-0000-0000-000000000000"
+
+	ProvisioningStateSucceeded                 = string(nodepool.ProvisioningStateSucceeded)
+	ProvisioningStateFailed                    = string(nodepool.ProvisioningStateFailed)
+	ProvisioningStateCanceled                  = string(nodepool.ProvisioningStateCanceled)
+	ProvisioningStateCreating                  = string(nodepool.ProvisioningStateCreating)
+	ProvisioningStateUpdating                  = string(nodepool.ProvisioningStateUpdating)
+	ProvisioningStateDeleting                  = string(nodepool.ProvisioningStateDeleting)
+	ProvisioningStateUpgradingNodeImageVersion = string(nodepool.ProvisioningStateUpgradingNodeImageVersion)
+)
+
+var _ nodepool.AgentPoolClientInterface = &AgentPoolClient{}
+
+type agentPoolState struct {
+	agentPool armcontainerservice.AgentPool
+	// transitionalState is reported as the provisioning state until transitionEnd
+	transitionalState string
+	transitionEnd     time.Time
+	// failedState is reported as the provisioning state until it is cleared, e.g. Failed after a broken operation
+	failedState string
+}
+
+// AgentPoolClient is an in-memory AgentPools API of a single AKS cluster, the resource group and the cluster name of
+// the requests are ignored. Every mutation puts the agent pool into the matching transitional provisioning state
+// (Creating, Updating, UpgradingNodeImageVersion or Deleting) for ProvisioningDuration, measured on Clock.
+type AgentPoolClient struct {
+	*armcontainerservice.AgentPoolsClient
+
+	// ProvisioningDuration is how long an agent pool stays in a transitional provisioning state, zero finishes every
+	// operation immediately
+	ProvisioningDuration time.Duration
+	// Clock drives the provisioning state transitions, use a fake clock to step through them
+	Clock clock.PassiveClock
+	// OnCreate is called when a new agent pool is created, it can add the nodes of the pool to a fake cluster
+	OnCreate func(name string)
+	// OnUpgrade is called when the node image of an agent pool is upgraded, it can update the nodes of the pool
+	OnUpgrade func(name, nodeImageVersion string)
+	// ListPageSize is the number of agent pools on a page of the list, zero lists every agent pool on one page
+	ListPageSize int
+
+	mu                     sync.Mutex
+	agentPoolSet           map[string]*agentPoolState
+	latestNodeImageVersion string
+	upgradeCount           map[string]int
+	rejectedUpdates        map[string]int
+}
+
+// NewAgentPoolClient creates an AgentPoolClient without agent pools, latestNodeImageVersion is reported by the
+// upgrade profile of every pool and used for new pools
+func NewAgentPoolClient(latestNodeImageVersion string) (*AgentPoolClient, error) {
+	client := &AgentPoolClient{
+		Clock:                  clock.RealClock{},
+		agentPoolSet:           make(map[string]*agentPoolState),
+		latestNodeImageVersion: latestNodeImageVersion,
+		upgradeCount:           make(map[string]int),
+		rejectedUpdates:        make(map[string]int),
+	}
+	agentPoolsClient, err := armcontainerservice.NewAgentPoolsClient(SubscriptionID, &azfake.TokenCredential{}, &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Transport: client,
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+		DisableRPRegistration: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent pools client: %w", err)
+	}
+	client.AgentPoolsClient = agentPoolsClient
+	return client, nil
+}
+
+// AddAgentPool registers an existing agent pool which has finished provisioning
+func (c *AgentPoolClient) AddAgentPool(name string, properties armcontainerservice.ManagedClusterAgentPoolProfileProperties) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	properties.ProvisioningState = to.Ptr(ProvisioningStateSucceeded)
+	c.agentPoolSet[name] = &agentPoolState{agentPool: armcontainerservice.AgentPool{Name: to.Ptr(name), Properties: &properties}}
+}
+
+// SetFailedState makes the agent pool report the provisioning state, e.g. Failed or Canceled, until it is cleared with
+// an empty state. A failed agent pool still accepts mutations, like ARM does.
+func (c *AgentPoolClient) SetFailedState(name, provisioningState string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, exists := c.agentPoolSet[name]; exists {
+		state.failedState = provisioningState
+	}
+}
+
+// RejectUpdates refuses the next count updates of the agent pool with a conflict, like ARM does when another client
+// started an operation on the pool after it was read
+func (c *AgentPoolClient) RejectUpdates(name string, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rejectedUpdates[name] = count
+}
+
+// AgentPool returns a copy of the agent pool as it is reported by Get, or nil when it does not exist
+func (c *AgentPoolClient) AgentPool(name string) *armcontainerservice.AgentPool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.currentState(name)
+	if state == nil {
+		return nil
+	}
+	return copyAgentPool(state.agentPool)
+}
+
+// UpgradeCount returns how many times the node image upgrade of the agent pool was started
+func (c *AgentPoolClient) UpgradeCount(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.upgradeCount[name]
+}
+
+// currentState applies the finished transitions of the agent pool, it returns nil when the pool does not exist
+func (c *AgentPoolClient) currentState(name string) *agentPoolState {
+	state, exists := c.agentPoolSet[name]
+	if !exists {
+		return nil
+	}
+	if state.transitionalState != "" && !c.Clock.Now().Before(state.transitionEnd) {
+		if state.transitionalState == ProvisioningStateDeleting {
+			delete(c.agentPoolSet, name)
+			return nil
+		}
+		state.transitionalState = ""
+	}
+	provisioningState := ProvisioningStateSucceeded
+	if state.failedState != "" {
+		provisioningState = state.failedState
+	} else if state.transitionalState != "" {
+		provisioningState = state.transitionalState
+	}
+	state.agentPool.Properties.ProvisioningState = to.Ptr(provisioningState)
+	return state
+}
+
+func (c *AgentPoolClient) startTransition(state *agentPoolState, transitionalState string) {
+	state.transitionalState = transitionalState
+	state.transitionEnd = c.Clock.Now().Add(c.ProvisioningDuration)
+}
+
+// Do implements policy.Transporter, it serves the requests of the embedded AgentPoolsClient from memory
+func (c *AgentPoolClient) Do(req *http.Request) (*http.Response, error) {
+	// /subscriptions/{sub}/resourceGroups/{rg}/providers/Microsoft.ContainerService/managedClusters/{cluster}/agentPools/{pool}[/...]
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) == 9 && strings.EqualFold(parts[8], "agentPools") && req.Method == http.MethodGet {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.list(req), nil
+	}
+	if len(parts) < 10 || !strings.EqualFold(parts[8], "agentPools") {
+		return newErrorResponse(req, http.StatusNotFound, "NotFound"), nil
+	}
+	name := parts[9]
+	subResource := strings.Join(parts[10:], "/")
+
+	c.mu.Lock()
+	response, event := c.serve(req, name, subResource, c.currentState(name))
+	onCreate, onUpgrade := c.OnCreate, c.OnUpgrade
+	nodeImageVersion := c.latestNodeImageVersion
+	c.mu.Unlock()
+
+	// the hooks run without the lock, so they can call back into the client
+	switch {
+	case event == eventCreated && onCreate != nil:
+		onCreate(name)
+	case event == eventUpgraded && onUpgrade != nil:
+		onUpgrade(name, nodeImageVersion)
+	}
+	return response, nil
+}
+
+type event int
+
+const (
+	eventNone event = iota
+	eventCreated
+	eventUpgraded
+)
+
+func (c *AgentPoolClient) serve(req *http.Request, name, subResource string, state *agentPoolState) (*http.Response, event) {
+	switch {
+	case req.Method == http.MethodGet && subResource == "":
+		if state == nil {
+			return newErrorResponse(req, http.StatusNotFound, "NotFound"), eventNone
+		}
+		return newJSONResponse(req, http.StatusOK, state.agentPool), eventNone
+	case req.Method == http.MethodGet && subResource == "upgradeProfiles/default":
+		if state == nil {
+			return newErrorResponse(req, http.StatusNotFound, "NotFound"), eventNone
+		}
+		return newJSONResponse(req, http.StatusOK, armcontainerservice.AgentPoolUpgradeProfile{
+			Name: to.Ptr("default"),
+			Properties: &armcontainerservice.AgentPoolUpgradeProfileProperties{
+				LatestNodeImageVersion: to.Ptr(c.latestNodeImageVersion),
+			},
+		}), eventNone
+	case req.Method == http.MethodPut && subResource == "":
+		update := armcontainerservice.AgentPool{}
+		if err := json.NewDecoder(req.Body).Decode(&update); err != nil || update.Properties == nil {
+			return newErrorResponse(req, http.StatusBadRequest, "InvalidRequestContent"), eventNone
+		}
+		if state != nil && state.transitionalState != "" {
+			return newErrorResponse(req, http.StatusConflict, "OperationNotAllowed"), eventNone
+		}
+		if state != nil && c.rejectedUpdates[name] > 0 {
+			c.rejectedUpdates[name]--
+			return newErrorResponse(req, http.StatusConflict, "OperationNotAllowed"), eventNone
+		}
+		update.Name = to.Ptr(name)
+		result := eventNone
+		if state == nil {
+			result = eventCreated
+			update.Properties.NodeImageVersion = to.Ptr(c.latestNodeImageVersion)
+			state = &agentPoolState{}
+			c.startTransition(state, ProvisioningStateCreating)
+		} else {
+			update.Properties.NodeImageVersion = state.agentPool.Properties.NodeImageVersion
+			c.startTransition(state, ProvisioningStateUpdating)
+		}
+		state.agentPool = update
+		c.agentPoolSet[name] = state
+		return newJSONResponse(req, http.StatusOK, c.currentState(name).agentPool), result
+	case req.Method == http.MethodDelete && subResource == "":
+		if state == nil {
+			return newResponse(req, http.StatusNoContent, nil), eventNone
+		}
+		c.startTransition(state, ProvisioningStateDeleting)
+		c.currentState(name)
+		// the deletion is accepted as a long-running operation, which is polled through the agent pool itself
+		response := newResponse(req, http.StatusAccepted, nil)
+		response.Header.Set("Location", req.URL.String())
+		return response, eventNone
+	case req.Method == http.MethodPost && subResource == "upgradeNodeImageVersion":
+		if state == nil {
+			return newErrorResponse(req, http.StatusNotFound, "NotFound"), eventNone
+		}
+		if state.transitionalState != "" {
+			return newErrorResponse(req, http.StatusConflict, "OperationNotAllowed"), eventNone
+		}
+		c.upgradeCount[name]++
+		if state.agentPool.Properties.NodeImageVersion != nil && *state.agentPool.Properties.NodeImageVersion == c.latestNodeImageVersion {
+			// the pool is already on the latest node image, so there is nothing to reimage
+			return newJSONResponse(req, http.StatusOK, state.agentPool), eventNone
+		}
+		state.agentPool.Properties.NodeImageVersion = to.Ptr(c.latestNodeImageVersion)
+		c.startTransition(state, ProvisioningStateUpgradingNodeImageVersion)
+		return newJSONResponse(req, http.StatusOK, c.currentState(name).agentPool), eventUpgraded
+	}
+	return newErrorResponse(req, http.StatusMethodNotAllowed, "MethodNotAllowed"), eventNone
+}
+
+// list serves a page of the agent pools in name order, the next page starts at the $skipToken of the next link
+func (c *AgentPoolClient) list(req *http.Request) *http.Response {
+	var agentPools []*armcontainerservice.AgentPool
+	for _, name := range slices.Sorted(maps.Keys(c.agentPoolSet)) {
+		if state := c.currentState(name); state != nil {
+			agentPools = append(agentPools, copyAgentPool(state.agentPool))
+		}
+	}
+	start, _ := strconv.Atoi(req.URL.Query().Get("$skipToken"))
+	start = min(start, len(agentPools))
+	end := len(agentPools)
+	if c.ListPageSize > 0 {
+		end = min(start+c.ListPageSize, end)
+	}
+	page := armcontainerservice.AgentPoolListResult{Value: agentPools[start:end]}
+	if end < len(agentPools) {
+		nextLink := *req.URL
+		query := nextLink.Query()
+		query.Set("$skipToken", strconv.Itoa(end))
+		nextLink.RawQuery = query.Encode()
+		page.NextLink = to.Ptr(nextLink.String())
+	}
+	return newJSONResponse(req, http.StatusOK, page)
+}
+
+func copyAgentPool(agentPool armcontainerservice.AgentPool) *armcontainerservice.AgentPool {
+	properties := *agentPool.Properties
+	return &armcontainerservice.AgentPool{Name: agentPool.Name, Properties: &properties}
+}
+
+func newResponse(req *http.Request, statusCode int, body []byte) *http.Response {
+	header := http.Header{}
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+	}
+	return &http.Response{
+		StatusCode:    statusCode,
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func newJSONResponse(req *http.Request, statusCode int, value any) *http.Response {
+	body, _ := json.Marshal(value)
+	return newResponse(req, statusCode, body)
+}
+
+func newErrorResponse(req *http.Request, statusCode int, code string) *http.Response {
+	response := newJSONResponse(req, statusCode, map[string]any{"error": map[string]string{"code": code, "message": code}})
+	response.Header.Set("x-ms-error-code", code)
+	return response
+}
@@ -0,0 +1,172 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+const latestNodeImageVersion = "AKSUbuntu-2204gen2containerd-202502.01.0"
+
+func newTestAgentPoolClient(t *testing.T) (*AgentPoolClient, *testingclock.FakePassiveClock) {
+	client, err := NewAgentPoolClient(latestNodeImageVersion)
+	if err != nil {
+		t.Fatalf("NewAgentPoolClient returned error: %v", err)
+	}
+	fakeClock := testingclock.NewFakePassiveClock(time.Now())
+	client.Clock = fakeClock
+	client.ProvisioningDuration = time.Minute
+	return client, fakeClock
+}
+
+func getProvisioningState(t *testing.T, client *AgentPoolClient, name string) string {
+	response, err := client.Get(context.Background(), "rg", "cluster", name, nil)
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	return *response.Properties.ProvisioningState
+}
+
+func TestAgentPoolClient_CreateTransitionsToSucceeded(t *testing.T) {
+	client, fakeClock := newTestAgentPoolClient(t)
+	created := ""
+	client.OnCreate = func(name string) { created = name }
+
+	_, err := client.BeginCreateOrUpdate(context.Background(), "rg", "cluster", "tmp", armcontainerservice.AgentPool{
+		Properties: &armcontainerservice.ManagedClusterAgentPoolProfileProperties{Count: to.Ptr(int32(1))},
+	}, nil)
+	if err != nil {
+		t.Fatalf("BeginCreateOrUpdate returned error: %v", err)
+	}
+	if created != "tmp" {
+		t.Errorf("expected OnCreate to be called for 'tmp', got '%s'", created)
+	}
+	if state := getProvisioningState(t, client, "tmp"); state != ProvisioningStateCreating {
+		t.Errorf("expected provisioning state %s, got %s", ProvisioningStateCreating, state)
+	}
+
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+	if state := getProvisioningState(t, client, "tmp"); state != ProvisioningStateSucceeded {
+		t.Errorf("expected provisioning state %s, got %s", ProvisioningStateSucceeded, state)
+	}
+	if version := *client.AgentPool("tmp").Properties.NodeImageVersion; version != latestNodeImageVersion {
+		t.Errorf("expected new pool to get node image %s, got %s", latestNodeImageVersion, version)
+	}
+}
+
+func TestAgentPoolClient_RejectsOperationDuringTransition(t *testing.T) {
+	client, _ := newTestAgentPoolClient(t)
+	client.AddAgentPool("pool", armcontainerservice.ManagedClusterAgentPoolProfileProperties{NodeImageVersion: to.Ptr("old")})
+	upgraded := ""
+	client.OnUpgrade = func(name, nodeImageVersion string) { upgraded = nodeImageVersion }
+
+	if _, err := client.BeginUpgradeNodeImageVersion(context.Background(), "rg", "cluster", "pool", nil); err != nil {
+		t.Fatalf("BeginUpgradeNodeImageVersion returned error: %v", err)
+	}
+	if upgraded != latestNodeImageVersion {
+		t.Errorf("expected OnUpgrade to be called with %s, got '%s'", latestNodeImageVersion, upgraded)
+	}
+	if state := getProvisioningState(t, client, "pool"); state != ProvisioningStateUpgradingNodeImageVersion {
+		t.Errorf("expected provisioning state %s, got %s", ProvisioningStateUpgradingNodeImageVersion, state)
+	}
+
+	_, err := client.BeginCreateOrUpdate(context.Background(), "rg", "cluster", "pool", *client.AgentPool("pool"), nil)
+	var responseErr *azcore.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected a conflict while the pool is upgrading, got %v", err)
+	}
+	if client.UpgradeCount("pool") != 1 {
+		t.Errorf("expected 1 upgrade, got %d", client.UpgradeCount("pool"))
+	}
+}
+
+func TestAgentPoolClient_DeleteRemovesPoolAfterTransition(t *testing.T) {
+	client, fakeClock := newTestAgentPoolClient(t)
+	client.AddAgentPool("tmp", armcontainerservice.ManagedClusterAgentPoolProfileProperties{})
+
+	if _, err := client.BeginDelete(context.Background(), "rg", "cluster", "tmp", nil); err != nil {
+		t.Fatalf("BeginDelete returned error: %v", err)
+	}
+	if state := getProvisioningState(t, client, "tmp"); state != ProvisioningStateDeleting {
+		t.Errorf("expected provisioning state %s, got %s", ProvisioningStateDeleting, state)
+	}
+
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+	_, err := client.Get(context.Background(), "rg", "cluster", "tmp", nil)
+	var responseErr *azcore.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the pool to be gone, got %v", err)
+	}
+}
+
+func TestAgentPoolClient_ReportsFailedStateUntilCleared(t *testing.T) {
+	client, fakeClock := newTestAgentPoolClient(t)
+	client.AddAgentPool("pool", armcontainerservice.ManagedClusterAgentPoolProfileProperties{})
+	client.SetFailedState("pool", ProvisioningStateFailed)
+
+	if _, err := client.BeginCreateOrUpdate(context.Background(), "rg", "cluster", "pool", *client.AgentPool("pool"), nil); err != nil {
+		t.Fatalf("BeginCreateOrUpdate returned error: %v", err)
+	}
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+	if state := getProvisioningState(t, client, "pool"); state != ProvisioningStateFailed {
+		t.Errorf("expected provisioning state %s, got %s", ProvisioningStateFailed, state)
+	}
+
+	client.SetFailedState("pool", "")
+	if state := getProvisioningState(t, client, "pool"); state != ProvisioningStateSucceeded {
+		t.Errorf("expected provisioning state %s, got %s", ProvisioningStateSucceeded, state)
+	}
+}
+
+func TestAgentPoolClient_RejectsUpdates(t *testing.T) {
+	client, _ := newTestAgentPoolClient(t)
+	client.AddAgentPool("pool", armcontainerservice.ManagedClusterAgentPoolProfileProperties{})
+	client.RejectUpdates("pool", 1)
+
+	_, err := client.BeginCreateOrUpdate(context.Background(), "rg", "cluster", "pool", *client.AgentPool("pool"), nil)
+	var responseErr *azcore.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected the rejected update to conflict, got %v", err)
+	}
+	if _, err := client.BeginCreateOrUpdate(context.Background(), "rg", "cluster", "pool", *client.AgentPool("pool"), nil); err != nil {
+		t.Errorf("expected the next update to be accepted, got %v", err)
+	}
+}
+
+func TestAgentPoolClient_ListsAgentPoolsInPages(t *testing.T) {
+	client, _ := newTestAgentPoolClient(t)
+	client.ListPageSize = 2
+	for _, name := range []string{"userpool", "agentpool", "system"} {
+		client.AddAgentPool(name, armcontainerservice.ManagedClusterAgentPoolProfileProperties{})
+	}
+	client.SetFailedState("system", ProvisioningStateFailed)
+
+	var names []string
+	pages := 0
+	pager := client.NewListPager("rg", "cluster", nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("NextPage returned error: %v", err)
+		}
+		pages++
+		for _, agentPool := range page.Value {
+			names = append(names, *agentPool.Name+"="+*agentPool.Properties.ProvisioningState)
+		}
+	}
+
+	if pages != 2 {
+		t.Errorf("expected 2 pages, got %d", pages)
+	}
+	if expected := "agentpool=Succeeded,system=Failed,userpool=Succeeded"; strings.Join(names, ",") != expected {
+		t.Errorf("expected %s, got %v", expected, names)
+	}
+}
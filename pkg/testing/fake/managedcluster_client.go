@@ -0,0 +1,78 @@
+package fake
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	azfake "github.com/Azure/azure-sdk-for-go/sdk/azcore/fake"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+
+	"norbinto/node-updater/internal/nodepool"
+)
+
+var _ nodepool.ManagedClusterClientInterface = &ManagedClusterClient{}
+
+// ManagedClusterClient is an in-memory ManagedClusters API of a single AKS cluster, the resource group and the cluster
+// name of the requests are ignored. The cluster is running and has finished provisioning until its state is changed.
+type ManagedClusterClient struct {
+	*armcontainerservice.ManagedClustersClient
+
+	mu                sync.Mutex
+	provisioningState string
+	powerState        armcontainerservice.Code
+}
+
+// NewManagedClusterClient creates a ManagedClusterClient of a running cluster
+func NewManagedClusterClient() (*ManagedClusterClient, error) {
+	client := &ManagedClusterClient{
+		provisioningState: ProvisioningStateSucceeded,
+		powerState:        armcontainerservice.CodeRunning,
+	}
+	managedClustersClient, err := armcontainerservice.NewManagedClustersClient(SubscriptionID, &azfake.TokenCredential{}, &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Transport: client,
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+		DisableRPRegistration: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create managed clusters client: %w", err)
+	}
+	client.ManagedClustersClient = managedClustersClient
+	return client, nil
+}
+
+// SetState changes the provisioning state and the power state reported for the cluster
+func (c *ManagedClusterClient) SetState(provisioningState string, powerState armcontainerservice.Code) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.provisioningState = provisioningState
+	c.powerState = powerState
+}
+
+// Do implements policy.Transporter, it serves the requests of the embedded ManagedClustersClient from memory
+func (c *ManagedClusterClient) Do(req *http.Request) (*http.Response, error) {
+	// /subscriptions/{sub}/resourceGroups/{rg}/providers/Microsoft.ContainerService/managedClusters/{cluster}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) != 8 || !strings.EqualFold(parts[6], "managedClusters") {
+		return newErrorResponse(req, http.StatusNotFound, "NotFound"), nil
+	}
+	if req.Method != http.MethodGet {
+		return newErrorResponse(req, http.StatusMethodNotAllowed, "MethodNotAllowed"), nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return newJSONResponse(req, http.StatusOK, armcontainerservice.ManagedCluster{
+		Name: to.Ptr(parts[7]),
+		Properties: &armcontainerservice.ManagedClusterProperties{
+			ProvisioningState: to.Ptr(c.provisioningState),
+			PowerState:        &armcontainerservice.PowerState{Code: to.Ptr(c.powerState)},
+		},
+	}), nil
+}
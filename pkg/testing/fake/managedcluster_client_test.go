@@ -0,0 +1,32 @@
+package fake
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+)
+
+func TestManagedClusterClient_ReportsState(t *testing.T) {
+	client, err := NewManagedClusterClient()
+	if err != nil {
+		t.Fatalf("NewManagedClusterClient returned error: %v", err)
+	}
+
+	response, err := client.Get(context.Background(), "rg", "cluster", nil)
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	if *response.Properties.ProvisioningState != ProvisioningStateSucceeded || *response.Properties.PowerState.Code != armcontainerservice.CodeRunning {
+		t.Errorf("expected a running cluster, got %s and %s", *response.Properties.ProvisioningState, *response.Properties.PowerState.Code)
+	}
+
+	client.SetState(ProvisioningStateUpdating, armcontainerservice.CodeStopped)
+	response, err = client.Get(context.Background(), "rg", "cluster", nil)
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	if *response.Properties.ProvisioningState != ProvisioningStateUpdating || *response.Properties.PowerState.Code != armcontainerservice.CodeStopped {
+		t.Errorf("expected the changed state, got %s and %s", *response.Properties.ProvisioningState, *response.Properties.PowerState.Code)
+	}
+}
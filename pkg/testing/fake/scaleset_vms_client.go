@@ -0,0 +1,102 @@
+package fake
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	azfake "github.com/Azure/azure-sdk-for-go/sdk/azcore/fake"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+
+	"norbinto/node-updater/internal/nodepool"
+)
+
+var _ nodepool.ScaleSetVMsClientInterface = &ScaleSetVMsClient{}
+
+// ScaleSetVMsClient is an in-memory VirtualMachineScaleSetVMs API, the resource group of the requests is ignored. Every
+// instance exists and has finished provisioning until its state is changed, a reimage finishes immediately.
+type ScaleSetVMsClient struct {
+	*armcompute.VirtualMachineScaleSetVMsClient
+
+	// OnReimage is called when an instance is reimaged, it can update the node of the instance in a fake cluster
+	OnReimage func(scaleSet, instanceID string)
+
+	mu                 sync.Mutex
+	provisioningStates map[string]string
+	reimageCount       map[string]int
+}
+
+// NewScaleSetVMsClient creates a ScaleSetVMsClient
+func NewScaleSetVMsClient() (*ScaleSetVMsClient, error) {
+	client := &ScaleSetVMsClient{
+		provisioningStates: make(map[string]string),
+		reimageCount:       make(map[string]int),
+	}
+	scaleSetVMsClient, err := armcompute.NewVirtualMachineScaleSetVMsClient(SubscriptionID, &azfake.TokenCredential{}, &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Transport: client,
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+		DisableRPRegistration: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scale set VMs client: %w", err)
+	}
+	client.VirtualMachineScaleSetVMsClient = scaleSetVMsClient
+	return client, nil
+}
+
+// SetProvisioningState changes the provisioning state reported for the instance, e.g. Updating while it is reimaged
+func (c *ScaleSetVMsClient) SetProvisioningState(scaleSet, instanceID, provisioningState string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.provisioningStates[scaleSet+"/"+instanceID] = provisioningState
+}
+
+// ReimageCount returns how many times the instance was reimaged
+func (c *ScaleSetVMsClient) ReimageCount(scaleSet, instanceID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reimageCount[scaleSet+"/"+instanceID]
+}
+
+// Do implements policy.Transporter, it serves the requests of the embedded VirtualMachineScaleSetVMsClient from memory
+func (c *ScaleSetVMsClient) Do(req *http.Request) (*http.Response, error) {
+	// /subscriptions/{sub}/resourceGroups/{rg}/providers/Microsoft.Compute/virtualMachineScaleSets/{vmss}/virtualMachines/{id}[/reimage]
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 10 || len(parts) > 11 || !strings.EqualFold(parts[6], "virtualMachineScaleSets") || !strings.EqualFold(parts[8], "virtualMachines") {
+		return newErrorResponse(req, http.StatusNotFound, "NotFound"), nil
+	}
+	scaleSet, instanceID := parts[7], parts[9]
+	key := scaleSet + "/" + instanceID
+
+	switch {
+	case req.Method == http.MethodGet && len(parts) == 10:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		provisioningState, changed := c.provisioningStates[key]
+		if !changed {
+			provisioningState = ProvisioningStateSucceeded
+		}
+		return newJSONResponse(req, http.StatusOK, armcompute.VirtualMachineScaleSetVM{
+			Name:       to.Ptr(scaleSet + "_" + instanceID),
+			InstanceID: to.Ptr(instanceID),
+			Properties: &armcompute.VirtualMachineScaleSetVMProperties{ProvisioningState: to.Ptr(provisioningState)},
+		}), nil
+	case req.Method == http.MethodPost && len(parts) == 11 && parts[10] == "reimage":
+		c.mu.Lock()
+		c.reimageCount[key]++
+		onReimage := c.OnReimage
+		c.mu.Unlock()
+		// the hook may call back into the client, so it runs without the lock
+		if onReimage != nil {
+			onReimage(scaleSet, instanceID)
+		}
+		return newResponse(req, http.StatusOK, nil), nil
+	}
+	return newErrorResponse(req, http.StatusMethodNotAllowed, "MethodNotAllowed"), nil
+}
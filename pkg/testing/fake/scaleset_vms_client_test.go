@@ -0,0 +1,37 @@
+package fake
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScaleSetVMsClient_ReimagesInstance(t *testing.T) {
+	client, err := NewScaleSetVMsClient()
+	if err != nil {
+		t.Fatalf("NewScaleSetVMsClient returned error: %v", err)
+	}
+	var reimaged []string
+	client.OnReimage = func(scaleSet, instanceID string) {
+		reimaged = append(reimaged, scaleSet+"/"+instanceID)
+	}
+
+	poller, err := client.BeginReimage(context.Background(), "rg", "aks-pool-vmss", "3", nil)
+	if err != nil {
+		t.Fatalf("BeginReimage returned error: %v", err)
+	}
+	if _, err := poller.PollUntilDone(context.Background(), nil); err != nil {
+		t.Fatalf("PollUntilDone returned error: %v", err)
+	}
+	if client.ReimageCount("aks-pool-vmss", "3") != 1 || len(reimaged) != 1 || reimaged[0] != "aks-pool-vmss/3" {
+		t.Errorf("expected the instance to be reimaged once, got %d reimages and hook calls %v", client.ReimageCount("aks-pool-vmss", "3"), reimaged)
+	}
+
+	client.SetProvisioningState("aks-pool-vmss", "3", ProvisioningStateUpdating)
+	response, err := client.Get(context.Background(), "rg", "aks-pool-vmss", "3", nil)
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	if *response.Properties.ProvisioningState != ProvisioningStateUpdating {
+		t.Errorf("expected the changed provisioning state, got %s", *response.Properties.ProvisioningState)
+	}
+}
@@ -0,0 +1,152 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type fakeAgent struct {
+	ID      int
+	Name    string
+	Enabled bool
+}
+
+// fakeDevopsServer is an in-memory implementation of the agent pool API of an Azure DevOps organization
+type fakeDevopsServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	poolSet  map[int]string
+	agentSet map[int][]*fakeAgent
+}
+
+func newFakeDevopsServer() *fakeDevopsServer {
+	server := &fakeDevopsServer{
+		poolSet:  make(map[int]string),
+		agentSet: make(map[int][]*fakeAgent),
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	return server
+}
+
+func (s *fakeDevopsServer) addAgent(poolID int, poolName string, agentID int, agentName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.poolSet[poolID] = poolName
+	s.agentSet[poolID] = append(s.agentSet[poolID], &fakeAgent{ID: agentID, Name: agentName, Enabled: true})
+}
+
+// getAgent returns a copy of the agent, or nil when it is not registered
+func (s *fakeDevopsServer) getAgent(poolID int, agentName string) *fakeAgent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, agent := range s.agentSet[poolID] {
+		if agent.Name == agentName {
+			agentCopy := *agent
+			return &agentCopy
+		}
+	}
+	return nil
+}
+
+// handle serves /{organization}/_apis/distributedtask/pools[/{poolID}/agents[/{agentID}]]
+func (s *fakeDevopsServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 4 || parts[1] != "_apis" || parts[2] != "distributedtask" || parts[3] != "pools" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	parts = parts[4:]
+
+	if len(parts) == 0 && r.Method == http.MethodGet {
+		pools := make([]map[string]any, 0, len(s.poolSet))
+		for id, name := range s.poolSet {
+			pools = append(pools, map[string]any{"id": id, "name": name})
+		}
+		writeList(w, pools)
+		return
+	}
+
+	poolID, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) < 2 || parts[1] != "agents" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 2 && r.Method == http.MethodGet {
+		agents := make([]map[string]any, 0, len(s.agentSet[poolID]))
+		for _, agent := range s.agentSet[poolID] {
+			agents = append(agents, map[string]any{
+				"id":                 agent.ID,
+				"name":               agent.Name,
+				"enabled":            agent.Enabled,
+				"systemCapabilities": map[string]string{"HOSTNAME": agent.Name},
+			})
+		}
+		writeList(w, agents)
+		return
+	}
+
+	agentID, err := strconv.Atoi(parts[2])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	for i, agent := range s.agentSet[poolID] {
+		if agent.ID != agentID {
+			continue
+		}
+		switch r.Method {
+		case http.MethodPatch:
+			update := struct {
+				Enabled bool `json:"enabled"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			agent.Enabled = update.Enabled
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			s.agentSet[poolID] = append(s.agentSet[poolID][:i], s.agentSet[poolID][i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func writeList(w http.ResponseWriter, items []map[string]any) {
+	body, _ := json.Marshal(map[string]any{"count": len(items), "value": items})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// redirectingDoer sends every request to the target server, so clients with hard-coded hosts can talk to a fake server
+type redirectingDoer struct {
+	target *url.URL
+	client *http.Client
+}
+
+func newRedirectingDoer(server *httptest.Server) *redirectingDoer {
+	target, _ := url.Parse(server.URL)
+	return &redirectingDoer{target: target, client: server.Client()}
+}
+
+func (d *redirectingDoer) Do(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = d.target.Scheme
+	req.URL.Host = d.target.Host
+	req.Host = d.target.Host
+	return d.client.Do(req)
+}
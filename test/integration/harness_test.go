@@ -0,0 +1,100 @@
+package integration
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"norbinto/node-updater/internal/appconfig"
+	"norbinto/node-updater/internal/azuredevops"
+	"norbinto/node-updater/internal/configmap"
+	"norbinto/node-updater/internal/controller"
+	"norbinto/node-updater/internal/job"
+	"norbinto/node-updater/internal/nodepool"
+	"norbinto/node-updater/internal/pod"
+	"norbinto/node-updater/pkg/testing/fake"
+)
+
+const (
+	clusterResourceGroup = "cluster-rg"
+	clusterName          = "cluster"
+	devopsOrganization   = "organization"
+)
+
+// newReconciler wires the SafeEvict reconciler the same way as the manager does, against the fake Azure APIs
+func newReconciler(crClient client.Client, kubeClient kubernetes.Interface, agentPoolClient *fake.AgentPoolClient, devopsServer *fakeDevopsServer, logger *zap.Logger) *controller.SafeEvictReconciler {
+	azureDevopsController := azuredevops.NewAzureDevopsController(newRedirectingDoer(devopsServer.Server), devopsOrganization, "token", logger.Named("azureDevOps"))
+
+	return &controller.SafeEvictReconciler{
+		Client:     crClient,
+		Scheme:     crClient.Scheme(),
+		KubeClient: kubeClient,
+		PodController: pod.NewPodController(
+			kubeClient,
+			azureDevopsController,
+			job.NewJobController(kubeClient, logger.Named("job")),
+			time.Second,
+			logger.Named("pod")),
+		NodepoolController: nodepool.NewNodePoolController(
+			kubeClient,
+			agentPoolClient,
+			fake.SubscriptionID,
+			clusterResourceGroup,
+			clusterName,
+			logger.Named("nodepool")),
+		ConfigmapController: configmap.NewConfigMapController(kubeClient, logger.Named("configmap")),
+		Config:              appconfig.NewStore(appconfig.NewConfig(time.Second, time.Second, time.Minute)),
+		Logger:              logger.Named("safeEvict"),
+	}
+}
+
+// newNode returns a node of the agent pool with the given node image
+func newNode(name, agentPool, nodeImageVersion string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				"agentpool": agentPool,
+				"kubernetes.azure.com/node-image-version": nodeImageVersion,
+			},
+		},
+	}
+}
+
+// setNodeImageVersion simulates the reimage of the nodes of an agent pool
+func setNodeImageVersion(ctx context.Context, kubeClient kubernetes.Interface, agentPool, nodeImageVersion string) error {
+	nodeList, err := kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: "agentpool=" + agentPool})
+	if err != nil {
+		return err
+	}
+	for _, node := range nodeList.Items {
+		node.Labels["kubernetes.azure.com/node-image-version"] = nodeImageVersion
+		if _, err := kubeClient.CoreV1().Nodes().Update(ctx, &node, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishPodTermination plays the kubelet, which would remove the deleted pods once their containers stopped
+func finishPodTermination(ctx context.Context, kubeClient kubernetes.Interface, namespace string) error {
+	podList, err := kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp == nil {
+			continue
+		}
+		err := kubeClient.CoreV1().Pods(namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: new(int64)})
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
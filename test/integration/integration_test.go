@@ -0,0 +1,180 @@
+package integration
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	updatev1 "norbinto/node-updater/api/v1"
+	"norbinto/node-updater/internal/controller"
+	"norbinto/node-updater/internal/nodepool"
+	"norbinto/node-updater/pkg/testing/fake"
+)
+
+const (
+	agentNamespace  = "agents"
+	agentPoolName   = "agentpool"
+	oldNodeImage    = "AKSUbuntu-2204gen2containerd-202501.01.0"
+	latestNodeImage = "AKSUbuntu-2204gen2containerd-202502.01.0"
+	idleLogLine     = "Listening for Jobs"
+	devopsPoolID    = 1
+	devopsPoolName  = "self-hosted"
+	devopsAgentID   = 7
+	agentPodName    = "agent-0"
+	agentJobName    = "agent"
+	reconcileLimit  = 10
+)
+
+var _ = Describe("SafeEvict rotation", Ordered, func() {
+	var (
+		agentPoolClient *fake.AgentPoolClient
+		devopsServer    *fakeDevopsServer
+		reconciler      *controller.SafeEvictReconciler
+		safeEvict       *updatev1.SafeEvict
+		request         reconcile.Request
+	)
+
+	BeforeAll(func() {
+		var err error
+		agentPoolClient, err = fake.NewAgentPoolClient(latestNodeImage)
+		Expect(err).NotTo(HaveOccurred())
+		agentPoolClient.AddAgentPool(agentPoolName, armcontainerservice.ManagedClusterAgentPoolProfileProperties{
+			Count:             to.Ptr(int32(1)),
+			EnableAutoScaling: to.Ptr(false),
+			Mode:              to.Ptr(armcontainerservice.AgentPoolModeUser),
+			NodeImageVersion:  to.Ptr(oldNodeImage),
+			VMSize:            to.Ptr("Standard_D4s_v5"),
+		})
+		agentPoolClient.OnCreate = func(name string) {
+			defer GinkgoRecover()
+			_, err := kubeClient.CoreV1().Nodes().Create(ctx, newNode(name+"-0", name, latestNodeImage), metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		agentPoolClient.OnUpgrade = func(name, nodeImageVersion string) {
+			defer GinkgoRecover()
+			Expect(setNodeImageVersion(ctx, kubeClient, name, nodeImageVersion)).To(Succeed())
+		}
+
+		devopsServer = newFakeDevopsServer()
+		devopsServer.addAgent(devopsPoolID, devopsPoolName, devopsAgentID, agentPodName)
+		DeferCleanup(devopsServer.Close)
+
+		reconciler = newReconciler(k8sClient, &podLogsClientset{Interface: kubeClient, logs: "job finished\n" + idleLogLine}, agentPoolClient, devopsServer, zap.NewNop())
+
+		By("creating an outdated node with an idle agent")
+		_, err = kubeClient.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: agentNamespace}}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = kubeClient.CoreV1().Nodes().Create(ctx, newNode(agentPoolName+"-0", agentPoolName, oldNodeImage), metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		agentJob, err := kubeClient.BatchV1().Jobs(agentNamespace).Create(ctx, &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: agentJobName, Namespace: agentNamespace},
+			Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						RestartPolicy: corev1.RestartPolicyNever,
+						Containers:    []corev1.Container{{Name: "agent", Image: "agent:latest"}},
+					},
+				},
+			},
+		}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		agentPod, err := kubeClient.CoreV1().Pods(agentNamespace).Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      agentPodName,
+				Namespace: agentNamespace,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "batch/v1",
+					Kind:       "Job",
+					Name:       agentJob.Name,
+					UID:        agentJob.UID,
+				}},
+			},
+			Spec: corev1.PodSpec{
+				NodeName: agentPoolName + "-0",
+				Containers: []corev1.Container{{
+					Name:  "agent",
+					Image: "agent:latest",
+					Env:   []corev1.EnvVar{{Name: "AZP_POOL", Value: devopsPoolName}},
+				}},
+			},
+		}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		agentPod.Status.Phase = corev1.PodRunning
+		_, err = kubeClient.CoreV1().Pods(agentNamespace).UpdateStatus(ctx, agentPod, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		By("creating the SafeEvict")
+		safeEvict = &updatev1.SafeEvict{
+			ObjectMeta: metav1.ObjectMeta{Name: "rotation", Namespace: "default"},
+			Spec: updatev1.SafeEvictSpec{
+				LabelSelector:     map[string]string{"busy": "true"},
+				LastLogLines:      []string{idleLogLine},
+				Nodepools:         []string{agentPoolName},
+				Namespaces:        []string{agentNamespace},
+				BaseForBackupPool: agentPoolName,
+			},
+		}
+		Expect(k8sClient.Create(ctx, safeEvict)).To(Succeed())
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: safeEvict.Name, Namespace: safeEvict.Namespace}, safeEvict)).To(Succeed())
+		request = reconcile.Request{NamespacedName: types.NamespacedName{Name: safeEvict.Name, Namespace: safeEvict.Namespace}}
+	})
+
+	It("rotates the outdated pool through a temporary pool", func() {
+		temporaryNodepoolName := safeEvict.GetTemporaryNodepoolName()
+		temporaryNodepoolCreated := false
+
+		for i := 0; i < reconcileLimit; i++ {
+			_, err := reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(finishPodTermination(ctx, kubeClient, agentNamespace)).To(Succeed())
+
+			temporaryNodepool := agentPoolClient.AgentPool(temporaryNodepoolName)
+			if temporaryNodepool != nil {
+				temporaryNodepoolCreated = true
+				Expect(temporaryNodepool.Properties.Tags).To(HaveKeyWithValue(nodepool.OwnerTagKey, to.Ptr(safeEvict.GetOwnerTag())))
+			}
+			if temporaryNodepoolCreated && temporaryNodepool == nil {
+				break
+			}
+		}
+
+		By("creating and removing the temporary pool")
+		Expect(temporaryNodepoolCreated).To(BeTrue())
+		Expect(agentPoolClient.AgentPool(temporaryNodepoolName)).To(BeNil())
+
+		By("deregistering the idle agent and evicting its pod")
+		Expect(devopsServer.getAgent(devopsPoolID, agentPodName)).To(BeNil())
+		_, err := kubeClient.CoreV1().Pods(agentNamespace).Get(ctx, agentPodName, metav1.GetOptions{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		_, err = kubeClient.BatchV1().Jobs(agentNamespace).Get(ctx, agentJobName, metav1.GetOptions{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		By("upgrading the node image of the pool")
+		Expect(agentPoolClient.UpgradeCount(agentPoolName)).To(BeNumerically(">=", 1))
+		agentPool := agentPoolClient.AgentPool(agentPoolName)
+		Expect(*agentPool.Properties.NodeImageVersion).To(Equal(latestNodeImage))
+
+		By("restoring the scaling and the schedulability of the pool")
+		Expect(*agentPool.Properties.Count).To(Equal(int32(1)))
+		Expect(*agentPool.Properties.EnableAutoScaling).To(BeFalse())
+		node, err := kubeClient.CoreV1().Nodes().Get(ctx, agentPoolName+"-0", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(node.Spec.Unschedulable).To(BeFalse())
+		Expect(node.Annotations).NotTo(HaveKey(nodepool.ScaleDownDisabledAnnotation))
+		Expect(node.Labels).To(HaveKeyWithValue("kubernetes.azure.com/node-image-version", latestNodeImage))
+
+		By("removing the state ConfigMap")
+		_, err = kubeClient.CoreV1().ConfigMaps(safeEvict.Namespace).Get(ctx, safeEvict.GetConfigmapName(), metav1.GetOptions{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,52 @@
+package integration
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+// podLogsClientset serves fixed pod logs, envtest has no kubelet which could stream the logs of a pod
+type podLogsClientset struct {
+	kubernetes.Interface
+	logs string
+}
+
+func (c *podLogsClientset) CoreV1() corev1client.CoreV1Interface {
+	return &podLogsCoreV1{CoreV1Interface: c.Interface.CoreV1(), logs: c.logs}
+}
+
+type podLogsCoreV1 struct {
+	corev1client.CoreV1Interface
+	logs string
+}
+
+func (c *podLogsCoreV1) Pods(namespace string) corev1client.PodInterface {
+	return &podLogsPods{PodInterface: c.CoreV1Interface.Pods(namespace), namespace: namespace, logs: c.logs}
+}
+
+type podLogsPods struct {
+	corev1client.PodInterface
+	namespace string
+	logs      string
+}
+
+func (c *podLogsPods) GetLogs(name string, opts *corev1.PodLogOptions) *rest.Request {
+	restClient := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(c.logs))}, nil
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		GroupVersion:         corev1.SchemeGroupVersion,
+		VersionedAPIPath:     fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", c.namespace, name),
+	}
+	return restClient.Request()
+}